@@ -0,0 +1,127 @@
+package aliases
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// namePattern is what the name of an alias looks like, a word such as tldr or code-review. The keys
+// of the config are read in lower case, so a name is lower case too.
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Split splits the arguments an alias expands to the way a shell would: at the spaces that aren't
+// quoted, with single and double quotes grouping the spaces they enclose, and a backslash escaping
+// the rune it precedes, except within single quotes.
+func Split(value string) ([]string, error) {
+	var (
+		result  []string
+		field   strings.Builder
+		quote   rune
+		escaped bool
+		pending bool
+	)
+
+	for _, r := range value {
+		switch {
+		case escaped:
+			field.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, pending = true, true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote == 0 && (r == '"' || r == '\''):
+			quote, pending = r, true
+		case quote == 0 && unicode.IsSpace(r):
+			if pending {
+				result = append(result, field.String())
+				field.Reset()
+				pending = false
+			}
+		default:
+			field.WriteRune(r)
+			pending = true
+		}
+	}
+
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("invalid arguments %q, a quote or a backslash isn't closed", value)
+	}
+	if pending {
+		result = append(result, field.String())
+	}
+
+	return result, nil
+}
+
+// Join joins the arguments into the value of an alias, which Split splits back into them: the
+// arguments with spaces, quotes or backslashes are single-quoted.
+func Join(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = arg
+		if arg == "" || strings.ContainsFunc(arg, func(r rune) bool {
+			return unicode.IsSpace(r) || r == '\'' || r == '"' || r == '\\'
+		}) {
+			quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+// Expand replaces an alias that starts the arguments with the arguments it expands to, followed by
+// the rest of them, so the flags that were typed win over those of the alias. An alias that starts
+// with another alias is expanded in turn, and one that comes back to itself is an error. The
+// commands of builtins are never expanded.
+func Expand(aliases map[string]string, builtins []string, args []string) ([]string, error) {
+	var seen []string
+
+	for len(args) > 0 && !slices.Contains(builtins, args[0]) {
+		value, ok := aliases[args[0]]
+		if !ok {
+			break
+		}
+
+		if slices.Contains(seen, args[0]) {
+			return nil, fmt.Errorf("alias %s expands to itself: %s", args[0], strings.Join(append(seen, args[0]), " -> "))
+		}
+		seen = append(seen, args[0])
+
+		expansion, err := Split(value)
+		if err != nil {
+			return nil, fmt.Errorf("alias %s: %w", args[0], err)
+		}
+
+		args = append(expansion, args[1:]...)
+	}
+
+	return args, nil
+}
+
+// Check tells why the alias can't be defined, if it can't: a name that isn't a word, the name of a
+// command of builtins, arguments that don't split, or an alias that would expand to itself along
+// with the aliases that exist.
+func Check(aliases map[string]string, builtins []string, name, value string) error {
+	switch {
+	case !namePattern.MatchString(name):
+		return fmt.Errorf("invalid alias name %q, use lower case letters, digits, - and _", name)
+	case slices.Contains(builtins, name):
+		return fmt.Errorf("%s is a command of chatgpt, an alias can't replace it", name)
+	case strings.TrimSpace(value) == "":
+		return errors.New("an alias expands to arguments, such as -t summarize --raw")
+	}
+
+	defined := make(map[string]string, len(aliases)+1)
+	for key, existing := range aliases {
+		defined[key] = existing
+	}
+	defined[name] = value
+
+	_, err := Expand(defined, builtins, []string{name})
+	return err
+}
@@ -0,0 +1,92 @@
+package aliases_test
+
+import (
+	"github.com/kardolus/chatgpt-cli/aliases"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitAliases(t *testing.T) {
+	spec.Run(t, "Testing the aliases", testAliases, spec.Report(report.Terminal{}))
+}
+
+func testAliases(t *testing.T, when spec.G, it spec.S) {
+	builtins := []string{"history", "cost"}
+
+	it.Before(func() {
+		RegisterTestingT(t)
+	})
+
+	when("Split()", func() {
+		it("splits at the spaces that aren't quoted or escaped", func() {
+			Expect(aliases.Split(`-t summarize  --system "Be brief, please" --var 'focus=a "b"' a\ b`)).To(Equal([]string{
+				"-t", "summarize", "--system", "Be brief, please", "--var", `focus=a "b"`, "a b",
+			}))
+			Expect(aliases.Split(`--var ""`)).To(Equal([]string{"--var", ""}))
+		})
+		it("refuses a quote that isn't closed", func() {
+			_, err := aliases.Split(`--system "Be brief`)
+			Expect(err).To(MatchError(ContainSubstring("a quote or a backslash isn't closed")))
+		})
+	})
+
+	when("Join()", func() {
+		it("quotes the arguments Split wouldn't give back", func() {
+			args := []string{"-t", "summarize", "--system", "Don't be long", "--var", `path=C:\tmp`, ""}
+			value := aliases.Join(args)
+			Expect(value).To(Equal(`-t summarize --system 'Don'\''t be long' --var 'path=C:\tmp' ''`))
+			Expect(aliases.Split(value)).To(Equal(args))
+		})
+	})
+
+	when("Expand()", func() {
+		defined := map[string]string{
+			"tldr":    "-t summarize --model gpt-4o-mini --raw",
+			"brief":   "tldr --system 'Be brief'",
+			"history": "--list-threads",
+			"loop":    "again",
+			"again":   "loop --raw",
+		}
+
+		it("puts the arguments of the alias before the ones that were typed, so these win", func() {
+			Expect(aliases.Expand(defined, builtins, []string{"tldr", "--model", "gpt-4o"})).To(Equal([]string{
+				"-t", "summarize", "--model", "gpt-4o-mini", "--raw", "--model", "gpt-4o",
+			}))
+		})
+		it("expands an alias that starts with another alias", func() {
+			Expect(aliases.Expand(defined, builtins, []string{"brief"})).To(Equal([]string{
+				"-t", "summarize", "--model", "gpt-4o-mini", "--raw", "--system", "Be brief",
+			}))
+		})
+		it("leaves the commands and the other arguments as they are", func() {
+			Expect(aliases.Expand(defined, builtins, []string{"history", "list"})).To(Equal([]string{"history", "list"}))
+			Expect(aliases.Expand(defined, builtins, []string{"--raw", "tldr"})).To(Equal([]string{"--raw", "tldr"}))
+			Expect(aliases.Expand(defined, builtins, nil)).To(BeEmpty())
+		})
+		it("refuses an alias that expands to itself", func() {
+			_, err := aliases.Expand(defined, builtins, []string{"loop"})
+			Expect(err).To(MatchError("alias loop expands to itself: loop -> again -> loop"))
+		})
+	})
+
+	when("Check()", func() {
+		defined := map[string]string{"tldr": "-t summarize"}
+
+		it("accepts an alias of arguments, also one that starts with another alias", func() {
+			Expect(aliases.Check(defined, builtins, "brief", "tldr --raw")).To(Succeed())
+		})
+		it("refuses the names of the commands and the names that aren't words", func() {
+			Expect(aliases.Check(defined, builtins, "cost", "--raw")).To(MatchError("cost is a command of chatgpt, an alias can't replace it"))
+			Expect(aliases.Check(defined, builtins, "--raw", "--raw")).To(MatchError(ContainSubstring("invalid alias name")))
+			Expect(aliases.Check(defined, builtins, "Tldr", "--raw")).To(MatchError(ContainSubstring("invalid alias name")))
+		})
+		it("refuses an alias that would expand to itself, or to nothing", func() {
+			Expect(aliases.Check(defined, builtins, "tldr", "brief")).To(Succeed())
+			Expect(aliases.Check(map[string]string{"brief": "tldr"}, builtins, "tldr", "brief --raw")).To(MatchError(ContainSubstring("expands to itself")))
+			Expect(aliases.Check(defined, builtins, "empty", " ")).To(HaveOccurred())
+		})
+	})
+}
@@ -0,0 +1,120 @@
+// Package batch reads the prompts of chatgpt batch and the lines of JSON it writes their results as,
+// which a batch that stopped part of the way resumes from.
+package batch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/client"
+	"github.com/kardolus/chatgpt-cli/types"
+	"io"
+	"strings"
+)
+
+// Result is the line of JSON of an answered prompt: the prompt, the model it was sent to, and its
+// answer with the usage and the estimated cost, or the error that failed it.
+type Result struct {
+	Prompt string      `json:"prompt"`
+	Model  string      `json:"model"`
+	Answer string      `json:"answer,omitempty"`
+	Usage  types.Usage `json:"usage"`
+	Cost   *float64    `json:"cost,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// Key is what tells the prompts of a batch apart in its results, the prompt and its model, so the
+// same prompt can be sent to several models.
+func Key(prompt, model string) string {
+	return model + "\x00" + prompt
+}
+
+// Read reads the prompts, a line for each. The lines are the prompts themselves, or, when the first
+// of them starts with a brace, objects of JSON with the prompt and the settings that replace those
+// of the config for it, such as {"prompt": "...", "model": "gpt-4o", "temperature": 0.2}. The blank
+// lines are skipped.
+func Read(r io.Reader) ([]client.BatchPrompt, error) {
+	var (
+		prompts []client.BatchPrompt
+		jsonl   bool
+	)
+
+	reader := bufio.NewReader(r)
+	for number := 1; ; number++ {
+		line, err := reader.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+
+		if text := strings.TrimSpace(line); text != "" {
+			if len(prompts) == 0 {
+				jsonl = strings.HasPrefix(text, "{")
+			}
+
+			prompt, parseErr := parsePrompt(text, jsonl)
+			if parseErr != nil {
+				return nil, fmt.Errorf("line %d: %w", number, parseErr)
+			}
+			prompts = append(prompts, prompt)
+		}
+
+		if err != nil {
+			return prompts, nil
+		}
+	}
+}
+
+func parsePrompt(text string, jsonl bool) (client.BatchPrompt, error) {
+	if !jsonl {
+		return client.BatchPrompt{Prompt: text}, nil
+	}
+
+	var prompt client.BatchPrompt
+	decoder := json.NewDecoder(strings.NewReader(text))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&prompt); err != nil {
+		return prompt, fmt.Errorf("invalid prompt, %w", err)
+	}
+
+	switch {
+	case strings.TrimSpace(prompt.Prompt) == "":
+		return prompt, errors.New("the prompt is missing")
+	case prompt.Temperature != nil && (*prompt.Temperature < 0 || *prompt.Temperature > 2):
+		return prompt, fmt.Errorf("invalid temperature %g, use a value from 0 to 2", *prompt.Temperature)
+	case prompt.TopP != nil && (*prompt.TopP < 0 || *prompt.TopP > 1):
+		return prompt, fmt.Errorf("invalid top_p %g, use a value from 0 to 1", *prompt.TopP)
+	case prompt.MaxTokens < 0:
+		return prompt, fmt.Errorf("invalid max_tokens %d, use at least 1", prompt.MaxTokens)
+	}
+
+	return prompt, nil
+}
+
+// Answered returns the keys of the prompts the results written so far hold, which a resumed batch
+// skips, and the length of the data up to the end of the last complete result. A last line that a
+// crash cut short is left out of both, so the results are resumed after the last complete one.
+func Answered(data []byte) (map[string]bool, int, error) {
+	answered := map[string]bool{}
+
+	length := 0
+	for number := 1; length < len(data); number++ {
+		end := bytes.IndexByte(data[length:], '\n')
+		if end < 0 {
+			break
+		}
+
+		line := bytes.TrimSpace(data[length : length+end])
+		if len(line) > 0 {
+			var result Result
+			if err := json.Unmarshal(line, &result); err != nil {
+				return nil, 0, fmt.Errorf("line %d isn't a result of a batch, %w", number, err)
+			}
+			answered[Key(result.Prompt, result.Model)] = true
+		}
+		length += end + 1
+	}
+
+	return answered, length, nil
+}
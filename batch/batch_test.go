@@ -0,0 +1,76 @@
+package batch_test
+
+import (
+	"github.com/kardolus/chatgpt-cli/batch"
+	"github.com/kardolus/chatgpt-cli/client"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitBatch(t *testing.T) {
+	spec.Run(t, "Testing the batches", testBatch, spec.Report(report.Terminal{}))
+}
+
+func testBatch(t *testing.T, when spec.G, it spec.S) {
+	it.Before(func() {
+		RegisterTestingT(t)
+	})
+
+	when("Read()", func() {
+		it("reads a prompt a line, skipping the blank ones", func() {
+			prompts, err := batch.Read(strings.NewReader("What is Go?\r\n\n  Name a {color}  \nlast"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(prompts).To(Equal([]client.BatchPrompt{{Prompt: "What is Go?"}, {Prompt: "Name a {color}"}, {Prompt: "last"}}))
+		})
+		it("reads the prompts of JSON lines with their settings", func() {
+			prompts, err := batch.Read(strings.NewReader(`{"prompt": "What is Go?"}` + "\n\n" +
+				`{"prompt": "Name a color", "model": "gpt-4o", "system": "Be brief.", "temperature": 0.2, "top_p": 0.5, "max_tokens": 50}` + "\n"))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(prompts).To(HaveLen(2))
+			Expect(prompts[0]).To(Equal(client.BatchPrompt{Prompt: "What is Go?"}))
+			Expect(prompts[1].Model).To(Equal("gpt-4o"))
+			Expect(prompts[1].System).To(Equal("Be brief."))
+			Expect(*prompts[1].Temperature).To(Equal(0.2))
+			Expect(*prompts[1].TopP).To(Equal(0.5))
+			Expect(prompts[1].MaxTokens).To(Equal(50))
+		})
+		it("refuses the JSON lines that aren't prompts, with their line", func() {
+			for input, message := range map[string]string{
+				`{"prompt": "one"}` + "\nnot json":                                 "line 2: invalid prompt",
+				`{"prompt": "one", "temprature": 1}`:                               `line 1: invalid prompt, json: unknown field "temprature"`,
+				"\n" + `{"model": "gpt-4o"}`:                                       "line 2: the prompt is missing",
+				`{"prompt": "one", "temperature": 3}`:                              "line 1: invalid temperature 3, use a value from 0 to 2",
+				`{"prompt": "one", "top_p": 1.5}`:                                  "line 1: invalid top_p 1.5, use a value from 0 to 1",
+				`{"prompt": "one"}` + "\n" + `{"prompt": "two", "max_tokens": -1}`: "line 2: invalid max_tokens -1, use at least 1",
+			} {
+				_, err := batch.Read(strings.NewReader(input))
+				Expect(err).To(MatchError(ContainSubstring(message)))
+			}
+		})
+	})
+
+	when("Answered()", func() {
+		it("returns the prompts of the results and where the complete ones end", func() {
+			data := `{"prompt":"one","model":"gpt-4o","answer":"1"}` + "\n" +
+				`{"prompt":"two","model":"gpt-4o","error":"timeout"}` + "\n"
+
+			answered, length, err := batch.Answered([]byte(data + `{"prompt":"thr`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(length).To(Equal(len(data)))
+			Expect(answered).To(Equal(map[string]bool{
+				batch.Key("one", "gpt-4o"): true,
+				batch.Key("two", "gpt-4o"): true,
+			}))
+			Expect(answered[batch.Key("one", "o1")]).To(BeFalse())
+		})
+		it("refuses a file that isn't the results of a batch", func() {
+			_, _, err := batch.Answered([]byte("# notes\n"))
+			Expect(err).To(MatchError(ContainSubstring("line 1 isn't a result of a batch")))
+		})
+	})
+}
@@ -0,0 +1,83 @@
+package buildinfo
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// develVersion is the version of a build that tells none, such as one made with go run.
+const develVersion = "dev"
+
+// Info is what a build of the CLI tells about itself. The release builds set the version, the
+// commit and the date with ldflags, the others are those of the binary.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	Date      string `json:"date"`
+	GoVersion string `json:"go_version"`
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+}
+
+// Of returns the info of the build from what the ldflags set, each of which may be empty.
+func Of(version, commit, date string) Info {
+	build, _ := debug.ReadBuildInfo()
+	return Fill(Info{Version: version, Commit: commit, Date: date}, build)
+}
+
+// Fill fills what the info leaves empty from the build info of the binary, which go install
+// records: the module version, and the revision and the time of the commit it was built from.
+// The Go version and the platform are always those of the binary, and the version is dev when
+// neither tells one.
+func Fill(info Info, build *debug.BuildInfo) Info {
+	info.GoVersion, info.OS, info.Arch = runtime.Version(), runtime.GOOS, runtime.GOARCH
+
+	if build != nil {
+		if info.Version == "" && build.Main.Version != "(devel)" {
+			info.Version = build.Main.Version
+		}
+
+		for _, setting := range build.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = setting.Value
+			case setting.Key == "vcs.time" && info.Date == "":
+				info.Date = setting.Value
+			}
+		}
+	}
+
+	if info.Version == "" {
+		info.Version = develVersion
+	}
+
+	return info
+}
+
+// String returns the info as the version command prints it, the version first. A commit of
+// homebrew is a build of the formula, whose commit isn't known.
+func (i Info) String() string {
+	commit := "unknown commit"
+	switch i.Commit {
+	case "":
+	case "homebrew":
+		commit = i.Commit
+	default:
+		commit = "commit " + i.Commit
+	}
+
+	date := i.Date
+	if date == "" {
+		date = "unknown"
+	}
+
+	var b strings.Builder
+	_, _ = fmt.Fprintf(&b, "ChatGPT CLI version %s (%s)\n", i.Version, commit)
+	_, _ = fmt.Fprintf(&b, "Built:      %s\n", date)
+	_, _ = fmt.Fprintf(&b, "Go version: %s\n", i.GoVersion)
+	_, _ = fmt.Fprintf(&b, "Platform:   %s/%s", i.OS, i.Arch)
+
+	return b.String()
+}
@@ -0,0 +1,172 @@
+package buildinfo_test
+
+import (
+	"context"
+	"github.com/kardolus/chatgpt-cli/buildinfo"
+	"github.com/kardolus/chatgpt-cli/clock"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitBuildInfo(t *testing.T) {
+	spec.Run(t, "Testing the build info", testBuildInfo, spec.Report(report.Terminal{}))
+}
+
+func testBuildInfo(t *testing.T, when spec.G, it spec.S) {
+	it.Before(func() {
+		RegisterTestingT(t)
+	})
+
+	when("Fill()", func() {
+		build := &debug.BuildInfo{
+			Main: debug.Module{Version: "v1.7.1"},
+			Settings: []debug.BuildSetting{
+				{Key: "vcs.revision", Value: "abc123"},
+				{Key: "vcs.time", Value: "2024-06-01T10:00:00Z"},
+			},
+		}
+
+		it("keeps what the ldflags set", func() {
+			info := buildinfo.Fill(buildinfo.Info{Version: "v1.8.0", Commit: "def456", Date: "2024-07-01"}, build)
+
+			Expect(info.Version).To(Equal("v1.8.0"))
+			Expect(info.Commit).To(Equal("def456"))
+			Expect(info.Date).To(Equal("2024-07-01"))
+			Expect(info.GoVersion).To(Equal(runtime.Version()))
+			Expect(info.OS).To(Equal(runtime.GOOS))
+			Expect(info.Arch).To(Equal(runtime.GOARCH))
+		})
+
+		it("falls back to the build info of a go install", func() {
+			info := buildinfo.Fill(buildinfo.Info{}, build)
+
+			Expect(info.Version).To(Equal("v1.7.1"))
+			Expect(info.Commit).To(Equal("abc123"))
+			Expect(info.Date).To(Equal("2024-06-01T10:00:00Z"))
+		})
+
+		it("tells a dev version when nothing tells one", func() {
+			Expect(buildinfo.Fill(buildinfo.Info{}, &debug.BuildInfo{Main: debug.Module{Version: "(devel)"}}).Version).To(Equal("dev"))
+			Expect(buildinfo.Fill(buildinfo.Info{}, nil).Version).To(Equal("dev"))
+		})
+	})
+
+	when("String()", func() {
+		it("prints the version, the commit, the date, the Go version and the platform", func() {
+			info := buildinfo.Info{Version: "v1.7.1", Commit: "abc123", Date: "2024-06-01", GoVersion: "go1.22.5", OS: "linux", Arch: "amd64"}
+
+			Expect(info.String()).To(Equal("ChatGPT CLI version v1.7.1 (commit abc123)\n" +
+				"Built:      2024-06-01\n" +
+				"Go version: go1.22.5\n" +
+				"Platform:   linux/amd64"))
+		})
+
+		it("tells what isn't known", func() {
+			info := buildinfo.Info{Version: "dev", GoVersion: "go1.22.5", OS: "linux", Arch: "amd64"}
+
+			Expect(info.String()).To(HavePrefix("ChatGPT CLI version dev (unknown commit)\nBuilt:      unknown\n"))
+			Expect(buildinfo.Info{Version: "v1.7.1", Commit: "homebrew"}.String()).To(HavePrefix("ChatGPT CLI version v1.7.1 (homebrew)\n"))
+		})
+	})
+
+	when("Newer()", func() {
+		it("compares semantic versions", func() {
+			Expect(buildinfo.Newer("v1.8.0", "v1.7.1")).To(BeTrue())
+			Expect(buildinfo.Newer("v1.10.0", "v1.9.9")).To(BeTrue())
+			Expect(buildinfo.Newer("2.0.0", "v1.9.9")).To(BeTrue())
+			Expect(buildinfo.Newer("v1.7.1", "v1.7.1")).To(BeFalse())
+			Expect(buildinfo.Newer("v1.7.0", "v1.7.1")).To(BeFalse())
+		})
+
+		it("puts the pre-releases before their release", func() {
+			Expect(buildinfo.Newer("v1.8.0", "v1.8.0-rc.1")).To(BeTrue())
+			Expect(buildinfo.Newer("v1.8.0-rc.1", "v1.8.0")).To(BeFalse())
+			Expect(buildinfo.Newer("v1.8.0-rc.1", "v1.7.1")).To(BeTrue())
+		})
+
+		it("never compares the versions that aren't semantic", func() {
+			Expect(buildinfo.Newer("v1.8.0", "dev")).To(BeFalse())
+			Expect(buildinfo.Newer("latest", "v1.7.1")).To(BeFalse())
+			Expect(buildinfo.Newer("v1.8", "v1.7.1")).To(BeFalse())
+		})
+	})
+
+	when("Notice()", func() {
+		it("tells a newer release", func() {
+			Expect(buildinfo.Notice("v1.7.1", "v1.8.0")).To(Equal("A new version of the ChatGPT CLI is available: v1.8.0 (you have v1.7.1)"))
+		})
+
+		it("tells nothing when the version is the latest or isn't a release", func() {
+			Expect(buildinfo.Notice("v1.8.0", "v1.8.0")).To(BeEmpty())
+			Expect(buildinfo.Notice("dev", "v1.8.0")).To(BeEmpty())
+		})
+	})
+
+	when("UpdateChecker", func() {
+		var (
+			requests int
+			status   int
+			server   *httptest.Server
+			fake     *clock.Fake
+			subject  *buildinfo.UpdateChecker
+		)
+
+		it.Before(func() {
+			requests, status = 0, http.StatusOK
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				w.WriteHeader(status)
+				_, _ = w.Write([]byte(`{"tag_name":"v1.8.0","name":"Release 1.8.0"}`))
+			}))
+
+			fake = clock.NewFake(time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC))
+			subject = buildinfo.NewUpdateChecker(filepath.Join(t.TempDir(), "data"))
+			subject.URL, subject.Clock = server.URL, fake
+		})
+
+		it.After(func() {
+			server.Close()
+		})
+
+		it("reads the latest release once a day", func() {
+			for i := 0; i < 2; i++ {
+				latest, err := subject.Latest(context.Background())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(latest).To(Equal("v1.8.0"))
+			}
+			Expect(requests).To(Equal(1))
+
+			fake.Advance(buildinfo.UpdateCheckInterval - time.Minute)
+			_, err := subject.Latest(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(requests).To(Equal(1))
+
+			fake.Advance(time.Minute)
+			_, err = subject.Latest(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(requests).To(Equal(2))
+		})
+
+		it("reads the release again after a failure", func() {
+			status = http.StatusServiceUnavailable
+
+			_, err := subject.Latest(context.Background())
+			Expect(err).To(MatchError(ContainSubstring("503")))
+
+			status = http.StatusOK
+			latest, err := subject.Latest(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(latest).To(Equal("v1.8.0"))
+			Expect(requests).To(Equal(2))
+		})
+	})
+}
@@ -0,0 +1,163 @@
+package buildinfo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/clock"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// ReleasesURL is where the latest release of the CLI is read from.
+	ReleasesURL = "https://api.github.com/repos/kardolus/chatgpt-cli/releases/latest"
+	// SkipUpdateCheckEnv turns the update check off, such as in CI, when it is set to true.
+	SkipUpdateCheckEnv = "CHATGPT_CLI_NO_UPDATE_CHECK"
+	// UpdateCheckInterval is how long the latest release that was read is used before it is read
+	// again.
+	UpdateCheckInterval = 24 * time.Hour
+	// CacheFile is the file of the data directory the latest release is kept in.
+	CacheFile = ".latest-release"
+
+	updateCheckTimeout = 2 * time.Second
+)
+
+// UpdateChecker tells whether a newer release than the running one was published. The latest
+// release is read from URL at most once per UpdateCheckInterval, and kept in CachePath in between.
+type UpdateChecker struct {
+	URL       string
+	CachePath string
+	Client    *http.Client
+	Clock     clock.Clock
+}
+
+// NewUpdateChecker returns a checker of the releases of the CLI, which keeps the latest one in
+// the CacheFile of the data directory.
+func NewUpdateChecker(dataHome string) *UpdateChecker {
+	return &UpdateChecker{
+		URL:       ReleasesURL,
+		CachePath: filepath.Join(dataHome, CacheFile),
+		Client:    &http.Client{Timeout: updateCheckTimeout},
+		Clock:     clock.Real,
+	}
+}
+
+// cache is what the cache file keeps, when the latest release was read and which one it was.
+type cache struct {
+	Checked time.Time `json:"checked"`
+	Latest  string    `json:"latest"`
+}
+
+// Latest returns the tag of the latest release, from the cache file while it was read less than
+// UpdateCheckInterval ago. A release that can't be read is an error, and is read again on the
+// next check.
+func (u *UpdateChecker) Latest(ctx context.Context) (string, error) {
+	now := u.Clock.Now()
+
+	if data, err := os.ReadFile(u.CachePath); err == nil {
+		var cached cache
+		if json.Unmarshal(data, &cached) == nil && cached.Latest != "" && now.Sub(cached.Checked) < UpdateCheckInterval {
+			return cached.Latest, nil
+		}
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, u.URL, nil)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Accept", "application/vnd.github+json")
+
+	response, err := u.Client.Do(request)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the latest release: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to read the latest release: %s", response.Status)
+	}
+
+	var release struct {
+		TagName string `json:"tag_name"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&release); err != nil || release.TagName == "" {
+		return "", errors.New("failed to read the latest release: the response has no tag")
+	}
+
+	// a cache that can't be written only means the release is read again next time
+	if data, err := json.Marshal(cache{Checked: now, Latest: release.TagName}); err == nil {
+		if os.MkdirAll(filepath.Dir(u.CachePath), 0700) == nil {
+			_ = os.WriteFile(u.CachePath, data, 0600)
+		}
+	}
+
+	return release.TagName, nil
+}
+
+// Notice returns the line that tells a newer release than the current version is available, or
+// an empty string when there is none. A version that isn't a release, such as dev, is never told
+// about one.
+func Notice(current, latest string) string {
+	if !Newer(latest, current) {
+		return ""
+	}
+
+	return fmt.Sprintf("A new version of the ChatGPT CLI is available: %s (you have %s)", latest, current)
+}
+
+// Newer tells whether the version a is a later release than the version b, both of which are
+// semantic versions such as v1.7.1, the v being optional. A pre-release, such as v1.8.0-rc.1, is
+// older than its release. A version that isn't semantic is never newer, nor older.
+func Newer(a, b string) bool {
+	first, ok := parseVersion(a)
+	if !ok {
+		return false
+	}
+	second, ok := parseVersion(b)
+	if !ok {
+		return false
+	}
+
+	for i := 0; i < 3; i++ {
+		if first.numbers[i] != second.numbers[i] {
+			return first.numbers[i] > second.numbers[i]
+		}
+	}
+
+	// the release comes after its pre-releases
+	return first.preRelease == "" && second.preRelease != ""
+}
+
+type semanticVersion struct {
+	numbers    [3]int
+	preRelease string
+}
+
+func parseVersion(version string) (semanticVersion, bool) {
+	version = strings.TrimPrefix(strings.TrimSpace(version), "v")
+	version, _, _ = strings.Cut(version, "+")
+
+	var result semanticVersion
+	version, result.preRelease, _ = strings.Cut(version, "-")
+
+	parts := strings.Split(version, ".")
+	if len(parts) != 3 {
+		return semanticVersion{}, false
+	}
+
+	for i, part := range parts {
+		number, err := strconv.Atoi(part)
+		if err != nil || number < 0 {
+			return semanticVersion{}, false
+		}
+		result.numbers[i] = number
+	}
+
+	return result, true
+}
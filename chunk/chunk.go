@@ -0,0 +1,149 @@
+package chunk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/utils"
+	"strings"
+)
+
+// Split packs the units of a long input, such as the diffs of its files, into chunks of at most
+// maxTokens, in their order. A unit is never split between chunks unless it is larger than a chunk
+// by itself, then it is cut at its lines, and a line larger than a chunk is a chunk of its own. A
+// maxTokens of 0 or less puts all the units in a single chunk.
+func Split(units []string, maxTokens int) []string {
+	var (
+		chunks  []string
+		current strings.Builder
+		tokens  int
+	)
+
+	flush := func() {
+		if current.Len() > 0 {
+			chunks = append(chunks, current.String())
+		}
+		current.Reset()
+		tokens = 0
+	}
+
+	add := func(text string, size int) {
+		if maxTokens > 0 && tokens > 0 && tokens+size > maxTokens {
+			flush()
+		}
+		current.WriteString(text)
+		tokens += size
+	}
+
+	for _, unit := range units {
+		size := estimate(unit)
+		if maxTokens <= 0 || size <= maxTokens {
+			add(unit, size)
+			continue
+		}
+
+		// the unit starts a chunk of its own, and its lines fill as many chunks as they take
+		flush()
+		for _, line := range strings.SplitAfter(unit, "\n") {
+			add(line, estimate(line))
+		}
+		flush()
+	}
+	flush()
+
+	return chunks
+}
+
+// estimate returns the tokens of a part of a chunk, rounded up, so the parts never add up to less
+// than the chunk they make.
+func estimate(text string) int {
+	return utils.EstimateTokens(text) + 1
+}
+
+// Step is a chunk of a long input, counting from 1, with the summary of the chunks before it.
+type Step struct {
+	Part    int
+	Parts   int
+	Summary string
+	Chunk   string
+}
+
+// Reducer answers a long input chunk by chunk, with sequential queries: each chunk is asked with
+// the summary of the answers of the chunks before it, so the answers agree with each other, and the
+// answers are merged by a last query once every chunk was answered.
+type Reducer struct {
+	// Ask sends a query on its own, outside of any conversation.
+	Ask func(ctx context.Context, query string) (string, error)
+	// Part returns the query of a chunk.
+	Part func(step Step) string
+	// Merge returns the query that merges the answers of the chunks, in their order.
+	Merge func(answers []string) string
+	// SummaryTokens caps how many tokens of the answers before it a chunk is asked with, the latest
+	// answers being kept, 0 being no cap.
+	SummaryTokens int
+	// OnPart and OnMerge, when they are set, are called before a chunk is asked and before the
+	// answers are merged, such as to tell the progress.
+	OnPart  func(part, parts int)
+	OnMerge func(parts int)
+}
+
+// Reduce returns the answer to the chunks of a long input: the answer of the single chunk, or the
+// merge of the answers of the chunks.
+func (r Reducer) Reduce(ctx context.Context, chunks []string) (string, error) {
+	if len(chunks) == 0 {
+		return "", errors.New("the input is empty, there is nothing to ask about")
+	}
+
+	var answers []string
+	for i, chunk := range chunks {
+		if r.OnPart != nil {
+			r.OnPart(i+1, len(chunks))
+		}
+
+		answer, err := r.Ask(ctx, r.Part(Step{
+			Part:    i + 1,
+			Parts:   len(chunks),
+			Summary: Summary(answers, r.SummaryTokens),
+			Chunk:   chunk,
+		}))
+		if err != nil {
+			return "", fmt.Errorf("failed to ask about part %d of %d: %w", i+1, len(chunks), err)
+		}
+		answers = append(answers, strings.TrimSpace(answer))
+	}
+
+	if len(answers) == 1 {
+		return answers[0], nil
+	}
+
+	if r.OnMerge != nil {
+		r.OnMerge(len(chunks))
+	}
+
+	merged, err := r.Ask(ctx, r.Merge(answers))
+	if err != nil {
+		return "", fmt.Errorf("failed to merge the answers of the %d parts: %w", len(chunks), err)
+	}
+
+	return strings.TrimSpace(merged), nil
+}
+
+// Summary returns the answers one after the other, without the earliest lines once they take more
+// than maxTokens, 0 being no cap.
+func Summary(answers []string, maxTokens int) string {
+	summary := strings.Join(answers, "\n\n")
+	if maxTokens <= 0 || utils.EstimateTokens(summary) <= maxTokens {
+		return summary
+	}
+
+	lines := strings.Split(summary, "\n")
+	tokens, first := 0, len(lines)
+	for first > 0 {
+		if tokens += utils.EstimateTokens(lines[first-1]); tokens > maxTokens {
+			break
+		}
+		first--
+	}
+
+	return strings.TrimLeft(strings.Join(lines[first:], "\n"), "\n")
+}
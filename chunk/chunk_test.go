@@ -0,0 +1,137 @@
+package chunk_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/chunk"
+	"github.com/kardolus/chatgpt-cli/utils"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitChunk(t *testing.T) {
+	spec.Run(t, "Testing the chunks", testChunk, spec.Report(report.Terminal{}))
+}
+
+func testChunk(t *testing.T, when spec.G, it spec.S) {
+	it.Before(func() {
+		RegisterTestingT(t)
+	})
+
+	// unit returns a unit of n lines of about 5 tokens each
+	unit := func(name string, n int) string {
+		var b strings.Builder
+		for i := 0; i < n; i++ {
+			_, _ = fmt.Fprintf(&b, "%s line %03d\n", name, i)
+		}
+		return b.String()
+	}
+
+	when("Split()", func() {
+		it("packs the units into chunks of at most the tokens, keeping them whole", func() {
+			a, b, c := unit("a", 4), unit("b", 4), unit("c", 4)
+			Expect(utils.EstimateTokens(a)).To(Equal(22))
+
+			Expect(chunk.Split([]string{a, b, c}, 50)).To(Equal([]string{a + b, c}))
+			Expect(chunk.Split([]string{a, b, c}, 70)).To(Equal([]string{a + b + c}))
+		})
+		it("puts everything in a single chunk without a cap", func() {
+			a, b := unit("a", 100), unit("b", 100)
+			Expect(chunk.Split([]string{a, b}, 0)).To(Equal([]string{a + b}))
+		})
+		it("cuts a unit larger than a chunk at its lines, in chunks of its own", func() {
+			small, large := unit("a", 2), unit("b", 10)
+			chunks := chunk.Split([]string{small, large, small}, 25)
+
+			Expect(chunks).To(HaveLen(5))
+			Expect(chunks[0]).To(Equal(small))
+			Expect(chunks[1] + chunks[2] + chunks[3]).To(Equal(large))
+			Expect(chunks[4]).To(Equal(small))
+			for _, c := range chunks {
+				Expect(utils.EstimateTokens(c)).To(BeNumerically("<=", 25))
+			}
+		})
+		it("returns no chunk for no unit", func() {
+			Expect(chunk.Split(nil, 10)).To(BeEmpty())
+		})
+	})
+
+	when("Summary()", func() {
+		it("keeps the latest lines of the answers within the tokens", func() {
+			answers := []string{"first answer line", "second answer line\nthird answer line"}
+			Expect(chunk.Summary(answers, 0)).To(Equal("first answer line\n\nsecond answer line\nthird answer line"))
+			Expect(chunk.Summary(answers, 20)).To(Equal("second answer line\nthird answer line"))
+			Expect(chunk.Summary(nil, 20)).To(BeEmpty())
+		})
+	})
+
+	when("Reducer.Reduce()", func() {
+		var (
+			queries []string
+			reducer chunk.Reducer
+		)
+
+		it.Before(func() {
+			queries = nil
+			reducer = chunk.Reducer{
+				Ask: func(_ context.Context, query string) (string, error) {
+					queries = append(queries, query)
+					return fmt.Sprintf(" answer %d \n", len(queries)), nil
+				},
+				Part: func(step chunk.Step) string {
+					return fmt.Sprintf("part %d/%d [%s] %s", step.Part, step.Parts, step.Summary, step.Chunk)
+				},
+				Merge: func(answers []string) string {
+					return "merge " + strings.Join(answers, " | ")
+				},
+			}
+		})
+
+		it("asks the chunks in turn with the summary of the answers before them, and merges the answers", func() {
+			var progress []string
+			reducer.OnPart = func(part, parts int) { progress = append(progress, fmt.Sprintf("%d/%d", part, parts)) }
+			reducer.OnMerge = func(parts int) { progress = append(progress, fmt.Sprintf("merge %d", parts)) }
+
+			answer, err := reducer.Reduce(context.Background(), []string{"x", "y", "z"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(answer).To(Equal("answer 4"))
+			Expect(queries).To(Equal([]string{
+				"part 1/3 [] x",
+				"part 2/3 [answer 1] y",
+				"part 3/3 [answer 1\n\nanswer 2] z",
+				"merge answer 1 | answer 2 | answer 3",
+			}))
+			Expect(progress).To(Equal([]string{"1/3", "2/3", "3/3", "merge 3"}))
+		})
+		it("returns the answer of a single chunk without merging it", func() {
+			answer, err := reducer.Reduce(context.Background(), []string{"x"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(answer).To(Equal("answer 1"))
+			Expect(queries).To(HaveLen(1))
+		})
+		it("stops at the first query that fails", func() {
+			failure := errors.New("rate limited")
+			reducer.Ask = func(_ context.Context, query string) (string, error) {
+				queries = append(queries, query)
+				if len(queries) == 2 {
+					return "", failure
+				}
+				return "answer", nil
+			}
+
+			_, err := reducer.Reduce(context.Background(), []string{"x", "y", "z"})
+			Expect(err).To(MatchError(failure))
+			Expect(err).To(MatchError(ContainSubstring("failed to ask about part 2 of 3")))
+			Expect(queries).To(HaveLen(2))
+		})
+		it("refuses an input without chunks", func() {
+			_, err := reducer.Reduce(context.Background(), nil)
+			Expect(err).To(MatchError("the input is empty, there is nothing to ask about"))
+		})
+	})
+}
@@ -1,49 +1,170 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/kardolus/chatgpt-cli/utils"
+	"io"
+	"os"
+	"reflect"
 	"strings"
-	"unicode/utf8"
+	"sync"
+	"time"
 
+	"github.com/kardolus/chatgpt-cli/clock"
 	"github.com/kardolus/chatgpt-cli/history"
 	"github.com/kardolus/chatgpt-cli/http"
+	"github.com/kardolus/chatgpt-cli/metrics"
+	"github.com/kardolus/chatgpt-cli/personas"
 	"github.com/kardolus/chatgpt-cli/types"
 )
 
 const (
 	AssistantRole            = "assistant"
-	ErrEmptyResponse         = "empty response"
+	ContinuePrompt           = "continue"
+	FinishReasonLength       = "length"
 	MaxTokenBufferPercentage = 20
 	SystemRole               = "system"
 	UserRole                 = "user"
+	CommandThreadPrefix      = "cmd_"
 	InteractiveThreadPrefix  = "int_"
+	PersonaThreadPrefix      = "persona_"
+	WatchThreadPrefix        = "watch_"
+	JSONRetryPrompt          = "your previous output was invalid JSON: %s; return only valid JSON"
+	ResponseFormatJSON       = "json_object"
 	gptPrefix                = "gpt"
 )
 
+var (
+	// ErrEmptyResponse is returned when the API answered with an empty body.
+	ErrEmptyResponse = errors.New("empty response")
+	// ErrNoResponses is returned when the answer of the API holds no choices.
+	ErrNoResponses = errors.New("no responses returned")
+	// ErrNothingToRegenerate is returned by Regenerate for a thread without questions.
+	ErrNothingToRegenerate = errors.New("there is no question to regenerate an answer for")
+)
+
+// BatchPrompt is a prompt of QueryEach, with the model, the system prompt and the sampling that
+// replace those of the configuration for it, when they are set.
+type BatchPrompt struct {
+	Prompt      string   `json:"prompt"`
+	Model       string   `json:"model,omitempty"`
+	System      string   `json:"system,omitempty"`
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	MaxTokens   int      `json:"max_tokens,omitempty"`
+}
+
+// configure returns cfg with the settings of the prompt.
+func (p BatchPrompt) configure(cfg types.Config) types.Config {
+	if p.Model != "" {
+		cfg.Model = p.Model
+	}
+	if p.System != "" {
+		cfg.Role = p.System
+	}
+	if p.Temperature != nil {
+		cfg.Temperature = *p.Temperature
+	}
+	if p.TopP != nil {
+		cfg.TopP = *p.TopP
+	}
+	if p.MaxTokens > 0 {
+		cfg.MaxTokens = p.MaxTokens
+	}
+
+	return cfg
+}
+
+// BatchResult holds the outcome of a single prompt sent through QueryAll or QueryEach.
+type BatchResult struct {
+	Prompt string
+	Answer string
+	Usage  types.Usage
+	Err    error
+}
+
+// Client is safe for concurrent use. Calls that take part in the conversation, such as Query,
+// Stream, Regenerate or UsePersona, are serialized, so the history is read, extended and written
+// back as a single step and concurrent exchanges never interleave. QueryAll does not touch the
+// conversation and runs its prompts in parallel, with a copy of the configuration taken when it is
+// called, so conversation calls that change the configuration don't affect it. Callers must not
+// modify Config and History themselves while calls are in flight.
 type Client struct {
 	Config       types.Config
 	History      []types.Message
 	caller       http.Caller
 	historyStore history.HistoryStore
+	usage        types.Usage
+	// firstToken is how long the most recent answer took to start streaming, 0 when it wasn't
+	// streamed
+	firstToken time.Duration
+	// finishReason and requestID are the finish reason and the id of the request of the most
+	// recent answer
+	finishReason string
+	requestID    string
+	limiter      *RateLimiter
+	personas     *personas.Personas
+	beforeHooks  []BeforeHook
+	afterHooks   []AfterHook
+	redactor     *history.Redactor
+	// system replaces the system prompt of the requests, without being stored in the history
+	system string
+	// reportRedactions is told about the secrets the redactor replaced
+	reportRedactions RedactionReporter
+	// reportHistoryError is told when the history could not be written after an exchange
+	reportHistoryError func(error)
+	// synced is the thread as it was last read from or written to the store, and unsaved counts
+	// the messages at the end of History that are not in the store yet.
+	synced  []types.Message
+	unsaved int
+	// sessionPolicy decides whether StartSession starts a new thread
+	sessionPolicy SessionPolicy
+	// callerFactory makes the caller again when the project of a persona replaces the project
+	callerFactory http.CallerFactory
+	// streamOutput is where Stream writes the answer as it arrives
+	streamOutput io.Writer
+	// observers are told about the writes of the history that wait in observed
+	observers []WriteObserver
+	observed  chan observedWrite
+	pending   sync.WaitGroup
+	metrics   metrics.Metrics
+	tracer    Tracer
+	progress  Progress
+	clock     clock.Clock
+	mu        sync.Mutex
 }
 
 func New(callerFactory http.CallerFactory, hs history.HistoryStore, cfg types.Config, interactiveMode bool) *Client {
 	caller := callerFactory(cfg)
 
 	if interactiveMode && cfg.AutoCreateNewThread {
-		hs.SetThread(utils.GenerateUniqueSlug(InteractiveThreadPrefix))
+		// Config.Thread always names the thread the client converses in
+		cfg.Thread = utils.GenerateUniqueSlug(InteractiveThreadPrefix)
+		hs.SetThread(cfg.Thread)
 	} else {
 		hs.SetThread(cfg.Thread)
 	}
 
-	return &Client{
-		Config:       cfg,
-		caller:       caller,
-		historyStore: hs,
+	result := &Client{
+		Config:        cfg,
+		caller:        caller,
+		callerFactory: callerFactory,
+		historyStore:  hs,
+		streamOutput:  os.Stdout,
+		metrics:       metrics.Nop{},
+		tracer:        nopTracer{},
+		progress:      nopProgress{},
+		clock:         clock.Real,
 	}
+
+	if cfg.RequestsPerMinute > 0 || cfg.TokensPerMinute > 0 {
+		result.limiter = NewRateLimiter(cfg.RequestsPerMinute, cfg.TokensPerMinute)
+	}
+
+	return result
 }
 
 func (c *Client) WithContextWindow(window int) *Client {
@@ -56,6 +177,110 @@ func (c *Client) WithServiceURL(url string) *Client {
 	return c
 }
 
+// WithRateLimiter replaces the rate limiter created from the configuration. Clients that share a
+// RateLimiter share its limits.
+func (c *Client) WithRateLimiter(limiter *RateLimiter) *Client {
+	c.limiter = limiter
+	return c
+}
+
+// WithEphemeralHistory keeps the conversation in memory instead of the history store the client was
+// created with. Queries still build on each other for as long as the client lives, but nothing is
+// read from or written to disk.
+func (c *Client) WithEphemeralHistory() *Client {
+	store := history.NewMemoryStore()
+	store.SetThread(c.Config.Thread)
+
+	c.historyStore = store
+	return c
+}
+
+// WithSystem replaces the system prompt of the requests that follow with the prompt, whatever the
+// role of the configuration or of its persona is, such as for a single run. The history is sent
+// with the prompt in place of its system messages, but it is stored with them as they were. An
+// empty prompt sends the system prompt of the history again.
+func (c *Client) WithSystem(prompt string) *Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.system = prompt
+	return c
+}
+
+// System returns the prompt WithSystem replaces the system prompt with, empty when there is none.
+func (c *Client) System() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.system
+}
+
+// WithStreamOutput replaces the standard output, which Stream writes the answer to as it arrives.
+func (c *Client) WithStreamOutput(output io.Writer) *Client {
+	c.streamOutput = output
+	return c
+}
+
+// WithClock replaces the clock that timestamps the messages added to the history, times the
+// requests and tells how recent a session is, along with the clock of the rate limiter of the
+// client, if any.
+func (c *Client) WithClock(clock clock.Clock) *Client {
+	c.clock = clock
+	if c.limiter != nil {
+		c.limiter.WithClock(clock)
+	}
+	return c
+}
+
+// WithHistoryErrorReporter is told about the errors of writing the history after an exchange, which
+// don't fail the exchange itself, such as a thread that was changed on a remote in the meantime.
+func (c *Client) WithHistoryErrorReporter(reporter func(error)) *Client {
+	c.reportHistoryError = reporter
+	return c
+}
+
+// WithPersonas sets the personas that UsePersona can switch to.
+func (c *Client) WithPersonas(p *personas.Personas) *Client {
+	c.personas = p
+	return c
+}
+
+// Usage returns the token usage of the most recent Query or Stream call. For streams the usage
+// is only available when TrackTokenUsage is enabled and the API reports it in the final chunk.
+func (c *Client) Usage() types.Usage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.usage
+}
+
+// FirstToken returns how long the answer of the most recent Stream call took to start, from when
+// its request was sent, and 0 when the most recent answer wasn't streamed.
+func (c *Client) FirstToken() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.firstToken
+}
+
+// FinishReason returns the finish reason of the most recent answer, such as stop, or length when
+// the answer was cut off by the token limit, and empty when it is unknown.
+func (c *Client) FinishReason() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.finishReason
+}
+
+// RequestID returns the id the API gave the request of the most recent answer, the last one when
+// it took several, and empty when the API didn't tell it.
+func (c *Client) RequestID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.requestID
+}
+
 // ListModels retrieves a list of all available models from the OpenAI API.
 // The models are returned as a slice of strings, each entry representing a model ID.
 // Models that have an ID starting with 'gpt' are included.
@@ -63,15 +288,42 @@ func (c *Client) WithServiceURL(url string) *Client {
 // In case of an error during the retrieval or processing of the models,
 // the method returns an error. If the API response is empty, an error is returned as well.
 func (c *Client) ListModels() ([]string, error) {
+	ids, err := c.ModelIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	return FormatModels(ids, c.Config.Model), nil
+}
+
+// FormatModels formats the IDs of the models as ListModels lists them, with the current model
+// marked.
+func FormatModels(ids []string, current string) []string {
+	var result []string
+	for _, id := range ids {
+		if id != current {
+			result = append(result, fmt.Sprintf("- %s", id))
+			continue
+		}
+		result = append(result, fmt.Sprintf("* %s (current)", id))
+	}
+
+	return result
+}
+
+// ModelIDs returns the IDs of the models that ListModels lists, without marking the current one.
+func (c *Client) ModelIDs() ([]string, error) {
 	var result []string
 
 	endpoint := c.getEndpoint(c.Config.ModelsPath)
 
 	if c.Config.Debug {
-		c.printRequestDebugInfo(endpoint, nil)
+		c.printRequestDebugInfo(c.Config, endpoint, nil)
 	}
 
+	start := c.clock.Now()
 	raw, err := c.caller.Get(c.getEndpoint(c.Config.ModelsPath))
+	c.observeRequest(start, "", err)
 	if c.Config.Debug {
 		c.printResponseDebugInfo(raw)
 	}
@@ -87,17 +339,24 @@ func (c *Client) ListModels() ([]string, error) {
 
 	for _, model := range response.Data {
 		if strings.HasPrefix(model.Id, gptPrefix) {
-			if model.Id != c.Config.Model {
-				result = append(result, fmt.Sprintf("- %s", model.Id))
-				continue
-			}
-			result = append(result, fmt.Sprintf("* %s (current)", model.Id))
+			result = append(result, model.Id)
 		}
 	}
 
 	return result, nil
 }
 
+// Warmup sets up the connection to the API ahead of the first query, so an interactive session
+// doesn't wait for it on its first answer. It does nothing when the caller can't warm up.
+func (c *Client) Warmup(ctx context.Context) error {
+	caller, ok := c.caller.(http.Warmer)
+	if !ok {
+		return nil
+	}
+
+	return caller.Warmup(ctx, c.getEndpoint(c.Config.ModelsPath))
+}
+
 // ProvideContext adds custom context to the client's history by converting the
 // provided string into a series of messages. This allows the ChatGPT API to have
 // prior knowledge of the provided context when generating responses.
@@ -106,9 +365,229 @@ func (c *Client) ListModels() ([]string, error) {
 // and the method will split it into messages, preserving punctuation and special
 // characters.
 func (c *Client) ProvideContext(context string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	c.initHistory()
 	messages := createMessagesFromString(context)
+	for i := range messages {
+		messages[i].Timestamp = c.clock.Now()
+	}
 	c.History = append(c.History, messages...)
+	c.unsaved += len(messages)
+}
+
+// Record adds the content to the conversation as a message of the user and stores it, without
+// sending anything, such as the outcome of a command that an answer suggested.
+func (c *Client) Record(content string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.initHistory()
+	c.History = append(c.History, types.Message{
+		Role:      UserRole,
+		Content:   content,
+		Timestamp: c.clock.Now(),
+	})
+	c.unsaved++
+
+	c.writeHistory()
+}
+
+// SetThread binds the client to the named thread, which keeps its own conversation. The history of
+// the thread is read on the next query, and a thread that does not exist yet starts with the system
+// prompt. Names that can't be stored in the history directory are rejected.
+func (c *Client) SetThread(name string) error {
+	if err := history.ValidateThread(name); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.historyStore.SetThread(name)
+	c.Config.Thread = name
+	c.History = nil
+
+	return nil
+}
+
+// ClearHistory removes the conversation of the current thread. The thread itself stays current, and
+// the next query starts over with the configured role as its system prompt.
+func (c *Client) ClearHistory() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.historyStore.Clear(c.Config.Thread); err != nil {
+		return err
+	}
+
+	c.History = nil
+
+	return nil
+}
+
+// DeleteThread deletes the named thread. When the current thread is deleted the client falls back
+// to the thread the store falls back to, which starts with the system prompt on the next query.
+func (c *Client) DeleteThread(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.historyStore.DeleteThread(name); err != nil {
+		return err
+	}
+
+	if name == c.Config.Thread {
+		c.Config.Thread = c.historyStore.GetThread()
+		c.History = nil
+	}
+
+	return nil
+}
+
+// RenameThread renames the thread from to to. When the current thread is renamed the client keeps
+// using it under its new name.
+func (c *Client) RenameThread(from, to string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.historyStore.RenameThread(from, to); err != nil {
+		return err
+	}
+
+	if from == c.Config.Thread {
+		c.Config.Thread = to
+	}
+
+	return nil
+}
+
+// MergeThreads merges the messages of src into dst and removes src, see history.MergeStrategy for
+// where the messages go. When the current thread is merged the client continues in dst, and when
+// dst is the current thread it is read again on the next query.
+func (c *Client) MergeThreads(dst, src string, strategy history.MergeStrategy) (history.MergeResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result, err := c.historyStore.MergeThreads(dst, src, strategy)
+	if err != nil {
+		return history.MergeResult{}, err
+	}
+
+	if src == c.Config.Thread {
+		c.Config.Thread = dst
+	}
+
+	if dst == c.Config.Thread {
+		c.History = nil
+	}
+
+	return result, nil
+}
+
+// DeleteMessages removes the messages at the given positions, counting from zero, from the thread.
+// System prompts can't be deleted. With pairs a question is removed along with its answer, without
+// it the result reports the questions whose answer was kept. When the current thread is changed the
+// client reads it again on the next query.
+func (c *Client) DeleteMessages(thread string, indices []int, pairs bool) (history.DeleteResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result, err := c.historyStore.DeleteMessages(thread, indices, pairs)
+	if err != nil {
+		return history.DeleteResult{}, err
+	}
+
+	if thread == c.Config.Thread {
+		c.History = nil
+	}
+
+	return result, nil
+}
+
+// SetPinned pins or unpins the messages at the given positions, counting from zero, in the thread.
+// Pinned messages are always sent with the queries, no matter how long the conversation gets.
+// When the current thread is changed the client reads it again on the next query.
+func (c *Client) SetPinned(thread string, indices []int, pinned bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.historyStore.SetPinned(thread, indices, pinned); err != nil {
+		return err
+	}
+
+	if thread == c.Config.Thread {
+		c.History = nil
+	}
+
+	return nil
+}
+
+// UsePersona switches to the named persona, using its system prompt and, when set, its model and
+// temperature for the following queries. When the conversation has not started yet the persona
+// simply replaces the system prompt. Otherwise the new system prompt is added to the conversation,
+// or a new thread is started for the persona when PersonaNewThread is set. Switching to the active
+// persona does nothing. An unknown name returns an error that lists the available personas.
+func (c *Client) UsePersona(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	registry := c.personas
+	if registry == nil {
+		registry = personas.New()
+	}
+
+	persona, err := registry.Get(name)
+	if err != nil {
+		return err
+	}
+
+	if c.Config.Persona == name {
+		return nil
+	}
+
+	c.initHistory()
+	started := len(c.History) > 1
+
+	project := c.Config.Project
+	c.Config = personas.Apply(c.Config, persona)
+	if c.Config.Project != project {
+		// the project is a header of every request the caller sends
+		c.caller = c.callerFactory(c.Config)
+	}
+
+	switch {
+	case !started:
+		c.History[0].Content = persona.Role
+	case c.Config.PersonaNewThread:
+		thread := utils.GenerateUniqueSlug(PersonaThreadPrefix)
+		c.historyStore.SetThread(thread)
+		c.Config.Thread = thread
+		c.History = []types.Message{{
+			Role:      SystemRole,
+			Content:   persona.Role,
+			Timestamp: c.clock.Now(),
+		}}
+		c.synced, c.unsaved = nil, 0
+	default:
+		c.History = append(c.History, types.Message{
+			Role:      SystemRole,
+			Content:   persona.Role,
+			Timestamp: c.clock.Now(),
+		})
+		c.unsaved++
+		c.writeHistory()
+	}
+
+	return nil
+}
+
+// UseModel switches to the model for the following queries, the conversation is kept as it is.
+func (c *Client) UseModel(model string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Config.Model = model
 }
 
 // Query sends a query to the API, returning the response as a string along with the token usage.
@@ -116,40 +595,544 @@ func (c *Client) ProvideContext(context string) {
 // Returns the API response string, the number of tokens used, and an error if any issues occur.
 // If the response contains choices, it decodes the JSON and returns the content of the first choice.
 func (c *Client) Query(input string) (string, int, error) {
+	return c.QueryContext(context.Background(), input)
+}
+
+// QueryContext behaves like Query, its request is bound to ctx, which cancels it and carries the
+// span it is traced in.
+func (c *Client) QueryContext(ctx context.Context, input string) (string, int, error) {
+	response, err := c.queryWithResponse(ctx, input)
+	if err != nil {
+		return "", response.Usage.TotalTokens, err
+	}
+
+	return response.Choices[0].Message.Content, response.Usage.TotalTokens, nil
+}
+
+// QueryWithResponse behaves like Query but returns the fully decoded API response, including the
+// model that served the request, the finish reason, the usage, the system fingerprint and the ids
+// the API gave the requests. When MaxContinuations is set and the answer was cut off by the token
+// limit, the client asks the model to continue and stitches the parts into the first choice. The
+// usage and the requests then cover all the calls that were made, while the remaining fields
+// describe the last one.
+func (c *Client) QueryWithResponse(input string) (types.CompletionsResponse, error) {
+	return c.queryWithResponse(context.Background(), input)
+}
+
+func (c *Client) queryWithResponse(ctx context.Context, input string) (types.CompletionsResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	c.prepareQuery(input)
 
-	body, err := c.createBody(false)
+	c.progress.Start(c.Config.Model)
+	defer c.progress.Stop()
+
+	response, err := c.complete(ctx, c.Config, c.withSystem(c.History))
+	c.usage, c.firstToken = response.Usage, 0
+	c.finishReason, c.requestID = finishReason(response), lastRequestID(response.Requests)
 	if err != nil {
-		return "", 0, err
+		return response, err
 	}
 
-	endpoint := c.getEndpoint(c.Config.CompletionsPath)
+	c.updateHistory(response.Choices[0].Message.Content)
+	c.titleThread(ctx)
 
-	if c.Config.Debug {
-		c.printRequestDebugInfo(endpoint, body)
+	return response, nil
+}
+
+// QueryJSON behaves like Query but expects the answer to be JSON and decodes it into v. When v is
+// nil the answer is only validated. An answer that fails to decode is sent back to the model
+// together with the decoding error, up to JSONRetries times, and the first answer that decodes is
+// returned. Only the final answer is stored in the history unless KeepJSONRetries is set, in which
+// case the failed attempts and the corrections that followed them are stored as well. The number
+// of tokens covers all attempts. When no attempt decodes, the last answer is returned together
+// with the decoding error and the history is left untouched.
+func (c *Client) QueryJSON(input string, v interface{}) (string, int, error) {
+	return c.QueryJSONContext(context.Background(), input, v)
+}
+
+// QueryJSONContext behaves like QueryJSON, its requests are bound to ctx, which cancels them and
+// carries the span they are traced in.
+func (c *Client) QueryJSONContext(ctx context.Context, input string, v interface{}) (result string, tokens int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.prepareQuery(input)
+
+	if v == nil {
+		v = &json.RawMessage{}
 	}
 
-	raw, err := c.caller.Post(endpoint, body, false)
-	if c.Config.Debug {
-		c.printResponseDebugInfo(raw)
+	var (
+		usage    types.Usage
+		attempts []types.Message
+		last     types.CompletionsResponse
+	)
+
+	// the title is asked for in a query of its own
+	parent := ctx
+
+	// the retries are requests of the same query
+	ctx, end := c.startQuery(ctx, c.Config.Model, c.History)
+	defer func() {
+		err = http.QueryTimeout(ctx, err)
+		end(finishReason(last), usage, err)
+	}()
+
+	c.progress.Start(c.Config.Model)
+	defer c.progress.Stop()
+
+	for attempt := 0; ; attempt++ {
+		response, err := c.complete(ctx, c.Config, c.withSystem(append(c.History[:len(c.History):len(c.History)], attempts...)))
+		usage = addUsage(usage, response.Usage)
+		c.usage, c.firstToken = usage, 0
+		c.finishReason, c.requestID = finishReason(response), lastRequestID(response.Requests)
+		last = response
+		if err != nil {
+			return "", usage.TotalTokens, err
+		}
+
+		answer := response.Choices[0].Message.Content
+
+		decodeErr := json.Unmarshal([]byte(answer), v)
+		if decodeErr == nil {
+			if c.Config.KeepJSONRetries {
+				c.History = append(c.History, attempts...)
+				c.unsaved += len(attempts)
+			}
+			c.updateHistory(answer)
+			c.titleThread(parent)
+
+			return answer, usage.TotalTokens, nil
+		}
+
+		if attempt >= c.Config.JSONRetries {
+			return answer, usage.TotalTokens, fmt.Errorf("invalid JSON after %d attempt(s): %w", attempt+1, decodeErr)
+		}
+
+		if c.Config.Debug {
+			_, _ = fmt.Fprintf(os.Stderr, "\nAttempt %d returned invalid JSON, retrying: %v\n", attempt+1, decodeErr)
+		}
+		c.metrics.ObserveRetry(metrics.RetryInvalidJSON)
+
+		attempts = append(attempts, types.Message{
+			Role:      AssistantRole,
+			Content:   answer,
+			Timestamp: c.clock.Now(),
+		}, types.Message{
+			Role:      UserRole,
+			Content:   fmt.Sprintf(JSONRetryPrompt, decodeErr),
+			Timestamp: c.clock.Now(),
+		})
 	}
+}
 
+// Ask sends the prompt on its own, with the system role only, as QueryAll does: the conversation
+// is neither read nor written. The progress is shown while the answer is awaited, and Usage is the
+// usage of the answer.
+func (c *Client) Ask(ctx context.Context, prompt string) (string, int, error) {
+	c.mu.Lock()
+	cfg := c.isolatedConfig()
+	c.mu.Unlock()
+
+	c.progress.Start(cfg.Model)
+	result := c.queryIsolated(ctx, cfg, prompt)
+	c.progress.Stop()
+
+	c.mu.Lock()
+	c.usage, c.firstToken = result.Usage, 0
+	c.finishReason, c.requestID = "", ""
+	c.mu.Unlock()
+
+	return result.Answer, result.Usage.TotalTokens, result.Err
+}
+
+// QueryAll sends every prompt as an independent query, using at most concurrency requests in
+// flight at a time. Each prompt gets its own ephemeral history consisting of the system role and
+// the prompt itself; the shared conversation history is neither read nor written. The results are
+// returned in the order of the prompts. Once ctx is done no new prompts are sent, and the prompts
+// that were not sent carry the context error.
+func (c *Client) QueryAll(ctx context.Context, prompts []string, concurrency int) []BatchResult {
+	batch := make([]BatchPrompt, len(prompts))
+	results := make([]BatchResult, len(prompts))
+	sent := make([]bool, len(prompts))
+	for i, prompt := range prompts {
+		batch[i].Prompt, results[i].Prompt = prompt, prompt
+	}
+
+	c.QueryEach(ctx, batch, concurrency, func(index int, result BatchResult) {
+		results[index], sent[index] = result, true
+	})
+
+	for i := range results {
+		if !sent[i] {
+			results[i].Err = ctx.Err()
+		}
+	}
+
+	return results
+}
+
+// QueryEach sends the prompts as QueryAll does, each with its own settings, and calls done with the
+// index and the result of every prompt as soon as it is answered, rather than once all of them
+// are. The calls of done never overlap, so it can write the results as they come. Once ctx is done
+// no new prompts are sent, and done isn't called for them.
+func (c *Client) QueryEach(ctx context.Context, prompts []BatchPrompt, concurrency int, done func(index int, result BatchResult)) {
+	// the prompts are sent with the configuration as it is now, whatever happens to the
+	// conversation in the meantime
+	c.mu.Lock()
+	cfg := c.isolatedConfig()
+	c.mu.Unlock()
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var (
+		jobs     = make(chan int)
+		finished sync.Mutex
+		wg       sync.WaitGroup
+	)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range jobs {
+				prompt := prompts[index]
+				result := c.queryIsolated(ctx, prompt.configure(cfg), prompt.Prompt)
+
+				finished.Lock()
+				done(index, result)
+				finished.Unlock()
+			}
+		}()
+	}
+
+dispatch:
+	for next := 0; next < len(prompts) && ctx.Err() == nil; next++ {
+		select {
+		case <-ctx.Done():
+			break dispatch
+		case jobs <- next:
+		}
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// RegenerateOptions tunes how Regenerate produces a new answer.
+type RegenerateOptions struct {
+	// Temperature replaces the configured temperature for the new answer when it is set, so an
+	// answer can be regenerated with a temperature of 0.
+	Temperature *float64
+	// KeepAlternative stores the discarded answer as an alternative of the new one instead of
+	// dropping it.
+	KeepAlternative bool
+}
+
+// Regenerate replaces the last answer in the conversation with a new one. It removes the trailing
+// assistant message, sends the conversation ending with the last user message again and stores the
+// new answer in its place. When the history already ends with a user message that message is
+// simply queried again. It returns the new answer and the number of tokens used, or an error when
+// there is no user message to answer.
+func (c *Client) Regenerate(opts RegenerateOptions) (string, int, error) {
+	return c.RegenerateContext(context.Background(), opts)
+}
+
+// RegenerateContext behaves like Regenerate, its request is bound to ctx, which cancels it.
+func (c *Client) RegenerateContext(ctx context.Context, opts RegenerateOptions) (string, int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.initHistory()
+
+	messages := c.History
+
+	var discarded *types.Message
+	if last := messages[len(messages)-1]; last.Role == AssistantRole {
+		discarded = &last
+		messages = messages[:len(messages)-1]
+	}
+
+	if len(messages) < 2 || messages[len(messages)-1].Role != UserRole {
+		return "", 0, ErrNothingToRegenerate
+	}
+
+	// the override only applies to this request
+	cfg := c.Config
+	if opts.Temperature != nil {
+		cfg.Temperature = *opts.Temperature
+	}
+
+	c.progress.Start(cfg.Model)
+	defer c.progress.Stop()
+
+	response, err := c.complete(ctx, cfg, c.withSystem(messages))
+	c.usage, c.firstToken = response.Usage, 0
+	c.finishReason, c.requestID = finishReason(response), lastRequestID(response.Requests)
 	if err != nil {
-		return "", 0, err
+		return "", response.Usage.TotalTokens, err
 	}
 
-	var response types.CompletionsResponse
-	if err := c.processResponse(raw, &response); err != nil {
-		return "", 0, err
+	answer := types.Message{
+		Role:      AssistantRole,
+		Content:   response.Choices[0].Message.Content,
+		Timestamp: c.clock.Now(),
+		Model:     cfg.Model,
 	}
 
-	if len(response.Choices) == 0 {
-		return "", response.Usage.TotalTokens, errors.New("no responses returned")
+	if discarded != nil && opts.KeepAlternative {
+		answer.Alternatives = append(append([]string(nil), discarded.Alternatives...), discarded.Content)
 	}
 
-	c.updateHistory(response.Choices[0].Message.Content)
+	c.storeRegenerated(messages, discarded, answer)
 
-	return response.Choices[0].Message.Content, response.Usage.TotalTokens, nil
+	return answer.Content, response.Usage.TotalTokens, nil
+}
+
+// storeRegenerated replaces the discarded answer of the messages with the new one. The thread is
+// re-read while the store holds its lock: when another process added to it in the meantime the
+// answer replaces the discarded one in what is stored, so the exchanges of the other process are
+// kept, and the client continues with the merged conversation.
+func (c *Client) storeRegenerated(messages []types.Message, discarded *types.Message, answer types.Message) {
+	// the messages from start on were never stored, along with the answer
+	start := min(len(c.History)-c.unsaved, len(messages))
+	c.History = append(messages[:len(messages):len(messages)], answer)
+
+	if c.Config.OmitHistory {
+		return
+	}
+
+	// what was stored before is redacted already
+	c.redactMessages(c.History[start:], false)
+	answer = c.History[len(c.History)-1]
+
+	var written []types.Message
+	err := c.historyStore.Update(func(stored []types.Message) []types.Message {
+		if len(stored) == 0 || equalMessages(stored, c.synced) {
+			written = c.History
+		} else {
+			written = replaceAnswer(append(stored[:len(stored):len(stored)], messages[start:]...), messages, discarded, answer)
+		}
+		return written
+	})
+	if err != nil {
+		c.historyError(err)
+		return
+	}
+
+	c.History = written
+	c.synced = append([]types.Message(nil), written...)
+	c.unsaved = 0
+}
+
+// replaceAnswer returns the stored thread with the answer in place of the discarded answer of the
+// messages. When the stored thread no longer starts with the messages, as it was rewound or
+// rewritten in the meantime, the question is asked again at its end instead.
+func replaceAnswer(stored, messages []types.Message, discarded *types.Message, answer types.Message) []types.Message {
+	n := len(messages)
+	if len(stored) < n || !equalMessages(stored[:n], messages) {
+		return append(stored[:len(stored):len(stored)], messages[n-1], answer)
+	}
+
+	rest := stored[n:]
+	if discarded != nil && len(rest) > 0 && equalMessages(rest[:1], []types.Message{*discarded}) {
+		rest = rest[1:]
+	}
+
+	return append(append(stored[:n:n], answer), rest...)
+}
+
+// Rewind removes the last n exchanges from the conversation and returns the removed messages in
+// their original order. An exchange is a run of user messages together with the answers that
+// followed it. The system message is never removed, and when n exceeds the number of exchanges
+// everything but the system message is removed. System prompts added later on, by switching
+// personas, don't count as exchanges: they are removed together with the exchanges around them,
+// but kept when they come after the last exchange.
+func (c *Client) Rewind(n int) ([]types.Message, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.initHistory()
+
+	if end, trailing := rewindBounds(c.History, n); end == trailing {
+		return nil, nil
+	}
+
+	if c.Config.OmitHistory {
+		kept, removed := rewindMessages(c.History, n)
+		c.History = kept
+		c.synced = append([]types.Message(nil), kept...)
+		c.unsaved = 0
+
+		return removed, nil
+	}
+
+	// the thread is cut while the store holds its lock, so an exchange another process added in
+	// the meantime is counted rather than silently overwritten
+	var kept, removed []types.Message
+	err := c.historyStore.Update(func(stored []types.Message) []types.Message {
+		current := c.History
+		if len(stored) > 0 && !equalMessages(stored, c.synced) {
+			current = append(stored[:len(stored):len(stored)], c.History[len(c.History)-c.unsaved:]...)
+		}
+
+		kept, removed = rewindMessages(current, n)
+		return kept
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c.History = kept
+	c.synced = append([]types.Message(nil), kept...)
+	c.unsaved = 0
+
+	return removed, nil
+}
+
+// rewindMessages splits the messages into those Rewind keeps and the last n exchanges it removes.
+func rewindMessages(messages []types.Message, n int) ([]types.Message, []types.Message) {
+	end, trailing := rewindBounds(messages, n)
+
+	kept := append(append([]types.Message(nil), messages[:end]...), messages[trailing:]...)
+	removed := append([]types.Message(nil), messages[end:trailing]...)
+
+	return kept, removed
+}
+
+// rewindBounds returns the range of the messages that the last n exchanges take up, which is empty
+// when there are none. The system prompts that come after the last exchange are left out of it.
+func rewindBounds(messages []types.Message, n int) (int, int) {
+	trailing := len(messages)
+	for trailing > 1 && messages[trailing-1].Role == SystemRole {
+		trailing--
+	}
+
+	end := trailing
+	for i := 0; i < n && end > 1; i++ {
+		start := end
+		for start > 1 && messages[start-1].Role == SystemRole {
+			start--
+		}
+		for start > 1 && messages[start-1].Role == AssistantRole {
+			start--
+		}
+		if start == 1 || messages[start-1].Role != UserRole {
+			break
+		}
+		for start > 1 && messages[start-1].Role == UserRole {
+			start--
+		}
+		end = start
+	}
+
+	return end, trailing
+}
+
+// Fork copies the first upTo messages of the conversation into a new thread and switches the
+// client to it, leaving the original thread untouched. The prefix has to end on an exchange
+// boundary, where system prompts added by persona switches don't count; forking in between a
+// question and its answer is rejected, as is forking into a thread that already has history.
+func (c *Client) Fork(name string, upTo int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.initHistory()
+
+	if upTo < 1 || upTo > len(c.History) {
+		return fmt.Errorf("cannot fork at message %d, the thread has %d messages", upTo, len(c.History))
+	}
+
+	if !c.exchangeBoundary(upTo) {
+		return fmt.Errorf("cannot fork at message %d, it splits an exchange", upTo)
+	}
+
+	if err := history.ValidateThread(name); err != nil {
+		return err
+	}
+
+	previous := c.historyStore.GetThread()
+	if name == previous {
+		return fmt.Errorf("cannot fork thread %s into itself", name)
+	}
+
+	existing, err := c.historyStore.ReadThread(name)
+	if err != nil {
+		return err
+	}
+
+	if len(existing) > 0 {
+		return fmt.Errorf("thread %s already exists", name)
+	}
+
+	prefix := append([]types.Message(nil), c.History[:upTo]...)
+
+	// the thread is checked again while the store holds its lock, so a thread another process
+	// created in the meantime isn't overwritten
+	c.historyStore.SetThread(name)
+	err = c.historyStore.Update(func(stored []types.Message) []types.Message {
+		if len(stored) > 0 {
+			existing = stored
+			return stored
+		}
+		return prefix
+	})
+	if err == nil && len(existing) > 0 {
+		err = fmt.Errorf("thread %s already exists", name)
+	}
+	if err != nil {
+		c.historyStore.SetThread(previous)
+		return err
+	}
+
+	c.Config.Thread = name
+	c.History = prefix
+	c.synced = append([]types.Message(nil), prefix...)
+	c.unsaved = 0
+
+	return nil
+}
+
+// exchangeBoundary reports whether the history can be cut before the message at index without
+// splitting an exchange. System prompts added by persona switches are skipped, so a cut right
+// before or after one of them is fine.
+func (c *Client) exchangeBoundary(index int) bool {
+	next := index
+	for next < len(c.History) && c.History[next].Role == SystemRole {
+		next++
+	}
+	if next < len(c.History) && c.History[next].Role != UserRole {
+		return false
+	}
+
+	previous := index - 1
+	for previous > 0 && c.History[previous].Role == SystemRole {
+		previous--
+	}
+
+	return previous == 0 || c.History[previous].Role != UserRole
+}
+
+// DryRun prepares the request that Query (or Stream, when stream is true) would send for the
+// input and returns its endpoint and body without sending it. The history is read, trimmed and
+// merged exactly as it would be for a real query, but nothing is written back and the in-memory
+// history is left untouched.
+func (c *Client) DryRun(input string, stream bool) (string, []byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	history := c.History
+	defer func() {
+		c.History = history
+	}()
+
+	// work on a copy, truncation rearranges the messages in place
+	c.History = append([]types.Message(nil), history...)
+	c.prepareQuery(input)
+
+	return c.buildRequest(c.Config, c.withSystem(c.History), stream)
 }
 
 // Stream sends a query to the API and processes the response as a stream.
@@ -158,56 +1141,294 @@ func (c *Client) Query(input string) (string, int, error) {
 // input and then makes an API call using the Post method. The actual
 // processing of the streamed response is done in the Post method.
 func (c *Client) Stream(input string) error {
+	return c.StreamContext(context.Background(), input)
+}
+
+// StreamContext behaves like Stream, its request is bound to ctx, which stops the stream when it
+// is canceled and carries the span it is traced in. The span ends once the stream does. The part
+// of the answer that arrived before ctx was canceled is stored in the history.
+func (c *Client) StreamContext(ctx context.Context, input string) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	c.prepareQuery(input)
+	c.finishReason, c.requestID = "", ""
+
+	// the title is asked for in a query of its own, once this one ended
+	parent := ctx
 
-	body, err := c.createBody(true)
+	messages := c.withSystem(c.History)
+	ctx, end := c.startQuery(ctx, c.Config.Model, messages)
+	defer func() {
+		err = http.QueryTimeout(ctx, err)
+		end(c.finishReason, c.usage, err)
+	}()
+
+	endpoint, body, err := c.buildRequest(c.Config, messages, true)
 	if err != nil {
 		return err
 	}
 
-	endpoint := c.getEndpoint(c.Config.CompletionsPath)
+	if err := c.limiter.Wait(ctx, estimateTokens(messages)); err != nil {
+		return err
+	}
 
 	if c.Config.Debug {
-		c.printRequestDebugInfo(endpoint, body)
+		c.printRequestDebugInfo(c.Config, endpoint, body)
 	}
 
-	result, err := c.caller.Post(endpoint, body, true)
+	ctx, attempt := startAttempt(ctx)
+
+	var id string
+	ctx = http.RecordRequests(ctx, func(info types.RequestInfo) {
+		id = info.ID
+	})
+
+	start := c.clock.Now()
+	reader, err := c.postStream(ctx, endpoint, body)
 	if err != nil {
+		c.observeRequest(start, c.Config.Model, err)
+		attempt.End(http.StatusOf(err), err)
+		return err
+	}
+	// closing releases the connection, also when the stream broke off
+	defer reader.Close()
+
+	output := &firstWrite{Writer: c.streamOutput, now: c.clock.Now}
+	result, usage, reason, err := http.ReadStreamReason(reader, output, c.Config.Debug, http.ResponseLimitsOf(c.Config))
+	err = http.WithRequestID(err, id)
+	c.usage, c.firstToken = usage, 0
+	c.finishReason, c.requestID = reason, id
+
+	if !output.first.IsZero() {
+		c.firstToken = output.first.Sub(start)
+		c.metrics.ObserveFirstToken(c.firstToken, c.Config.Model)
+	}
+	c.observeRequest(start, c.Config.Model, err)
+	c.observeTokens(usage, c.Config.Model)
+	attempt.End(http.StatusOf(err), err)
+
+	// the part of an answer that arrived before the stream was canceled, such as on Ctrl-C, is kept
+	if errors.Is(err, context.Canceled) && len(result) > 0 {
+		c.updateHistory(string(result))
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := c.runAfterHooks(string(result), c.usage); err != nil {
 		return err
 	}
 
 	c.updateHistory(string(result))
+	c.titleThread(parent)
 
 	return nil
 }
 
-func (c *Client) createBody(stream bool) ([]byte, error) {
+// complete fetches a completion for the messages. When MaxContinuations is set and the answer was
+// cut off by the token limit, the model is asked to continue and the parts are stitched into the
+// first choice. The usage and the requests cover all the calls that were made, while the remaining
+// fields describe the last one. The messages slice itself is never modified.
+func (c *Client) complete(ctx context.Context, cfg types.Config, messages []types.Message) (response types.CompletionsResponse, err error) {
+	ctx, end := c.startQuery(ctx, cfg.Model, messages)
+	defer func() {
+		err = http.QueryTimeout(ctx, err)
+		end(finishReason(response), response.Usage, err)
+	}()
+
+	response, err = c.fetchCompletion(ctx, cfg, messages)
+	if err != nil {
+		return response, err
+	}
+
+	answer := response.Choices[0].Message.Content
+	usage := response.Usage
+	requests := response.Requests
+
+	for i := 0; i < cfg.MaxContinuations && response.Choices[0].FinishReason == FinishReasonLength; i++ {
+		continuation := append(messages[:len(messages):len(messages)], types.Message{
+			Role:    AssistantRole,
+			Content: answer,
+		}, types.Message{
+			Role:    UserRole,
+			Content: ContinuePrompt,
+		})
+
+		response, err = c.fetchCompletion(ctx, cfg, continuation)
+		requests = append(requests, response.Requests...)
+		if err != nil {
+			response.Usage = addUsage(usage, response.Usage)
+			response.Requests = requests
+			return response, err
+		}
+
+		answer = stitchContinuation(answer, response.Choices[0].Message.Content)
+		usage = addUsage(usage, response.Usage)
+	}
+
+	response.Choices[0].Message.Content = answer
+	response.Usage = usage
+	response.Requests = requests
+
+	if err := c.runAfterHooks(answer, usage); err != nil {
+		return response, err
+	}
+
+	return response, nil
+}
+
+func (c *Client) fetchCompletion(ctx context.Context, cfg types.Config, messages []types.Message) (types.CompletionsResponse, error) {
+	var response types.CompletionsResponse
+
+	endpoint, body, err := c.buildRequest(cfg, messages, false)
+	if err != nil {
+		return response, err
+	}
+
+	if err := c.limiter.Wait(ctx, estimateTokens(messages)); err != nil {
+		return response, err
+	}
+
+	if cfg.Debug {
+		c.printRequestDebugInfo(cfg, endpoint, body)
+	}
+
+	ctx, attempt := startAttempt(ctx)
+	ctx = http.RecordRequests(ctx, func(info types.RequestInfo) {
+		response.Requests = append(response.Requests, info)
+	})
+
+	start := c.clock.Now()
+	raw, err := c.post(ctx, endpoint, body)
+	c.observeRequest(start, cfg.Model, err)
+	attempt.End(http.StatusOf(err), err)
+	if cfg.Debug {
+		c.printResponseDebugInfo(raw)
+	}
+
+	if err != nil {
+		return response, err
+	}
+
+	// the errors of an answered request tell its id
+	id := lastRequestID(response.Requests)
+
+	if err := c.processResponse(raw, &response); err != nil {
+		return response, http.WithRequestID(err, id)
+	}
+	c.observeTokens(response.Usage, cfg.Model)
+
+	if len(response.Choices) == 0 {
+		return response, http.WithRequestID(ErrNoResponses, id)
+	}
+
+	if response.Choices[0].FinishReason == http.FinishReasonContentFilter {
+		return response, http.WithRequestID(http.ErrContentFiltered, id)
+	}
+
+	return response, nil
+}
+
+// buildRequest returns the endpoint and the body of the completions request for the messages. The
+// configuration is passed in, so requests made outside the conversation never read Config while
+// another call changes it.
+func (c *Client) buildRequest(cfg types.Config, messages []types.Message, stream bool) (string, []byte, error) {
+	messages, err := c.runBeforeHooks(messages)
+	if err != nil {
+		return "", nil, err
+	}
+
+	body, err := createBody(cfg, messages, stream)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return cfg.URL + cfg.CompletionsPath, body, nil
+}
+
+func createBody(cfg types.Config, messages []types.Message, stream bool) ([]byte, error) {
+	// only send what the API understands, the history may carry additional bookkeeping
+	requestMessages := make([]types.Message, len(messages))
+	for i, message := range messages {
+		requestMessages[i] = types.Message{
+			Role:    message.Role,
+			Content: message.Content,
+		}
+	}
+
 	body := types.CompletionsRequest{
-		Messages:         c.History,
-		Model:            c.Config.Model,
-		MaxTokens:        c.Config.MaxTokens,
-		Temperature:      c.Config.Temperature,
-		TopP:             c.Config.TopP,
-		FrequencyPenalty: c.Config.FrequencyPenalty,
-		PresencePenalty:  c.Config.PresencePenalty,
+		Messages:         requestMessages,
+		Model:            cfg.Model,
+		MaxTokens:        cfg.MaxTokens,
+		Temperature:      cfg.Temperature,
+		TopP:             cfg.TopP,
+		FrequencyPenalty: cfg.FrequencyPenalty,
+		PresencePenalty:  cfg.PresencePenalty,
+		Seed:             cfg.Seed,
+		Stop:             cfg.Stop,
 		Stream:           stream,
 	}
 
+	if stream && (cfg.TrackTokenUsage || cfg.ShowUsage) {
+		body.StreamOptions = &types.StreamOptions{IncludeUsage: true}
+	}
+
+	if cfg.JSONMode {
+		body.ResponseFormat = &types.ResponseFormat{Type: ResponseFormatJSON}
+	}
+
 	return json.Marshal(body)
 }
 
+// withSystem returns the messages of a request of the conversation with the prompt of WithSystem
+// as their system prompt, in place of the system messages of the history, such as the ones that
+// switching personas added, or the messages themselves without one. The messages are never
+// modified.
+func (c *Client) withSystem(messages []types.Message) []types.Message {
+	if c.system == "" {
+		return messages
+	}
+
+	result := make([]types.Message, 0, len(messages)+1)
+	result = append(result, types.Message{Role: SystemRole, Content: c.system})
+	for _, message := range messages {
+		if message.Role == SystemRole {
+			continue
+		}
+		result = append(result, message)
+	}
+
+	return result
+}
+
+// isolatedConfig returns the configuration of the queries that are sent on their own, whose system
+// prompt is the one of WithSystem, if any.
+func (c *Client) isolatedConfig() types.Config {
+	cfg := c.Config
+	if c.system != "" {
+		cfg.Role = c.system
+	}
+
+	return cfg
+}
+
 func (c *Client) initHistory() {
 	if len(c.History) != 0 {
 		return
 	}
 
+	c.synced, c.unsaved = nil, 0
+
 	if !c.Config.OmitHistory {
 		c.History, _ = c.historyStore.Read()
+		c.synced = append([]types.Message(nil), c.History...)
 	}
 
 	if len(c.History) == 0 {
 		c.History = []types.Message{{
-			Role: SystemRole,
+			Role:      SystemRole,
+			Timestamp: c.clock.Now(),
 		}}
 	}
 
@@ -216,12 +1437,23 @@ func (c *Client) initHistory() {
 
 func (c *Client) addQuery(query string) {
 	message := types.Message{
-		Role:    UserRole,
-		Content: query,
+		Role:      UserRole,
+		Content:   query,
+		Timestamp: c.clock.Now(),
 	}
 
 	c.History = append(c.History, message)
+	c.unsaved++
+
+	if c.Config.RedactQuery {
+		c.redactMessages(c.History[len(c.History)-1:], true)
+	}
 	c.truncateHistory()
+
+	// truncation never removes the system prompt
+	if c.unsaved > len(c.History)-1 {
+		c.unsaved = len(c.History) - 1
+	}
 }
 
 func (c *Client) getEndpoint(path string) string {
@@ -233,9 +1465,31 @@ func (c *Client) prepareQuery(input string) {
 	c.addQuery(input)
 }
 
+func (c *Client) queryIsolated(ctx context.Context, cfg types.Config, prompt string) BatchResult {
+	messages := []types.Message{{
+		Role:    SystemRole,
+		Content: cfg.Role,
+	}, {
+		Role:    UserRole,
+		Content: prompt,
+	}}
+
+	result := BatchResult{Prompt: prompt}
+
+	response, err := c.complete(ctx, cfg, messages)
+	result.Usage = response.Usage
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	result.Answer = response.Choices[0].Message.Content
+	return result
+}
+
 func (c *Client) processResponse(raw []byte, v interface{}) error {
 	if raw == nil {
-		return errors.New(ErrEmptyResponse)
+		return ErrEmptyResponse
 	}
 
 	if err := json.Unmarshal(raw, v); err != nil {
@@ -245,12 +1499,21 @@ func (c *Client) processResponse(raw []byte, v interface{}) error {
 	return nil
 }
 
+// truncateHistory drops the oldest messages after the system prompt until the history fits the
+// context window, see truncateMessages.
 func (c *Client) truncateHistory() {
-	tokens, rolling := countTokens(c.History)
-	effectiveTokenSize := calculateEffectiveContextWindow(c.Config.ContextWindow, MaxTokenBufferPercentage)
+	c.History = truncateMessages(c.History, c.Config.ContextWindow)
+}
+
+// truncateMessages drops the oldest messages after the system prompt until the messages fit the
+// context window. Pinned messages are never dropped, their tokens are reserved and the messages
+// around them are dropped instead. They move up to right after the system prompt, in their order.
+func truncateMessages(messages []types.Message, window int) []types.Message {
+	tokens, rolling := countTokens(messages)
+	effectiveTokenSize := calculateEffectiveContextWindow(window, MaxTokenBufferPercentage)
 
 	if tokens <= effectiveTokenSize {
-		return
+		return messages
 	}
 
 	var index int
@@ -258,6 +1521,10 @@ func (c *Client) truncateHistory() {
 	diff := tokens - effectiveTokenSize
 
 	for i := 1; i < len(rolling); i++ {
+		if messages[i].Pinned {
+			continue
+		}
+
 		total += rolling[i]
 		if total > diff {
 			index = i
@@ -265,18 +1532,90 @@ func (c *Client) truncateHistory() {
 		}
 	}
 
-	c.History = append(c.History[:1], c.History[index+1:]...)
+	if index == 0 {
+		return messages
+	}
+
+	result := messages[:1:1]
+	for _, message := range messages[1 : index+1] {
+		if message.Pinned {
+			result = append(result, message)
+		}
+	}
+
+	return append(result, messages[index+1:]...)
 }
 
 func (c *Client) updateHistory(response string) {
 	c.History = append(c.History, types.Message{
-		Role:    AssistantRole,
-		Content: response,
+		Role:      AssistantRole,
+		Content:   response,
+		Timestamp: c.clock.Now(),
+		Model:     c.Config.Model,
 	})
+	c.unsaved++
 
-	if !c.Config.OmitHistory {
-		_ = c.historyStore.Write(c.History)
+	c.writeHistory()
+}
+
+// writeHistory stores the unsaved messages. The thread is re-read while the store holds its lock:
+// when it is still what the client last saw, or empty, the history is written as it is. When
+// another process added to the thread in the meantime only the unsaved messages are appended to
+// what is stored, so neither side loses its exchanges, and the client continues with the merged
+// conversation.
+func (c *Client) writeHistory() {
+	if c.Config.OmitHistory {
+		return
 	}
+
+	unsaved := c.History[len(c.History)-c.unsaved:]
+	c.redactMessages(unsaved, false)
+
+	var written []types.Message
+	err := c.historyStore.Update(func(stored []types.Message) []types.Message {
+		if len(stored) == 0 || equalMessages(stored, c.synced) {
+			written = c.History
+		} else {
+			written = append(stored[:len(stored):len(stored)], unsaved...)
+		}
+		return written
+	})
+	if err != nil {
+		c.historyError(err)
+		return
+	}
+
+	c.History = written
+	c.synced = append([]types.Message(nil), written...)
+	c.unsaved = 0
+
+	c.notifyObservers(c.Config.Thread, unsaved)
+}
+
+func (c *Client) historyError(err error) {
+	if c.reportHistoryError != nil {
+		c.reportHistoryError(err)
+	}
+}
+
+// equalMessages compares two conversations, with the timestamps compared as instants since they
+// lose their monotonic clock reading and possibly their location in the store.
+func equalMessages(a, b []types.Message) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i].Role != b[i].Role || a[i].Content != b[i].Content || a[i].Pinned != b[i].Pinned || !a[i].Timestamp.Equal(b[i].Timestamp) {
+			return false
+		}
+
+		if !reflect.DeepEqual(a[i].Alternatives, b[i].Alternatives) && len(a[i].Alternatives)+len(b[i].Alternatives) > 0 {
+			return false
+		}
+	}
+
+	return true
 }
 
 func calculateEffectiveContextWindow(window int, bufferPercentage int) int {
@@ -285,29 +1624,63 @@ func calculateEffectiveContextWindow(window int, bufferPercentage int) int {
 	return effectiveContextWindow
 }
 
+func estimateTokens(messages []types.Message) int {
+	tokens, _ := countTokens(messages)
+	return tokens
+}
+
+// countTokens returns the total and the per message token counts. The counts the history recorded
+// are used as they are, only messages without a count of the current encoding are counted.
 func countTokens(messages []types.Message) (int, []int) {
 	var result int
 	var rolling []int
 
 	for _, message := range messages {
-		charCount, wordCount := 0, 0
-		words := strings.Fields(message.Content)
-		wordCount += len(words)
-
-		for _, word := range words {
-			charCount += utf8.RuneCountInString(word)
+		tokens := message.Tokens
+		if message.TokenEncoding != utils.TokenEncoding {
+			tokens = utils.EstimateTokens(message.Content)
 		}
 
-		// This is a simple approximation; actual token count may differ.
-		// You can adjust this based on your language and the specific tokenizer used by the model.
-		tokenCountForMessage := (charCount + wordCount) / 2
-		result += tokenCountForMessage
-		rolling = append(rolling, tokenCountForMessage)
+		result += tokens
+		rolling = append(rolling, tokens)
 	}
 
 	return result, rolling
 }
 
+func lastRequestID(requests []types.RequestInfo) string {
+	if len(requests) == 0 {
+		return ""
+	}
+
+	return requests[len(requests)-1].ID
+}
+
+func addUsage(a, b types.Usage) types.Usage {
+	return types.Usage{
+		PromptTokens:     a.PromptTokens + b.PromptTokens,
+		CompletionTokens: a.CompletionTokens + b.CompletionTokens,
+		TotalTokens:      a.TotalTokens + b.TotalTokens,
+		PromptTokensDetails: types.PromptTokensDetails{
+			CachedTokens: a.PromptTokensDetails.CachedTokens + b.PromptTokensDetails.CachedTokens,
+		},
+	}
+}
+
+// stitchContinuation appends a continuation to the partial answer that preceded it. Models often
+// restart the sentence they were cut off in, in which case the repeated fragment is dropped.
+func stitchContinuation(partial, continuation string) string {
+	boundary := strings.LastIndexAny(partial, ".!?\n") + 1
+	fragment := partial[boundary:]
+
+	if tail := strings.TrimSpace(fragment); tail != "" && strings.HasPrefix(strings.TrimSpace(continuation), tail) {
+		leading := fragment[:len(fragment)-len(strings.TrimLeft(fragment, " \t"))]
+		return partial[:boundary] + leading + strings.TrimLeft(continuation, " \t")
+	}
+
+	return partial + continuation
+}
+
 func createMessagesFromString(input string) []types.Message {
 	words := strings.Fields(input)
 	var messages []types.Message
@@ -330,24 +1703,35 @@ func createMessagesFromString(input string) []types.Message {
 	return messages
 }
 
-func (c *Client) printRequestDebugInfo(endpoint string, body []byte) {
-	fmt.Printf("\nGenerated cURL command:\n\n")
+// printRequestDebugInfo prints the request as a cURL command to stderr, so the answer alone is
+// printed to stdout. The API key is the variable it is read from.
+func (c *Client) printRequestDebugInfo(cfg types.Config, endpoint string, body []byte) {
+	out := os.Stderr
+
+	_, _ = fmt.Fprintf(out, "\nGenerated cURL command:\n\n")
 	method := "POST"
 	if body == nil {
 		method = "GET"
 	}
-	fmt.Printf("curl --location --insecure --request %s '%s' \\\n", method, endpoint)
-	fmt.Printf("  --header \"Authorization: Bearer ${%s_API_KEY}\" \\\n", strings.ToUpper(c.Config.Name))
-	fmt.Printf("  --header 'Content-Type: application/json'")
+	_, _ = fmt.Fprintf(out, "curl --location --insecure --request %s '%s' \\\n", method, endpoint)
+	_, _ = fmt.Fprintf(out, "  --header \"Authorization: Bearer ${%s_API_KEY}\" \\\n", strings.ToUpper(cfg.Name))
+	if cfg.Organization != "" {
+		_, _ = fmt.Fprintf(out, "  --header 'OpenAI-Organization: %s' \\\n", cfg.Organization)
+	}
+	// keys that aren't scoped to a project ignore it, it is sent all the same
+	if cfg.Project != "" {
+		_, _ = fmt.Fprintf(out, "  --header 'OpenAI-Project: %s' \\\n", cfg.Project)
+	}
+	_, _ = fmt.Fprintf(out, "  --header 'Content-Type: application/json'")
 
 	if body != nil {
 		bodyString := strings.ReplaceAll(string(body), "'", "'\"'\"'") // Escape single quotes
-		fmt.Printf(" \\\n  --data-raw '%s'", bodyString)
+		_, _ = fmt.Fprintf(out, " \\\n  --data-raw '%s'", bodyString)
 	}
-	fmt.Println() // Print a newline at the end
+	_, _ = fmt.Fprintln(out) // Print a newline at the end
 }
 
 func (c *Client) printResponseDebugInfo(raw []byte) {
-	fmt.Printf("\nResponse\n\n")
-	fmt.Printf("%s\n\n", raw)
+	_, _ = fmt.Fprintf(os.Stderr, "\nResponse\n\n")
+	_, _ = fmt.Fprintf(os.Stderr, "%s\n\n", raw)
 }
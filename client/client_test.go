@@ -1,17 +1,31 @@
 package client_test
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/golang/mock/gomock"
 	_ "github.com/golang/mock/mockgen/model"
 	"github.com/kardolus/chatgpt-cli/client"
+	"github.com/kardolus/chatgpt-cli/clock"
+	"github.com/kardolus/chatgpt-cli/history"
 	"github.com/kardolus/chatgpt-cli/http"
+	"github.com/kardolus/chatgpt-cli/metrics"
+	"github.com/kardolus/chatgpt-cli/personas"
 	"github.com/kardolus/chatgpt-cli/types"
 	"github.com/kardolus/chatgpt-cli/utils"
+	"io"
+	"io/fs"
+	nethttp "net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	. "github.com/onsi/gomega"
 	"github.com/sclevine/spec"
@@ -72,6 +86,24 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 			Expect(capturedThread).To(HavePrefix(client.InteractiveThreadPrefix)) // Assuming `InteractiveThreadPrefix` is "int_"
 			Expect(len(capturedThread)).To(Equal(8))                              // "int_" (4 chars) + 4 random characters
 		})
+		it("clears and deletes the generated thread rather than the configured one in interactive mode", func() {
+			var capturedThread string
+			mockHistoryStore.EXPECT().SetThread(gomock.Any()).DoAndReturn(func(thread string) {
+				capturedThread = thread
+			}).Times(1)
+
+			subject := client.New(mockCallerFactory, mockHistoryStore, MockConfig(), interactiveMode)
+			Expect(subject.Config.Thread).To(Equal(capturedThread))
+
+			mockHistoryStore.EXPECT().Clear(capturedThread).Return(nil)
+			Expect(subject.ClearHistory()).To(Succeed())
+
+			mockHistoryStore.EXPECT().DeleteThread(capturedThread).Return(nil)
+			mockHistoryStore.EXPECT().GetThread().Return("default")
+			Expect(subject.DeleteThread(capturedThread)).To(Succeed())
+			Expect(subject.Config.Thread).To(Equal("default"))
+			Expect(subject.History).To(BeEmpty())
+		})
 		it("should not overwrite the thread in interactive mode when AutoCreateNewThread is false", func() {
 			var capturedThread string
 			mockHistoryStore.EXPECT().SetThread(gomock.Any()).DoAndReturn(func(thread string) {
@@ -109,6 +141,7 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 			setupPostReturn func() ([]byte, error)
 			postError       error
 			expectedError   string
+			expectedKind    error
 		}
 
 		tests := []TestCase{
@@ -118,11 +151,19 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 				postError:       errors.New("error message"),
 				expectedError:   "error message",
 			},
+			{
+				description:     "passes the kind of an error of the API on",
+				setupPostReturn: func() ([]byte, error) { return nil, nil },
+				postError:       &http.APIError{StatusCode: 401, Code: "invalid_api_key", Message: "Incorrect API key provided"},
+				expectedError:   "invalid_api_key: Incorrect API key provided",
+				expectedKind:    http.ErrUnauthorized,
+			},
 			{
 				description:     "throws an error when the response is empty",
 				setupPostReturn: func() ([]byte, error) { return nil, nil },
 				postError:       nil,
 				expectedError:   "empty response",
+				expectedKind:    client.ErrEmptyResponse,
 			},
 			{
 				description: "throws an error when the response is a malformed json",
@@ -149,6 +190,20 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 				},
 				postError:     nil,
 				expectedError: "no responses returned",
+				expectedKind:  client.ErrNoResponses,
+			},
+			{
+				description: "throws an error when the content filter stopped the answer",
+				setupPostReturn: func() ([]byte, error) {
+					response := &types.CompletionsResponse{
+						Choices: []types.Choice{{FinishReason: http.FinishReasonContentFilter}},
+					}
+
+					return json.Marshal(response)
+				},
+				postError:     nil,
+				expectedError: "the content was filtered by the API",
+				expectedKind:  http.ErrContentFiltered,
 			},
 		}
 
@@ -168,6 +223,9 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 				_, _, err = subject.Query(query)
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(ContainSubstring(tt.expectedError))
+				if tt.expectedKind != nil {
+					Expect(errors.Is(err, tt.expectedKind)).To(BeTrue())
+				}
 			})
 		}
 
@@ -208,9 +266,10 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 				Expect(err).NotTo(HaveOccurred())
 
 				if !omitHistory {
-					mockHistoryStore.EXPECT().Write(append(request.Messages, types.Message{
+					expectUpdate(append(request.Messages, types.Message{
 						Role:    client.AssistantRole,
 						Content: answer,
+						Model:   config.Model,
 					}))
 				}
 
@@ -252,9 +311,9 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 				subject := client.New(mockCallerFactory, mockHistoryStore, config, commandLineMode)
 				Expect(err).NotTo(HaveOccurred())
 
-				// Read and Write are never called on the history store
+				// the history store is never read or updated
 				mockHistoryStore.EXPECT().Read().Times(0)
-				mockHistoryStore.EXPECT().Write(gomock.Any()).Times(0)
+				mockHistoryStore.EXPECT().Update(gomock.Any()).Times(0)
 
 				messages = createMessages(nil, query)
 
@@ -308,219 +367,2882 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 
 				testValidHTTPResponse(subject, history, body, false)
 			})
+			it("keeps the pinned messages when truncating the history", func() {
+				history := []types.Message{
+					{Role: client.SystemRole, Content: config.Role},
+					{Role: client.UserRole, Content: "we are on Go 1.21", Pinned: true},
+					{Role: client.AssistantRole, Content: "answer 1"},
+					{Role: client.UserRole, Content: "question 2"},
+					{Role: client.AssistantRole, Content: "answer 2"},
+					{Role: client.UserRole, Content: "question 3"},
+					{Role: client.AssistantRole, Content: "answer 3", Pinned: true},
+					{Role: client.UserRole, Content: "question 4"},
+					{Role: client.AssistantRole, Content: "answer 4"},
+				}
+
+				factory.withHistory(history)
+				subject := factory.buildClientWithoutConfig()
+
+				var request types.CompletionsRequest
+				mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).DoAndReturn(func(_ string, body []byte, _ bool) ([]byte, error) {
+					Expect(json.Unmarshal(body, &request)).To(Succeed())
+					return createRawResponse("content"), nil
+				})
+				expectUpdate(gomock.Any())
+
+				_, _, err := subject.Query(query)
+				Expect(err).NotTo(HaveOccurred())
+
+				var contents []string
+				for _, message := range request.Messages {
+					contents = append(contents, message.Content)
+				}
+
+				// the oldest messages are dropped around the pinned ones, which follow the system prompt
+				Expect(contents[:3]).To(Equal([]string{config.Role, "we are on Go 1.21", "answer 3"}))
+				Expect(contents).NotTo(ContainElement("answer 1"))
+				Expect(contents[len(contents)-1]).To(Equal(query))
+				Expect(utils.EstimateTokens(strings.Join(contents, " "))).To(BeNumerically("<=", config.ContextWindow))
+			})
 		})
 	})
-	when("Stream()", func() {
-		var (
-			body     []byte
-			messages []types.Message
-			err      error
-		)
+	when("messages are added to the history", func() {
+		it("timestamps the new messages and keeps the times of the stored ones", func() {
+			now := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
 
-		it("throws an error when the http callout fails", func() {
-			factory.withoutHistory()
-			subject := factory.buildClientWithoutConfig()
+			subject := factory.buildClientWithoutConfig().WithClock(clock.NewFake(now))
 
-			messages = createMessages(nil, query)
-			body, err = createBody(messages, true)
+			stored := []types.Message{
+				{Role: client.SystemRole, Content: config.Role},
+				{Role: client.UserRole, Content: "earlier question"},
+			}
+			factory.withHistory(stored)
+
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).Return(createRawResponse("answer"), nil)
+			mockHistoryStore.EXPECT().Update(gomock.Any()).DoAndReturn(func(update func([]types.Message) []types.Message) error {
+				written := update(stored)
+				Expect(written).To(HaveLen(4))
+				Expect(written[1].Timestamp).To(BeZero())
+				Expect(written[2].Timestamp).To(Equal(now))
+				Expect(written[3].Timestamp).To(Equal(now))
+				return nil
+			})
+
+			_, _, err := subject.Query("question")
 			Expect(err).NotTo(HaveOccurred())
+		})
+		it("reports the history that could not be written without failing the query", func() {
+			var reported []error
+			subject := factory.buildClientWithoutConfig().WithHistoryErrorReporter(func(err error) {
+				reported = append(reported, err)
+			})
+			factory.withoutHistory()
 
-			errorMsg := "error message"
-			mockCaller.EXPECT().Post(subject.Config.URL+subject.Config.CompletionsPath, body, true).Return(nil, errors.New(errorMsg))
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).Return(createRawResponse("answer"), nil)
+			mockHistoryStore.EXPECT().Update(gomock.Any()).Return(history.ErrRemoteConflict)
 
-			err := subject.Stream(query)
-			Expect(err).To(HaveOccurred())
-			Expect(err.Error()).To(Equal(errorMsg))
+			result, _, err := subject.Query("question")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal("answer"))
+			Expect(reported).To(ConsistOf(history.ErrRemoteConflict))
 		})
-		when("a valid http response is received", func() {
-			const answer = "answer"
-
-			testValidHTTPResponse := func(subject *client.Client, history []types.Message, expectedBody []byte) {
-				messages = createMessages(nil, query)
-				body, err = createBody(messages, true)
-				Expect(err).NotTo(HaveOccurred())
+		it("truncates by the token counts the history recorded with the current encoding", func() {
+			subject := factory.buildClientWithoutConfig()
 
-				mockCaller.EXPECT().Post(subject.Config.URL+subject.Config.CompletionsPath, expectedBody, true).Return([]byte(answer), nil)
+			stored := []types.Message{
+				{Role: client.SystemRole, Content: config.Role},
+				{Role: client.UserRole, Content: "short", Tokens: 1000, TokenEncoding: utils.TokenEncoding},
+				{Role: client.AssistantRole, Content: "short", Tokens: 1000, TokenEncoding: "another-encoding"},
+			}
+			factory.withHistory(stored)
+
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).Return(createRawResponse("answer"), nil)
+			expectUpdate([]types.Message{
+				stored[0],
+				stored[2],
+				{Role: client.UserRole, Content: "question"},
+				{Role: client.AssistantRole, Content: "answer", Model: config.Model},
+			})
 
-				messages = createMessages(history, query)
+			_, _, err := subject.Query("question")
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+	when("QueryWithResponse()", func() {
+		it("returns the fully decoded response and updates the history", func() {
+			factory.withoutHistory()
+			subject := factory.buildClientWithoutConfig()
 
-				mockHistoryStore.EXPECT().Write(append(messages, types.Message{
-					Role:    client.AssistantRole,
-					Content: answer,
-				}))
+			messages := createMessages(nil, query)
+			body, err := createBody(messages, false)
+			Expect(err).NotTo(HaveOccurred())
 
-				err := subject.Stream(query)
-				Expect(err).NotTo(HaveOccurred())
+			response := types.CompletionsResponse{
+				ID:                "id",
+				Model:             "served-model",
+				SystemFingerprint: "fp_123",
+				Choices: []types.Choice{{
+					Message:      types.Message{Role: client.AssistantRole, Content: "answer"},
+					FinishReason: "stop",
+				}},
+				Usage: types.Usage{PromptTokens: 1, CompletionTokens: 2, TotalTokens: 3},
 			}
+			respBytes, err := json.Marshal(response)
+			Expect(err).NotTo(HaveOccurred())
 
-			it("returns the expected result for an empty history", func() {
-				factory.withHistory(nil)
-				subject := factory.buildClientWithoutConfig()
+			mockCaller.EXPECT().Post(subject.Config.URL+subject.Config.CompletionsPath, body, false).Return(respBytes, nil)
+			expectUpdate(append(messages, types.Message{
+				Role:    client.AssistantRole,
+				Content: "answer",
+				Model:   config.Model,
+			}))
 
-				messages = createMessages(nil, query)
-				body, err = createBody(messages, true)
-				Expect(err).NotTo(HaveOccurred())
+			result, err := subject.QueryWithResponse(query)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal(response))
+			Expect(subject.Usage()).To(Equal(response.Usage))
+		})
+	})
+	when("Regenerate()", func() {
+		history := []types.Message{
+			{Role: client.SystemRole, Content: config.Role},
+			{Role: client.UserRole, Content: "question 1"},
+			{Role: client.AssistantRole, Content: "answer 1"},
+		}
 
-				testValidHTTPResponse(subject, nil, body)
+		respondWith := func(answer string) []byte {
+			respBytes, err := json.Marshal(types.CompletionsResponse{
+				Choices: []types.Choice{{Message: types.Message{Role: client.AssistantRole, Content: answer}}},
+				Usage:   types.Usage{TotalTokens: 7},
 			})
-			it("returns the expected result for a non-empty history", func() {
-				history := []types.Message{
-					{
-						Role:    client.SystemRole,
-						Content: config.Role,
-					},
-					{
-						Role:    client.UserRole,
-						Content: "question 1",
-					},
-					{
-						Role:    client.AssistantRole,
-						Content: "answer 1",
-					},
-				}
-				factory.withHistory(history)
-				subject := factory.buildClientWithoutConfig()
+			Expect(err).NotTo(HaveOccurred())
+			return respBytes
+		}
 
-				messages = createMessages(history, query)
-				body, err = createBody(messages, true)
-				Expect(err).NotTo(HaveOccurred())
+		it("throws an error when there is nothing to regenerate", func() {
+			factory.withoutHistory()
+			subject := factory.buildClientWithoutConfig()
 
-				testValidHTTPResponse(subject, history, body)
+			_, _, err := subject.Regenerate(client.RegenerateOptions{})
+			Expect(err).To(MatchError(client.ErrNothingToRegenerate))
+		})
+		it("replaces the last answer", func() {
+			factory.withHistory(history)
+			subject := factory.buildClientWithoutConfig()
+
+			body, err := createBody(history[:2], false)
+			Expect(err).NotTo(HaveOccurred())
+
+			mockCaller.EXPECT().Post(subject.Config.URL+subject.Config.CompletionsPath, body, false).Return(respondWith("answer 2"), nil)
+			expectUpdate([]types.Message{
+				history[0],
+				history[1],
+				{Role: client.AssistantRole, Content: "answer 2", Model: config.Model},
 			})
+
+			result, usage, err := subject.Regenerate(client.RegenerateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal("answer 2"))
+			Expect(usage).To(Equal(7))
 		})
-	})
-	when("ListModels()", func() {
-		it("throws an error when the http callout fails", func() {
+		it("keeps the discarded answer as an alternative and uses the temperature override", func() {
+			factory.withHistory(history)
 			subject := factory.buildClientWithoutConfig()
 
-			errorMsg := "error message"
-			mockCaller.EXPECT().Get(subject.Config.URL+subject.Config.ModelsPath).Return(nil, errors.New(errorMsg))
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).
+				DoAndReturn(func(_ string, body []byte, _ bool) ([]byte, error) {
+					var request types.CompletionsRequest
+					Expect(json.Unmarshal(body, &request)).To(Succeed())
+					Expect(request.Temperature).To(Equal(1.5))
+					return respondWith("answer 2"), nil
+				})
+			expectUpdate([]types.Message{
+				history[0],
+				history[1],
+				{Role: client.AssistantRole, Content: "answer 2", Alternatives: []string{"answer 1"}, Model: config.Model},
+			})
 
-			_, err := subject.ListModels()
-			Expect(err).To(HaveOccurred())
-			Expect(err.Error()).To(Equal(errorMsg))
+			temperature := 1.5
+			_, _, err := subject.Regenerate(client.RegenerateOptions{Temperature: &temperature, KeepAlternative: true})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(subject.Config.Temperature).To(Equal(config.Temperature))
 		})
-		it("throws an error when the response is empty", func() {
+		it("uses a temperature override of 0", func() {
+			factory.withHistory(history)
 			subject := factory.buildClientWithoutConfig()
 
-			mockCaller.EXPECT().Get(subject.Config.URL+subject.Config.ModelsPath).Return(nil, nil)
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).
+				DoAndReturn(func(_ string, body []byte, _ bool) ([]byte, error) {
+					var request types.CompletionsRequest
+					Expect(json.Unmarshal(body, &request)).To(Succeed())
+					Expect(request.Temperature).To(BeZero())
+					return respondWith("answer 2"), nil
+				})
+			expectUpdate(gomock.Any())
+
+			temperature := 0.0
+			_, _, err := subject.Regenerate(client.RegenerateOptions{Temperature: &temperature})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(subject.Config.Temperature).To(Equal(config.Temperature))
+		})
+		it("keeps the exchanges another process added in the meantime", func() {
+			factory.withHistory(history)
+			subject := factory.buildClientWithoutConfig()
 
-			_, err := subject.ListModels()
-			Expect(err).To(HaveOccurred())
-			Expect(err.Error()).To(Equal("empty response"))
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).Return(respondWith("answer 2"), nil)
+
+			added := []types.Message{
+				{Role: client.UserRole, Content: "question 2"},
+				{Role: client.AssistantRole, Content: "answer of the other process"},
+			}
+			regenerated := types.Message{Role: client.AssistantRole, Content: "answer 2", Model: config.Model}
+			mockHistoryStore.EXPECT().Update(gomock.Any()).DoAndReturn(func(update func([]types.Message) []types.Message) error {
+				Expect(update(append(history[:3:3], added...))).To(Equal(append([]types.Message{history[0], history[1], regenerated}, added...)))
+				return nil
+			})
+
+			_, _, err := subject.Regenerate(client.RegenerateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(subject.History).To(Equal(append([]types.Message{history[0], history[1], regenerated}, added...)))
 		})
-		it("throws an error when the response is a malformed json", func() {
+		it("asks the question again at the end of a thread that was rewound in the meantime", func() {
+			factory.withHistory(history)
 			subject := factory.buildClientWithoutConfig()
 
-			malformed := `{"invalid":"json"` // missing closing brace
-			mockCaller.EXPECT().Get(subject.Config.URL+subject.Config.ModelsPath).Return([]byte(malformed), nil)
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).Return(respondWith("answer 2"), nil)
 
-			_, err := subject.ListModels()
-			Expect(err).To(HaveOccurred())
-			Expect(err.Error()).Should(HavePrefix("failed to decode response:"))
+			rewound := []types.Message{history[0], {Role: client.UserRole, Content: "another question"}, {Role: client.AssistantRole, Content: "another answer"}}
+			regenerated := types.Message{Role: client.AssistantRole, Content: "answer 2", Model: config.Model}
+			mockHistoryStore.EXPECT().Update(gomock.Any()).DoAndReturn(func(update func([]types.Message) []types.Message) error {
+				Expect(update(rewound)).To(Equal(append(rewound[:3:3], history[1], regenerated)))
+				return nil
+			})
+
+			_, _, err := subject.Regenerate(client.RegenerateOptions{})
+			Expect(err).NotTo(HaveOccurred())
 		})
-		it("filters gpt models as expected", func() {
+		it("queries again when the history ends with a user message", func() {
+			factory.withHistory(history[:2])
 			subject := factory.buildClientWithoutConfig()
 
-			response, err := utils.FileToBytes("models.json")
+			body, err := createBody(history[:2], false)
 			Expect(err).NotTo(HaveOccurred())
 
-			mockCaller.EXPECT().Get(subject.Config.URL+subject.Config.ModelsPath).Return(response, nil)
+			mockCaller.EXPECT().Post(subject.Config.URL+subject.Config.CompletionsPath, body, false).Return(respondWith("answer 2"), nil)
+			expectUpdate(gomock.Any())
 
-			result, err := subject.ListModels()
+			result, _, err := subject.Regenerate(client.RegenerateOptions{})
 			Expect(err).NotTo(HaveOccurred())
-			Expect(result).NotTo(BeEmpty())
-			Expect(result).To(HaveLen(3))
-			Expect(result[0]).To(Equal("* gpt-3.5-turbo (current)"))
-			Expect(result[1]).To(Equal("- gpt-3.5-turbo-0301"))
+			Expect(result).To(Equal("answer 2"))
 		})
 	})
-	when("ProvideContext()", func() {
-		it("updates the history with the provided context", func() {
+	when("Rewind()", func() {
+		history := []types.Message{
+			{Role: client.SystemRole, Content: config.Role},
+			{Role: client.UserRole, Content: "context"},
+			{Role: client.UserRole, Content: "question 1"},
+			{Role: client.AssistantRole, Content: "answer 1"},
+			{Role: client.UserRole, Content: "question 2"},
+			{Role: client.AssistantRole, Content: "answer 2"},
+		}
+
+		it("removes the last exchange and returns it", func() {
+			factory.withHistory(history)
 			subject := factory.buildClientWithoutConfig()
 
-			context := "This is a story about a dog named Kya. Kya loves to play fetch and swim in the lake."
-			mockHistoryStore.EXPECT().Read().Return(nil, nil).Times(1)
+			expectUpdate(history[:4])
 
-			subject.ProvideContext(context)
+			removed, err := subject.Rewind(1)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(removed).To(Equal(history[4:]))
+			Expect(subject.History).To(Equal(history[:4]))
+		})
+		it("treats consecutive user messages as a single exchange", func() {
+			factory.withHistory(history)
+			subject := factory.buildClientWithoutConfig()
 
-			Expect(len(subject.History)).To(Equal(2)) // The system message and the provided context
+			expectUpdate(history[:1])
 
-			systemMessage := subject.History[0]
-			Expect(systemMessage.Role).To(Equal(client.SystemRole))
-			Expect(systemMessage.Content).To(Equal(config.Role))
+			removed, err := subject.Rewind(2)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(removed).To(Equal(history[1:]))
+		})
+		it("never removes the system message", func() {
+			factory.withHistory(history)
+			subject := factory.buildClientWithoutConfig()
 
-			contextMessage := subject.History[1]
-			Expect(contextMessage.Role).To(Equal(client.UserRole))
-			Expect(contextMessage.Content).To(Equal(context))
+			expectUpdate(history[:1])
+
+			removed, err := subject.Rewind(10)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(removed).To(HaveLen(5))
+			Expect(subject.History).To(Equal(history[:1]))
 		})
-	})
-}
+		it("does not count the system prompts of persona switches as exchanges", func() {
+			switched := []types.Message{
+				{Role: client.SystemRole, Content: config.Role},
+				{Role: client.UserRole, Content: "question 1"},
+				{Role: client.AssistantRole, Content: "answer 1"},
+				{Role: client.SystemRole, Content: "persona"},
+				{Role: client.UserRole, Content: "question 2"},
+				{Role: client.AssistantRole, Content: "answer 2"},
+				{Role: client.SystemRole, Content: "another persona"},
+			}
+			factory.withHistory(switched)
+			subject := factory.buildClientWithoutConfig()
 
-func createBody(messages []types.Message, stream bool) ([]byte, error) {
-	req := types.CompletionsRequest{
-		Model:            config.Model,
-		Messages:         messages,
-		Stream:           stream,
-		Temperature:      config.Temperature,
-		TopP:             config.TopP,
-		FrequencyPenalty: config.FrequencyPenalty,
-		MaxTokens:        config.MaxTokens,
-		PresencePenalty:  config.PresencePenalty,
-	}
+			expectUpdate([]types.Message{switched[0], switched[6]})
 
-	return json.Marshal(req)
-}
+			removed, err := subject.Rewind(2)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(removed).To(Equal(switched[1:6]))
+			Expect(subject.History).To(Equal([]types.Message{switched[0], switched[6]}))
+		})
+		it("keeps the system prompt of a persona switch that precedes the remaining exchanges", func() {
+			switched := []types.Message{
+				{Role: client.SystemRole, Content: config.Role},
+				{Role: client.UserRole, Content: "question 1"},
+				{Role: client.AssistantRole, Content: "answer 1"},
+				{Role: client.SystemRole, Content: "persona"},
+				{Role: client.UserRole, Content: "question 2"},
+				{Role: client.AssistantRole, Content: "answer 2"},
+			}
+			factory.withHistory(switched)
+			subject := factory.buildClientWithoutConfig()
 
-func createMessages(history []types.Message, query string) []types.Message {
-	var messages []types.Message
+			expectUpdate(switched[:4])
 
-	if len(history) == 0 {
-		messages = append(messages, types.Message{
-			Role:    client.SystemRole,
-			Content: config.Role,
+			removed, err := subject.Rewind(1)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(removed).To(Equal(switched[4:]))
 		})
-	} else {
-		messages = history
-	}
+		it("keeps the exchange another process added in the meantime", func() {
+			factory.withHistory(history[:4])
+			subject := factory.buildClientWithoutConfig()
 
-	messages = append(messages, types.Message{
-		Role:    client.UserRole,
-		Content: query,
-	})
+			mockHistoryStore.EXPECT().Update(gomock.Any()).DoAndReturn(func(update func([]types.Message) []types.Message) error {
+				Expect(update(history)).To(Equal(history[:4]))
+				return nil
+			})
 
-	return messages
-}
+			removed, err := subject.Rewind(1)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(removed).To(Equal(history[4:]))
+			Expect(subject.History).To(Equal(history[:4]))
+		})
+		it("does nothing when there are no exchanges", func() {
+			factory.withoutHistory()
+			subject := factory.buildClientWithoutConfig()
 
-type clientFactory struct {
-	mockHistoryStore *MockHistoryStore
-}
+			mockHistoryStore.EXPECT().Update(gomock.Any()).Times(0)
 
-func newClientFactory(mhs *MockHistoryStore) *clientFactory {
-	return &clientFactory{
-		mockHistoryStore: mhs,
-	}
-}
+			removed, err := subject.Rewind(1)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(removed).To(BeEmpty())
+		})
+		it("throws an error when the history cannot be written", func() {
+			factory.withHistory(history)
+			subject := factory.buildClientWithoutConfig()
 
-func (f *clientFactory) buildClientWithoutConfig() *client.Client {
-	f.mockHistoryStore.EXPECT().SetThread(config.Thread).Times(1)
+			mockHistoryStore.EXPECT().Update(gomock.Any()).Return(errors.New("error message"))
 
-	c := client.New(mockCallerFactory, f.mockHistoryStore, MockConfig(), commandLineMode)
+			_, err := subject.Rewind(1)
+			Expect(err).To(MatchError("error message"))
+			Expect(subject.History).To(Equal(history))
+		})
+	})
+	when("Fork()", func() {
+		const fork = "fork"
+
+		history := []types.Message{
+			{Role: client.SystemRole, Content: config.Role},
+			{Role: client.UserRole, Content: "question 1"},
+			{Role: client.AssistantRole, Content: "answer 1"},
+			{Role: client.UserRole, Content: "question 2"},
+			{Role: client.AssistantRole, Content: "answer 2"},
+		}
 
-	return c.WithContextWindow(config.ContextWindow)
-}
+		it("copies the prefix into a new thread and switches to it", func() {
+			factory.withHistory(history)
+			subject := factory.buildClientWithoutConfig()
 
-func (f *clientFactory) withoutHistory() {
-	f.mockHistoryStore.EXPECT().Read().Return(nil, nil).Times(1)
-}
+			gomock.InOrder(
+				mockHistoryStore.EXPECT().GetThread().Return(config.Thread),
+				mockHistoryStore.EXPECT().ReadThread(fork).Return(nil, nil),
+				mockHistoryStore.EXPECT().SetThread(fork),
+				expectUpdate(history[:3]),
+			)
 
-func (f *clientFactory) withHistory(history []types.Message) {
-	f.mockHistoryStore.EXPECT().Read().Return(history, nil).Times(1)
-}
+			Expect(subject.Fork(fork, 3)).To(Succeed())
+			Expect(subject.History).To(Equal(history[:3]))
+			Expect(subject.Config.Thread).To(Equal(fork))
+		})
+		it("refuses to fork into a thread that cannot be read", func() {
+			factory.withHistory(history)
+			subject := factory.buildClientWithoutConfig()
 
-func mockCallerFactory(_ types.Config) http.Caller {
-	return mockCaller
-}
+			mockHistoryStore.EXPECT().GetThread().Return(config.Thread)
+			mockHistoryStore.EXPECT().ReadThread(fork).Return(nil, errors.New("invalid character"))
+
+			Expect(subject.Fork(fork, 3)).To(MatchError("invalid character"))
+			Expect(subject.Config.Thread).To(Equal(config.Thread))
+		})
+		it("rejects an index that splits an exchange", func() {
+			factory.withHistory(history)
+			subject := factory.buildClientWithoutConfig()
+
+			err := subject.Fork(fork, 2)
+			Expect(err).To(MatchError(ContainSubstring("splits an exchange")))
+		})
+		it("forks next to the system prompt of a persona switch", func() {
+			switched := []types.Message{
+				{Role: client.SystemRole, Content: config.Role},
+				{Role: client.UserRole, Content: "question 1"},
+				{Role: client.AssistantRole, Content: "answer 1"},
+				{Role: client.SystemRole, Content: "persona"},
+				{Role: client.UserRole, Content: "question 2"},
+				{Role: client.AssistantRole, Content: "answer 2"},
+			}
+			factory.withHistory(switched)
+			subject := factory.buildClientWithoutConfig()
+
+			mockHistoryStore.EXPECT().GetThread().Return(config.Thread).Times(2)
+			mockHistoryStore.EXPECT().ReadThread(gomock.Any()).Return(nil, nil).Times(2)
+			mockHistoryStore.EXPECT().SetThread(gomock.Any()).Times(2)
+			expectUpdate(switched[:3])
+			expectUpdate(switched[:4])
+
+			Expect(subject.Fork(fork, 3)).To(Succeed())
+
+			subject.History = switched
+			Expect(subject.Fork("other", 4)).To(Succeed())
+
+			subject.History = switched
+			Expect(subject.Fork("third", 5)).To(MatchError(ContainSubstring("splits an exchange")))
+		})
+		it("rejects an index that is out of range", func() {
+			factory.withHistory(history)
+			subject := factory.buildClientWithoutConfig()
+
+			Expect(subject.Fork(fork, 0)).NotTo(Succeed())
+			Expect(subject.Fork(fork, len(history)+1)).NotTo(Succeed())
+		})
+		it("refuses to overwrite an existing thread", func() {
+			factory.withHistory(history)
+			subject := factory.buildClientWithoutConfig()
+
+			mockHistoryStore.EXPECT().GetThread().Return(config.Thread)
+			mockHistoryStore.EXPECT().ReadThread(fork).Return(history, nil)
+
+			err := subject.Fork(fork, len(history))
+			Expect(err).To(MatchError("thread fork already exists"))
+		})
+		it("refuses to overwrite a thread created in the meantime", func() {
+			factory.withHistory(history)
+			subject := factory.buildClientWithoutConfig()
+
+			gomock.InOrder(
+				mockHistoryStore.EXPECT().GetThread().Return(config.Thread),
+				mockHistoryStore.EXPECT().ReadThread(fork).Return(nil, nil),
+				mockHistoryStore.EXPECT().SetThread(fork),
+				mockHistoryStore.EXPECT().Update(gomock.Any()).DoAndReturn(func(update func([]types.Message) []types.Message) error {
+					Expect(update(history[:1])).To(Equal(history[:1]))
+					return nil
+				}),
+				mockHistoryStore.EXPECT().SetThread(config.Thread),
+			)
+
+			Expect(subject.Fork(fork, len(history))).To(MatchError("thread fork already exists"))
+			Expect(subject.Config.Thread).To(Equal(config.Thread))
+			Expect(subject.History).To(Equal(history))
+		})
+		it("switches back when the fork cannot be written", func() {
+			factory.withHistory(history)
+			subject := factory.buildClientWithoutConfig()
+
+			gomock.InOrder(
+				mockHistoryStore.EXPECT().GetThread().Return(config.Thread),
+				mockHistoryStore.EXPECT().ReadThread(fork).Return(nil, nil),
+				mockHistoryStore.EXPECT().SetThread(fork),
+				mockHistoryStore.EXPECT().Update(gomock.Any()).Return(errors.New("error message")),
+				mockHistoryStore.EXPECT().SetThread(config.Thread),
+			)
+
+			Expect(subject.Fork(fork, len(history))).To(MatchError("error message"))
+			Expect(subject.Config.Thread).To(Equal(config.Thread))
+		})
+	})
+	when("DryRun()", func() {
+		history := []types.Message{
+			{Role: client.SystemRole, Content: config.Role},
+			{Role: client.UserRole, Content: "question 1"},
+			{Role: client.AssistantRole, Content: "answer 1"},
+		}
+
+		it("returns the exact request that Query would send", func() {
+			mockHistoryStore.EXPECT().Read().Return(history, nil).Times(2)
+			subject := factory.buildClientWithoutConfig()
+
+			endpoint, body, err := subject.DryRun(query, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(endpoint).To(Equal(subject.Config.URL + subject.Config.CompletionsPath))
+			Expect(subject.History).To(BeEmpty())
+
+			expected, err := createBody(createMessages(history, query), false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(body).To(Equal(expected))
+
+			respBytes, err := json.Marshal(types.CompletionsResponse{
+				Choices: []types.Choice{{Message: types.Message{Role: client.AssistantRole, Content: "answer"}}},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			mockCaller.EXPECT().Post(endpoint, body, false).Return(respBytes, nil)
+			expectUpdate(gomock.Any())
+
+			_, _, err = subject.Query(query)
+			Expect(err).NotTo(HaveOccurred())
+		})
+		it("only sends the seed and the stop sequences when they are set", func() {
+			mockHistoryStore.EXPECT().Read().Return(history, nil).Times(2)
+			subject := factory.buildClientWithoutConfig()
+
+			_, body, err := subject.DryRun(query, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).NotTo(ContainSubstring(`"seed"`))
+			Expect(string(body)).NotTo(ContainSubstring(`"stop"`))
+
+			seed := 0
+			subject.Config.Seed = &seed
+			subject.Config.Stop = []string{"END", "\n\n"}
+
+			_, body, err = subject.DryRun(query, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			var request types.CompletionsRequest
+			Expect(json.Unmarshal(body, &request)).To(Succeed())
+			Expect(request.Seed).To(Equal(&seed))
+			Expect(request.Stop).To(Equal([]string{"END", "\n\n"}))
+		})
+		it("leaves the provided context untouched", func() {
+			subject := factory.buildClientWithoutConfig()
+			subject.Config.ContextWindow = 1
+
+			mockHistoryStore.EXPECT().Read().Return(history, nil).Times(1)
+			subject.ProvideContext("some context")
+			before := append([]types.Message(nil), subject.History...)
+
+			_, _, err := subject.DryRun(query, true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(subject.History).To(Equal(before))
+		})
+	})
+	when("ValidateSampling()", func() {
+		it("accepts the values the API does", func() {
+			cfg := MockConfig()
+			cfg.Temperature, cfg.TopP, cfg.FrequencyPenalty, cfg.PresencePenalty = 0, 1, -2, 2
+			cfg.Stop = []string{"a", "b", "c", "d"}
+
+			Expect(client.ValidateSampling(cfg)).To(Succeed())
+		})
+		it("names the flag of a value out of range", func() {
+			tests := []struct {
+				change func(*types.Config)
+				err    string
+			}{
+				{func(c *types.Config) { c.Temperature = 2.5 }, "invalid --temperature 2.5, use a value from 0 to 2"},
+				{func(c *types.Config) { c.TopP = -0.1 }, "invalid --top-p -0.1, use a value from 0 to 1"},
+				{func(c *types.Config) { c.FrequencyPenalty = 3 }, "invalid --frequency-penalty 3, use a value from -2 to 2"},
+				{func(c *types.Config) { c.PresencePenalty = -3 }, "invalid --presence-penalty -3, use a value from -2 to 2"},
+				{func(c *types.Config) { c.MaxTokens = 0 }, "invalid --max-tokens 0, use at least 1"},
+				{func(c *types.Config) { c.Stop = []string{"a", "b", "c", "d", "e"} }, "too many --stop sequences, the API accepts up to 4 of them"},
+				{func(c *types.Config) { c.Stop = []string{""} }, "invalid --stop sequence, it can't be empty"},
+			}
+
+			for _, test := range tests {
+				cfg := MockConfig()
+				test.change(&cfg)
+				Expect(client.ValidateSampling(cfg)).To(MatchError(test.err))
+			}
+		})
+	})
+	when("SetThread()", func() {
+		it("reads the history of the new thread on the next query", func() {
+			factory.withoutHistory()
+			subject := factory.buildClientWithoutConfig()
+
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).Return(createRawResponse("answer"), nil).Times(2)
+			expectUpdate(gomock.Any()).Times(2)
+
+			_, _, err := subject.Query(query)
+			Expect(err).NotTo(HaveOccurred())
+
+			mockHistoryStore.EXPECT().SetThread("other")
+			mockHistoryStore.EXPECT().Read().Return(nil, nil)
+
+			Expect(subject.SetThread("other")).To(Succeed())
+			Expect(subject.Config.Thread).To(Equal("other"))
+
+			_, _, err = subject.Query(query)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(subject.History).To(Equal([]types.Message{
+				{Role: client.SystemRole, Content: config.Role},
+				{Role: client.UserRole, Content: query},
+				{Role: client.AssistantRole, Content: "answer", Model: config.Model},
+			}))
+		})
+		it("rejects names that escape the history directory", func() {
+			subject := factory.buildClientWithoutConfig()
+
+			for _, name := range []string{"../outside", "nested/thread", ".hidden", ""} {
+				Expect(subject.SetThread(name)).NotTo(Succeed())
+			}
+			Expect(subject.Config.Thread).To(Equal(config.Thread))
+		})
+	})
+	when("StartSession()", func() {
+		now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+		it("continues the current thread by default", func() {
+			subject := factory.buildClientWithoutConfig()
+
+			started, err := subject.StartSession()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(started).To(BeFalse())
+			Expect(subject.Config.Thread).To(Equal(config.Thread))
+		})
+		it("starts a new thread for every query with the new policy", func() {
+			subject := factory.buildClientWithoutConfig().WithSessionPolicy(client.SessionPolicy{Mode: client.SessionNew})
+
+			mockHistoryStore.EXPECT().SetThread(gomock.Any())
+
+			started, err := subject.StartSession()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(started).To(BeTrue())
+			Expect(subject.Config.Thread).To(HavePrefix(client.CommandThreadPrefix))
+		})
+		it("continues a thread that was used within the window of the recent policy", func() {
+			subject := factory.buildClientWithoutConfig().
+				WithClock(clock.NewFake(now)).
+				WithSessionPolicy(client.SessionPolicy{Mode: client.SessionRecent, Window: time.Hour})
+
+			mockHistoryStore.EXPECT().ListThreads().Return([]history.ThreadInfo{
+				{Name: "other", Updated: now.Add(-2 * time.Hour)},
+				{Name: config.Thread, Updated: now.Add(-30 * time.Minute)},
+			}, nil)
+
+			started, err := subject.StartSession()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(started).To(BeFalse())
+			Expect(subject.Config.Thread).To(Equal(config.Thread))
+		})
+		it("starts a new thread when the thread wasn't used within the window of the recent policy", func() {
+			subject := factory.buildClientWithoutConfig().
+				WithClock(clock.NewFake(now)).
+				WithSessionPolicy(client.SessionPolicy{Mode: client.SessionRecent, Window: time.Hour})
+
+			mockHistoryStore.EXPECT().ListThreads().Return([]history.ThreadInfo{
+				{Name: config.Thread, Updated: now.Add(-2 * time.Hour)},
+			}, nil)
+			mockHistoryStore.EXPECT().SetThread(gomock.Any())
+
+			started, err := subject.StartSession()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(started).To(BeTrue())
+			Expect(subject.Config.Thread).NotTo(Equal(config.Thread))
+		})
+		it("continues a thread that wasn't written yet with the recent policy", func() {
+			subject := factory.buildClientWithoutConfig().
+				WithSessionPolicy(client.SessionPolicy{Mode: client.SessionRecent, Window: time.Hour})
+
+			mockHistoryStore.EXPECT().ListThreads().Return(nil, nil)
+
+			started, err := subject.StartSession()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(started).To(BeFalse())
+		})
+		it("rejects an unknown policy", func() {
+			subject := factory.buildClientWithoutConfig().WithSessionPolicy(client.SessionPolicy{Mode: "sometimes"})
+
+			_, err := subject.StartSession()
+			Expect(err).To(MatchError(`unknown session policy "sometimes", use continue, new or recent`))
+		})
+	})
+
+	when("NewSession()", func() {
+		it("starts a thread with a unique name of the prefix, whatever the policy", func() {
+			subject := factory.buildClientWithoutConfig().WithSessionPolicy(client.SessionPolicy{Mode: client.SessionContinue})
+			subject.History = []types.Message{{Role: client.UserRole, Content: "question"}}
+
+			mockHistoryStore.EXPECT().ListThreads().Return([]history.ThreadInfo{{Name: config.Thread}}, nil)
+			mockHistoryStore.EXPECT().SetThread(gomock.Any())
+
+			name, err := subject.NewSession("", client.CommandThreadPrefix)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(name).To(HavePrefix(client.CommandThreadPrefix))
+			Expect(subject.Config.Thread).To(Equal(name))
+			Expect(subject.History).To(BeEmpty())
+		})
+		it("starts the thread of the name, before any thread was written", func() {
+			subject := factory.buildClientWithoutConfig()
+
+			mockHistoryStore.EXPECT().ListThreads().Return(nil, fs.ErrNotExist)
+			mockHistoryStore.EXPECT().SetThread("fresh")
+
+			name, err := subject.NewSession("fresh", client.CommandThreadPrefix)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(name).To(Equal("fresh"))
+			Expect(subject.Config.Thread).To(Equal("fresh"))
+		})
+		it("refuses a thread that exists, and an invalid name", func() {
+			subject := factory.buildClientWithoutConfig()
+
+			mockHistoryStore.EXPECT().ListThreads().Return([]history.ThreadInfo{{Name: "work"}}, nil)
+
+			_, err := subject.NewSession("work", client.CommandThreadPrefix)
+			Expect(err).To(MatchError("thread work already exists, a new session needs the name of another one"))
+
+			_, err = subject.NewSession("../outside", client.CommandThreadPrefix)
+			Expect(err).To(HaveOccurred())
+			Expect(subject.Config.Thread).To(Equal(config.Thread))
+		})
+	})
+
+	when("ClearHistory()", func() {
+		it("clears the current thread and reseeds the system prompt on the next query", func() {
+			subject := factory.buildClientWithoutConfig()
+			subject.History = []types.Message{
+				{Role: client.SystemRole, Content: config.Role},
+				{Role: client.UserRole, Content: "question"},
+			}
+
+			mockHistoryStore.EXPECT().Clear(config.Thread).Return(nil)
+
+			Expect(subject.ClearHistory()).To(Succeed())
+			Expect(subject.History).To(BeEmpty())
+			Expect(subject.Config.Thread).To(Equal(config.Thread))
+
+			mockHistoryStore.EXPECT().Read().Return(nil, nil)
+			messages := createMessages(nil, "next query")
+
+			body, err := createBody(messages, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			mockCaller.EXPECT().Post(subject.Config.URL+subject.Config.CompletionsPath, body, false).Return(createRawResponse("answer"), nil)
+			expectUpdate(gomock.Any())
+
+			_, _, err = subject.Query("next query")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(subject.History[0]).To(Equal(types.Message{Role: client.SystemRole, Content: config.Role}))
+		})
+		it("propagates the error of the store", func() {
+			subject := factory.buildClientWithoutConfig()
+			subject.History = []types.Message{{Role: client.SystemRole, Content: config.Role}}
+
+			mockHistoryStore.EXPECT().Clear(config.Thread).Return(errors.New("boom"))
+
+			Expect(subject.ClearHistory()).To(MatchError("boom"))
+			Expect(subject.History).NotTo(BeEmpty())
+		})
+	})
+
+	when("DeleteThread()", func() {
+		it("falls back to the thread of the store when the current thread is deleted", func() {
+			subject := factory.buildClientWithoutConfig()
+			subject.History = []types.Message{{Role: client.SystemRole, Content: config.Role}}
+
+			mockHistoryStore.EXPECT().DeleteThread(config.Thread).Return(nil)
+			mockHistoryStore.EXPECT().GetThread().Return("default")
+
+			Expect(subject.DeleteThread(config.Thread)).To(Succeed())
+			Expect(subject.Config.Thread).To(Equal("default"))
+			Expect(subject.History).To(BeEmpty())
+		})
+		it("keeps the current thread when another thread is deleted", func() {
+			subject := factory.buildClientWithoutConfig()
+
+			mockHistoryStore.EXPECT().DeleteThread("other").Return(nil)
+
+			Expect(subject.DeleteThread("other")).To(Succeed())
+			Expect(subject.Config.Thread).To(Equal(config.Thread))
+		})
+		it("propagates the error of the store", func() {
+			subject := factory.buildClientWithoutConfig()
+
+			mockHistoryStore.EXPECT().DeleteThread(config.Thread).Return(errors.New("boom"))
+
+			Expect(subject.DeleteThread(config.Thread)).To(MatchError("boom"))
+			Expect(subject.Config.Thread).To(Equal(config.Thread))
+		})
+	})
+
+	when("RenameThread()", func() {
+		it("follows the current thread to its new name", func() {
+			subject := factory.buildClientWithoutConfig()
+
+			mockHistoryStore.EXPECT().RenameThread(config.Thread, "renamed").Return(nil)
+
+			Expect(subject.RenameThread(config.Thread, "renamed")).To(Succeed())
+			Expect(subject.Config.Thread).To(Equal("renamed"))
+		})
+		it("propagates the error of the store", func() {
+			subject := factory.buildClientWithoutConfig()
+
+			mockHistoryStore.EXPECT().RenameThread(config.Thread, "renamed").Return(errors.New("boom"))
+
+			Expect(subject.RenameThread(config.Thread, "renamed")).To(MatchError("boom"))
+			Expect(subject.Config.Thread).To(Equal(config.Thread))
+		})
+	})
+
+	when("MergeThreads()", func() {
+		it("continues in the destination when the current thread is merged", func() {
+			subject := factory.buildClientWithoutConfig()
+			subject.History = []types.Message{{Role: client.SystemRole, Content: config.Role}}
+
+			result := history.MergeResult{Added: 2, Dropped: 1}
+			mockHistoryStore.EXPECT().MergeThreads("main", config.Thread, history.MergeAppend).Return(result, nil)
+
+			Expect(subject.MergeThreads("main", config.Thread, history.MergeAppend)).To(Equal(result))
+			Expect(subject.Config.Thread).To(Equal("main"))
+			Expect(subject.History).To(BeEmpty())
+		})
+		it("propagates the error of the store", func() {
+			subject := factory.buildClientWithoutConfig()
+
+			mockHistoryStore.EXPECT().MergeThreads(config.Thread, "other", history.MergeInterleave).Return(history.MergeResult{}, errors.New("boom"))
+
+			_, err := subject.MergeThreads(config.Thread, "other", history.MergeInterleave)
+			Expect(err).To(MatchError("boom"))
+			Expect(subject.Config.Thread).To(Equal(config.Thread))
+		})
+	})
+
+	when("Stats()", func() {
+		it("estimates the cost with the prices of the configured model", func() {
+			subject := factory.buildClientWithoutConfig()
+			subject.Config.Model = "gpt-4o-mini-2024-07-18"
+
+			stats := history.Stats{PromptTokens: 1_000_000, CompletionTokens: 2_000_000}
+			mockHistoryStore.EXPECT().Stats("", history.DateRange{}).Return(stats, nil)
+
+			result, err := subject.Stats("", history.DateRange{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Model).To(Equal("gpt-4o-mini-2024-07-18"))
+			Expect(result.Cost).NotTo(BeNil())
+			Expect(*result.Cost).To(BeNumerically("~", 1.35))
+		})
+		it("leaves the cost out when the price of the model is unknown", func() {
+			subject := factory.buildClientWithoutConfig()
+			subject.Config.Model = "llama-3.1-sonar-small-128k-online"
+
+			mockHistoryStore.EXPECT().Stats(config.Thread, history.DateRange{}).Return(history.Stats{PromptTokens: 10}, nil)
+
+			result, err := subject.Stats(config.Thread, history.DateRange{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Cost).To(BeNil())
+		})
+	})
+
+	when("LastExchanges()", func() {
+		asked := time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC)
+		answered := asked.Add(5 * time.Second)
+
+		thread := []types.Message{
+			{Role: client.SystemRole, Content: config.Role},
+			{Role: client.UserRole, Content: "first question", Timestamp: asked},
+			{Role: client.AssistantRole, Content: "first answer", Timestamp: answered},
+			{Role: client.UserRole, Content: "second question", Timestamp: asked},
+			{Role: client.AssistantRole, Content: "cut off answer"},
+			{Role: client.AssistantRole, Content: "second answer", Timestamp: answered},
+			{Role: client.UserRole, Content: "unanswered question"},
+		}
+
+		it("returns the last answered questions, oldest first, without sending anything", func() {
+			subject := factory.buildClientWithoutConfig()
+			mockHistoryStore.EXPECT().ReadThread(config.Thread).Return(thread, nil).Times(2)
+
+			exchanges, err := subject.LastExchanges(config.Thread, 3)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(exchanges).To(Equal([]client.Exchange{
+				{Question: "first question", Answer: "first answer", Asked: asked, Answered: answered},
+				{Question: "second question", Answer: "second answer", Asked: asked, Answered: answered},
+			}))
+
+			exchange, err := subject.LastExchange(config.Thread)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(exchange.Answer).To(Equal("second answer"))
+		})
+		it("returns the last answer of the current thread", func() {
+			subject := factory.buildClientWithoutConfig()
+			mockHistoryStore.EXPECT().GetThread().Return(config.Thread)
+			mockHistoryStore.EXPECT().ReadThread(config.Thread).Return(thread, nil)
+
+			Expect(subject.LastAnswer()).To(Equal("second answer"))
+		})
+		it("returns a NoExchangeError when nothing was answered yet", func() {
+			subject := factory.buildClientWithoutConfig()
+			mockHistoryStore.EXPECT().ReadThread(config.Thread).Return(thread[:2], nil)
+
+			_, err := subject.LastExchange(config.Thread)
+
+			var notFound *client.NoExchangeError
+			Expect(errors.As(err, &notFound)).To(BeTrue())
+			Expect(err).To(MatchError("thread " + config.Thread + " has no answered questions"))
+		})
+	})
+
+	when("DeleteMessages()", func() {
+		it("reads the current thread again after deleting from it", func() {
+			subject := factory.buildClientWithoutConfig()
+			subject.History = []types.Message{{Role: client.SystemRole, Content: config.Role}}
+
+			result := history.DeleteResult{Deleted: []int{1}, Orphaned: []int{1}}
+			mockHistoryStore.EXPECT().DeleteMessages(config.Thread, []int{1}, false).Return(result, nil)
+
+			Expect(subject.DeleteMessages(config.Thread, []int{1}, false)).To(Equal(result))
+			Expect(subject.History).To(BeEmpty())
+		})
+		it("propagates the error of the store", func() {
+			subject := factory.buildClientWithoutConfig()
+			subject.History = []types.Message{{Role: client.SystemRole, Content: config.Role}}
+
+			mockHistoryStore.EXPECT().DeleteMessages("other", []int{0}, true).Return(history.DeleteResult{}, errors.New("boom"))
+
+			_, err := subject.DeleteMessages("other", []int{0}, true)
+			Expect(err).To(MatchError("boom"))
+			Expect(subject.History).To(HaveLen(1))
+		})
+	})
+
+	when("UsePersona()", func() {
+		var registry *personas.Personas
+
+		const sqlRole = "You review SQL queries."
+
+		it.Before(func() {
+			registry = personas.New()
+			temperature := 0.2
+			registry.Register(types.Persona{Name: "sql", Role: sqlRole, Model: "gpt-4o", Temperature: &temperature})
+		})
+
+		it("returns an error that lists the available personas for an unknown name", func() {
+			subject := factory.buildClientWithoutConfig().WithPersonas(registry)
+
+			err := subject.UsePersona("unknown")
+			Expect(err).To(MatchError("unknown persona unknown, available personas: sql"))
+		})
+		it("replaces the system prompt when the conversation has not started", func() {
+			factory.withoutHistory()
+			subject := factory.buildClientWithoutConfig().WithPersonas(registry)
+
+			Expect(subject.UsePersona("sql")).To(Succeed())
+
+			Expect(subject.Config.Persona).To(Equal("sql"))
+			Expect(subject.Config.Model).To(Equal("gpt-4o"))
+			Expect(subject.Config.Temperature).To(Equal(0.2))
+			Expect(subject.History).To(Equal([]types.Message{{Role: client.SystemRole, Content: sqlRole}}))
+		})
+		it("adds the system prompt to a conversation in progress", func() {
+			history := []types.Message{
+				{Role: client.SystemRole, Content: config.Role},
+				{Role: client.UserRole, Content: "question"},
+				{Role: client.AssistantRole, Content: "answer"},
+			}
+			factory.withHistory(history)
+			subject := factory.buildClientWithoutConfig().WithPersonas(registry)
+
+			expected := append(append([]types.Message{}, history...), types.Message{Role: client.SystemRole, Content: sqlRole})
+			expectUpdate(expected)
+
+			Expect(subject.UsePersona("sql")).To(Succeed())
+			Expect(subject.History).To(Equal(expected))
+		})
+		it("starts a new thread for a conversation in progress when configured to", func() {
+			factory.withHistory([]types.Message{
+				{Role: client.SystemRole, Content: config.Role},
+				{Role: client.UserRole, Content: "question"},
+				{Role: client.AssistantRole, Content: "answer"},
+			})
+			subject := factory.buildClientWithoutConfig().WithPersonas(registry)
+			subject.Config.PersonaNewThread = true
+
+			mockHistoryStore.EXPECT().SetThread(gomock.Any()).Do(func(thread string) {
+				Expect(thread).To(HavePrefix(client.PersonaThreadPrefix))
+			})
+
+			Expect(subject.UsePersona("sql")).To(Succeed())
+			Expect(subject.Config.Thread).To(HavePrefix(client.PersonaThreadPrefix))
+			Expect(subject.History).To(Equal([]types.Message{{Role: client.SystemRole, Content: sqlRole}}))
+		})
+		it("sends the requests that follow with the project of the persona", func() {
+			factory.withoutHistory()
+			registry.Register(types.Persona{Name: "analytics", Role: sqlRole, Project: "proj_analytics"})
+
+			var projects []string
+			factoryOf := func(cfg types.Config) http.Caller {
+				projects = append(projects, cfg.Project)
+				return mockCaller
+			}
+
+			mockHistoryStore.EXPECT().SetThread(config.Thread)
+			subject := client.New(factoryOf, mockHistoryStore, MockConfig(), commandLineMode).WithPersonas(registry)
+
+			Expect(subject.UsePersona("sql")).To(Succeed())
+			Expect(subject.UsePersona("analytics")).To(Succeed())
+			Expect(subject.Config.Project).To(Equal("proj_analytics"))
+			Expect(projects).To(Equal([]string{"", "proj_analytics"}))
+		})
+		it("does nothing when the persona is already active", func() {
+			subject := factory.buildClientWithoutConfig().WithPersonas(registry)
+			subject.Config.Persona = "sql"
+
+			Expect(subject.UsePersona("sql")).To(Succeed())
+			Expect(subject.History).To(BeEmpty())
+		})
+	})
+	when("WithSystem()", func() {
+		const override = "Answer only with a single shell command."
+
+		requestMessages := func(body []byte) []types.Message {
+			var request types.CompletionsRequest
+			Expect(json.Unmarshal(body, &request)).To(Succeed())
+			return request.Messages
+		}
+		answer := func(string, []byte, bool) ([]byte, error) {
+			return json.Marshal(types.CompletionsResponse{
+				Choices: []types.Choice{{Message: types.Message{Role: client.AssistantRole, Content: "ls"}}},
+			})
+		}
+
+		it("sends the prompt in place of the system messages, and stores them as they were", func() {
+			factory.withHistory([]types.Message{
+				{Role: client.SystemRole, Content: config.Role},
+				{Role: client.UserRole, Content: "question"},
+				{Role: client.AssistantRole, Content: "answer"},
+				{Role: client.SystemRole, Content: "You review SQL queries."},
+			})
+			subject := factory.buildClientWithoutConfig().WithSystem(override)
+			Expect(subject.System()).To(Equal(override))
+
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).DoAndReturn(func(url string, body []byte, stream bool) ([]byte, error) {
+				Expect(requestMessages(body)).To(Equal([]types.Message{
+					{Role: client.SystemRole, Content: override},
+					{Role: client.UserRole, Content: "question"},
+					{Role: client.AssistantRole, Content: "answer"},
+					{Role: client.UserRole, Content: query},
+				}))
+				return answer(url, body, stream)
+			})
+			expectUpdate(gomock.Any())
+
+			_, _, err := subject.Query(query)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(subject.History[0].Content).To(Equal(config.Role))
+			Expect(subject.History[3].Content).To(Equal("You review SQL queries."))
+		})
+		it("wins over the role of the configuration and of the persona, until it is removed", func() {
+			registry := personas.New()
+			registry.Register(types.Persona{Name: "sql", Role: "You review SQL queries."})
+
+			factory.withoutHistory()
+			subject := factory.buildClientWithoutConfig().WithPersonas(registry)
+			Expect(subject.UsePersona("sql")).To(Succeed())
+
+			_, body, err := subject.DryRun(query, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(requestMessages(body)[0].Content).To(Equal("You review SQL queries."))
+
+			_, body, err = subject.WithSystem(override).DryRun(query, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(requestMessages(body)[0].Content).To(Equal(override))
+
+			_, body, err = subject.WithSystem("").DryRun(query, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(requestMessages(body)[0].Content).To(Equal("You review SQL queries."))
+		})
+		it("is the system prompt of the queries sent on their own", func() {
+			subject := factory.buildClientWithoutConfig().WithSystem(override)
+
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).DoAndReturn(func(url string, body []byte, stream bool) ([]byte, error) {
+				Expect(requestMessages(body)).To(Equal([]types.Message{
+					{Role: client.SystemRole, Content: override},
+					{Role: client.UserRole, Content: "list the files"},
+				}))
+				return answer(url, body, stream)
+			})
+
+			_, _, err := subject.Ask(context.Background(), "list the files")
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+	when("hooks are registered", func() {
+		createResponse := func(content string) []byte {
+			response := &types.CompletionsResponse{
+				Choices: []types.Choice{{
+					Message: types.Message{
+						Role:    client.AssistantRole,
+						Content: content,
+					},
+					FinishReason: "stop",
+				}},
+				Usage: types.Usage{TotalTokens: 10},
+			}
+
+			respBytes, err := json.Marshal(response)
+			Expect(err).NotTo(HaveOccurred())
+			return respBytes
+		}
+
+		redact := func(messages []types.Message) ([]types.Message, error) {
+			for i := range messages {
+				messages[i].Content = strings.ReplaceAll(messages[i].Content, "secret", "[redacted]")
+			}
+			return messages, nil
+		}
+
+		it("runs the hooks in the order of registration", func() {
+			factory.withoutHistory()
+
+			var calls []string
+			subject := factory.buildClientWithoutConfig().
+				WithBeforeHook(func(messages []types.Message) ([]types.Message, error) {
+					calls = append(calls, "before 1")
+					return messages, nil
+				}).
+				WithBeforeHook(func(messages []types.Message) ([]types.Message, error) {
+					calls = append(calls, "before 2")
+					return messages, nil
+				}).
+				WithAfterHook(func(answer string, usage types.Usage) error {
+					calls = append(calls, "after 1: "+answer)
+					return nil
+				}).
+				WithAfterHook(func(answer string, usage types.Usage) error {
+					calls = append(calls, fmt.Sprintf("after 2: %d", usage.TotalTokens))
+					return nil
+				})
+
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).Return(createResponse("answer"), nil)
+			expectUpdate(gomock.Any())
+
+			_, _, err := subject.Query(query)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(calls).To(Equal([]string{"before 1", "before 2", "after 1: answer", "after 2: 10"}))
+		})
+		it("sends the messages returned by the before hooks but stores the original ones", func() {
+			factory.withoutHistory()
+			subject := factory.buildClientWithoutConfig().WithBeforeHook(redact)
+
+			messages := createMessages(nil, "my secret query")
+			redacted, err := redact(createMessages(nil, "my secret query"))
+			Expect(err).NotTo(HaveOccurred())
+			body, err := createBody(redacted, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			mockCaller.EXPECT().Post(subject.Config.URL+subject.Config.CompletionsPath, body, false).Return(createResponse("answer"), nil)
+			expectUpdate(append(messages, types.Message{Role: client.AssistantRole, Content: "answer", Model: config.Model}))
+
+			_, _, err = subject.Query("my secret query")
+			Expect(err).NotTo(HaveOccurred())
+		})
+		it("applies the before hooks to streams", func() {
+			factory.withoutHistory()
+			subject := factory.buildClientWithoutConfig().WithBeforeHook(redact)
+
+			redacted, err := redact(createMessages(nil, "my secret query"))
+			Expect(err).NotTo(HaveOccurred())
+			body, err := createBody(redacted, true)
+			Expect(err).NotTo(HaveOccurred())
+
+			var streamed string
+			subject.WithAfterHook(func(answer string, _ types.Usage) error {
+				streamed = answer
+				return nil
+			})
+
+			mockCaller.EXPECT().PostStream(subject.Config.URL+subject.Config.CompletionsPath, body).Return(createStream("answer", nil), nil)
+			expectUpdate(gomock.Any())
+
+			Expect(subject.Stream("my secret query")).To(Succeed())
+			Expect(streamed).To(Equal("answer"))
+		})
+		it("aborts the request when a before hook returns an error", func() {
+			factory.withoutHistory()
+
+			called := false
+			subject := factory.buildClientWithoutConfig().
+				WithBeforeHook(func([]types.Message) ([]types.Message, error) {
+					return nil, errors.New("blocked")
+				}).
+				WithBeforeHook(func(messages []types.Message) ([]types.Message, error) {
+					called = true
+					return messages, nil
+				})
+
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+			_, _, err := subject.Query(query)
+			Expect(err).To(MatchError("blocked"))
+			Expect(called).To(BeFalse())
+		})
+		it("keeps the answer out of the history when an after hook returns an error", func() {
+			factory.withoutHistory()
+			subject := factory.buildClientWithoutConfig().WithAfterHook(func(string, types.Usage) error {
+				return errors.New("rejected")
+			})
+
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).Return(createResponse("answer"), nil)
+			mockHistoryStore.EXPECT().Update(gomock.Any()).Times(0)
+
+			_, _, err := subject.Query(query)
+			Expect(err).To(MatchError("rejected"))
+		})
+		it("reports a panicking hook as an error", func() {
+			factory.withoutHistory()
+			subject := factory.buildClientWithoutConfig().
+				WithBeforeHook(func(messages []types.Message) ([]types.Message, error) {
+					return messages, nil
+				}).
+				WithBeforeHook(func([]types.Message) ([]types.Message, error) {
+					panic("boom")
+				})
+
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+			_, _, err := subject.Query(query)
+			Expect(err).To(MatchError("before hook 2 panicked: boom"))
+		})
+	})
+	when("write observers are registered", func() {
+		it("tells the observers about the appended messages in the order of registration", func() {
+			factory.withoutHistory()
+
+			var calls []string
+			subject := factory.buildClientWithoutConfig().
+				WithWriteObserver(func(thread string, messages []types.Message) error {
+					calls = append(calls, fmt.Sprintf("observer 1: %s %d", thread, len(messages)))
+					return nil
+				}).
+				WithWriteObserver(func(thread string, messages []types.Message) error {
+					calls = append(calls, "observer 2: "+messages[len(messages)-1].Content)
+					return nil
+				})
+
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).Return(createRawResponse("answer"), nil).Times(2)
+			expectUpdate(gomock.Any()).Times(2)
+
+			_, _, err := subject.Query(query)
+			Expect(err).NotTo(HaveOccurred())
+			_, _, err = subject.Query("second query")
+			Expect(err).NotTo(HaveOccurred())
+
+			subject.FlushObservers()
+			Expect(calls).To(Equal([]string{
+				"observer 1: " + config.Thread + " 2",
+				"observer 2: answer",
+				"observer 1: " + config.Thread + " 2",
+				"observer 2: answer",
+			}))
+		})
+		it("reports the errors of the observers instead of failing the query", func() {
+			factory.withoutHistory()
+
+			var reported []error
+			subject := factory.buildClientWithoutConfig().
+				WithHistoryErrorReporter(func(err error) { reported = append(reported, err) }).
+				WithWriteObserver(func(string, []types.Message) error { return errors.New("pipeline is down") }).
+				WithWriteObserver(func(string, []types.Message) error { panic("boom") })
+
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).Return(createRawResponse("answer"), nil)
+			expectUpdate(gomock.Any())
+
+			answer, _, err := subject.Query(query)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(answer).To(Equal("answer"))
+
+			subject.FlushObservers()
+			Expect(reported).To(HaveLen(2))
+			Expect(reported[0]).To(MatchError("write observer 1 failed for thread " + config.Thread + ": pipeline is down"))
+			Expect(reported[1]).To(MatchError(ContainSubstring("observer hook 2 panicked: boom")))
+		})
+		it("drops writes for a slow observer instead of holding up the queries", func() {
+			factory.withoutHistory()
+
+			var (
+				mu       sync.Mutex
+				reported []error
+			)
+			release := make(chan struct{})
+			subject := factory.buildClientWithoutConfig().
+				WithHistoryErrorReporter(func(err error) {
+					mu.Lock()
+					defer mu.Unlock()
+					reported = append(reported, err)
+				}).
+				WithWriteObserver(func(string, []types.Message) error {
+					<-release
+					return nil
+				})
+
+			// one write is with the observer, the queue holds the next ones and the last is dropped
+			queries := client.ObserverQueueSize + 2
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).Return(createRawResponse("answer"), nil).Times(queries)
+			expectUpdate(gomock.Any()).Times(queries)
+
+			for i := 0; i < queries; i++ {
+				_, _, err := subject.Query(query)
+				Expect(err).NotTo(HaveOccurred())
+
+				// the observer is given the first write before the queue fills up
+				if i == 0 {
+					time.Sleep(50 * time.Millisecond)
+				}
+			}
+
+			close(release)
+			subject.FlushObservers()
+
+			mu.Lock()
+			defer mu.Unlock()
+			Expect(reported).To(HaveLen(1))
+			Expect(reported[0]).To(MatchError("the write observers fell behind, dropped 2 messages of thread " + config.Thread))
+		})
+	})
+
+	when("metrics are reported", func() {
+		var (
+			recorded *recordingMetrics
+			ticking  tickingClock
+		)
+
+		it.Before(func() {
+			factory.withoutHistory()
+			recorded = &recordingMetrics{}
+
+			// every reading of the clock is 100ms after the one before
+			ticking = tickingClock{Fake: clock.NewFake(time.Date(2024, time.March, 1, 9, 0, 0, 0, time.UTC)), tick: 100 * time.Millisecond}
+		})
+
+		it("reports the duration, the status and the usage of a query", func() {
+			subject := factory.buildClientWithoutConfig().WithClock(ticking).WithMetrics(recorded)
+
+			response, err := json.Marshal(&types.CompletionsResponse{
+				Choices: []types.Choice{{Message: types.Message{Role: client.AssistantRole, Content: "answer"}, FinishReason: "stop"}},
+				Usage:   types.Usage{PromptTokens: 12, CompletionTokens: 3, TotalTokens: 15},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).Return(response, nil)
+			expectUpdate(gomock.Any())
+
+			_, _, err = subject.Query(query)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(recorded.calls).To(Equal([]string{
+				"request 100ms " + config.Model + " 200",
+				"tokens 12 3 " + config.Model,
+			}))
+		})
+		it("reports the status of a failed request", func() {
+			subject := factory.buildClientWithoutConfig().WithClock(ticking).WithMetrics(recorded)
+
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).Return(nil, &http.APIError{StatusCode: 429, Message: "slow down"})
+			mockCaller.EXPECT().Get(gomock.Any()).Return(nil, errors.New("connection refused"))
+
+			_, _, err := subject.Query(query)
+			Expect(err).To(HaveOccurred())
+			_, err = subject.ListModels()
+			Expect(err).To(HaveOccurred())
+
+			Expect(recorded.calls).To(Equal([]string{
+				"request 100ms " + config.Model + " 429",
+				"request 100ms  0",
+			}))
+		})
+		it("reports the time to the first token of a stream apart from its duration", func() {
+			subject := factory.buildClientWithoutConfig().WithClock(ticking).WithMetrics(recorded)
+
+			usage := types.Usage{PromptTokens: 20, CompletionTokens: 2, TotalTokens: 22}
+			mockCaller.EXPECT().PostStream(gomock.Any(), gomock.Any()).Return(createStream("an answer", &usage), nil)
+			expectUpdate(gomock.Any())
+
+			Expect(subject.Stream(query)).To(Succeed())
+			Expect(subject.FirstToken()).To(Equal(100 * time.Millisecond))
+
+			Expect(recorded.calls).To(Equal([]string{
+				"first token 100ms " + config.Model,
+				"request 200ms " + config.Model + " 200",
+				"tokens 20 2 " + config.Model,
+			}))
+
+			// an answer that isn't streamed has no first token
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).Return(createRawResponse("answer"), nil)
+			expectUpdate(gomock.Any())
+
+			_, _, err := subject.Query(query)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(subject.FirstToken()).To(BeZero())
+		})
+		it("reports the retries of invalid JSON", func() {
+			subject := factory.buildClientWithoutConfig().WithClock(ticking).WithMetrics(recorded)
+			subject.Config.JSONRetries = 1
+
+			gomock.InOrder(
+				mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).Return(createRawResponse(`{"name":`), nil),
+				mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).Return(createRawResponse(`{"name":"chatgpt"}`), nil),
+			)
+			expectUpdate(gomock.Any())
+
+			var target map[string]string
+			_, _, err := subject.QueryJSON(query, &target)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(recorded.calls).To(ContainElement("retry " + metrics.RetryInvalidJSON))
+			Expect(recorded.calls).To(HaveLen(3))
+		})
+	})
+
+	when("progress is shown", func() {
+		var recorded *recordingProgress
+
+		it.Before(func() {
+			factory.withoutHistory()
+			recorded = &recordingProgress{}
+		})
+
+		it("starts the progress with the model and stops it once the query is answered", func() {
+			subject := factory.buildClientWithoutConfig().WithProgress(recorded)
+
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).Return(createRawResponse("answer"), nil)
+			expectUpdate(gomock.Any())
+
+			_, _, err := subject.Query(query)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(recorded.calls).To(Equal([]string{"start " + config.Model, "stop"}))
+		})
+		it("stops the progress when the query fails", func() {
+			subject := factory.buildClientWithoutConfig().WithProgress(recorded)
+
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).Return(nil, errors.New("connection refused"))
+
+			_, _, err := subject.Query(query)
+			Expect(err).To(HaveOccurred())
+
+			Expect(recorded.calls).To(Equal([]string{"start " + config.Model, "stop"}))
+		})
+		it("stops the progress once the retries of invalid JSON are done", func() {
+			subject := factory.buildClientWithoutConfig().WithProgress(recorded)
+			subject.Config.JSONRetries = 1
+
+			gomock.InOrder(
+				mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).Return(createRawResponse(`{"name":`), nil),
+				mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).Return(createRawResponse(`{"name":"chatgpt"}`), nil),
+			)
+			expectUpdate(gomock.Any())
+
+			var target map[string]string
+			_, _, err := subject.QueryJSON(query, &target)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(recorded.calls).To(Equal([]string{"start " + config.Model, "stop"}))
+		})
+		it("doesn't show the progress of a stream", func() {
+			subject := factory.buildClientWithoutConfig().WithProgress(recorded)
+
+			mockCaller.EXPECT().PostStream(gomock.Any(), gomock.Any()).Return(createStream("an answer", nil), nil)
+			expectUpdate(gomock.Any())
+
+			Expect(subject.Stream(query)).To(Succeed())
+
+			Expect(recorded.calls).To(BeEmpty())
+		})
+	})
+
+	when("Replay()", func() {
+		thread := []types.Message{
+			{Role: client.SystemRole, Content: "You answer briefly."},
+			{Role: client.UserRole, Content: "question 1"},
+			{Role: client.AssistantRole, Content: "answer 1"},
+			{Role: client.UserRole, Content: "question 2"},
+			{Role: client.AssistantRole, Content: "answer 2"},
+		}
+
+		// replay answers with the model and the number of messages it was sent
+		replay := func(_ string, body []byte, _ bool) ([]byte, error) {
+			var request types.CompletionsRequest
+			Expect(json.Unmarshal(body, &request)).To(Succeed())
+			Expect(request.Messages[0].Content).To(Equal("You answer briefly."))
+
+			for _, message := range request.Messages {
+				Expect(message.Content).NotTo(HavePrefix("answer"))
+			}
+
+			return createRawResponse(fmt.Sprintf("%s saw %d", request.Model, len(request.Messages))), nil
+		}
+
+		it("asks the questions again with a context of its own without changing the thread", func() {
+			subject := factory.buildClientWithoutConfig()
+
+			mockHistoryStore.EXPECT().ReadThread("old").Return(thread, nil)
+			mockHistoryStore.EXPECT().Update(gomock.Any()).Times(0)
+			mockHistoryStore.EXPECT().Write(gomock.Any()).Times(0)
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).DoAndReturn(replay).Times(2)
+
+			var progress []int
+			result, err := subject.Replay(context.Background(), "old", "gpt-4o", client.ReplayOptions{
+				Progress: func(result client.ReplayResult, total int) {
+					Expect(total).To(Equal(2))
+					progress = append(progress, len(result.Turns))
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(progress).To(Equal([]int{1, 2}))
+
+			Expect(result.Done).To(BeTrue())
+			Expect(result.Model).To(Equal("gpt-4o"))
+			Expect(result.Turns).To(HaveLen(2))
+			Expect(result.Turns[0]).To(Equal(client.ReplayTurn{Index: 1, Question: "question 1", Original: "answer 1", Answer: "gpt-4o saw 2"}))
+			Expect(result.Turns[1].Index).To(Equal(3))
+			Expect(result.Turns[1].Original).To(Equal("answer 2"))
+			Expect(result.Turns[1].Answer).To(Equal("gpt-4o saw 4"))
+			Expect(subject.Config.Model).NotTo(Equal("gpt-4o"))
+		})
+		it("returns the turns replayed so far and resumes from them", func() {
+			subject := factory.buildClientWithoutConfig()
+
+			mockHistoryStore.EXPECT().ReadThread("old").Return(thread, nil).Times(2)
+			gomock.InOrder(
+				mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).DoAndReturn(replay),
+				mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).Return(nil, errors.New("rate limited")),
+				mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).DoAndReturn(replay),
+			)
+
+			partial, err := subject.Replay(context.Background(), "old", "gpt-4o", client.ReplayOptions{})
+			Expect(err).To(MatchError("failed to replay question 3 of thread old: rate limited"))
+			Expect(partial.Done).To(BeFalse())
+			Expect(partial.Turns).To(HaveLen(1))
+
+			result, err := subject.Replay(context.Background(), "old", "gpt-4o", client.ReplayOptions{Resume: &partial})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Done).To(BeTrue())
+			Expect(result.Turns[0]).To(Equal(partial.Turns[0]))
+			Expect(result.Turns[1].Answer).To(Equal("gpt-4o saw 4"))
+		})
+		it("refuses to resume a replay of another thread or model", func() {
+			subject := factory.buildClientWithoutConfig()
+
+			mockHistoryStore.EXPECT().ReadThread("old").Return(thread, nil).Times(2)
+
+			_, err := subject.Replay(context.Background(), "old", "gpt-4o", client.ReplayOptions{Resume: &client.ReplayResult{Thread: "old", Model: "o1"}})
+			Expect(err).To(MatchError(ContainSubstring("can't resume the replay of thread old against o1")))
+
+			changed := &client.ReplayResult{Thread: "old", Model: "gpt-4o", Turns: []client.ReplayTurn{{Index: 1, Question: "another question"}}}
+			_, err = subject.Replay(context.Background(), "old", "gpt-4o", client.ReplayOptions{Resume: changed})
+			Expect(err).To(MatchError("can't resume the replay, turn 1 is not question 1 of thread old anymore"))
+		})
+		it("returns an error for a thread without questions", func() {
+			subject := factory.buildClientWithoutConfig()
+
+			mockHistoryStore.EXPECT().ReadThread("empty").Return(nil, nil)
+
+			_, err := subject.Replay(context.Background(), "empty", "gpt-4o", client.ReplayOptions{})
+			Expect(err).To(MatchError("thread empty has no questions to replay"))
+		})
+	})
+
+	when("Ask()", func() {
+		it("sends the prompt with the system role only, without touching the history", func() {
+			recorded := &recordingProgress{}
+			subject := factory.buildClientWithoutConfig().WithProgress(recorded)
+
+			mockHistoryStore.EXPECT().Read().Times(0)
+			mockHistoryStore.EXPECT().Update(gomock.Any()).Times(0)
+
+			mockCaller.EXPECT().Post(subject.Config.URL+subject.Config.CompletionsPath, gomock.Any(), false).
+				DoAndReturn(func(_ string, body []byte, _ bool) ([]byte, error) {
+					var request types.CompletionsRequest
+					Expect(json.Unmarshal(body, &request)).To(Succeed())
+					Expect(request.Messages).To(Equal([]types.Message{
+						{Role: client.SystemRole, Content: config.Role},
+						{Role: client.UserRole, Content: "explain part 1"},
+					}))
+
+					return json.Marshal(types.CompletionsResponse{
+						Choices: []types.Choice{{Message: types.Message{Role: client.AssistantRole, Content: "the answer"}}},
+						Usage:   types.Usage{PromptTokens: 3, CompletionTokens: 2, TotalTokens: 5},
+					})
+				})
+
+			answer, tokens, err := subject.Ask(context.Background(), "explain part 1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(answer).To(Equal("the answer"))
+			Expect(tokens).To(Equal(5))
+			Expect(subject.Usage().PromptTokens).To(Equal(3))
+			Expect(subject.History).To(BeEmpty())
+			Expect(recorded.calls).To(Equal([]string{"start " + config.Model, "stop"}))
+		})
+	})
+
+	when("QueryAll()", func() {
+		it("returns the results in the order of the prompts without touching the history", func() {
+			subject := factory.buildClientWithoutConfig()
+
+			mockHistoryStore.EXPECT().Read().Times(0)
+			mockHistoryStore.EXPECT().Update(gomock.Any()).Times(0)
+
+			mockCaller.EXPECT().Post(subject.Config.URL+subject.Config.CompletionsPath, gomock.Any(), false).
+				DoAndReturn(func(_ string, body []byte, _ bool) ([]byte, error) {
+					var request types.CompletionsRequest
+					Expect(json.Unmarshal(body, &request)).To(Succeed())
+					Expect(request.Messages).To(HaveLen(2))
+					Expect(request.Messages[0]).To(Equal(types.Message{Role: client.SystemRole, Content: config.Role}))
+
+					prompt := request.Messages[1].Content
+					if prompt == "fail" {
+						return nil, errors.New("error message")
+					}
+
+					return json.Marshal(types.CompletionsResponse{
+						Choices: []types.Choice{{Message: types.Message{Role: client.AssistantRole, Content: "answer to " + prompt}}},
+						Usage:   types.Usage{TotalTokens: 1},
+					})
+				}).Times(4)
+
+			results := subject.QueryAll(context.Background(), []string{"one", "two", "fail", "three"}, 2)
+			Expect(results).To(HaveLen(4))
+			Expect(results[0].Answer).To(Equal("answer to one"))
+			Expect(results[1].Answer).To(Equal("answer to two"))
+			Expect(results[2].Err).To(MatchError("error message"))
+			Expect(results[3].Answer).To(Equal("answer to three"))
+			Expect(results[3].Prompt).To(Equal("three"))
+			Expect(results[3].Usage.TotalTokens).To(Equal(1))
+		})
+		it("is not affected by conversation calls that change the configuration", func() {
+			factory.withHistory([]types.Message{
+				{Role: client.SystemRole, Content: config.Role},
+				{Role: client.UserRole, Content: "question 1"},
+				{Role: client.AssistantRole, Content: "answer 1"},
+			})
+			subject := factory.buildClientWithoutConfig()
+
+			var (
+				mu           sync.Mutex
+				temperatures []float64
+			)
+
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).
+				DoAndReturn(func(_ string, body []byte, _ bool) ([]byte, error) {
+					var request types.CompletionsRequest
+					if err := json.Unmarshal(body, &request); err != nil {
+						return nil, err
+					}
+
+					if request.Messages[len(request.Messages)-1].Content != "question 1" {
+						mu.Lock()
+						temperatures = append(temperatures, request.Temperature)
+						mu.Unlock()
+					}
+
+					return createRawResponse("answer"), nil
+				}).AnyTimes()
+			expectUpdate(gomock.Any())
+
+			prompts := make([]string, 20)
+			for i := range prompts {
+				prompts[i] = fmt.Sprintf("prompt %d", i)
+			}
+
+			done := make(chan []client.BatchResult)
+			go func() {
+				done <- subject.QueryAll(context.Background(), prompts, 4)
+			}()
+
+			temperature := 1.5
+			_, _, err := subject.Regenerate(client.RegenerateOptions{Temperature: &temperature})
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, result := range <-done {
+				Expect(result.Err).NotTo(HaveOccurred())
+			}
+
+			Expect(temperatures).To(HaveLen(len(prompts)))
+			for _, temperature := range temperatures {
+				Expect(temperature).To(Equal(config.Temperature))
+			}
+		})
+		it("does not send any prompts once the context is cancelled", func() {
+			subject := factory.buildClientWithoutConfig()
+
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			results := subject.QueryAll(ctx, []string{"one", "two"}, 1)
+			Expect(results).To(HaveLen(2))
+			for _, result := range results {
+				Expect(result.Err).To(MatchError(context.Canceled))
+			}
+		})
+	})
+	when("QueryEach()", func() {
+		it("sends every prompt with its settings and passes each result as it is answered", func() {
+			subject := factory.buildClientWithoutConfig()
+
+			var (
+				mu       sync.Mutex
+				requests = map[string]types.CompletionsRequest{}
+			)
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).
+				DoAndReturn(func(_ string, body []byte, _ bool) ([]byte, error) {
+					var request types.CompletionsRequest
+					if err := json.Unmarshal(body, &request); err != nil {
+						return nil, err
+					}
+
+					mu.Lock()
+					requests[request.Messages[1].Content] = request
+					mu.Unlock()
+
+					return createRawResponse("answer to " + request.Messages[1].Content), nil
+				}).Times(2)
+
+			temperature := 0.2
+			prompts := []client.BatchPrompt{
+				{Prompt: "one"},
+				{Prompt: "two", Model: "gpt-4o", System: "Be brief.", Temperature: &temperature, MaxTokens: 100},
+			}
+
+			answers := map[int]string{}
+			subject.QueryEach(context.Background(), prompts, 2, func(index int, result client.BatchResult) {
+				Expect(result.Err).NotTo(HaveOccurred())
+				answers[index] = result.Answer
+			})
+
+			Expect(answers).To(Equal(map[int]string{0: "answer to one", 1: "answer to two"}))
+
+			Expect(requests["one"].Model).To(Equal(config.Model))
+			Expect(requests["one"].Messages[0].Content).To(Equal(config.Role))
+			Expect(requests["one"].Temperature).To(Equal(config.Temperature))
+
+			Expect(requests["two"].Model).To(Equal("gpt-4o"))
+			Expect(requests["two"].Messages[0].Content).To(Equal("Be brief."))
+			Expect(requests["two"].Temperature).To(Equal(0.2))
+			Expect(requests["two"].MaxTokens).To(Equal(100))
+		})
+		it("doesn't pass the prompts that weren't sent once the context is done", func() {
+			subject := factory.buildClientWithoutConfig()
+
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			called := false
+			subject.QueryEach(ctx, []client.BatchPrompt{{Prompt: "one"}}, 1, func(int, client.BatchResult) {
+				called = true
+			})
+			Expect(called).To(BeFalse())
+		})
+	})
+	when("the thread is shared with another process", func() {
+		it("appends only the new exchange when the thread changed in the meantime", func() {
+			stored := []types.Message{
+				{Role: client.SystemRole, Content: config.Role},
+				{Role: client.UserRole, Content: "question 1"},
+				{Role: client.AssistantRole, Content: "answer 1"},
+			}
+			factory.withHistory(stored[:1])
+			subject := factory.buildClientWithoutConfig()
+
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).Return(createRawResponse("answer"), nil)
+			mockHistoryStore.EXPECT().Update(gomock.Any()).DoAndReturn(func(update func([]types.Message) []types.Message) error {
+				Expect(update(stored)).To(Equal(append(stored[:3:3],
+					types.Message{Role: client.UserRole, Content: query},
+					types.Message{Role: client.AssistantRole, Content: "answer", Model: config.Model},
+				)))
+				return nil
+			})
+
+			_, _, err := subject.Query(query)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(subject.History).To(HaveLen(5))
+		})
+		it("keeps the exchanges of two stores on one directory", func() {
+			dir := t.TempDir()
+			Expect(os.Setenv(utils.ConfigHomeEnv, dir)).To(Succeed())
+			Expect(os.Setenv(utils.DataHomeEnv, dir)).To(Succeed())
+			defer func() {
+				Expect(os.Unsetenv(utils.ConfigHomeEnv)).To(Succeed())
+				Expect(os.Unsetenv(utils.DataHomeEnv)).To(Succeed())
+			}()
+
+			newClient := func() *client.Client {
+				store, err := history.New()
+				Expect(err).NotTo(HaveOccurred())
+				return client.New(mockCallerFactory, store, MockConfig(), commandLineMode)
+			}
+
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).
+				DoAndReturn(func(_ string, body []byte, _ bool) ([]byte, error) {
+					var request types.CompletionsRequest
+					Expect(json.Unmarshal(body, &request)).To(Succeed())
+					return createRawResponse("answer to " + request.Messages[len(request.Messages)-1].Content), nil
+				}).Times(3)
+
+			first, second := newClient(), newClient()
+
+			_, _, err := first.Query("first")
+			Expect(err).NotTo(HaveOccurred())
+			_, _, err = second.Query("second")
+			Expect(err).NotTo(HaveOccurred())
+			// the first client still holds the conversation from before the second query
+			_, _, err = first.Query("third")
+			Expect(err).NotTo(HaveOccurred())
+
+			store, err := history.New()
+			Expect(err).NotTo(HaveOccurred())
+			store.SetThread(config.Thread)
+
+			messages, err := store.Read()
+			Expect(err).NotTo(HaveOccurred())
+
+			var contents []string
+			for _, message := range messages[1:] {
+				contents = append(contents, message.Content)
+			}
+			Expect(contents).To(Equal([]string{"first", "answer to first", "second", "answer to second", "third", "answer to third"}))
+		})
+	})
+	when("WithEphemeralHistory()", func() {
+		it("keeps the conversation in memory without touching the history store", func() {
+			subject := factory.buildClientWithoutConfig().WithEphemeralHistory()
+
+			mockHistoryStore.EXPECT().Read().Times(0)
+			mockHistoryStore.EXPECT().Update(gomock.Any()).Times(0)
+
+			var requests []types.CompletionsRequest
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).
+				DoAndReturn(func(_ string, body []byte, _ bool) ([]byte, error) {
+					var request types.CompletionsRequest
+					Expect(json.Unmarshal(body, &request)).To(Succeed())
+					requests = append(requests, request)
+					return createRawResponse("answer to " + request.Messages[len(request.Messages)-1].Content), nil
+				}).Times(2)
+
+			_, _, err := subject.Query("first")
+			Expect(err).NotTo(HaveOccurred())
+			_, _, err = subject.Query("second")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(requests[1].Messages).To(HaveLen(4))
+			Expect(requests[1].Messages[2].Content).To(Equal("answer to first"))
+		})
+	})
+	when("Query() is called concurrently", func() {
+		it("serializes the exchanges so no messages are lost", func() {
+			const workers = 10
+
+			store := history.NewMemoryStore()
+			subject := client.New(mockCallerFactory, store, MockConfig(), commandLineMode)
+			subject.Config.ContextWindow = 100000
+
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).
+				DoAndReturn(func(_ string, body []byte, _ bool) ([]byte, error) {
+					var request types.CompletionsRequest
+					Expect(json.Unmarshal(body, &request)).To(Succeed())
+
+					return json.Marshal(types.CompletionsResponse{
+						Choices: []types.Choice{{Message: types.Message{
+							Role:    client.AssistantRole,
+							Content: "answer to " + request.Messages[len(request.Messages)-1].Content,
+						}}},
+					})
+				}).Times(workers)
+
+			var wg sync.WaitGroup
+			for i := 0; i < workers; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					_, _, err := subject.Query(fmt.Sprintf("question %d", i))
+					Expect(err).NotTo(HaveOccurred())
+				}(i)
+			}
+			wg.Wait()
+
+			messages, err := store.Read()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(messages).To(HaveLen(1 + 2*workers))
+
+			for i := 1; i < len(messages); i += 2 {
+				Expect(messages[i].Role).To(Equal(client.UserRole))
+				Expect(messages[i+1].Content).To(Equal("answer to " + messages[i].Content))
+			}
+		})
+	})
+	when("Query() with continuations enabled", func() {
+		const (
+			firstPart  = "The first sentence. The second sen"
+			secondPart = "The second sentence."
+			continued  = "The first sentence. The second sentence."
+		)
+
+		createResponse := func(content, finishReason string, tokens int) []byte {
+			response := &types.CompletionsResponse{
+				Choices: []types.Choice{{
+					Message: types.Message{
+						Role:    client.AssistantRole,
+						Content: content,
+					},
+					FinishReason: finishReason,
+				}},
+				Usage: types.Usage{TotalTokens: tokens},
+			}
+
+			respBytes, err := json.Marshal(response)
+			Expect(err).NotTo(HaveOccurred())
+			return respBytes
+		}
+
+		it("continues a truncated answer and stores it as a single message", func() {
+			factory.withoutHistory()
+			subject := factory.buildClientWithoutConfig()
+			subject.Config.MaxContinuations = 1
+
+			messages := createMessages(nil, query)
+			firstBody, err := createBody(messages, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			continuation := append(append([]types.Message{}, messages...),
+				types.Message{Role: client.AssistantRole, Content: firstPart},
+				types.Message{Role: client.UserRole, Content: client.ContinuePrompt},
+			)
+			secondBody, err := createBody(continuation, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			gomock.InOrder(
+				mockCaller.EXPECT().Post(subject.Config.URL+subject.Config.CompletionsPath, firstBody, false).
+					Return(createResponse(firstPart, client.FinishReasonLength, 10), nil),
+				mockCaller.EXPECT().Post(subject.Config.URL+subject.Config.CompletionsPath, secondBody, false).
+					Return(createResponse(secondPart, "stop", 5), nil),
+			)
+			expectUpdate(append(messages, types.Message{
+				Role:    client.AssistantRole,
+				Content: continued,
+				Model:   config.Model,
+			}))
+
+			result, usage, err := subject.Query(query)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal(continued))
+			Expect(usage).To(Equal(15))
+		})
+		it("stops continuing once the configured maximum is reached", func() {
+			factory.withoutHistory()
+			subject := factory.buildClientWithoutConfig()
+			subject.Config.MaxContinuations = 1
+
+			gomock.InOrder(
+				mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).
+					Return(createResponse("one ", client.FinishReasonLength, 1), nil),
+				mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).
+					Return(createResponse("two ", client.FinishReasonLength, 1), nil),
+			)
+			expectUpdate(gomock.Any())
+
+			result, _, err := subject.Query(query)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal("one two "))
+		})
+		it("does not continue when continuations are disabled", func() {
+			factory.withoutHistory()
+			subject := factory.buildClientWithoutConfig()
+
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).
+				Return(createResponse(firstPart, client.FinishReasonLength, 10), nil).Times(1)
+			expectUpdate(gomock.Any())
+
+			result, _, err := subject.Query(query)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal(firstPart))
+		})
+		it("tells the ids of all the requests of the answer in order", func() {
+			var calls int
+			server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+				calls++
+				w.Header().Set("X-Request-Id", fmt.Sprintf("req_%d", calls))
+				if calls == 1 {
+					_, _ = w.Write(createResponse(firstPart, client.FinishReasonLength, 10))
+					return
+				}
+				_, _ = w.Write(createResponse(secondPart, "stop", 5))
+			}))
+			defer server.Close()
+
+			cfg := MockConfig()
+			cfg.URL = server.URL
+			cfg.MaxContinuations = 1
+
+			subject := client.New(http.RealCallerFactory, history.NewMemoryStore(), cfg, commandLineMode).WithEphemeralHistory()
+
+			result, err := subject.QueryWithResponse(query)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Choices[0].Message.Content).To(Equal(continued))
+			Expect(result.Requests).To(Equal([]types.RequestInfo{{ID: "req_1"}, {ID: "req_2"}}))
+		})
+		it("attaches the id of the request to the errors of an answered request", func() {
+			server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+				w.Header().Set("X-Request-Id", "req_1")
+				_, _ = io.WriteString(w, `{"choices":[]}`)
+			}))
+			defer server.Close()
+
+			cfg := MockConfig()
+			cfg.URL = server.URL
+
+			subject := client.New(http.RealCallerFactory, history.NewMemoryStore(), cfg, commandLineMode).WithEphemeralHistory()
+
+			_, _, err := subject.Query(query)
+			Expect(err).To(MatchError(client.ErrNoResponses))
+			Expect(http.RequestID(err)).To(Equal("req_1"))
+		})
+		it("tells the finish reason and the id of the request of the last answer", func() {
+			server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+				w.Header().Set("X-Request-Id", "req_1")
+				_, _ = w.Write(createRawResponse("answer"))
+			}))
+			defer server.Close()
+
+			cfg := MockConfig()
+			cfg.URL = server.URL
+
+			subject := client.New(http.RealCallerFactory, history.NewMemoryStore(), cfg, commandLineMode).WithEphemeralHistory()
+
+			_, _, err := subject.Query(query)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(subject.FinishReason()).To(Equal("stop"))
+			Expect(subject.RequestID()).To(Equal("req_1"))
+		})
+	})
+	when("titling new threads", func() {
+		var requests []types.CompletionsRequest
+
+		// answer returns the answers in order, an error answers with an error
+		answer := func(answers ...interface{}) {
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).DoAndReturn(func(_ string, body []byte, _ bool) ([]byte, error) {
+				var request types.CompletionsRequest
+				Expect(json.Unmarshal(body, &request)).To(Succeed())
+				requests = append(requests, request)
+
+				next := answers[len(requests)-1]
+				if err, ok := next.(error); ok {
+					return nil, err
+				}
+				return createRawResponse(next.(string)), nil
+			}).Times(len(answers))
+		}
+
+		newSubject := func() *client.Client {
+			subject := factory.buildClientWithoutConfig()
+			subject.Config.AutoTitle = true
+			subject.Config.TitleModel = "gpt-4o-mini"
+			return subject
+		}
+
+		it.Before(func() {
+			requests = nil
+		})
+
+		it("asks the title model for a title after the first exchange", func() {
+			factory.withoutHistory()
+			subject := newSubject()
+
+			answer("Sunny's Bar, mostly.", `"Bars in Red Hook."`)
+			expectUpdate(gomock.Any())
+			mockHistoryStore.EXPECT().ListThreads().Return([]history.ThreadInfo{{Name: config.Thread}}, nil)
+			mockHistoryStore.EXPECT().SetTitle(config.Thread, "Bars in Red Hook").Return(nil)
+
+			result, _, err := subject.Query("Which bars are in Red Hook?")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal("Sunny's Bar, mostly."))
+
+			Expect(requests).To(HaveLen(2))
+			Expect(requests[1].Model).To(Equal("gpt-4o-mini"))
+			Expect(requests[1].MaxTokens).To(BeNumerically("<=", 32))
+			Expect(requests[1].Messages[0].Content).To(Equal(client.TitlePrompt))
+			Expect(requests[1].Messages[1].Content).To(Equal("Question: Which bars are in Red Hook?\n\nAnswer: Sunny's Bar, mostly."))
+		})
+		it("asks the model of the query without a title model, and reports the usage of the title", func() {
+			factory.withoutHistory()
+			recorded := &recordingMetrics{}
+			subject := newSubject().WithMetrics(recorded)
+			subject.Config.TitleModel = ""
+
+			var models []string
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).DoAndReturn(func(_ string, body []byte, _ bool) ([]byte, error) {
+				var request types.CompletionsRequest
+				Expect(json.Unmarshal(body, &request)).To(Succeed())
+				models = append(models, request.Model)
+
+				return json.Marshal(&types.CompletionsResponse{
+					Choices: []types.Choice{{Message: types.Message{Role: client.AssistantRole, Content: "Bars in Red Hook"}, FinishReason: "stop"}},
+					Usage:   types.Usage{PromptTokens: 40, CompletionTokens: 5, TotalTokens: 45},
+				})
+			}).Times(2)
+			expectUpdate(gomock.Any())
+			mockHistoryStore.EXPECT().ListThreads().Return(nil, nil)
+			mockHistoryStore.EXPECT().SetTitle(config.Thread, "Bars in Red Hook").Return(nil)
+
+			_, _, err := subject.Query("Which bars are in Red Hook?")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(models).To(Equal([]string{config.Model, config.Model}))
+			Expect(recorded.calls).To(Equal([]string{
+				"request 0s " + config.Model + " 200",
+				"tokens 40 5 " + config.Model,
+				"request 0s " + config.Model + " 200",
+				"tokens 40 5 " + config.Model,
+			}))
+		})
+		it("falls back to the truncated question when the title model fails", func() {
+			factory.withoutHistory()
+			subject := newSubject()
+
+			subject.WithContextWindow(8192)
+
+			answer("an answer", errors.New("rate limited"))
+			expectUpdate(gomock.Any())
+			mockHistoryStore.EXPECT().ListThreads().Return(nil, nil)
+			mockHistoryStore.EXPECT().SetTitle(config.Thread, strings.TrimSpace(strings.Repeat("word ", 12))+"...").Return(nil)
+
+			result, _, err := subject.Query(strings.Repeat("word ", 30))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal("an answer"))
+		})
+		it("never fails the query when the title can't be stored", func() {
+			factory.withoutHistory()
+			subject := newSubject()
+
+			answer("an answer", "A title")
+			expectUpdate(gomock.Any())
+			mockHistoryStore.EXPECT().ListThreads().Return(nil, nil)
+			mockHistoryStore.EXPECT().SetTitle(config.Thread, "A title").Return(errors.New("disk full"))
+
+			_, _, err := subject.Query(query)
+			Expect(err).NotTo(HaveOccurred())
+		})
+		it("leaves threads that were answered before or have a title alone", func() {
+			factory.withHistory([]types.Message{
+				{Role: client.SystemRole, Content: config.Role},
+				{Role: client.UserRole, Content: "question 1"},
+				{Role: client.AssistantRole, Content: "answer 1"},
+			})
+			subject := newSubject()
+
+			answer("answer 2")
+			expectUpdate(gomock.Any())
+
+			_, _, err := subject.Query(query)
+			Expect(err).NotTo(HaveOccurred())
+
+			factory.withoutHistory()
+			subject = newSubject()
+
+			requests = nil
+			answer("an answer")
+			expectUpdate(gomock.Any())
+			mockHistoryStore.EXPECT().ListThreads().Return([]history.ThreadInfo{{Name: config.Thread, Title: "My title"}}, nil)
+
+			_, _, err = subject.Query(query)
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	when("secrets are redacted", func() {
+		const query = "why does password=hunter2 fail?"
+
+		var (
+			sent     []string
+			written  []types.Message
+			reported []bool
+		)
+
+		newSubject := func() *client.Client {
+			redactor, err := history.NewRedactor(nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			return factory.buildClientWithoutConfig().WithRedactor(redactor, func(redactions []history.Redaction, query bool) {
+				Expect(redactions).To(HaveLen(1))
+				Expect(redactions[0].Type).To(Equal("password"))
+				reported = append(reported, query)
+			})
+		}
+
+		it.Before(func() {
+			sent, written, reported = nil, nil, nil
+
+			factory.withoutHistory()
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).DoAndReturn(func(_ string, body []byte, _ bool) ([]byte, error) {
+				sent = append(sent, string(body))
+				return createRawResponse("an answer"), nil
+			})
+			mockHistoryStore.EXPECT().Update(gomock.Any()).DoAndReturn(func(update func([]types.Message) []types.Message) error {
+				written = update(nil)
+				return nil
+			})
+		})
+
+		it("sends the query as it is and stores it redacted", func() {
+			subject := newSubject()
+
+			_, _, err := subject.Query(query)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(sent[0]).To(ContainSubstring("hunter2"))
+			Expect(written[1].Content).To(MatchRegexp(`^why does password=\[REDACTED password [0-9a-f]{8}\] fail\?$`))
+			Expect(subject.History).To(Equal(written))
+			Expect(reported).To(Equal([]bool{false}))
+		})
+
+		it("redacts the query before it is sent with RedactQuery", func() {
+			subject := newSubject()
+			subject.Config.RedactQuery = true
+
+			_, _, err := subject.Query(query)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(sent[0]).NotTo(ContainSubstring("hunter2"))
+			Expect(sent[0]).To(ContainSubstring("[REDACTED password"))
+			Expect(written[1].Content).NotTo(ContainSubstring("hunter2"))
+			Expect(reported).To(Equal([]bool{true}))
+		})
+	})
+
+	when("Summarize()", func() {
+		const summary = `{"topics":["pgbouncer timeouts"],"decisions":["raise query_timeout"],"open_questions":[]}`
+
+		conversation := []types.Message{
+			{Role: client.SystemRole, Content: config.Role},
+			{Role: client.UserRole, Content: "How do I fix pgbouncer timeouts?"},
+			{Role: client.AssistantRole, Content: "Raise the PgBouncer query_timeout."},
+		}
+
+		var requests []types.CompletionsRequest
+
+		recordRequests := func(answer string) {
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).DoAndReturn(func(_ string, body []byte, _ bool) ([]byte, error) {
+				var request types.CompletionsRequest
+				Expect(json.Unmarshal(body, &request)).To(Succeed())
+				requests = append(requests, request)
+				return createRawResponse(answer), nil
+			}).AnyTimes()
+		}
+
+		it.Before(func() {
+			requests = nil
+		})
+
+		it("asks the summary model and leaves the thread alone", func() {
+			subject := factory.buildClientWithoutConfig()
+			subject.Config.SummaryModel = "gpt-4o-mini"
+			subject.Config.SummaryContextWindow = 8192
+
+			mockHistoryStore.EXPECT().ReadThread("other").Return(conversation, nil)
+			recordRequests(summary)
+
+			result, err := subject.Summarize("other", false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Topics).To(Equal([]string{"pgbouncer timeouts"}))
+			Expect(result.Decisions).To(Equal([]string{"raise query_timeout"}))
+
+			Expect(requests).To(HaveLen(1))
+			Expect(requests[0].Model).To(Equal("gpt-4o-mini"))
+			Expect(requests[0].ResponseFormat).NotTo(BeNil())
+			Expect(requests[0].Messages[0].Content).To(Equal(client.SummaryPrompt))
+			Expect(requests[0].Messages[1].Content).To(Equal("user: How do I fix pgbouncer timeouts?\n\nassistant: Raise the PgBouncer query_timeout."))
+		})
+		it("stores the summary with the thread when asked to", func() {
+			subject := factory.buildClientWithoutConfig()
+			subject.Config.SummaryContextWindow = 8192
+
+			mockHistoryStore.EXPECT().ReadThread(config.Thread).Return(conversation, nil)
+			recordRequests(summary)
+			mockHistoryStore.EXPECT().SetSummary(config.Thread, types.Summary{
+				Topics:        []string{"pgbouncer timeouts"},
+				Decisions:     []string{"raise query_timeout"},
+				OpenQuestions: []string{},
+			}).Return(nil)
+
+			_, err := subject.Summarize(config.Thread, true)
+			Expect(err).NotTo(HaveOccurred())
+		})
+		it("summarizes long threads in chunks and merges the partial summaries", func() {
+			subject := factory.buildClientWithoutConfig()
+			subject.Config.MaxTokens = 0
+			subject.Config.SummaryContextWindow = 400
+
+			long := []types.Message{{Role: client.SystemRole, Content: config.Role}}
+			for i := 0; i < 20; i++ {
+				long = append(long, types.Message{Role: client.UserRole, Content: strings.Repeat(fmt.Sprintf("question %d ", i), 5)})
+			}
+
+			mockHistoryStore.EXPECT().ReadThread(config.Thread).Return(long, nil)
+			recordRequests(summary)
+
+			result, err := subject.Summarize(config.Thread, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Topics).To(Equal([]string{"pgbouncer timeouts"}))
+
+			Expect(len(requests)).To(BeNumerically(">", 2))
+			Expect(requests[0].Messages[0].Content).To(Equal(client.SummaryPrompt))
+			Expect(requests[len(requests)-1].Messages[0].Content).To(Equal(client.SummaryMergePrompt))
+
+			for _, request := range requests {
+				Expect(utils.EstimateTokens(request.Messages[1].Content)).To(BeNumerically("<=", 400))
+			}
+		})
+		it("splits messages that don't fit the context window on their own", func() {
+			subject := factory.buildClientWithoutConfig()
+			subject.Config.MaxTokens = 0
+			subject.Config.SummaryContextWindow = 400
+
+			mockHistoryStore.EXPECT().ReadThread(config.Thread).Return([]types.Message{
+				{Role: client.UserRole, Content: strings.Repeat("word ", 500)},
+			}, nil)
+			recordRequests(summary)
+
+			_, err := subject.Summarize(config.Thread, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(len(requests)).To(BeNumerically(">", 2))
+		})
+		it("returns an error for answers that are not a summary and for empty threads", func() {
+			subject := factory.buildClientWithoutConfig()
+			subject.Config.SummaryContextWindow = 8192
+
+			mockHistoryStore.EXPECT().ReadThread(config.Thread).Return(conversation, nil)
+			recordRequests("not json")
+
+			_, err := subject.Summarize(config.Thread, false)
+			Expect(err).To(MatchError(ContainSubstring("the summary is not valid JSON")))
+
+			mockHistoryStore.EXPECT().ReadThread("empty").Return(conversation[:1], nil)
+
+			_, err = subject.Summarize("empty", false)
+			Expect(err).To(MatchError("thread empty has nothing to summarize"))
+		})
+	})
+
+	when("QueryJSON()", func() {
+		const (
+			invalid = `{"name": "chatgpt",}`
+			valid   = `{"name": "chatgpt"}`
+		)
+
+		createResponse := func(content string, tokens int) []byte {
+			response := &types.CompletionsResponse{
+				Choices: []types.Choice{{
+					Message: types.Message{
+						Role:    client.AssistantRole,
+						Content: content,
+					},
+					FinishReason: "stop",
+				}},
+				Usage: types.Usage{TotalTokens: tokens},
+			}
+
+			respBytes, err := json.Marshal(response)
+			Expect(err).NotTo(HaveOccurred())
+			return respBytes
+		}
+
+		correction := func(content string) types.Message {
+			var target json.RawMessage
+			err := json.Unmarshal([]byte(content), &target)
+			Expect(err).To(HaveOccurred())
+
+			return types.Message{
+				Role:    client.UserRole,
+				Content: fmt.Sprintf(client.JSONRetryPrompt, err),
+			}
+		}
+
+		it("decodes a valid answer into the target", func() {
+			factory.withoutHistory()
+			subject := factory.buildClientWithoutConfig()
+
+			messages := createMessages(nil, query)
+			body, err := createBody(messages, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			mockCaller.EXPECT().Post(subject.Config.URL+subject.Config.CompletionsPath, body, false).
+				Return(createResponse(valid, 10), nil)
+			expectUpdate(append(messages, types.Message{
+				Role:    client.AssistantRole,
+				Content: valid,
+				Model:   config.Model,
+			}))
+
+			var target struct {
+				Name string `json:"name"`
+			}
+
+			result, usage, err := subject.QueryJSON(query, &target)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal(valid))
+			Expect(usage).To(Equal(10))
+			Expect(target.Name).To(Equal("chatgpt"))
+		})
+		it("asks for a correction and only stores the final answer", func() {
+			factory.withoutHistory()
+			subject := factory.buildClientWithoutConfig()
+			subject.Config.JSONRetries = 2
+
+			messages := createMessages(nil, query)
+			firstBody, err := createBody(messages, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			retry := append(append([]types.Message{}, messages...),
+				types.Message{Role: client.AssistantRole, Content: invalid},
+				correction(invalid),
+			)
+			secondBody, err := createBody(retry, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			gomock.InOrder(
+				mockCaller.EXPECT().Post(subject.Config.URL+subject.Config.CompletionsPath, firstBody, false).
+					Return(createResponse(invalid, 10), nil),
+				mockCaller.EXPECT().Post(subject.Config.URL+subject.Config.CompletionsPath, secondBody, false).
+					Return(createResponse(valid, 15), nil),
+			)
+			expectUpdate(append(messages, types.Message{
+				Role:    client.AssistantRole,
+				Content: valid,
+				Model:   config.Model,
+			}))
+
+			result, usage, err := subject.QueryJSON(query, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal(valid))
+			Expect(usage).To(Equal(25))
+		})
+		it("stores the failed attempts when configured to", func() {
+			factory.withoutHistory()
+			subject := factory.buildClientWithoutConfig()
+			subject.Config.JSONRetries = 1
+			subject.Config.KeepJSONRetries = true
+
+			messages := createMessages(nil, query)
+
+			gomock.InOrder(
+				mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).Return(createResponse(invalid, 10), nil),
+				mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).Return(createResponse(valid, 15), nil),
+			)
+			expectUpdate(append(messages,
+				types.Message{Role: client.AssistantRole, Content: invalid},
+				correction(invalid),
+				types.Message{Role: client.AssistantRole, Content: valid, Model: config.Model},
+			))
+
+			_, _, err := subject.QueryJSON(query, nil)
+			Expect(err).NotTo(HaveOccurred())
+		})
+		it("returns an error and leaves the history untouched when the retries are exhausted", func() {
+			factory.withoutHistory()
+			subject := factory.buildClientWithoutConfig()
+			subject.Config.JSONRetries = 1
+
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).Return(createResponse(invalid, 10), nil).Times(2)
+			mockHistoryStore.EXPECT().Update(gomock.Any()).Times(0)
+
+			result, usage, err := subject.QueryJSON(query, nil)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("invalid JSON after 2 attempt(s)"))
+			Expect(result).To(Equal(invalid))
+			Expect(usage).To(Equal(20))
+		})
+		it("requests a JSON object when JSON mode is enabled", func() {
+			factory.withoutHistory()
+			subject := factory.buildClientWithoutConfig()
+			subject.Config.JSONMode = true
+
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), false).DoAndReturn(func(_ string, body []byte, _ bool) ([]byte, error) {
+				var request types.CompletionsRequest
+				Expect(json.Unmarshal(body, &request)).To(Succeed())
+				Expect(request.ResponseFormat).To(Equal(&types.ResponseFormat{Type: client.ResponseFormatJSON}))
+				return createResponse(valid, 10), nil
+			})
+			expectUpdate(gomock.Any())
+
+			_, _, err := subject.QueryJSON(query, nil)
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+	when("Stream()", func() {
+		var (
+			body     []byte
+			messages []types.Message
+			err      error
+		)
+
+		it("throws an error when the http callout fails", func() {
+			factory.withoutHistory()
+			subject := factory.buildClientWithoutConfig()
+
+			messages = createMessages(nil, query)
+			body, err = createBody(messages, true)
+			Expect(err).NotTo(HaveOccurred())
+
+			errorMsg := "error message"
+			mockCaller.EXPECT().PostStream(subject.Config.URL+subject.Config.CompletionsPath, body).Return(nil, errors.New(errorMsg))
+
+			err := subject.Stream(query)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal(errorMsg))
+		})
+		when("a valid http response is received", func() {
+			const answer = "answer"
+
+			usage := types.Usage{PromptTokens: 412, CompletionTokens: 128, TotalTokens: 540}
+
+			testValidHTTPResponse := func(subject *client.Client, history []types.Message, expectedBody []byte) {
+				messages = createMessages(nil, query)
+				body, err = createBody(messages, true)
+				Expect(err).NotTo(HaveOccurred())
+
+				stream := createStream(answer, nil)
+				mockCaller.EXPECT().PostStream(subject.Config.URL+subject.Config.CompletionsPath, expectedBody).Return(stream, nil)
+
+				messages = createMessages(history, query)
+
+				expectUpdate(append(messages, types.Message{
+					Role:    client.AssistantRole,
+					Content: answer,
+					Model:   config.Model,
+				}))
+
+				err := subject.Stream(query)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(subject.Usage()).To(BeZero())
+				Expect(stream.closed).To(BeTrue())
+			}
+
+			it("returns the expected result for an empty history", func() {
+				factory.withHistory(nil)
+				subject := factory.buildClientWithoutConfig()
+
+				messages = createMessages(nil, query)
+				body, err = createBody(messages, true)
+				Expect(err).NotTo(HaveOccurred())
+
+				testValidHTTPResponse(subject, nil, body)
+			})
+			it("returns the expected result for a non-empty history", func() {
+				history := []types.Message{
+					{
+						Role:    client.SystemRole,
+						Content: config.Role,
+					},
+					{
+						Role:    client.UserRole,
+						Content: "question 1",
+					},
+					{
+						Role:    client.AssistantRole,
+						Content: "answer 1",
+					},
+				}
+				factory.withHistory(history)
+				subject := factory.buildClientWithoutConfig()
+
+				messages = createMessages(history, query)
+				body, err = createBody(messages, true)
+				Expect(err).NotTo(HaveOccurred())
+
+				testValidHTTPResponse(subject, history, body)
+			})
+			it("reports the usage the stream ends with", func() {
+				factory.withoutHistory()
+				subject := factory.buildClientWithoutConfig()
+
+				messages = createMessages(nil, query)
+				body, err = createBody(messages, true)
+				Expect(err).NotTo(HaveOccurred())
+
+				mockCaller.EXPECT().PostStream(subject.Config.URL+subject.Config.CompletionsPath, body).Return(createStream(answer, &usage), nil)
+				expectUpdate(append(messages, types.Message{
+					Role:    client.AssistantRole,
+					Content: answer,
+					Model:   config.Model,
+				}))
+
+				Expect(subject.Stream(query)).To(Succeed())
+				Expect(subject.Usage()).To(Equal(usage))
+			})
+			it("asks for the usage of the stream to show it, without tracking the token usage", func() {
+				factory.withoutHistory()
+				subject := factory.buildClientWithoutConfig()
+				subject.Config.TrackTokenUsage, subject.Config.ShowUsage = false, true
+
+				mockCaller.EXPECT().PostStream(gomock.Any(), gomock.Any()).DoAndReturn(func(url string, body []byte) (io.ReadCloser, error) {
+					var request types.CompletionsRequest
+					Expect(json.Unmarshal(body, &request)).To(Succeed())
+					Expect(request.StreamOptions).To(Equal(&types.StreamOptions{IncludeUsage: true}))
+					return createStream(answer, &usage), nil
+				})
+				expectUpdate(gomock.Any())
+
+				Expect(subject.Stream(query)).To(Succeed())
+				Expect(subject.Usage()).To(Equal(usage))
+			})
+			it("writes the answer to the stream output as it arrives", func() {
+				factory.withoutHistory()
+
+				var output bytes.Buffer
+				subject := factory.buildClientWithoutConfig().WithStreamOutput(&output)
+
+				mockCaller.EXPECT().PostStream(gomock.Any(), gomock.Any()).Return(createStream(answer, nil), nil)
+				expectUpdate(gomock.Any())
+
+				Expect(subject.Stream(query)).To(Succeed())
+				Expect(output.String()).To(Equal(answer))
+			})
+		})
+		it("closes a stream that breaks off and keeps the partial answer out of the history", func() {
+			factory.withoutHistory()
+			subject := factory.buildClientWithoutConfig()
+
+			stream := &streamBody{Reader: io.MultiReader(createStream("partial", nil), iotest.ErrReader(errors.New("connection reset")))}
+			mockCaller.EXPECT().PostStream(gomock.Any(), gomock.Any()).Return(stream, nil)
+
+			err := subject.Stream(query)
+			Expect(err).To(MatchError(ContainSubstring("connection reset")))
+			Expect(stream.closed).To(BeTrue())
+		})
+		it("keeps the part of the answer that arrived before the stream was canceled", func() {
+			factory.withoutHistory()
+			subject := factory.buildClientWithoutConfig()
+
+			stream := &streamBody{Reader: io.MultiReader(createStream("partial", nil), iotest.ErrReader(context.Canceled))}
+			mockCaller.EXPECT().PostStream(gomock.Any(), gomock.Any()).Return(stream, nil)
+			expectUpdate(gomock.Any())
+
+			err := subject.Stream(query)
+			Expect(err).To(MatchError(context.Canceled))
+			Expect(stream.closed).To(BeTrue())
+			Expect(subject.History[len(subject.History)-1].Content).To(Equal("partial"))
+		})
+	})
+	when("Warmup()", func() {
+		it("sets up the connection to the API with a request of its models endpoint", func() {
+			var requested []string
+			server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+				requested = append(requested, r.Method+" "+r.URL.Path)
+			}))
+			defer server.Close()
+
+			cfg := MockConfig()
+			cfg.URL = server.URL
+
+			subject := client.New(http.RealCallerFactory, history.NewMemoryStore(), cfg, commandLineMode).WithEphemeralHistory()
+			Expect(subject.Warmup(context.Background())).To(Succeed())
+			Expect(requested).To(Equal([]string{"HEAD " + cfg.ModelsPath}))
+		})
+		it("does nothing when the caller can't warm up", func() {
+			Expect(factory.buildClientWithoutConfig().Warmup(context.Background())).To(Succeed())
+		})
+	})
+
+	when("ListModels()", func() {
+		it("throws an error when the http callout fails", func() {
+			subject := factory.buildClientWithoutConfig()
+
+			errorMsg := "error message"
+			mockCaller.EXPECT().Get(subject.Config.URL+subject.Config.ModelsPath).Return(nil, errors.New(errorMsg))
+
+			_, err := subject.ListModels()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal(errorMsg))
+		})
+		it("throws an error when the response is empty", func() {
+			subject := factory.buildClientWithoutConfig()
+
+			mockCaller.EXPECT().Get(subject.Config.URL+subject.Config.ModelsPath).Return(nil, nil)
+
+			_, err := subject.ListModels()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("empty response"))
+		})
+		it("throws an error when the response is a malformed json", func() {
+			subject := factory.buildClientWithoutConfig()
+
+			malformed := `{"invalid":"json"` // missing closing brace
+			mockCaller.EXPECT().Get(subject.Config.URL+subject.Config.ModelsPath).Return([]byte(malformed), nil)
+
+			_, err := subject.ListModels()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).Should(HavePrefix("failed to decode response:"))
+		})
+		it("filters gpt models as expected", func() {
+			subject := factory.buildClientWithoutConfig()
+
+			response, err := utils.FileToBytes("models.json")
+			Expect(err).NotTo(HaveOccurred())
+
+			mockCaller.EXPECT().Get(subject.Config.URL+subject.Config.ModelsPath).Return(response, nil)
+
+			result, err := subject.ListModels()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).NotTo(BeEmpty())
+			Expect(result).To(HaveLen(3))
+			Expect(result[0]).To(Equal("* gpt-3.5-turbo (current)"))
+			Expect(result[1]).To(Equal("- gpt-3.5-turbo-0301"))
+		})
+		it("returns the IDs of the models without marking the current one with ModelIDs()", func() {
+			subject := factory.buildClientWithoutConfig()
+
+			response, err := utils.FileToBytes("models.json")
+			Expect(err).NotTo(HaveOccurred())
+
+			mockCaller.EXPECT().Get(subject.Config.URL+subject.Config.ModelsPath).Return(response, nil)
+
+			result, err := subject.ModelIDs()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(HaveLen(3))
+			Expect(result[:2]).To(Equal([]string{"gpt-3.5-turbo", "gpt-3.5-turbo-0301"}))
+		})
+	})
+	when("ProvideContext()", func() {
+		it("updates the history with the provided context", func() {
+			subject := factory.buildClientWithoutConfig()
+
+			context := "This is a story about a dog named Kya. Kya loves to play fetch and swim in the lake."
+			mockHistoryStore.EXPECT().Read().Return(nil, nil).Times(1)
+
+			subject.ProvideContext(context)
+
+			Expect(len(subject.History)).To(Equal(2)) // The system message and the provided context
+
+			systemMessage := subject.History[0]
+			Expect(systemMessage.Role).To(Equal(client.SystemRole))
+			Expect(systemMessage.Content).To(Equal(config.Role))
+
+			contextMessage := subject.History[1]
+			Expect(contextMessage.Role).To(Equal(client.UserRole))
+			Expect(contextMessage.Content).To(Equal(context))
+		})
+	})
+
+	when("Record()", func() {
+		it("stores the content as a message of the user, without sending anything", func() {
+			subject := factory.buildClientWithoutConfig()
+
+			stored := []types.Message{
+				{Role: client.SystemRole, Content: config.Role},
+				{Role: client.UserRole, Content: "suggest a command"},
+				{Role: client.AssistantRole, Content: `{"command": "make"}`},
+			}
+			mockHistoryStore.EXPECT().Read().Return(stored, nil)
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+			mockHistoryStore.EXPECT().Update(gomock.Any()).DoAndReturn(func(update func([]types.Message) []types.Message) error {
+				written := update(stored)
+				Expect(written).To(HaveLen(4))
+				Expect(written[3].Role).To(Equal(client.UserRole))
+				Expect(written[3].Content).To(Equal("it exited with status 2"))
+				return nil
+			})
+
+			subject.Record("it exited with status 2")
+			Expect(subject.History).To(HaveLen(4))
+		})
+	})
+}
+
+func createBody(messages []types.Message, stream bool) ([]byte, error) {
+	req := types.CompletionsRequest{
+		Model:            config.Model,
+		Messages:         messages,
+		Stream:           stream,
+		Temperature:      config.Temperature,
+		TopP:             config.TopP,
+		FrequencyPenalty: config.FrequencyPenalty,
+		MaxTokens:        config.MaxTokens,
+		PresencePenalty:  config.PresencePenalty,
+		Seed:             config.Seed,
+		Stop:             config.Stop,
+	}
+
+	if stream && (config.TrackTokenUsage || config.ShowUsage) {
+		req.StreamOptions = &types.StreamOptions{IncludeUsage: true}
+	}
+
+	return json.Marshal(req)
+}
+
+func createMessages(history []types.Message, query string) []types.Message {
+	var messages []types.Message
+
+	if len(history) == 0 {
+		messages = append(messages, types.Message{
+			Role:    client.SystemRole,
+			Content: config.Role,
+		})
+	} else {
+		messages = history
+	}
+
+	messages = append(messages, types.Message{
+		Role:    client.UserRole,
+		Content: query,
+	})
+
+	return messages
+}
+
+type clientFactory struct {
+	mockHistoryStore *MockHistoryStore
+}
+
+func newClientFactory(mhs *MockHistoryStore) *clientFactory {
+	return &clientFactory{
+		mockHistoryStore: mhs,
+	}
+}
+
+func (f *clientFactory) buildClientWithoutConfig() *client.Client {
+	f.mockHistoryStore.EXPECT().SetThread(config.Thread).Times(1)
+
+	c := client.New(mockCallerFactory, f.mockHistoryStore, MockConfig(), commandLineMode)
+
+	// the messages are stamped with the zero time, so the messages the tests expect don't need a
+	// timestamp of their own
+	return c.WithContextWindow(config.ContextWindow).WithClock(clock.NewFake(time.Time{})).WithStreamOutput(io.Discard)
+}
+
+// tickingClock is a clock that moves by its tick with every reading.
+type tickingClock struct {
+	*clock.Fake
+	tick time.Duration
+}
+
+func (c tickingClock) Now() time.Time {
+	c.Advance(c.tick)
+	return c.Fake.Now()
+}
+
+func (f *clientFactory) withoutHistory() {
+	f.mockHistoryStore.EXPECT().Read().Return(nil, nil).Times(1)
+}
+
+func (f *clientFactory) withHistory(history []types.Message) {
+	f.mockHistoryStore.EXPECT().Read().Return(history, nil).Times(1)
+}
+
+func mockCallerFactory(_ types.Config) http.Caller {
+	return mockCaller
+}
 
 func MockConfig() types.Config {
 	return types.Config{
@@ -549,3 +3271,101 @@ func MockConfig() types.Config {
 		Debug:               false,
 	}
 }
+
+func createRawResponse(content string) []byte {
+	response := &types.CompletionsResponse{
+		Choices: []types.Choice{{
+			Message: types.Message{
+				Role:    client.AssistantRole,
+				Content: content,
+			},
+			FinishReason: "stop",
+		}},
+	}
+
+	respBytes, err := json.Marshal(response)
+	Expect(err).NotTo(HaveOccurred())
+	return respBytes
+}
+
+// recordingMetrics records the metrics it is told about.
+type recordingMetrics struct {
+	calls []string
+}
+
+func (r *recordingMetrics) ObserveRequest(duration time.Duration, model string, status int) {
+	r.calls = append(r.calls, fmt.Sprintf("request %s %s %d", duration, model, status))
+}
+
+func (r *recordingMetrics) ObserveFirstToken(duration time.Duration, model string) {
+	r.calls = append(r.calls, fmt.Sprintf("first token %s %s", duration, model))
+}
+
+func (r *recordingMetrics) ObserveTokens(prompt, completion int, model string) {
+	r.calls = append(r.calls, fmt.Sprintf("tokens %d %d %s", prompt, completion, model))
+}
+
+func (r *recordingMetrics) ObserveRetry(reason string) {
+	r.calls = append(r.calls, "retry "+reason)
+}
+
+// recordingProgress records the starts and the stops of the progress.
+type recordingProgress struct {
+	calls []string
+}
+
+func (r *recordingProgress) Start(model string) {
+	r.calls = append(r.calls, "start "+model)
+}
+
+func (r *recordingProgress) Stop() {
+	r.calls = append(r.calls, "stop")
+}
+
+// streamBody is the body of a streamed response, which remembers whether it was closed.
+type streamBody struct {
+	io.Reader
+	closed bool
+}
+
+func (s *streamBody) Close() error {
+	s.closed = true
+	return nil
+}
+
+// createStream returns a streamed response of the content, which ends with a usage chunk when usage
+// is given.
+func createStream(content string, usage *types.Usage) *streamBody {
+	var result strings.Builder
+
+	for _, word := range strings.SplitAfter(content, " ") {
+		chunk, err := json.Marshal(map[string]interface{}{
+			"choices": []map[string]interface{}{{"delta": map[string]string{"content": word}}},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		fmt.Fprintf(&result, "data: %s\n\n", chunk)
+	}
+
+	if usage != nil {
+		chunk, err := json.Marshal(map[string]interface{}{"choices": []interface{}{}, "usage": usage})
+		Expect(err).NotTo(HaveOccurred())
+		fmt.Fprintf(&result, "data: %s\n\n", chunk)
+	}
+
+	return &streamBody{Reader: strings.NewReader(result.String())}
+}
+
+// expectUpdate expects the history to be updated and applies the update to an empty thread, so the
+// messages that end up in the store can be checked like those passed to Write.
+func expectUpdate(messages interface{}) *gomock.Call {
+	matcher, ok := messages.(gomock.Matcher)
+	if !ok {
+		matcher = gomock.Eq(messages)
+	}
+
+	return mockHistoryStore.EXPECT().Update(gomock.Any()).DoAndReturn(func(update func([]types.Message) []types.Message) error {
+		written := update(nil)
+		ExpectWithOffset(1, matcher.Matches(written)).To(BeTrue(), "unexpected messages written: %v", written)
+		return nil
+	})
+}
@@ -6,8 +6,10 @@ package client_test
 
 import (
 	reflect "reflect"
+	time "time"
 
 	gomock "github.com/golang/mock/gomock"
+	history "github.com/kardolus/chatgpt-cli/history"
 	types "github.com/kardolus/chatgpt-cli/types"
 )
 
@@ -34,6 +36,49 @@ func (m *MockHistoryStore) EXPECT() *MockHistoryStoreMockRecorder {
 	return m.recorder
 }
 
+// Clear mocks base method.
+func (m *MockHistoryStore) Clear(arg0 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Clear", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Clear indicates an expected call of Clear.
+func (mr *MockHistoryStoreMockRecorder) Clear(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Clear", reflect.TypeOf((*MockHistoryStore)(nil).Clear), arg0)
+}
+
+// DeleteMessages mocks base method.
+func (m *MockHistoryStore) DeleteMessages(arg0 string, arg1 []int, arg2 bool) (history.DeleteResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteMessages", arg0, arg1, arg2)
+	ret0, _ := ret[0].(history.DeleteResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteMessages indicates an expected call of DeleteMessages.
+func (mr *MockHistoryStoreMockRecorder) DeleteMessages(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteMessages", reflect.TypeOf((*MockHistoryStore)(nil).DeleteMessages), arg0, arg1, arg2)
+}
+
+// DeleteThread mocks base method.
+func (m *MockHistoryStore) DeleteThread(arg0 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteThread", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteThread indicates an expected call of DeleteThread.
+func (mr *MockHistoryStoreMockRecorder) DeleteThread(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteThread", reflect.TypeOf((*MockHistoryStore)(nil).DeleteThread), arg0)
+}
+
 // GetThread mocks base method.
 func (m *MockHistoryStore) GetThread() string {
 	m.ctrl.T.Helper()
@@ -48,6 +93,51 @@ func (mr *MockHistoryStoreMockRecorder) GetThread() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetThread", reflect.TypeOf((*MockHistoryStore)(nil).GetThread))
 }
 
+// ListThreads mocks base method.
+func (m *MockHistoryStore) ListThreads() ([]history.ThreadInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListThreads")
+	ret0, _ := ret[0].([]history.ThreadInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListThreads indicates an expected call of ListThreads.
+func (mr *MockHistoryStoreMockRecorder) ListThreads() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListThreads", reflect.TypeOf((*MockHistoryStore)(nil).ListThreads))
+}
+
+// MergeThreads mocks base method.
+func (m *MockHistoryStore) MergeThreads(arg0, arg1 string, arg2 history.MergeStrategy) (history.MergeResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MergeThreads", arg0, arg1, arg2)
+	ret0, _ := ret[0].(history.MergeResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MergeThreads indicates an expected call of MergeThreads.
+func (mr *MockHistoryStoreMockRecorder) MergeThreads(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MergeThreads", reflect.TypeOf((*MockHistoryStore)(nil).MergeThreads), arg0, arg1, arg2)
+}
+
+// Prune mocks base method.
+func (m *MockHistoryStore) Prune(arg0 time.Duration, arg1 bool) ([]history.PruneResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Prune", arg0, arg1)
+	ret0, _ := ret[0].([]history.PruneResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Prune indicates an expected call of Prune.
+func (mr *MockHistoryStoreMockRecorder) Prune(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Prune", reflect.TypeOf((*MockHistoryStore)(nil).Prune), arg0, arg1)
+}
+
 // Read mocks base method.
 func (m *MockHistoryStore) Read() ([]types.Message, error) {
 	m.ctrl.T.Helper()
@@ -63,6 +153,22 @@ func (mr *MockHistoryStoreMockRecorder) Read() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Read", reflect.TypeOf((*MockHistoryStore)(nil).Read))
 }
 
+// ReadPage mocks base method.
+func (m *MockHistoryStore) ReadPage(arg0 string, arg1, arg2 int) ([]types.Message, int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReadPage", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]types.Message)
+	ret1, _ := ret[1].(int)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// ReadPage indicates an expected call of ReadPage.
+func (mr *MockHistoryStoreMockRecorder) ReadPage(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadPage", reflect.TypeOf((*MockHistoryStore)(nil).ReadPage), arg0, arg1, arg2)
+}
+
 // ReadThread mocks base method.
 func (m *MockHistoryStore) ReadThread(arg0 string) ([]types.Message, error) {
 	m.ctrl.T.Helper()
@@ -78,6 +184,90 @@ func (mr *MockHistoryStoreMockRecorder) ReadThread(arg0 interface{}) *gomock.Cal
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadThread", reflect.TypeOf((*MockHistoryStore)(nil).ReadThread), arg0)
 }
 
+// RenameThread mocks base method.
+func (m *MockHistoryStore) RenameThread(arg0, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RenameThread", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RenameThread indicates an expected call of RenameThread.
+func (mr *MockHistoryStoreMockRecorder) RenameThread(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RenameThread", reflect.TypeOf((*MockHistoryStore)(nil).RenameThread), arg0, arg1)
+}
+
+// Search mocks base method.
+func (m *MockHistoryStore) Search(arg0 history.SearchOptions, arg1 func(history.SearchMatch) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Search", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Search indicates an expected call of Search.
+func (mr *MockHistoryStoreMockRecorder) Search(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Search", reflect.TypeOf((*MockHistoryStore)(nil).Search), arg0, arg1)
+}
+
+// SetArchived mocks base method.
+func (m *MockHistoryStore) SetArchived(arg0 string, arg1 bool) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetArchived", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetArchived indicates an expected call of SetArchived.
+func (mr *MockHistoryStoreMockRecorder) SetArchived(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetArchived", reflect.TypeOf((*MockHistoryStore)(nil).SetArchived), arg0, arg1)
+}
+
+// SetPinned mocks base method.
+func (m *MockHistoryStore) SetPinned(arg0 string, arg1 []int, arg2 bool) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetPinned", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetPinned indicates an expected call of SetPinned.
+func (mr *MockHistoryStoreMockRecorder) SetPinned(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetPinned", reflect.TypeOf((*MockHistoryStore)(nil).SetPinned), arg0, arg1, arg2)
+}
+
+// SetSummary mocks base method.
+func (m *MockHistoryStore) SetSummary(arg0 string, arg1 types.Summary) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetSummary", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetSummary indicates an expected call of SetSummary.
+func (mr *MockHistoryStoreMockRecorder) SetSummary(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetSummary", reflect.TypeOf((*MockHistoryStore)(nil).SetSummary), arg0, arg1)
+}
+
+// SetTags mocks base method.
+func (m *MockHistoryStore) SetTags(arg0 string, arg1 []string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetTags", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetTags indicates an expected call of SetTags.
+func (mr *MockHistoryStoreMockRecorder) SetTags(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetTags", reflect.TypeOf((*MockHistoryStore)(nil).SetTags), arg0, arg1)
+}
+
 // SetThread mocks base method.
 func (m *MockHistoryStore) SetThread(arg0 string) {
 	m.ctrl.T.Helper()
@@ -90,6 +280,49 @@ func (mr *MockHistoryStoreMockRecorder) SetThread(arg0 interface{}) *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetThread", reflect.TypeOf((*MockHistoryStore)(nil).SetThread), arg0)
 }
 
+// SetTitle mocks base method.
+func (m *MockHistoryStore) SetTitle(arg0, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetTitle", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetTitle indicates an expected call of SetTitle.
+func (mr *MockHistoryStoreMockRecorder) SetTitle(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetTitle", reflect.TypeOf((*MockHistoryStore)(nil).SetTitle), arg0, arg1)
+}
+
+// Stats mocks base method.
+func (m *MockHistoryStore) Stats(arg0 string, arg1 history.DateRange) (history.Stats, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Stats", arg0, arg1)
+	ret0, _ := ret[0].(history.Stats)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Stats indicates an expected call of Stats.
+func (mr *MockHistoryStoreMockRecorder) Stats(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stats", reflect.TypeOf((*MockHistoryStore)(nil).Stats), arg0, arg1)
+}
+
+// Update mocks base method.
+func (m *MockHistoryStore) Update(arg0 func([]types.Message) []types.Message) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Update", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Update indicates an expected call of Update.
+func (mr *MockHistoryStoreMockRecorder) Update(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockHistoryStore)(nil).Update), arg0)
+}
+
 // Write mocks base method.
 func (m *MockHistoryStore) Write(arg0 []types.Message) error {
 	m.ctrl.T.Helper()
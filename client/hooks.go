@@ -0,0 +1,75 @@
+package client
+
+import (
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/types"
+)
+
+// BeforeHook is called with the messages of every request before it is sent. It returns the
+// messages to send instead, which allows hooks to redact or annotate the request, or an error to
+// abort it. The history itself is not affected by the changes of a hook.
+type BeforeHook func(messages []types.Message) ([]types.Message, error)
+
+// AfterHook is called with the final answer of a query or stream and the usage it took. Returning
+// an error fails the call and keeps the answer out of the history.
+type AfterHook func(answer string, usage types.Usage) error
+
+// WithBeforeHook registers a hook that runs before every request. Hooks run in the order in which
+// they were registered and must be safe for concurrent use when QueryAll is used.
+func (c *Client) WithBeforeHook(hook BeforeHook) *Client {
+	c.beforeHooks = append(c.beforeHooks, hook)
+	return c
+}
+
+// WithAfterHook registers a hook that runs after every answer. Hooks run in the order in which they
+// were registered and must be safe for concurrent use when QueryAll is used.
+func (c *Client) WithAfterHook(hook AfterHook) *Client {
+	c.afterHooks = append(c.afterHooks, hook)
+	return c
+}
+
+func (c *Client) runBeforeHooks(messages []types.Message) ([]types.Message, error) {
+	if len(c.beforeHooks) == 0 {
+		return messages, nil
+	}
+
+	// hooks get their own copy so they can't modify the history by accident
+	messages = append([]types.Message(nil), messages...)
+
+	for i, hook := range c.beforeHooks {
+		err := recoverHook("before", i, func() error {
+			var err error
+			messages, err = hook(messages)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return messages, nil
+}
+
+func (c *Client) runAfterHooks(answer string, usage types.Usage) error {
+	for i, hook := range c.afterHooks {
+		if err := recoverHook("after", i, func() error {
+			return hook(answer, usage)
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// recoverHook runs fn and turns a panic into an error, so a misbehaving hook fails the call instead
+// of crashing the program.
+func recoverHook(kind string, index int, fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s hook %d panicked: %v", kind, index+1, r)
+		}
+	}()
+
+	return fn()
+}
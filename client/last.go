@@ -0,0 +1,108 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/types"
+	"time"
+)
+
+// Exchange is a question of a thread and the answer to it. Asked and Answered are zero for the
+// messages of older histories, which didn't record when they were added, and Model is empty for
+// the answers of the histories that didn't record the model that gave them.
+type Exchange struct {
+	Question string    `json:"question"`
+	Answer   string    `json:"answer"`
+	Model    string    `json:"model,omitempty"`
+	Asked    time.Time `json:"asked"`
+	Answered time.Time `json:"answered"`
+}
+
+// Messages returns the question and the answer as the messages of a conversation.
+func (e Exchange) Messages() []types.Message {
+	return []types.Message{
+		{Role: UserRole, Content: e.Question, Timestamp: e.Asked},
+		{Role: AssistantRole, Content: e.Answer, Timestamp: e.Answered, Model: e.Model},
+	}
+}
+
+// NoExchangeError is returned when a thread has no answered question.
+type NoExchangeError struct {
+	Thread string
+}
+
+func (e *NoExchangeError) Error() string {
+	return fmt.Sprintf("thread %s has no answered questions", e.Thread)
+}
+
+// LastAnswer returns the content of the last answer of the current thread, without sending
+// anything.
+func (c *Client) LastAnswer() (string, error) {
+	exchange, err := c.LastExchange(c.historyStore.GetThread())
+	if err != nil {
+		return "", err
+	}
+
+	return exchange.Answer, nil
+}
+
+// LastExchange returns the last answered question of the thread, without sending anything.
+func (c *Client) LastExchange(thread string) (Exchange, error) {
+	exchanges, err := c.LastExchanges(thread, 1)
+	if err != nil {
+		return Exchange{}, err
+	}
+
+	return exchanges[0], nil
+}
+
+// LastExchanges returns up to n of the last answered questions of the thread, oldest first, as
+// they are stored, without sending anything. A question that wasn't answered yet is left out, and
+// the answer of an exchange is the last one given before the next question. A thread without
+// answered questions is a *NoExchangeError.
+func (c *Client) LastExchanges(thread string, n int) ([]Exchange, error) {
+	if n < 1 {
+		return nil, errors.New("the number of exchanges must be at least 1")
+	}
+
+	messages, err := c.historyStore.ReadThread(thread)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		result []Exchange
+		answer *types.Message
+	)
+
+	for i := len(messages) - 1; i >= 0 && len(result) < n; i-- {
+		switch message := messages[i]; message.Role {
+		case AssistantRole:
+			if answer == nil {
+				answer = &messages[i]
+			}
+		case UserRole:
+			if answer != nil {
+				result = append(result, Exchange{
+					Question: message.Content,
+					Answer:   answer.Content,
+					Model:    answer.Model,
+					Asked:    message.Timestamp,
+					Answered: answer.Timestamp,
+				})
+				answer = nil
+			}
+		}
+	}
+
+	if len(result) == 0 {
+		return nil, &NoExchangeError{Thread: thread}
+	}
+
+	// the exchanges were collected from the end of the thread
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+
+	return result, nil
+}
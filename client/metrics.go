@@ -0,0 +1,42 @@
+package client
+
+import (
+	"github.com/kardolus/chatgpt-cli/http"
+	"github.com/kardolus/chatgpt-cli/metrics"
+	"github.com/kardolus/chatgpt-cli/types"
+	"io"
+	"time"
+)
+
+// WithMetrics reports the durations, statuses and token usage of the requests to m, along with
+// the time to the first content of streamed answers.
+func (c *Client) WithMetrics(m metrics.Metrics) *Client {
+	c.metrics = m
+	return c
+}
+
+// observeRequest reports the request that started at start and ended with err.
+func (c *Client) observeRequest(start time.Time, model string, err error) {
+	c.metrics.ObserveRequest(c.clock.Now().Sub(start), model, http.StatusOf(err))
+}
+
+func (c *Client) observeTokens(usage types.Usage, model string) {
+	if usage.PromptTokens > 0 || usage.CompletionTokens > 0 {
+		c.metrics.ObserveTokens(usage.PromptTokens, usage.CompletionTokens, model)
+	}
+}
+
+// firstWrite tells when the first content of a stream was written.
+type firstWrite struct {
+	io.Writer
+	now   func() time.Time
+	first time.Time
+}
+
+func (f *firstWrite) Write(p []byte) (int, error) {
+	if f.first.IsZero() && len(p) > 0 {
+		f.first = f.now()
+	}
+
+	return f.Writer.Write(p)
+}
@@ -0,0 +1,74 @@
+package client
+
+import (
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/types"
+)
+
+// ObserverQueueSize is how many writes can wait for the write observers before further writes are
+// dropped for them.
+const ObserverQueueSize = 64
+
+// WriteObserver is told, after the history was written, about the messages an exchange appended to
+// the thread. Rewrites of the conversation, such as Rewind or Regenerate, aren't appends and aren't
+// observed.
+type WriteObserver func(thread string, messages []types.Message) error
+
+// observedWrite is a write that waits for the observers.
+type observedWrite struct {
+	thread   string
+	messages []types.Message
+}
+
+// WithWriteObserver registers an observer of the writes of the history. Observers run in the order
+// in which they were registered, one write at a time, outside of the exchange, so a slow observer
+// doesn't hold up the conversation. When more than ObserverQueueSize writes wait for them, further
+// writes are dropped for the observers. Errors of observers, and dropped writes, are told to the
+// history error reporter rather than failing the exchange. Observers must be registered before the
+// client is used, and FlushObservers waits for them to catch up.
+func (c *Client) WithWriteObserver(observer WriteObserver) *Client {
+	c.observers = append(c.observers, observer)
+
+	if c.observed == nil {
+		c.observed = make(chan observedWrite, ObserverQueueSize)
+		go c.runObservers()
+	}
+
+	return c
+}
+
+// FlushObservers waits until the observers were told about every write that wasn't dropped, for
+// instance before the program exits.
+func (c *Client) FlushObservers() {
+	c.pending.Wait()
+}
+
+func (c *Client) notifyObservers(thread string, messages []types.Message) {
+	if c.observed == nil || len(messages) == 0 {
+		return
+	}
+
+	// the observers get their own copy, the history keeps changing while they run
+	write := observedWrite{thread: thread, messages: append([]types.Message(nil), messages...)}
+
+	c.pending.Add(1)
+	select {
+	case c.observed <- write:
+	default:
+		c.pending.Done()
+		c.historyError(fmt.Errorf("the write observers fell behind, dropped %d messages of thread %s", len(messages), thread))
+	}
+}
+
+func (c *Client) runObservers() {
+	for write := range c.observed {
+		for i, observer := range c.observers {
+			if err := recoverHook("observer", i, func() error {
+				return observer(write.thread, write.messages)
+			}); err != nil {
+				c.historyError(fmt.Errorf("write observer %d failed for thread %s: %w", i+1, write.thread, err))
+			}
+		}
+		c.pending.Done()
+	}
+}
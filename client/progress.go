@@ -0,0 +1,22 @@
+package client
+
+// Progress shows that the client waits for an answer that isn't streamed, such as the spinner of
+// the progress package. Start is called with the model once the query is sent, and Stop once it is
+// answered or failed, before the answer is returned.
+type Progress interface {
+	Start(model string)
+	Stop()
+}
+
+// WithProgress shows the waits for the answers of Query, QueryJSON and Regenerate with p. Streamed
+// answers show their progress as they arrive.
+func (c *Client) WithProgress(p Progress) *Client {
+	c.progress = p
+	return c
+}
+
+type nopProgress struct{}
+
+func (nopProgress) Start(string) {}
+
+func (nopProgress) Stop() {}
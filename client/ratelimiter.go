@@ -0,0 +1,148 @@
+package client
+
+import (
+	"context"
+	"github.com/kardolus/chatgpt-cli/clock"
+	"sync"
+	"time"
+)
+
+// RateLimiter throttles outgoing requests with two token buckets, one for the number of requests
+// and one for the number of tokens sent per minute. A limit of 0 disables that dimension. The
+// buckets start full, so short bursts up to the limits are sent without delay.
+type RateLimiter struct {
+	mu       sync.Mutex
+	requests *bucket
+	tokens   *bucket
+	clock    clock.Clock
+}
+
+type bucket struct {
+	capacity float64
+	level    float64
+	updated  time.Time
+}
+
+// NewRateLimiter returns a RateLimiter for the given limits. A limit of 0 disables that dimension.
+func NewRateLimiter(requestsPerMinute, tokensPerMinute int) *RateLimiter {
+	limiter := &RateLimiter{clock: clock.Real}
+
+	limiter.requests = newBucket(requestsPerMinute, limiter.clock.Now())
+	limiter.tokens = newBucket(tokensPerMinute, limiter.clock.Now())
+
+	return limiter
+}
+
+// WithClock replaces the clock used to read the time and to wait, which allows tests to run
+// without real delays.
+func (l *RateLimiter) WithClock(clock clock.Clock) *RateLimiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.clock = clock
+
+	for _, b := range []*bucket{l.requests, l.tokens} {
+		if b != nil {
+			b.updated = clock.Now()
+		}
+	}
+
+	return l
+}
+
+// Wait blocks until a request of the given number of tokens can be sent, and then takes it from
+// the buckets. Requests larger than the tokens per minute limit only wait for a full bucket. Wait
+// returns early with the context error when ctx is done. A nil RateLimiter never waits.
+func (l *RateLimiter) Wait(ctx context.Context, tokens int) error {
+	if l == nil {
+		return nil
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		delay := l.reserve(float64(tokens))
+		if delay == 0 {
+			return nil
+		}
+
+		if err := l.clock.Sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// reserve takes the request from the buckets when possible and returns 0. Otherwise it returns how
+// long to wait before trying again.
+func (l *RateLimiter) reserve(tokens float64) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+
+	delay := l.requests.delay(1, now)
+	if d := l.tokens.delay(tokens, now); d > delay {
+		delay = d
+	}
+
+	if delay > 0 {
+		return delay
+	}
+
+	l.requests.take(1)
+	l.tokens.take(tokens)
+
+	return 0
+}
+
+func newBucket(perMinute int, now time.Time) *bucket {
+	if perMinute <= 0 {
+		return nil
+	}
+
+	return &bucket{
+		capacity: float64(perMinute),
+		level:    float64(perMinute),
+		updated:  now,
+	}
+}
+
+// delay refills the bucket up to now and returns how long it takes until amount is available.
+func (b *bucket) delay(amount float64, now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.level += elapsed.Minutes() * b.capacity
+		if b.level > b.capacity {
+			b.level = b.capacity
+		}
+		b.updated = now
+	}
+
+	if amount > b.capacity {
+		amount = b.capacity
+	}
+
+	if b.level >= amount {
+		return 0
+	}
+
+	missing := amount - b.level
+	return time.Duration(missing / b.capacity * float64(time.Minute))
+}
+
+func (b *bucket) take(amount float64) {
+	if b == nil {
+		return
+	}
+
+	if amount > b.capacity {
+		amount = b.capacity
+	}
+
+	b.level -= amount
+}
@@ -0,0 +1,106 @@
+package client_test
+
+import (
+	"context"
+	"github.com/kardolus/chatgpt-cli/client"
+	"github.com/kardolus/chatgpt-cli/clock"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitRateLimiter(t *testing.T) {
+	spec.Run(t, "Testing the rate limiter", testRateLimiter, spec.Report(report.Terminal{}))
+}
+
+func testRateLimiter(t *testing.T, when spec.G, it spec.S) {
+	var fake *clock.Fake
+
+	it.Before(func() {
+		RegisterTestingT(t)
+		// every wait ends at once, moving the time along
+		fake = clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)).AutoAdvance()
+	})
+
+	when("Wait()", func() {
+		it("sends requests within the requests per minute limit without waiting", func() {
+			subject := client.NewRateLimiter(2, 0).WithClock(fake)
+
+			Expect(subject.Wait(context.Background(), 100)).To(Succeed())
+			Expect(subject.Wait(context.Background(), 100)).To(Succeed())
+			Expect(fake.Waits()).To(BeEmpty())
+		})
+		it("delays requests over the requests per minute limit", func() {
+			subject := client.NewRateLimiter(2, 0).WithClock(fake)
+
+			Expect(subject.Wait(context.Background(), 0)).To(Succeed())
+			Expect(subject.Wait(context.Background(), 0)).To(Succeed())
+			Expect(subject.Wait(context.Background(), 0)).To(Succeed())
+
+			Expect(fake.Waits()).To(Equal([]time.Duration{30 * time.Second}))
+		})
+		it("delays requests over the tokens per minute limit", func() {
+			subject := client.NewRateLimiter(0, 1000).WithClock(fake)
+
+			Expect(subject.Wait(context.Background(), 750)).To(Succeed())
+			Expect(subject.Wait(context.Background(), 500)).To(Succeed())
+
+			Expect(fake.Waits()).To(Equal([]time.Duration{15 * time.Second}))
+		})
+		it("refills the buckets as time passes", func() {
+			subject := client.NewRateLimiter(1, 1000).WithClock(fake)
+
+			Expect(subject.Wait(context.Background(), 1000)).To(Succeed())
+			fake.Advance(time.Minute)
+			Expect(subject.Wait(context.Background(), 1000)).To(Succeed())
+
+			Expect(fake.Waits()).To(BeEmpty())
+		})
+		it("waits for the slowest dimension", func() {
+			subject := client.NewRateLimiter(6, 1000).WithClock(fake)
+
+			Expect(subject.Wait(context.Background(), 1000)).To(Succeed())
+			Expect(subject.Wait(context.Background(), 500)).To(Succeed())
+
+			Expect(fake.Waits()).To(Equal([]time.Duration{30 * time.Second}))
+		})
+		it("only waits for a full bucket when a request exceeds the tokens per minute limit", func() {
+			subject := client.NewRateLimiter(0, 1000).WithClock(fake)
+
+			Expect(subject.Wait(context.Background(), 5000)).To(Succeed())
+			Expect(subject.Wait(context.Background(), 5000)).To(Succeed())
+
+			Expect(fake.Waits()).To(Equal([]time.Duration{time.Minute}))
+		})
+		it("returns the context error without waiting when the context is done", func() {
+			subject := client.NewRateLimiter(1, 0).WithClock(fake)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			Expect(subject.Wait(ctx, 0)).To(MatchError(context.Canceled))
+			Expect(fake.Waits()).To(BeEmpty())
+		})
+		it("stops waiting when the context is done", func() {
+			// the time never moves, so only the context ends the wait
+			subject := client.NewRateLimiter(1, 0).WithClock(clock.NewFake(time.Now()))
+
+			Expect(subject.Wait(context.Background(), 0)).To(Succeed())
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+
+			Expect(subject.Wait(ctx, 0)).To(MatchError(context.DeadlineExceeded))
+		})
+		it("never waits when the limiter is nil", func() {
+			var subject *client.RateLimiter
+
+			for i := 0; i < 10; i++ {
+				Expect(subject.Wait(context.Background(), 1000000)).To(Succeed())
+			}
+		})
+	})
+}
@@ -0,0 +1,42 @@
+package client
+
+import (
+	"github.com/kardolus/chatgpt-cli/history"
+	"github.com/kardolus/chatgpt-cli/types"
+)
+
+// RedactionReporter is told about the secrets that were redacted, from the query before it was sent
+// when query is set, or else from the messages before they were stored.
+type RedactionReporter func(redactions []history.Redaction, query bool)
+
+// WithRedactor redacts the secrets in the messages before they are stored, so the history and the
+// context of later queries only hold their placeholders. The query itself is sent as it is, unless
+// RedactQuery is set. The reporter, when given, is told about every redaction.
+func (c *Client) WithRedactor(redactor *history.Redactor, reporter RedactionReporter) *Client {
+	c.redactor = redactor
+	c.reportRedactions = reporter
+	return c
+}
+
+// redactMessages redacts the messages in place and reports what was redacted.
+func (c *Client) redactMessages(messages []types.Message, query bool) {
+	if c.redactor == nil {
+		return
+	}
+
+	var redactions []history.Redaction
+	for i := range messages {
+		content, found := c.redactor.Redact(messages[i].Content)
+		if len(found) == 0 {
+			continue
+		}
+
+		// the token count of the original content no longer applies
+		messages[i].Content, messages[i].Tokens, messages[i].TokenEncoding = content, 0, ""
+		redactions = append(redactions, found...)
+	}
+
+	if len(redactions) > 0 && c.reportRedactions != nil {
+		c.reportRedactions(redactions, query)
+	}
+}
@@ -0,0 +1,151 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/types"
+	"strings"
+)
+
+// ReplayTurn is a question of the replayed thread with the answer the thread has for it and the one
+// the target model gave. Index is the position of the question in the thread.
+type ReplayTurn struct {
+	Index    int         `json:"index"`
+	Question string      `json:"question"`
+	Original string      `json:"original"`
+	Answer   string      `json:"answer"`
+	Usage    types.Usage `json:"usage"`
+}
+
+// ReplayResult holds the turns replayed so far. Done is set once every question was replayed, a
+// result that isn't done can be passed to Replay again to resume it.
+type ReplayResult struct {
+	Thread string       `json:"thread"`
+	Model  string       `json:"model"`
+	Turns  []ReplayTurn `json:"turns"`
+	Done   bool         `json:"done"`
+}
+
+// ReplayOptions tunes Replay.
+type ReplayOptions struct {
+	// Resume is a replay of the same thread and model that stopped part of the way. Its turns are
+	// kept and only the questions after them are sent.
+	Resume *ReplayResult
+	// Progress is called after every turn with the result so far and the number of questions.
+	Progress func(result ReplayResult, total int)
+}
+
+// Replay asks the questions of a stored thread again, one after the other, with the model in place
+// of the configured one. The model gets a context of its own, made of the system prompts of the
+// thread, the questions and its own answers, so it never sees the original answers. The thread
+// itself is neither changed nor made the current thread. The requests go through the rate limiter
+// of the client. When a request fails, or ctx is done, the turns replayed so far are returned
+// along with the error, so the replay can be resumed.
+func (c *Client) Replay(ctx context.Context, thread, model string, opts ReplayOptions) (ReplayResult, error) {
+	c.mu.Lock()
+	cfg := c.Config
+	c.mu.Unlock()
+	cfg.Model = model
+
+	messages, err := c.historyStore.ReadThread(thread)
+	if err != nil {
+		return ReplayResult{}, err
+	}
+
+	questions := 0
+	for _, message := range messages {
+		if message.Role == UserRole {
+			questions++
+		}
+	}
+	if questions == 0 {
+		return ReplayResult{}, fmt.Errorf("thread %s has no questions to replay", thread)
+	}
+
+	result := ReplayResult{Thread: thread, Model: model}
+	if opts.Resume != nil {
+		if opts.Resume.Thread != thread || opts.Resume.Model != model {
+			return ReplayResult{}, fmt.Errorf("can't resume the replay of thread %s against %s as a replay of thread %s against %s",
+				opts.Resume.Thread, opts.Resume.Model, thread, model)
+		}
+		result.Turns = append(result.Turns, opts.Resume.Turns...)
+	}
+
+	replayed := []types.Message{{Role: SystemRole, Content: cfg.Role}}
+	turn := 0
+	for index, message := range messages {
+		switch message.Role {
+		case SystemRole:
+			if index == 0 {
+				replayed[0].Content = message.Content
+			} else {
+				replayed = append(replayed, message)
+			}
+			continue
+		case AssistantRole:
+			continue
+		}
+
+		replayed = append(replayed, message)
+
+		if turn < len(result.Turns) {
+			// resumed turns are taken as they are, they only have to be the same questions
+			resumed := result.Turns[turn]
+			if resumed.Index != index || resumed.Question != message.Content {
+				return ReplayResult{}, fmt.Errorf("can't resume the replay, turn %d is not question %d of thread %s anymore", turn+1, index, thread)
+			}
+			replayed = append(replayed, types.Message{Role: AssistantRole, Content: resumed.Answer})
+			turn++
+			continue
+		}
+
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+
+		replayed = truncateMessages(replayed, cfg.ContextWindow)
+
+		response, err := c.complete(ctx, cfg, replayed)
+		if err != nil {
+			return result, fmt.Errorf("failed to replay question %d of thread %s: %w", index, thread, err)
+		}
+
+		answer := response.Choices[0].Message.Content
+		replayed = append(replayed, types.Message{Role: AssistantRole, Content: answer})
+
+		result.Turns = append(result.Turns, ReplayTurn{
+			Index:    index,
+			Question: message.Content,
+			Original: originalAnswer(messages[index+1:]),
+			Answer:   answer,
+			Usage:    response.Usage,
+		})
+		turn++
+
+		if opts.Progress != nil {
+			opts.Progress(result, questions)
+		}
+	}
+
+	if turn < len(result.Turns) {
+		return ReplayResult{}, fmt.Errorf("can't resume the replay, thread %s has fewer questions than the replay", thread)
+	}
+
+	result.Done = true
+	return result, nil
+}
+
+// originalAnswer returns the answers that follow a question, up to the next question.
+func originalAnswer(messages []types.Message) string {
+	var answers []string
+	for _, message := range messages {
+		if message.Role == UserRole {
+			break
+		}
+		if message.Role == AssistantRole {
+			answers = append(answers, message.Content)
+		}
+	}
+
+	return strings.Join(answers, "\n\n")
+}
@@ -0,0 +1,48 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/types"
+)
+
+// MaxStopSequences is how many stop sequences the API accepts.
+const MaxStopSequences = 4
+
+// ValidateSampling checks the sampling parameters of the config against the ranges the API
+// accepts, so a value out of range fails before a request is sent. The errors name the flag that
+// sets the parameter, the config key being the flag with underscores.
+func ValidateSampling(cfg types.Config) error {
+	ranges := []struct {
+		flag     string
+		value    float64
+		min, max float64
+	}{
+		{"--temperature", cfg.Temperature, 0, 2},
+		{"--top-p", cfg.TopP, 0, 1},
+		{"--frequency-penalty", cfg.FrequencyPenalty, -2, 2},
+		{"--presence-penalty", cfg.PresencePenalty, -2, 2},
+	}
+
+	for _, r := range ranges {
+		if r.value < r.min || r.value > r.max {
+			return fmt.Errorf("invalid %s %g, use a value from %g to %g", r.flag, r.value, r.min, r.max)
+		}
+	}
+
+	if cfg.MaxTokens < 1 {
+		return fmt.Errorf("invalid --max-tokens %d, use at least 1", cfg.MaxTokens)
+	}
+
+	if len(cfg.Stop) > MaxStopSequences {
+		return fmt.Errorf("too many --stop sequences, the API accepts up to %d of them", MaxStopSequences)
+	}
+
+	for _, stop := range cfg.Stop {
+		if stop == "" {
+			return errors.New("invalid --stop sequence, it can't be empty")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/history"
+	"github.com/kardolus/chatgpt-cli/utils"
+	"io/fs"
+	"time"
+)
+
+const (
+	// SessionContinue continues the current thread, whenever it was last used.
+	SessionContinue = "continue"
+	// SessionNew starts a new thread for every query.
+	SessionNew = "new"
+	// SessionRecent continues the current thread while its last message is more recent than the
+	// window of the policy, and starts a new thread otherwise.
+	SessionRecent = "recent"
+)
+
+// SessionPolicy decides whether a query continues the current thread or starts a new one. The zero
+// policy continues the current thread.
+type SessionPolicy struct {
+	Mode   string
+	Window time.Duration
+}
+
+// WithSessionPolicy sets the policy StartSession applies.
+func (c *Client) WithSessionPolicy(policy SessionPolicy) *Client {
+	c.sessionPolicy = policy
+	return c
+}
+
+// StartSession applies the session policy before a query. When the policy starts a new thread, the
+// client converses in it from then on and StartSession returns true. The last message of a thread
+// is when the thread was last written, as ListThreads reports it.
+func (c *Client) StartSession() (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch c.sessionPolicy.Mode {
+	case "", SessionContinue:
+		return false, nil
+	case SessionNew:
+	case SessionRecent:
+		threads, err := c.historyStore.ListThreads()
+		if err != nil {
+			return false, err
+		}
+
+		// a thread that wasn't written yet has nothing to continue, so it is as good as a new one
+		recent := true
+		for _, thread := range threads {
+			if thread.Name == c.Config.Thread {
+				recent = c.clock.Now().Sub(thread.Updated) < c.sessionPolicy.Window
+				break
+			}
+		}
+
+		if recent {
+			return false, nil
+		}
+	default:
+		return false, fmt.Errorf("unknown session policy %q, use %s, %s or %s", c.sessionPolicy.Mode, SessionContinue, SessionNew, SessionRecent)
+	}
+
+	c.switchThread(utils.GenerateUniqueSlug(CommandThreadPrefix))
+
+	return true, nil
+}
+
+// NewSession starts a new thread whatever the session policy says, and converses in it from then
+// on. The thread is named name, or gets a unique name with the prefix when name is empty. A thread
+// that already exists is refused, the sessions before are left untouched. NewSession returns the
+// name of the thread.
+func (c *Client) NewSession(name, prefix string) (string, error) {
+	if name == "" {
+		name = utils.GenerateUniqueSlug(prefix)
+	} else if err := history.ValidateThread(name); err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// without a history directory, there is no thread yet
+	threads, err := c.historyStore.ListThreads()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+
+	for _, thread := range threads {
+		if thread.Name == name {
+			return "", fmt.Errorf("thread %s already exists, a new session needs the name of another one", name)
+		}
+	}
+
+	c.switchThread(name)
+
+	return name, nil
+}
+
+// switchThread makes the thread the current one, the history of the thread before is left behind.
+func (c *Client) switchThread(name string) {
+	c.Config.Thread = name
+	c.historyStore.SetThread(name)
+	c.History, c.synced, c.unsaved = nil, nil, 0
+}
@@ -0,0 +1,64 @@
+package client
+
+import (
+	"github.com/kardolus/chatgpt-cli/history"
+	"strings"
+)
+
+// price is what a model costs in US dollars per million prompt and completion tokens.
+type price struct {
+	prompt     float64
+	completion float64
+}
+
+// prices are the list prices of the models the cost of a history can be estimated for. Dated
+// versions of a model, such as gpt-4o-2024-08-06, cost what the model does.
+var prices = map[string]price{
+	"gpt-3.5-turbo": {0.50, 1.50},
+	"gpt-4":         {30, 60},
+	"gpt-4-turbo":   {10, 30},
+	"gpt-4o":        {2.50, 10},
+	"gpt-4o-mini":   {0.15, 0.60},
+	"o1":            {15, 60},
+	"o1-mini":       {3, 12},
+}
+
+// EstimateCost returns what the tokens cost with the model, and false when the price of the model
+// is unknown.
+func EstimateCost(model string, promptTokens, completionTokens int) (float64, bool) {
+	var (
+		match string
+		found price
+	)
+
+	// the longest name wins, so gpt-4o-mini isn't priced as gpt-4o
+	for name, p := range prices {
+		if (model == name || strings.HasPrefix(model, name+"-")) && len(name) > len(match) {
+			match, found = name, p
+		}
+	}
+
+	if match == "" {
+		return 0, false
+	}
+
+	return (float64(promptTokens)*found.prompt + float64(completionTokens)*found.completion) / 1_000_000, true
+}
+
+// Stats adds up the messages of the thread, or of all threads when the thread is empty, that were
+// added in the date range. The cost is estimated with the prices of the configured model, since
+// the history doesn't keep which model answered, and counts every message once rather than every
+// time it was sent along with the conversation.
+func (c *Client) Stats(thread string, dates history.DateRange) (history.Stats, error) {
+	stats, err := c.historyStore.Stats(thread, dates)
+	if err != nil {
+		return history.Stats{}, err
+	}
+
+	stats.Model = c.Config.Model
+	if cost, ok := EstimateCost(c.Config.Model, stats.PromptTokens, stats.CompletionTokens); ok {
+		stats.Cost = &cost
+	}
+
+	return stats, nil
+}
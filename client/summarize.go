@@ -0,0 +1,184 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/types"
+	"github.com/kardolus/chatgpt-cli/utils"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	SummaryPrompt = "Summarize the conversation below. Answer with a JSON object with the keys topics, decisions and " +
+		"open_questions, each a list of short strings: the topics discussed, the decisions made and the questions left open."
+	SummaryMergePrompt = "The JSON objects below summarize consecutive parts of one conversation. Merge them into a " +
+		"single JSON object with the keys topics, decisions and open_questions, each a list of short strings without duplicates."
+)
+
+// Summarize asks the summary model for the topics discussed in the thread, the decisions made and
+// the questions left open. A thread too long for the context window of the summary model is
+// summarized in chunks, and the partial summaries are merged. Nothing is added to the thread; with
+// save the summary is stored with it and shows up when the threads are listed.
+func (c *Client) Summarize(thread string, save bool) (types.Summary, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	messages, err := c.historyStore.ReadThread(thread)
+	if err != nil {
+		return types.Summary{}, err
+	}
+
+	var lines []string
+	for _, message := range messages {
+		if message.Role == SystemRole || strings.TrimSpace(message.Content) == "" {
+			continue
+		}
+		lines = append(lines, message.Role+": "+message.Content)
+	}
+
+	if len(lines) == 0 {
+		return types.Summary{}, fmt.Errorf("thread %s has nothing to summarize", thread)
+	}
+
+	cfg := c.Config
+	if cfg.SummaryModel != "" {
+		cfg.Model = cfg.SummaryModel
+	}
+	if cfg.SummaryContextWindow > 0 {
+		cfg.ContextWindow = cfg.SummaryContextWindow
+	}
+	cfg.JSONMode = true
+
+	// the prompt and the answer have to fit next to every chunk
+	budget := calculateEffectiveContextWindow(cfg.ContextWindow, MaxTokenBufferPercentage) - cfg.MaxTokens -
+		utils.EstimateTokens(SummaryPrompt+SummaryMergePrompt)
+	if budget <= 0 {
+		return types.Summary{}, fmt.Errorf("a context window of %d tokens leaves no room for the conversation to summarize", cfg.ContextWindow)
+	}
+
+	c.usage = types.Usage{}
+
+	var partials []string
+	for _, part := range chunk(splitLines(lines, budget), budget, 1) {
+		summary, err := c.summarize(cfg, SummaryPrompt, part)
+		if err != nil {
+			return types.Summary{}, err
+		}
+		partials = append(partials, summary)
+	}
+
+	// every round merges at least two summaries, so the merging ends
+	for len(partials) > 1 {
+		var merged []string
+		for _, group := range chunk(partials, budget, 2) {
+			if len(group) == 1 {
+				merged = append(merged, group[0])
+				continue
+			}
+
+			summary, err := c.summarize(cfg, SummaryMergePrompt, group)
+			if err != nil {
+				return types.Summary{}, err
+			}
+			merged = append(merged, summary)
+		}
+		partials = merged
+	}
+
+	var result types.Summary
+	if err := json.Unmarshal([]byte(partials[0]), &result); err != nil {
+		return types.Summary{}, err
+	}
+
+	if save {
+		if err := c.historyStore.SetSummary(thread, result); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// summarize sends the prompt with the parts and returns the summary the model answers with, as
+// JSON. The request is made outside the conversation, so the history is left alone.
+func (c *Client) summarize(cfg types.Config, prompt string, parts []string) (string, error) {
+	response, err := c.complete(context.Background(), cfg, []types.Message{
+		{Role: SystemRole, Content: prompt},
+		{Role: UserRole, Content: strings.Join(parts, "\n\n")},
+	})
+	c.usage = addUsage(c.usage, response.Usage)
+	if err != nil {
+		return "", err
+	}
+
+	var summary types.Summary
+	if err := json.Unmarshal([]byte(response.Choices[0].Message.Content), &summary); err != nil {
+		return "", fmt.Errorf("the summary is not valid JSON: %w", err)
+	}
+
+	result, err := json.Marshal(summary)
+	return string(result), err
+}
+
+// chunk groups the lines, in order, into chunks of at most budget tokens. A chunk holds at least
+// minimum lines even when they don't fit, the last chunk excepted.
+func chunk(lines []string, budget, minimum int) [][]string {
+	var (
+		result  [][]string
+		current []string
+		tokens  int
+	)
+
+	for _, line := range lines {
+		count := utils.EstimateTokens(line)
+		if len(current) >= minimum && tokens+count > budget {
+			result = append(result, current)
+			current, tokens = nil, 0
+		}
+
+		current = append(current, line)
+		tokens += count
+	}
+
+	if len(current) > 0 {
+		result = append(result, current)
+	}
+
+	return result
+}
+
+// splitLines splits the lines that don't fit the budget on their own into pieces that do, at word
+// boundaries.
+func splitLines(lines []string, budget int) []string {
+	var result []string
+
+	for _, line := range lines {
+		if utils.EstimateTokens(line) <= budget {
+			result = append(result, line)
+			continue
+		}
+
+		// EstimateTokens counts half a token per character and per word
+		var (
+			piece  []string
+			weight int
+		)
+		for _, word := range strings.Fields(line) {
+			wordWeight := utf8.RuneCountInString(word) + 1
+			if len(piece) > 0 && (weight+wordWeight)/2 > budget {
+				result = append(result, strings.Join(piece, " "))
+				piece, weight = nil, 0
+			}
+			piece = append(piece, word)
+			weight += wordWeight
+		}
+
+		if len(piece) > 0 {
+			result = append(result, strings.Join(piece, " "))
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,129 @@
+package client
+
+import (
+	"context"
+	"github.com/kardolus/chatgpt-cli/http"
+	"github.com/kardolus/chatgpt-cli/types"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	TitlePrompt = "Write a title of 5 to 8 words for the conversation below. Answer with the title only, " +
+		"without quotes and without a period at the end."
+	titleMaxTokens = 24
+	titleMaxWords  = 8
+	titleMaxRunes  = 60
+	// titleInputRunes caps how much of the question and the answer the title model gets to see
+	titleInputRunes = 1000
+)
+
+// SetTitle gives the thread a title, replacing the one it was given automatically.
+func (c *Client) SetTitle(thread, title string) error {
+	return c.historyStore.SetTitle(thread, strings.Join(strings.Fields(title), " "))
+}
+
+// titleThread gives the thread a title after its first exchange, when AutoTitle is set. The title
+// model is asked for a short title; when that fails the title is made from the question instead.
+// Titling never fails the query it follows, a thread that can't be titled simply has no title. The
+// request of the title is bound to ctx, the context of the query, so it is canceled along with it.
+func (c *Client) titleThread(ctx context.Context) {
+	if !c.Config.AutoTitle || c.Config.OmitHistory || c.unsaved > 0 || len(c.History) < 2 {
+		return
+	}
+
+	question, answer := c.History[len(c.History)-2], c.History[len(c.History)-1]
+	if question.Role != UserRole || answer.Role != AssistantRole {
+		return
+	}
+
+	for _, message := range c.History[:len(c.History)-1] {
+		if message.Role == AssistantRole {
+			return
+		}
+	}
+
+	// a title that was set already, for instance before the thread was rewound, is kept
+	threads, err := c.historyStore.ListThreads()
+	if err != nil {
+		return
+	}
+	for _, thread := range threads {
+		if thread.Name == c.Config.Thread && thread.Title != "" {
+			return
+		}
+	}
+
+	title := c.requestTitle(ctx, question.Content, answer.Content)
+	if title == "" {
+		title = truncateTitle(question.Content)
+	}
+
+	_ = c.historyStore.SetTitle(c.Config.Thread, title)
+}
+
+// requestTitle asks the title model for a title of the exchange, it returns an empty title when the
+// model can't be reached or answers with nothing usable. The request is a query of its own, with
+// the timeout of a query.
+func (c *Client) requestTitle(ctx context.Context, question, answer string) string {
+	cfg := c.Config
+	if cfg.TitleModel != "" {
+		cfg.Model = cfg.TitleModel
+	}
+	cfg.MaxTokens = titleMaxTokens
+	cfg.JSONMode = false
+
+	messages := createTitleMessages(question, answer)
+	ctx, end := c.startQuery(ctx, cfg.Model, messages)
+	response, err := c.fetchCompletion(ctx, cfg, messages)
+	end(finishReason(response), response.Usage, http.QueryTimeout(ctx, err))
+	if err != nil {
+		return ""
+	}
+
+	return cleanTitle(response.Choices[0].Message.Content)
+}
+
+func createTitleMessages(question, answer string) []types.Message {
+	return []types.Message{
+		{Role: SystemRole, Content: TitlePrompt},
+		{Role: UserRole, Content: "Question: " + truncateRunes(question, titleInputRunes) + "\n\nAnswer: " + truncateRunes(answer, titleInputRunes)},
+	}
+}
+
+// cleanTitle returns the first line of the answer without the quotes and the period models tend to
+// add, capped at titleMaxWords words.
+func cleanTitle(answer string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(answer), "\n")
+
+	words := strings.Fields(strings.Trim(line, ` "'“”`))
+	if len(words) > titleMaxWords {
+		words = words[:titleMaxWords]
+	}
+
+	return strings.TrimRight(strings.Join(words, " "), ".")
+}
+
+// truncateTitle makes a title from the question, cut at a word boundary when it is too long.
+func truncateTitle(question string) string {
+	title := strings.Join(strings.Fields(question), " ")
+	if utf8.RuneCountInString(title) <= titleMaxRunes {
+		return title
+	}
+
+	title = truncateRunes(title, titleMaxRunes)
+	if index := strings.LastIndex(title, " "); index > 0 {
+		title = title[:index]
+	}
+
+	return title + "..."
+}
+
+func truncateRunes(value string, limit int) string {
+	runes := []rune(value)
+	if len(runes) <= limit {
+		return value
+	}
+
+	return string(runes[:limit])
+}
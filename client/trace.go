@@ -0,0 +1,120 @@
+package client
+
+import (
+	"context"
+	"github.com/kardolus/chatgpt-cli/http"
+	"github.com/kardolus/chatgpt-cli/types"
+	"io"
+)
+
+// Tracer starts the spans of the queries. A query is traced in one span, with a child span for
+// every request it sends, such as the continuations of a cut off answer or the retries of invalid
+// JSON. The tracing package implements it with OpenTelemetry.
+type Tracer interface {
+	StartQuery(ctx context.Context, model string, promptTokens int) (context.Context, QuerySpan)
+}
+
+// QuerySpan is the span of a query. End is called once, when the query is answered, fails or its
+// stream is canceled.
+type QuerySpan interface {
+	StartAttempt(ctx context.Context, attempt int) (context.Context, AttemptSpan)
+	End(finishReason string, usage types.Usage, err error)
+}
+
+// AttemptSpan is the span of a request of a query. The status is the HTTP status of the response,
+// or 0 when there was none.
+type AttemptSpan interface {
+	End(status int, err error)
+}
+
+// WithTracer traces the queries and their requests with t.
+func (c *Client) WithTracer(t Tracer) *Client {
+	c.tracer = t
+	return c
+}
+
+type nopTracer struct{}
+
+func (nopTracer) StartQuery(ctx context.Context, _ string, _ int) (context.Context, QuerySpan) {
+	return ctx, nopSpan{}
+}
+
+type nopSpan struct{}
+
+func (nopSpan) StartAttempt(ctx context.Context, _ int) (context.Context, AttemptSpan) {
+	return ctx, nopAttempt{}
+}
+
+func (nopSpan) End(string, types.Usage, error) {}
+
+type nopAttempt struct{}
+
+func (nopAttempt) End(int, error) {}
+
+type queryKey struct{}
+
+// tracedQuery is the span of the query in flight, along with the number of requests it sent.
+type tracedQuery struct {
+	span     QuerySpan
+	attempts int
+}
+
+// startQuery starts the span of a query, unless ctx is within one already, and bounds the query by
+// the timeout of the configuration. The returned function ends the span the call started and
+// releases the timeout, and does nothing otherwise. Once the timeout passed, http.QueryTimeout
+// tells the error of the query apart.
+func (c *Client) startQuery(ctx context.Context, model string, messages []types.Message) (context.Context, func(string, types.Usage, error)) {
+	if _, ok := ctx.Value(queryKey{}).(*tracedQuery); ok {
+		return ctx, func(string, types.Usage, error) {}
+	}
+
+	// an invalid timeout fails the requests of the caller, which reads the same configuration
+	timeouts, _ := http.QueryTimeoutsOf(c.Config)
+	ctx, cancel := http.WithQueryTimeout(ctx, timeouts.Query)
+
+	ctx, span := c.tracer.StartQuery(ctx, model, estimateTokens(messages))
+	return context.WithValue(ctx, queryKey{}, &tracedQuery{span: span}), func(reason string, usage types.Usage, err error) {
+		span.End(reason, usage, err)
+		cancel()
+	}
+}
+
+// startAttempt starts the span of a request of the query ctx is within.
+func startAttempt(ctx context.Context) (context.Context, AttemptSpan) {
+	query, ok := ctx.Value(queryKey{}).(*tracedQuery)
+	if !ok {
+		return ctx, nopAttempt{}
+	}
+
+	attempt := query.attempts
+	query.attempts++
+
+	return query.span.StartAttempt(ctx, attempt)
+}
+
+// post sends the request bound to ctx when the caller supports it.
+func (c *Client) post(ctx context.Context, endpoint string, body []byte) ([]byte, error) {
+	if caller, ok := c.caller.(http.ContextCaller); ok {
+		return caller.PostContext(ctx, endpoint, body)
+	}
+
+	return c.caller.Post(endpoint, body, false)
+}
+
+// postStream sends the streamed request bound to ctx when the caller supports it.
+func (c *Client) postStream(ctx context.Context, endpoint string, body []byte) (io.ReadCloser, error) {
+	if caller, ok := c.caller.(http.ContextCaller); ok {
+		return caller.PostStreamContext(ctx, endpoint, body)
+	}
+
+	return c.caller.PostStream(endpoint, body)
+}
+
+// finishReason is the finish reason of the first choice of the response, if it has any.
+func finishReason(response types.CompletionsResponse) string {
+	if len(response.Choices) == 0 {
+		return ""
+	}
+
+	return response.Choices[0].FinishReason
+}
@@ -0,0 +1,171 @@
+package clipboard
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"unicode/utf8"
+)
+
+// OSC52 is the name of the escape sequence that asks the terminal to copy, as Copy tells it.
+const OSC52 = "OSC 52"
+
+// errNoTool is a platform without a tool to copy with.
+var errNoTool = errors.New("no clipboard tool found")
+
+var (
+	// ErrEmpty is a clipboard without text to paste. Some platforms paste nothing rather than what
+	// isn't text, such as an image.
+	ErrEmpty = errors.New("the clipboard is empty, or holds no text, such as an image, copy the text to paste first")
+	// ErrNotText is a clipboard that holds something else than text, such as an image.
+	ErrNotText = errors.New("the clipboard doesn't hold text, such as when an image was copied, only text can be pasted")
+)
+
+// Reader reads what the clipboard holds, the text Read pastes.
+type Reader interface {
+	Paste() ([]byte, error)
+}
+
+// System is the Reader of the clipboard of the platform: the Windows API, or the first of the
+// PasteTools that is installed.
+type System struct{}
+
+// Paste returns what the system clipboard holds.
+func (System) Paste() ([]byte, error) {
+	data, err := pasteNative()
+	if errors.Is(err, errNoTool) {
+		return nil, errors.New("no clipboard tool found, install wl-clipboard, xclip or xsel to paste")
+	}
+
+	return data, err
+}
+
+// Read returns the text the clipboard of the reader holds, without its trailing newlines. A
+// clipboard that is larger than the limit, is empty or doesn't hold text is refused, as the piped
+// input is.
+func Read(r Reader, limit int) (string, error) {
+	data, err := r.Paste()
+	if err != nil {
+		return "", err
+	}
+
+	if len(data) > limit {
+		return "", fmt.Errorf("the clipboard is larger than max_pipe_bytes (%d bytes), copy less of it or raise max_pipe_bytes", limit)
+	}
+
+	if bytes.IndexByte(data, 0) >= 0 || !utf8.Valid(data) {
+		return "", ErrNotText
+	}
+
+	text := strings.TrimRight(string(data), "\r\n")
+	if strings.TrimSpace(text) == "" {
+		return "", ErrEmpty
+	}
+
+	return text, nil
+}
+
+// Copy copies the text to the system clipboard and returns what copied it, such as pbcopy or
+// OSC 52. The clipboard of the platform is used, the Windows API or the first tool of Tools that
+// is installed, unless the session runs over SSH, whose clipboard is the one of the machine the
+// terminal runs on. Then, and when there is no tool, the terminal is asked to copy with the OSC 52
+// escape sequence, which most terminals support, some only once it is turned on.
+func Copy(text string) (string, error) {
+	if os.Getenv("SSH_TTY") == "" && os.Getenv("SSH_CONNECTION") == "" {
+		name, err := copyNative(text)
+		if !errors.Is(err, errNoTool) {
+			return name, err
+		}
+	}
+
+	return OSC52, writeTerminal(Sequence(text, os.Getenv("TMUX") != ""))
+}
+
+// Tools returns the commands that copy their standard input to the clipboard on the operating
+// system, in the order they are tried. On Linux, the tools of the display server the session runs
+// on come first, wl-copy for Wayland and xclip or xsel for X11, and clip.exe of Windows under WSL.
+func Tools(goos string, getenv func(string) string) [][]string {
+	switch goos {
+	case "darwin":
+		return [][]string{{"pbcopy"}}
+	case "windows":
+		// the Windows API copies, rather than a tool
+		return nil
+	}
+
+	var result [][]string
+	if getenv("WAYLAND_DISPLAY") != "" {
+		result = append(result, []string{"wl-copy"})
+	}
+	if getenv("DISPLAY") != "" {
+		result = append(result, []string{"xclip", "-selection", "clipboard"}, []string{"xsel", "--clipboard", "--input"})
+	}
+	if getenv("WSL_DISTRO_NAME") != "" {
+		result = append(result, []string{"clip.exe"})
+	}
+
+	return result
+}
+
+// PasteTools returns the commands that print the text of the clipboard on the operating system, in
+// the order they are tried, as Tools returns the ones that copy.
+func PasteTools(goos string, getenv func(string) string) [][]string {
+	switch goos {
+	case "darwin":
+		return [][]string{{"pbpaste"}}
+	case "windows":
+		// the Windows API pastes, rather than a tool
+		return nil
+	}
+
+	var result [][]string
+	if getenv("WAYLAND_DISPLAY") != "" {
+		result = append(result, []string{"wl-paste", "--no-newline", "--type", "text"})
+	}
+	if getenv("DISPLAY") != "" {
+		result = append(result, []string{"xclip", "-selection", "clipboard", "-out"}, []string{"xsel", "--clipboard", "--output"})
+	}
+	if getenv("WSL_DISTRO_NAME") != "" {
+		result = append(result, []string{"powershell.exe", "-NoProfile", "-Command", "Get-Clipboard -Raw"})
+	}
+
+	return result
+}
+
+// Sequence returns the OSC 52 escape sequence that asks the terminal to copy the text to its
+// clipboard. Within tmux, the sequence is wrapped for tmux to pass it through to the terminal.
+func Sequence(text string, tmux bool) string {
+	sequence := "\033]52;c;" + base64.StdEncoding.EncodeToString([]byte(text)) + "\a"
+	if !tmux {
+		return sequence
+	}
+
+	// tmux passes the sequences of a DCS through once their escapes are doubled
+	return "\033Ptmux;" + strings.ReplaceAll(sequence, "\033", "\033\033") + "\033\\"
+}
+
+// writeTerminal writes the sequence to the terminal, rather than to the standard output, which may
+// be piped.
+func writeTerminal(sequence string) error {
+	terminal, err := os.OpenFile(terminalPath(), os.O_WRONLY, 0)
+	if err != nil {
+		_, err = os.Stderr.WriteString(sequence)
+		return err
+	}
+	defer terminal.Close()
+
+	_, err = terminal.WriteString(sequence)
+	return err
+}
+
+func terminalPath() string {
+	if runtime.GOOS == "windows" {
+		return "CONOUT$"
+	}
+
+	return "/dev/tty"
+}
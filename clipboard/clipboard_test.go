@@ -0,0 +1,107 @@
+package clipboard_test
+
+import (
+	"errors"
+	"github.com/kardolus/chatgpt-cli/clipboard"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitClipboard(t *testing.T) {
+	spec.Run(t, "Testing the clipboard", testClipboard, spec.Report(report.Terminal{}))
+}
+
+func testClipboard(t *testing.T, when spec.G, it spec.S) {
+	it.Before(func() {
+		RegisterTestingT(t)
+	})
+
+	when("Tools()", func() {
+		env := func(vars map[string]string) func(string) string {
+			return func(key string) string { return vars[key] }
+		}
+
+		it("returns the tool of macOS and none for Windows, which has an API", func() {
+			Expect(clipboard.Tools("darwin", env(nil))).To(Equal([][]string{{"pbcopy"}}))
+			Expect(clipboard.Tools("windows", env(nil))).To(BeEmpty())
+		})
+		it("returns the tools of the display servers of the session, Wayland first", func() {
+			Expect(clipboard.Tools("linux", env(map[string]string{"WAYLAND_DISPLAY": "wayland-0", "DISPLAY": ":0"}))).To(Equal([][]string{
+				{"wl-copy"},
+				{"xclip", "-selection", "clipboard"},
+				{"xsel", "--clipboard", "--input"},
+			}))
+			Expect(clipboard.Tools("linux", env(map[string]string{"WSL_DISTRO_NAME": "Ubuntu"}))).To(Equal([][]string{{"clip.exe"}}))
+			Expect(clipboard.Tools("freebsd", env(nil))).To(BeEmpty())
+		})
+	})
+
+	when("PasteTools()", func() {
+		env := func(vars map[string]string) func(string) string {
+			return func(key string) string { return vars[key] }
+		}
+
+		it("returns the tool of macOS and none for Windows, which has an API", func() {
+			Expect(clipboard.PasteTools("darwin", env(nil))).To(Equal([][]string{{"pbpaste"}}))
+			Expect(clipboard.PasteTools("windows", env(nil))).To(BeEmpty())
+		})
+		it("returns the tools of the display servers of the session, Wayland first", func() {
+			Expect(clipboard.PasteTools("linux", env(map[string]string{"WAYLAND_DISPLAY": "wayland-0", "DISPLAY": ":0"}))).To(Equal([][]string{
+				{"wl-paste", "--no-newline", "--type", "text"},
+				{"xclip", "-selection", "clipboard", "-out"},
+				{"xsel", "--clipboard", "--output"},
+			}))
+			Expect(clipboard.PasteTools("linux", env(map[string]string{"WSL_DISTRO_NAME": "Ubuntu"}))).To(Equal([][]string{
+				{"powershell.exe", "-NoProfile", "-Command", "Get-Clipboard -Raw"},
+			}))
+			Expect(clipboard.PasteTools("freebsd", env(nil))).To(BeEmpty())
+		})
+	})
+
+	when("Read()", func() {
+		it("returns the text without its trailing newlines", func() {
+			Expect(clipboard.Read(fakeReader{data: []byte("panic: nil map\r\n\n")}, 100)).To(Equal("panic: nil map"))
+		})
+		it("refuses an empty clipboard", func() {
+			for _, data := range []string{"", " \n\t\n"} {
+				_, err := clipboard.Read(fakeReader{data: []byte(data)}, 100)
+				Expect(err).To(MatchError(clipboard.ErrEmpty))
+			}
+		})
+		it("refuses what isn't text", func() {
+			for _, data := range [][]byte{{0x89, 'P', 'N', 'G', 0}, {0xff, 0xfe, 0xfd}} {
+				_, err := clipboard.Read(fakeReader{data: data}, 100)
+				Expect(err).To(MatchError(clipboard.ErrNotText))
+			}
+		})
+		it("refuses a clipboard larger than the limit", func() {
+			_, err := clipboard.Read(fakeReader{data: []byte("12345")}, 4)
+			Expect(err).To(MatchError(ContainSubstring("larger than max_pipe_bytes (4 bytes)")))
+		})
+		it("returns the error of the reader", func() {
+			_, err := clipboard.Read(fakeReader{err: errors.New("xclip failed")}, 100)
+			Expect(err).To(MatchError("xclip failed"))
+		})
+	})
+
+	when("Sequence()", func() {
+		it("encodes the text as the OSC 52 sequence of the clipboard", func() {
+			Expect(clipboard.Sequence("hi", false)).To(Equal("\033]52;c;aGk=\a"))
+		})
+		it("wraps the sequence for tmux to pass it through", func() {
+			Expect(clipboard.Sequence("hi", true)).To(Equal("\033Ptmux;\033\033]52;c;aGk=\a\033\\"))
+		})
+	})
+}
+
+type fakeReader struct {
+	data []byte
+	err  error
+}
+
+func (f fakeReader) Paste() ([]byte, error) {
+	return f.data, f.err
+}
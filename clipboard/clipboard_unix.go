@@ -0,0 +1,60 @@
+//go:build !windows
+
+package clipboard
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// copyNative copies the text with the first of the Tools that is installed.
+func copyNative(text string) (string, error) {
+	for _, tool := range Tools(runtime.GOOS, os.Getenv) {
+		if _, err := exec.LookPath(tool[0]); err != nil {
+			continue
+		}
+
+		// the output isn't read, xclip keeps running to serve the clipboard and would hold it open
+		command := exec.Command(tool[0], tool[1:]...)
+		command.Stdin = strings.NewReader(text)
+		if err := command.Run(); err != nil {
+			return tool[0], fmt.Errorf("%s failed: %w", tool[0], err)
+		}
+
+		return tool[0], nil
+	}
+
+	return "", errNoTool
+}
+
+// pasteNative returns what the first of the PasteTools that is installed prints. The error of a
+// tool tells why, such as the clipboard only holding an image.
+func pasteNative() ([]byte, error) {
+	for _, tool := range PasteTools(runtime.GOOS, os.Getenv) {
+		if _, err := exec.LookPath(tool[0]); err != nil {
+			continue
+		}
+
+		var stderr bytes.Buffer
+		command := exec.Command(tool[0], tool[1:]...)
+		command.Stderr = &stderr
+
+		data, err := command.Output()
+		if err != nil {
+			var exitErr *exec.ExitError
+			if message := strings.TrimSpace(stderr.String()); errors.As(err, &exitErr) && message != "" {
+				return nil, fmt.Errorf("%s failed: %s", tool[0], message)
+			}
+			return nil, fmt.Errorf("%s failed: %w", tool[0], err)
+		}
+
+		return data, nil
+	}
+
+	return nil, errNoTool
+}
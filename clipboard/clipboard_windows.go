@@ -0,0 +1,105 @@
+//go:build windows
+
+package clipboard
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	unicodeText = 13 // CF_UNICODETEXT
+	moveable    = 2  // GMEM_MOVEABLE
+)
+
+var (
+	user32   = windows.NewLazySystemDLL("user32.dll")
+	kernel32 = windows.NewLazySystemDLL("kernel32.dll")
+
+	openClipboard     = user32.NewProc("OpenClipboard")
+	closeClipboard    = user32.NewProc("CloseClipboard")
+	emptyClipboard    = user32.NewProc("EmptyClipboard")
+	setClipboardData  = user32.NewProc("SetClipboardData")
+	getClipboardData  = user32.NewProc("GetClipboardData")
+	isFormatAvailable = user32.NewProc("IsClipboardFormatAvailable")
+	countFormats      = user32.NewProc("CountClipboardFormats")
+	globalAlloc       = kernel32.NewProc("GlobalAlloc")
+	globalFree        = kernel32.NewProc("GlobalFree")
+	globalLock        = kernel32.NewProc("GlobalLock")
+	globalUnlock      = kernel32.NewProc("GlobalUnlock")
+	moveMemory        = kernel32.NewProc("RtlMoveMemory")
+)
+
+// copyNative copies the text to the clipboard with the Windows API, as UTF-16.
+func copyNative(text string) (string, error) {
+	const name = "the Windows clipboard"
+
+	data, err := windows.UTF16FromString(text)
+	if err != nil {
+		return name, err
+	}
+
+	if ok, _, err := openClipboard.Call(0); ok == 0 {
+		return name, fmt.Errorf("failed to open the clipboard: %w", err)
+	}
+	defer closeClipboard.Call()
+
+	if ok, _, err := emptyClipboard.Call(); ok == 0 {
+		return name, fmt.Errorf("failed to empty the clipboard: %w", err)
+	}
+
+	size := uintptr(len(data)) * unsafe.Sizeof(data[0])
+	handle, _, err := globalAlloc.Call(moveable, size)
+	if handle == 0 {
+		return name, fmt.Errorf("failed to allocate the clipboard data: %w", err)
+	}
+
+	pointer, _, err := globalLock.Call(handle)
+	if pointer == 0 {
+		globalFree.Call(handle)
+		return name, fmt.Errorf("failed to lock the clipboard data: %w", err)
+	}
+	moveMemory.Call(pointer, uintptr(unsafe.Pointer(&data[0])), size)
+	globalUnlock.Call(handle)
+
+	// the clipboard owns the data once it is set, it is only freed when setting it failed
+	if ok, _, err := setClipboardData.Call(unicodeText, handle); ok == 0 {
+		globalFree.Call(handle)
+		return name, fmt.Errorf("failed to set the clipboard data: %w", err)
+	}
+
+	return name, nil
+}
+
+// pasteNative returns the text of the clipboard with the Windows API, converted from UTF-16. A
+// clipboard that holds something else than text is an ErrNotText.
+func pasteNative() ([]byte, error) {
+	if ok, _, err := openClipboard.Call(0); ok == 0 {
+		return nil, fmt.Errorf("failed to open the clipboard: %w", err)
+	}
+	defer closeClipboard.Call()
+
+	if available, _, _ := isFormatAvailable.Call(unicodeText); available == 0 {
+		if count, _, _ := countFormats.Call(); count == 0 {
+			return nil, nil
+		}
+		return nil, ErrNotText
+	}
+
+	handle, _, err := getClipboardData.Call(unicodeText)
+	if handle == 0 {
+		return nil, fmt.Errorf("failed to get the clipboard data: %w", err)
+	}
+
+	pointer, _, err := globalLock.Call(handle)
+	if pointer == 0 {
+		return nil, fmt.Errorf("failed to lock the clipboard data: %w", err)
+	}
+	defer globalUnlock.Call(handle)
+
+	// the clipboard owns the data, which is only read until it is unlocked
+	text := windows.UTF16PtrToString(*(**uint16)(unsafe.Pointer(&pointer)))
+	return []byte(text), nil
+}
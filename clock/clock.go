@@ -0,0 +1,181 @@
+package clock
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is what the time-dependent behavior of the http and the client packages reads the time
+// and waits with, such as the backoff of the retries, the rate limits and the cooldown of the
+// circuit breaker, so tests can move the time along rather than wait for it.
+type Clock interface {
+	Now() time.Time
+	// Sleep waits for the duration, or until ctx is done, in which case it returns the error of
+	// ctx.
+	Sleep(ctx context.Context, d time.Duration) error
+	// After returns a channel the time is sent on once the duration passed.
+	After(d time.Duration) <-chan time.Time
+}
+
+// Real is the clock of the system.
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// Fake is a clock for tests whose time only moves when Advance moves it. Its waits end once the
+// time passed them, or right away, moving the time along, with AutoAdvance. Every wait is kept,
+// in order, for Waits to tell. It is safe for concurrent use.
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	auto    bool
+	waits   []time.Duration
+	waiters []*waiter
+}
+
+type waiter struct {
+	until time.Time
+	ch    chan time.Time
+}
+
+// NewFake returns a fake clock at the time.
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+// AutoAdvance ends the waits right away, moving the time by each of them, so the code under test
+// runs through its waits without a goroutine to advance the clock.
+func (f *Fake) AutoAdvance() *Fake {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.auto = true
+	return f
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.now
+}
+
+func (f *Fake) Sleep(ctx context.Context, d time.Duration) error {
+	w := f.wait(d)
+
+	select {
+	case <-w.ch:
+		return nil
+	case <-ctx.Done():
+		f.cancel(w)
+		return ctx.Err()
+	}
+}
+
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	return f.wait(d).ch
+}
+
+func (f *Fake) wait(d time.Duration) *waiter {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.waits = append(f.waits, d)
+
+	w := &waiter{until: f.now.Add(d), ch: make(chan time.Time, 1)}
+	if f.auto && w.until.After(f.now) {
+		f.now = w.until
+	}
+	f.waiters = append(f.waiters, w)
+	f.release()
+
+	return w
+}
+
+// cancel drops the wait of a sleep whose context is done.
+func (f *Fake) cancel(w *waiter) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i, pending := range f.waiters {
+		if pending == w {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// Advance moves the time by the duration, and ends the waits it passed.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.advance(d)
+}
+
+// Set moves the time to the time, and ends the waits it passed.
+func (f *Fake) Set(now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.advance(now.Sub(f.now))
+}
+
+// Waits returns the durations of the waits so far, in their order.
+func (f *Fake) Waits() []time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]time.Duration(nil), f.waits...)
+}
+
+// Waiters returns how many waits are yet to end, so a test can tell the code under test is
+// waiting before it advances the clock.
+func (f *Fake) Waiters() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.waiters)
+}
+
+func (f *Fake) advance(d time.Duration) {
+	f.now = f.now.Add(d)
+	f.release()
+}
+
+// release ends the waits the time passed, the earliest first.
+func (f *Fake) release() {
+	sort.SliceStable(f.waiters, func(i, j int) bool { return f.waiters[i].until.Before(f.waiters[j].until) })
+
+	var pending []*waiter
+	for _, w := range f.waiters {
+		if w.until.After(f.now) {
+			pending = append(pending, w)
+			continue
+		}
+		w.ch <- f.now
+	}
+	f.waiters = pending
+}
@@ -0,0 +1,83 @@
+package clock_test
+
+import (
+	"context"
+	"github.com/kardolus/chatgpt-cli/clock"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitClock(t *testing.T) {
+	spec.Run(t, "Testing the clock", testClock, spec.Report(report.Terminal{}))
+}
+
+func testClock(t *testing.T, when spec.G, it spec.S) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	it.Before(func() {
+		RegisterTestingT(t)
+	})
+
+	when("Fake", func() {
+		it("ends the waits once the time passed them, the earliest first", func() {
+			fake := clock.NewFake(start)
+
+			late := fake.After(2 * time.Second)
+			early := fake.After(time.Second)
+			Expect(fake.Waiters()).To(Equal(2))
+
+			fake.Advance(500 * time.Millisecond)
+			Expect(early).NotTo(Receive())
+
+			fake.Advance(500 * time.Millisecond)
+			Expect(early).To(Receive(Equal(start.Add(time.Second))))
+			Expect(late).NotTo(Receive())
+
+			fake.Set(start.Add(time.Minute))
+			Expect(late).To(Receive(Equal(start.Add(time.Minute))))
+			Expect(fake.Waiters()).To(BeZero())
+			Expect(fake.Waits()).To(Equal([]time.Duration{2 * time.Second, time.Second}))
+		})
+		it("ends the waits right away when it auto-advances", func() {
+			fake := clock.NewFake(start).AutoAdvance()
+
+			Expect(fake.Sleep(context.Background(), time.Second)).To(Succeed())
+			Expect(fake.After(time.Minute)).To(Receive(Equal(start.Add(time.Minute + time.Second))))
+			Expect(fake.Sleep(context.Background(), 0)).To(Succeed())
+
+			Expect(fake.Now()).To(Equal(start.Add(time.Minute + time.Second)))
+			Expect(fake.Waits()).To(Equal([]time.Duration{time.Second, time.Minute, 0}))
+		})
+		it("stops sleeping once the context is done", func() {
+			fake := clock.NewFake(start)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error)
+			go func() {
+				done <- fake.Sleep(ctx, time.Hour)
+			}()
+
+			Eventually(fake.Waiters).Should(Equal(1))
+			cancel()
+			Eventually(done).Should(Receive(MatchError(context.Canceled)))
+			Expect(fake.Waiters()).To(BeZero())
+			Expect(fake.Now()).To(Equal(start))
+		})
+	})
+
+	when("Real", func() {
+		it("sleeps for the duration unless the context is done first", func() {
+			before := clock.Real.Now()
+			Expect(clock.Real.Sleep(context.Background(), 10*time.Millisecond)).To(Succeed())
+			Expect(clock.Real.Now().Sub(before)).To(BeNumerically(">=", 10*time.Millisecond))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			Expect(clock.Real.Sleep(ctx, time.Hour)).To(MatchError(context.Canceled))
+		})
+	})
+}
@@ -0,0 +1,30 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// onResize calls resized every time the terminal is resized, until the function it returns is
+// called.
+func onResize(resized func()) func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGWINCH)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range signals {
+			resized()
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(signals)
+		<-done
+	}
+}
@@ -0,0 +1,9 @@
+//go:build windows
+
+package main
+
+// onResize does nothing on Windows, which has no signal for a resize, so a stream is wrapped at the
+// width the terminal had when it started.
+func onResize(func()) func() {
+	return func() {}
+}
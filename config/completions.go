@@ -7,22 +7,27 @@ import (
 	"github.com/spf13/cobra"
 )
 
+// Shells are the shells GenCompletions generates the completion script of.
+var Shells = []string{"bash", "zsh", "fish", "powershell"}
+
+// GenCompletions writes the completion script of the shell for the command and its flags, as
+// requested with chatgpt completion <shell> or chatgpt --set-completions <shell>.
 func GenCompletions(command *cobra.Command, shell string) error {
 
 	var completionCmd = &cobra.Command{
-		Use:   "chatgpt --set-completions [bash|zsh|fish|powershell]",
+		Use:   "chatgpt completion [bash|zsh|fish|powershell]",
 		Short: "Generate completion script",
 		Long: `To load completions:
 
 Bash:
 
-  $ source <(chatgpt --set-completions bash)
+  $ source <(chatgpt completion bash)
 
   # To load completions for each session, execute once:
   # Linux:
-  $ chatgpt --set-completions bash > /etc/bash_completion.d/chatgpt
+  $ chatgpt completion bash > /etc/bash_completion.d/chatgpt
   # macOS:
-  $ chatgpt --set-completions bash > /usr/local/etc/bash_completion.d/chatgpt
+  $ chatgpt completion bash > /usr/local/etc/bash_completion.d/chatgpt
 
 Zsh:
 
@@ -32,23 +37,23 @@ Zsh:
   $ echo "autoload -U compinit; compinit" >> ~/.zshrc
 
   # To load completions for each session, execute once:
-  $ chatgpt --set-completions zsh > "${fpath[1]}/_chatgpt"
+  $ chatgpt completion zsh > "${fpath[1]}/_chatgpt"
 
   # You will need to start a new shell for this setup to take effect.
 
 fish:
 
-  $ chatgpt --set-completions fish | source
+  $ chatgpt completion fish | source
 
   # To load completions for each session, execute once:
-  $ chatgpt --set-completions fish > ~/.config/fish/completions/chatgpt.fish
+  $ chatgpt completion fish > ~/.config/fish/completions/chatgpt.fish
 
 PowerShell:
 
-  PS> chatgpt --set-completions powershell | Out-String | Invoke-Expression
+  PS> chatgpt completion powershell | Out-String | Invoke-Expression
 
   # To load completions for every new session, run:
-  PS> chatgpt --set-completions powershell > chatgpt.ps1
+  PS> chatgpt completion powershell > chatgpt.ps1
   # and source this file from your PowerShell profile.
 `,
 		Args:                  cobra.ExactArgs(1),
@@ -69,10 +74,10 @@ PowerShell:
 			default:
 				fmt.Printf(`
 Usage:
-  chatgpt --set-completions [bash|zsh|fish|powershell]
+  chatgpt completion [bash|zsh|fish|powershell]
 
 Flags:
-  -h, --help   help for --set-completions
+  -h, --help   help for completion
 
 Invalid Arg: %s
 `, args[0])
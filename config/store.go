@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 	"reflect"
 	"strconv"
+	"strings"
 )
 
 const (
@@ -83,6 +84,10 @@ func (f *FileIO) List() ([]string, error) {
 	}
 
 	for _, file := range files {
+		// skip bookkeeping files such as the thread index
+		if strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
 		result = append(result, file.Name())
 	}
 
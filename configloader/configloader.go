@@ -0,0 +1,407 @@
+// Package configloader reads the config file into viper, which merges it with the environment
+// variables, the flags and the defaults. A flag bound to viper takes precedence over its
+// environment variable, which takes precedence over the config file, which takes precedence over
+// the default. The settings of the profile of the config file that is selected replace those of
+// the file, and those of the project file of the directory, such as a repository, replace both.
+package configloader
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/types"
+	"github.com/kardolus/chatgpt-cli/utils"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// FileName is the name of the config file in the config home
+	FileName = "config.yaml"
+	// DefaultName prefixes the environment variables when the config doesn't name the provider
+	DefaultName = "openai"
+	// ProfileEnv is the variable that selects the profile when --profile doesn't
+	ProfileEnv = EnvPrefix + "PROFILE"
+	// ProfilesDir is the directory of the history directory the threads of a profile are kept in,
+	// in a directory of its name, when the profile doesn't set history_dir
+	ProfilesDir = "profiles"
+)
+
+// UnknownKey is a key of the config file that isn't a setting, such as a mistyped one, which is
+// ignored.
+type UnknownKey struct {
+	Key  string
+	Line int
+	// Profile is the profile the key is in, empty for the keys outside of profiles
+	Profile string
+	// Matches are the settings the key could have meant, the closest first
+	Matches []string
+}
+
+// warning returns the warning of the key, which is of the file of the path.
+func (u UnknownKey) warning(path string) string {
+	warning := fmt.Sprintf("unknown key %s on line %d of %s, it is ignored", u.Key, u.Line, path)
+	if u.Profile != "" {
+		warning = fmt.Sprintf("unknown key %s of profile %s on line %d of %s, it is ignored", u.Key, u.Profile, u.Line, path)
+	}
+	if len(u.Matches) > 0 {
+		warning += fmt.Sprintf(", did you mean %s?", strings.Join(u.Matches, " or "))
+	}
+	return warning
+}
+
+// Load reads the config file of dir into v, when there is one, and makes v read the environment
+// variables of the settings, those of Variables. It returns the warnings of the keys of the file
+// that aren't settings. A file that isn't YAML, or a setting of the file or of a variable of the
+// wrong type, is an error that tells the line or the variable.
+//
+// The settings of the profile, or else of the one of ProfileEnv, or else of the profile key of the
+// file, replace those of the file, and the profile key of v is set to the name of the profile. A
+// profile that doesn't set history_dir keeps its threads in a directory of its own, in ProfilesDir.
+// A profile that isn't one of the file is an error that names those that are.
+//
+// The settings of the project file, when project has one, overlay those of the file and of its
+// profile, and its profile key takes precedence over the one of the file. Its sensitive settings,
+// those of IsSensitive, are ignored unless the project is trusted, and Load tells which in the
+// Keys and the Ignored of project.
+func Load(v *viper.Viper, dir, profile string, project *Project) ([]string, error) {
+	v.SetDefault("name", DefaultName)
+
+	path := filepath.Join(dir, FileName)
+	v.SetConfigFile(path)
+	v.SetConfigType("yaml")
+
+	var warnings []string
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		unknown, err := Check(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", path, err)
+		}
+		for _, u := range unknown {
+			warnings = append(warnings, u.warning(path))
+		}
+
+		if err := v.ReadConfig(bytes.NewReader(data)); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", path, err)
+		}
+	}
+
+	var overlay map[string]interface{}
+	if project != nil && project.Path != "" {
+		settings, projectWarnings, err := readProject(project)
+		if err != nil {
+			return nil, err
+		}
+		overlay = settings
+		warnings = append(warnings, projectWarnings...)
+	}
+
+	if profile == "" {
+		profile = os.Getenv(ProfileEnv)
+	}
+	if name, ok := overlay["profile"].(string); ok && profile == "" {
+		profile = name
+	}
+	if profile == "" {
+		profile = v.GetString("profile")
+	}
+	if err := useProfile(v, profile, path); err != nil {
+		return nil, err
+	}
+
+	// the project file overlays the file and its profile
+	if len(overlay) > 0 {
+		if err := v.MergeConfigMap(overlay); err != nil {
+			return nil, err
+		}
+	}
+
+	// the name, which prefixes the variables, is read from the file, the profile or its own variable
+	_ = v.BindEnv("name", EnvPrefix+"NAME")
+	v.SetEnvPrefix(v.GetString("name"))
+	if err := bindEnv(v); err != nil {
+		return nil, err
+	}
+
+	return warnings, nil
+}
+
+// Profiles returns the names of the profiles of the config read into v, sorted. As all the keys of
+// viper, they are in lower case.
+func Profiles(v *viper.Viper) []string {
+	var names []string
+	for name := range v.GetStringMap("profiles") {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// useProfile replaces the settings of the config read into v with those of the profile, when
+// there is one.
+func useProfile(v *viper.Viper, profile, path string) error {
+	if profile == "" {
+		return nil
+	}
+
+	// the names are keys of viper, which ignores their case
+	profile = strings.ToLower(profile)
+
+	names := Profiles(v)
+	if !slices.Contains(names, profile) {
+		if len(names) == 0 {
+			return fmt.Errorf("unknown profile %s, %s has no profiles", profile, path)
+		}
+		return fmt.Errorf("unknown profile %s, use one of %s", profile, strings.Join(names, ", "))
+	}
+
+	// the settings are copied, as the directory of the history is added to them
+	settings := map[string]interface{}{}
+	maps.Copy(settings, v.GetStringMap("profiles."+profile))
+	if _, ok := settings["history_dir"]; !ok {
+		base := v.GetString("history_dir")
+		if base == "" {
+			var err error
+			if base, err = utils.GetDataHome(); err != nil {
+				return err
+			}
+		}
+		settings["history_dir"] = filepath.Join(base, ProfilesDir, profile)
+	}
+
+	if err := v.MergeConfigMap(settings); err != nil {
+		return err
+	}
+	v.Set("profile", profile)
+
+	return nil
+}
+
+// Keys returns the keys of the settings of the config file, in the order of types.Config.
+func Keys() []string {
+	var keys []string
+	for _, field := range settings() {
+		keys = append(keys, field.Tag.Get("yaml"))
+	}
+	return keys
+}
+
+// Check returns the keys of the YAML config that aren't settings, and an error for a setting whose
+// value can't be of its type, such as a word for temperature, which tells its line. Values are
+// checked the way viper reads them, so a number may be quoted.
+func Check(data []byte) ([]UnknownKey, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+
+	// an empty file has no document
+	if len(root.Content) == 0 {
+		return nil, nil
+	}
+
+	mapping := root.Content[0]
+	if mapping.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("line %d: the config is not a map of keys to values", mapping.Line)
+	}
+
+	return checkSettings(mapping, "")
+}
+
+// CheckKey returns an error for a key that isn't a setting, with the settings it could have meant,
+// and for the profiles, which are maps of settings rather than a setting.
+func CheckKey(key string) error {
+	if key == "profiles" {
+		return errors.New("the profiles are maps of settings, edit them in the config file")
+	}
+
+	if slices.Contains(Keys(), key) {
+		return nil
+	}
+
+	if matches := utils.ClosestMatches(key, Keys(), 3); len(matches) > 0 {
+		return fmt.Errorf("unknown setting %s, did you mean %s?", key, strings.Join(matches, " or "))
+	}
+	return fmt.Errorf("unknown setting %s", key)
+}
+
+// ParseValue returns the node of the value of the setting, checked as those of the config file
+// are. A string is taken as it is, the other values are YAML, so a list is [a, b] and a map is
+// {name: value}, which JSON is too. An empty value is null, which unsets the setting.
+func ParseValue(key, value string) (*yaml.Node, error) {
+	if err := CheckKey(key); err != nil {
+		return nil, err
+	}
+
+	t := kinds()[key]
+	if t.Kind() == reflect.String {
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}, nil
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(value), &doc); err != nil {
+		// a list is what no setting but the lists takes, and a map what the lists don't
+		usage := checkValue(t, &yaml.Node{Kind: yaml.SequenceNode})
+		if usage == "" {
+			usage = checkValue(t, &yaml.Node{Kind: yaml.MappingNode})
+		}
+		return nil, fmt.Errorf("invalid %s %q, %s", key, value, usage)
+	}
+
+	node := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null"}
+	if len(doc.Content) > 0 {
+		node = doc.Content[0]
+	}
+	if usage := checkValue(t, node); usage != "" {
+		return nil, fmt.Errorf("invalid %s %q, %s", key, value, usage)
+	}
+
+	// the lists and the maps are written in the style of the rest of the file, rather than as the
+	// JSON they may have been given as
+	plain(node)
+	return node, nil
+}
+
+// plain clears the style of the node and of the nodes in it.
+func plain(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		plain(child)
+	}
+}
+
+// checkSettings checks the settings of the mapping, those of the config file or of its profile.
+func checkSettings(mapping *yaml.Node, profile string) ([]UnknownKey, error) {
+	known := kinds()
+
+	// a profile doesn't select a profile, nor has profiles of its own
+	if profile != "" {
+		delete(known, "profile")
+		delete(known, "profiles")
+	}
+
+	var unknown []UnknownKey
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		key, value := mapping.Content[i], mapping.Content[i+1]
+
+		t, ok := known[key.Value]
+		if !ok {
+			unknown = append(unknown, UnknownKey{Key: key.Value, Line: key.Line, Profile: profile, Matches: utils.ClosestMatches(key.Value, Keys(), 3)})
+			continue
+		}
+
+		if usage := checkValue(t, value); usage != "" {
+			return nil, fmt.Errorf("line %d: invalid %s %s, %s", value.Line, key.Value, describe(value), usage)
+		}
+
+		if key.Value != "profiles" || value.Kind != yaml.MappingNode {
+			continue
+		}
+
+		for j := 0; j+1 < len(value.Content); j += 2 {
+			name, settings := value.Content[j], value.Content[j+1]
+			if settings.ShortTag() == "!!null" {
+				continue
+			}
+			if settings.Kind != yaml.MappingNode {
+				return nil, fmt.Errorf("line %d: invalid profile %s, use a map of settings", settings.Line, name.Value)
+			}
+
+			keys, err := checkSettings(settings, name.Value)
+			if err != nil {
+				return nil, err
+			}
+			unknown = append(unknown, keys...)
+		}
+	}
+
+	return unknown, nil
+}
+
+// settings returns the fields of types.Config that are read from the config file.
+func settings() []reflect.StructField {
+	t := reflect.TypeOf(types.Config{})
+
+	var fields []reflect.StructField
+	for i := 0; i < t.NumField(); i++ {
+		if key := t.Field(i).Tag.Get("yaml"); key != "" && key != "-" {
+			fields = append(fields, t.Field(i))
+		}
+	}
+
+	return fields
+}
+
+// kinds returns the types of the settings by their key.
+func kinds() map[string]reflect.Type {
+	result := map[string]reflect.Type{}
+	for _, field := range settings() {
+		result[field.Tag.Get("yaml")] = field.Type
+	}
+	return result
+}
+
+// checkValue returns how to set a value of the type, when the node can't be one.
+func checkValue(t reflect.Type, node *yaml.Node) string {
+	if node.ShortTag() == "!!null" {
+		return ""
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		if node.Kind != yaml.ScalarNode {
+			return "use a string"
+		}
+	case reflect.Int:
+		if _, err := strconv.Atoi(node.Value); node.Kind != yaml.ScalarNode || err != nil {
+			return "use a whole number"
+		}
+	case reflect.Float64:
+		if _, err := strconv.ParseFloat(node.Value, 64); node.Kind != yaml.ScalarNode || err != nil {
+			return "use a number"
+		}
+	case reflect.Bool:
+		if _, err := strconv.ParseBool(node.Value); node.Kind != yaml.ScalarNode || err != nil {
+			return "use true or false"
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return "use a map of names to values"
+		}
+	case reflect.Slice:
+		if node.Kind == yaml.MappingNode {
+			return "use a list"
+		}
+	case reflect.Pointer:
+		if node.Kind != yaml.ScalarNode {
+			return "use a single value"
+		}
+	}
+
+	return ""
+}
+
+// describe returns the quoted value of a scalar node, or what a node of several values is.
+func describe(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "map"
+	case yaml.SequenceNode:
+		return "list"
+	}
+	return strconv.Quote(node.Value)
+}
@@ -0,0 +1,373 @@
+package configloader_test
+
+import (
+	"github.com/kardolus/chatgpt-cli/configloader"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitConfigLoader(t *testing.T) {
+	spec.Run(t, "Testing the config loader", testConfigLoader, spec.Report(report.Terminal{}))
+}
+
+func testConfigLoader(t *testing.T, when spec.G, it spec.S) {
+	var dir string
+
+	it.Before(func() {
+		RegisterTestingT(t)
+		dir = t.TempDir()
+	})
+
+	writeConfig := func(content string) {
+		Expect(os.WriteFile(filepath.Join(dir, configloader.FileName), []byte(content), 0644)).To(Succeed())
+	}
+
+	when("Load()", func() {
+		// each layer is set or left out, the highest one that is set wins
+		for _, tc := range []struct {
+			name  string
+			file  string
+			env   string
+			flag  string
+			model string
+		}{
+			{name: "the default without anything else", model: "default-model"},
+			{name: "the config file over the default", file: "file-model", model: "file-model"},
+			{name: "the environment over the config file", file: "file-model", env: "env-model", model: "env-model"},
+			{name: "the environment over the default", env: "env-model", model: "env-model"},
+			{name: "the flag over the environment", file: "file-model", env: "env-model", flag: "flag-model", model: "flag-model"},
+			{name: "the flag over the config file", file: "file-model", flag: "flag-model", model: "flag-model"},
+			{name: "the flag over the default", flag: "flag-model", model: "flag-model"},
+		} {
+			it("takes "+tc.name, func() {
+				if tc.file != "" {
+					writeConfig("model: " + tc.file + "\n")
+				}
+				if tc.env != "" {
+					t.Setenv("OPENAI_MODEL", tc.env)
+				}
+
+				v := viper.New()
+				warnings, err := configloader.Load(v, dir, "", nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(warnings).To(BeEmpty())
+
+				flags := pflag.NewFlagSet("chatgpt", pflag.ContinueOnError)
+				flags.String("model", v.GetString("model"), "")
+				Expect(v.BindPFlag("model", flags.Lookup("model"))).To(Succeed())
+				v.SetDefault("model", "default-model")
+
+				var args []string
+				if tc.flag != "" {
+					args = []string{"--model", tc.flag}
+				}
+				Expect(flags.Parse(args)).To(Succeed())
+
+				Expect(v.GetString("model")).To(Equal(tc.model))
+			})
+		}
+
+		it("reads the environment variables prefixed with the name of the config file", func() {
+			writeConfig("name: azure\nmodel: file-model\n")
+			t.Setenv("AZURE_MODEL", "azure-model")
+			t.Setenv("OPENAI_MODEL", "openai-model")
+
+			v := viper.New()
+			_, err := configloader.Load(v, dir, "", nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(v.GetString("model")).To(Equal("azure-model"))
+		})
+
+		it("reads the organization from the variable of the OpenAI SDKs too", func() {
+			t.Setenv("OPENAI_ORG_ID", "org-sdk")
+
+			v := viper.New()
+			_, err := configloader.Load(v, dir, "", nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(v.GetString("organization")).To(Equal("org-sdk"))
+		})
+
+		it("reads nothing but the environment without a config file", func() {
+			v := viper.New()
+			warnings, err := configloader.Load(v, dir, "", nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(BeEmpty())
+			Expect(v.GetString("name")).To(Equal(configloader.DefaultName))
+			Expect(v.ConfigFileUsed()).To(Equal(filepath.Join(dir, configloader.FileName)))
+		})
+
+		it("warns of the unknown keys of the config file with their line and ignores them", func() {
+			writeConfig("model: gpt-4o\ntemprature: 0.5\nfavorite_color: blue\n")
+
+			v := viper.New()
+			warnings, err := configloader.Load(v, dir, "", nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(HaveLen(2))
+			Expect(warnings[0]).To(HavePrefix("unknown key temprature on line 2 of " + filepath.Join(dir, configloader.FileName)))
+			Expect(warnings[0]).To(HaveSuffix("did you mean temperature?"))
+			Expect(warnings[1]).To(ContainSubstring("unknown key favorite_color on line 3"))
+			Expect(warnings[1]).NotTo(ContainSubstring("did you mean"))
+			Expect(v.GetString("model")).To(Equal("gpt-4o"))
+		})
+
+		it("fails on a config file that isn't YAML, with the path and the line", func() {
+			writeConfig("model: gpt-4o\nrole: you: are\n")
+
+			_, err := configloader.Load(viper.New(), dir, "", nil)
+			Expect(err).To(MatchError(ContainSubstring(filepath.Join(dir, configloader.FileName))))
+			Expect(err).To(MatchError(ContainSubstring("line 2: mapping values are not allowed")))
+		})
+	})
+
+	when("the config file has profiles", func() {
+		const profiles = "model: file-model\nhistory_dir: /data/chatgpt\nprofile: home\nprofiles:\n" +
+			"  home:\n    model: home-model\n" +
+			"  work:\n    name: azure\n    model: work-model\n    url: https://work.example.com\n" +
+			"  sandbox:\n    history_dir: /tmp/sandbox\n"
+
+		it.Before(func() {
+			writeConfig(profiles)
+		})
+
+		// the profile of the flag, or else of the variable, or else of the profile key is used
+		for _, tc := range []struct {
+			name    string
+			flag    string
+			env     string
+			profile string
+		}{
+			{name: "the profile key without the flag and the variable", profile: "home"},
+			{name: "the variable over the profile key", env: "work", profile: "work"},
+			{name: "the flag over the variable", flag: "sandbox", env: "work", profile: "sandbox"},
+			{name: "the flag over the profile key", flag: "work", profile: "work"},
+		} {
+			it("selects "+tc.name, func() {
+				if tc.env != "" {
+					t.Setenv(configloader.ProfileEnv, tc.env)
+				}
+
+				v := viper.New()
+				_, err := configloader.Load(v, dir, tc.flag, nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(v.GetString("profile")).To(Equal(tc.profile))
+			})
+		}
+
+		it("replaces the settings of the file with those of the profile, under the environment", func() {
+			t.Setenv("AZURE_URL", "https://env.example.com")
+
+			v := viper.New()
+			_, err := configloader.Load(v, dir, "work", nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(v.GetString("model")).To(Equal("work-model"))
+			// the name of the profile prefixes the variables
+			Expect(v.GetString("url")).To(Equal("https://env.example.com"))
+		})
+
+		it("keeps the threads of each profile in a directory of its own, unless it sets history_dir", func() {
+			v := viper.New()
+			_, err := configloader.Load(v, dir, "work", nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(v.GetString("history_dir")).To(Equal(filepath.Join("/data/chatgpt", configloader.ProfilesDir, "work")))
+
+			v = viper.New()
+			_, err = configloader.Load(v, dir, "sandbox", nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(v.GetString("history_dir")).To(Equal("/tmp/sandbox"))
+		})
+
+		it("lists the profiles in order", func() {
+			v := viper.New()
+			_, err := configloader.Load(v, dir, "", nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(configloader.Profiles(v)).To(Equal([]string{"home", "sandbox", "work"}))
+		})
+
+		it("refuses a profile the file doesn't have, with those it has", func() {
+			_, err := configloader.Load(viper.New(), dir, "wrok", nil)
+			Expect(err).To(MatchError("unknown profile wrok, use one of home, sandbox, work"))
+
+			writeConfig("model: file-model\n")
+			_, err = configloader.Load(viper.New(), dir, "work", nil)
+			Expect(err).To(MatchError(ContainSubstring("unknown profile work, " + filepath.Join(dir, configloader.FileName) + " has no profiles")))
+		})
+	})
+
+	when("the settings are read from the environment", func() {
+		it("takes the variable of the name over the one of EnvPrefix, and both over the config file", func() {
+			writeConfig("model: file-model\n")
+			t.Setenv("CHATGPT_CLI_MODEL", "cli-model")
+
+			v := viper.New()
+			_, err := configloader.Load(v, dir, "", nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(v.GetString("model")).To(Equal("cli-model"))
+
+			t.Setenv("OPENAI_MODEL", "openai-model")
+			v = viper.New()
+			_, err = configloader.Load(v, dir, "", nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(v.GetString("model")).To(Equal("openai-model"))
+		})
+
+		it("reads the maps as JSON objects", func() {
+			t.Setenv("CHATGPT_CLI_HEADERS", `{"X-Team": "cli"}`)
+
+			v := viper.New()
+			_, err := configloader.Load(v, dir, "", nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(v.GetStringMapString("headers")).To(Equal(map[string]string{"X-Team": "cli"}))
+		})
+
+		it("refuses the values that can't be of the type of their setting, with their variable", func() {
+			for variable, message := range map[string]string{
+				"CHATGPT_CLI_TEMPERATURE":  `invalid CHATGPT_CLI_TEMPERATURE "hot", use a number`,
+				"OPENAI_MAX_TOKENS":        `invalid OPENAI_MAX_TOKENS "hot", use a whole number`,
+				"CHATGPT_CLI_OMIT_HISTORY": `invalid CHATGPT_CLI_OMIT_HISTORY "hot", use true or false`,
+				"CHATGPT_CLI_HEADERS":      `invalid CHATGPT_CLI_HEADERS "hot", use a JSON object`,
+			} {
+				t.Setenv(variable, "hot")
+				_, err := configloader.Load(viper.New(), dir, "", nil)
+				Expect(err).To(MatchError(HavePrefix(message)))
+				Expect(os.Unsetenv(variable)).To(Succeed())
+			}
+		})
+
+		it("returns the variables of a setting, the first one that is set taking precedence", func() {
+			Expect(configloader.Variables("azure", "model")).To(Equal([]string{"AZURE_MODEL", "CHATGPT_CLI_MODEL"}))
+			Expect(configloader.Variables("openai", "organization")).To(Equal([]string{"OPENAI_ORGANIZATION", "CHATGPT_CLI_ORGANIZATION", "OPENAI_ORG_ID"}))
+			Expect(configloader.Variables("azure", "name")).To(Equal([]string{"CHATGPT_CLI_NAME"}))
+			Expect(configloader.Variables("azure", "profiles")).To(BeEmpty())
+		})
+
+		it("tells where the value of each setting comes from, hiding the secrets", func() {
+			writeConfig("model: file-model\nrole: file-role\nprofiles:\n  work:\n    url: https://work.example.com\n")
+			t.Setenv("CHATGPT_CLI_TEMPERATURE", "0.3")
+			t.Setenv("OPENAI_API_KEY", "sk-secret")
+
+			v := viper.New()
+			_, err := configloader.Load(v, dir, "work", nil)
+			Expect(err).NotTo(HaveOccurred())
+			v.Set("role", "flag-role")
+
+			settings := map[string]configloader.Setting{}
+			for _, setting := range configloader.Settings(v, map[string]bool{"role": true}, nil) {
+				settings[setting.Key] = setting
+			}
+
+			Expect(settings["role"]).To(Equal(configloader.Setting{Key: "role", Variable: "CHATGPT_CLI_ROLE", Value: "flag-role", Source: configloader.SourceFlag}))
+			Expect(settings["temperature"]).To(Equal(configloader.Setting{Key: "temperature", Variable: "CHATGPT_CLI_TEMPERATURE", Value: "0.3", Source: configloader.SourceEnv}))
+			Expect(settings["url"].Source).To(Equal(configloader.SourceProfile))
+			Expect(settings["history_dir"].Source).To(Equal(configloader.SourceProfile))
+			Expect(settings["model"].Source).To(Equal(configloader.SourceConfig))
+			Expect(settings["thread"].Source).To(Equal(configloader.SourceDefault))
+			Expect(settings["api_key"]).To(Equal(configloader.Setting{Key: "api_key", Variable: "OPENAI_API_KEY", Value: "********", Source: configloader.SourceEnv}))
+			Expect(settings).NotTo(HaveKey("profiles"))
+		})
+	})
+
+	when("Check()", func() {
+		it("accepts the values viper reads, such as quoted numbers and empty values", func() {
+			unknown, err := configloader.Check([]byte("max_tokens: \"100\"\ntemperature: 1\nomit_history: \"true\"\n" +
+				"seed: \"\"\nstop: END\nheaders:\n  X-Team: cli\ncontext_window:\n"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(unknown).To(BeEmpty())
+		})
+		it("accepts an empty config", func() {
+			unknown, err := configloader.Check(nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(unknown).To(BeEmpty())
+		})
+		it("refuses the values that can't be of the type of their setting, with their line", func() {
+			for input, message := range map[string]string{
+				"model: gpt-4o\ntemperature: hot":     `line 2: invalid temperature "hot", use a number`,
+				"max_tokens: 1.5":                     `line 1: invalid max_tokens "1.5", use a whole number`,
+				"omit_history: yes":                   `line 1: invalid omit_history "yes", use true or false`,
+				"model:\n  - gpt-4o":                  "line 2: invalid model list, use a string",
+				"headers: X-Team":                     `line 1: invalid headers "X-Team", use a map of names to values`,
+				"stop:\n  end: 1":                     "line 2: invalid stop map, use a list",
+				"- model: gpt-4o":                     "line 1: the config is not a map of keys to values",
+				"model: gpt-4o\nthread: [one, two]\n": "line 2: invalid thread list, use a string",
+			} {
+				_, err := configloader.Check([]byte(input))
+				Expect(err).To(MatchError(message))
+			}
+		})
+		it("checks the settings of the profiles", func() {
+			unknown, err := configloader.Check([]byte("profiles:\n  work:\n    modle: gpt-4o\n    profile: home\n  empty:\n"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(unknown).To(HaveLen(2))
+			Expect(unknown[0]).To(Equal(configloader.UnknownKey{Key: "modle", Line: 3, Profile: "work", Matches: []string{"model", "role"}}))
+			Expect(unknown[1].Key).To(Equal("profile"))
+
+			_, err = configloader.Check([]byte("profiles:\n  work:\n    temperature: hot\n"))
+			Expect(err).To(MatchError(`line 3: invalid temperature "hot", use a number`))
+
+			_, err = configloader.Check([]byte("profiles:\n  work: gpt-4o\n"))
+			Expect(err).To(MatchError("line 2: invalid profile work, use a map of settings"))
+		})
+		it("returns the unknown keys with the settings they could have meant", func() {
+			unknown, err := configloader.Check([]byte("modle: gpt-4o\nthread: work\n"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(unknown).To(Equal([]configloader.UnknownKey{{Key: "modle", Line: 1, Matches: []string{"model", "role"}}}))
+		})
+	})
+
+	when("ParseValue()", func() {
+		it("takes a string as it is, and the other values as YAML", func() {
+			node, err := configloader.ParseValue("role", "You are: a pirate")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(node.Value).To(Equal("You are: a pirate"))
+
+			node, err = configloader.ParseValue("stop", "[END, STOP]")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(node.Kind).To(Equal(yaml.SequenceNode))
+			Expect(node.Style).To(BeZero())
+
+			node, err = configloader.ParseValue("headers", `{"X-Team": "cli"}`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(node.Kind).To(Equal(yaml.MappingNode))
+			Expect(node.Content[0].Value).To(Equal("X-Team"))
+			Expect(node.Content[0].Style).To(BeZero())
+
+			node, err = configloader.ParseValue("max_tokens", "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(node.ShortTag()).To(Equal("!!null"))
+		})
+		it("refuses the values that can't be of the type of their setting", func() {
+			for value, message := range map[string]string{
+				"temperature=hot":  `invalid temperature "hot", use a number`,
+				"max_tokens=[1":    `invalid max_tokens "[1", use a whole number`,
+				"stop=a: b":        `invalid stop "a: b", use a list`,
+				"headers=X-Team":   `invalid headers "X-Team", use a map of names to values`,
+				"omit_history=yes": `invalid omit_history "yes", use true or false`,
+			} {
+				key, value, _ := strings.Cut(value, "=")
+				_, err := configloader.ParseValue(key, value)
+				Expect(err).To(MatchError(message))
+			}
+		})
+		it("refuses the keys that aren't settings, and the profiles", func() {
+			_, err := configloader.ParseValue("temprature", "1")
+			Expect(err).To(MatchError("unknown setting temprature, did you mean temperature?"))
+
+			Expect(configloader.CheckKey("favorite_color")).To(MatchError("unknown setting favorite_color"))
+			Expect(configloader.CheckKey("profiles")).To(MatchError(ContainSubstring("edit them in the config file")))
+			Expect(configloader.CheckKey("model")).To(Succeed())
+		})
+	})
+
+	it("returns the keys of the settings", func() {
+		Expect(configloader.Keys()).To(ContainElements("model", "temperature", "max_tokens", "role", "url", "history_dir", "aliases"))
+		Expect(configloader.Keys()).NotTo(ContainElement(""))
+	})
+}
@@ -0,0 +1,163 @@
+package configloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// EnvPrefix prefixes the variables of the settings that don't depend on the name of the config,
+// such as CHATGPT_CLI_MODEL for model
+const EnvPrefix = "CHATGPT_CLI_"
+
+// Source is where the value of a setting comes from, from the highest precedence to the lowest.
+type Source string
+
+const (
+	SourceFlag    Source = "flag"
+	SourceEnv     Source = "env"
+	SourceProject Source = "project"
+	SourceProfile Source = "profile"
+	SourceConfig  Source = "config"
+	SourceDefault Source = "default"
+)
+
+// secrets are the settings whose values Settings hides
+var secrets = map[string]bool{"api_key": true, "api_keys": true, "history_passphrase": true, "client_key_passphrase": true}
+
+// IsSecret tells whether the setting is a secret, such as the API key, whose value is hidden.
+func IsSecret(key string) bool {
+	return secrets[key]
+}
+
+// Setting is the value a setting has once the flags, the environment, the project file, the
+// profile, the config file and the defaults are merged, and where it comes from.
+type Setting struct {
+	Key string `json:"key"`
+	// Variable is the variable of the setting, the one that set it when it comes from the
+	// environment
+	Variable string      `json:"variable"`
+	Value    interface{} `json:"value"`
+	Source   Source      `json:"source"`
+}
+
+// Variables returns the environment variables of the setting, the first one that is set taking
+// precedence: the one prefixed with the name of the config, such as OPENAI_MODEL, then the one
+// prefixed with EnvPrefix, such as CHATGPT_CLI_MODEL. The name and the profile, which decide the
+// prefix, only have the latter, and the profiles have none.
+func Variables(name, key string) []string {
+	upper := strings.ToUpper(key)
+
+	switch key {
+	case "profiles":
+		return nil
+	case "name", "profile":
+		return []string{EnvPrefix + upper}
+	case "organization":
+		// also read from the variables of the OpenAI SDKs
+		return []string{strings.ToUpper(name) + "_" + upper, EnvPrefix + upper, "OPENAI_ORG_ID"}
+	case "project":
+		return []string{strings.ToUpper(name) + "_" + upper, EnvPrefix + upper, "OPENAI_PROJECT_ID"}
+	}
+
+	return []string{strings.ToUpper(name) + "_" + upper, EnvPrefix + upper}
+}
+
+// Settings returns the settings of v, in the order of Keys, but for the profiles, with where their
+// values come from. The keys of flagged are the settings a flag that was given is bound to, and
+// project, which may be nil, is the project file Load read. The values of the secrets, such as the
+// API key, are hidden.
+func Settings(v *viper.Viper, flagged map[string]bool, project *Project) []Setting {
+	name := v.GetString("name")
+	profile := v.GetStringMap("profiles." + v.GetString("profile"))
+
+	var result []Setting
+	for _, key := range Keys() {
+		variables := Variables(name, key)
+		if len(variables) == 0 {
+			continue
+		}
+
+		// the variable of EnvPrefix is the one that works whatever the name, unless another one is set
+		setting := Setting{Key: key, Variable: EnvPrefix + strings.ToUpper(key), Value: v.Get(key), Source: SourceDefault}
+		variable, _, inEnv := lookupEnv(variables)
+		if inEnv {
+			setting.Variable = variable
+		}
+
+		switch _, inProfile := profile[key]; {
+		case flagged[key]:
+			setting.Source = SourceFlag
+		case inEnv:
+			setting.Source = SourceEnv
+		case project != nil && slices.Contains(project.Keys, key):
+			setting.Source = SourceProject
+		case inProfile || (key == "history_dir" && len(profile) > 0):
+			setting.Source = SourceProfile
+		case v.InConfig(key):
+			setting.Source = SourceConfig
+		}
+
+		if secrets[key] && setting.Value != "" && setting.Value != nil {
+			setting.Value = "********"
+		}
+		result = append(result, setting)
+	}
+
+	return result
+}
+
+// bindEnv makes v read the variables of the settings, and checks their values as those of the
+// config file are.
+func bindEnv(v *viper.Viper) error {
+	name := v.GetString("name")
+
+	known := kinds()
+	for _, key := range Keys() {
+		variables := Variables(name, key)
+		if len(variables) == 0 {
+			continue
+		}
+		_ = v.BindEnv(append([]string{key}, variables...)...)
+
+		variable, value, ok := lookupEnv(variables)
+		if !ok {
+			continue
+		}
+		if usage := checkEnv(known[key], value); usage != "" {
+			return fmt.Errorf("invalid %s %q, %s", variable, value, usage)
+		}
+	}
+
+	return nil
+}
+
+// checkEnv returns how to set a value of the type in a variable, when the value can't be one. The
+// maps are JSON objects, which viper reads from a variable.
+func checkEnv(t reflect.Type, value string) string {
+	if t.Kind() == reflect.Map {
+		var m map[string]interface{}
+		if err := json.Unmarshal([]byte(value), &m); err != nil {
+			return `use a JSON object of names to values, such as {"X-Team": "cli"}`
+		}
+		return ""
+	}
+
+	return checkValue(t, &yaml.Node{Kind: yaml.ScalarNode, Value: value})
+}
+
+// lookupEnv returns the first of the variables that is set, as viper reads them: an empty one is
+// unset.
+func lookupEnv(variables []string) (string, string, bool) {
+	for _, variable := range variables {
+		if value := os.Getenv(variable); value != "" {
+			return variable, value, true
+		}
+	}
+	return "", "", false
+}
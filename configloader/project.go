@@ -0,0 +1,260 @@
+package configloader
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/utils"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+)
+
+const (
+	// ProjectFileName is the name of the project file, which overlays the config file for the
+	// directory it is in and those below it
+	ProjectFileName = ".chatgpt-cli.yaml"
+	// TrustFileName is the name of the file of the config home that lists the trusted directories
+	TrustFileName = "trusted.json"
+)
+
+// sensitive are the settings a project file only sets when its directory is trusted: those that
+// could send the requests, the API key or the history elsewhere, such as a history_backend of a
+// bucket of someone else, turn the redaction of the secrets off, or run a program, which a
+// repository that was cloned shouldn't be able to do.
+var sensitive = map[string]bool{
+	"name": true, "api_key": true, "api_keys": true, "credential_store": true, "credential": true,
+	"api_key_env": true, "provider": true, "azure_deployment": true, "azure_api_version": true,
+	"organization": true, "project": true, "url": true, "completions_path": true, "models_path": true,
+	"auth_header": true, "auth_token_prefix": true, "allow_auth_override": true, "headers": true,
+	"proxy": true, "ca_bundle": true, "tls_min_version": true, "client_cert": true, "client_key": true,
+	"client_key_passphrase": true, "skip_tls_verify": true, "dns_resolver": true, "dial_overrides": true,
+	"prompt_url_hosts": true, "history_backend": true, "history_dir": true, "history_passphrase": true,
+	"history_key_file": true, "redact_secrets": true, "redact_query": true, "redact_patterns_file": true,
+	"pager_command": true, "aliases": true, "profiles": true,
+}
+
+// IsSensitive tells whether the setting is one a project file only sets when its directory is
+// trusted, such as the URL of the API.
+func IsSensitive(key string) bool {
+	return sensitive[key]
+}
+
+// Project is the project file that overlays the config file, found by FindProject.
+type Project struct {
+	// Path is the path of the file, none when there is no project file
+	Path string
+	// Trusted tells whether its directory is trusted, so its sensitive settings are used
+	Trusted bool
+	// Keys are the settings the file sets, once Load read it, sorted
+	Keys []string
+	// Ignored are the sensitive settings the file sets that are ignored, as its directory isn't
+	// trusted, sorted
+	Ignored []string
+}
+
+// Dir returns the directory of the project file, the root of the project.
+func (p *Project) Dir() string {
+	return filepath.Dir(p.Path)
+}
+
+// FindProject returns the path of the project file of dir or of the nearest directory above it,
+// which the search stops at once it reaches the root of a git repository, the directory with a
+// .git, or the root of the file system. It returns false when there is none.
+func FindProject(dir string) (string, bool) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", false
+	}
+
+	for {
+		path := filepath.Join(dir, ProjectFileName)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path, true
+		}
+
+		// a .git file is a worktree or a submodule, whose root it is too
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return "", false
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+// readProject reads the settings of the project file into the project, with the warnings of the
+// keys that aren't settings. The sensitive settings are left out of those it returns unless the
+// project is trusted.
+func readProject(project *Project) (map[string]interface{}, []string, error) {
+	data, err := os.ReadFile(project.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	unknown, err := Check(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid %s: %w", project.Path, err)
+	}
+
+	var warnings []string
+	keys := map[string]bool{}
+	for _, u := range unknown {
+		if u.Profile == "" {
+			keys[u.Key] = true
+		}
+		warnings = append(warnings, u.warning(project.Path))
+	}
+
+	var settings map[string]interface{}
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return nil, nil, fmt.Errorf("invalid %s: %w", project.Path, err)
+	}
+
+	project.Keys, project.Ignored = nil, nil
+	for key := range settings {
+		switch {
+		case keys[key]:
+			delete(settings, key)
+		case sensitive[key] && !project.Trusted:
+			project.Ignored = append(project.Ignored, key)
+			delete(settings, key)
+		default:
+			project.Keys = append(project.Keys, key)
+		}
+	}
+	sort.Strings(project.Keys)
+	sort.Strings(project.Ignored)
+
+	return settings, warnings, nil
+}
+
+// Trust is the list of the directories the user trusts, whose project files may set the sensitive
+// settings. It is kept in a JSON file that only the user can write.
+type Trust struct {
+	path string
+}
+
+// NewTrust returns the trust of the file, which is created with the first directory.
+func NewTrust(path string) *Trust {
+	return &Trust{path: path}
+}
+
+// Trusted tells whether the directory is one of the trusted ones. A directory below a trusted one
+// isn't trusted, each project is trusted on its own.
+func (t *Trust) Trusted(dir string) (bool, error) {
+	dir, err := canonical(dir)
+	if err != nil {
+		return false, err
+	}
+
+	dirs, err := t.read()
+	if err != nil {
+		return false, err
+	}
+
+	return slices.Contains(dirs, dir), nil
+}
+
+// Add trusts the directory, which must exist. It returns false when it was already trusted.
+func (t *Trust) Add(dir string) (bool, error) {
+	dir, err := canonical(dir)
+	if err != nil {
+		return false, err
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return false, fmt.Errorf("%s is not a directory", dir)
+	}
+
+	dirs, err := t.read()
+	if err != nil {
+		return false, err
+	}
+	if slices.Contains(dirs, dir) {
+		return false, nil
+	}
+
+	dirs = append(dirs, dir)
+	sort.Strings(dirs)
+	return true, t.write(dirs)
+}
+
+// Remove stops trusting the directory. It returns false when it wasn't trusted.
+func (t *Trust) Remove(dir string) (bool, error) {
+	dir, err := canonical(dir)
+	if err != nil {
+		return false, err
+	}
+
+	dirs, err := t.read()
+	if err != nil {
+		return false, err
+	}
+
+	i := slices.Index(dirs, dir)
+	if i < 0 {
+		return false, nil
+	}
+
+	return true, t.write(slices.Delete(dirs, i, i+1))
+}
+
+// Dirs returns the trusted directories, sorted.
+func (t *Trust) Dirs() ([]string, error) {
+	return t.read()
+}
+
+// canonical returns the absolute path of the directory, its links resolved when it exists, so a
+// directory is trusted whatever the path it is reached by.
+func canonical(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		return resolved, nil
+	}
+	return dir, nil
+}
+
+// read returns the directories of the file, none when there is no file.
+func (t *Trust) read() ([]string, error) {
+	data, err := os.ReadFile(t.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var dirs []string
+	if err := json.Unmarshal(data, &dirs); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", t.path, err)
+	}
+
+	return dirs, nil
+}
+
+// write replaces the file with the directories, at once, so an error never leaves it half written.
+func (t *Trust) write(dirs []string) error {
+	if dirs == nil {
+		dirs = []string{}
+	}
+
+	data, err := json.MarshalIndent(dirs, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(t.path), 0700); err != nil {
+		return err
+	}
+
+	return utils.WriteFileAtomic(t.path, append(data, '\n'), 0600)
+}
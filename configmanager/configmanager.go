@@ -3,6 +3,7 @@ package configmanager
 import (
 	"fmt"
 	"github.com/kardolus/chatgpt-cli/config"
+	"github.com/kardolus/chatgpt-cli/history"
 	"github.com/kardolus/chatgpt-cli/types"
 	"gopkg.in/yaml.v3"
 	"os"
@@ -38,7 +39,12 @@ func (c *ConfigManager) APIKeyEnvVarName() string {
 
 // DeleteThread removes the specified thread from the configuration store.
 // This operation is idempotent; non-existent threads do not cause errors.
+// Thread names that could point outside the history directory are rejected.
 func (c *ConfigManager) DeleteThread(thread string) error {
+	if err := history.ValidateThread(thread); err != nil {
+		return err
+	}
+
 	return c.configStore.Delete(thread)
 }
 
@@ -339,6 +339,14 @@ func testConfig(t *testing.T, when spec.G, it spec.S) {
 
 			Expect(err).NotTo(HaveOccurred())
 		})
+		it("rejects thread names that escape the history directory", func() {
+			mockConfigStore.EXPECT().Delete(gomock.Any()).Times(0)
+
+			err := subject.DeleteThread("../config")
+
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("invalid thread name"))
+		})
 	})
 
 	when("ListThreads()", func() {
@@ -0,0 +1,3 @@
+// Package console prepares the terminal the CLI writes to for the ANSI escape sequences that color
+// the answers, draw the spinner and move the cursor.
+package console
@@ -0,0 +1,11 @@
+//go:build !windows
+
+package console
+
+import "os"
+
+// EnableANSI tells whether the terminal of the file interprets the ANSI escape sequences, which the
+// terminals of Unix always do.
+func EnableANSI(file *os.File) bool {
+	return true
+}
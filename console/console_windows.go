@@ -0,0 +1,26 @@
+//go:build windows
+
+package console
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// EnableANSI turns on the virtual terminal processing of the console of the file, so it interprets
+// the ANSI escape sequences, and tells whether it does. The consoles older than Windows 10 can't,
+// and print the sequences as they are, as does a file that isn't a console.
+func EnableANSI(file *os.File) bool {
+	handle := windows.Handle(file.Fd())
+
+	var mode uint32
+	if err := windows.GetConsoleMode(handle, &mode); err != nil {
+		return false
+	}
+	if mode&windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING != 0 {
+		return true
+	}
+
+	return windows.SetConsoleMode(handle, mode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING) == nil
+}
@@ -0,0 +1,169 @@
+// Package credentials keeps the API keys in the keychain of the system: the Keychain of macOS, the
+// Secret Service of Linux or the Credential Manager of Windows. Where the system has none, or when
+// asked to, they are kept in a file only the user can read.
+package credentials
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/utils"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+const (
+	BackendKeychain = "keychain"
+	BackendFile     = "file"
+
+	// Service is the service the keys are stored under in the keychain
+	Service = "chatgpt-cli"
+	// FileName is the name of the file of the directory the File keeps the keys in
+	FileName = "credentials.json"
+)
+
+// ErrNotFound is a key that isn't stored.
+var ErrNotFound = errors.New("no API key is stored")
+
+// Store keeps the API keys by account, the name of the config they are the key of, such as openai.
+type Store interface {
+	// Name names where the keys are kept, such as the macOS Keychain
+	Name() string
+	// Get returns the key of the account, or ErrNotFound
+	Get(account string) (string, error)
+	// Set stores the key of the account, replacing the one it had
+	Set(account, key string) error
+	// Delete removes the key of the account, or returns ErrNotFound
+	Delete(account string) error
+}
+
+// Open returns the store of the backend: the keychain of the system for BackendKeychain, or the
+// File of the directory for BackendFile. Where the system has no keychain, such as a server
+// without a D-Bus session, the File is returned instead, and its Unavailable tells why.
+func Open(backend, dir string) (Store, error) {
+	path := filepath.Join(dir, FileName)
+
+	switch backend {
+	case BackendFile:
+		return NewFile(path), nil
+	case BackendKeychain, "":
+		keychain, err := system()
+		if err != nil {
+			file := NewFile(path)
+			file.unavailable = err
+			return file, nil
+		}
+		return keychain, nil
+	}
+
+	return nil, fmt.Errorf("unknown credential store %q, use %s or %s", backend, BackendKeychain, BackendFile)
+}
+
+// File keeps the keys in a JSON file that only the user can read and write.
+type File struct {
+	path string
+	// unavailable is why the keychain of the system isn't used instead
+	unavailable error
+}
+
+// NewFile returns the store of the file, which is created with the first key.
+func NewFile(path string) *File {
+	return &File{path: path}
+}
+
+// Name returns the path of the file.
+func (f *File) Name() string {
+	return f.path
+}
+
+// Unavailable returns why the keychain of the system is unavailable, when the file is used in its
+// place, and nil when the file was asked for.
+func (f *File) Unavailable() error {
+	return f.unavailable
+}
+
+// Get returns the key of the account. A file others can read is refused, as its keys may have been
+// read.
+func (f *File) Get(account string) (string, error) {
+	keys, err := f.read()
+	if err != nil {
+		return "", err
+	}
+
+	key, ok := keys[account]
+	if !ok {
+		return "", ErrNotFound
+	}
+
+	return key, nil
+}
+
+// Set stores the key of the account.
+func (f *File) Set(account, key string) error {
+	keys, err := f.read()
+	if err != nil {
+		return err
+	}
+
+	keys[account] = key
+	return f.write(keys)
+}
+
+// Delete removes the key of the account.
+func (f *File) Delete(account string) error {
+	keys, err := f.read()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := keys[account]; !ok {
+		return ErrNotFound
+	}
+
+	delete(keys, account)
+	return f.write(keys)
+}
+
+// read returns the keys of the file by account, none when there is no file.
+func (f *File) read() (map[string]string, error) {
+	keys := map[string]string{}
+
+	info, err := os.Stat(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return keys, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Windows doesn't have the permissions of the other systems
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("%s can be read by others, make it only readable by you with chmod 600 %s", f.path, f.path)
+	}
+
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", f.path, err)
+	}
+
+	return keys, nil
+}
+
+// write replaces the file with the keys, at once, so an error never leaves it half written.
+func (f *File) write(keys map[string]string) error {
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(f.path), 0700); err != nil {
+		return err
+	}
+
+	return utils.WriteFileAtomic(f.path, data, 0600)
+}
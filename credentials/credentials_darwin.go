@@ -0,0 +1,75 @@
+package credentials
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// notFound is the exit code of security for an item the keychain doesn't have
+const notFound = 44
+
+// keychain keeps the keys as generic passwords of the login Keychain, with the security tool.
+type keychain struct{}
+
+// system returns the Keychain, which the security tool of macOS reads and writes.
+func system() (Store, error) {
+	if _, err := exec.LookPath("security"); err != nil {
+		return nil, errors.New("the security tool of macOS isn't installed")
+	}
+	return keychain{}, nil
+}
+
+func (keychain) Name() string {
+	return "the macOS Keychain"
+}
+
+func (keychain) Get(account string) (string, error) {
+	output, err := security(nil, "find-generic-password", "-s", Service, "-a", account, "-w")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(output, "\n"), nil
+}
+
+// Set stores the key with the commands of security read from its input, so the key isn't one of
+// its arguments, which other processes can see.
+func (keychain) Set(account, key string) error {
+	command := fmt.Sprintf("add-generic-password -U -s %s -a %s -w %s\n", strconv.Quote(Service), strconv.Quote(account), strconv.Quote(key))
+	_, err := security(strings.NewReader(command), "-i")
+	return err
+}
+
+func (keychain) Delete(account string) error {
+	_, err := security(nil, "delete-generic-password", "-s", Service, "-a", account)
+	return err
+}
+
+// security runs the security tool with the arguments and returns what it printed. An item the
+// Keychain doesn't have is an ErrNotFound.
+func security(input *strings.Reader, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	command := exec.Command("security", args...)
+	command.Stdout, command.Stderr = &stdout, &stderr
+	if input != nil {
+		command.Stdin = input
+	}
+
+	err := command.Run()
+
+	// the commands read from the input fail without failing security, they only tell it
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil && (input == nil || stderr.Len() == 0):
+		return stdout.String(), nil
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == notFound:
+		return "", ErrNotFound
+	case stderr.Len() > 0:
+		return "", fmt.Errorf("the macOS Keychain failed: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	return "", fmt.Errorf("the macOS Keychain failed: %w", err)
+}
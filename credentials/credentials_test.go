@@ -0,0 +1,114 @@
+package credentials_test
+
+import (
+	"github.com/kardolus/chatgpt-cli/credentials"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitCredentials(t *testing.T) {
+	spec.Run(t, "Testing the credentials", testCredentials, spec.Report(report.Terminal{}))
+}
+
+func testCredentials(t *testing.T, when spec.G, it spec.S) {
+	var (
+		dir   string
+		path  string
+		store *credentials.File
+	)
+
+	it.Before(func() {
+		RegisterTestingT(t)
+		dir = t.TempDir()
+		path = filepath.Join(dir, "nested", credentials.FileName)
+		store = credentials.NewFile(path)
+	})
+
+	when("the keys are kept in a file", func() {
+		it("has no key before one is stored", func() {
+			_, err := store.Get("openai")
+			Expect(err).To(MatchError(credentials.ErrNotFound))
+			Expect(store.Delete("openai")).To(MatchError(credentials.ErrNotFound))
+		})
+		it("stores, replaces and removes the key of each account", func() {
+			Expect(store.Set("openai", "sk-1")).To(Succeed())
+			Expect(store.Set("azure", "az-1")).To(Succeed())
+			Expect(store.Set("openai", "sk-2")).To(Succeed())
+
+			key, err := store.Get("openai")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(key).To(Equal("sk-2"))
+
+			Expect(store.Delete("openai")).To(Succeed())
+			_, err = store.Get("openai")
+			Expect(err).To(MatchError(credentials.ErrNotFound))
+
+			key, err = store.Get("azure")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(key).To(Equal("az-1"))
+		})
+		it("creates the file only readable by the user", func() {
+			if runtime.GOOS == "windows" {
+				t.Skip("Windows doesn't have the permissions of the other systems")
+			}
+			Expect(store.Set("openai", "sk-1")).To(Succeed())
+
+			info, err := os.Stat(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+
+			entries, err := os.ReadDir(filepath.Dir(path))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries).To(HaveLen(1))
+		})
+		it("refuses a file others can read", func() {
+			if runtime.GOOS == "windows" {
+				t.Skip("Windows doesn't have the permissions of the other systems")
+			}
+			Expect(store.Set("openai", "sk-1")).To(Succeed())
+			Expect(os.Chmod(path, 0644)).To(Succeed())
+
+			_, err := store.Get("openai")
+			Expect(err).To(MatchError(ContainSubstring("can be read by others")))
+			Expect(store.Set("openai", "sk-2")).To(MatchError(ContainSubstring("chmod 600")))
+		})
+		it("fails on a file that isn't JSON", func() {
+			Expect(os.MkdirAll(filepath.Dir(path), 0700)).To(Succeed())
+			Expect(os.WriteFile(path, []byte("sk-1"), 0600)).To(Succeed())
+
+			_, err := store.Get("openai")
+			Expect(err).To(MatchError(ContainSubstring("invalid " + path)))
+		})
+	})
+
+	when("Open()", func() {
+		it("returns the file of the directory for the file backend", func() {
+			opened, err := credentials.Open(credentials.BackendFile, dir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(opened.Name()).To(Equal(filepath.Join(dir, credentials.FileName)))
+			Expect(opened.(*credentials.File).Unavailable()).To(BeNil())
+		})
+		it("falls back on the file, telling why, when the system has no keychain", func() {
+			t.Setenv("PATH", "")
+			t.Setenv("DBUS_SESSION_BUS_ADDRESS", "")
+			if runtime.GOOS == "windows" {
+				t.Skip("every Windows has the Credential Manager")
+			}
+
+			opened, err := credentials.Open(credentials.BackendKeychain, dir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(opened.Name()).To(Equal(filepath.Join(dir, credentials.FileName)))
+			Expect(opened.(*credentials.File).Unavailable()).To(HaveOccurred())
+		})
+		it("fails for an unknown backend", func() {
+			_, err := credentials.Open("vault", dir)
+			Expect(err).To(MatchError(`unknown credential store "vault", use keychain or file`))
+		})
+	})
+}
@@ -0,0 +1,90 @@
+//go:build !darwin && !windows
+
+package credentials
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// secretService keeps the keys in the Secret Service of the desktop, such as GNOME Keyring or
+// KWallet, which secret-tool of libsecret speaks to over D-Bus.
+type secretService struct{}
+
+// system returns the Secret Service, when secret-tool is installed and the session has a D-Bus bus
+// to reach it on, which a session over SSH often doesn't.
+func system() (Store, error) {
+	if _, err := exec.LookPath("secret-tool"); err != nil {
+		return nil, errors.New("secret-tool isn't installed, install libsecret-tools to use the Secret Service")
+	}
+	if os.Getenv("DBUS_SESSION_BUS_ADDRESS") == "" {
+		return nil, errors.New("the session has no D-Bus bus to reach the Secret Service on")
+	}
+	return secretService{}, nil
+}
+
+func (secretService) Name() string {
+	return "the Secret Service"
+}
+
+// Get returns the key, which secret-tool prints without a newline. secret-tool fails without a
+// message for a key it doesn't have.
+func (secretService) Get(account string) (string, error) {
+	output, message, err := secretTool(nil, "lookup", "service", Service, "account", account)
+	if err != nil && message == "" {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("the Secret Service failed: %s", message)
+	}
+	return output, nil
+}
+
+// Set stores the key, which secret-tool reads from its input, so it isn't one of its arguments,
+// which other processes can see.
+func (secretService) Set(account, key string) error {
+	label := fmt.Sprintf("API key of %s for %s", account, Service)
+	_, message, err := secretTool(strings.NewReader(key), "store", "--label", label, "service", Service, "account", account)
+	if err != nil {
+		return fmt.Errorf("the Secret Service failed: %s", message)
+	}
+	return nil
+}
+
+// Delete removes the key. secret-tool clears nothing without failing, so the key is looked up
+// first.
+func (s secretService) Delete(account string) error {
+	if _, err := s.Get(account); err != nil {
+		return err
+	}
+
+	_, message, err := secretTool(nil, "clear", "service", Service, "account", account)
+	if err != nil {
+		return fmt.Errorf("the Secret Service failed: %s", message)
+	}
+	return nil
+}
+
+// secretTool runs secret-tool with the arguments, and returns what it printed and the message of
+// its failure, or of how it was run when it printed none.
+func secretTool(input *strings.Reader, args ...string) (string, string, error) {
+	var stdout, stderr bytes.Buffer
+	command := exec.Command("secret-tool", args...)
+	command.Stdout, command.Stderr = &stdout, &stderr
+	if input != nil {
+		command.Stdin = input
+	}
+
+	err := command.Run()
+
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return "", err.Error(), err
+	}
+
+	return stdout.String(), strings.TrimSpace(stderr.String()), err
+}
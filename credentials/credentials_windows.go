@@ -0,0 +1,122 @@
+package credentials
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	credTypeGeneric         = 1 // CRED_TYPE_GENERIC
+	credPersistLocalMachine = 2 // CRED_PERSIST_LOCAL_MACHINE
+)
+
+var (
+	advapi32 = windows.NewLazySystemDLL("advapi32.dll")
+
+	credRead   = advapi32.NewProc("CredReadW")
+	credWrite  = advapi32.NewProc("CredWriteW")
+	credDelete = advapi32.NewProc("CredDeleteW")
+	credFree   = advapi32.NewProc("CredFree")
+)
+
+// credential is the CREDENTIALW of the Windows API.
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        windows.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+// credentialManager keeps the keys as generic credentials of the Credential Manager, each under a
+// target of the service and the account, such as chatgpt-cli:openai.
+type credentialManager struct{}
+
+// system returns the Credential Manager, which every Windows has.
+func system() (Store, error) {
+	if err := credRead.Find(); err != nil {
+		return nil, fmt.Errorf("the Credential Manager is unavailable: %w", err)
+	}
+	return credentialManager{}, nil
+}
+
+func (credentialManager) Name() string {
+	return "the Windows Credential Manager"
+}
+
+func (credentialManager) Get(account string) (string, error) {
+	target, err := windows.UTF16PtrFromString(Service + ":" + account)
+	if err != nil {
+		return "", err
+	}
+
+	var cred *credential
+	if ok, _, err := credRead.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred))); ok == 0 {
+		return "", managerError(err)
+	}
+	defer credFree.Call(uintptr(unsafe.Pointer(cred)))
+
+	// the blob belongs to the credential, so it is copied before it is freed
+	return string(unsafe.Slice(cred.CredentialBlob, cred.CredentialBlobSize)), nil
+}
+
+func (credentialManager) Set(account, key string) error {
+	if key == "" {
+		return errors.New("the API key is empty")
+	}
+
+	target, err := windows.UTF16PtrFromString(Service + ":" + account)
+	if err != nil {
+		return err
+	}
+	user, err := windows.UTF16PtrFromString(account)
+	if err != nil {
+		return err
+	}
+
+	blob := []byte(key)
+	cred := credential{
+		Type:               credTypeGeneric,
+		TargetName:         target,
+		CredentialBlobSize: uint32(len(blob)),
+		CredentialBlob:     &blob[0],
+		Persist:            credPersistLocalMachine,
+		UserName:           user,
+	}
+
+	if ok, _, err := credWrite.Call(uintptr(unsafe.Pointer(&cred)), 0); ok == 0 {
+		return managerError(err)
+	}
+	return nil
+}
+
+func (credentialManager) Delete(account string) error {
+	target, err := windows.UTF16PtrFromString(Service + ":" + account)
+	if err != nil {
+		return err
+	}
+
+	if ok, _, err := credDelete.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0); ok == 0 {
+		return managerError(err)
+	}
+	return nil
+}
+
+// managerError returns the error of a call of the Credential Manager, an ErrNotFound for a
+// credential it doesn't have.
+func managerError(err error) error {
+	if errors.Is(err, windows.ERROR_NOT_FOUND) {
+		return ErrNotFound
+	}
+	return fmt.Errorf("the Windows Credential Manager failed: %w", err)
+}
@@ -0,0 +1,184 @@
+// Package doctor reports what chatgpt doctor checks of the setup of the CLI: each check tells
+// whether a part of it works, such as the config file or the API key, and how to fix it when it
+// doesn't. The report is printed as text, or as JSON to attach to a bug report.
+package doctor
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/buildinfo"
+	"github.com/kardolus/chatgpt-cli/configloader"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// MaxClockSkew is how far the clock of the system may be off the one of the API before the check
+// of the clock warns.
+const MaxClockSkew = time.Minute
+
+// Status is how a check went. A check that fails is critical, the CLI doesn't work until it is
+// fixed, while one that warns tells of what may get in the way.
+type Status string
+
+const (
+	Pass Status = "pass"
+	Warn Status = "warn"
+	Fail Status = "fail"
+)
+
+// proxyVariables are the variables of the environment the requests take their proxy from, in the
+// order they are told.
+var proxyVariables = []string{"HTTPS_PROXY", "HTTP_PROXY", "ALL_PROXY", "NO_PROXY"}
+
+// Check is the result of a check, with a hint of how to fix it when it didn't pass.
+type Check struct {
+	Name   string `json:"name"`
+	Status Status `json:"status"`
+	Detail string `json:"detail"`
+	Hint   string `json:"hint,omitempty"`
+}
+
+// Passed returns a check that passed.
+func Passed(name, detail string) Check {
+	return Check{Name: name, Status: Pass, Detail: detail}
+}
+
+// Warned returns a check that warns, with the hint of how to fix it.
+func Warned(name, detail, hint string) Check {
+	return Check{Name: name, Status: Warn, Detail: detail, Hint: hint}
+}
+
+// Failed returns a check that failed, with the hint of how to fix it.
+func Failed(name, detail, hint string) Check {
+	return Check{Name: name, Status: Fail, Detail: detail, Hint: hint}
+}
+
+// Report is what chatgpt doctor found: the build of the CLI, the settings in use and where each
+// of them comes from, and the checks in the order they ran.
+type Report struct {
+	Build    buildinfo.Info         `json:"build"`
+	Settings []configloader.Setting `json:"settings,omitempty"`
+	Checks   []Check                `json:"checks"`
+}
+
+// Failures returns how many of the checks failed.
+func (r Report) Failures() int {
+	count := 0
+	for _, check := range r.Checks {
+		if check.Status == Fail {
+			count++
+		}
+	}
+	return count
+}
+
+// Write writes the report as text: the build, the settings that aren't at their defaults, then a
+// line per check, with its hint below it, and how many checks passed, warned and failed.
+func (r Report) Write(w io.Writer) error {
+	var b strings.Builder
+
+	_, _ = fmt.Fprintf(&b, "ChatGPT CLI %s, %s, %s/%s\n", r.Build.Version, r.Build.GoVersion, r.Build.OS, r.Build.Arch)
+
+	var changed []configloader.Setting
+	width := 0
+	for _, setting := range r.Settings {
+		if setting.Source != configloader.SourceDefault {
+			changed = append(changed, setting)
+			width = max(width, len(setting.Key))
+		}
+	}
+	if len(changed) > 0 {
+		b.WriteString("\nSettings that aren't at their defaults:\n")
+		for _, setting := range changed {
+			_, _ = fmt.Fprintf(&b, "  %-*s  %-7s  %v\n", width, setting.Key, setting.Source, setting.Value)
+		}
+	}
+
+	b.WriteString("\n")
+	width = 0
+	for _, check := range r.Checks {
+		width = max(width, len(check.Name))
+	}
+
+	counts := map[Status]int{}
+	for _, check := range r.Checks {
+		counts[check.Status]++
+		_, _ = fmt.Fprintf(&b, "%-4s  %-*s  %s\n", strings.ToUpper(string(check.Status)), width, check.Name, check.Detail)
+		if check.Hint != "" {
+			_, _ = fmt.Fprintf(&b, "      %-*s  %s\n", width, "", check.Hint)
+		}
+	}
+
+	_, _ = fmt.Fprintf(&b, "\n%d checks: %d passed, %d warned, %d failed\n", len(r.Checks), counts[Pass], counts[Warn], counts[Fail])
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteJSON writes the report as an indented JSON object.
+func (r Report) WriteJSON(w io.Writer) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
+
+// ProxyVariables returns the proxy variables of the environment that are set, as NAME=value, the
+// passwords of their URLs hidden. The lowercase variables count as well, as the requests take them
+// when the uppercase one isn't set.
+func ProxyVariables(env func(string) string) []string {
+	var result []string
+	for _, name := range proxyVariables {
+		for _, variable := range []string{name, strings.ToLower(name)} {
+			value := env(variable)
+			if value == "" {
+				continue
+			}
+
+			if u, err := url.Parse(value); err == nil && u.User != nil {
+				value = u.Redacted()
+			}
+			result = append(result, variable+"="+value)
+			break
+		}
+	}
+
+	return result
+}
+
+// CheckDirectory returns an error when the directory can't be read or a file can't be written to
+// it. A directory that doesn't exist yet is no error, it is created along with the first file.
+func CheckDirectory(dir string) error {
+	if _, err := os.ReadDir(dir); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(dir, ".doctor-*")
+	if err != nil {
+		return err
+	}
+	_ = file.Close()
+
+	return os.Remove(filepath.Clean(file.Name()))
+}
+
+// FormatSkew tells how far the clock of the system is off the one of the API, whose clock is
+// ahead by skew.
+func FormatSkew(skew time.Duration) string {
+	switch {
+	case skew > 0:
+		return fmt.Sprintf("the clock is %s behind the one of the API", skew.Round(time.Second))
+	case skew < 0:
+		return fmt.Sprintf("the clock is %s ahead of the one of the API", (-skew).Round(time.Second))
+	}
+	return "the clock agrees with the one of the API"
+}
@@ -0,0 +1,130 @@
+package editor
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"unicode"
+)
+
+// ErrEmpty is a prompt that was left empty, or only holds spaces, which isn't sent.
+var ErrEmpty = errors.New("the prompt is empty, nothing was sent")
+
+// Editor composes the prompts in the editor of the Command, which is run with the file to edit as
+// its last argument, and waited for.
+type Editor struct {
+	Command []string
+	Stdin   io.Reader
+	Stdout  io.Writer
+	Stderr  io.Writer
+}
+
+// New returns an editor that runs the command on the terminal of the CLI.
+func New(command []string) *Editor {
+	return &Editor{Command: command, Stdin: os.Stdin, Stdout: os.Stdout, Stderr: os.Stderr}
+}
+
+// Command returns the editor the user picked, with its arguments, such as code --wait: the one of
+// VISUAL, or else of EDITOR, or else the one every system of the OS has, vi or notepad. A path with
+// spaces is quoted, such as "C:\Program Files\Notepad++\notepad++.exe" -multiInst on Windows.
+func Command(goos string, getenv func(string) string) []string {
+	for _, name := range []string{"VISUAL", "EDITOR"} {
+		if fields := split(getenv(name)); len(fields) > 0 {
+			return fields
+		}
+	}
+
+	if goos == "windows" {
+		return []string{"notepad"}
+	}
+
+	return []string{"vi"}
+}
+
+// split splits the command at the spaces that aren't in double quotes, which are removed. The
+// backslashes are kept, they separate the directories of the paths of Windows.
+func split(command string) []string {
+	var (
+		fields  []string
+		field   strings.Builder
+		quoted  bool
+		pending bool
+	)
+
+	for _, r := range command {
+		switch {
+		case r == '"':
+			quoted, pending = !quoted, true
+		case unicode.IsSpace(r) && !quoted:
+			if pending {
+				fields = append(fields, field.String())
+				field.Reset()
+				pending = false
+			}
+		default:
+			field.WriteRune(r)
+			pending = true
+		}
+	}
+	if pending {
+		fields = append(fields, field.String())
+	}
+
+	return fields
+}
+
+// Compose opens the editor on a temporary file that holds the initial text, ended with a newline
+// as the editors end the lines they save, and returns what the file holds once the editor exited,
+// without the spaces and the newlines it ends with. The file is only readable by the user, since
+// the prompts can be sensitive, and is removed once it was read.
+// A prompt left empty is an ErrEmpty, and an editor that exited with an error sends nothing either.
+func (e *Editor) Compose(initial string) (string, error) {
+	if len(e.Command) == 0 {
+		return "", errors.New("no editor is set, set VISUAL or EDITOR")
+	}
+
+	// CreateTemp creates the file with 0600
+	file, err := os.CreateTemp("", "chatgpt-prompt-*.md")
+	if err != nil {
+		return "", fmt.Errorf("failed to create the file of the prompt: %w", err)
+	}
+	defer os.Remove(file.Name())
+
+	if initial != "" && !strings.HasSuffix(initial, "\n") {
+		initial += "\n"
+	}
+
+	_, err = file.WriteString(initial)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to write the file of the prompt: %w", err)
+	}
+
+	cmd := exec.Command(e.Command[0], append(e.Command[1:], file.Name())...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = e.Stdin, e.Stdout, e.Stderr
+
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return "", fmt.Errorf("the editor %s exited with status %d, nothing was sent", e.Command[0], exitErr.ExitCode())
+		}
+		return "", fmt.Errorf("failed to run the editor %s: %w", e.Command[0], err)
+	}
+
+	data, err := os.ReadFile(file.Name())
+	if err != nil {
+		return "", fmt.Errorf("failed to read the file of the prompt: %w", err)
+	}
+
+	prompt := strings.TrimRight(string(data), " \t\r\n")
+	if strings.TrimSpace(prompt) == "" {
+		return "", ErrEmpty
+	}
+
+	return prompt, nil
+}
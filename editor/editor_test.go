@@ -0,0 +1,98 @@
+package editor_test
+
+import (
+	"github.com/kardolus/chatgpt-cli/editor"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitEditor(t *testing.T) {
+	spec.Run(t, "Testing the editor", testEditor, spec.Report(report.Terminal{}))
+}
+
+func testEditor(t *testing.T, when spec.G, it spec.S) {
+	var dir string
+
+	it.Before(func() {
+		RegisterTestingT(t)
+
+		dir = t.TempDir()
+	})
+
+	// script returns an editor that runs the shell script with the file to edit as $1
+	script := func(script string) *editor.Editor {
+		e := editor.New([]string{"sh", "-c", script, "sh"})
+		e.Stdin, e.Stdout, e.Stderr = nil, nil, nil
+		return e
+	}
+
+	when("Command()", func() {
+		it("prefers VISUAL to EDITOR, and keeps the arguments", func() {
+			env := map[string]string{"VISUAL": "code --wait", "EDITOR": "nano"}
+			Expect(editor.Command("linux", func(key string) string { return env[key] })).To(Equal([]string{"code", "--wait"}))
+
+			delete(env, "VISUAL")
+			Expect(editor.Command("linux", func(key string) string { return env[key] })).To(Equal([]string{"nano"}))
+		})
+		it("keeps the quoted paths with spaces whole", func() {
+			env := map[string]string{"EDITOR": `"C:\Program Files\Notepad++\notepad++.exe" -multiInst  -nosession`}
+			Expect(editor.Command("windows", func(key string) string { return env[key] })).To(Equal([]string{
+				`C:\Program Files\Notepad++\notepad++.exe`, "-multiInst", "-nosession",
+			}))
+		})
+		it("falls back to the editor of the OS", func() {
+			none := func(string) string { return " " }
+			Expect(editor.Command("linux", none)).To(Equal([]string{"vi"}))
+			Expect(editor.Command("windows", none)).To(Equal([]string{"notepad"}))
+		})
+	})
+
+	when("Compose()", func() {
+		it("returns what the editor saved, without the newlines it ends with", func() {
+			prompt, err := script(`printf 'first paragraph\n\nsecond paragraph\n\n' > "$1"`).Compose("")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(prompt).To(Equal("first paragraph\n\nsecond paragraph"))
+		})
+		it("opens the file with the initial text", func() {
+			prompt, err := script(`printf 'and more\n' >> "$1"`).Compose("the previous prompt")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(prompt).To(Equal("the previous prompt\nand more"))
+		})
+		it("creates the file only readable by the user, and removes it", func() {
+			record := filepath.Join(dir, "record")
+
+			_, err := script(`ls -l "$1" | cut -c1-10 > "` + record + `"; echo "$1" >> "` + record + `"; echo x > "$1"`).Compose("")
+			Expect(err).NotTo(HaveOccurred())
+
+			data, err := os.ReadFile(record)
+			Expect(err).NotTo(HaveOccurred())
+			lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+			Expect(lines).To(HaveLen(2))
+			Expect(lines[0]).To(Equal("-rw-------"))
+			Expect(lines[1]).NotTo(BeAnExistingFile())
+		})
+		it("sends nothing when the prompt is left empty", func() {
+			_, err := script(`printf '  \n\n' > "$1"`).Compose("")
+			Expect(err).To(MatchError(editor.ErrEmpty))
+
+			_, err = script(`:`).Compose("")
+			Expect(err).To(MatchError(editor.ErrEmpty))
+		})
+		it("sends nothing when the editor exits with an error", func() {
+			_, err := script(`echo saved > "$1"; exit 3`).Compose("")
+			Expect(err).To(MatchError("the editor sh exited with status 3, nothing was sent"))
+		})
+		it("fails when the editor can't be run", func() {
+			e := editor.New([]string{filepath.Join(dir, "no-such-editor")})
+			_, err := e.Compose("")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(HavePrefix("failed to run the editor"))
+		})
+	})
+}
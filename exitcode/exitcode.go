@@ -0,0 +1,88 @@
+package exitcode
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+
+	"github.com/kardolus/chatgpt-cli/http"
+	"github.com/kardolus/chatgpt-cli/ledger"
+)
+
+// The exit codes of the CLI, which scripts branch on rather than on the messages of the errors.
+// They are stable, a new kind of failure gets a new code.
+const (
+	Success         = 0
+	General         = 1
+	Usage           = 2
+	Auth            = 3
+	RateLimited     = 4
+	ContextTooLong  = 5
+	Network         = 6
+	ContentFiltered = 7
+	OverBudget      = 8
+	Interrupted     = 130
+)
+
+// Error is an error that ends the command with its code, for the failures that no typed error
+// tells, such as a flag that doesn't apply to the command.
+type Error struct {
+	Code int
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Wrap returns the error with the exit code it ends the command with, nil when err is nil.
+func Wrap(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &Error{Code: code, Err: err}
+}
+
+// Of returns the exit code of the error the command failed with, the code of an *Error, or the one
+// of the kind of the error the client and the caller return, and General for the others.
+func Of(err error) int {
+	var (
+		coded    *Error
+		urlError *url.Error
+		opError  *net.OpError
+	)
+
+	switch {
+	case err == nil:
+		return Success
+	case errors.As(err, &coded):
+		return coded.Code
+	// a request that Ctrl-C canceled fails with a url error too, so this comes before those
+	case errors.Is(err, context.Canceled):
+		return Interrupted
+	case errors.Is(err, ledger.ErrOverBudget):
+		return OverBudget
+	case errors.Is(err, http.ErrUnauthorized):
+		return Auth
+	case errors.Is(err, http.ErrRateLimited):
+		return RateLimited
+	case errors.Is(err, http.ErrContextLengthExceeded):
+		return ContextTooLong
+	case errors.Is(err, http.ErrContentFiltered):
+		return ContentFiltered
+	case errors.Is(err, http.ErrTimeout), errors.Is(err, http.ErrTLSHandshake), errors.Is(err, http.ErrCircuitOpen):
+		return Network
+	// the requests that fail before an answer, such as when the API can't be reached, are url errors,
+	// and a connection that breaks later is an op error
+	case errors.As(err, &urlError), errors.As(err, &opError):
+		return Network
+	}
+
+	return General
+}
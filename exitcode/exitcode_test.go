@@ -0,0 +1,72 @@
+package exitcode_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/url"
+	"syscall"
+	"testing"
+
+	"github.com/kardolus/chatgpt-cli/exitcode"
+	"github.com/kardolus/chatgpt-cli/http"
+	"github.com/kardolus/chatgpt-cli/ledger"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitExitCode(t *testing.T) {
+	spec.Run(t, "Testing the exit codes", testExitCode, spec.Report(report.Terminal{}))
+}
+
+func testExitCode(t *testing.T, when spec.G, it spec.S) {
+	it.Before(func() {
+		RegisterTestingT(t)
+	})
+
+	when("Of()", func() {
+		it("succeeds without an error", func() {
+			Expect(exitcode.Of(nil)).To(Equal(exitcode.Success))
+		})
+		it("returns the code of each kind of error", func() {
+			refused := &url.Error{Op: "Post", URL: "https://api.openai.com", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}
+
+			for err, code := range map[error]int{
+				errors.New("something else"):                                      exitcode.General,
+				&fs.PathError{Op: "open", Path: "history", Err: syscall.ENOENT}:   exitcode.General,
+				exitcode.Wrap(exitcode.Usage, errors.New("--last only applies")):  exitcode.Usage,
+				&http.APIError{StatusCode: 401, Code: "invalid_api_key"}:          exitcode.Auth,
+				&http.APIError{StatusCode: 429, Code: "rate_limit_exceeded"}:      exitcode.RateLimited,
+				&http.APIError{StatusCode: 429, Code: "insufficient_quota"}:       exitcode.General,
+				&http.APIError{StatusCode: 400, Code: "context_length_exceeded"}:  exitcode.ContextTooLong,
+				&http.APIError{StatusCode: 400, Code: "content_policy_violation"}: exitcode.ContentFiltered,
+				fmt.Errorf("request req-1: %w", http.ErrContentFiltered):          exitcode.ContentFiltered,
+				fmt.Errorf("failed to make request: %w", refused):                 exitcode.Network,
+				fmt.Errorf("failed to read response: %w", http.ErrTimeout):        exitcode.Network,
+				http.ErrTLSHandshake: exitcode.Network,
+				http.ErrCircuitOpen:  exitcode.Network,
+				fmt.Errorf("the usage of 2024-06 is %w", ledger.ErrOverBudget):               exitcode.OverBudget,
+				exitcode.Wrap(exitcode.Auth, &http.APIError{StatusCode: 429}):                exitcode.Auth,
+				&url.Error{Op: "Post", URL: "https://api.openai.com", Err: context.Canceled}: exitcode.Interrupted,
+				fmt.Errorf("%w while waiting to retry: %w", context.Canceled, refused):       exitcode.Interrupted,
+				fmt.Errorf("wrapped: %w", exitcode.Wrap(exitcode.Usage, errors.New("x"))):    exitcode.Usage,
+			} {
+				Expect(exitcode.Of(err)).To(Equal(code), "the exit code of %v", err)
+			}
+		})
+	})
+
+	when("Wrap()", func() {
+		it("keeps the message and the kind of the error", func() {
+			err := exitcode.Wrap(exitcode.Usage, http.ErrTimeout)
+			Expect(err).To(MatchError("timeout"))
+			Expect(errors.Is(err, http.ErrTimeout)).To(BeTrue())
+
+			Expect(exitcode.Wrap(exitcode.Usage, nil)).To(BeNil())
+		})
+	})
+}
@@ -0,0 +1,175 @@
+package git
+
+import (
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/utils"
+	"slices"
+	"strings"
+)
+
+// minFileTokens is how many tokens the diff of a file is cut to at least, a file whose share of
+// the budget is smaller is left out rather than cut to its header.
+const minFileTokens = 64
+
+// FileDiff is the diff of a single file of a diff, its header included.
+type FileDiff struct {
+	Path    string
+	Text    string
+	Added   int
+	Removed int
+	Binary  bool
+}
+
+// Summary returns the path of the file with the count of its added and removed lines, such as
+// main.go (+12 -3).
+func (f FileDiff) Summary() string {
+	if f.Binary {
+		return f.Path + " (binary)"
+	}
+
+	return fmt.Sprintf("%s (+%d -%d)", f.Path, f.Added, f.Removed)
+}
+
+// SplitDiff splits the diff that git prints into the diffs of its files, in their order. What comes
+// before the diff of the first file, if anything, is left out.
+func SplitDiff(diff string) []FileDiff {
+	var (
+		files  []FileDiff
+		lines  []string
+		inHunk bool
+	)
+
+	flush := func() {
+		if len(files) > 0 {
+			files[len(files)-1].Text = strings.Join(lines, "\n") + "\n"
+		}
+		lines = nil
+	}
+
+	for _, line := range strings.Split(strings.TrimRight(diff, "\n"), "\n") {
+		if strings.HasPrefix(line, "diff --git ") {
+			flush()
+			files, inHunk = append(files, FileDiff{Path: headerPath(line)}), false
+		}
+		if len(files) == 0 {
+			continue
+		}
+		lines = append(lines, line)
+
+		file := &files[len(files)-1]
+		switch {
+		case strings.HasPrefix(line, "@@"):
+			inHunk = true
+		case !inHunk && strings.HasPrefix(line, "+++ ") && line != "+++ /dev/null":
+			file.Path = strings.TrimPrefix(strings.TrimPrefix(line, "+++ "), "b/")
+		case !inHunk && strings.HasPrefix(line, "Binary files "):
+			file.Binary = true
+		case inHunk && strings.HasPrefix(line, "+"):
+			file.Added++
+		case inHunk && strings.HasPrefix(line, "-"):
+			file.Removed++
+		}
+	}
+	flush()
+
+	return files
+}
+
+// headerPath returns the path of the file of a diff --git a/path b/path header, the one after it
+// was changed.
+func headerPath(header string) string {
+	paths := strings.TrimPrefix(header, "diff --git ")
+	if i := strings.LastIndex(paths, " b/"); i >= 0 {
+		return paths[i+len(" b/"):]
+	}
+
+	return paths
+}
+
+// FittedDiff is a diff cut to a token budget.
+type FittedDiff struct {
+	// Text is the diff of the files that fit, the ones that were cut end with a line that tells how
+	// many lines were left out, and the summary of the files that were left out follows.
+	Text      string
+	Truncated []string
+	Omitted   []FileDiff
+}
+
+// FitDiff cuts the diffs of the files to the budget of tokens, 0 being no budget. The budget is
+// shared out from the smallest file to the largest, so the small files are sent whole and the
+// large ones only get what is left: their diff is cut to their share, or left out when the share is
+// too small to tell anything, with the count of its lines in the summary. The files keep the order
+// of the diff.
+func FitDiff(files []FileDiff, budget int) FittedDiff {
+	texts := make([]string, len(files))
+	for i, file := range files {
+		texts[i] = file.Text
+	}
+
+	truncated, omitted := make([]bool, len(files)), make([]bool, len(files))
+	if budget > 0 {
+		tokens := make([]int, len(files))
+		order := make([]int, len(files))
+		for i, file := range files {
+			tokens[i], order[i] = utils.EstimateTokens(file.Text), i
+		}
+		slices.SortStableFunc(order, func(a, b int) int { return tokens[a] - tokens[b] })
+
+		remaining := budget
+		for k, i := range order {
+			share := remaining / (len(order) - k)
+			switch {
+			case tokens[i] <= share:
+				remaining -= tokens[i]
+			case share >= minFileTokens:
+				texts[i], truncated[i] = truncate(files[i], share), true
+				remaining -= utils.EstimateTokens(texts[i])
+			default:
+				texts[i], omitted[i] = "", true
+			}
+		}
+	}
+
+	var (
+		result FittedDiff
+		b      strings.Builder
+	)
+	for i, file := range files {
+		b.WriteString(texts[i])
+		if truncated[i] {
+			result.Truncated = append(result.Truncated, file.Path)
+		}
+		if omitted[i] {
+			result.Omitted = append(result.Omitted, file)
+		}
+	}
+
+	if len(result.Omitted) > 0 {
+		b.WriteString("\nThe diffs of these files were left out, they were too large:\n")
+		for _, file := range result.Omitted {
+			b.WriteString("- " + file.Summary() + "\n")
+		}
+	}
+
+	result.Text = b.String()
+	return result
+}
+
+// truncate returns the first lines of the diff of the file that take up to the tokens, with a line
+// that tells how many more lines the diff has.
+func truncate(file FileDiff, tokens int) string {
+	lines := strings.Split(strings.TrimRight(file.Text, "\n"), "\n")
+	marker := func(left int) string {
+		return fmt.Sprintf("[%d more line(s) of the diff of %s were left out]\n", left, file.Path)
+	}
+
+	used, kept := utils.EstimateTokens(marker(len(lines))), 0
+	for _, line := range lines {
+		if used += utils.EstimateTokens(line); used > tokens {
+			break
+		}
+		kept++
+	}
+
+	return strings.Join(lines[:kept], "\n") + "\n" + marker(len(lines)-kept)
+}
@@ -0,0 +1,79 @@
+package git
+
+import (
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/chunk"
+	"strings"
+)
+
+// ExplainSummaryTokens is how many tokens of the explanations of the parts before it a part of a
+// long diff is explained with.
+const ExplainSummaryTokens = 600
+
+// ExplainPrompt is the system prompt that has the model explain a diff, or a log with patches, to
+// its reviewer.
+const ExplainPrompt = "You explain the diffs and the git logs the user gives you to a reviewer who hasn't read them. " +
+	"Stick to what the diff shows, name the files and the functions it changes, and don't make up what it doesn't show."
+
+// explainFormat is the structure of the explanation of a diff, which the explanations of its parts
+// and their merge share.
+const explainFormat = "Answer in Markdown, with these sections:\n\n" +
+	"## Summary\nWhat the change does and why, in a few sentences.\n\n" +
+	"## Files\nA bullet per file, its path in backticks followed by what changed in it.\n\n" +
+	"## Risks\nWhat could break, such as a changed behavior, a missing test, a migration or a security concern, " +
+	"or None when nothing stands out.\n\n" +
+	"## Review focus\nWhere a reviewer should look the closest, and what to check there."
+
+// ExplainPart returns the query that explains a part of a diff, with what the parts before it
+// were explained as, so the explanations agree with each other. A diff of a single part is
+// explained as a whole.
+func ExplainPart(step chunk.Step) string {
+	if step.Parts == 1 {
+		return "Explain this diff.\n\n" + explainFormat + "\n\nThe diff:\n\n" + step.Chunk
+	}
+
+	var b strings.Builder
+	_, _ = fmt.Fprintf(&b, "This is part %d of %d of a diff that is too large to be explained at once. Explain this part only.\n\n", step.Part, step.Parts)
+	b.WriteString(explainFormat)
+	if step.Summary != "" {
+		b.WriteString("\n\nThe parts before this one were explained as follows, don't repeat it:\n\n" + step.Summary)
+	}
+	_, _ = fmt.Fprintf(&b, "\n\nPart %d of the diff:\n\n%s", step.Part, step.Chunk)
+
+	return b.String()
+}
+
+// ExplainMerge returns the query that merges the explanations of the parts of a diff into the
+// explanation of the whole diff.
+func ExplainMerge(answers []string) string {
+	var b strings.Builder
+	_, _ = fmt.Fprintf(&b, "These are the explanations of the %d parts of a diff, in their order. "+
+		"Merge them into the explanation of the whole diff: a single summary, every file once, and the risks and the "+
+		"review focus of the whole change, the most important first.\n\n%s", len(answers), explainFormat)
+
+	for i, answer := range answers {
+		_, _ = fmt.Fprintf(&b, "\n\n# Part %d\n\n%s", i+1, answer)
+	}
+
+	return b.String()
+}
+
+// Units returns the units a long diff is split into chunks of: the diff of each file, with what
+// comes before the first one, such as the message of the first commit of a log. A text without
+// diffs is a single unit.
+func Units(text string) []string {
+	files := SplitDiff(text)
+	if len(files) == 0 {
+		return []string{text}
+	}
+
+	var units []string
+	if i := strings.Index("\n"+text, "\ndiff --git "); i > 0 {
+		units = append(units, text[:i])
+	}
+	for _, file := range files {
+		units = append(units, file.Text)
+	}
+
+	return units
+}
@@ -0,0 +1,98 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// ErrNothingStaged is a repository whose index has no change to commit.
+var ErrNothingStaged = errors.New("nothing is staged, stage the changes to describe with git add first")
+
+// Git runs the git commands on the repository of Dir, the working directory when it is empty.
+type Git struct {
+	Dir    string
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// New returns the git of the repository of the directory, whose commits print on the terminal of
+// the CLI.
+func New(dir string) *Git {
+	return &Git{Dir: dir, Stdout: os.Stdout, Stderr: os.Stderr}
+}
+
+// StagedDiff returns the diff of what is staged, as git diff --cached prints it without colors or
+// an external diff. An index without changes is an ErrNothingStaged.
+func (g *Git) StagedDiff() (string, error) {
+	// outside of a repository, git diff compares files and only tells it doesn't know --cached
+	if _, err := g.output("rev-parse", "--git-dir"); err != nil {
+		return "", err
+	}
+
+	diff, err := g.output("diff", "--cached", "--no-color", "--no-ext-diff")
+	if err != nil {
+		return "", err
+	}
+
+	if strings.TrimSpace(diff) == "" {
+		return "", ErrNothingStaged
+	}
+
+	return diff, nil
+}
+
+// Commit commits what is staged with the message, as git commit -F - does, so the hooks of the
+// repository run as for any other commit.
+func (g *Git) Commit(message string) error {
+	cmd := exec.Command("git", "commit", "-F", "-")
+	cmd.Dir = g.Dir
+	cmd.Stdin = strings.NewReader(strings.TrimRight(message, "\n") + "\n")
+
+	cmd.Stdout, cmd.Stderr = g.Stdout, g.Stderr
+
+	if err := cmd.Run(); err != nil {
+		// what git said was printed already
+		return failed("git commit", err, "")
+	}
+
+	return nil
+}
+
+// output runs the git command and returns what it printed on stdout.
+func (g *Git) output(args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = g.Dir
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", failed("git "+strings.Join(args, " "), err, stderr.String())
+	}
+
+	return stdout.String(), nil
+}
+
+// failed returns the error of a git command, with the first line of what it printed on stderr,
+// such as the fatal error of a directory that isn't a repository.
+func failed(command string, err error, stderr string) error {
+	if errors.Is(err, exec.ErrNotFound) {
+		return errors.New("git isn't installed, or isn't in the PATH")
+	}
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return fmt.Errorf("failed to run %s: %w", command, err)
+	}
+
+	if line, _, _ := strings.Cut(strings.TrimSpace(stderr), "\n"); line != "" {
+		return fmt.Errorf("%s exited with status %d: %s", command, exitErr.ExitCode(), line)
+	}
+
+	return fmt.Errorf("%s exited with status %d", command, exitErr.ExitCode())
+}
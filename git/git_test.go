@@ -0,0 +1,218 @@
+package git_test
+
+import (
+	"bytes"
+	"github.com/kardolus/chatgpt-cli/chunk"
+	"github.com/kardolus/chatgpt-cli/git"
+	"github.com/kardolus/chatgpt-cli/utils"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitGit(t *testing.T) {
+	spec.Run(t, "Testing git", testGit, spec.Report(report.Terminal{}))
+}
+
+func testGit(t *testing.T, when spec.G, it spec.S) {
+	it.Before(func() {
+		RegisterTestingT(t)
+	})
+
+	fixture := func(name string) string {
+		data, err := utils.FileToBytes(filepath.Join("diffs", name))
+		Expect(err).NotTo(HaveOccurred())
+		return string(data)
+	}
+
+	when("SplitDiff()", func() {
+		it("splits the diff into the diffs of its files, with their path and their lines", func() {
+			files := git.SplitDiff(fixture("small.diff"))
+			Expect(files).To(HaveLen(4))
+
+			var summaries []string
+			for _, file := range files {
+				summaries = append(summaries, file.Summary())
+			}
+			Expect(summaries).To(Equal([]string{"NOTES.md (+0 -1)", "README.md (+3 -0)", "logo.png (binary)", "main.go (+3 -1)"}))
+
+			Expect(files[3].Text).To(HavePrefix("diff --git a/main.go b/main.go\n"))
+			Expect(files[3].Text).To(HaveSuffix(" }\n"))
+
+			var joined strings.Builder
+			for _, file := range files {
+				joined.WriteString(file.Text)
+			}
+			Expect(joined.String()).To(Equal(fixture("small.diff")))
+		})
+	})
+
+	when("FitDiff()", func() {
+		it("keeps the whole diff without a budget, or when it fits", func() {
+			files := git.SplitDiff(fixture("small.diff"))
+			for _, budget := range []int{0, 10000} {
+				fitted := git.FitDiff(files, budget)
+				Expect(fitted.Text).To(Equal(fixture("small.diff")))
+				Expect(fitted.Truncated).To(BeEmpty())
+				Expect(fitted.Omitted).To(BeEmpty())
+			}
+		})
+		it("cuts the large files to what the small ones left of the budget", func() {
+			fitted := git.FitDiff(git.SplitDiff(fixture("large.diff")), 1000)
+
+			Expect(fitted.Truncated).To(Equal([]string{"table.go"}))
+			Expect(fitted.Omitted).To(BeEmpty())
+			Expect(utils.EstimateTokens(fitted.Text)).To(BeNumerically("<=", 1000))
+			Expect(utils.EstimateTokens(fitted.Text)).To(BeNumerically(">", 900))
+
+			// the small file is whole, and keeps its place before the large one
+			Expect(fitted.Text).To(HavePrefix(git.SplitDiff(fixture("large.diff"))[0].Text + "diff --git a/table.go b/table.go\n"))
+			Expect(fitted.Text).To(MatchRegexp(`\n\[\d+ more line\(s\) of the diff of table.go were left out\]\n$`))
+		})
+		it("leaves out the files whose share is too small, and summarizes them", func() {
+			// the share of main.go is half of the budget, which is too small to tell anything
+			fitted := git.FitDiff(git.SplitDiff(fixture("large.diff")), 100)
+
+			Expect(fitted.Omitted).To(HaveLen(1))
+			Expect(fitted.Omitted[0].Path).To(Equal("main.go"))
+			Expect(fitted.Truncated).To(Equal([]string{"table.go"}))
+			Expect(fitted.Text).To(HavePrefix("diff --git a/table.go b/table.go\n"))
+			Expect(fitted.Text).To(HaveSuffix("\nThe diffs of these files were left out, they were too large:\n- main.go (+1 -1)\n"))
+		})
+	})
+
+	when("Units()", func() {
+		it("returns the diffs of the files, after what comes before the first one", func() {
+			log := fixture("log.diff")
+			units := git.Units(log)
+
+			Expect(units).To(HaveLen(4))
+			Expect(units[0]).To(HavePrefix("commit f7a431985af5813fe6fd03669c76c2e03d87e1d7\n"))
+			Expect(units[0]).To(HaveSuffix("    It does nothing yet.\n\n"))
+			Expect(units[1]).To(HavePrefix("diff --git a/README.md b/README.md\n"))
+			// the message of the next commit goes with the diff it follows
+			Expect(units[2]).To(ContainSubstring("\ncommit 546a128a4bd0d6831bccd70ca33dd36e2b7b515e\n"))
+			Expect(strings.Join(units, "")).To(Equal(log))
+		})
+		it("returns a text without diffs as a single unit", func() {
+			Expect(git.Units("just a log\n")).To(Equal([]string{"just a log\n"}))
+		})
+	})
+
+	when("ExplainPart() and ExplainMerge()", func() {
+		it("explains a diff of a single part as a whole", func() {
+			query := git.ExplainPart(chunk.Step{Part: 1, Parts: 1, Chunk: "diff --git a/x b/x\n"})
+			Expect(query).To(HavePrefix("Explain this diff.\n\n"))
+			Expect(query).To(ContainSubstring("## Risks\n"))
+			Expect(query).To(HaveSuffix("The diff:\n\ndiff --git a/x b/x\n"))
+			Expect(query).NotTo(ContainSubstring("part"))
+		})
+		it("explains a part with what the parts before it were explained as", func() {
+			query := git.ExplainPart(chunk.Step{Part: 2, Parts: 3, Summary: "## Summary\nAdds x.", Chunk: "diff --git a/y b/y\n"})
+			Expect(query).To(HavePrefix("This is part 2 of 3 of a diff"))
+			Expect(query).To(ContainSubstring("were explained as follows, don't repeat it:\n\n## Summary\nAdds x.\n\n"))
+			Expect(query).To(HaveSuffix("Part 2 of the diff:\n\ndiff --git a/y b/y\n"))
+
+			Expect(git.ExplainPart(chunk.Step{Part: 1, Parts: 3, Chunk: "x"})).NotTo(ContainSubstring("were explained as follows"))
+		})
+		it("merges the explanations of the parts in their order", func() {
+			query := git.ExplainMerge([]string{"first", "second"})
+			Expect(query).To(HavePrefix("These are the explanations of the 2 parts of a diff"))
+			Expect(query).To(HaveSuffix("\n\n# Part 1\n\nfirst\n\n# Part 2\n\nsecond"))
+		})
+	})
+
+	when("CommitQuery()", func() {
+		it("puts the notes of the user before the diff", func() {
+			Expect(git.CommitQuery("diff --git a/x b/x\n", "")).To(Equal("The staged diff:\n\ndiff --git a/x b/x\n"))
+			Expect(git.CommitQuery("diff --git a/x b/x\n", " closes #12\n")).To(Equal("Notes about the change: closes #12\n\nThe staged diff:\n\ndiff --git a/x b/x\n"))
+		})
+	})
+
+	when("CleanMessage()", func() {
+		it("removes the fences, the trailing spaces and the extra blank lines", func() {
+			answer := "```\nfeat(git): describe the staged diff  \n\n\n\nThe first paragraph.\n\n\nThe second one.\n```\n"
+			Expect(git.CleanMessage(answer)).To(Equal("feat(git): describe the staged diff\n\nThe first paragraph.\n\nThe second one."))
+		})
+	})
+
+	when("ValidateMessage()", func() {
+		it("accepts a conventional commit with paragraphs", func() {
+			Expect(git.ValidateMessage("fix(history)!: keep the order of the pinned messages\n\nThey were sorted by time.\n\nhttps://example.com/" + strings.Repeat("x", 120))).To(Succeed())
+			Expect(git.ValidateMessage("docs: explain the budget")).To(Succeed())
+		})
+		it("refuses a subject that isn't a conventional commit", func() {
+			Expect(git.ValidateMessage("Add the budget")).To(MatchError(ContainSubstring(`the subject "Add the budget" isn't a conventional commit`)))
+			Expect(git.ValidateMessage("feature: add the budget")).To(MatchError(ContainSubstring("isn't a conventional commit")))
+			Expect(git.ValidateMessage("")).To(MatchError("the commit message has no subject"))
+		})
+		it("refuses a subject that is too long", func() {
+			Expect(git.ValidateMessage("feat: " + strings.Repeat("a", 67))).To(MatchError("the subject has 73 characters, more than the 72 of a subject"))
+		})
+		it("refuses a body that isn't separated from the subject, or whose lines are too long", func() {
+			Expect(git.ValidateMessage("feat: add it\nright below")).To(MatchError("the subject isn't followed by a blank line before the body"))
+			Expect(git.ValidateMessage("feat: add it\n\nshort\n\n" + strings.Repeat("word ", 21))).To(MatchError("line 5 of the commit message has 105 characters, more than the 100 of a line of the body"))
+		})
+	})
+
+	when("a repository has staged changes", func() {
+		var (
+			dir     string
+			stdout  bytes.Buffer
+			subject *git.Git
+		)
+
+		run := func(args ...string) string {
+			cmd := exec.Command("git", args...)
+			cmd.Dir = dir
+			output, err := cmd.CombinedOutput()
+			Expect(err).NotTo(HaveOccurred(), string(output))
+			return string(output)
+		}
+
+		it.Before(func() {
+			if _, err := exec.LookPath("git"); err != nil {
+				t.Skip("git isn't installed")
+			}
+
+			dir = t.TempDir()
+			run("init", "-q")
+			run("config", "user.email", "dev@example.com")
+			run("config", "user.name", "Dev")
+			run("config", "commit.gpgsign", "false")
+
+			stdout.Reset()
+			subject = &git.Git{Dir: dir, Stdout: &stdout, Stderr: &stdout}
+		})
+
+		it("refuses an index without changes", func() {
+			_, err := subject.StagedDiff()
+			Expect(err).To(MatchError(git.ErrNothingStaged))
+		})
+		it("returns the staged diff, and commits it with the message", func() {
+			Expect(os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, "unstaged.go"), []byte("package main\n"), 0644)).To(Succeed())
+			run("add", "main.go")
+
+			diff, err := subject.StagedDiff()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(diff).To(ContainSubstring("+++ b/main.go\n"))
+			Expect(diff).NotTo(ContainSubstring("unstaged.go"))
+
+			Expect(subject.Commit("feat: add the main package\n\nIt is empty.")).To(Succeed())
+			Expect(run("log", "-1", "--format=%B")).To(Equal("feat: add the main package\n\nIt is empty.\n\n"))
+		})
+		it("tells what git said when it fails", func() {
+			subject.Dir = t.TempDir()
+
+			_, err := subject.StagedDiff()
+			Expect(err).To(MatchError(MatchRegexp(`git rev-parse --git-dir exited with status \d+: .*not a git repository`)))
+		})
+	})
+}
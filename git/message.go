@@ -0,0 +1,93 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// MaxSubjectLength is how many characters the subject line of a commit message has at most.
+	MaxSubjectLength = 72
+	// MaxBodyLineLength is how many characters a line of the body of a commit message has at most,
+	// a single word, such as a URL, aside.
+	MaxBodyLineLength = 100
+)
+
+// CommitTypes are the types of the conventional commits.
+var CommitTypes = []string{"feat", "fix", "docs", "style", "refactor", "perf", "test", "build", "ci", "chore", "revert"}
+
+// subjectPattern matches the subject of a conventional commit, such as feat(history): add tags.
+var subjectPattern = regexp.MustCompile(`^(` + strings.Join(CommitTypes, "|") + `)(\([^()\s]+\))?!?: \S`)
+
+// CommitPrompt is the system prompt that has the model write the commit message of a staged diff.
+var CommitPrompt = fmt.Sprintf("You write the commit message of the staged diff the user gives you, "+
+	"in the format of the conventional commits. The first line is the subject: a type, one of %s, "+
+	"an optional scope in parentheses, a colon and a space, then what the commit does in the imperative mood, "+
+	"such as fix(history): keep the order of the pinned messages, in at most %d characters and without a period. "+
+	"When the change needs more explanation, a blank line and a body follow, in paragraphs separated by blank lines, "+
+	"whose lines are at most %d characters, telling what changed and why rather than how. "+
+	"Answer with the commit message only, without Markdown fences and without any other text.",
+	strings.Join(CommitTypes, ", "), MaxSubjectLength, MaxBodyLineLength)
+
+// CommitQuery returns the query of the commit message of the diff, after the notes of the user
+// about the change, if any.
+func CommitQuery(diff, notes string) string {
+	query := "The staged diff:\n\n" + diff
+	if notes = strings.TrimSpace(notes); notes != "" {
+		query = "Notes about the change: " + notes + "\n\n" + query
+	}
+
+	return query
+}
+
+// CleanMessage returns the answer to the CommitPrompt as a commit message: without the fences the
+// model may have put around it, the spaces the lines end with, and with single blank lines between
+// the paragraphs.
+func CleanMessage(answer string) string {
+	lines := strings.Split(strings.TrimSpace(strings.ReplaceAll(answer, "\r\n", "\n")), "\n")
+	if len(lines) > 1 && strings.HasPrefix(lines[0], "```") && strings.TrimSpace(lines[len(lines)-1]) == "```" {
+		lines = lines[1 : len(lines)-1]
+	}
+
+	var cleaned []string
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t")
+		if line == "" && (len(cleaned) == 0 || cleaned[len(cleaned)-1] == "") {
+			continue
+		}
+		cleaned = append(cleaned, line)
+	}
+
+	return strings.TrimSpace(strings.Join(cleaned, "\n"))
+}
+
+// ValidateMessage checks that the message is a conventional commit whose subject has at most
+// MaxSubjectLength characters, followed by a blank line when a body follows, whose lines have at
+// most MaxBodyLineLength characters, in every paragraph.
+func ValidateMessage(message string) error {
+	lines := strings.Split(message, "\n")
+	subject := lines[0]
+
+	switch {
+	case strings.TrimSpace(subject) == "":
+		return errors.New("the commit message has no subject")
+	case !subjectPattern.MatchString(subject):
+		return fmt.Errorf("the subject %q isn't a conventional commit, such as fix(scope): what the commit does", subject)
+	case utf8.RuneCountInString(subject) > MaxSubjectLength:
+		return fmt.Errorf("the subject has %d characters, more than the %d of a subject", utf8.RuneCountInString(subject), MaxSubjectLength)
+	case len(lines) > 1 && strings.TrimSpace(lines[1]) != "":
+		return errors.New("the subject isn't followed by a blank line before the body")
+	}
+
+	for i := 2; i < len(lines); i++ {
+		line := lines[i]
+		if length := utf8.RuneCountInString(line); length > MaxBodyLineLength && strings.Contains(strings.TrimSpace(line), " ") {
+			return fmt.Errorf("line %d of the commit message has %d characters, more than the %d of a line of the body", i+1, length, MaxBodyLineLength)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package history
+
+import (
+	"errors"
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/types"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// LiteralValue is the type of the values the user asked to anonymize.
+const LiteralValue = "literal"
+
+// identifyingPatterns are the shapes of what identifies people and machines, in the order they are
+// anonymized: an email is anonymized before the host it names, and a URL keeps its path. Paths
+// don't end with the punctuation of the sentence they are in.
+var identifyingPatterns = []secretPattern{
+	{"email", regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`)},
+	{"host", regexp.MustCompile(`(?i)\b[a-z][a-z0-9+.-]*://(?:[^/\s@]+@)?([^/\s:?#@\[\]]+)`)},
+	{"host", regexp.MustCompile(`(?i)\b(?:[a-z0-9](?:[a-z0-9-]*[a-z0-9])?\.){2,}(?:com|net|org|io|dev|app|cloud|co|internal|local|lan|corp|intra)\b`)},
+	{"ip", regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4]\d|1?\d?\d)\.){3}(?:25[0-5]|2[0-4]\d|1?\d?\d)\b`)},
+	{"ip", regexp.MustCompile(`(?i)(?:[0-9a-f]{0,4}:){2,7}[0-9a-f]{0,4}`)},
+	{"path", regexp.MustCompile("(?m)(?:^|[\\s\"'(=`])(~?/(?:[\\w.@+-]*[\\w@+-]/?)+)")},
+	{"path", regexp.MustCompile(`\b([A-Za-z]:\\[^\s"'<>|]*[^\s"'<>|.,;:!?)])`)},
+}
+
+// AnonymizedValue is a value that was replaced with a placeholder.
+type AnonymizedValue struct {
+	Placeholder string `json:"placeholder"`
+	Value       string `json:"value"`
+}
+
+// Anonymizer replaces what identifies people and machines, emails, hosts, IP addresses, absolute
+// paths and the values given to it, as well as the secrets its redactor finds, with numbered
+// placeholders such as <email-1>. The same value always gets the same placeholder, so a
+// conversation stays readable.
+type Anonymizer struct {
+	patterns []secretPattern
+	values   map[string]string
+	counts   map[string]int
+	mapping  []AnonymizedValue
+}
+
+// NewAnonymizer returns an anonymizer for the secrets of the redactor, the identifying shapes and
+// the literal values, which are matched case-insensitively.
+func NewAnonymizer(redactor *Redactor, literals []string) (*Anonymizer, error) {
+	var patterns []secretPattern
+
+	for _, literal := range literals {
+		if strings.TrimSpace(literal) == "" {
+			return nil, errors.New("invalid anonymized value, the value can't be empty")
+		}
+		patterns = append(patterns, secretPattern{kind: LiteralValue, regexp: regexp.MustCompile("(?i)" + regexp.QuoteMeta(literal))})
+	}
+
+	if redactor != nil {
+		patterns = append(patterns, redactor.patterns...)
+	}
+
+	return &Anonymizer{
+		patterns: append(patterns, identifyingPatterns...),
+		values:   map[string]string{},
+		counts:   map[string]int{},
+	}, nil
+}
+
+// Anonymize returns the text with the values replaced by their placeholders.
+func (a *Anonymizer) Anonymize(text string) string {
+	for _, pattern := range a.patterns {
+		text = pattern.replace(text, func(value string) (string, bool) {
+			if pattern.kind == "ip" && !isIP(value) {
+				return "", false
+			}
+
+			return a.placeholder(pattern.kind, value), true
+		})
+	}
+
+	return text
+}
+
+// Messages returns copies of the messages with their content and alternatives anonymized.
+func (a *Anonymizer) Messages(messages []types.Message) []types.Message {
+	result := make([]types.Message, len(messages))
+	for i, message := range messages {
+		message.Content = a.Anonymize(message.Content)
+
+		if message.Alternatives != nil {
+			alternatives := make([]string, len(message.Alternatives))
+			for j, alternative := range message.Alternatives {
+				alternatives[j] = a.Anonymize(alternative)
+			}
+			message.Alternatives = alternatives
+		}
+
+		// the token count of the original content no longer applies
+		message.Tokens, message.TokenEncoding = 0, ""
+		result[i] = message
+	}
+
+	return result
+}
+
+// Mapping returns the values that were replaced, in the order they were first found.
+func (a *Anonymizer) Mapping() []AnonymizedValue {
+	return append([]AnonymizedValue(nil), a.mapping...)
+}
+
+func (a *Anonymizer) placeholder(kind, value string) string {
+	key := kind + "\x00" + value
+	if placeholder, ok := a.values[key]; ok {
+		return placeholder
+	}
+
+	a.counts[kind]++
+	placeholder := fmt.Sprintf("<%s-%d>", kind, a.counts[kind])
+
+	a.values[key] = placeholder
+	a.mapping = append(a.mapping, AnonymizedValue{Placeholder: placeholder, Value: value})
+
+	return placeholder
+}
+
+// isIP reports whether the value is an IP address, leaving out what only looks like an IPv6
+// address, such as the times 12:30:00 or a scope like std::.
+func isIP(value string) bool {
+	if net.ParseIP(value) == nil {
+		return false
+	}
+
+	return strings.Contains(value, ".") || strings.Count(value, ":") == 7 || strings.Contains(value, "::") && strings.ContainsAny(value, "0123456789")
+}
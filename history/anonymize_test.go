@@ -0,0 +1,88 @@
+package history_test
+
+import (
+	"github.com/kardolus/chatgpt-cli/history"
+	"github.com/kardolus/chatgpt-cli/types"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitAnonymize(t *testing.T) {
+	spec.Run(t, "Testing the anonymization of exports", testAnonymize, spec.Report(report.Terminal{}))
+}
+
+func testAnonymize(t *testing.T, when spec.G, it spec.S) {
+	var anonymizer *history.Anonymizer
+
+	it.Before(func() {
+		RegisterTestingT(t)
+
+		redactor, err := history.NewRedactor(nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		anonymizer, err = history.NewAnonymizer(redactor, []string{"Acme Corp"})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	it("replaces the identifying values with numbered placeholders", func() {
+		// the placeholders are numbered in the order the values are found
+		for _, example := range []struct{ text, expected string }{
+			{"mail bob@example.com or alice@example.org", "mail <email-1> or <email-2>"},
+			{"ssh into db01.prod.example.com at 10.0.12.7", "ssh into <host-1> at <ip-1>"},
+			{"curl https://api.internal:8443/v1/users?id=3", "curl https://<host-2>:8443/v1/users?id=3"},
+			{"the ipv6 address fe80::1ff:fe23:4567:890a is local", "the ipv6 address <ip-2> is local"},
+			{"it fails in /home/bob/src/app/main.go:12.", "it fails in <path-1>:12."},
+			{`copy C:\Users\bob\report.docx to "~/reports/"`, `copy <path-3> to "<path-2>"`},
+			{"ACME CORP uses password: hunter2", "<literal-1> uses password: <password-1>"},
+		} {
+			Expect(anonymizer.Anonymize(example.text)).To(Equal(example.expected), example.text)
+		}
+	})
+
+	it("gives the same value the same placeholder every time", func() {
+		first := anonymizer.Anonymize("bob@example.com wrote to alice@example.com")
+		second := anonymizer.Anonymize("alice@example.com answered bob@example.com")
+
+		Expect(first).To(Equal("<email-1> wrote to <email-2>"))
+		Expect(second).To(Equal("<email-2> answered <email-1>"))
+		Expect(anonymizer.Mapping()).To(Equal([]history.AnonymizedValue{
+			{Placeholder: "<email-1>", Value: "bob@example.com"},
+			{Placeholder: "<email-2>", Value: "alice@example.com"},
+		}))
+	})
+
+	it("leaves alone what only looks like an identifying value", func() {
+		for _, text := range []string{
+			"use std::string in C++",
+			"the build ran from 12:30:00 to 12:45:10",
+			"unpack archive.tar.gz and use client.go",
+			"km/h and either/or",
+		} {
+			Expect(anonymizer.Anonymize(text)).To(Equal(text))
+		}
+		Expect(anonymizer.Mapping()).To(BeEmpty())
+	})
+
+	it("anonymizes copies of the messages", func() {
+		messages := []types.Message{{
+			Role:         "assistant",
+			Content:      "ask bob@example.com",
+			Alternatives: []string{"mail bob@example.com"},
+			Tokens:       4,
+		}}
+
+		anonymized := anonymizer.Messages(messages)
+		Expect(anonymized[0].Content).To(Equal("ask <email-1>"))
+		Expect(anonymized[0].Alternatives).To(Equal([]string{"mail <email-1>"}))
+		Expect(anonymized[0].Tokens).To(BeZero())
+		Expect(messages[0].Content).To(Equal("ask bob@example.com"))
+	})
+
+	it("refuses empty values", func() {
+		_, err := history.NewAnonymizer(nil, []string{" "})
+		Expect(err).To(MatchError("invalid anonymized value, the value can't be empty"))
+	})
+}
@@ -0,0 +1,425 @@
+package history
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/utils"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// BackupVersion is the version of the backup format, Restore refuses backups of newer versions.
+	BackupVersion = 1
+
+	backupManifest = "manifest.json"
+	backupIndex    = "index.json"
+	backupThreads  = "threads/"
+	backupArchives = "archives/"
+)
+
+var ErrCorruptBackup = errors.New("the backup is corrupt")
+
+// backupManifestData describes a backup, it is the first entry of the archive.
+type backupManifestData struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	Threads []string  `json:"threads"`
+}
+
+// RestoreOptions tunes how a backup is restored.
+type RestoreOptions struct {
+	// Thread restores a single thread out of the backup, all threads are restored when it is empty.
+	Thread string
+	// Force replaces the threads that exist already.
+	Force bool
+}
+
+// BackupName returns the name of a backup made at the given time.
+func BackupName(now time.Time) string {
+	return "chatgpt-cli-history-" + now.Format("20060102-150405") + ".tar.gz"
+}
+
+// Backup writes every thread, with its archives and its metadata, to w as a gzipped tar archive.
+// The files are copied as they are, so encrypted threads stay encrypted. It returns the threads in
+// the backup.
+func (f *FileIO) Backup(w io.Writer) ([]string, error) {
+	threads, err := f.ListThreads()
+	if err != nil {
+		return nil, err
+	}
+
+	gz := gzip.NewWriter(w)
+	archive := tar.NewWriter(gz)
+
+	manifest := backupManifestData{Version: BackupVersion, Created: time.Now()}
+
+	err = f.withLock(false, func() error {
+		var (
+			entries = make(map[string][]byte)
+			times   = make(map[string]time.Time)
+			names   []string
+		)
+
+		add := func(name, fileName string) error {
+			data, err := os.ReadFile(fileName)
+			if err != nil {
+				return err
+			}
+
+			entries[name], times[name] = data, modTime(fileName)
+			names = append(names, name)
+			return nil
+		}
+
+		index, _ := f.readIndex()
+		backedUp := make(map[string]indexEntry)
+
+		for _, thread := range threads {
+			// threads that are removed while the backup is made are left out
+			err := add(backupThreads+thread.Name+jsonExtension, filepath.Join(f.historyDir, thread.Name+jsonExtension))
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			archives, err := f.listArchives(thread.Name)
+			if err != nil {
+				return err
+			}
+			for _, number := range archives {
+				if err := add(backupArchives+filepath.Base(f.archivePath(thread.Name, number)), f.archivePath(thread.Name, number)); err != nil {
+					return err
+				}
+			}
+
+			if entry, ok := index[thread.Name]; ok {
+				backedUp[thread.Name] = entry
+			}
+			manifest.Threads = append(manifest.Threads, thread.Name)
+		}
+
+		if err := writeJSONEntry(archive, backupManifest, manifest); err != nil {
+			return err
+		}
+		// the index is encrypted like the threads, as it holds their titles and summaries
+		indexData, err := f.encodeIndex(backedUp)
+		if err != nil {
+			return err
+		}
+		if err := writeEntry(archive, backupIndex, indexData, time.Now()); err != nil {
+			return err
+		}
+
+		for _, name := range names {
+			if err := writeEntry(archive, name, entries[name], times[name]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+
+	return manifest.Threads, gz.Close()
+}
+
+// Restore restores the threads of a backup made with Backup, with their archives and metadata. The
+// whole backup is validated before anything is written, and threads that exist already are only
+// replaced with Force. It returns the threads that were restored.
+func (f *FileIO) Restore(r io.Reader, opts RestoreOptions) ([]string, error) {
+	backup, err := readBackup(r)
+	if err != nil {
+		return nil, err
+	}
+
+	backedUp, err := f.decodeIndex(backup.index)
+	if errors.Is(err, ErrEncrypted) || errors.Is(err, ErrWrongKey) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptBackup, err)
+	}
+
+	threads := backup.manifest.Threads
+	if opts.Thread != "" {
+		if _, ok := backup.threads[opts.Thread]; !ok {
+			return nil, fmt.Errorf("thread %s is not in the backup", opts.Thread)
+		}
+		threads = []string{opts.Thread}
+	}
+
+	for _, thread := range threads {
+		if err := f.validateBackup(thread, backup); err != nil {
+			return nil, err
+		}
+	}
+
+	err = f.withLock(true, func() error {
+		if !opts.Force {
+			var existing []string
+			for _, thread := range threads {
+				if _, err := os.Stat(filepath.Join(f.historyDir, thread+jsonExtension)); err == nil {
+					existing = append(existing, thread)
+				}
+			}
+
+			if len(existing) > 0 {
+				return fmt.Errorf("thread %s already exists, add --force to replace it", strings.Join(existing, ", "))
+			}
+		}
+
+		if err := os.MkdirAll(f.historyDir, dirPerm); err != nil {
+			return err
+		}
+
+		for _, thread := range threads {
+			if err := f.restoreThread(thread, backup); err != nil {
+				return fmt.Errorf("failed to restore thread %s: %w", thread, err)
+			}
+		}
+
+		// the index only speeds up listing, threads without an entry are counted from their file
+		return f.updateIndex(func(index map[string]indexEntry) {
+			for _, thread := range threads {
+				if entry, ok := backedUp[thread]; ok {
+					index[thread] = entry
+				} else {
+					delete(index, thread)
+				}
+			}
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return threads, nil
+}
+
+func (f *FileIO) restoreThread(thread string, backup *backupContent) error {
+	if err := f.removeArchives(thread); err != nil {
+		return err
+	}
+
+	if archives := backup.archives[thread]; len(archives) > 0 {
+		if err := os.MkdirAll(filepath.Join(f.historyDir, archiveDir), dirPerm); err != nil {
+			return err
+		}
+
+		for number, file := range archives {
+			if err := restoreFile(f.archiveName(thread, number, file.compressed), file); err != nil {
+				return err
+			}
+		}
+	}
+
+	fileName := filepath.Join(f.historyDir, thread+jsonExtension)
+
+	// the backup of the replaced thread must not be what a damaged thread falls back to
+	if err := restoreFile(fileName+backupExtension, backup.threads[thread]); err != nil {
+		return err
+	}
+
+	return restoreFile(fileName, backup.threads[thread])
+}
+
+// validateBackup checks that the thread and its archives can be read. Encrypted files are only
+// checked when the store has a passphrase.
+func (f *FileIO) validateBackup(thread string, backup *backupContent) error {
+	files := []backupFile{backup.threads[thread]}
+	for _, archive := range backup.archives[thread] {
+		files = append(files, archive)
+	}
+
+	for _, file := range files {
+		data := file.data
+		if isEncrypted(data) {
+			if f.encryption == nil {
+				continue
+			}
+
+			var err error
+			if data, err = f.decrypt(data); err != nil {
+				return fmt.Errorf("thread %s can't be restored: %w", thread, err)
+			}
+		}
+
+		data, err := Decompress(file.name, data)
+		if err != nil {
+			return fmt.Errorf("%w: thread %s can't be read: %v", ErrCorruptBackup, thread, err)
+		}
+
+		if _, _, err := decodeMessages(data); err != nil {
+			return fmt.Errorf("%w: thread %s can't be read: %v", ErrCorruptBackup, thread, err)
+		}
+	}
+
+	return nil
+}
+
+type backupFile struct {
+	name       string
+	data       []byte
+	modified   time.Time
+	compressed bool
+}
+
+type backupContent struct {
+	manifest backupManifestData
+	// index is the index of the threads as it was written, encrypted when the history was
+	index    []byte
+	threads  map[string]backupFile
+	archives map[string]map[int]backupFile
+}
+
+// readBackup reads the whole backup and refuses anything a backup doesn't contain, such as paths
+// outside the history.
+func readBackup(r io.Reader) (*backupContent, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptBackup, err)
+	}
+	defer gz.Close()
+
+	result := &backupContent{
+		threads:  make(map[string]backupFile),
+		archives: make(map[string]map[int]backupFile),
+	}
+
+	var hasManifest bool
+
+	archive := tar.NewReader(gz)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorruptBackup, err)
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			return nil, fmt.Errorf("%w: unexpected entry %s", ErrCorruptBackup, header.Name)
+		}
+
+		data, err := io.ReadAll(archive)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorruptBackup, err)
+		}
+		file := backupFile{name: header.Name, data: data, modified: header.ModTime}
+
+		switch name := header.Name; {
+		case name == backupManifest:
+			if err := json.Unmarshal(data, &result.manifest); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrCorruptBackup, err)
+			}
+			hasManifest = true
+		case name == backupIndex:
+			result.index = data
+		case strings.HasPrefix(name, backupThreads):
+			thread := strings.TrimSuffix(strings.TrimPrefix(name, backupThreads), jsonExtension)
+			if ValidateThread(thread) != nil || !strings.HasSuffix(name, jsonExtension) {
+				return nil, fmt.Errorf("%w: unexpected entry %s", ErrCorruptBackup, name)
+			}
+			result.threads[thread] = file
+		case strings.HasPrefix(name, backupArchives):
+			base := strings.TrimPrefix(name, backupArchives)
+			file.compressed = isCompressedName(base)
+
+			thread, number, ok := parseArchiveName(strings.TrimSuffix(base, compressedExtension))
+			if !ok {
+				return nil, fmt.Errorf("%w: unexpected entry %s", ErrCorruptBackup, name)
+			}
+			if result.archives[thread] == nil {
+				result.archives[thread] = make(map[int]backupFile)
+			}
+			result.archives[thread][number] = file
+		default:
+			return nil, fmt.Errorf("%w: unexpected entry %s", ErrCorruptBackup, name)
+		}
+	}
+
+	if !hasManifest {
+		return nil, fmt.Errorf("%w: it has no manifest", ErrCorruptBackup)
+	}
+
+	if result.manifest.Version > BackupVersion {
+		return nil, fmt.Errorf("the backup has version %d and this version only restores up to version %d, upgrade chatgpt-cli to restore it", result.manifest.Version, BackupVersion)
+	}
+
+	for _, thread := range result.manifest.Threads {
+		if _, ok := result.threads[thread]; !ok {
+			return nil, fmt.Errorf("%w: thread %s is missing", ErrCorruptBackup, thread)
+		}
+	}
+
+	sort.Strings(result.manifest.Threads)
+
+	return result, nil
+}
+
+// parseArchiveName splits the name of an archive file into the thread and the archive number.
+func parseArchiveName(name string) (string, int, bool) {
+	base := strings.TrimSuffix(name, jsonExtension)
+	dot := strings.LastIndex(base, ".")
+	if dot <= 0 || !strings.HasSuffix(name, jsonExtension) {
+		return "", 0, false
+	}
+
+	number, err := strconv.Atoi(base[dot+1:])
+	if err != nil || number <= 0 || ValidateThread(base[:dot]) != nil {
+		return "", 0, false
+	}
+
+	return base[:dot], number, true
+}
+
+func restoreFile(fileName string, file backupFile) error {
+	if err := utils.WriteFileAtomic(fileName, file.data, filePerm); err != nil {
+		return err
+	}
+
+	// the update time of a thread is the time of its file
+	return os.Chtimes(fileName, file.modified, file.modified)
+}
+
+func writeJSONEntry(archive *tar.Writer, name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return writeEntry(archive, name, data, time.Now())
+}
+
+func writeEntry(archive *tar.Writer, name string, data []byte, modified time.Time) error {
+	if err := archive.WriteHeader(&tar.Header{
+		Name:     name,
+		Mode:     filePerm,
+		Size:     int64(len(data)),
+		ModTime:  modified,
+		Typeflag: tar.TypeReg,
+	}); err != nil {
+		return err
+	}
+
+	_, err := archive.Write(data)
+	return err
+}
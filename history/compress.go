@@ -0,0 +1,139 @@
+package history
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/utils"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// compressedExtension is added to the name of the archives that are compressed with gzip. Threads
+// are rewritten on every exchange, so only the archives are ever compressed.
+const compressedExtension = ".gz"
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// CompressArchives compresses the archives that were written before the archives were compressed,
+// in place, and returns how many it compressed. Every archive is replaced in a single step, and
+// keeps its modification time, since pruning falls back to it for the messages without a
+// timestamp. The threads themselves are never compressed.
+func (f *FileIO) CompressArchives() (int, error) {
+	var compressed int
+
+	err := f.withLock(true, func() error {
+		dir := filepath.Join(f.historyDir, archiveDir)
+
+		entries, err := os.ReadDir(dir)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			if _, _, ok := parseArchiveName(entry.Name()); entry.IsDir() || !ok {
+				continue
+			}
+
+			path := filepath.Join(dir, entry.Name())
+			if err := f.compressArchive(path); err != nil {
+				return fmt.Errorf("failed to compress %s: %w", path, err)
+			}
+			compressed++
+		}
+
+		return nil
+	})
+
+	return compressed, err
+}
+
+// compressArchive replaces the plain archive with its compressed version. An archive that was
+// compressed already, when a previous run stopped before the plain one was removed, is kept.
+func (f *FileIO) compressArchive(path string) error {
+	target := path + compressedExtension
+
+	if _, err := os.Stat(target); os.IsNotExist(err) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		encrypted := isEncrypted(data)
+		if data, err = f.decrypt(data); err != nil {
+			return err
+		}
+
+		if data, err = compress(data); err != nil {
+			return err
+		}
+
+		// an encrypted archive stays encrypted, the data can only be compressed before that
+		if encrypted {
+			if data, err = f.encryption.seal(data); err != nil {
+				return err
+			}
+		}
+
+		if err := utils.WriteFileAtomic(target, data, filePerm); err != nil {
+			return err
+		}
+
+		modified := modTime(path)
+		if err := os.Chtimes(target, modified, modified); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// Decompress returns the data of the file with the given name, decompressed when it was compressed
+// with gzip. Anything else is returned as it is.
+func Decompress(name string, data []byte) ([]byte, error) {
+	if !isCompressed(data) {
+		return data, nil
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress %s: %w", name, err)
+	}
+	defer reader.Close()
+
+	result, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress %s: %w", name, err)
+	}
+
+	return result, nil
+}
+
+func compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func isCompressed(data []byte) bool {
+	return bytes.HasPrefix(data, gzipMagic)
+}
+
+func isCompressedName(name string) bool {
+	return strings.HasSuffix(name, jsonExtension+compressedExtension)
+}
@@ -0,0 +1,138 @@
+package history
+
+import (
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/types"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dateFormats are the absolute forms a date range accepts, besides RFC 3339. Times without a zone
+// are local.
+var dateFormats = []string{"2006-01-02", "2006-01-02T15:04", "2006-01-02 15:04", "2006-01-02T15:04:05", "2006-01-02 15:04:05"}
+
+// DateRange selects the messages by the time they were added. Since is inclusive and Until is
+// exclusive, a zero bound leaves that side of the range open. Messages from older histories have no
+// time of their own and only match a range when Undated is set.
+type DateRange struct {
+	Since   time.Time
+	Until   time.Time
+	Undated bool
+}
+
+// ParseDateRange parses the bounds of a range, either of which can be empty. A bound is a date such
+// as 2024-06-01, a time such as 2024-06-01T15:04 or 2024-06-01T15:04:05Z, or how long ago relative to
+// now, in hours, days or weeks such as 12h, 7d or 2w. A date given as the end of the range includes
+// that whole day.
+func ParseDateRange(since, until string, now time.Time) (DateRange, error) {
+	var (
+		result DateRange
+		err    error
+	)
+
+	if since != "" {
+		if result.Since, err = parseBound(since, now, false); err != nil {
+			return DateRange{}, err
+		}
+	}
+
+	if until != "" {
+		if result.Until, err = parseBound(until, now, true); err != nil {
+			return DateRange{}, err
+		}
+	}
+
+	if !result.Since.IsZero() && !result.Until.IsZero() && !result.Since.Before(result.Until) {
+		return DateRange{}, fmt.Errorf("the date range is empty, %s is not before %s", since, until)
+	}
+
+	return result, nil
+}
+
+// IsZero reports whether the range is open on both sides, so it matches every message.
+func (r DateRange) IsZero() bool {
+	return r.Since.IsZero() && r.Until.IsZero()
+}
+
+// Contains reports whether a message added at the time is in the range.
+func (r DateRange) Contains(timestamp time.Time) bool {
+	if r.IsZero() {
+		return true
+	}
+
+	if timestamp.IsZero() {
+		return r.Undated
+	}
+
+	return (r.Since.IsZero() || !timestamp.Before(r.Since)) && (r.Until.IsZero() || timestamp.Before(r.Until))
+}
+
+// Filter returns the messages in the range. The system prompt the messages start with is kept, since
+// the messages that follow it don't make sense without it.
+func (r DateRange) Filter(messages []types.Message) []types.Message {
+	if r.IsZero() {
+		return messages
+	}
+
+	var result []types.Message
+	for i, message := range messages {
+		if i == 0 && message.Role == systemRole || r.Contains(message.Timestamp) {
+			result = append(result, message)
+		}
+	}
+
+	return result
+}
+
+func parseBound(value string, now time.Time, end bool) (time.Time, error) {
+	value = strings.TrimSpace(value)
+
+	if ago, ok := parseAgo(value); ok {
+		return now.Add(-ago), nil
+	}
+
+	if result, err := time.Parse(time.RFC3339, value); err == nil {
+		return result, nil
+	}
+
+	for _, format := range dateFormats {
+		result, err := time.ParseInLocation(format, value, time.Local)
+		if err != nil {
+			continue
+		}
+
+		if end && format == dateFormats[0] {
+			return result.AddDate(0, 0, 1), nil
+		}
+		return result, nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid date %q, use a date such as 2024-06-01, a time such as 2024-06-01T15:04 or 2024-06-01T15:04:05Z, or how long ago such as 12h, 7d or 2w", value)
+}
+
+// parseAgo parses a number of hours, days or weeks.
+func parseAgo(value string) (time.Duration, bool) {
+	if len(value) < 2 {
+		return 0, false
+	}
+
+	var unit time.Duration
+	switch value[len(value)-1] {
+	case 'h':
+		unit = time.Hour
+	case 'd':
+		unit = 24 * time.Hour
+	case 'w':
+		unit = 7 * 24 * time.Hour
+	default:
+		return 0, false
+	}
+
+	count, err := strconv.Atoi(value[:len(value)-1])
+	if err != nil || count < 0 {
+		return 0, false
+	}
+
+	return time.Duration(count) * unit, true
+}
@@ -0,0 +1,96 @@
+package history_test
+
+import (
+	"github.com/kardolus/chatgpt-cli/history"
+	"github.com/kardolus/chatgpt-cli/types"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitDateRange(t *testing.T) {
+	spec.Run(t, "Testing the date ranges", testDateRange, spec.Report(report.Terminal{}))
+}
+
+func testDateRange(t *testing.T, when spec.G, it spec.S) {
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	it.Before(func() {
+		RegisterTestingT(t)
+	})
+
+	when("ParseDateRange()", func() {
+		it("parses how long ago relative to now", func() {
+			dates, err := history.ParseDateRange("2w", "12h", now)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dates.Since).To(Equal(now.AddDate(0, 0, -14)))
+			Expect(dates.Until).To(Equal(now.Add(-12 * time.Hour)))
+
+			dates, err = history.ParseDateRange("7d", "", now)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dates.Since).To(Equal(now.AddDate(0, 0, -7)))
+			Expect(dates.Until).To(BeZero())
+		})
+		it("parses dates and times and includes the whole day the range ends with", func() {
+			dates, err := history.ParseDateRange("2024-06-01T09:30", "2024-06-10", now)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dates.Since).To(Equal(time.Date(2024, 6, 1, 9, 30, 0, 0, time.Local)))
+			Expect(dates.Until).To(Equal(time.Date(2024, 6, 11, 0, 0, 0, 0, time.Local)))
+
+			dates, err = history.ParseDateRange("2024-06-01T09:30:00Z", "", now)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dates.Since).To(BeTemporally("==", time.Date(2024, 6, 1, 9, 30, 0, 0, time.UTC)))
+		})
+		it("names the accepted formats of an invalid date", func() {
+			_, err := history.ParseDateRange("last week", "", now)
+			Expect(err).To(MatchError(ContainSubstring(`invalid date "last week", use a date such as 2024-06-01`)))
+			Expect(err).To(MatchError(ContainSubstring("12h, 7d or 2w")))
+
+			_, err = history.ParseDateRange("", "-3d", now)
+			Expect(err).To(HaveOccurred())
+		})
+		it("refuses an empty range", func() {
+			_, err := history.ParseDateRange("2024-06-10", "2024-06-01", now)
+			Expect(err).To(MatchError("the date range is empty, 2024-06-10 is not before 2024-06-01"))
+		})
+	})
+
+	when("Contains()", func() {
+		dates := history.DateRange{Since: now.AddDate(0, 0, -1), Until: now}
+
+		it("includes the start of the range and excludes its end", func() {
+			Expect(dates.Contains(dates.Since)).To(BeTrue())
+			Expect(dates.Contains(now.Add(-time.Hour))).To(BeTrue())
+			Expect(dates.Contains(now)).To(BeFalse())
+			Expect(dates.Contains(now.AddDate(0, 0, -2))).To(BeFalse())
+		})
+		it("only includes the messages without a time when asked to", func() {
+			Expect(dates.Contains(time.Time{})).To(BeFalse())
+
+			dates.Undated = true
+			Expect(dates.Contains(time.Time{})).To(BeTrue())
+			Expect(history.DateRange{}.Contains(time.Time{})).To(BeTrue())
+		})
+	})
+
+	when("Filter()", func() {
+		it("keeps the system prompt and the messages in the range", func() {
+			messages := []types.Message{
+				{Role: "system", Content: "You are a helpful assistant."},
+				{Role: "user", Content: "old", Timestamp: now.AddDate(0, 0, -3)},
+				{Role: "user", Content: "undated"},
+				{Role: "user", Content: "recent", Timestamp: now.Add(-time.Hour)},
+			}
+
+			filtered := history.DateRange{Since: now.AddDate(0, 0, -1)}.Filter(messages)
+			Expect(filtered).To(HaveLen(2))
+			Expect(filtered[0].Role).To(Equal("system"))
+			Expect(filtered[1].Content).To(Equal("recent"))
+
+			Expect(history.DateRange{}.Filter(messages)).To(HaveLen(4))
+		})
+	})
+}
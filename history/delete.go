@@ -0,0 +1,92 @@
+package history
+
+import (
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/types"
+	"strconv"
+	"strings"
+)
+
+// DeleteResult reports what DeleteMessages removed. The positions are the ones the messages had
+// before they were removed.
+type DeleteResult struct {
+	Deleted []int
+	// Orphaned holds the removed questions whose answer was kept.
+	Orphaned []int
+}
+
+// ParseIndices parses a comma separated list of message positions and ranges of positions, such as
+// "3,12-13".
+func ParseIndices(value string) ([]int, error) {
+	var result []int
+
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+
+		from, to, isRange := strings.Cut(part, "-")
+		first, err := strconv.Atoi(from)
+		if err != nil || first < 0 {
+			return nil, fmt.Errorf("invalid message index %q, use positions such as 3 or ranges such as 12-13", part)
+		}
+
+		last := first
+		if isRange {
+			if last, err = strconv.Atoi(to); err != nil || last < first {
+				return nil, fmt.Errorf("invalid message range %q, use positions such as 3 or ranges such as 12-13", part)
+			}
+		}
+
+		for index := first; index <= last; index++ {
+			result = append(result, index)
+		}
+	}
+
+	return result, nil
+}
+
+// deleteMessages returns the messages without the ones at the given positions. System prompts are
+// never removed. With pairs a question is removed along with the answer that follows it, and an
+// answer along with its question.
+func deleteMessages(messages []types.Message, indices []int, pairs bool) ([]types.Message, DeleteResult, error) {
+	deleted := make(map[int]bool)
+
+	for _, index := range indices {
+		if index < 0 || index >= len(messages) {
+			return nil, DeleteResult{}, fmt.Errorf("message %d does not exist, the thread has %d messages", index, len(messages))
+		}
+
+		if messages[index].Role == systemRole {
+			return nil, DeleteResult{}, fmt.Errorf("message %d is a system prompt and can't be deleted", index)
+		}
+
+		deleted[index] = true
+
+		if !pairs {
+			continue
+		}
+
+		if messages[index].Role == userRole && index+1 < len(messages) && messages[index+1].Role == assistantRole {
+			deleted[index+1] = true
+		}
+		if messages[index].Role == assistantRole && index > 0 && messages[index-1].Role == userRole {
+			deleted[index-1] = true
+		}
+	}
+
+	var result DeleteResult
+	kept := make([]types.Message, 0, len(messages)-len(deleted))
+
+	for index, message := range messages {
+		if !deleted[index] {
+			kept = append(kept, message)
+			continue
+		}
+
+		result.Deleted = append(result.Deleted, index)
+		if message.Role == userRole && index+1 < len(messages) && messages[index+1].Role == assistantRole && !deleted[index+1] {
+			result.Orphaned = append(result.Orphaned, index)
+		}
+	}
+
+	return kept, result, nil
+}
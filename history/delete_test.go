@@ -0,0 +1,91 @@
+package history_test
+
+import (
+	"github.com/kardolus/chatgpt-cli/history"
+	"github.com/kardolus/chatgpt-cli/types"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitDelete(t *testing.T) {
+	spec.Run(t, "Testing the deletion of messages", testDelete, spec.Report(report.Terminal{}))
+}
+
+func testDelete(t *testing.T, when spec.G, it spec.S) {
+	it.Before(func() {
+		RegisterTestingT(t)
+	})
+
+	when("ParseIndices()", func() {
+		it("parses positions and ranges", func() {
+			Expect(history.ParseIndices("3, 12-13")).To(Equal([]int{3, 12, 13}))
+			Expect(history.ParseIndices("0")).To(Equal([]int{0}))
+		})
+
+		it("refuses anything else", func() {
+			for _, value := range []string{"", "-1", "a", "13-12", "1-", "1-2-3"} {
+				_, err := history.ParseIndices(value)
+				Expect(err).To(HaveOccurred(), value)
+			}
+		})
+	})
+
+	when("DeleteMessages()", func() {
+		var store *history.MemoryStore
+
+		conversation := []types.Message{
+			{Role: "system", Content: "You are a helpful assistant."},
+			{Role: "user", Content: "my password is hunter2"},
+			{Role: "assistant", Content: "Don't share your password."},
+			{Role: "user", Content: "noted"},
+			{Role: "assistant", Content: "Good."},
+		}
+
+		contents := func() []string {
+			messages, err := store.Read()
+			Expect(err).NotTo(HaveOccurred())
+
+			var result []string
+			for _, message := range messages {
+				result = append(result, message.Content)
+			}
+			return result
+		}
+
+		it.Before(func() {
+			store = history.NewMemoryStore()
+			store.SetThread("default")
+			Expect(store.Write(conversation)).To(Succeed())
+		})
+
+		it("removes the messages and reports the questions whose answer was kept", func() {
+			result, err := store.DeleteMessages("default", []int{1}, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal(history.DeleteResult{Deleted: []int{1}, Orphaned: []int{1}}))
+
+			Expect(contents()).To(Equal([]string{"You are a helpful assistant.", "Don't share your password.", "noted", "Good."}))
+		})
+
+		it("removes the questions and answers as pairs", func() {
+			result, err := store.DeleteMessages("default", []int{1, 4}, true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Deleted).To(Equal([]int{1, 2, 3, 4}))
+			Expect(result.Orphaned).To(BeEmpty())
+
+			Expect(contents()).To(Equal([]string{"You are a helpful assistant."}))
+		})
+
+		it("refuses to delete the system prompt or messages that don't exist", func() {
+			_, err := store.DeleteMessages("default", []int{0}, false)
+			Expect(err).To(MatchError("message 0 is a system prompt and can't be deleted"))
+
+			_, err = store.DeleteMessages("default", []int{3, 5}, false)
+			Expect(err).To(MatchError("message 5 does not exist, the thread has 5 messages"))
+
+			Expect(contents()).To(HaveLen(len(conversation)))
+		})
+	})
+}
@@ -0,0 +1,278 @@
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/utils"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	encryptedMagic = "chatgpt-cli encrypted history v1\n"
+	saltSize       = 16
+
+	// argon2id parameters, as recommended by RFC 9106 for memory constrained environments
+	argonTime    = 3
+	argonMemory  = 64 * 1024
+	argonThreads = 4
+)
+
+var (
+	ErrEncrypted = errors.New("the history is encrypted, configure the passphrase to read it")
+	ErrWrongKey  = errors.New("failed to decrypt the history, the passphrase is wrong or the file was tampered with")
+)
+
+// encryption seals the thread files with XChaCha20-Poly1305, using a key derived from the passphrase
+// with argon2id. Every file carries the salt its key was derived with, the keys are derived once
+// per salt and kept in memory.
+type encryption struct {
+	passphrase []byte
+	salt       []byte
+
+	mu   sync.Mutex
+	keys map[string][]byte
+}
+
+// WithPassphrase encrypts the threads the store writes with a key derived from the passphrase.
+// Plain threads are still read, and are encrypted the next time they are written.
+func (f *FileIO) WithPassphrase(passphrase []byte) *FileIO {
+	f.encryption = &encryption{
+		passphrase: passphrase,
+		keys:       make(map[string][]byte),
+	}
+	return f
+}
+
+// EncryptHistory encrypts every plain thread, with its backup and its archives, in place, and the
+// index of their titles and summaries. Each file is replaced in a single step, so a thread is either
+// plain or encrypted, never partially written. It returns the number of threads that were encrypted.
+func (f *FileIO) EncryptHistory() (int, error) {
+	if f.encryption == nil {
+		return 0, errors.New("no passphrase is configured to encrypt the history with")
+	}
+
+	var encrypted int
+
+	err := f.withLock(true, func() error {
+		var err error
+		if encrypted, err = f.encryptDir(f.historyDir); err != nil {
+			return err
+		}
+
+		_, err = f.encryptDir(filepath.Join(f.historyDir, archiveDir))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	})
+
+	return encrypted, err
+}
+
+// encryptDir encrypts the plain history files in the directory and returns how many of them were
+// threads rather than backups or the index.
+func (f *FileIO) encryptDir(dir string) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var encrypted int
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() ||
+			(filepath.Ext(name) != jsonExtension && !isCompressedName(name) && !strings.HasSuffix(name, jsonExtension+backupExtension)) {
+			continue
+		}
+
+		path := filepath.Join(dir, name)
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return encrypted, err
+		}
+
+		if isEncrypted(data) {
+			continue
+		}
+
+		sealed, err := f.encryption.seal(data)
+		if err != nil {
+			return encrypted, err
+		}
+
+		if err := utils.WriteFileAtomic(path, sealed, filePerm); err != nil {
+			return encrypted, fmt.Errorf("failed to encrypt %s: %w", name, err)
+		}
+
+		if filepath.Ext(name) == jsonExtension && name != indexFile {
+			encrypted++
+		}
+	}
+
+	return encrypted, nil
+}
+
+// readThreadData returns the content of a thread file, decrypted when it is encrypted and
+// decompressed when it is a compressed archive.
+func (f *FileIO) readThreadData(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.readData(path, data)
+}
+
+// readData decrypts and decompresses the content of the file.
+func (f *FileIO) readData(path string, data []byte) ([]byte, error) {
+	data, err := f.decrypt(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return Decompress(path, data)
+}
+
+// openThread opens a thread file for streaming. Encrypted files and compressed archives are
+// decrypted and decompressed as a whole first, so a damaged archive is reported rather than read
+// as an empty one.
+func (f *FileIO) openThread(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(file)
+	if prefix, _ := reader.Peek(len(encryptedMagic)); !isEncrypted(prefix) && !isCompressed(prefix) {
+		return struct {
+			io.Reader
+			io.Closer
+		}{reader, file}, nil
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	plain, err := f.readData(path, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.NopCloser(bytes.NewReader(plain)), nil
+}
+
+func (f *FileIO) decrypt(data []byte) ([]byte, error) {
+	if !isEncrypted(data) {
+		return data, nil
+	}
+
+	if f.encryption == nil {
+		return nil, ErrEncrypted
+	}
+
+	return f.encryption.open(data)
+}
+
+// encrypt seals the data when the store has a passphrase.
+func (f *FileIO) encrypt(data []byte) ([]byte, error) {
+	if f.encryption == nil {
+		return data, nil
+	}
+
+	return f.encryption.seal(data)
+}
+
+func isEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(encryptedMagic))
+}
+
+// seal returns the magic, the salt, the nonce and the sealed data, in that order. The salt is
+// picked on the first write and shared by everything the store writes.
+func (e *encryption) seal(plain []byte) ([]byte, error) {
+	salt, err := e.writeSalt()
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := chacha20poly1305.NewX(e.key(salt))
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	result := make([]byte, 0, len(encryptedMagic)+saltSize+len(nonce)+len(plain)+aead.Overhead())
+	result = append(result, encryptedMagic...)
+	result = append(result, salt...)
+	result = append(result, nonce...)
+
+	// the header is authenticated along with the data
+	return aead.Seal(result, nonce, plain, result), nil
+}
+
+func (e *encryption) open(data []byte) ([]byte, error) {
+	headerSize := len(encryptedMagic) + saltSize + chacha20poly1305.NonceSizeX
+	if len(data) < headerSize {
+		return nil, ErrWrongKey
+	}
+
+	salt := data[len(encryptedMagic) : len(encryptedMagic)+saltSize]
+	nonce := data[len(encryptedMagic)+saltSize : headerSize]
+
+	aead, err := chacha20poly1305.NewX(e.key(salt))
+	if err != nil {
+		return nil, err
+	}
+
+	plain, err := aead.Open(nil, nonce, data[headerSize:], data[:headerSize])
+	if err != nil {
+		return nil, ErrWrongKey
+	}
+
+	return plain, nil
+}
+
+func (e *encryption) writeSalt() ([]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.salt == nil {
+		salt := make([]byte, saltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		e.salt = salt
+	}
+
+	return e.salt, nil
+}
+
+func (e *encryption) key(salt []byte) []byte {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if key, ok := e.keys[string(salt)]; ok {
+		return key
+	}
+
+	key := argon2.IDKey(e.passphrase, salt, argonTime, argonMemory, argonThreads, chacha20poly1305.KeySize)
+	e.keys[string(salt)] = key
+
+	return key
+}
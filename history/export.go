@@ -0,0 +1,284 @@
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/types"
+	"strings"
+	"time"
+)
+
+const (
+	ExportVersion  = 1
+	FormatJSON     = "json"
+	FormatJSONL    = "jsonl"
+	FormatMarkdown = "md"
+)
+
+// Export is the lossless export format of a thread. As JSON the messages are part of the document,
+// as JSONL the first line holds the document without its messages and every following line holds
+// a single message. Files with a version other than ExportVersion are refused on import.
+type Export struct {
+	Version  int             `json:"version"`
+	Thread   string          `json:"thread"`
+	Exported time.Time       `json:"exported"`
+	Tags     []string        `json:"tags,omitempty"`
+	Archived bool            `json:"archived,omitempty"`
+	Messages []types.Message `json:"messages,omitempty"`
+}
+
+// exportDocument is the wire form of an Export, with the messages as they are stored.
+type exportDocument struct {
+	Version  int             `json:"version"`
+	Thread   string          `json:"thread"`
+	Exported time.Time       `json:"exported"`
+	Tags     []string        `json:"tags,omitempty"`
+	Archived bool            `json:"archived,omitempty"`
+	Messages []storedMessage `json:"messages,omitempty"`
+}
+
+// ExportOptions tunes how a thread is exported.
+type ExportOptions struct {
+	// OmitSystem leaves the system prompts out of the export.
+	OmitSystem bool
+}
+
+// ExportMarkdown renders the messages of a thread as Markdown, with a heading carrying the thread
+// name and the date, followed by a labeled section per message. The content is kept verbatim, and a
+// code fence the content leaves open is closed so it can't swallow the sections that follow.
+func ExportMarkdown(thread string, date time.Time, messages []types.Message, opts ExportOptions) string {
+	var result strings.Builder
+
+	fmt.Fprintf(&result, "# %s\n\n_%s_\n", thread, date.Format("2006-01-02"))
+
+	for _, message := range messages {
+		if opts.OmitSystem && message.Role == systemRole {
+			continue
+		}
+
+		fmt.Fprintf(&result, "\n**%s:**\n\n%s\n", roleLabel(message.Role), closeOpenFence(strings.TrimRight(message.Content, "\n")))
+	}
+
+	return result.String()
+}
+
+// ExportJSON renders the messages of a thread, along with its tags and whether it is archived, as a
+// single JSON document.
+func ExportJSON(thread string, date time.Time, messages []types.Message, tags []string, archived bool) ([]byte, error) {
+	return json.MarshalIndent(exportDocument{
+		Version:  ExportVersion,
+		Thread:   thread,
+		Exported: date,
+		Tags:     tags,
+		Archived: archived,
+		Messages: toStored(messages),
+	}, "", "  ")
+}
+
+// ExportJSONL renders the messages of a thread as a header line, which carries the tags and whether
+// the thread is archived, followed by one line per message.
+func ExportJSONL(thread string, date time.Time, messages []types.Message, tags []string, archived bool) ([]byte, error) {
+	var result bytes.Buffer
+
+	encoder := json.NewEncoder(&result)
+	if err := encoder.Encode(exportDocument{Version: ExportVersion, Thread: thread, Exported: date, Tags: tags, Archived: archived}); err != nil {
+		return nil, err
+	}
+
+	for _, message := range toStored(messages) {
+		if err := encoder.Encode(message); err != nil {
+			return nil, err
+		}
+	}
+
+	return result.Bytes(), nil
+}
+
+// ParseExport reads an export in either the JSON or the JSONL format and validates it. Unknown
+// fields, unsupported versions and messages without a known role are rejected.
+func ParseExport(data []byte) (Export, error) {
+	trimmed := bytes.TrimSpace(data)
+
+	// check the version first, newer versions may well have fields this version doesn't know
+	var header struct {
+		Version int `json:"version"`
+	}
+	if err := json.NewDecoder(bytes.NewReader(trimmed)).Decode(&header); err != nil {
+		return Export{}, fmt.Errorf("invalid export: %w", err)
+	}
+
+	if header.Version != ExportVersion {
+		return Export{}, fmt.Errorf("unsupported export version %d, expected version %d", header.Version, ExportVersion)
+	}
+
+	var document exportDocument
+	decoder := json.NewDecoder(bytes.NewReader(trimmed))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(&document); err != nil {
+		return Export{}, fmt.Errorf("invalid export: %w", err)
+	}
+
+	tags, err := NormalizeTags(document.Tags)
+	if err != nil {
+		return Export{}, fmt.Errorf("invalid export: %w", err)
+	}
+
+	result := Export{
+		Version:  document.Version,
+		Thread:   document.Thread,
+		Exported: document.Exported,
+		Tags:     tags,
+		Archived: document.Archived,
+		Messages: fromStored(document.Messages),
+	}
+
+	// anything after the header makes it JSONL
+	if rest := trimmed[decoder.InputOffset():]; len(bytes.TrimSpace(rest)) > 0 {
+		if len(result.Messages) > 0 {
+			return Export{}, errors.New("invalid export: a JSONL header can't contain messages")
+		}
+
+		scanner := bufio.NewScanner(bytes.NewReader(rest))
+		scanner.Buffer(nil, len(rest)+1)
+
+		// the rest starts with the remainder of the header line
+		for line := 1; scanner.Scan(); line++ {
+			if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+				continue
+			}
+
+			var message storedMessage
+			lineDecoder := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+			lineDecoder.DisallowUnknownFields()
+
+			if err := lineDecoder.Decode(&message); err != nil {
+				return Export{}, fmt.Errorf("invalid export on line %d: %w", line, err)
+			}
+			result.Messages = append(result.Messages, message.toMessage())
+		}
+
+		if err := scanner.Err(); err != nil {
+			return Export{}, err
+		}
+	}
+
+	for i, message := range result.Messages {
+		switch message.Role {
+		case systemRole, userRole, assistantRole:
+		default:
+			return Export{}, fmt.Errorf("invalid export: message %d has unknown role %q", i, message.Role)
+		}
+	}
+
+	return result, nil
+}
+
+// Import stores the messages of the export in the given thread, or in the thread of the export when
+// thread is empty. With replace the thread is overwritten, otherwise the messages are appended to
+// the existing conversation, leaving out a leading system prompt when the thread already has one.
+// The tags of the export are added to the thread, and an archived export archives it. The current
+// thread of the store is left unchanged.
+func Import(store HistoryStore, export Export, thread string, replace bool) error {
+	if thread == "" {
+		thread = export.Thread
+	}
+
+	if err := ValidateThread(thread); err != nil {
+		return err
+	}
+
+	messages := export.Messages
+
+	if !replace {
+		existing, err := store.ReadThread(thread)
+		if err != nil {
+			return err
+		}
+
+		if len(existing) > 0 && len(messages) > 0 && messages[0].Role == systemRole && existing[0].Role == systemRole {
+			messages = messages[1:]
+		}
+
+		messages = append(existing, messages...)
+	}
+
+	previous := store.GetThread()
+	store.SetThread(thread)
+	err := store.Write(messages)
+	store.SetThread(previous)
+
+	if err != nil {
+		return err
+	}
+
+	if len(export.Tags) > 0 {
+		if _, err := AddTags(store, thread, export.Tags); err != nil {
+			return err
+		}
+	}
+
+	if export.Archived {
+		return store.SetArchived(thread, true)
+	}
+
+	return nil
+}
+
+func roleLabel(role string) string {
+	if role == "" {
+		return "Unknown"
+	}
+
+	return strings.ToUpper(role[:1]) + role[1:]
+}
+
+// closeOpenFence appends a closing fence when the content opens a code fence without closing it. A
+// fence is closed by a line of at least as many of the same characters, following CommonMark.
+func closeOpenFence(content string) string {
+	var open string
+
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		if len(line)-len(trimmed) > 3 {
+			continue
+		}
+
+		fence := fenceOf(trimmed)
+		switch {
+		case fence == "":
+		case open == "":
+			open = fence
+		case fence[0] == open[0] && len(fence) >= len(open) && strings.TrimSpace(trimmed[len(fence):]) == "":
+			open = ""
+		}
+	}
+
+	if open == "" {
+		return content
+	}
+
+	return content + "\n" + open
+}
+
+// fenceOf returns the run of backticks or tildes that starts line when it is long enough to be a
+// code fence.
+func fenceOf(line string) string {
+	if line == "" || (line[0] != '`' && line[0] != '~') {
+		return ""
+	}
+
+	n := 0
+	for n < len(line) && line[n] == line[0] {
+		n++
+	}
+
+	if n < 3 {
+		return ""
+	}
+
+	return line[:n]
+}
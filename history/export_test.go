@@ -0,0 +1,211 @@
+package history_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/golang/mock/gomock"
+	"github.com/kardolus/chatgpt-cli/history"
+	"github.com/kardolus/chatgpt-cli/types"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitExport(t *testing.T) {
+	spec.Run(t, "Testing the history export", testExport, spec.Report(report.Terminal{}))
+}
+
+func testExport(t *testing.T, when spec.G, it spec.S) {
+	date := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	it.Before(func() {
+		RegisterTestingT(t)
+	})
+
+	when("ExportMarkdown()", func() {
+		messages := []types.Message{
+			{Role: "system", Content: "You are a helpful assistant."},
+			{Role: "user", Content: "Show me a loop"},
+			{Role: "assistant", Content: "Here you go:\n\n```go\nfor {}\n```\n"},
+		}
+
+		it("renders a heading and a labeled section per message", func() {
+			result := history.ExportMarkdown("default", date, messages, history.ExportOptions{})
+
+			Expect(result).To(Equal("# default\n\n_2024-03-01_\n" +
+				"\n**System:**\n\nYou are a helpful assistant.\n" +
+				"\n**User:**\n\nShow me a loop\n" +
+				"\n**Assistant:**\n\nHere you go:\n\n```go\nfor {}\n```\n"))
+		})
+		it("omits the system prompt when asked to", func() {
+			result := history.ExportMarkdown("default", date, messages, history.ExportOptions{OmitSystem: true})
+
+			Expect(result).NotTo(ContainSubstring("System"))
+			Expect(result).To(ContainSubstring("**User:**"))
+		})
+		it("closes a fence that the content leaves open", func() {
+			result := history.ExportMarkdown("default", date, []types.Message{
+				{Role: "assistant", Content: "````md\n```go\nfor {}\n```\n"},
+				{Role: "user", Content: "thanks"},
+			}, history.ExportOptions{})
+
+			Expect(result).To(ContainSubstring("````md\n```go\nfor {}\n```\n````\n\n**User:**"))
+		})
+		it("does not close a fence with a shorter or different fence", func() {
+			result := history.ExportMarkdown("default", date, []types.Message{
+				{Role: "assistant", Content: "~~~\ncode\n```"},
+			}, history.ExportOptions{})
+
+			Expect(result).To(HaveSuffix("~~~\ncode\n```\n~~~\n"))
+		})
+	})
+	when("exporting and importing JSON and JSONL", func() {
+		// every kind of message the history can hold
+		messages := []types.Message{
+			{Role: "system", Content: "You are a helpful assistant."},
+			{Role: "user", Content: "Multi-line\ncontent with \"quotes\", tabs\t, unicode ✓ and <html> & ```fences```"},
+			{Role: "user", Content: ""},
+			{Role: "assistant", Content: "The current answer", Alternatives: []string{"first try", "second try"}},
+			{Role: "assistant", Content: "An answer without alternatives"},
+			{Role: "user", Content: "A timestamped question", Timestamp: date.Add(-time.Hour)},
+		}
+
+		roundTrip := func(export func(string, time.Time, []types.Message, []string, bool) ([]byte, error)) {
+			data, err := export("default", date, messages, []string{"golang", "work"}, true)
+			Expect(err).NotTo(HaveOccurred())
+
+			result, err := history.ParseExport(data)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Version).To(Equal(history.ExportVersion))
+			Expect(result.Thread).To(Equal("default"))
+			Expect(result.Tags).To(Equal([]string{"golang", "work"}))
+			Expect(result.Archived).To(BeTrue())
+			Expect(result.Exported).To(BeTemporally("==", date))
+			Expect(result.Messages).To(HaveLen(len(messages)))
+
+			for i := range messages {
+				expected, err := json.Marshal(messages[i])
+				Expect(err).NotTo(HaveOccurred())
+				actual, err := json.Marshal(result.Messages[i])
+				Expect(err).NotTo(HaveOccurred())
+				Expect(actual).To(Equal(expected))
+				Expect(result.Messages[i].Timestamp).To(BeTemporally("==", messages[i].Timestamp))
+			}
+		}
+
+		it("round-trips every message variant through JSON", func() {
+			roundTrip(history.ExportJSON)
+		})
+		it("round-trips every message variant through JSONL", func() {
+			roundTrip(history.ExportJSONL)
+		})
+		it("writes one message per line as JSONL", func() {
+			data, err := history.ExportJSONL("default", date, messages, nil, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(bytes.Count(data, []byte("\n"))).To(Equal(len(messages) + 1))
+		})
+		it("refuses unsupported versions", func() {
+			_, err := history.ParseExport([]byte(`{"version": 2, "thread": "default", "future": true}`))
+			Expect(err).To(MatchError("unsupported export version 2, expected version 1"))
+		})
+		it("refuses unknown fields and roles", func() {
+			_, err := history.ParseExport([]byte(`{"version": 1, "thread": "default", "unknown": true}`))
+			Expect(err).To(HaveOccurred())
+
+			_, err = history.ParseExport([]byte("{\"version\": 1}\n{\"role\": \"user\", \"content\": \"hi\", \"extra\": 1}\n"))
+			Expect(err).To(MatchError(ContainSubstring("line 2")))
+
+			_, err = history.ParseExport([]byte(`{"version": 1, "messages": [{"role": "robot", "content": "hi"}]}`))
+			Expect(err).To(MatchError(`invalid export: message 0 has unknown role "robot"`))
+
+			_, err = history.ParseExport([]byte(`{"version": 1, "tags": ["two words"]}`))
+			Expect(err).To(MatchError(ContainSubstring("tags can't contain spaces or commas")))
+		})
+		it("refuses malformed files", func() {
+			_, err := history.ParseExport([]byte(`[{"role": "user"}]`))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	when("Import()", func() {
+		var (
+			mockCtrl  *gomock.Controller
+			mockStore *MockHistoryStore
+		)
+
+		export := history.Export{
+			Version: history.ExportVersion,
+			Thread:  "imported",
+			Messages: []types.Message{
+				{Role: "system", Content: "system"},
+				{Role: "user", Content: "question"},
+			},
+		}
+
+		it.Before(func() {
+			mockCtrl = gomock.NewController(t)
+			mockStore = NewMockHistoryStore(mockCtrl)
+		})
+
+		it.After(func() {
+			mockCtrl.Finish()
+		})
+
+		it("replaces the thread of the export and restores the current thread", func() {
+			mockStore.EXPECT().GetThread().Return("current")
+			gomock.InOrder(
+				mockStore.EXPECT().SetThread("imported"),
+				mockStore.EXPECT().Write(export.Messages).Return(nil),
+				mockStore.EXPECT().SetThread("current"),
+			)
+
+			Expect(history.Import(mockStore, export, "", true)).To(Succeed())
+		})
+		it("appends to an existing thread without repeating the system prompt", func() {
+			existing := []types.Message{
+				{Role: "system", Content: "system"},
+				{Role: "user", Content: "earlier"},
+			}
+
+			mockStore.EXPECT().ReadThread("target").Return(existing, nil)
+			mockStore.EXPECT().GetThread().Return("current")
+			mockStore.EXPECT().SetThread("target")
+			mockStore.EXPECT().Write(append(existing, export.Messages[1])).Return(nil)
+			mockStore.EXPECT().SetThread("current")
+
+			Expect(history.Import(mockStore, export, "target", false)).To(Succeed())
+		})
+		it("adds the tags of the export to the thread", func() {
+			tagged := export
+			tagged.Tags = []string{"golang"}
+
+			mockStore.EXPECT().GetThread().Return("current")
+			mockStore.EXPECT().SetThread("imported")
+			mockStore.EXPECT().Write(export.Messages).Return(nil)
+			mockStore.EXPECT().SetThread("current")
+			mockStore.EXPECT().ListThreads().Return([]history.ThreadInfo{{Name: "imported", Tags: []string{"work"}}}, nil)
+			mockStore.EXPECT().SetTags("imported", []string{"golang", "work"}).Return(nil)
+
+			Expect(history.Import(mockStore, tagged, "", true)).To(Succeed())
+		})
+		it("archives the thread of an archived export", func() {
+			archived := export
+			archived.Archived = true
+
+			mockStore.EXPECT().GetThread().Return("current")
+			mockStore.EXPECT().SetThread("imported")
+			mockStore.EXPECT().Write(export.Messages).Return(nil)
+			mockStore.EXPECT().SetThread("current")
+			mockStore.EXPECT().SetArchived("imported", true).Return(nil)
+
+			Expect(history.Import(mockStore, archived, "", true)).To(Succeed())
+		})
+		it("rejects invalid thread names", func() {
+			err := history.Import(mockStore, history.Export{Thread: "../escape"}, "", true)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+}
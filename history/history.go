@@ -3,7 +3,9 @@ package history
 import (
 	"fmt"
 	"github.com/kardolus/chatgpt-cli/types"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 const (
@@ -21,29 +23,39 @@ func NewHistory(store HistoryStore) *History {
 }
 
 func (h *History) Print(thread string) (string, error) {
-	var result string
-
 	messages, err := h.store.ReadThread(thread)
 	if err != nil {
 		return "", err
 	}
 
+	return Format(messages), nil
+}
+
+// Format renders the messages in a human-readable way. Consecutive user messages, which is how
+// provided context ends up in the history, are concatenated into a single entry. Pinned messages
+// are marked with a pin.
+func Format(messages []types.Message) string {
+	var result string
+
 	var (
 		lastRole            string
 		concatenatedMessage string
+		concatenatedPinned  bool
 	)
 
 	for _, message := range messages {
 		if message.Role == userRole && lastRole == userRole {
 			concatenatedMessage += message.Content
+			concatenatedPinned = concatenatedPinned || message.Pinned
 		} else {
 			if lastRole == userRole && concatenatedMessage != "" {
-				result += formatMessage(types.Message{Role: userRole, Content: concatenatedMessage})
+				result += formatMessage(types.Message{Role: userRole, Content: concatenatedMessage, Pinned: concatenatedPinned})
 				concatenatedMessage = ""
 			}
 
 			if message.Role == userRole {
 				concatenatedMessage = message.Content
+				concatenatedPinned = message.Pinned
 			} else {
 				result += formatMessage(message)
 			}
@@ -54,10 +66,50 @@ func (h *History) Print(thread string) (string, error) {
 
 	// Handle the case where the last message is a user message and was concatenated
 	if lastRole == userRole && concatenatedMessage != "" {
-		result += formatMessage(types.Message{Role: userRole, Content: concatenatedMessage})
+		result += formatMessage(types.Message{Role: userRole, Content: concatenatedMessage, Pinned: concatenatedPinned})
 	}
 
-	return result, nil
+	return result
+}
+
+// FormatTable renders the threads as a table of their name, title, number of messages, last
+// activity and tags, a row each and the columns aligned. The current thread is marked with a *.
+func FormatTable(threads []ThreadInfo, current string) string {
+	const layout = "2006-01-02 15:04"
+
+	rows := [][]string{{"THREAD", "TITLE", "MESSAGES", "LAST ACTIVITY", "TAGS"}}
+	for _, thread := range threads {
+		name := "  " + thread.Name
+		if thread.Name == current {
+			name = "* " + thread.Name
+		}
+
+		updated := "-"
+		if !thread.Updated.IsZero() {
+			updated = thread.Updated.Format(layout)
+		}
+
+		rows = append(rows, []string{name, thread.Title, strconv.Itoa(thread.Messages), updated, strings.Join(thread.Tags, ", ")})
+	}
+	rows[0][0] = "  " + rows[0][0]
+
+	widths := make([]int, len(rows[0]))
+	for _, row := range rows {
+		for i, cell := range row {
+			widths[i] = max(widths[i], utf8.RuneCountInString(cell))
+		}
+	}
+
+	var result strings.Builder
+	for _, row := range rows {
+		var line strings.Builder
+		for i, cell := range row {
+			line.WriteString(cell + strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell)+2))
+		}
+		result.WriteString(strings.TrimRight(line.String(), " ") + "\n")
+	}
+
+	return result.String()
 }
 
 func formatMessage(msg types.Message) string {
@@ -78,5 +130,14 @@ func formatMessage(msg types.Message) string {
 		prefix = "\n"
 	}
 
+	if msg.Pinned {
+		emoji += " 📌"
+	}
+
+	// the model of the answer tells which one answered when the thread changed models
+	if msg.Model != "" {
+		emoji += " (" + msg.Model + ")"
+	}
+
 	return fmt.Sprintf("%s**%s** %s:\n%s\n", prefix, strings.ToUpper(msg.Role), emoji, msg.Content)
 }
@@ -9,6 +9,7 @@ import (
 	"github.com/sclevine/spec"
 	"github.com/sclevine/spec/report"
 	"testing"
+	"time"
 )
 
 //go:generate mockgen -destination=historymocks_test.go -package=history_test github.com/kardolus/chatgpt-cli/history HistoryStore
@@ -76,6 +77,19 @@ func testHistory(t *testing.T, when spec.G, it spec.S) {
 			Expect(result).To(ContainSubstring("**ASSISTANT** 🤖:\nassistant message\n"))
 		})
 
+		it("prints the model that gave an answer", func() {
+			messages := []types.Message{
+				{Role: "user", Content: "user message"},
+				{Role: "assistant", Content: "assistant message", Model: "gpt-4o"},
+			}
+
+			mockHistoryStore.EXPECT().ReadThread(threadName).Return(messages, nil).Times(1)
+
+			result, err := subject.Print(threadName)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(ContainSubstring("**ASSISTANT** 🤖 (gpt-4o):\nassistant message\n"))
+		})
+
 		it("handles the final user message concatenation", func() {
 			messages := []types.Message{
 				{Role: "user", Content: "first message"},
@@ -89,4 +103,21 @@ func testHistory(t *testing.T, when spec.G, it spec.S) {
 			Expect(result).To(ContainSubstring("**USER** 👤:\nfirst message second message\n"))
 		})
 	})
+
+	when("FormatTable()", func() {
+		it("aligns the threads in columns, with the current one marked", func() {
+			updated := time.Date(2024, 5, 1, 9, 30, 0, 0, time.UTC)
+			threads := []history.ThreadInfo{
+				{Name: "default", Messages: 12, Updated: updated},
+				{Name: "work", Title: "Release notes", Tags: []string{"go", "work"}, Messages: 4, Updated: updated.Add(time.Hour)},
+				{Name: "old"},
+			}
+
+			Expect(history.FormatTable(threads, "work")).To(Equal("" +
+				"  THREAD   TITLE          MESSAGES  LAST ACTIVITY     TAGS\n" +
+				"  default                 12        2024-05-01 09:30\n" +
+				"* work     Release notes  4         2024-05-01 10:30  go, work\n" +
+				"  old                     0         -\n"))
+		})
+	})
 }
@@ -0,0 +1,57 @@
+package history
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	lockFile          = ".lock"
+	lockRetryInterval = 10 * time.Millisecond
+
+	// DefaultLockTimeout is how long the store waits for another process to release the history.
+	DefaultLockTimeout = 5 * time.Second
+)
+
+var ErrLocked = errors.New("history is locked by another process")
+
+// WithLockTimeout sets how long the store waits for the lock on the history before it gives up
+// with ErrLocked.
+func (f *FileIO) WithLockTimeout(timeout time.Duration) *FileIO {
+	f.lockTimeout = timeout
+	return f
+}
+
+// withLock runs fn while holding an advisory lock on the history directory. Readers share the lock,
+// writers hold it on their own. The lock is taken on a separate file, so it covers the threads as
+// well as the index. Without a history directory there is nothing to protect and fn runs unlocked.
+func (f *FileIO) withLock(exclusive bool, fn func() error) error {
+	file, err := os.OpenFile(filepath.Join(f.historyDir, lockFile), os.O_CREATE|os.O_RDWR, filePerm)
+	if os.IsNotExist(err) {
+		return fn()
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	deadline := time.Now().Add(f.lockTimeout)
+	for {
+		locked, err := tryLock(file, exclusive)
+		if err != nil {
+			return err
+		}
+		if locked {
+			break
+		}
+		if time.Now().After(deadline) {
+			return ErrLocked
+		}
+		time.Sleep(lockRetryInterval)
+	}
+	defer unlock(file)
+
+	return fn()
+}
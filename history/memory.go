@@ -0,0 +1,528 @@
+package history
+
+import (
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/config"
+	"github.com/kardolus/chatgpt-cli/types"
+	"sort"
+	"sync"
+	"time"
+)
+
+const BackendMemory = "memory"
+
+// Ensure MemoryStore implements the HistoryStore interface
+var _ HistoryStore = &MemoryStore{}
+
+// MemoryStore keeps the history in memory only, so a conversation lasts as long as the program
+// and nothing is ever written to disk. It is safe for concurrent use.
+type MemoryStore struct {
+	mu      sync.Mutex
+	thread  string
+	threads map[string]*memoryThread
+}
+
+type memoryThread struct {
+	created  time.Time
+	updated  time.Time
+	messages []types.Message
+	title    string
+	tags     []string
+	summary  *types.Summary
+	archived bool
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{threads: make(map[string]*memoryThread)}
+}
+
+func (m *MemoryStore) GetThread() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.thread
+}
+
+func (m *MemoryStore) SetThread(thread string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.thread = thread
+}
+
+// Read returns the messages of the current thread. A thread that does not exist yet has no messages.
+func (m *MemoryStore) Read() ([]types.Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.read(m.thread)
+}
+
+// ReadThread returns the messages of the given thread. A thread that does not exist yet has no
+// messages.
+func (m *MemoryStore) ReadThread(thread string) ([]types.Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.read(thread)
+}
+
+// ReadPage returns up to limit messages of the thread, starting with the message at offset, along
+// with the number of messages of the thread.
+func (m *MemoryStore) ReadPage(thread string, offset, limit int) ([]types.Message, int, error) {
+	if err := validatePage(offset, limit); err != nil {
+		return nil, 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := ValidateThread(thread); err != nil {
+		return nil, 0, err
+	}
+
+	var messages []types.Message
+	if stored, ok := m.threads[thread]; ok {
+		messages = stored.messages
+	}
+
+	return page(messages, offset, limit), len(messages), nil
+}
+
+// Write replaces the messages of the current thread.
+func (m *MemoryStore) Write(messages []types.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.write(messages)
+}
+
+// Update reads the current thread, passes its messages to fn and writes what fn returns as a single
+// step.
+func (m *MemoryStore) Update(fn func([]types.Message) []types.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	messages, err := m.read(m.thread)
+	if err != nil {
+		return err
+	}
+
+	return m.write(fn(messages))
+}
+
+// ListThreads returns the threads sorted by name.
+func (m *MemoryStore) ListThreads() ([]ThreadInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var result []ThreadInfo
+	for name, thread := range m.threads {
+		result = append(result, ThreadInfo{
+			Name:     name,
+			Title:    thread.title,
+			Tags:     thread.tags,
+			Created:  thread.created,
+			Updated:  thread.updated,
+			Messages: len(thread.messages),
+			Summary:  thread.summary,
+			Archived: thread.archived,
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result, nil
+}
+
+// Clear removes the messages of the thread. Clearing a thread without history is not an error, and
+// the current thread stays the same.
+func (m *MemoryStore) Clear(thread string) error {
+	if err := ValidateThread(thread); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.threads, thread)
+	return nil
+}
+
+// DeleteThread removes the thread. Deleting a thread that does not exist is not an error. When the
+// current thread is deleted the store falls back to the default thread.
+func (m *MemoryStore) DeleteThread(thread string) error {
+	if err := m.Clear(thread); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if thread == m.thread {
+		m.thread = config.New().ReadDefaults().Thread
+	}
+
+	return nil
+}
+
+// RenameThread renames the thread from to to, which must not exist yet. When the current thread is
+// renamed the store follows it.
+func (m *MemoryStore) RenameThread(from, to string) error {
+	if err := ValidateThread(from); err != nil {
+		return err
+	}
+
+	if err := ValidateThread(to); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	thread, ok := m.threads[from]
+	if !ok {
+		return fmt.Errorf("thread %s does not exist", from)
+	}
+
+	if _, ok := m.threads[to]; ok {
+		return fmt.Errorf("thread %s already exists", to)
+	}
+
+	delete(m.threads, from)
+	m.threads[to] = thread
+
+	if from == m.thread {
+		m.thread = to
+	}
+
+	return nil
+}
+
+// Search scans all threads, in alphabetical order, and calls fn for every message that matches.
+// When fn returns an error the search stops and returns that error.
+func (m *MemoryStore) Search(opts SearchOptions, fn func(SearchMatch) error) error {
+	pattern, err := CompileSearch(opts)
+	if err != nil {
+		return err
+	}
+
+	threads, err := m.ListThreads()
+	if err != nil {
+		return err
+	}
+
+	for _, thread := range FilterByTag(FilterArchived(threads, opts.ArchivedThreads), opts.Tag) {
+		// fn runs without the lock, so it may use the store itself
+		messages, err := m.ReadThread(thread.Name)
+		if err != nil {
+			return err
+		}
+
+		for index, message := range messages {
+			if !opts.Matches(message) {
+				continue
+			}
+
+			match, ok := MatchMessage(message, pattern)
+			if !ok {
+				continue
+			}
+
+			match.Thread = thread.Name
+			match.Index = index
+			match.Updated = thread.Updated
+			match.Timestamp = message.Timestamp
+
+			if err := fn(match); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Stats adds up the messages of the thread, or of all threads when the thread is empty, that were
+// added in the date range.
+func (m *MemoryStore) Stats(thread string, dates DateRange) (Stats, error) {
+	threads := []string{thread}
+	if thread == "" {
+		infos, err := m.ListThreads()
+		if err != nil {
+			return Stats{}, err
+		}
+
+		threads = nil
+		for _, info := range infos {
+			threads = append(threads, info.Name)
+		}
+	}
+
+	builder := newStatsBuilder(thread, dates)
+	for _, name := range threads {
+		messages, err := m.ReadThread(name)
+		if err != nil {
+			return Stats{}, err
+		}
+
+		for index, message := range messages {
+			builder.add(MessageRef{Thread: name, Index: index}, message)
+		}
+	}
+
+	return builder.stats, nil
+}
+
+// SetSummary keeps the summary of the thread, which ListThreads returns from then on.
+func (m *MemoryStore) SetSummary(thread string, summary types.Summary) error {
+	if err := ValidateThread(thread); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.threads[thread]
+	if !ok {
+		return fmt.Errorf("thread %s does not exist", thread)
+	}
+
+	stored.summary = &summary
+	return nil
+}
+
+// SetTitle gives the thread a title, which ListThreads returns from then on.
+func (m *MemoryStore) SetTitle(thread, title string) error {
+	if err := ValidateThread(thread); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.threads[thread]
+	if !ok {
+		return fmt.Errorf("thread %s does not exist", thread)
+	}
+
+	stored.title = title
+	return nil
+}
+
+// SetTags replaces the tags of the thread.
+func (m *MemoryStore) SetTags(thread string, tags []string) error {
+	if err := ValidateThread(thread); err != nil {
+		return err
+	}
+
+	tags, err := NormalizeTags(tags)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.threads[thread]
+	if !ok {
+		return fmt.Errorf("thread %s does not exist", thread)
+	}
+
+	stored.tags = tags
+	return nil
+}
+
+// SetArchived archives or unarchives the thread.
+func (m *MemoryStore) SetArchived(thread string, archived bool) error {
+	if err := ValidateThread(thread); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.threads[thread]
+	if !ok {
+		return fmt.Errorf("thread %s does not exist", thread)
+	}
+
+	stored.archived = archived
+	return nil
+}
+
+// MergeThreads adds the messages of src to dst and removes src. When the current thread is merged
+// the store follows it to dst.
+func (m *MemoryStore) MergeThreads(dst, src string, strategy MergeStrategy) (MergeResult, error) {
+	if dst == src {
+		return MergeResult{}, fmt.Errorf("can't merge thread %s into itself", src)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	source, err := m.read(src)
+	if err != nil {
+		return MergeResult{}, err
+	}
+	if len(source) == 0 {
+		return MergeResult{}, fmt.Errorf("thread %s does not exist", src)
+	}
+
+	target, err := m.read(dst)
+	if err != nil {
+		return MergeResult{}, err
+	}
+
+	merged, result, err := mergeMessages(target, source, strategy)
+	if err != nil {
+		return MergeResult{}, err
+	}
+
+	if err := m.writeThread(dst, merged); err != nil {
+		return MergeResult{}, err
+	}
+
+	delete(m.threads, src)
+	if src == m.thread {
+		m.thread = dst
+	}
+
+	return result, nil
+}
+
+// SetPinned pins or unpins the messages at the given positions. System prompts can't be pinned.
+func (m *MemoryStore) SetPinned(thread string, indices []int, pinned bool) error {
+	if err := ValidateThread(thread); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.threads[thread]
+	if !ok {
+		stored = &memoryThread{}
+	}
+
+	messages, err := pinMessages(stored.messages, indices, pinned)
+	if err != nil {
+		return err
+	}
+
+	stored.updated = time.Now()
+	stored.messages = messages
+
+	return nil
+}
+
+// DeleteMessages removes the messages at the given positions from the thread. System prompts can't
+// be deleted. With pairs a question is removed along with its answer.
+func (m *MemoryStore) DeleteMessages(thread string, indices []int, pairs bool) (DeleteResult, error) {
+	if err := ValidateThread(thread); err != nil {
+		return DeleteResult{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.threads[thread]
+	if !ok {
+		stored = &memoryThread{}
+	}
+
+	kept, result, err := deleteMessages(stored.messages, indices, pairs)
+	if err != nil {
+		return DeleteResult{}, err
+	}
+
+	if len(kept) == 0 {
+		delete(m.threads, thread)
+		return result, nil
+	}
+
+	stored.updated = time.Now()
+	stored.messages = kept
+
+	return result, nil
+}
+
+// Prune removes the messages older than olderThan from every thread. The system prompt a thread
+// starts with is kept, and a question is only removed along with its answer. With dryRun nothing is
+// removed. It returns the threads that lose messages, in alphabetical order.
+func (m *MemoryStore) Prune(olderThan time.Duration, dryRun bool) ([]PruneResult, error) {
+	cutoff := time.Now().Add(-olderThan)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var result []PruneResult
+	for name, thread := range m.threads {
+		kept := pruneMessages(thread.messages, cutoff, thread.updated, true)
+		if len(kept) == len(thread.messages) {
+			continue
+		}
+
+		result = append(result, PruneResult{Thread: name, Removed: len(thread.messages) - len(kept), Kept: len(kept)})
+
+		if dryRun {
+			continue
+		}
+
+		if len(kept) == 0 {
+			delete(m.threads, name)
+			continue
+		}
+
+		thread.updated = time.Now()
+		thread.messages = kept
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Thread < result[j].Thread
+	})
+
+	return result, nil
+}
+
+func (m *MemoryStore) read(thread string) ([]types.Message, error) {
+	if err := ValidateThread(thread); err != nil {
+		return nil, err
+	}
+
+	stored, ok := m.threads[thread]
+	if !ok {
+		return nil, nil
+	}
+
+	return append([]types.Message(nil), stored.messages...), nil
+}
+
+func (m *MemoryStore) write(messages []types.Message) error {
+	return m.writeThread(m.thread, messages)
+}
+
+func (m *MemoryStore) writeThread(name string, messages []types.Message) error {
+	if err := ValidateThread(name); err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	thread, ok := m.threads[name]
+	if !ok {
+		thread = &memoryThread{created: now}
+		m.threads[name] = thread
+	}
+
+	thread.updated = now
+	thread.messages = make([]types.Message, len(messages))
+	for i, message := range messages {
+		message.Tokens, message.TokenEncoding = countTokens(message)
+		thread.messages[i] = message
+	}
+
+	return nil
+}
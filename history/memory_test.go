@@ -0,0 +1,249 @@
+package history_test
+
+import (
+	"errors"
+	"github.com/kardolus/chatgpt-cli/history"
+	"github.com/kardolus/chatgpt-cli/types"
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"testing"
+	"time"
+)
+
+func TestUnitMemoryStore(t *testing.T) {
+	spec.Run(t, "Testing the MemoryStore", testMemoryStore, spec.Report(report.Terminal{}))
+}
+
+func testMemoryStore(t *testing.T, when spec.G, it spec.S) {
+	var store *history.MemoryStore
+
+	messages := []types.Message{
+		{Role: "user", Content: "How do I fix pgbouncer timeouts?"},
+		{Role: "assistant", Content: "Raise the PgBouncer query_timeout."},
+	}
+
+	it.Before(func() {
+		RegisterTestingT(t)
+		store = history.NewMemoryStore()
+		store.SetThread("default")
+	})
+
+	when("reading and writing", func() {
+		it("reads what was written and an unknown thread as empty", func() {
+			Expect(store.Write(messages)).To(Succeed())
+
+			result, err := store.Read()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(HaveLen(2))
+			Expect(result[1].Content).To(Equal(messages[1].Content))
+
+			result, err = store.ReadThread("does-not-exist")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(BeEmpty())
+		})
+		it("does not share the slices it is given or returns", func() {
+			written := append([]types.Message(nil), messages...)
+			Expect(store.Write(written)).To(Succeed())
+			written[0].Content = "changed"
+
+			result, err := store.Read()
+			Expect(err).NotTo(HaveOccurred())
+			result[1].Content = "changed"
+
+			result, err = store.Read()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result[0].Content).To(Equal(messages[0].Content))
+			Expect(result[1].Content).To(Equal(messages[1].Content))
+		})
+		it("updates the current thread", func() {
+			Expect(store.Write(messages[:1])).To(Succeed())
+			Expect(store.Update(func(stored []types.Message) []types.Message {
+				return append(stored, messages[1])
+			})).To(Succeed())
+
+			result, err := store.Read()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(HaveLen(2))
+		})
+		it("reads a page of a thread", func() {
+			Expect(store.Write(append(append([]types.Message(nil), messages...), messages...))).To(Succeed())
+
+			result, total, err := store.ReadPage("default", 1, 2)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(total).To(Equal(4))
+			Expect(result).To(HaveLen(2))
+			Expect(result[0].Content).To(Equal(messages[1].Content))
+			Expect(result[1].Content).To(Equal(messages[0].Content))
+
+			result, total, err = store.ReadPage("default", 4, 2)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(total).To(Equal(4))
+			Expect(result).To(BeEmpty())
+
+			result, total, err = store.ReadPage("does-not-exist", 0, 10)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(total).To(BeZero())
+			Expect(result).To(BeEmpty())
+
+			_, _, err = store.ReadPage("default", -1, 2)
+			Expect(err).To(MatchError("invalid page, the offset and the limit can't be negative"))
+		})
+		it("rejects invalid thread names", func() {
+			store.SetThread("../escape")
+			Expect(store.Write(messages)).NotTo(Succeed())
+		})
+	})
+
+	when("managing threads", func() {
+		it("lists, renames, clears and deletes threads", func() {
+			Expect(store.Write(messages)).To(Succeed())
+			store.SetThread("other")
+			Expect(store.Write(messages[:1])).To(Succeed())
+
+			threads, err := store.ListThreads()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(threads).To(HaveLen(2))
+			Expect(threads[0].Name).To(Equal("default"))
+			Expect(threads[0].Messages).To(Equal(2))
+
+			Expect(store.RenameThread("other", "renamed")).To(Succeed())
+			Expect(store.GetThread()).To(Equal("renamed"))
+			Expect(store.RenameThread("does-not-exist", "x")).To(MatchError("thread does-not-exist does not exist"))
+			Expect(store.RenameThread("renamed", "default")).To(MatchError("thread default already exists"))
+
+			Expect(store.Clear("default")).To(Succeed())
+			Expect(store.DeleteThread("renamed")).To(Succeed())
+			Expect(store.GetThread()).To(Equal("default"))
+
+			threads, err = store.ListThreads()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(threads).To(BeEmpty())
+		})
+
+		it("keeps the title of a thread", func() {
+			Expect(store.SetTitle("default", "A title")).To(MatchError("thread default does not exist"))
+
+			Expect(store.Write(messages)).To(Succeed())
+			Expect(store.SetTitle("default", "A title")).To(Succeed())
+
+			threads, err := store.ListThreads()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(threads[0].Title).To(Equal("A title"))
+		})
+
+		it("keeps the summary of a thread", func() {
+			summary := types.Summary{Topics: []string{"pgbouncer"}}
+			Expect(store.SetSummary("default", summary)).To(MatchError("thread default does not exist"))
+
+			Expect(store.Write(messages)).To(Succeed())
+			Expect(store.SetSummary("default", summary)).To(Succeed())
+			Expect(store.Write(messages)).To(Succeed())
+
+			threads, err := store.ListThreads()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(threads[0].Summary).To(Equal(&summary))
+		})
+
+		it("archives and unarchives a thread", func() {
+			Expect(store.SetArchived("default", true)).To(MatchError("thread default does not exist"))
+
+			Expect(store.Write(messages)).To(Succeed())
+			Expect(store.SetArchived("default", true)).To(Succeed())
+
+			threads, err := store.ListThreads()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(threads[0].Archived).To(BeTrue())
+			Expect(history.FilterArchived(threads, false)).To(BeEmpty())
+
+			var matches int
+			search := func(opts history.SearchOptions) {
+				matches = 0
+				Expect(store.Search(opts, func(history.SearchMatch) error {
+					matches++
+					return nil
+				})).To(Succeed())
+			}
+
+			search(history.SearchOptions{Term: "pgbouncer"})
+			Expect(matches).To(BeZero())
+			search(history.SearchOptions{Term: "pgbouncer", ArchivedThreads: true})
+			Expect(matches).NotTo(BeZero())
+
+			Expect(store.SetArchived("default", false)).To(Succeed())
+			threads, err = store.ListThreads()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(threads[0].Archived).To(BeFalse())
+		})
+	})
+
+	when("searching", func() {
+		it("matches the messages of all threads and stops when asked to", func() {
+			Expect(store.Write(messages)).To(Succeed())
+
+			var matches []history.SearchMatch
+			Expect(store.Search(history.SearchOptions{Term: "pgbouncer", Role: "assistant"}, func(match history.SearchMatch) error {
+				matches = append(matches, match)
+				return nil
+			})).To(Succeed())
+			Expect(matches).To(HaveLen(1))
+			Expect(matches[0].Index).To(Equal(1))
+
+			stop := errors.New("stop")
+			Expect(store.Search(history.SearchOptions{Term: "pgbouncer"}, func(history.SearchMatch) error {
+				return stop
+			})).To(MatchError(stop))
+		})
+	})
+
+	when("pruning", func() {
+		old := time.Now().Add(-48 * time.Hour)
+		recent := time.Now()
+
+		conversation := []types.Message{
+			{Role: "system", Content: "You are a helpful assistant.", Timestamp: old},
+			{Role: "user", Content: "old question", Timestamp: old},
+			{Role: "assistant", Content: "old answer", Timestamp: old},
+			{Role: "user", Content: "old question, recent answer", Timestamp: old},
+			{Role: "assistant", Content: "recent answer", Timestamp: recent},
+			{Role: "user", Content: "recent question", Timestamp: recent},
+		}
+
+		it("keeps the system prompt and the exchanges that are not entirely old", func() {
+			Expect(store.Write(conversation)).To(Succeed())
+
+			result, err := store.Prune(24*time.Hour, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal([]history.PruneResult{{Thread: "default", Removed: 2, Kept: 4}}))
+
+			stored, err := store.Read()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stored).To(HaveLen(4))
+			Expect(stored[0].Role).To(Equal("system"))
+			Expect(stored[1].Content).To(Equal("old question, recent answer"))
+		})
+		it("only reports what would be removed on a dry run", func() {
+			Expect(store.Write(conversation)).To(Succeed())
+
+			result, err := store.Prune(24*time.Hour, true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(HaveLen(1))
+			Expect(result[0].Removed).To(Equal(2))
+
+			stored, err := store.Read()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stored).To(HaveLen(len(conversation)))
+		})
+		it("removes threads that are entirely old", func() {
+			Expect(store.Write(conversation[1:3])).To(Succeed())
+
+			result, err := store.Prune(24*time.Hour, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal([]history.PruneResult{{Thread: "default", Removed: 2}}))
+
+			threads, err := store.ListThreads()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(threads).To(BeEmpty())
+		})
+	})
+}
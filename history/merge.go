@@ -0,0 +1,186 @@
+package history
+
+import (
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/types"
+	"strings"
+)
+
+// MergeStrategy decides where the messages of the merged thread go.
+type MergeStrategy string
+
+const (
+	// MergeAppend adds the messages of the source after the ones of the destination.
+	MergeAppend MergeStrategy = "append"
+	// MergeInterleave orders the exchanges of both threads by the time they started.
+	MergeInterleave MergeStrategy = "interleave"
+)
+
+// MergeResult describes a merge. Added counts the messages of the source that were added to the
+// destination, Dropped the ones that were left out as duplicates: the prefix both threads share and
+// the system prompts where the one of the destination was kept.
+type MergeResult struct {
+	Added   int
+	Dropped int
+}
+
+// ParseMergeStrategy returns the strategy with the given name, append when the name is empty.
+func ParseMergeStrategy(name string) (MergeStrategy, error) {
+	switch strategy := MergeStrategy(strings.ToLower(strings.TrimSpace(name))); strategy {
+	case "":
+		return MergeAppend, nil
+	case MergeAppend, MergeInterleave:
+		return strategy, nil
+	default:
+		return "", fmt.Errorf("unknown merge strategy %q, expected %s or %s", name, MergeAppend, MergeInterleave)
+	}
+}
+
+// MergeThreads adds the messages of src, including the ones rotated out to its archives, to dst and
+// removes src, holding the lock for the whole merge so src is only removed once dst was written.
+// When the current thread is merged the store follows it to dst.
+func (f *FileIO) MergeThreads(dst, src string, strategy MergeStrategy) (MergeResult, error) {
+	dstPath, srcPath, err := f.mergePaths(dst, src)
+	if err != nil {
+		return MergeResult{}, err
+	}
+
+	var result MergeResult
+	err = f.withLock(true, func() error {
+		source, err := f.readArchived(src, srcPath)
+		if err != nil {
+			return err
+		}
+		if len(source) == 0 {
+			return fmt.Errorf("thread %s does not exist", src)
+		}
+
+		target, _, err := f.parseThreadFile(dstPath)
+		if err != nil {
+			return err
+		}
+
+		var merged []types.Message
+		if merged, result, err = mergeMessages(target, source, strategy); err != nil {
+			return err
+		}
+
+		if err := f.write(dst, merged); err != nil {
+			return err
+		}
+
+		return f.clear(src, srcPath)
+	})
+	if err != nil {
+		return MergeResult{}, err
+	}
+
+	if src == f.thread {
+		f.thread = dst
+	}
+
+	return result, nil
+}
+
+func (f *FileIO) mergePaths(dst, src string) (string, string, error) {
+	if dst == src {
+		return "", "", fmt.Errorf("can't merge thread %s into itself", src)
+	}
+
+	dstPath, err := f.getPath(dst)
+	if err != nil {
+		return "", "", err
+	}
+
+	srcPath, err := f.getPath(src)
+	if err != nil {
+		return "", "", err
+	}
+
+	return dstPath, srcPath, nil
+}
+
+// mergeMessages merges the messages of src into dst. The system prompt of dst wins over the one of
+// src, and a prefix that src shares with dst, as it does when it was forked from dst, is kept once.
+func mergeMessages(dst, src []types.Message, strategy MergeStrategy) ([]types.Message, MergeResult, error) {
+	if _, err := ParseMergeStrategy(string(strategy)); err != nil {
+		return nil, MergeResult{}, err
+	}
+
+	var (
+		result MergeResult
+		system []types.Message
+	)
+	if len(dst) > 0 && dst[0].Role == systemRole {
+		system, dst = dst[:1], dst[1:]
+	}
+	if len(src) > 0 && src[0].Role == systemRole {
+		if system == nil && len(dst) == 0 {
+			system = src[:1]
+		} else {
+			result.Dropped++
+		}
+		src = src[1:]
+	}
+
+	shared := 0
+	for shared < len(dst) && shared < len(src) && sameMessage(dst[shared], src[shared]) {
+		shared++
+	}
+	result.Dropped += shared
+
+	var added []types.Message
+	for _, message := range src[shared:] {
+		if message.Role == systemRole && len(system) > 0 && message.Content == system[0].Content {
+			result.Dropped++
+			continue
+		}
+		added = append(added, message)
+	}
+	result.Added = len(added)
+
+	merged := append([]types.Message(nil), system...)
+	if strategy == MergeInterleave {
+		return append(merged, interleave(dst, added)...), result, nil
+	}
+
+	return append(append(merged, dst...), added...), result, nil
+}
+
+// interleave orders the exchanges of both conversations by the time of their first message, so a
+// question stays with its answer. Exchanges without a time keep their place after the ones before.
+func interleave(a, b []types.Message) []types.Message {
+	first, second := exchanges(a), exchanges(b)
+
+	var result []types.Message
+	for len(first) > 0 || len(second) > 0 {
+		if len(first) == 0 || len(second) > 0 && startsBefore(second[0], first[0]) {
+			result, second = append(result, second[0]...), second[1:]
+			continue
+		}
+		result, first = append(result, first[0]...), first[1:]
+	}
+
+	return result
+}
+
+// exchanges splits the conversation into runs of user messages with the messages that followed them.
+func exchanges(messages []types.Message) [][]types.Message {
+	var result [][]types.Message
+	for i, message := range messages {
+		if len(result) == 0 || message.Role == userRole && messages[i-1].Role != userRole {
+			result = append(result, nil)
+		}
+		result[len(result)-1] = append(result[len(result)-1], message)
+	}
+
+	return result
+}
+
+func startsBefore(a, b []types.Message) bool {
+	return !a[0].Timestamp.IsZero() && !b[0].Timestamp.IsZero() && a[0].Timestamp.Before(b[0].Timestamp)
+}
+
+func sameMessage(a, b types.Message) bool {
+	return a.Role == b.Role && a.Content == b.Content
+}
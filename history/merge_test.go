@@ -0,0 +1,128 @@
+package history_test
+
+import (
+	"github.com/kardolus/chatgpt-cli/history"
+	"github.com/kardolus/chatgpt-cli/types"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitMerge(t *testing.T) {
+	spec.Run(t, "Testing the merging of threads", testMerge, spec.Report(report.Terminal{}))
+}
+
+func testMerge(t *testing.T, when spec.G, it spec.S) {
+	var store *history.MemoryStore
+
+	at := func(minute int) time.Time {
+		return time.Date(2024, 6, 1, 9, minute, 0, 0, time.UTC)
+	}
+
+	system := types.Message{Role: "system", Content: "You are a helpful assistant."}
+	shared := []types.Message{
+		system,
+		{Role: "user", Content: "what is Go?", Timestamp: at(0)},
+		{Role: "assistant", Content: "A programming language.", Timestamp: at(1)},
+	}
+
+	write := func(thread string, messages ...types.Message) {
+		store.SetThread(thread)
+		Expect(store.Write(messages)).To(Succeed())
+	}
+
+	contents := func(thread string) []string {
+		messages, err := store.ReadThread(thread)
+		Expect(err).NotTo(HaveOccurred())
+
+		var result []string
+		for _, message := range messages {
+			result = append(result, message.Content)
+		}
+		return result
+	}
+
+	it.Before(func() {
+		RegisterTestingT(t)
+		store = history.NewMemoryStore()
+
+		write("main", append(shared,
+			types.Message{Role: "user", Content: "who made it?", Timestamp: at(10)},
+			types.Message{Role: "assistant", Content: "Google.", Timestamp: at(11)},
+		)...)
+		write("fork", append(shared,
+			types.Message{Role: "user", Content: "is it fast?", Timestamp: at(5)},
+			types.Message{Role: "assistant", Content: "Yes.", Timestamp: at(6)},
+			types.Message{Role: "system", Content: system.Content},
+		)...)
+		store.SetThread("main")
+	})
+
+	it("appends the source after the prefix both threads share and removes it", func() {
+		result, err := store.MergeThreads("main", "fork", history.MergeAppend)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(history.MergeResult{Added: 2, Dropped: 4}))
+
+		Expect(contents("main")).To(Equal([]string{
+			system.Content, "what is Go?", "A programming language.", "who made it?", "Google.", "is it fast?", "Yes.",
+		}))
+		Expect(contents("fork")).To(BeEmpty())
+	})
+
+	it("interleaves the exchanges by their time", func() {
+		_, err := store.MergeThreads("main", "fork", history.MergeInterleave)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(contents("main")).To(Equal([]string{
+			system.Content, "what is Go?", "A programming language.", "is it fast?", "Yes.", "who made it?", "Google.",
+		}))
+	})
+
+	it("keeps the system prompt of the destination", func() {
+		write("other",
+			types.Message{Role: "system", Content: "You answer in French."},
+			types.Message{Role: "user", Content: "bonjour"},
+		)
+
+		_, err := store.MergeThreads("main", "other", history.MergeAppend)
+		Expect(err).NotTo(HaveOccurred())
+
+		messages := contents("main")
+		Expect(messages[0]).To(Equal(system.Content))
+		Expect(messages).NotTo(ContainElement("You answer in French."))
+		Expect(messages[len(messages)-1]).To(Equal("bonjour"))
+	})
+
+	it("follows the current thread into the destination", func() {
+		store.SetThread("fork")
+
+		_, err := store.MergeThreads("new", "fork", history.MergeAppend)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(store.GetThread()).To(Equal("new"))
+		Expect(contents("new")).To(HaveLen(5))
+	})
+
+	it("leaves both threads alone when the merge fails", func() {
+		_, err := store.MergeThreads("main", "main", history.MergeAppend)
+		Expect(err).To(MatchError("can't merge thread main into itself"))
+
+		_, err = store.MergeThreads("main", "missing", history.MergeAppend)
+		Expect(err).To(MatchError("thread missing does not exist"))
+
+		_, err = store.MergeThreads("main", "fork", "zip")
+		Expect(err).To(MatchError(`unknown merge strategy "zip", expected append or interleave`))
+		Expect(contents("fork")).To(HaveLen(6))
+		Expect(contents("main")).To(HaveLen(5))
+	})
+
+	it("parses the strategies", func() {
+		Expect(history.ParseMergeStrategy("")).To(Equal(history.MergeAppend))
+		Expect(history.ParseMergeStrategy("Interleave")).To(Equal(history.MergeInterleave))
+
+		_, err := history.ParseMergeStrategy("zip")
+		Expect(err).To(HaveOccurred())
+	})
+}
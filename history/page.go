@@ -0,0 +1,111 @@
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/kardolus/chatgpt-cli/types"
+	"os"
+)
+
+// ReadPage returns up to limit messages of the thread, starting with the message at offset, in the
+// order they were written, along with the number of messages of the thread. An offset past the end
+// is an empty page. The thread is decoded one message at a time and only the messages of the page
+// are kept, threads that can't be streamed, such as damaged ones, are read as a whole.
+func (f *FileIO) ReadPage(thread string, offset, limit int) ([]types.Message, int, error) {
+	if err := validatePage(offset, limit); err != nil {
+		return nil, 0, err
+	}
+
+	path, err := f.getPath(thread)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var (
+		result   []types.Message
+		total    int
+		streamed bool
+	)
+	err = f.withLock(false, func() error {
+		result, total, streamed, err = f.streamPage(path, offset, limit)
+		return err
+	})
+	if err != nil || streamed {
+		return result, total, err
+	}
+
+	messages, err := f.ReadThread(thread)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return page(messages, offset, limit), len(messages), nil
+}
+
+// streamPage returns the page of the thread file, or false when the file can't be streamed.
+func (f *FileIO) streamPage(path string, offset, limit int) ([]types.Message, int, bool, error) {
+	file, err := f.openThread(path)
+	if os.IsNotExist(err) {
+		return nil, 0, true, nil
+	}
+	if err != nil {
+		return nil, 0, false, err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	if !openMessages(decoder) {
+		return nil, 0, false, nil
+	}
+
+	var (
+		result []types.Message
+		total  int
+	)
+	for ; decoder.More(); total++ {
+		// the messages outside of the page are only skipped over
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			return nil, 0, false, nil
+		}
+
+		if total < offset || total-offset >= limit {
+			continue
+		}
+
+		var stored storedMessage
+		if err := json.Unmarshal(raw, &stored); err != nil {
+			return nil, 0, false, nil
+		}
+		result = append(result, stored.toMessage())
+	}
+
+	// a truncated file ends before its messages do
+	if _, err := decoder.Token(); err != nil {
+		return nil, 0, false, nil
+	}
+
+	return result, total, true, nil
+}
+
+// page returns the messages of the page, an offset past the end is an empty page.
+func page(messages []types.Message, offset, limit int) []types.Message {
+	if offset >= len(messages) {
+		return nil
+	}
+
+	end := len(messages)
+	if end-offset > limit {
+		end = offset + limit
+	}
+
+	return append([]types.Message(nil), messages[offset:end]...)
+}
+
+func validatePage(offset, limit int) error {
+	if offset < 0 || limit < 0 {
+		return errors.New("invalid page, the offset and the limit can't be negative")
+	}
+
+	return nil
+}
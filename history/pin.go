@@ -0,0 +1,64 @@
+package history
+
+import (
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/types"
+)
+
+// SetPinned pins or unpins the messages at the given positions, pinned messages are never trimmed
+// from the conversation, pruned or rotated out to an archive. System prompts are always kept and
+// can't be pinned.
+func (f *FileIO) SetPinned(thread string, indices []int, pinned bool) error {
+	path, err := f.getPath(thread)
+	if err != nil {
+		return err
+	}
+
+	return f.withLock(true, func() error {
+		messages, _, err := f.parseThreadFile(path)
+		if err != nil {
+			return err
+		}
+
+		if messages, err = pinMessages(messages, indices, pinned); err != nil {
+			return err
+		}
+
+		return f.write(thread, messages)
+	})
+}
+
+// pinMessages returns a copy of the messages with the ones at the given positions pinned or
+// unpinned.
+func pinMessages(messages []types.Message, indices []int, pinned bool) ([]types.Message, error) {
+	result := append([]types.Message(nil), messages...)
+
+	for _, index := range indices {
+		if index < 0 || index >= len(result) {
+			return nil, fmt.Errorf("message %d does not exist, the thread has %d messages", index, len(result))
+		}
+
+		if result[index].Role == systemRole {
+			return nil, fmt.Errorf("message %d is a system prompt, which is always kept", index)
+		}
+
+		result[index].Pinned = pinned
+	}
+
+	return result, nil
+}
+
+// unpinned returns the messages that are not pinned, and the pinned ones separately.
+func unpinned(messages []types.Message) ([]types.Message, []types.Message) {
+	var rest, pinned []types.Message
+
+	for _, message := range messages {
+		if message.Pinned {
+			pinned = append(pinned, message)
+		} else {
+			rest = append(rest, message)
+		}
+	}
+
+	return rest, pinned
+}
@@ -0,0 +1,76 @@
+package history_test
+
+import (
+	"github.com/kardolus/chatgpt-cli/history"
+	"github.com/kardolus/chatgpt-cli/types"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitPin(t *testing.T) {
+	spec.Run(t, "Testing pinned messages", testPin, spec.Report(report.Terminal{}))
+}
+
+func testPin(t *testing.T, when spec.G, it spec.S) {
+	var store *history.MemoryStore
+
+	old := time.Now().Add(-48 * time.Hour)
+
+	conversation := []types.Message{
+		{Role: "system", Content: "You are a helpful assistant.", Timestamp: old},
+		{Role: "user", Content: "we are on Go 1.21", Timestamp: old},
+		{Role: "assistant", Content: "Noted.", Timestamp: old},
+		{Role: "user", Content: "old question", Timestamp: old},
+		{Role: "assistant", Content: "old answer", Timestamp: old},
+		{Role: "user", Content: "recent question", Timestamp: time.Now()},
+	}
+
+	it.Before(func() {
+		RegisterTestingT(t)
+
+		store = history.NewMemoryStore()
+		store.SetThread("default")
+		Expect(store.Write(conversation)).To(Succeed())
+	})
+
+	it("pins and unpins messages", func() {
+		Expect(store.SetPinned("default", []int{1, 2}, true)).To(Succeed())
+		Expect(store.SetPinned("default", []int{2}, false)).To(Succeed())
+
+		messages, err := store.Read()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(messages[1].Pinned).To(BeTrue())
+		Expect(messages[2].Pinned).To(BeFalse())
+	})
+
+	it("refuses to pin the system prompt or messages that don't exist", func() {
+		Expect(store.SetPinned("default", []int{0}, true)).To(MatchError("message 0 is a system prompt, which is always kept"))
+		Expect(store.SetPinned("default", []int{1, 6}, true)).To(MatchError("message 6 does not exist, the thread has 6 messages"))
+
+		messages, err := store.Read()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(messages[1].Pinned).To(BeFalse())
+	})
+
+	it("never prunes pinned messages", func() {
+		Expect(store.SetPinned("default", []int{1}, true)).To(Succeed())
+
+		result, err := store.Prune(24*time.Hour, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal([]history.PruneResult{{Thread: "default", Removed: 3, Kept: 3}}))
+
+		messages, err := store.Read()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(messages).To(HaveLen(3))
+		Expect(messages[1].Content).To(Equal("we are on Go 1.21"))
+		Expect(messages[2].Content).To(Equal("recent question"))
+	})
+
+	it("marks pinned messages when they are formatted", func() {
+		Expect(history.Format([]types.Message{{Role: "user", Content: "we are on Go 1.21", Pinned: true}})).To(ContainSubstring("👤 📌:"))
+	})
+}
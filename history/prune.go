@@ -0,0 +1,169 @@
+package history
+
+import (
+	"github.com/kardolus/chatgpt-cli/types"
+	"os"
+	"time"
+)
+
+// PruneResult reports how many messages pruning removed from a thread, or would remove on a dry
+// run, and how many messages the thread keeps.
+type PruneResult struct {
+	Thread  string
+	Removed int
+	Kept    int
+}
+
+// Prune removes the messages older than olderThan from every thread, along with the archives they
+// were rotated out to. The system prompt a thread starts with is kept, and a question is only
+// removed along with its answer. Messages from older histories have no time of their own and are as
+// old as the file they are in. With dryRun nothing is removed. It returns the threads that lose
+// messages, in alphabetical order.
+func (f *FileIO) Prune(olderThan time.Duration, dryRun bool) ([]PruneResult, error) {
+	cutoff := time.Now().Add(-olderThan)
+
+	// without a history directory there is nothing to prune
+	threads, err := f.ListThreads()
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var result []PruneResult
+	for _, thread := range threads {
+		path, err := f.getPath(thread.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		var pruned PruneResult
+		err = f.withLock(!dryRun, func() error {
+			pruned, err = f.pruneThread(thread.Name, path, cutoff, dryRun)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if pruned.Removed > 0 {
+			result = append(result, pruned)
+		}
+	}
+
+	return result, nil
+}
+
+func (f *FileIO) pruneThread(thread, path string, cutoff time.Time, dryRun bool) (PruneResult, error) {
+	result := PruneResult{Thread: thread}
+
+	archives, err := f.listArchives(thread)
+	if err != nil {
+		return result, err
+	}
+
+	// the archives are older than the thread, the thread is only pruned once they are all gone
+	for _, archive := range archives {
+		archivePath := f.archivePath(thread, archive)
+
+		messages, _, err := f.parseFile(archivePath)
+		if err != nil {
+			return result, err
+		}
+
+		kept := pruneMessages(messages, cutoff, modTime(archivePath), false)
+		result.Removed += len(messages) - len(kept)
+
+		if !dryRun && len(kept) == 0 {
+			if err := os.Remove(archivePath); err != nil {
+				return result, err
+			}
+		}
+
+		if len(kept) > 0 {
+			result.Kept = f.countMessages(path)
+			if !dryRun && len(kept) < len(messages) {
+				return result, f.writeMessages(archivePath, kept)
+			}
+			return result, nil
+		}
+	}
+
+	messages, _, err := f.parseThreadFile(path)
+	if err != nil {
+		return result, err
+	}
+
+	kept := pruneMessages(messages, cutoff, modTime(path), true)
+	result.Removed += len(messages) - len(kept)
+	result.Kept = len(kept)
+
+	if dryRun || len(kept) == len(messages) {
+		return result, nil
+	}
+
+	if len(kept) == 0 {
+		return result, f.clear(thread, path)
+	}
+
+	return result, f.write(thread, kept)
+}
+
+// pruneMessages returns the messages without the exchanges at the start that are older than cutoff.
+// An exchange is a question with everything up to the next question, it is only removed when all
+// of its messages are old. Messages without a time of their own are as old as fallback. With
+// keepSystem the system prompt the messages start with is kept. Pinned messages are always kept.
+func pruneMessages(messages []types.Message, cutoff, fallback time.Time, keepSystem bool) []types.Message {
+	start := 0
+	if keepSystem && len(messages) > 0 && messages[0].Role == systemRole {
+		start = 1
+	}
+
+	end := start
+	for end < len(messages) {
+		next := end + 1
+		for next < len(messages) && messages[next].Role != userRole {
+			next++
+		}
+
+		if !olderThan(messages[end:next], cutoff, fallback) {
+			break
+		}
+		end = next
+	}
+
+	if end == start {
+		return messages
+	}
+
+	// pinned messages are never pruned, they stay right after the system prompt
+	_, pinned := unpinned(messages[start:end])
+	result := append(append([]types.Message(nil), messages[:start]...), pinned...)
+
+	return append(result, messages[end:]...)
+}
+
+func olderThan(messages []types.Message, cutoff, fallback time.Time) bool {
+	for _, message := range messages {
+		timestamp := message.Timestamp
+		if timestamp.IsZero() {
+			timestamp = fallback
+		}
+
+		if !timestamp.Before(cutoff) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func modTime(fileName string) time.Time {
+	fileInfo, err := os.Stat(fileName)
+	if err != nil {
+		return time.Now()
+	}
+
+	return fileInfo.ModTime()
+}
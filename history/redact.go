@@ -0,0 +1,125 @@
+package history
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// CustomSecret is the type of the secrets matched by the patterns of the user.
+	CustomSecret = "custom"
+
+	redactedPrefix = "[REDACTED"
+)
+
+// Redaction is a secret that was replaced with a placeholder. The hash tells secrets of the same
+// type apart without revealing them, the same secret always has the same hash.
+type Redaction struct {
+	Type string
+	Hash string
+}
+
+// Placeholder returns what the secret is replaced with.
+func (r Redaction) Placeholder() string {
+	return redactedPrefix + " " + r.Type + " " + r.Hash + "]"
+}
+
+type secretPattern struct {
+	kind   string
+	regexp *regexp.Regexp
+}
+
+// secretPatterns are the shapes of common secrets. Patterns with a group only redact what the
+// group matched, so "password=hunter2" keeps the name of the value and loses the value.
+var secretPatterns = []secretPattern{
+	{"private-key", regexp.MustCompile(`-----BEGIN [A-Z0-9 ]+-----[\s\S]*?-----END [A-Z0-9 ]+-----`)},
+	{"aws-access-key", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"aws-secret-key", regexp.MustCompile(`(?i)aws_?secret_?access_?key["']?\s*[=:]\s*["']?([A-Za-z0-9/+]{40})\b`)},
+	{"openai-key", regexp.MustCompile(`\bsk-[A-Za-z0-9_-]{20,}`)},
+	{"github-token", regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}\b`)},
+	{"bearer-token", regexp.MustCompile(`(?i)\bbearer\s+([A-Za-z0-9\-._~+/]{16,}=*)`)},
+	{"password", regexp.MustCompile(`(?i)\b(?:password|passwd|pwd)["']?\s*[=:]\s*["']?([^\s"'&;,]+)`)},
+	{"api-key", regexp.MustCompile(`(?i)\b(?:api[_-]?key|secret|access[_-]?token)["']?\s*[=:]\s*["']?([^\s"'&;,]{8,})`)},
+}
+
+// Redactor replaces the secrets in messages with placeholders, before they are stored.
+type Redactor struct {
+	patterns []secretPattern
+}
+
+// NewRedactor returns a redactor for the common secret shapes and the given regular expressions.
+// Like the built-in patterns, a custom pattern with a group only redacts what the group matched.
+func NewRedactor(custom []string) (*Redactor, error) {
+	patterns := append([]secretPattern(nil), secretPatterns...)
+
+	for _, pattern := range custom {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %w", pattern, err)
+		}
+
+		patterns = append(patterns, secretPattern{kind: CustomSecret, regexp: re})
+	}
+
+	return &Redactor{patterns: patterns}, nil
+}
+
+// Redact returns the text with the secrets replaced by their placeholders, and the secrets that
+// were replaced in the order they were found. Placeholders are left alone, so redacting twice
+// changes nothing.
+func (r *Redactor) Redact(text string) (string, []Redaction) {
+	var result []Redaction
+
+	for _, pattern := range r.patterns {
+		text = pattern.replace(text, func(secret string) (string, bool) {
+			sum := sha256.Sum256([]byte(secret))
+			redaction := Redaction{Type: pattern.kind, Hash: hex.EncodeToString(sum[:])[:8]}
+
+			result = append(result, redaction)
+			return redaction.Placeholder(), true
+		})
+	}
+
+	return text, result
+}
+
+// replace replaces what the pattern matches in the text, or only what its group matched, with what
+// fn returns for it, unless fn returns false. Empty matches and the placeholders of earlier
+// redactions are left alone.
+func (p secretPattern) replace(text string, fn func(string) (string, bool)) string {
+	var (
+		builder strings.Builder
+		last    int
+	)
+
+	for _, match := range p.regexp.FindAllStringSubmatchIndex(text, -1) {
+		start, end := match[0], match[1]
+		if len(match) >= 4 && match[2] >= 0 {
+			start, end = match[2], match[3]
+		}
+
+		secret := text[start:end]
+		if start == end || strings.HasPrefix(secret, redactedPrefix) {
+			continue
+		}
+
+		replacement, ok := fn(secret)
+		if !ok {
+			continue
+		}
+
+		builder.WriteString(text[last:start])
+		builder.WriteString(replacement)
+		last = end
+	}
+
+	if last == 0 {
+		return text
+	}
+
+	builder.WriteString(text[last:])
+	return builder.String()
+}
@@ -0,0 +1,475 @@
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/types"
+	"github.com/kardolus/chatgpt-cli/utils"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// remoteAttempts is how often an update is tried before giving up on a thread that keeps being
+	// changed by others in between reading and writing it.
+	remoteAttempts = 3
+	// etagsFile keeps the ETag of every thread as it was last read from or written to the remote.
+	etagsFile = ".etags"
+	// remoteCacheDir is where the copies of the remote threads are kept, under the history directory.
+	remoteCacheDir = ".remote"
+)
+
+var (
+	// ErrRemoteConflict is returned when a thread was changed on the remote since it was last read, so
+	// writing it would overwrite the changes of someone else.
+	ErrRemoteConflict    = errors.New("the thread was changed on the remote since it was last read")
+	ErrRemoteUnavailable = errors.New("the remote history is unavailable")
+	ErrRemoteNotFound    = errors.New("the remote thread does not exist")
+	ErrNotModified       = errors.New("the remote thread was not modified")
+)
+
+// Remote is an object store the threads are synced with. Every object has an ETag that changes
+// whenever the object does.
+type Remote interface {
+	// Get returns the object and its ETag. It returns ErrNotModified when the object still has the
+	// given ETag, and ErrRemoteNotFound when there is no such object.
+	Get(key, etag string) ([]byte, string, error)
+	// Put writes the object when it still has the given ETag, or when it doesn't exist yet when the
+	// ETag is empty, and returns its new ETag. It returns ErrRemoteConflict when the object changed.
+	Put(key string, data []byte, etag string) (string, error)
+	// Delete removes the object, removing one that does not exist is not an error.
+	Delete(key string) error
+}
+
+// Ensure RemoteStore implements the HistoryStore interface
+var _ HistoryStore = &RemoteStore{}
+
+// RemoteStore keeps the history on a remote, such as an S3 bucket, so it can be shared between
+// machines. A thread is read from the remote whenever it is read and written back after every
+// change; the copy kept in the local cache is used when the remote can't be reached. Writes are
+// conditional on the ETag of the last read, so a thread that was changed elsewhere in the meantime
+// is reported with ErrRemoteConflict instead of overwritten. Listing and searching the threads,
+// as well as their titles and summaries, only use the cache on this machine.
+type RemoteStore struct {
+	*FileIO
+	remote Remote
+	prefix string
+}
+
+// NewRemoteStore returns a store that syncs the threads with the remote, under the given key
+// prefix, and caches them with the cache store. The cache should not rotate or encrypt the threads.
+func NewRemoteStore(remote Remote, prefix string, cache *FileIO) *RemoteStore {
+	return &RemoteStore{
+		FileIO: cache,
+		remote: remote,
+		prefix: strings.Trim(prefix, "/"),
+	}
+}
+
+// IsRemoteLocator reports whether the history backend is the locator of a remote rather than the
+// name of a local backend.
+func IsRemoteLocator(backend string) bool {
+	return strings.Contains(backend, "://")
+}
+
+// OpenRemote opens the remote the locator points to, s3://bucket/prefix, and caches its threads in
+// a directory of their own under historyDir.
+func OpenRemote(locator, historyDir string, lockTimeout time.Duration) (*RemoteStore, error) {
+	u, err := url.Parse(locator)
+	if err != nil {
+		return nil, fmt.Errorf("invalid history locator %q: %w", locator, err)
+	}
+
+	var remote Remote
+	switch u.Scheme {
+	case S3Scheme:
+		if remote, err = NewS3(u.Host); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported history locator %q, expected %s://bucket/prefix", locator, S3Scheme)
+	}
+
+	prefix := strings.Trim(u.Path, "/")
+
+	cache, err := NewInDirectory(filepath.Join(historyDir, remoteCacheDir, u.Scheme, u.Host, filepath.FromSlash(prefix)))
+	if err != nil {
+		return nil, err
+	}
+
+	return NewRemoteStore(remote, prefix, cache.WithLockTimeout(lockTimeout)), nil
+}
+
+// Read returns the messages of the current thread.
+func (r *RemoteStore) Read() ([]types.Message, error) {
+	return r.ReadThread(r.thread)
+}
+
+// ReadThread returns the messages of the thread on the remote, or the cached ones when the remote
+// can't be reached.
+func (r *RemoteStore) ReadThread(thread string) ([]types.Message, error) {
+	if _, err := r.pull(thread); err != nil && !errors.Is(err, ErrRemoteUnavailable) {
+		return nil, err
+	}
+
+	return r.FileIO.ReadThread(thread)
+}
+
+// ReadPage returns a page of the thread on the remote, or of the cached one when the remote can't
+// be reached.
+func (r *RemoteStore) ReadPage(thread string, offset, limit int) ([]types.Message, int, error) {
+	if _, err := r.pull(thread); err != nil && !errors.Is(err, ErrRemoteUnavailable) {
+		return nil, 0, err
+	}
+
+	return r.FileIO.ReadPage(thread, offset, limit)
+}
+
+// Write replaces the messages of the current thread, unless it was changed on the remote since it
+// was last read.
+func (r *RemoteStore) Write(messages []types.Message) error {
+	etag, err := r.etag(r.thread)
+	if err != nil {
+		return err
+	}
+
+	return r.push(r.thread, messages, etag)
+}
+
+// Update reads the current thread from the remote, passes its messages to fn and writes what fn
+// returns. When the thread is changed elsewhere in between, fn is called again with the new messages.
+func (r *RemoteStore) Update(fn func([]types.Message) []types.Message) error {
+	for attempt := 0; attempt < remoteAttempts; attempt++ {
+		etag, err := r.pull(r.thread)
+		if err != nil {
+			return err
+		}
+
+		messages, err := r.FileIO.ReadThread(r.thread)
+		if err != nil {
+			return err
+		}
+
+		if err := r.push(r.thread, fn(messages), etag); !errors.Is(err, ErrRemoteConflict) {
+			return err
+		}
+	}
+
+	return fmt.Errorf("%w, it kept changing while it was written", ErrRemoteConflict)
+}
+
+// Clear removes the messages of the thread from the remote and the cache.
+func (r *RemoteStore) Clear(thread string) error {
+	if err := r.remove(thread); err != nil {
+		return err
+	}
+
+	return r.FileIO.Clear(thread)
+}
+
+// DeleteThread removes the thread from the remote and the cache. When the current thread is deleted
+// the store falls back to the default thread.
+func (r *RemoteStore) DeleteThread(thread string) error {
+	if err := r.remove(thread); err != nil {
+		return err
+	}
+
+	return r.FileIO.DeleteThread(thread)
+}
+
+// RenameThread renames the thread from to to, which must not exist yet on the remote either.
+func (r *RemoteStore) RenameThread(from, to string) error {
+	if _, err := r.pull(from); err != nil {
+		return err
+	}
+	if _, err := r.pull(to); err != nil {
+		return err
+	}
+
+	messages, err := r.FileIO.ReadThread(from)
+	if err != nil {
+		return err
+	}
+	if messages == nil {
+		return fmt.Errorf("thread %s does not exist", from)
+	}
+
+	if existing, err := r.FileIO.ReadThread(to); err != nil || existing != nil {
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("thread %s already exists", to)
+	}
+
+	data, err := encodeMessages(messages)
+	if err != nil {
+		return err
+	}
+
+	etag, err := r.remote.Put(r.key(to), data, "")
+	if errors.Is(err, ErrRemoteConflict) {
+		return fmt.Errorf("thread %s already exists", to)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := r.remove(from); err != nil {
+		return err
+	}
+
+	if err := r.FileIO.RenameThread(from, to); err != nil {
+		return err
+	}
+
+	return r.setETag(to, etag)
+}
+
+// MergeThreads merges src into dst, see FileIO.MergeThreads, and writes the result to the remote
+// before src is removed from it.
+func (r *RemoteStore) MergeThreads(dst, src string, strategy MergeStrategy) (MergeResult, error) {
+	if _, err := r.pull(src); err != nil {
+		return MergeResult{}, err
+	}
+
+	etag, err := r.pull(dst)
+	if err != nil {
+		return MergeResult{}, err
+	}
+
+	result, err := r.FileIO.MergeThreads(dst, src, strategy)
+	if err != nil {
+		return result, err
+	}
+
+	if err := r.sync(dst, etag); err != nil {
+		return result, err
+	}
+
+	return result, r.remove(src)
+}
+
+// DeleteMessages removes the messages at the given positions from the thread, see
+// FileIO.DeleteMessages, and writes the thread back to the remote.
+func (r *RemoteStore) DeleteMessages(thread string, indices []int, pairs bool) (DeleteResult, error) {
+	etag, err := r.pull(thread)
+	if err != nil {
+		return DeleteResult{}, err
+	}
+
+	result, err := r.FileIO.DeleteMessages(thread, indices, pairs)
+	if err != nil {
+		return result, err
+	}
+
+	return result, r.sync(thread, etag)
+}
+
+// SetPinned pins or unpins the messages at the given positions and writes the thread back to the
+// remote.
+func (r *RemoteStore) SetPinned(thread string, indices []int, pinned bool) error {
+	etag, err := r.pull(thread)
+	if err != nil {
+		return err
+	}
+
+	if err := r.FileIO.SetPinned(thread, indices, pinned); err != nil {
+		return err
+	}
+
+	return r.sync(thread, etag)
+}
+
+// Prune removes the old messages from the cached threads, see FileIO.Prune, and writes the threads
+// that lost messages back to the remote.
+func (r *RemoteStore) Prune(olderThan time.Duration, dryRun bool) ([]PruneResult, error) {
+	threads, err := r.FileIO.ListThreads()
+	if err != nil {
+		return nil, err
+	}
+
+	etags := make(map[string]string)
+	for _, thread := range threads {
+		if etags[thread.Name], err = r.pull(thread.Name); err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := r.FileIO.Prune(olderThan, dryRun)
+	if err != nil || dryRun {
+		return result, err
+	}
+
+	for _, pruned := range result {
+		if err := r.sync(pruned.Thread, etags[pruned.Thread]); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// pull replaces the cached thread with the one on the remote when it changed, and returns the ETag
+// of the thread, which is empty when the remote doesn't have it.
+func (r *RemoteStore) pull(thread string) (string, error) {
+	path, err := r.getPath(thread)
+	if err != nil {
+		return "", err
+	}
+
+	etag, err := r.etag(thread)
+	if err != nil {
+		return "", err
+	}
+
+	// without a cached copy there is nothing to compare the remote one with
+	cached := etag
+	if _, err := os.Stat(path); err != nil {
+		cached = ""
+	}
+
+	data, latest, err := r.remote.Get(r.key(thread), cached)
+	switch {
+	case errors.Is(err, ErrNotModified):
+		return etag, nil
+	case errors.Is(err, ErrRemoteNotFound):
+		// a thread that was synced before was deleted on another machine
+		if etag != "" {
+			if err := r.FileIO.Clear(thread); err != nil {
+				return "", err
+			}
+			return "", r.setETag(thread, "")
+		}
+		return "", nil
+	case err != nil:
+		return "", err
+	}
+
+	stored, _, err := decodeMessages(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the remote thread %s: %w", thread, err)
+	}
+
+	return latest, r.withLock(true, func() error {
+		if err := r.write(thread, fromStored(stored)); err != nil {
+			return err
+		}
+		return r.updateETags(thread, latest)
+	})
+}
+
+// push writes the messages to the remote when the thread still has the given ETag, and to the cache
+// once the remote accepted them.
+func (r *RemoteStore) push(thread string, messages []types.Message, etag string) error {
+	data, err := encodeMessages(messages)
+	if err != nil {
+		return err
+	}
+
+	latest, err := r.remote.Put(r.key(thread), data, etag)
+	if err != nil {
+		return err
+	}
+
+	return r.withLock(true, func() error {
+		if err := r.write(thread, messages); err != nil {
+			return err
+		}
+		return r.updateETags(thread, latest)
+	})
+}
+
+// sync writes the cached thread to the remote after it was changed in the cache, or removes it from
+// the remote when the change removed the thread.
+func (r *RemoteStore) sync(thread, etag string) error {
+	messages, err := r.FileIO.ReadThread(thread)
+	if err != nil {
+		return err
+	}
+
+	if messages == nil {
+		return r.remove(thread)
+	}
+
+	return r.push(thread, messages, etag)
+}
+
+func (r *RemoteStore) remove(thread string) error {
+	if err := ValidateThread(thread); err != nil {
+		return err
+	}
+
+	if err := r.remote.Delete(r.key(thread)); err != nil {
+		return err
+	}
+
+	return r.setETag(thread, "")
+}
+
+func (r *RemoteStore) key(thread string) string {
+	if r.prefix == "" {
+		return thread + jsonExtension
+	}
+
+	return r.prefix + "/" + thread + jsonExtension
+}
+
+func (r *RemoteStore) etag(thread string) (string, error) {
+	var etags map[string]string
+	err := r.withLock(false, func() error {
+		var err error
+		etags, err = r.readETags()
+		return err
+	})
+
+	return etags[thread], err
+}
+
+func (r *RemoteStore) setETag(thread, etag string) error {
+	return r.withLock(true, func() error {
+		return r.updateETags(thread, etag)
+	})
+}
+
+// updateETags changes the ETag of the thread, the caller holds the lock.
+func (r *RemoteStore) updateETags(thread, etag string) error {
+	etags, err := r.readETags()
+	if err != nil {
+		return err
+	}
+
+	if etag == "" {
+		delete(etags, thread)
+	} else {
+		etags[thread] = etag
+	}
+
+	data, err := json.Marshal(etags)
+	if err != nil {
+		return err
+	}
+
+	return utils.WriteFileAtomic(filepath.Join(r.historyDir, etagsFile), data, filePerm)
+}
+
+func (r *RemoteStore) readETags() (map[string]string, error) {
+	etags := make(map[string]string)
+
+	data, err := os.ReadFile(filepath.Join(r.historyDir, etagsFile))
+	if os.IsNotExist(err) {
+		return etags, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &etags); err != nil {
+		return nil, fmt.Errorf("failed to read the ETags of the remote history: %w", err)
+	}
+
+	return etags, nil
+}
@@ -0,0 +1,233 @@
+package history_test
+
+import (
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/history"
+	"github.com/kardolus/chatgpt-cli/types"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitRemote(t *testing.T) {
+	spec.Run(t, "Testing the remote history", testRemote, spec.Report(report.Terminal{}))
+}
+
+// fakeS3 keeps objects the way S3 does, with an ETag per object and conditional requests.
+type fakeS3 struct {
+	mu             sync.Mutex
+	objects        map[string][]byte
+	etags          map[string]string
+	writes         int
+	authorizations []string
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.authorizations = append(s.authorizations, r.Header.Get("Authorization"))
+	key := r.URL.Path
+
+	switch r.Method {
+	case http.MethodGet:
+		data, ok := s.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("If-None-Match") == s.etags[key] {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", s.etags[key])
+		_, _ = w.Write(data)
+	case http.MethodPut:
+		_, exists := s.objects[key]
+		if match := r.Header.Get("If-Match"); match != "" && match != s.etags[key] ||
+			r.Header.Get("If-None-Match") == "*" && exists {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		s.writes++
+		s.objects[key], s.etags[key] = data, fmt.Sprintf(`"etag-%d"`, s.writes)
+		w.Header().Set("ETag", s.etags[key])
+	case http.MethodDelete:
+		delete(s.objects, key)
+		delete(s.etags, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func testRemote(t *testing.T, when spec.G, it spec.S) {
+	const locator = "s3://team-bucket/chat/history"
+
+	var (
+		fake    *fakeS3
+		server  *httptest.Server
+		laptop  *history.RemoteStore
+		desktop *history.RemoteStore
+	)
+
+	question := types.Message{Role: "user", Content: "what is the capital of France?"}
+	answer := types.Message{Role: "assistant", Content: "Paris"}
+	other := types.Message{Role: "user", Content: "and of Spain?"}
+
+	open := func() *history.RemoteStore {
+		store, err := history.OpenRemote(locator, t.TempDir(), time.Second)
+		Expect(err).NotTo(HaveOccurred())
+		store.SetThread("default")
+		return store
+	}
+
+	appending := func(messages ...types.Message) func([]types.Message) []types.Message {
+		return func(stored []types.Message) []types.Message {
+			return append(stored, messages...)
+		}
+	}
+
+	it.Before(func() {
+		RegisterTestingT(t)
+
+		fake = &fakeS3{objects: map[string][]byte{}, etags: map[string]string{}}
+		server = httptest.NewServer(fake)
+
+		t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+		t.Setenv("AWS_SECRET_ACCESS_KEY", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY")
+		t.Setenv("AWS_SESSION_TOKEN", "")
+		t.Setenv("AWS_REGION", "eu-west-1")
+		t.Setenv("AWS_ENDPOINT_URL", server.URL)
+
+		laptop, desktop = open(), open()
+	})
+
+	it.After(func() {
+		server.Close()
+	})
+
+	it("shares the threads between machines", func() {
+		Expect(laptop.Update(appending(question, answer))).To(Succeed())
+		Expect(fake.objects).To(HaveKey("/team-bucket/chat/history/default.json"))
+
+		messages, err := desktop.Read()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(messages).To(HaveLen(2))
+		Expect(messages[1].Content).To(Equal("Paris"))
+
+		Expect(desktop.Update(appending(other))).To(Succeed())
+
+		messages, err = laptop.Read()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(messages).To(HaveLen(3))
+	})
+
+	it("only downloads a thread when it changed", func() {
+		Expect(laptop.Update(appending(question))).To(Succeed())
+		_, err := laptop.Read()
+		Expect(err).NotTo(HaveOccurred())
+
+		fake.objects["/team-bucket/chat/history/default.json"] = []byte("not the cached thread")
+
+		messages, err := laptop.Read()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(messages).To(HaveLen(1))
+	})
+
+	it("updates a thread that was changed elsewhere with the new messages", func() {
+		Expect(laptop.Update(appending(question))).To(Succeed())
+		Expect(desktop.Update(appending(answer))).To(Succeed())
+
+		// the laptop last saw the thread with the question only
+		Expect(laptop.Update(appending(other))).To(Succeed())
+
+		messages, err := desktop.Read()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(messages).To(HaveLen(3))
+		Expect(messages[2].Content).To(Equal(other.Content))
+	})
+
+	it("refuses to replace a thread that was changed since it was read", func() {
+		Expect(laptop.Update(appending(question))).To(Succeed())
+		_, err := desktop.Read()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(laptop.Update(appending(answer))).To(Succeed())
+
+		Expect(desktop.Write([]types.Message{other})).To(MatchError(history.ErrRemoteConflict))
+
+		messages, err := laptop.Read()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(messages).To(HaveLen(2))
+
+		// once the change was read the thread can be replaced
+		_, err = desktop.Read()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(desktop.Write([]types.Message{other})).To(Succeed())
+	})
+
+	it("reads the cached threads when the remote is unavailable", func() {
+		Expect(laptop.Update(appending(question, answer))).To(Succeed())
+		server.Close()
+
+		messages, err := laptop.Read()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(messages).To(HaveLen(2))
+
+		Expect(laptop.Update(appending(other))).To(MatchError(history.ErrRemoteUnavailable))
+	})
+
+	it("deletes, renames and edits threads on the remote", func() {
+		Expect(laptop.Update(appending(question, answer))).To(Succeed())
+		_, err := desktop.Read()
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(laptop.RenameThread("default", "geography")).To(Succeed())
+		Expect(fake.objects).NotTo(HaveKey("/team-bucket/chat/history/default.json"))
+
+		messages, err := desktop.Read()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(messages).To(BeEmpty())
+
+		Expect(laptop.SetPinned("geography", []int{0}, true)).To(Succeed())
+		messages, err = desktop.ReadThread("geography")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(messages[0].Pinned).To(BeTrue())
+
+		Expect(desktop.DeleteThread("geography")).To(Succeed())
+		Expect(fake.objects).To(BeEmpty())
+
+		messages, err = laptop.ReadThread("geography")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(messages).To(BeEmpty())
+	})
+
+	it("signs the requests with the credentials of the environment", func() {
+		_, err := laptop.Read()
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(fake.authorizations).NotTo(BeEmpty())
+		authorization := fake.authorizations[0]
+		Expect(authorization).To(HavePrefix("AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/"))
+		Expect(authorization).To(ContainSubstring("/eu-west-1/s3/aws4_request, SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature="))
+		Expect(authorization).NotTo(ContainSubstring("wJalrXUtnFEMI"))
+	})
+
+	it("refuses locators and environments it can't use", func() {
+		_, err := history.OpenRemote("gist://0123456789", t.TempDir(), time.Second)
+		Expect(err).To(MatchError(ContainSubstring(`unsupported history locator "gist://0123456789"`)))
+
+		t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+		_, err = history.OpenRemote(locator, t.TempDir(), time.Second)
+		Expect(err).To(MatchError(ContainSubstring("AWS_SECRET_ACCESS_KEY")))
+
+		Expect(history.IsRemoteLocator(locator)).To(BeTrue())
+		Expect(history.IsRemoteLocator(history.BackendSQLite)).To(BeFalse())
+	})
+}
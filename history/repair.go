@@ -0,0 +1,311 @@
+package history
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/types"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// corruptExtension is added, along with the time, to the name of the damaged files a repair moves
+// aside, so they are kept for inspection but never read as threads again.
+const corruptExtension = ".corrupt-"
+
+var (
+	errInvalidUTF8 = errors.New("the history file is not valid UTF-8")
+	roleKey        = regexp.MustCompile(`"role"\s*:`)
+)
+
+// RepairResult describes a damaged thread, or one of its archives, and what a repair recovered of
+// it. Lost is the number of messages the damaged file held, as far as it can still tell, that
+// couldn't be recovered. Moved is where the damaged file was moved to.
+type RepairResult struct {
+	Thread     string
+	Archive    int
+	Recovered  int
+	Lost       int
+	FromBackup bool
+	Moved      string
+}
+
+// WithRecovery repairs the threads that are damaged, as Repair does, when they are read instead of
+// failing the read, and reports every repair to report.
+func (f *FileIO) WithRecovery(report func(RepairResult)) *FileIO {
+	f.recovery = report
+	return f
+}
+
+// Repair checks every thread and its archives, and repairs the ones that can't be read. The
+// messages that can still be decoded are kept, or those of the backup of the thread when it holds
+// more of them, and the damaged file is moved aside next to the thread. Characters that aren't
+// valid UTF-8 are replaced. It returns the files that were repaired, threads that can't be read
+// for another reason, such as a missing passphrase, are an error.
+func (f *FileIO) Repair() ([]RepairResult, error) {
+	return f.repair(false)
+}
+
+// Scan checks every thread and its archives as Repair does, but only reports what a repair would
+// recover of the damaged ones, without writing anything. The Moved of its results is empty.
+func (f *FileIO) Scan() ([]RepairResult, error) {
+	return f.repair(true)
+}
+
+func (f *FileIO) repair(dryRun bool) ([]RepairResult, error) {
+	var result []RepairResult
+
+	action := "repair"
+	if dryRun {
+		action = "scan"
+	}
+
+	err := f.withLock(!dryRun, func() error {
+		entries, err := os.ReadDir(f.historyDir)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() || filepath.Ext(entry.Name()) != jsonExtension || entry.Name() == indexFile {
+				continue
+			}
+
+			thread := strings.TrimSuffix(entry.Name(), jsonExtension)
+
+			var repaired *RepairResult
+			if dryRun {
+				repaired, err = f.scanThread(thread, filepath.Join(f.historyDir, entry.Name()))
+			} else {
+				repaired, _, err = f.repairThread(thread, filepath.Join(f.historyDir, entry.Name()))
+			}
+			if err != nil {
+				return fmt.Errorf("failed to %s thread %s: %w", action, thread, err)
+			}
+			if repaired != nil {
+				result = append(result, *repaired)
+			}
+
+			archives, err := f.listArchives(thread)
+			if err != nil {
+				return err
+			}
+
+			for _, archive := range archives {
+				var repaired *RepairResult
+				if dryRun {
+					repaired, err = f.scanArchive(thread, archive)
+				} else {
+					repaired, err = f.repairArchive(thread, archive)
+				}
+				if err != nil {
+					return fmt.Errorf("failed to %s archive %d of thread %s: %w", action, archive, thread, err)
+				}
+				if repaired != nil {
+					result = append(result, *repaired)
+				}
+			}
+		}
+
+		return nil
+	})
+
+	return result, err
+}
+
+// recoverThread repairs the thread a read failed on, for the stores that recover.
+func (f *FileIO) recoverThread(thread, path string) ([]types.Message, error) {
+	var (
+		result   []types.Message
+		repaired *RepairResult
+	)
+
+	err := f.withLock(true, func() error {
+		var err error
+		repaired, result, err = f.repairThread(thread, path)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if repaired != nil {
+		f.recovery(*repaired)
+	}
+
+	return result, nil
+}
+
+// repairThread returns the messages of the thread, and the repair when the thread had to be
+// repaired.
+func (f *FileIO) repairThread(thread, path string) (*RepairResult, []types.Message, error) {
+	messages, total, damaged, err := f.salvageFile(path)
+	if err != nil || !damaged {
+		return nil, messages, err
+	}
+
+	result := &RepairResult{Thread: thread}
+
+	// the backup is the thread as it was last written, so it is usually the whole of it
+	if backup, _, err := f.parseFile(path + backupExtension); err == nil && backup != nil && len(backup) >= len(messages) {
+		messages, result.FromBackup = backup, true
+	}
+
+	if result.Moved, err = moveAside(path); err != nil {
+		return nil, nil, err
+	}
+
+	if err := f.write(thread, messages); err != nil {
+		return nil, nil, err
+	}
+
+	result.Recovered, result.Lost = len(messages), max(total-len(messages), 0)
+
+	return result, messages, nil
+}
+
+// scanThread returns what a repair of the thread would recover, nil when it isn't damaged.
+func (f *FileIO) scanThread(thread, path string) (*RepairResult, error) {
+	messages, total, damaged, err := f.salvageFile(path)
+	if err != nil || !damaged {
+		return nil, err
+	}
+
+	result := &RepairResult{Thread: thread, Recovered: len(messages)}
+	if backup, _, err := f.parseFile(path + backupExtension); err == nil && backup != nil && len(backup) >= len(messages) {
+		result.Recovered, result.FromBackup = len(backup), true
+	}
+	result.Lost = max(total-result.Recovered, 0)
+
+	return result, nil
+}
+
+// scanArchive returns what a repair of the archive would recover, nil when it isn't damaged.
+func (f *FileIO) scanArchive(thread string, number int) (*RepairResult, error) {
+	messages, total, damaged, err := f.salvageFile(f.archivePath(thread, number))
+	if err != nil || !damaged {
+		return nil, err
+	}
+
+	return &RepairResult{
+		Thread:    thread,
+		Archive:   number,
+		Recovered: len(messages),
+		Lost:      max(total-len(messages), 0),
+	}, nil
+}
+
+func (f *FileIO) repairArchive(thread string, number int) (*RepairResult, error) {
+	path := f.archivePath(thread, number)
+
+	messages, total, damaged, err := f.salvageFile(path)
+	if err != nil || !damaged {
+		return nil, err
+	}
+
+	moved, err := moveAside(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := f.writeMessages(path, messages); err != nil {
+		return nil, err
+	}
+
+	return &RepairResult{
+		Thread:    thread,
+		Archive:   number,
+		Recovered: len(messages),
+		Lost:      max(total-len(messages), 0),
+		Moved:     moved,
+	}, nil
+}
+
+// salvageFile parses the history file, and when it is damaged returns the messages that can still
+// be decoded along with how many messages the file held. Files that can't be decrypted or were
+// written by a newer version are an error rather than damage, a repair would lose them.
+func (f *FileIO) salvageFile(path string) ([]types.Message, int, bool, error) {
+	messages, _, err := f.parseFile(path)
+	if err == nil || !isDamage(err) {
+		return messages, len(messages), false, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	if data, err = f.decrypt(data); err != nil {
+		return nil, 0, false, err
+	}
+
+	// what a truncated archive still decompresses to is kept
+	if isCompressed(data) {
+		if reader, err := gzip.NewReader(bytes.NewReader(data)); err == nil {
+			data, _ = io.ReadAll(reader)
+		}
+	}
+
+	salvaged, total := salvageMessages(data)
+
+	return fromStored(salvaged), total, true, nil
+}
+
+// salvageMessages decodes every message of a damaged history file that is still whole, wherever
+// it is in the file, and returns them with the number of messages the file held, which is counted
+// by their roles.
+func salvageMessages(data []byte) ([]storedMessage, int) {
+	var result []storedMessage
+
+	for offset := 0; offset < len(data); {
+		start := bytes.IndexByte(data[offset:], '{')
+		if start < 0 {
+			break
+		}
+		start += offset
+
+		decoder := json.NewDecoder(bytes.NewReader(data[start:]))
+
+		var message storedMessage
+		if err := decoder.Decode(&message); err != nil || !isRole(message.Role) {
+			// the document itself, or a damaged message, the next message may still be whole
+			offset = start + 1
+			continue
+		}
+
+		result = append(result, message)
+		offset = start + int(decoder.InputOffset())
+	}
+
+	return result, max(len(roleKey.FindAllIndex(data, -1)), len(result))
+}
+
+// moveAside renames the damaged file to a name that is never read as a thread or an archive.
+func moveAside(path string) (string, error) {
+	moved := path + corruptExtension + time.Now().Format("20060102-150405")
+	if err := os.Rename(path, moved); err != nil {
+		return "", err
+	}
+
+	return moved, nil
+}
+
+// isDamage reports whether the error is about the content of a history file, rather than about
+// reading it at all, decrypting it or its schema version.
+func isDamage(err error) bool {
+	var pathError *os.PathError
+	return !errors.As(err, &pathError) && !errors.Is(err, ErrEncrypted) && !errors.Is(err, ErrWrongKey) && !errors.Is(err, ErrNewerSchema)
+}
+
+func isRole(role string) bool {
+	return role == systemRole || role == userRole || role == assistantRole
+}
@@ -0,0 +1,293 @@
+package history
+
+import (
+	"encoding/json"
+	"github.com/kardolus/chatgpt-cli/types"
+	"github.com/kardolus/chatgpt-cli/utils"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// archiveDir holds the messages rotated out of the threads, thread names can't start with a dot so
+// it never clashes with a thread
+const archiveDir = ".archive"
+
+// Archiver is implemented by the stores that rotate the older messages of a thread out to archives.
+type Archiver interface {
+	ReadArchived(thread string) ([]types.Message, error)
+}
+
+// Ensure FileIO implements the Archiver interface
+var _ Archiver = &FileIO{}
+
+// WithRotation caps the size of the threads, by message count, by size in bytes or both, where zero
+// means no cap. A thread that grows past the cap has its oldest messages moved to an archive file,
+// so the thread is back to about half the cap. The system prompt the thread starts with stays, and
+// a question is never archived without its answer. Pinned messages are never archived either.
+// Read only returns what was not archived.
+func (f *FileIO) WithRotation(maxMessages, maxBytes int) *FileIO {
+	f.maxMessages = maxMessages
+	f.maxBytes = maxBytes
+	return f
+}
+
+// ReadArchived returns the whole history of the thread in the order it was written, the messages
+// that were rotated out to the archives followed by the ones that are still active.
+func (f *FileIO) ReadArchived(thread string) ([]types.Message, error) {
+	path, err := f.getPath(thread)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []types.Message
+	err = f.withLock(false, func() error {
+		result, err = f.readArchived(thread, path)
+		return err
+	})
+
+	return result, err
+}
+
+// readArchived is ReadArchived for callers that hold the lock.
+func (f *FileIO) readArchived(thread, path string) ([]types.Message, error) {
+	active, _, err := f.parseThreadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	archives, err := f.listArchives(thread)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []types.Message
+
+	// the system prompt is kept in the thread when the messages after it are archived
+	if len(archives) > 0 && len(active) > 0 && active[0].Role == systemRole {
+		result = append(result, active[0])
+		active = active[1:]
+	}
+
+	for _, archive := range archives {
+		messages, _, err := f.parseFile(f.archivePath(thread, archive))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, messages...)
+	}
+
+	return append(result, active...), nil
+}
+
+// split returns the messages to keep in the thread and the messages to archive. Nothing is archived
+// while the thread fits the cap.
+func (f *FileIO) split(messages []types.Message) ([]types.Message, []types.Message, error) {
+	if f.maxMessages <= 0 && f.maxBytes <= 0 {
+		return messages, nil, nil
+	}
+
+	sizes := make([]int, len(messages))
+	var total int
+	if f.maxBytes > 0 {
+		for i, message := range toStored(messages) {
+			data, err := json.Marshal(message)
+			if err != nil {
+				return nil, nil, err
+			}
+			sizes[i] = len(data) + 1 // the separator
+			total += sizes[i]
+		}
+	}
+
+	if !f.exceeds(len(messages), total) {
+		return messages, nil, nil
+	}
+
+	start := 0
+	if len(messages) > 0 && messages[0].Role == systemRole {
+		start = 1
+	}
+
+	// keep about half the cap, so the thread is not rotated again on the next write
+	keptMessages, keptBytes := start, 0
+	if start > 0 {
+		keptBytes = sizes[0]
+	}
+
+	cut := len(messages)
+	for cut > start && !f.exceedsHalf(keptMessages+1, keptBytes+sizes[cut-1]) {
+		cut--
+		keptMessages++
+		keptBytes += sizes[cut]
+	}
+
+	// the thread is only cut before a question, so a question and its answer stay together
+	boundary := -1
+	for i := min(cut, len(messages)-1); i > start; i-- {
+		if messages[i].Role == userRole {
+			boundary = i
+			break
+		}
+	}
+	if boundary < 0 {
+		for i := cut + 1; i < len(messages); i++ {
+			if messages[i].Role == userRole {
+				boundary = i
+				break
+			}
+		}
+	}
+
+	if boundary <= start {
+		return messages, nil, nil
+	}
+
+	// pinned messages are never archived, they stay right after the system prompt
+	archived, pinned := unpinned(messages[start:boundary])
+	if len(archived) == 0 {
+		return messages, nil, nil
+	}
+
+	active := append(append([]types.Message(nil), messages[:start]...), pinned...)
+
+	return append(active, messages[boundary:]...), archived, nil
+}
+
+func (f *FileIO) exceeds(messages, bytes int) bool {
+	return (f.maxMessages > 0 && messages > f.maxMessages) || (f.maxBytes > 0 && bytes > f.maxBytes)
+}
+
+func (f *FileIO) exceedsHalf(messages, bytes int) bool {
+	return (f.maxMessages > 0 && messages > f.maxMessages/2) || (f.maxBytes > 0 && bytes > f.maxBytes/2)
+}
+
+// writeArchive writes the messages to the next archive of the thread.
+func (f *FileIO) writeArchive(thread string, messages []types.Message) error {
+	archives, err := f.listArchives(thread)
+	if err != nil {
+		return err
+	}
+
+	next := 1
+	if len(archives) > 0 {
+		next = archives[len(archives)-1] + 1
+	}
+
+	if err := os.MkdirAll(filepath.Join(f.historyDir, archiveDir), dirPerm); err != nil {
+		return err
+	}
+
+	return f.writeMessages(f.archiveName(thread, next, true), messages)
+}
+
+// writeMessages writes the messages to the file in a single step, compressed when the name is the
+// one of a compressed archive and encrypted when the store has a passphrase.
+func (f *FileIO) writeMessages(fileName string, messages []types.Message) error {
+	data, err := encodeMessages(messages)
+	if err != nil {
+		return err
+	}
+
+	if isCompressedName(fileName) {
+		if data, err = compress(data); err != nil {
+			return err
+		}
+	}
+
+	if data, err = f.encrypt(data); err != nil {
+		return err
+	}
+
+	return utils.WriteFileAtomic(fileName, data, filePerm)
+}
+
+// listArchives returns the numbers of the archives of the thread, oldest first.
+func (f *FileIO) listArchives(thread string) ([]int, error) {
+	entries, err := os.ReadDir(filepath.Join(f.historyDir, archiveDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var result []int
+	seen := make(map[int]bool)
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), compressedExtension)
+		if entry.IsDir() || !strings.HasPrefix(name, thread+".") || !strings.HasSuffix(name, jsonExtension) {
+			continue
+		}
+
+		number, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, thread+"."), jsonExtension))
+		if err != nil || number <= 0 || seen[number] {
+			// the archive of another thread, whose name starts with this one, or an archive
+			// whose compression was interrupted
+			continue
+		}
+
+		seen[number] = true
+		result = append(result, number)
+	}
+
+	sort.Ints(result)
+
+	return result, nil
+}
+
+// archivePath returns the path of the archive, the compressed one when there is one.
+func (f *FileIO) archivePath(thread string, number int) string {
+	compressed := f.archiveName(thread, number, true)
+	if _, err := os.Stat(compressed); err == nil {
+		return compressed
+	}
+
+	return f.archiveName(thread, number, false)
+}
+
+func (f *FileIO) archiveName(thread string, number int, compressed bool) string {
+	name := thread + "." + strconv.Itoa(number) + jsonExtension
+	if compressed {
+		name += compressedExtension
+	}
+
+	return filepath.Join(f.historyDir, archiveDir, name)
+}
+
+// removeArchives removes the archives of the thread.
+func (f *FileIO) removeArchives(thread string) error {
+	archives, err := f.listArchives(thread)
+	if err != nil {
+		return err
+	}
+
+	for _, archive := range archives {
+		for _, compressed := range []bool{true, false} {
+			if err := os.Remove(f.archiveName(thread, archive, compressed)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// renameArchives moves the archives of the thread from to the thread to.
+func (f *FileIO) renameArchives(from, to string) error {
+	archives, err := f.listArchives(from)
+	if err != nil {
+		return err
+	}
+
+	for _, archive := range archives {
+		path := f.archivePath(from, archive)
+		if err := os.Rename(path, f.archiveName(to, archive, isCompressedName(path))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,270 @@
+package history
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	S3Scheme = "s3"
+
+	// the standard variables of the AWS tooling
+	awsAccessKeyEnv    = "AWS_ACCESS_KEY_ID"
+	awsSecretKeyEnv    = "AWS_SECRET_ACCESS_KEY"
+	awsSessionTokenEnv = "AWS_SESSION_TOKEN"
+	awsRegionEnv       = "AWS_REGION"
+	awsDefaultRegion   = "AWS_DEFAULT_REGION"
+	// awsEndpointEnv points the store to an S3 compatible server, such as MinIO, instead of AWS
+	awsEndpointEnv = "AWS_ENDPOINT_URL"
+
+	s3Timeout     = 30 * time.Second
+	amzDateFormat = "20060102T150405Z"
+	emptySHA256   = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// Ensure S3 implements the Remote interface
+var _ Remote = &S3{}
+
+// S3 keeps the threads as objects in an S3 bucket, signing its requests with the credentials of the
+// standard AWS environment variables.
+type S3 struct {
+	bucket       string
+	region       string
+	endpoint     *url.URL
+	pathStyle    bool
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	client       *http.Client
+	now          func() time.Time
+}
+
+// NewS3 returns the bucket with the credentials and the region of the environment. The region
+// defaults to us-east-1, and AWS_ENDPOINT_URL replaces the AWS endpoint with another S3 compatible
+// server, which is addressed with the bucket in the path.
+func NewS3(bucket string) (*S3, error) {
+	if bucket == "" {
+		return nil, errors.New("the S3 history locator has no bucket, expected s3://bucket/prefix")
+	}
+
+	s := &S3{
+		bucket:       bucket,
+		region:       os.Getenv(awsRegionEnv),
+		accessKey:    os.Getenv(awsAccessKeyEnv),
+		secretKey:    os.Getenv(awsSecretKeyEnv),
+		sessionToken: os.Getenv(awsSessionTokenEnv),
+		client:       &http.Client{Timeout: s3Timeout},
+		now:          time.Now,
+	}
+
+	if s.accessKey == "" || s.secretKey == "" {
+		return nil, fmt.Errorf("the S3 history backend needs the %s and %s environment variables", awsAccessKeyEnv, awsSecretKeyEnv)
+	}
+
+	if s.region == "" {
+		s.region = os.Getenv(awsDefaultRegion)
+	}
+	if s.region == "" {
+		s.region = "us-east-1"
+	}
+
+	endpoint := "https://" + bucket + ".s3." + s.region + ".amazonaws.com"
+	if custom := os.Getenv(awsEndpointEnv); custom != "" {
+		endpoint, s.pathStyle = strings.TrimSuffix(custom, "/"), true
+	}
+
+	var err error
+	if s.endpoint, err = url.Parse(endpoint); err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint %q: %w", endpoint, err)
+	}
+
+	return s, nil
+}
+
+// Get returns the object along with its ETag, or ErrNotModified when it still has the given one.
+func (s *S3) Get(key, etag string) ([]byte, string, error) {
+	header := http.Header{}
+	if etag != "" {
+		header.Set("If-None-Match", etag)
+	}
+
+	resp, err := s.do(http.MethodGet, key, nil, header)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil, etag, ErrNotModified
+	case http.StatusNotFound:
+		return nil, "", ErrRemoteNotFound
+	default:
+		return nil, "", s3Error(resp)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrRemoteUnavailable, err)
+	}
+
+	return data, resp.Header.Get("ETag"), nil
+}
+
+// Put writes the object with a conditional request, so it's only written when it still has the
+// given ETag, or when it doesn't exist yet when the ETag is empty.
+func (s *S3) Put(key string, data []byte, etag string) (string, error) {
+	header := http.Header{"Content-Type": {"application/json"}}
+	if etag != "" {
+		header.Set("If-Match", etag)
+	} else {
+		header.Set("If-None-Match", "*")
+	}
+
+	resp, err := s.do(http.MethodPut, key, data, header)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Header.Get("ETag"), nil
+	// S3 answers 409 when another conditional write to the object is in progress
+	case http.StatusPreconditionFailed, http.StatusConflict:
+		return "", ErrRemoteConflict
+	default:
+		return "", s3Error(resp)
+	}
+}
+
+// Delete removes the object, S3 doesn't report objects that don't exist.
+func (s *S3) Delete(key string) error {
+	resp, err := s.do(http.MethodDelete, key, nil, http.Header{})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return s3Error(resp)
+	}
+
+	return nil
+}
+
+func (s *S3) do(method, key string, body []byte, header http.Header) (*http.Response, error) {
+	target := *s.endpoint
+	target.Path = "/" + key
+	if s.pathStyle {
+		target.Path = strings.TrimSuffix(s.endpoint.Path, "/") + "/" + s.bucket + "/" + key
+	}
+
+	req, err := http.NewRequest(method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header
+	s.sign(req, body)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrRemoteUnavailable, err)
+	}
+
+	return resp, nil
+}
+
+// sign adds the AWS Signature Version 4 of the request, which signs the host and the x-amz headers.
+func (s *S3) sign(req *http.Request, body []byte) {
+	now := s.now().UTC()
+	amzDate := now.Format(amzDateFormat)
+	date := amzDate[:8]
+
+	payloadHash := emptySHA256
+	if len(body) > 0 {
+		sum := sha256.Sum256(body)
+		payloadHash = hex.EncodeToString(sum[:])
+	}
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	signed := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		if name = strings.ToLower(name); strings.HasPrefix(name, "x-amz-") {
+			signed[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+
+	names := make([]string, 0, len(signed))
+	for name := range signed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + signed[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	for _, part := range []string{s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Error turns an unexpected response into an error with the message of S3, which comes as XML.
+func s3Error(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+
+	message := string(body)
+	if start, end := strings.Index(message, "<Message>"), strings.Index(message, "</Message>"); start >= 0 && end > start {
+		message = message[start+len("<Message>") : end]
+	}
+
+	if message = strings.TrimSpace(message); message == "" {
+		return fmt.Errorf("the S3 history backend responded with %s", resp.Status)
+	}
+
+	return fmt.Errorf("the S3 history backend responded with %s: %s", resp.Status, message)
+}
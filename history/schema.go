@@ -0,0 +1,144 @@
+package history
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/types"
+)
+
+// SchemaVersion is the version of the format the history files are written in. Version 0 is the bare
+// array of messages the history was kept as before the files recorded their version.
+const SchemaVersion = 1
+
+// ErrNewerSchema is returned for history files written by a newer chatgpt-cli, in a format this
+// version doesn't know.
+var ErrNewerSchema = errors.New("the history file was written by a newer version of chatgpt-cli")
+
+// migrations upgrade the content of a history file from the version they are keyed by to the next
+// one. A change to the format bumps SchemaVersion and adds the migration from the previous version,
+// older files are then upgraded one version at a time on their first read.
+var migrations = map[int]func([]byte) ([]byte, error){
+	0: wrapMessages,
+}
+
+// storedThread is the content of a history file since version 1.
+type storedThread struct {
+	Version  int             `json:"version"`
+	Messages []storedMessage `json:"messages"`
+}
+
+func encodeMessages(messages []types.Message) ([]byte, error) {
+	return json.Marshal(storedThread{Version: SchemaVersion, Messages: toStored(messages)})
+}
+
+// decodeMessages upgrades the content of a history file to the current version and returns its
+// messages along with the version the content was in.
+func decodeMessages(data []byte) ([]storedMessage, int, error) {
+	version, err := schemaVersion(data)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if version > SchemaVersion {
+		return nil, version, fmt.Errorf("%w: it has schema version %d and this version only reads up to version %d, upgrade chatgpt-cli to use it", ErrNewerSchema, version, SchemaVersion)
+	}
+
+	for from := version; from < SchemaVersion; from++ {
+		migrate, ok := migrations[from]
+		if !ok {
+			return nil, version, fmt.Errorf("no migration from history schema version %d", from)
+		}
+
+		if data, err = migrate(data); err != nil {
+			return nil, version, err
+		}
+	}
+
+	var result storedThread
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, version, err
+	}
+
+	return result.Messages, version, nil
+}
+
+// schemaVersion returns the version of the content of a history file. Anything but a JSON object is
+// version 0, the migration to version 1 refuses what is not an array of messages.
+func schemaVersion(data []byte) (int, error) {
+	if trimmed := bytes.TrimLeft(data, " \t\r\n"); len(trimmed) == 0 || trimmed[0] != '{' {
+		return 0, nil
+	}
+
+	var header struct {
+		Version *int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return 0, err
+	}
+
+	if header.Version == nil || *header.Version < 1 {
+		return 0, errors.New("the history file has no valid schema version")
+	}
+
+	return *header.Version, nil
+}
+
+// wrapMessages upgrades version 0 to version 1 by moving the array of messages into a document
+// that records the version. The messages themselves are kept as they are.
+func wrapMessages(data []byte) ([]byte, error) {
+	var messages []json.RawMessage
+	if err := json.Unmarshal(data, &messages); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(struct {
+		Version  int               `json:"version"`
+		Messages []json.RawMessage `json:"messages"`
+	}{1, messages})
+}
+
+// openMessages advances the decoder to the first message of a history file, so the messages can be
+// decoded one at a time. It returns false for files without messages to stream, such as empty,
+// malformed or newer files. Every version so far stores its messages alike, a migration that
+// changes the messages has to be applied here as well.
+func openMessages(decoder *json.Decoder) bool {
+	token, err := decoder.Token()
+	if err != nil {
+		return false
+	}
+
+	if token == json.Delim('[') {
+		return true
+	}
+
+	if token != json.Delim('{') {
+		return false
+	}
+
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return false
+		}
+
+		switch key {
+		case "version":
+			var version int
+			if err := decoder.Decode(&version); err != nil || version > SchemaVersion {
+				return false
+			}
+		case "messages":
+			token, err := decoder.Token()
+			return err == nil && token == json.Delim('[')
+		default:
+			var skipped json.RawMessage
+			if err := decoder.Decode(&skipped); err != nil {
+				return false
+			}
+		}
+	}
+
+	return false
+}
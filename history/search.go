@@ -0,0 +1,201 @@
+package history
+
+import (
+	"encoding/json"
+	"github.com/kardolus/chatgpt-cli/types"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const snippetContext = 40
+
+// SearchOptions describes what Search looks for.
+type SearchOptions struct {
+	// Term is matched case-insensitively, as a substring or as a regular expression when Regexp is set.
+	Term   string
+	Regexp bool
+	// Role restricts the search to the messages of a single role when it is set.
+	Role string
+	// Tag restricts the search to the threads with the tag when it is set.
+	Tag string
+	// Range restricts the search to the messages added within it.
+	Range DateRange
+	// Archives includes the messages that were rotated out of the threads, for the stores that
+	// rotate them.
+	Archives bool
+	// ArchivedThreads includes the threads that were archived.
+	ArchivedThreads bool
+}
+
+// SearchMatch is a message that matched a search.
+type SearchMatch struct {
+	Thread string
+	// Archive is the number of the archive the message is in, zero for the thread itself, and
+	// Index is the position of the message in there.
+	Archive int
+	Index   int
+	Role    string
+	// Updated is when the thread was last written and Timestamp is when the message was added,
+	// which is zero for messages from older histories.
+	Updated   time.Time
+	Timestamp time.Time
+	// Snippet is the part of the message around the match. Start and End are the byte offsets of
+	// the match within the snippet.
+	Snippet string
+	Start   int
+	End     int
+}
+
+// Matches reports whether the message has the role and was added in the range of the search,
+// without looking at its content.
+func (opts SearchOptions) Matches(message types.Message) bool {
+	return (opts.Role == "" || message.Role == opts.Role) && opts.Range.Contains(message.Timestamp)
+}
+
+// CompileSearch returns the case-insensitive regular expression for the search options.
+func CompileSearch(opts SearchOptions) (*regexp.Regexp, error) {
+	term := opts.Term
+	if !opts.Regexp {
+		term = regexp.QuoteMeta(term)
+	}
+
+	return regexp.Compile("(?i)" + term)
+}
+
+// Search scans all threads, in alphabetical order, and calls fn for every message that matches.
+// The threads are decoded one message at a time, so the history is never loaded as a whole. When
+// fn returns an error the search stops and returns that error.
+func (f *FileIO) Search(opts SearchOptions, fn func(SearchMatch) error) error {
+	pattern, err := CompileSearch(opts)
+	if err != nil {
+		return err
+	}
+
+	threads, err := f.ListThreads()
+	if err != nil {
+		return err
+	}
+
+	for _, thread := range FilterByTag(FilterArchived(threads, opts.ArchivedThreads), opts.Tag) {
+		if opts.Archives {
+			archives, err := f.listArchives(thread.Name)
+			if err != nil {
+				return err
+			}
+
+			for _, archive := range archives {
+				if err := f.searchFile(f.archivePath(thread.Name, archive), thread, archive, pattern, opts, fn); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := f.searchFile(filepath.Join(f.historyDir, thread.Name+jsonExtension), thread, 0, pattern, opts, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (f *FileIO) searchFile(fileName string, thread ThreadInfo, archive int, pattern *regexp.Regexp, opts SearchOptions, fn func(SearchMatch) error) error {
+	return f.eachMessage(fileName, func(index int, message types.Message) error {
+		if !opts.Matches(message) {
+			return nil
+		}
+
+		match, ok := MatchMessage(message, pattern)
+		if !ok {
+			return nil
+		}
+
+		match.Thread = thread.Name
+		match.Archive = archive
+		match.Index = index
+		match.Updated = thread.Updated
+		match.Timestamp = message.Timestamp
+
+		return fn(match)
+	})
+}
+
+// eachMessage decodes the messages of a history file one at a time and calls fn for each of them,
+// with its position. Decoding stops at the first malformed message. When fn returns an error
+// eachMessage stops and returns that error.
+func (f *FileIO) eachMessage(fileName string, fn func(int, types.Message) error) error {
+	file, err := f.openThread(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	if !openMessages(decoder) {
+		// empty or malformed files have no messages
+		return nil
+	}
+
+	for index := 0; decoder.More(); index++ {
+		var stored storedMessage
+		if err := decoder.Decode(&stored); err != nil {
+			return nil
+		}
+
+		if err := fn(index, stored.toMessage()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MatchMessage returns the match of pattern in the message content, with Role and the snippet set.
+func MatchMessage(message types.Message, pattern *regexp.Regexp) (SearchMatch, bool) {
+	location := pattern.FindStringIndex(message.Content)
+	if location == nil {
+		return SearchMatch{}, false
+	}
+
+	start, end := location[0], location[1]
+	from := snippetBoundary(message.Content, start-snippetContext)
+	to := snippetBoundary(message.Content, end+snippetContext)
+
+	snippet := message.Content[from:to]
+	prefix, suffix := "", ""
+	if from > 0 {
+		prefix = "..."
+	}
+	if to < len(message.Content) {
+		suffix = "..."
+	}
+
+	// newlines are kept at the same length so the offsets stay valid
+	snippet = strings.NewReplacer("\n", " ", "\r", " ", "\t", " ").Replace(snippet)
+
+	return SearchMatch{
+		Role:    message.Role,
+		Snippet: prefix + snippet + suffix,
+		Start:   len(prefix) + start - from,
+		End:     len(prefix) + end - from,
+	}, true
+}
+
+// snippetBoundary clamps offset to the content and moves it back to the start of a rune.
+func snippetBoundary(content string, offset int) int {
+	if offset <= 0 {
+		return 0
+	}
+
+	if offset >= len(content) {
+		return len(content)
+	}
+
+	for offset > 0 && !utf8.RuneStart(content[offset]) {
+		offset--
+	}
+
+	return offset
+}
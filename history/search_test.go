@@ -0,0 +1,79 @@
+package history_test
+
+import (
+	"github.com/kardolus/chatgpt-cli/history"
+	"github.com/kardolus/chatgpt-cli/types"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitSearch(t *testing.T) {
+	spec.Run(t, "Testing the history search", testSearch, spec.Report(report.Terminal{}))
+}
+
+func testSearch(t *testing.T, when spec.G, it spec.S) {
+	it.Before(func() {
+		RegisterTestingT(t)
+	})
+
+	when("CompileSearch()", func() {
+		it("matches substrings case-insensitively and literally", func() {
+			pattern, err := history.CompileSearch(history.SearchOptions{Term: "PgBouncer (timeouts)"})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(pattern.MatchString("why do pgbouncer (timeouts) happen")).To(BeTrue())
+			Expect(pattern.MatchString("pgbouncer timeouts")).To(BeFalse())
+		})
+		it("matches regular expressions case-insensitively", func() {
+			pattern, err := history.CompileSearch(history.SearchOptions{Term: "pg(bouncer|pool)", Regexp: true})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(pattern.MatchString("PGPOOL")).To(BeTrue())
+		})
+		it("returns an error for an invalid regular expression", func() {
+			_, err := history.CompileSearch(history.SearchOptions{Term: "(", Regexp: true})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	when("MatchMessage()", func() {
+		pattern, _ := history.CompileSearch(history.SearchOptions{Term: "timeouts"})
+
+		it("returns the whole message when it is short", func() {
+			match, ok := history.MatchMessage(types.Message{Role: "user", Content: "pgbouncer\ntimeouts?"}, pattern)
+
+			Expect(ok).To(BeTrue())
+			Expect(match.Role).To(Equal("user"))
+			Expect(match.Snippet).To(Equal("pgbouncer timeouts?"))
+			Expect(match.Snippet[match.Start:match.End]).To(Equal("timeouts"))
+		})
+		it("shortens long messages around the match", func() {
+			content := strings.Repeat("a", 100) + " TIMEOUTS " + strings.Repeat("b", 100)
+
+			match, ok := history.MatchMessage(types.Message{Role: "assistant", Content: content}, pattern)
+
+			Expect(ok).To(BeTrue())
+			Expect(match.Snippet).To(HavePrefix("..."))
+			Expect(match.Snippet).To(HaveSuffix("..."))
+			Expect(len(match.Snippet)).To(BeNumerically("<", len(content)))
+			Expect(match.Snippet[match.Start:match.End]).To(Equal("TIMEOUTS"))
+		})
+		it("does not split multi-byte characters", func() {
+			content := strings.Repeat("é", 50) + "timeouts"
+
+			match, ok := history.MatchMessage(types.Message{Role: "user", Content: content}, pattern)
+
+			Expect(ok).To(BeTrue())
+			Expect(match.Snippet).To(Equal("..." + strings.Repeat("é", 20) + "timeouts"))
+		})
+		it("reports messages without a match", func() {
+			_, ok := history.MatchMessage(types.Message{Role: "user", Content: "nothing here"}, pattern)
+
+			Expect(ok).To(BeFalse())
+		})
+	})
+}
@@ -0,0 +1,847 @@
+package history
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/config"
+	"github.com/kardolus/chatgpt-cli/types"
+	"github.com/kardolus/chatgpt-cli/utils"
+	// the pure-Go SQLite driver, which registers itself as SQLiteDriver, so the build needs no cgo
+	_ "modernc.org/sqlite"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	BackendFile   = "file"
+	BackendSQLite = "sqlite"
+
+	// SQLiteDriver is the database/sql driver the SQLite store opens its database with, the one of
+	// modernc.org/sqlite
+	SQLiteDriver = "sqlite"
+	sqliteFile   = "history.db"
+
+	jsonMigration = "json-history"
+)
+
+// columns are added to the tables of older databases once, each under the name of its migration
+var columns = []struct {
+	migration string
+	statement string
+}{
+	{"thread-summary", `ALTER TABLE threads ADD COLUMN summary TEXT NOT NULL DEFAULT ''`},
+	{"thread-title", `ALTER TABLE threads ADD COLUMN title TEXT NOT NULL DEFAULT ''`},
+	{"message-pinned", `ALTER TABLE messages ADD COLUMN pinned INTEGER NOT NULL DEFAULT 0`},
+	{"thread-tags", `ALTER TABLE threads ADD COLUMN tags TEXT NOT NULL DEFAULT ''`},
+	{"thread-archived", `ALTER TABLE threads ADD COLUMN archived INTEGER NOT NULL DEFAULT 0`},
+	{"message-model", `ALTER TABLE messages ADD COLUMN model TEXT NOT NULL DEFAULT ''`},
+}
+
+// schema creates the tables of the SQLite store. A thread exists once it is written, like the
+// files of FileIO. Times are stored as Unix nanoseconds, 0 stands for an unknown time.
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS threads (
+		name    TEXT PRIMARY KEY,
+		created INTEGER NOT NULL,
+		updated INTEGER NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS messages (
+		thread         TEXT NOT NULL,
+		position       INTEGER NOT NULL,
+		role           TEXT NOT NULL,
+		content        TEXT NOT NULL,
+		alternatives   TEXT NOT NULL DEFAULT '',
+		timestamp      INTEGER NOT NULL DEFAULT 0,
+		tokens         INTEGER NOT NULL DEFAULT 0,
+		token_encoding TEXT NOT NULL DEFAULT '',
+		PRIMARY KEY (thread, position)
+	)`,
+	`CREATE INDEX IF NOT EXISTS messages_by_thread ON messages (thread)`,
+	`CREATE INDEX IF NOT EXISTS messages_by_timestamp ON messages (timestamp)`,
+	`CREATE TABLE IF NOT EXISTS migrations (name TEXT PRIMARY KEY)`,
+}
+
+// Ensure SQLStore implements the HistoryStore interface
+var _ HistoryStore = &SQLStore{}
+
+// SQLStore keeps the history in a SQLite database, which scales to far larger histories than the
+// JSON files of FileIO. Every write runs in a transaction, so concurrent processes never see a
+// partially written thread.
+type SQLStore struct {
+	db     *sql.DB
+	thread string
+}
+
+// OpenSQLite opens, and creates when needed, the SQLite database in dir, or in the data home when
+// dir is empty. Writers wait up to lockTimeout for another process to finish its transaction.
+func OpenSQLite(dir string, lockTimeout time.Duration) (*SQLStore, error) {
+	var err error
+	if dir == "" {
+		dir, err = utils.GetDataHome()
+	} else {
+		dir, err = createDirectory(dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return OpenSQLiteFile(filepath.Join(dir, sqliteFile), lockTimeout)
+}
+
+// OpenSQLiteFile opens, and creates when needed, the SQLite database at path.
+func OpenSQLiteFile(path string, lockTimeout time.Duration) (*SQLStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), dirPerm); err != nil {
+		return nil, err
+	}
+
+	// immediate transactions take the write lock up front, so a read-modify-write can't fail halfway
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(%d)&_txlock=immediate", path, lockTimeout.Milliseconds())
+
+	db, err := sql.Open(SQLiteDriver, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := NewSQLStore(db)
+	if err != nil {
+		return nil, err
+	}
+
+	// the database file only exists once the tables were created
+	if err := os.Chmod(path, filePerm); err != nil {
+		_ = store.Close()
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// NewSQLStore returns a store on the given database and creates its tables when needed.
+func NewSQLStore(db *sql.DB) (*SQLStore, error) {
+	for _, statement := range schema {
+		if _, err := db.Exec(statement); err != nil {
+			return nil, fmt.Errorf("failed to create the history tables: %w", err)
+		}
+	}
+
+	store := &SQLStore{db: db}
+
+	for _, column := range columns {
+		err := store.inTransaction(func(tx *sql.Tx) error {
+			var done int
+			if err := tx.QueryRow(`SELECT COUNT(*) FROM migrations WHERE name = ?`, column.migration).Scan(&done); err != nil || done > 0 {
+				return err
+			}
+
+			if _, err := tx.Exec(column.statement); err != nil {
+				return err
+			}
+
+			_, err := tx.Exec(`INSERT INTO migrations (name) VALUES (?)`, column.migration)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to upgrade the history tables: %w", err)
+		}
+	}
+
+	return store, nil
+}
+
+// Close closes the database.
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLStore) GetThread() string {
+	return s.thread
+}
+
+func (s *SQLStore) SetThread(thread string) {
+	s.thread = thread
+}
+
+// Read returns the messages of the current thread. A thread that does not exist yet has no messages.
+func (s *SQLStore) Read() ([]types.Message, error) {
+	return s.ReadThread(s.thread)
+}
+
+// ReadThread returns the messages of the given thread. A thread that does not exist yet has no
+// messages.
+func (s *SQLStore) ReadThread(thread string) ([]types.Message, error) {
+	if err := ValidateThread(thread); err != nil {
+		return nil, err
+	}
+
+	return readMessages(s.db, thread)
+}
+
+// ReadPage returns up to limit messages of the thread, starting with the message at offset, along
+// with the number of messages of the thread. Only the messages of the page are read.
+func (s *SQLStore) ReadPage(thread string, offset, limit int) ([]types.Message, int, error) {
+	if err := validatePage(offset, limit); err != nil {
+		return nil, 0, err
+	}
+
+	if err := ValidateThread(thread); err != nil {
+		return nil, 0, err
+	}
+
+	var (
+		result []types.Message
+		total  int
+	)
+
+	// the count and the page are read in the same transaction, so they agree
+	err := s.inTransaction(func(tx *sql.Tx) error {
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM messages WHERE thread = ?`, thread).Scan(&total); err != nil {
+			return err
+		}
+
+		var err error
+		result, err = queryMessages(tx, `SELECT role, content, alternatives, timestamp, tokens, token_encoding, pinned, model
+			FROM messages WHERE thread = ? AND position >= ? ORDER BY position LIMIT ?`, thread, offset, limit)
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return result, total, nil
+}
+
+// Write replaces the messages of the current thread.
+func (s *SQLStore) Write(messages []types.Message) error {
+	if err := ValidateThread(s.thread); err != nil {
+		return err
+	}
+
+	return s.inTransaction(func(tx *sql.Tx) error {
+		return writeMessages(tx, s.thread, messages, time.Now())
+	})
+}
+
+// Update reads the current thread, passes its messages to fn and writes what fn returns in a
+// single transaction, so concurrent updates from other processes are not lost.
+func (s *SQLStore) Update(fn func([]types.Message) []types.Message) error {
+	if err := ValidateThread(s.thread); err != nil {
+		return err
+	}
+
+	return s.inTransaction(func(tx *sql.Tx) error {
+		messages, err := readMessages(tx, s.thread)
+		if err != nil {
+			return err
+		}
+
+		return writeMessages(tx, s.thread, fn(messages), time.Now())
+	})
+}
+
+// ListThreads returns the stored threads sorted by name.
+func (s *SQLStore) ListThreads() ([]ThreadInfo, error) {
+	rows, err := s.db.Query(`SELECT t.name, t.title, t.tags, t.created, t.updated, t.summary, t.archived, COUNT(m.position)
+		FROM threads t LEFT JOIN messages m ON m.thread = t.name
+		GROUP BY t.name ORDER BY t.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []ThreadInfo
+	for rows.Next() {
+		var (
+			info             ThreadInfo
+			created, updated int64
+			tags, summary    string
+		)
+
+		if err := rows.Scan(&info.Name, &info.Title, &tags, &created, &updated, &summary, &info.Archived, &info.Messages); err != nil {
+			return nil, err
+		}
+
+		if tags != "" {
+			info.Tags = strings.Split(tags, ",")
+		}
+
+		if summary != "" {
+			info.Summary = &types.Summary{}
+			if err := json.Unmarshal([]byte(summary), info.Summary); err != nil {
+				return nil, err
+			}
+		}
+
+		info.Created, info.Updated = fromUnixNano(created), fromUnixNano(updated)
+		result = append(result, info)
+	}
+
+	return result, rows.Err()
+}
+
+// Clear removes the messages of the thread. Clearing a thread without history is not an error, and
+// the current thread stays the same.
+func (s *SQLStore) Clear(thread string) error {
+	if err := ValidateThread(thread); err != nil {
+		return err
+	}
+
+	return s.inTransaction(func(tx *sql.Tx) error {
+		return deleteThread(tx, thread)
+	})
+}
+
+// DeleteThread removes the thread. Deleting a thread that does not exist is not an error. When the
+// current thread is deleted the store falls back to the default thread.
+func (s *SQLStore) DeleteThread(thread string) error {
+	if err := s.Clear(thread); err != nil {
+		return err
+	}
+
+	if thread == s.thread {
+		s.thread = config.New().ReadDefaults().Thread
+	}
+
+	return nil
+}
+
+// RenameThread renames the thread from to to, which must not exist yet. When the current thread is
+// renamed the store follows it.
+func (s *SQLStore) RenameThread(from, to string) error {
+	if err := ValidateThread(from); err != nil {
+		return err
+	}
+
+	if err := ValidateThread(to); err != nil {
+		return err
+	}
+
+	err := s.inTransaction(func(tx *sql.Tx) error {
+		if exists, err := threadExists(tx, from); err != nil || !exists {
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("thread %s does not exist", from)
+		}
+
+		if exists, err := threadExists(tx, to); err != nil || exists {
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("thread %s already exists", to)
+		}
+
+		if _, err := tx.Exec(`UPDATE threads SET name = ? WHERE name = ?`, to, from); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec(`UPDATE messages SET thread = ? WHERE thread = ?`, to, from)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if from == s.thread {
+		s.thread = to
+	}
+
+	return nil
+}
+
+// MergeThreads adds the messages of src to dst and removes src in a single transaction. When the
+// current thread is merged the store follows it to dst.
+func (s *SQLStore) MergeThreads(dst, src string, strategy MergeStrategy) (MergeResult, error) {
+	if dst == src {
+		return MergeResult{}, fmt.Errorf("can't merge thread %s into itself", src)
+	}
+
+	if err := ValidateThread(dst); err != nil {
+		return MergeResult{}, err
+	}
+
+	if err := ValidateThread(src); err != nil {
+		return MergeResult{}, err
+	}
+
+	var result MergeResult
+	err := s.inTransaction(func(tx *sql.Tx) error {
+		source, err := readMessages(tx, src)
+		if err != nil {
+			return err
+		}
+		if len(source) == 0 {
+			return fmt.Errorf("thread %s does not exist", src)
+		}
+
+		target, err := readMessages(tx, dst)
+		if err != nil {
+			return err
+		}
+
+		var merged []types.Message
+		if merged, result, err = mergeMessages(target, source, strategy); err != nil {
+			return err
+		}
+
+		if err := writeMessages(tx, dst, merged, time.Now()); err != nil {
+			return err
+		}
+
+		return deleteThread(tx, src)
+	})
+	if err != nil {
+		return MergeResult{}, err
+	}
+
+	if src == s.thread {
+		s.thread = dst
+	}
+
+	return result, nil
+}
+
+// Search scans all threads, in alphabetical order, and calls fn for every message that matches. The
+// messages are streamed from the database, so the history is never loaded as a whole. When fn
+// returns an error the search stops and returns that error.
+func (s *SQLStore) Search(opts SearchOptions, fn func(SearchMatch) error) error {
+	pattern, err := CompileSearch(opts)
+	if err != nil {
+		return err
+	}
+
+	rows, err := s.db.Query(`SELECT m.thread, m.position, m.role, m.content, m.timestamp, t.updated
+		FROM messages m JOIN threads t ON t.name = m.thread
+		WHERE (? = '' OR m.role = ?) AND (? = '' OR instr(',' || t.tags || ',', ',' || ? || ',') > 0) AND (? OR t.archived = 0)
+		ORDER BY m.thread, m.position`, opts.Role, opts.Role, opts.Tag, opts.Tag, opts.ArchivedThreads)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			message            types.Message
+			thread             string
+			index              int
+			timestamp, updated int64
+		)
+
+		if err := rows.Scan(&thread, &index, &message.Role, &message.Content, &timestamp, &updated); err != nil {
+			return err
+		}
+
+		message.Timestamp = fromUnixNano(timestamp)
+		if !opts.Range.Contains(message.Timestamp) {
+			continue
+		}
+
+		match, ok := MatchMessage(message, pattern)
+		if !ok {
+			continue
+		}
+
+		match.Thread = thread
+		match.Index = index
+		match.Timestamp = message.Timestamp
+		match.Updated = fromUnixNano(updated)
+
+		if err := fn(match); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// Stats adds up the messages of the thread, or of all threads when the thread is empty, that were
+// added in the date range. The messages are streamed from the database without their content,
+// unless their tokens were counted with another encoding and have to be counted again.
+func (s *SQLStore) Stats(thread string, dates DateRange) (Stats, error) {
+	if thread != "" {
+		if err := ValidateThread(thread); err != nil {
+			return Stats{}, err
+		}
+	}
+
+	rows, err := s.db.Query(`SELECT thread, position, role, timestamp, tokens, length(content),
+		CASE WHEN token_encoding = ? THEN '' ELSE content END
+		FROM messages WHERE ? = '' OR thread = ?
+		ORDER BY thread, position`, utils.TokenEncoding, thread, thread)
+	if err != nil {
+		return Stats{}, err
+	}
+	defer rows.Close()
+
+	builder := newStatsBuilder(thread, dates)
+	for rows.Next() {
+		var (
+			ref       MessageRef
+			timestamp int64
+			tokens    int
+			content   string
+		)
+
+		if err := rows.Scan(&ref.Thread, &ref.Index, &ref.Role, &timestamp, &tokens, &ref.Characters, &content); err != nil {
+			return Stats{}, err
+		}
+
+		if content != "" {
+			tokens = utils.EstimateTokens(content)
+		}
+
+		builder.addCounted(ref, fromUnixNano(timestamp), tokens)
+	}
+
+	return builder.stats, rows.Err()
+}
+
+// SetSummary keeps the summary of the thread, which ListThreads returns from then on.
+func (s *SQLStore) SetSummary(thread string, summary types.Summary) error {
+	if err := ValidateThread(thread); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+
+	return s.updateThread(thread, `UPDATE threads SET summary = ? WHERE name = ?`, string(data))
+}
+
+// SetTitle gives the thread a title, which ListThreads returns from then on.
+func (s *SQLStore) SetTitle(thread, title string) error {
+	if err := ValidateThread(thread); err != nil {
+		return err
+	}
+
+	return s.updateThread(thread, `UPDATE threads SET title = ? WHERE name = ?`, title)
+}
+
+// SetTags replaces the tags of the thread, which are kept as a comma separated list since tags
+// can't contain commas.
+func (s *SQLStore) SetTags(thread string, tags []string) error {
+	if err := ValidateThread(thread); err != nil {
+		return err
+	}
+
+	tags, err := NormalizeTags(tags)
+	if err != nil {
+		return err
+	}
+
+	return s.updateThread(thread, `UPDATE threads SET tags = ? WHERE name = ?`, strings.Join(tags, ","))
+}
+
+// SetArchived archives or unarchives the thread.
+func (s *SQLStore) SetArchived(thread string, archived bool) error {
+	if err := ValidateThread(thread); err != nil {
+		return err
+	}
+
+	return s.updateThread(thread, `UPDATE threads SET archived = ? WHERE name = ?`, archived)
+}
+
+// updateThread runs the statement, with the value and the thread name as arguments, on a thread
+// that exists.
+func (s *SQLStore) updateThread(thread, statement string, value interface{}) error {
+	return s.inTransaction(func(tx *sql.Tx) error {
+		if exists, err := threadExists(tx, thread); err != nil || !exists {
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("thread %s does not exist", thread)
+		}
+
+		_, err := tx.Exec(statement, value, thread)
+		return err
+	})
+}
+
+// DeleteMessages removes the messages at the given positions from the thread in a single
+// transaction. System prompts can't be deleted. With pairs a question is removed along with its
+// answer.
+func (s *SQLStore) DeleteMessages(thread string, indices []int, pairs bool) (DeleteResult, error) {
+	if err := ValidateThread(thread); err != nil {
+		return DeleteResult{}, err
+	}
+
+	var result DeleteResult
+	err := s.inTransaction(func(tx *sql.Tx) error {
+		messages, err := readMessages(tx, thread)
+		if err != nil {
+			return err
+		}
+
+		var kept []types.Message
+		if kept, result, err = deleteMessages(messages, indices, pairs); err != nil {
+			return err
+		}
+
+		if len(kept) == 0 {
+			return deleteThread(tx, thread)
+		}
+
+		return writeMessages(tx, thread, kept, time.Now())
+	})
+
+	return result, err
+}
+
+// SetPinned pins or unpins the messages at the given positions in a single transaction. System
+// prompts can't be pinned.
+func (s *SQLStore) SetPinned(thread string, indices []int, pinned bool) error {
+	if err := ValidateThread(thread); err != nil {
+		return err
+	}
+
+	return s.inTransaction(func(tx *sql.Tx) error {
+		messages, err := readMessages(tx, thread)
+		if err != nil {
+			return err
+		}
+
+		if messages, err = pinMessages(messages, indices, pinned); err != nil {
+			return err
+		}
+
+		return writeMessages(tx, thread, messages, time.Now())
+	})
+}
+
+// Prune removes the messages older than olderThan from every thread in a single transaction. The
+// system prompt a thread starts with is kept, and a question is only removed along with its answer.
+// With dryRun nothing is removed. It returns the threads that lose messages, in alphabetical order.
+func (s *SQLStore) Prune(olderThan time.Duration, dryRun bool) ([]PruneResult, error) {
+	cutoff := time.Now().Add(-olderThan)
+
+	threads, err := s.ListThreads()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []PruneResult
+	err = s.inTransaction(func(tx *sql.Tx) error {
+		for _, thread := range threads {
+			messages, err := readMessages(tx, thread.Name)
+			if err != nil {
+				return err
+			}
+
+			kept := pruneMessages(messages, cutoff, thread.Updated, true)
+			if len(kept) == len(messages) {
+				continue
+			}
+
+			result = append(result, PruneResult{Thread: thread.Name, Removed: len(messages) - len(kept), Kept: len(kept)})
+
+			if dryRun {
+				continue
+			}
+
+			if len(kept) == 0 {
+				err = deleteThread(tx, thread.Name)
+			} else {
+				err = writeMessages(tx, thread.Name, kept, time.Now())
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// MigrateFiles imports the threads of the JSON history, with their archives, into the database,
+// once. Threads that are already in the database are left alone. It returns the number of imported
+// threads.
+func (s *SQLStore) MigrateFiles(files *FileIO) (int, error) {
+	var imported int
+
+	err := s.inTransaction(func(tx *sql.Tx) error {
+		var done int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM migrations WHERE name = ?`, jsonMigration).Scan(&done); err != nil {
+			return err
+		}
+		if done > 0 {
+			return nil
+		}
+
+		// without a history directory there is nothing to import
+		threads, err := files.ListThreads()
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		for _, thread := range threads {
+			if exists, err := threadExists(tx, thread.Name); err != nil || exists {
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			messages, err := files.ReadArchived(thread.Name)
+			if err != nil {
+				return fmt.Errorf("failed to migrate thread %s: %w", thread.Name, err)
+			}
+
+			if err := writeMessages(tx, thread.Name, messages, thread.Updated); err != nil {
+				return err
+			}
+
+			if _, err := tx.Exec(`UPDATE threads SET created = ?, title = ?, tags = ?, archived = ? WHERE name = ?`, toUnixNano(thread.Created), thread.Title, strings.Join(thread.Tags, ","), thread.Archived, thread.Name); err != nil {
+				return err
+			}
+
+			if thread.Summary != nil {
+				data, err := json.Marshal(thread.Summary)
+				if err != nil {
+					return err
+				}
+
+				if _, err := tx.Exec(`UPDATE threads SET summary = ? WHERE name = ?`, string(data), thread.Name); err != nil {
+					return err
+				}
+			}
+			imported++
+		}
+
+		_, err = tx.Exec(`INSERT INTO migrations (name) VALUES (?)`, jsonMigration)
+		return err
+	})
+
+	return imported, err
+}
+
+func (s *SQLStore) inTransaction(fn func(*sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// queryer is what reading needs from a database or a transaction.
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func readMessages(db queryer, thread string) ([]types.Message, error) {
+	return queryMessages(db, `SELECT role, content, alternatives, timestamp, tokens, token_encoding, pinned, model
+		FROM messages WHERE thread = ? ORDER BY position`, thread)
+}
+
+// queryMessages returns the messages the query selects the columns of.
+func queryMessages(db queryer, query string, args ...interface{}) ([]types.Message, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []types.Message
+	for rows.Next() {
+		var (
+			message      types.Message
+			alternatives string
+			timestamp    int64
+		)
+
+		if err := rows.Scan(&message.Role, &message.Content, &alternatives, &timestamp, &message.Tokens, &message.TokenEncoding, &message.Pinned, &message.Model); err != nil {
+			return nil, err
+		}
+
+		if alternatives != "" {
+			if err := json.Unmarshal([]byte(alternatives), &message.Alternatives); err != nil {
+				return nil, err
+			}
+		}
+
+		message.Timestamp = fromUnixNano(timestamp)
+		message.Tokens, message.TokenEncoding = countTokens(message)
+		result = append(result, message)
+	}
+
+	return result, rows.Err()
+}
+
+// writeMessages replaces the messages of the thread and keeps its creation time.
+func writeMessages(tx *sql.Tx, thread string, messages []types.Message, updated time.Time) error {
+	if _, err := tx.Exec(`DELETE FROM messages WHERE thread = ?`, thread); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`INSERT INTO threads (name, created, updated) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET updated = excluded.updated`,
+		thread, toUnixNano(updated), toUnixNano(updated)); err != nil {
+		return err
+	}
+
+	for i, message := range messages {
+		var alternatives string
+		if len(message.Alternatives) > 0 {
+			data, err := json.Marshal(message.Alternatives)
+			if err != nil {
+				return err
+			}
+			alternatives = string(data)
+		}
+
+		tokens, encoding := countTokens(message)
+
+		if _, err := tx.Exec(`INSERT INTO messages (thread, position, role, content, alternatives, timestamp, tokens, token_encoding, pinned, model)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			thread, i, message.Role, message.Content, alternatives, toUnixNano(message.Timestamp), tokens, encoding, message.Pinned, message.Model); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func deleteThread(tx *sql.Tx, thread string) error {
+	if _, err := tx.Exec(`DELETE FROM messages WHERE thread = ?`, thread); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(`DELETE FROM threads WHERE name = ?`, thread)
+	return err
+}
+
+func threadExists(tx *sql.Tx, thread string) (bool, error) {
+	var count int
+	err := tx.QueryRow(`SELECT COUNT(*) FROM threads WHERE name = ?`, thread).Scan(&count)
+	return count > 0, err
+}
+
+func toUnixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.UnixNano()
+}
+
+func fromUnixNano(nanos int64) time.Time {
+	if nanos == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, nanos)
+}
@@ -0,0 +1,151 @@
+package history
+
+import (
+	"github.com/kardolus/chatgpt-cli/types"
+	"os"
+	"path/filepath"
+	"time"
+	"unicode/utf8"
+)
+
+// Stats describes the messages of a thread, or of all threads. The tokens are the stored counts of
+// the messages, the system prompts and questions count as prompt tokens and the answers as
+// completion tokens. First and Last are zero when none of the messages has a time, and Longest is
+// nil without messages. Cost is estimated by the client and nil when the price of the model is
+// unknown.
+type Stats struct {
+	Thread           string         `json:"thread,omitempty"`
+	Threads          int            `json:"threads"`
+	Messages         map[string]int `json:"messages"`
+	PromptTokens     int            `json:"prompt_tokens"`
+	CompletionTokens int            `json:"completion_tokens"`
+	Model            string         `json:"model,omitempty"`
+	Cost             *float64       `json:"estimated_cost,omitempty"`
+	First            time.Time      `json:"first_activity,omitempty"`
+	Last             time.Time      `json:"last_activity,omitempty"`
+	Longest          *MessageRef    `json:"longest_message,omitempty"`
+}
+
+// MessageRef points at a message by the thread it is in and its position, with its length in
+// characters. Archive is the number of the archive the message is in, zero for the thread itself.
+type MessageRef struct {
+	Thread     string `json:"thread"`
+	Archive    int    `json:"archive,omitempty"`
+	Index      int    `json:"index"`
+	Role       string `json:"role"`
+	Characters int    `json:"characters"`
+}
+
+// TotalMessages returns the number of messages of all roles.
+func (s Stats) TotalMessages() int {
+	total := 0
+	for _, count := range s.Messages {
+		total += count
+	}
+
+	return total
+}
+
+// statsBuilder adds up the messages one at a time, so the stores can stream them. The messages of
+// a thread are expected to be added together.
+type statsBuilder struct {
+	stats  Stats
+	dates  DateRange
+	thread string
+}
+
+func newStatsBuilder(thread string, dates DateRange) *statsBuilder {
+	return &statsBuilder{stats: Stats{Thread: thread, Messages: map[string]int{}}, dates: dates}
+}
+
+func (b *statsBuilder) add(ref MessageRef, message types.Message) {
+	tokens, _ := countTokens(message)
+	ref.Role, ref.Characters = message.Role, utf8.RuneCountInString(message.Content)
+	b.addCounted(ref, message.Timestamp, tokens)
+}
+
+// addCounted adds a message the store already counted, ref has its role and length.
+func (b *statsBuilder) addCounted(ref MessageRef, timestamp time.Time, tokens int) {
+	if !b.dates.Contains(timestamp) {
+		return
+	}
+
+	if ref.Thread != b.thread {
+		b.thread = ref.Thread
+		b.stats.Threads++
+	}
+
+	b.stats.Messages[ref.Role]++
+	if ref.Role == assistantRole {
+		b.stats.CompletionTokens += tokens
+	} else {
+		b.stats.PromptTokens += tokens
+	}
+
+	if !timestamp.IsZero() {
+		if b.stats.First.IsZero() || timestamp.Before(b.stats.First) {
+			b.stats.First = timestamp
+		}
+		if timestamp.After(b.stats.Last) {
+			b.stats.Last = timestamp
+		}
+	}
+
+	if b.stats.Longest == nil || ref.Characters > b.stats.Longest.Characters {
+		b.stats.Longest = &ref
+	}
+}
+
+// Stats adds up the messages of the thread, or of all threads when the thread is empty, that were
+// added in the date range, including the ones rotated out to the archives. The threads are decoded
+// one message at a time, so the history is never loaded as a whole.
+func (f *FileIO) Stats(thread string, dates DateRange) (Stats, error) {
+	builder := newStatsBuilder(thread, dates)
+
+	var threads []string
+	if thread != "" {
+		if err := ValidateThread(thread); err != nil {
+			return Stats{}, err
+		}
+		threads = []string{thread}
+	} else {
+		infos, err := f.ListThreads()
+		if os.IsNotExist(err) {
+			return builder.stats, nil
+		}
+		if err != nil {
+			return Stats{}, err
+		}
+
+		for _, info := range infos {
+			threads = append(threads, info.Name)
+		}
+	}
+
+	for _, name := range threads {
+		archives, err := f.listArchives(name)
+		if err != nil {
+			return Stats{}, err
+		}
+
+		add := func(archive int) func(int, types.Message) error {
+			return func(index int, message types.Message) error {
+				builder.add(MessageRef{Thread: name, Archive: archive, Index: index}, message)
+				return nil
+			}
+		}
+
+		for _, archive := range archives {
+			if err := f.eachMessage(f.archivePath(name, archive), add(archive)); err != nil {
+				return Stats{}, err
+			}
+		}
+
+		err = f.eachMessage(filepath.Join(f.historyDir, name+jsonExtension), add(0))
+		if err != nil && !os.IsNotExist(err) {
+			return Stats{}, err
+		}
+	}
+
+	return builder.stats, nil
+}
@@ -0,0 +1,95 @@
+package history_test
+
+import (
+	"github.com/kardolus/chatgpt-cli/history"
+	"github.com/kardolus/chatgpt-cli/types"
+	"github.com/kardolus/chatgpt-cli/utils"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitStats(t *testing.T) {
+	spec.Run(t, "Testing the history statistics", testStats, spec.Report(report.Terminal{}))
+}
+
+func testStats(t *testing.T, when spec.G, it spec.S) {
+	var store *history.MemoryStore
+
+	at := func(day int) time.Time {
+		return time.Date(2024, 6, day, 9, 0, 0, 0, time.UTC)
+	}
+
+	counted := func(message types.Message, tokens int) types.Message {
+		message.Tokens, message.TokenEncoding = tokens, utils.TokenEncoding
+		return message
+	}
+
+	it.Before(func() {
+		RegisterTestingT(t)
+		store = history.NewMemoryStore()
+
+		store.SetThread("main")
+		Expect(store.Write([]types.Message{
+			counted(types.Message{Role: "system", Content: "You are a helpful assistant."}, 6),
+			counted(types.Message{Role: "user", Content: "what is Go?", Timestamp: at(1)}, 4),
+			counted(types.Message{Role: "assistant", Content: "A programming language made at Google.", Timestamp: at(2)}, 8),
+		})).To(Succeed())
+
+		store.SetThread("other")
+		Expect(store.Write([]types.Message{
+			counted(types.Message{Role: "user", Content: "hi", Timestamp: at(10)}, 1),
+			counted(types.Message{Role: "assistant", Content: "hello", Timestamp: at(11)}, 2),
+		})).To(Succeed())
+	})
+
+	it("adds up the messages of a thread", func() {
+		stats, err := store.Stats("main", history.DateRange{})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(stats.Thread).To(Equal("main"))
+		Expect(stats.Threads).To(Equal(1))
+		Expect(stats.Messages).To(Equal(map[string]int{"system": 1, "user": 1, "assistant": 1}))
+		Expect(stats.TotalMessages()).To(Equal(3))
+		Expect(stats.PromptTokens).To(Equal(10))
+		Expect(stats.CompletionTokens).To(Equal(8))
+		Expect(stats.First).To(Equal(at(1)))
+		Expect(stats.Last).To(Equal(at(2)))
+		Expect(stats.Longest).To(Equal(&history.MessageRef{Thread: "main", Index: 2, Role: "assistant", Characters: 38}))
+	})
+
+	it("adds up the messages of all threads in the date range", func() {
+		stats, err := store.Stats("", history.DateRange{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stats.Threads).To(Equal(2))
+		Expect(stats.TotalMessages()).To(Equal(5))
+		Expect(stats.Last).To(Equal(at(11)))
+
+		stats, err = store.Stats("", history.DateRange{Since: at(2)})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stats.Threads).To(Equal(2))
+		Expect(stats.Messages).To(Equal(map[string]int{"user": 1, "assistant": 2}))
+		Expect(stats.First).To(Equal(at(2)))
+	})
+
+	it("counts the tokens of messages that were written without a count", func() {
+		store.SetThread("old")
+		Expect(store.Write([]types.Message{{Role: "user", Content: "a question from an older history"}})).To(Succeed())
+
+		stats, err := store.Stats("old", history.DateRange{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stats.PromptTokens).To(BeNumerically(">", 0))
+		Expect(stats.First).To(BeZero())
+	})
+
+	it("reports nothing for a thread without messages", func() {
+		stats, err := store.Stats("missing", history.DateRange{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stats.Threads).To(BeZero())
+		Expect(stats.TotalMessages()).To(BeZero())
+		Expect(stats.Longest).To(BeNil())
+	})
+}
@@ -2,32 +2,121 @@ package history
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/kardolus/chatgpt-cli/config"
 	"github.com/kardolus/chatgpt-cli/types"
 	"github.com/kardolus/chatgpt-cli/utils"
 	"os"
-	"path"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 const (
-	jsonExtension = ".json"
+	backupExtension = ".bak"
+	indexFile       = ".index.json"
+	jsonExtension   = ".json"
+
+	// dirPerm and filePerm keep the history private to its owner, wherever it is stored.
+	dirPerm  = 0700
+	filePerm = 0600
 )
 
 type HistoryStore interface {
 	Read() ([]types.Message, error)
 	ReadThread(string) ([]types.Message, error)
+	ReadPage(thread string, offset, limit int) ([]types.Message, int, error)
 	Write([]types.Message) error
+	Update(func([]types.Message) []types.Message) error
 	SetThread(string)
 	GetThread() string
+	ListThreads() ([]ThreadInfo, error)
+	Clear(string) error
+	DeleteThread(string) error
+	RenameThread(from, to string) error
+	MergeThreads(dst, src string, strategy MergeStrategy) (MergeResult, error)
+	Search(SearchOptions, func(SearchMatch) error) error
+	Stats(thread string, dates DateRange) (Stats, error)
+	Prune(olderThan time.Duration, dryRun bool) ([]PruneResult, error)
+	DeleteMessages(thread string, indices []int, pairs bool) (DeleteResult, error)
+	SetSummary(thread string, summary types.Summary) error
+	SetTitle(thread, title string) error
+	SetTags(thread string, tags []string) error
+	SetArchived(thread string, archived bool) error
+	SetPinned(thread string, indices []int, pinned bool) error
+}
+
+// ThreadInfo describes a stored thread without its messages. Title is empty until the thread is
+// given one, Tags are normalized and sorted, and Summary is only set once the thread was summarized.
+// Archived threads are finished conversations that are kept, but left out of the listings by
+// default.
+type ThreadInfo struct {
+	Name     string         `json:"name"`
+	Title    string         `json:"title,omitempty"`
+	Tags     []string       `json:"tags,omitempty"`
+	Created  time.Time      `json:"created"`
+	Updated  time.Time      `json:"updated"`
+	Messages int            `json:"messages"`
+	Summary  *types.Summary `json:"summary,omitempty"`
+	Archived bool           `json:"archived,omitempty"`
+}
+
+// FindThread returns the description of an existing thread.
+func FindThread(store HistoryStore, thread string) (ThreadInfo, error) {
+	threads, err := store.ListThreads()
+	if err != nil {
+		return ThreadInfo{}, err
+	}
+
+	for _, info := range threads {
+		if info.Name == thread {
+			return info, nil
+		}
+	}
+
+	return ThreadInfo{}, fmt.Errorf("thread %s does not exist", thread)
+}
+
+// FilterArchived returns the threads that aren't archived, all threads with archived.
+func FilterArchived(threads []ThreadInfo, archived bool) []ThreadInfo {
+	if archived {
+		return threads
+	}
+
+	var result []ThreadInfo
+	for _, thread := range threads {
+		if !thread.Archived {
+			result = append(result, thread)
+		}
+	}
+
+	return result
+}
+
+// indexEntry is what the index keeps per thread, the update time comes from the file itself.
+type indexEntry struct {
+	Created  time.Time      `json:"created"`
+	Messages int            `json:"messages"`
+	Title    string         `json:"title,omitempty"`
+	Tags     []string       `json:"tags,omitempty"`
+	Summary  *types.Summary `json:"summary,omitempty"`
+	Archived bool           `json:"archived,omitempty"`
 }
 
 // Ensure FileIO implements the HistoryStore interface
 var _ HistoryStore = &FileIO{}
 
 type FileIO struct {
-	historyDir string
-	thread     string
+	historyDir  string
+	thread      string
+	lockTimeout time.Duration
+	encryption  *encryption
+	maxMessages int
+	maxBytes    int
+	recovery    func(RepairResult)
 }
 
 func New() (*FileIO, error) {
@@ -38,6 +127,11 @@ func New() (*FileIO, error) {
 		return nil, err
 	}
 
+	// a location that was set explicitly is created, the default one only along with the config home
+	if os.Getenv(utils.DataHomeEnv) != "" {
+		return NewInDirectory(dir)
+	}
+
 	chatGPTDir, err := utils.GetConfigHome()
 	if err != nil {
 		return nil, err
@@ -46,15 +140,31 @@ func New() (*FileIO, error) {
 	fileInfo, err := os.Stat(chatGPTDir)
 	if err == nil {
 		if fileInfo.IsDir() {
-			err = os.MkdirAll(dir, 0755)
+			err = os.MkdirAll(dir, dirPerm)
 		}
 	}
 
 	return &FileIO{
-		historyDir: dir,
+		historyDir:  dir,
+		lockTimeout: DefaultLockTimeout,
 	}, err
 }
 
+// NewInDirectory returns a store that keeps the history in dir instead of the data home. A leading ~
+// is expanded to the home directory and relative paths are resolved against the working directory.
+// The directory is created, with its missing parents, readable by the user only.
+func NewInDirectory(dir string) (*FileIO, error) {
+	dir, err := createDirectory(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileIO{
+		historyDir:  dir,
+		lockTimeout: DefaultLockTimeout,
+	}, nil
+}
+
 func (f *FileIO) GetThread() string {
 	return f.thread
 }
@@ -68,25 +178,469 @@ func (f *FileIO) WithDirectory(historyDir string) *FileIO {
 	return f
 }
 
+// Read returns the messages of the current thread. A thread that does not exist yet has no messages.
 func (f *FileIO) Read() ([]types.Message, error) {
-	return parseFile(f.getPath(f.thread))
+	return f.ReadThread(f.thread)
 }
 
+// ReadThread returns the messages of the given thread. A thread that does not exist yet has no
+// messages.
 func (f *FileIO) ReadThread(thread string) ([]types.Message, error) {
-	return parseFile(f.getPath(thread))
+	path, err := f.getPath(thread)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		result  []types.Message
+		version int
+	)
+	err = f.withLock(false, func() error {
+		result, version, err = f.parseThreadFile(path)
+		return err
+	})
+
+	if err != nil && f.recovery != nil && isDamage(err) {
+		return f.recoverThread(thread, path)
+	}
+
+	if err == nil && result != nil && version < SchemaVersion {
+		f.upgrade(path)
+	}
+
+	return result, err
 }
 
+// upgrade writes a thread kept in an older schema version back in the current one. The thread was
+// read already, so it is left in the older version when it can't be written.
+func (f *FileIO) upgrade(path string) {
+	_ = f.withLock(true, func() error {
+		messages, version, err := f.parseFile(path)
+		if err != nil || messages == nil || version >= SchemaVersion {
+			return err
+		}
+
+		return f.writeMessages(path, messages)
+	})
+}
+
+// Write replaces the messages of the current thread.
 func (f *FileIO) Write(messages []types.Message) error {
-	data, err := json.Marshal(messages)
+	return f.withLock(true, func() error {
+		return f.write(f.thread, messages)
+	})
+}
+
+// Update reads the current thread, passes its messages to fn and writes what fn returns, holding
+// the lock for the whole cycle so concurrent updates from other processes are not lost.
+func (f *FileIO) Update(fn func([]types.Message) []types.Message) error {
+	path, err := f.getPath(f.thread)
 	if err != nil {
 		return err
 	}
 
-	return os.WriteFile(f.getPath(f.thread), data, 0644)
+	return f.withLock(true, func() error {
+		messages, _, err := f.parseThreadFile(path)
+		if err != nil {
+			return err
+		}
+
+		return f.write(f.thread, fn(messages))
+	})
 }
 
-func (f *FileIO) getPath(thread string) string {
-	return filepath.Join(f.historyDir, thread+jsonExtension)
+func (f *FileIO) write(thread string, messages []types.Message) error {
+	path, err := f.getPath(thread)
+	if err != nil {
+		return err
+	}
+
+	messages, archived, err := f.split(messages)
+	if err != nil {
+		return err
+	}
+
+	// the archive is written first, so an interrupted rotation leaves the archived messages in both
+	// places rather than in neither
+	if len(archived) > 0 {
+		if err := f.writeArchive(thread, archived); err != nil {
+			return fmt.Errorf("failed to archive the oldest messages: %w", err)
+		}
+	}
+
+	data, err := encodeMessages(messages)
+	if err != nil {
+		return err
+	}
+
+	if data, err = f.encrypt(data); err != nil {
+		return err
+	}
+
+	// threads written by older versions are not in the index, their file is the best creation time
+	created := time.Now()
+	if fileInfo, err := os.Stat(path); err == nil {
+		created = fileInfo.ModTime()
+	}
+
+	if err := utils.WriteFileAtomic(path, data, filePerm); err != nil {
+		return err
+	}
+
+	// the backup is what Read falls back to when the thread file gets damaged by something else
+	_ = utils.WriteFileAtomic(path+backupExtension, data, filePerm)
+
+	// the index only speeds up listing, ListThreads falls back to the files when it is out of date
+	_ = f.updateIndex(func(index map[string]indexEntry) {
+		entry, ok := index[thread]
+		if !ok {
+			entry.Created = created
+		}
+		entry.Messages = len(messages)
+		index[thread] = entry
+	})
+
+	return nil
+}
+
+// DeleteMessages removes the messages at the given positions from the thread and rewrites it, along
+// with its backup, in a single step. System prompts can't be deleted. With pairs a question is
+// removed along with its answer.
+func (f *FileIO) DeleteMessages(thread string, indices []int, pairs bool) (DeleteResult, error) {
+	path, err := f.getPath(thread)
+	if err != nil {
+		return DeleteResult{}, err
+	}
+
+	var result DeleteResult
+	err = f.withLock(true, func() error {
+		messages, _, err := f.parseThreadFile(path)
+		if err != nil {
+			return err
+		}
+
+		var kept []types.Message
+		if kept, result, err = deleteMessages(messages, indices, pairs); err != nil {
+			return err
+		}
+
+		if len(kept) == 0 {
+			return f.clear(thread, path)
+		}
+
+		return f.write(thread, kept)
+	})
+
+	return result, err
+}
+
+// SetSummary keeps the summary of the thread, which ListThreads returns from then on. The summary is
+// kept in the index, next to the message count.
+func (f *FileIO) SetSummary(thread string, summary types.Summary) error {
+	return f.updateEntry(thread, func(entry *indexEntry) {
+		entry.Summary = &summary
+	})
+}
+
+// SetTitle gives the thread a title, which ListThreads returns from then on. Like the summary the
+// title is kept in the index.
+func (f *FileIO) SetTitle(thread, title string) error {
+	return f.updateEntry(thread, func(entry *indexEntry) {
+		entry.Title = title
+	})
+}
+
+// SetTags replaces the tags of the thread, which are kept in the index like the title.
+func (f *FileIO) SetTags(thread string, tags []string) error {
+	tags, err := NormalizeTags(tags)
+	if err != nil {
+		return err
+	}
+
+	return f.updateEntry(thread, func(entry *indexEntry) {
+		entry.Tags = tags
+	})
+}
+
+// SetArchived archives or unarchives the thread, which is kept in the index like the title. This
+// has nothing to do with the archives the messages are rotated into.
+func (f *FileIO) SetArchived(thread string, archived bool) error {
+	return f.updateEntry(thread, func(entry *indexEntry) {
+		entry.Archived = archived
+	})
+}
+
+// updateEntry changes the index entry of an existing thread, the entry is created for threads that
+// are missing from the index.
+func (f *FileIO) updateEntry(thread string, update func(*indexEntry)) error {
+	path, err := f.getPath(thread)
+	if err != nil {
+		return err
+	}
+
+	return f.withLock(true, func() error {
+		fileInfo, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			return fmt.Errorf("thread %s does not exist", thread)
+		}
+		if err != nil {
+			return err
+		}
+
+		index, err := f.readIndexForUpdate()
+		if err != nil {
+			return err
+		}
+
+		entry, ok := index[thread]
+		if !ok {
+			entry = indexEntry{Created: fileInfo.ModTime(), Messages: f.countMessages(path)}
+		}
+		update(&entry)
+		index[thread] = entry
+
+		return f.writeIndex(index)
+	})
+}
+
+// ListThreads returns the stored threads sorted by name. The message counts and creation times
+// come from a small index that is kept up to date by Write, so the threads are not loaded. Threads
+// that are missing from the index are counted from their file instead.
+func (f *FileIO) ListThreads() ([]ThreadInfo, error) {
+	entries, err := os.ReadDir(f.historyDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var index map[string]indexEntry
+	_ = f.withLock(false, func() error {
+		index, _ = f.readIndex()
+		return nil
+	})
+
+	var result []ThreadInfo
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != jsonExtension || entry.Name() == indexFile {
+			continue
+		}
+
+		fileInfo, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+
+		info := ThreadInfo{
+			Name:    strings.TrimSuffix(entry.Name(), jsonExtension),
+			Created: fileInfo.ModTime(),
+			Updated: fileInfo.ModTime(),
+		}
+
+		if indexed, ok := index[info.Name]; ok {
+			info.Created = indexed.Created
+			info.Messages = indexed.Messages
+			info.Title = indexed.Title
+			info.Tags = indexed.Tags
+			info.Summary = indexed.Summary
+			info.Archived = indexed.Archived
+		} else {
+			info.Messages = f.countMessages(filepath.Join(f.historyDir, entry.Name()))
+		}
+
+		result = append(result, info)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result, nil
+}
+
+// Clear removes the messages of the thread, along with its archives, in a single step, so a cleared
+// thread is either intact or gone. Clearing a thread without history is not an error, and the
+// current thread stays the same.
+func (f *FileIO) Clear(thread string) error {
+	path, err := f.getPath(thread)
+	if err != nil {
+		return err
+	}
+
+	return f.withLock(true, func() error {
+		return f.clear(thread, path)
+	})
+}
+
+func (f *FileIO) clear(thread, path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	_ = os.Remove(path + backupExtension)
+
+	_ = f.updateIndex(func(index map[string]indexEntry) {
+		delete(index, thread)
+	})
+
+	return f.removeArchives(thread)
+}
+
+// DeleteThread removes the thread. Deleting a thread that does not exist is not an error. When the
+// current thread is deleted the store falls back to the default thread.
+func (f *FileIO) DeleteThread(thread string) error {
+	if err := f.Clear(thread); err != nil {
+		return err
+	}
+
+	if thread == f.thread {
+		f.thread = config.New().ReadDefaults().Thread
+	}
+
+	return nil
+}
+
+// RenameThread renames the thread from to to, which must not exist yet. When the current thread is
+// renamed the store follows it.
+func (f *FileIO) RenameThread(from, to string) error {
+	source, err := f.getPath(from)
+	if err != nil {
+		return err
+	}
+
+	target, err := f.getPath(to)
+	if err != nil {
+		return err
+	}
+
+	err = f.withLock(true, func() error {
+		if _, err := os.Stat(source); err != nil {
+			if os.IsNotExist(err) {
+				return fmt.Errorf("thread %s does not exist", from)
+			}
+			return err
+		}
+
+		if _, err := os.Stat(target); err == nil {
+			return fmt.Errorf("thread %s already exists", to)
+		}
+
+		if err := os.Rename(source, target); err != nil {
+			return err
+		}
+		_ = os.Rename(source+backupExtension, target+backupExtension)
+
+		_ = f.updateIndex(func(index map[string]indexEntry) {
+			if entry, ok := index[from]; ok {
+				delete(index, from)
+				index[to] = entry
+			}
+		})
+
+		return f.renameArchives(from, to)
+	})
+	if err != nil {
+		return err
+	}
+
+	if from == f.thread {
+		f.thread = to
+	}
+
+	return nil
+}
+
+func (f *FileIO) readIndex() (map[string]indexEntry, error) {
+	data, err := os.ReadFile(filepath.Join(f.historyDir, indexFile))
+	if os.IsNotExist(err) {
+		return make(map[string]indexEntry), nil
+	}
+	if err != nil {
+		return make(map[string]indexEntry), err
+	}
+
+	return f.decodeIndex(data)
+}
+
+// readIndexForUpdate returns the index to update. A damaged index is replaced, but not one that
+// can't be decrypted, which would lose the titles and the summaries of the other threads.
+func (f *FileIO) readIndexForUpdate() (map[string]indexEntry, error) {
+	index, err := f.readIndex()
+	if errors.Is(err, ErrEncrypted) || errors.Is(err, ErrWrongKey) {
+		return nil, err
+	}
+
+	return index, nil
+}
+
+func (f *FileIO) updateIndex(update func(map[string]indexEntry)) error {
+	index, err := f.readIndexForUpdate()
+	if err != nil {
+		return err
+	}
+
+	update(index)
+
+	return f.writeIndex(index)
+}
+
+func (f *FileIO) writeIndex(index map[string]indexEntry) error {
+	data, err := f.encodeIndex(index)
+	if err != nil {
+		return err
+	}
+
+	return utils.WriteFileAtomic(filepath.Join(f.historyDir, indexFile), data, filePerm)
+}
+
+// encodeIndex returns the index as it is written, encrypted when the store has a passphrase, as the
+// summaries and the titles tell what the threads are about.
+func (f *FileIO) encodeIndex(index map[string]indexEntry) ([]byte, error) {
+	data, err := json.Marshal(index)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.encrypt(data)
+}
+
+// decodeIndex returns the index of the data, decrypted when it is encrypted. No data is an empty
+// index.
+func (f *FileIO) decodeIndex(data []byte) (map[string]indexEntry, error) {
+	index := make(map[string]indexEntry)
+	if len(data) == 0 {
+		return index, nil
+	}
+
+	data, err := f.decrypt(data)
+	if err != nil {
+		return index, err
+	}
+
+	if err := json.Unmarshal(data, &index); err != nil {
+		return make(map[string]indexEntry), err
+	}
+
+	return index, nil
+}
+
+func (f *FileIO) getPath(thread string) (string, error) {
+	if err := ValidateThread(thread); err != nil {
+		return "", err
+	}
+
+	return filepath.Join(f.historyDir, thread+jsonExtension), nil
+}
+
+// ValidateThread returns an error for thread names that can't be used as a file name in the
+// history directory, such as empty names, names with path separators and names starting with a dot.
+func ValidateThread(thread string) error {
+	if thread == "" {
+		return fmt.Errorf("invalid thread name, the name can't be empty")
+	}
+
+	if strings.ContainsAny(thread, `/\`) || strings.HasPrefix(thread, ".") {
+		return fmt.Errorf("invalid thread name %q, the name can't contain path separators or start with a dot", thread)
+	}
+
+	return nil
 }
 
 // migrate moves the legacy "history" file in ~/.chatgpt-cli to "history/default.json"
@@ -110,17 +664,17 @@ func migrate() error {
 		defaults := config.New().ReadDefaults()
 
 		// move the legacy "history" file to "default.json"
-		if err := os.Rename(historyFile, path.Join(hiddenDir, defaults.Thread+jsonExtension)); err != nil {
+		if err := os.Rename(historyFile, filepath.Join(hiddenDir, defaults.Thread+jsonExtension)); err != nil {
 			return err
 		}
 
 		// create the "history" directory
-		if err := os.Mkdir(historyFile, 0755); err != nil {
+		if err := os.Mkdir(historyFile, dirPerm); err != nil {
 			return err
 		}
 
 		// move default.json to the "history" directory
-		if err := os.Rename(path.Join(hiddenDir, defaults.Thread+jsonExtension), path.Join(historyFile, defaults.Thread+jsonExtension)); err != nil {
+		if err := os.Rename(filepath.Join(hiddenDir, defaults.Thread+jsonExtension), filepath.Join(historyFile, defaults.Thread+jsonExtension)); err != nil {
 			return err
 		}
 	}
@@ -128,17 +682,162 @@ func migrate() error {
 	return nil
 }
 
-func parseFile(fileName string) ([]types.Message, error) {
-	var result []types.Message
+// countMessages decodes the messages of a thread one at a time, so large threads are never held in
+// memory. Files that can't be decoded count as empty.
+func (f *FileIO) countMessages(fileName string) int {
+	file, err := f.openThread(fileName)
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	if !openMessages(decoder) {
+		return 0
+	}
+
+	var result int
+	for decoder.More() {
+		var message json.RawMessage
+		if err := decoder.Decode(&message); err != nil {
+			return result
+		}
+		result++
+	}
+
+	return result
+}
+
+// parseThreadFile parses the thread file and falls back to its backup when the file can't be
+// decoded, for instance when it was truncated. The original error is returned when the backup
+// can't be used either. It returns the schema version of the file the messages were read from.
+func (f *FileIO) parseThreadFile(fileName string) ([]types.Message, int, error) {
+	result, version, err := f.parseFile(fileName)
+	if err == nil {
+		return result, version, nil
+	}
+
+	if !isDamage(err) {
+		return nil, version, err
+	}
 
-	buf, err := os.ReadFile(fileName)
+	backup, backupVersion, backupErr := f.parseFile(fileName + backupExtension)
+	if backupErr != nil || backup == nil {
+		return nil, version, err
+	}
+
+	return backup, backupVersion, nil
+}
+
+// createDirectory expands the directory to an absolute path and creates it when it is missing.
+func createDirectory(dir string) (string, error) {
+	dir, err := utils.ExpandPath(dir)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
-	if err := json.Unmarshal(buf, &result); err != nil {
-		return nil, err
+	if err := os.MkdirAll(dir, dirPerm); err != nil {
+		return "", fmt.Errorf("failed to create the history directory: %w", err)
 	}
 
-	return result, nil
+	return dir, nil
+}
+
+// parseFile parses a history file of any schema version up to the current one, and returns its
+// messages along with the version of the file. A file that does not exist has no messages.
+func (f *FileIO) parseFile(fileName string) ([]types.Message, int, error) {
+	buf, err := f.readThreadData(fileName)
+	if os.IsNotExist(err) {
+		return nil, SchemaVersion, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// the history is written as valid UTF-8, anything else was damaged after it was written
+	if !utf8.Valid(buf) {
+		return nil, 0, errInvalidUTF8
+	}
+
+	result, version, err := decodeMessages(buf)
+	if errors.Is(err, ErrNewerSchema) {
+		return nil, version, fmt.Errorf("%s: %w", fileName, err)
+	}
+	if err != nil {
+		return nil, version, err
+	}
+
+	return fromStored(result), version, nil
+}
+
+// storedMessage is how a message is kept in the history files and exports, with the bookkeeping
+// that types.Message leaves out of its wire form. Timestamp is a pointer so messages without one
+// are stored as they were before.
+type storedMessage struct {
+	types.Message
+	Timestamp     *time.Time `json:"timestamp,omitempty"`
+	Tokens        int        `json:"tokens,omitempty"`
+	TokenEncoding string     `json:"token_encoding,omitempty"`
+	Pinned        bool       `json:"pinned,omitempty"`
+	Model         string     `json:"model,omitempty"`
+}
+
+func toStored(messages []types.Message) []storedMessage {
+	if messages == nil {
+		return nil
+	}
+
+	result := make([]storedMessage, len(messages))
+	for i, message := range messages {
+		result[i].Message = message
+		result[i].Tokens, result[i].TokenEncoding = countTokens(message)
+		result[i].Pinned = message.Pinned
+		result[i].Model = message.Model
+		if !message.Timestamp.IsZero() {
+			timestamp := message.Timestamp
+			result[i].Timestamp = &timestamp
+		}
+	}
+
+	return result
+}
+
+func fromStored(messages []storedMessage) []types.Message {
+	if messages == nil {
+		return nil
+	}
+
+	result := make([]types.Message, len(messages))
+	for i, message := range messages {
+		result[i] = message.toMessage()
+	}
+
+	return result
+}
+
+func (s storedMessage) toMessage() types.Message {
+	result := s.Message
+	result.Tokens = s.Tokens
+	result.TokenEncoding = s.TokenEncoding
+	result.Pinned = s.Pinned
+	result.Model = s.Model
+	if s.Timestamp != nil {
+		result.Timestamp = *s.Timestamp
+	}
+
+	// messages from older histories, or counted with another encoding, are counted on read and
+	// stored with their count on the next write
+	result.Tokens, result.TokenEncoding = countTokens(result)
+
+	return result
+}
+
+// countTokens returns the recorded token count of the message, or counts it when the count is
+// missing or was made with another encoding.
+func countTokens(message types.Message) (int, string) {
+	if message.TokenEncoding == utils.TokenEncoding {
+		return message.Tokens, message.TokenEncoding
+	}
+
+	return utils.EstimateTokens(message.Content), utils.TokenEncoding
 }
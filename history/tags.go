@@ -0,0 +1,136 @@
+package history
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// MaxTags is how many tags a thread can have.
+	MaxTags = 16
+	// MaxTagLength is how many characters a tag can have.
+	MaxTagLength = 32
+)
+
+// NormalizeTag returns the tag trimmed and in lowercase, so "Work " and "work" are the same tag. Tags
+// can't be empty, longer than MaxTagLength or contain spaces or commas.
+func NormalizeTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+
+	if tag == "" {
+		return "", fmt.Errorf("invalid tag, the tag can't be empty")
+	}
+
+	if utf8.RuneCountInString(tag) > MaxTagLength {
+		return "", fmt.Errorf("invalid tag %q, tags can have up to %d characters", tag, MaxTagLength)
+	}
+
+	if strings.ContainsFunc(tag, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+		return "", fmt.Errorf("invalid tag %q, tags can't contain spaces or commas", tag)
+	}
+
+	return tag, nil
+}
+
+// NormalizeTags normalizes the tags and returns them sorted, without duplicates.
+func NormalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool)
+
+	var result []string
+	for _, tag := range tags {
+		normalized, err := NormalizeTag(tag)
+		if err != nil {
+			return nil, err
+		}
+
+		if !seen[normalized] {
+			seen[normalized] = true
+			result = append(result, normalized)
+		}
+	}
+
+	if len(result) > MaxTags {
+		return nil, fmt.Errorf("too many tags, a thread can have up to %d tags", MaxTags)
+	}
+
+	sort.Strings(result)
+	return result, nil
+}
+
+// HasTag reports whether the thread has the tag, which is expected to be normalized.
+func (t ThreadInfo) HasTag(tag string) bool {
+	for _, own := range t.Tags {
+		if own == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// FilterByTag returns the threads that have the tag, all threads when the tag is empty.
+func FilterByTag(threads []ThreadInfo, tag string) []ThreadInfo {
+	if tag == "" {
+		return threads
+	}
+
+	var result []ThreadInfo
+	for _, thread := range threads {
+		if thread.HasTag(tag) {
+			result = append(result, thread)
+		}
+	}
+
+	return result
+}
+
+// AddTags adds the tags to the thread, which has to exist, and returns all of its tags.
+func AddTags(store HistoryStore, thread string, tags []string) ([]string, error) {
+	existing, err := ThreadTags(store, thread)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := NormalizeTags(append(existing, tags...))
+	if err != nil {
+		return nil, err
+	}
+
+	return result, store.SetTags(thread, result)
+}
+
+// RemoveTags removes the tags from the thread and returns the ones it still has. Removing a tag the
+// thread doesn't have is not an error.
+func RemoveTags(store HistoryStore, thread string, tags []string) ([]string, error) {
+	existing, err := ThreadTags(store, thread)
+	if err != nil {
+		return nil, err
+	}
+
+	removed, err := NormalizeTags(tags)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for _, tag := range existing {
+		if !(ThreadInfo{Tags: removed}).HasTag(tag) {
+			result = append(result, tag)
+		}
+	}
+
+	return result, store.SetTags(thread, result)
+}
+
+// ThreadTags returns the tags of an existing thread.
+func ThreadTags(store HistoryStore, thread string) ([]string, error) {
+	info, err := FindThread(store, thread)
+	if err != nil {
+		return nil, err
+	}
+
+	return info.Tags, nil
+}
@@ -0,0 +1,83 @@
+package history_test
+
+import (
+	"github.com/kardolus/chatgpt-cli/history"
+	"github.com/kardolus/chatgpt-cli/types"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitTags(t *testing.T) {
+	spec.Run(t, "Testing the tags of threads", testTags, spec.Report(report.Terminal{}))
+}
+
+func testTags(t *testing.T, when spec.G, it spec.S) {
+	var store *history.MemoryStore
+
+	it.Before(func() {
+		RegisterTestingT(t)
+
+		store = history.NewMemoryStore()
+		for _, thread := range []string{"default", "pgbouncer", "holidays"} {
+			store.SetThread(thread)
+			Expect(store.Write([]types.Message{{Role: "user", Content: "timeouts in " + thread}})).To(Succeed())
+		}
+	})
+
+	when("NormalizeTags()", func() {
+		it("trims, lowercases, sorts and deduplicates the tags", func() {
+			Expect(history.NormalizeTags([]string{" Work", "golang", "work "})).To(Equal([]string{"golang", "work"}))
+		})
+
+		it("refuses empty, long and too many tags", func() {
+			for _, tag := range []string{"", "  ", "two words", "a,b", strings.Repeat("x", history.MaxTagLength+1)} {
+				_, err := history.NormalizeTag(tag)
+				Expect(err).To(HaveOccurred(), tag)
+			}
+
+			var tags []string
+			for i := 0; i <= history.MaxTags; i++ {
+				tags = append(tags, strings.Repeat("t", i+1))
+			}
+			_, err := history.NormalizeTags(tags)
+			Expect(err).To(MatchError("too many tags, a thread can have up to 16 tags"))
+		})
+	})
+
+	it("adds and removes the tags of a thread", func() {
+		Expect(history.AddTags(store, "pgbouncer", []string{"Work", "postgres"})).To(Equal([]string{"postgres", "work"}))
+		Expect(history.AddTags(store, "pgbouncer", []string{"work", "golang"})).To(Equal([]string{"golang", "postgres", "work"}))
+		Expect(history.RemoveTags(store, "pgbouncer", []string{"POSTGRES", "unknown"})).To(Equal([]string{"golang", "work"}))
+
+		_, err := history.AddTags(store, "missing", []string{"work"})
+		Expect(err).To(MatchError("thread missing does not exist"))
+	})
+
+	it("filters the threads and the search by tag", func() {
+		_, err := history.AddTags(store, "pgbouncer", []string{"work"})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = history.AddTags(store, "default", []string{"work", "golang"})
+		Expect(err).NotTo(HaveOccurred())
+
+		threads, err := store.ListThreads()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(history.FilterByTag(threads, "")).To(HaveLen(3))
+
+		var names []string
+		for _, thread := range history.FilterByTag(threads, "work") {
+			names = append(names, thread.Name)
+		}
+		Expect(names).To(Equal([]string{"default", "pgbouncer"}))
+
+		var found []string
+		Expect(store.Search(history.SearchOptions{Term: "timeouts", Tag: "golang"}, func(match history.SearchMatch) error {
+			found = append(found, match.Thread)
+			return nil
+		})).To(Succeed())
+		Expect(found).To(Equal([]string{"default"}))
+	})
+}
@@ -0,0 +1,211 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/clock"
+	"github.com/kardolus/chatgpt-cli/types"
+	"github.com/kardolus/chatgpt-cli/utils"
+	"sync"
+	"time"
+)
+
+// DefaultCircuitCooldown is how long a circuit stays open when the configuration sets no cooldown.
+const DefaultCircuitCooldown = 30 * time.Second
+
+// The states of a circuit.
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// ErrCircuitOpen is the kind of the errors of the requests a CircuitBreaker fails fast.
+var ErrCircuitOpen = errors.New("circuit open")
+
+var (
+	breakersMu sync.Mutex
+	// breakers are shared by the callers of the same settings, so the failures of a caller open
+	// the circuit for the callers that come after it
+	breakers = map[breakerKey]*CircuitBreaker{}
+)
+
+type breakerKey struct {
+	threshold int
+	cooldown  time.Duration
+}
+
+// CircuitOpenError is a request the circuit breaker failed without sending it, Remaining is how
+// long the circuit stays open, 0 while a probe request is in flight.
+type CircuitOpenError struct {
+	Host      string
+	Failures  int
+	Remaining time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	if e.Remaining <= 0 {
+		return fmt.Sprintf("circuit open for %s after %d failures in a row, waiting for a probe request", e.Host, e.Failures)
+	}
+
+	return fmt.Sprintf("circuit open for %s after %d failures in a row, retry in %s", e.Host, e.Failures, e.Remaining.Round(time.Second))
+}
+
+func (e *CircuitOpenError) Unwrap() error {
+	return ErrCircuitOpen
+}
+
+// CircuitBreaker fails the requests to a host fast once Threshold requests to it failed in a row,
+// for Cooldown, rather than sending requests that are bound to fail. Once the cooldown is over a
+// single probe request is sent, which closes the circuit when the API answers it and opens it again
+// for another cooldown otherwise. Only the responses of the server errors and the requests that
+// failed to get a response count as failures, any other response, such as an authentication error,
+// tells that the API is up. It is safe for concurrent use.
+type CircuitBreaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	clock    clock.Clock
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+type circuit struct {
+	failures int
+	until    time.Time
+	probing  bool
+}
+
+// NewCircuitBreaker returns a breaker that opens after threshold failures in a row, for cooldown.
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		Threshold: threshold,
+		Cooldown:  cooldown,
+		clock:     clock.Real,
+		circuits:  make(map[string]*circuit),
+	}
+}
+
+// CircuitBreakerOf returns the breaker of the configuration, which opens after
+// circuit_breaker_threshold failures in a row for circuit_breaker_cooldown, or nil when the
+// threshold is 0. The callers of the same settings share their breaker.
+func CircuitBreakerOf(cfg types.Config) (*CircuitBreaker, error) {
+	if cfg.CircuitBreakerThreshold <= 0 {
+		return nil, nil
+	}
+
+	cooldown := DefaultCircuitCooldown
+	if cfg.CircuitBreakerCooldown != "" {
+		var err error
+		if cooldown, err = utils.ParseDuration(cfg.CircuitBreakerCooldown); err != nil {
+			return nil, fmt.Errorf("invalid circuit_breaker_cooldown: %w", err)
+		}
+	}
+
+	return sharedBreaker(cfg.CircuitBreakerThreshold, cooldown), nil
+}
+
+// WithClock replaces the clock the cooldown is measured with.
+func (b *CircuitBreaker) WithClock(clock clock.Clock) *CircuitBreaker {
+	b.clock = clock
+	return b
+}
+
+// WithCircuitBreaker fails the requests fast while the circuit of their host is open.
+func (r *RestCaller) WithCircuitBreaker(breaker *CircuitBreaker) *RestCaller {
+	r.breaker = breaker
+	return r
+}
+
+// State returns the state of the circuit of the host, CircuitClosed, CircuitOpen or
+// CircuitHalfOpen, which is when the cooldown is over and the probe request is due or in flight.
+func (b *CircuitBreaker) State(host string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c, ok := b.circuits[host]
+	switch {
+	case !ok || c.failures < b.Threshold:
+		return CircuitClosed
+	case c.probing || !b.clock.Now().Before(c.until):
+		return CircuitHalfOpen
+	default:
+		return CircuitOpen
+	}
+}
+
+// allow returns a *CircuitOpenError when the request to the host is failed fast. Once the cooldown
+// is over the first request is let through as the probe.
+func (b *CircuitBreaker) allow(host string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c, ok := b.circuits[host]
+	if !ok || c.failures < b.Threshold {
+		return nil
+	}
+
+	if remaining := c.until.Sub(b.clock.Now()); remaining > 0 || c.probing {
+		return &CircuitOpenError{Host: host, Failures: c.failures, Remaining: max(remaining, 0)}
+	}
+
+	c.probing = true
+	return nil
+}
+
+// record counts the outcome of a request to the host that was let through.
+func (b *CircuitBreaker) record(host string, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c, ok := b.circuits[host]
+	if !ok {
+		c = &circuit{}
+		b.circuits[host] = c
+	}
+
+	// a canceled request tells nothing about the API, a probe that was canceled is due again
+	if errors.Is(err, context.Canceled) {
+		c.probing = false
+		return
+	}
+
+	if !isUpstreamFailure(err) {
+		delete(b.circuits, host)
+		return
+	}
+
+	c.failures++
+	c.probing = false
+	if c.failures >= b.Threshold {
+		c.until = b.clock.Now().Add(b.Cooldown)
+	}
+}
+
+// isUpstreamFailure reports whether the request failed because of the API rather than because of
+// what was asked, the server errors and the requests that got no response.
+func isUpstreamFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	status := StatusOf(err)
+	return status == 0 || status >= 500
+}
+
+// sharedBreaker returns the breaker of the settings, which the callers that come after share.
+func sharedBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+
+	key := breakerKey{threshold: threshold, cooldown: cooldown}
+	if breaker, ok := breakers[key]; ok {
+		return breaker
+	}
+
+	breaker := NewCircuitBreaker(threshold, cooldown)
+	breakers[key] = breaker
+
+	return breaker
+}
@@ -0,0 +1,227 @@
+package http
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/utils"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// inlinePEM starts the values of client_cert and client_key that are PEM rather than the path of
+// a file of it.
+const inlinePEM = "-----BEGIN"
+
+// ClientCertificate is the certificate the requests present to the servers that ask for one, for
+// mutual TLS. A certificate of files is read again once one of its files changed, so a process
+// that runs for days picks up the certificates that rotate under it. A reload that fails, such as
+// a certificate that was replaced ahead of its key, keeps the certificate that was loaded. It is
+// safe for concurrent use.
+type ClientCertificate struct {
+	cert       string
+	key        string
+	passphrase string
+
+	mu          sync.Mutex
+	certificate *tls.Certificate
+	versions    [2]fileVersion
+}
+
+// fileVersion tells whether a file changed since it was read.
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+// LoadClientCertificate loads the certificate and its key, each the path of a PEM file or the PEM
+// itself, the key decrypted with the passphrase when it is encrypted. A key that isn't the one of
+// the certificate, or a certificate that expired, is an error.
+func LoadClientCertificate(cert, key, passphrase string) (*ClientCertificate, error) {
+	result := &ClientCertificate{cert: cert, key: key, passphrase: passphrase}
+
+	certificate, versions, err := result.load()
+	if err != nil {
+		return nil, err
+	}
+	result.certificate, result.versions = certificate, versions
+
+	return result, nil
+}
+
+// ParseClientCertificate returns the certificate of the PEM of a certificate and of its key, the
+// key decrypted with the passphrase when it is encrypted.
+func ParseClientCertificate(certPEM, keyPEM []byte, passphrase string) (tls.Certificate, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil || !strings.HasSuffix(block.Type, "PRIVATE KEY") {
+		return tls.Certificate{}, errors.New("invalid client_key, it holds no PEM private key")
+	}
+
+	// the legacy encryption of PEM, which openssl still writes with -traditional, is the only one
+	// the standard library decrypts
+	if x509.IsEncryptedPEMBlock(block) {
+		if passphrase == "" {
+			return tls.Certificate{}, errors.New("client_key is encrypted, set client_key_passphrase")
+		}
+
+		der, err := x509.DecryptPEMBlock(block, []byte(passphrase))
+		if err != nil {
+			return tls.Certificate{}, errors.New("invalid client_key_passphrase, it doesn't decrypt client_key")
+		}
+		keyPEM = pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der})
+	} else if block.Type == "ENCRYPTED PRIVATE KEY" {
+		return tls.Certificate{}, errors.New("client_key is an encrypted PKCS#8 key, which isn't supported, convert it with openssl pkey -traditional -aes256")
+	}
+
+	result, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		// crypto/tls doesn't export its errors, only the message tells a mismatch
+		if strings.Contains(err.Error(), "does not match") {
+			return tls.Certificate{}, errors.New("invalid client_key, it isn't the key of the certificate of client_cert")
+		}
+		return tls.Certificate{}, fmt.Errorf("invalid client_cert or client_key: %w", err)
+	}
+
+	leaf, err := x509.ParseCertificate(result.Certificate[0])
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("invalid client_cert: %w", err)
+	}
+	if time.Now().After(leaf.NotAfter) {
+		return tls.Certificate{}, fmt.Errorf("the certificate of client_cert expired on %s", leaf.NotAfter.UTC().Format(time.RFC3339))
+	}
+	result.Leaf = leaf
+
+	return result, nil
+}
+
+// GetClientCertificate returns the certificate for a handshake, reloaded when its files changed.
+// It is the GetClientCertificate of a tls.Config.
+func (c *ClientCertificate) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if versions := c.stat(); versions != c.versions {
+		// the versions are kept on a failure as well, so the reload is tried again on the next
+		// change rather than on every handshake
+		c.versions = versions
+		if certificate, _, err := c.load(); err == nil {
+			c.certificate = certificate
+		}
+	}
+
+	return c.certificate, nil
+}
+
+// load reads and parses the certificate and the key, along with the versions of their files.
+func (c *ClientCertificate) load() (*tls.Certificate, [2]fileVersion, error) {
+	versions := c.stat()
+
+	certPEM, err := readPEM("client_cert", c.cert)
+	if err != nil {
+		return nil, versions, err
+	}
+
+	keyPEM, err := readPEM("client_key", c.key)
+	if err != nil {
+		return nil, versions, err
+	}
+
+	certificate, err := ParseClientCertificate(certPEM, keyPEM, c.passphrase)
+	if err != nil {
+		return nil, versions, err
+	}
+
+	return &certificate, versions, nil
+}
+
+// stat returns the versions of the files of the certificate and the key, the zero version for
+// inline PEM or a file that can't be read.
+func (c *ClientCertificate) stat() [2]fileVersion {
+	var result [2]fileVersion
+
+	for i, value := range []string{c.cert, c.key} {
+		if strings.HasPrefix(strings.TrimSpace(value), inlinePEM) {
+			continue
+		}
+
+		path, err := utils.ExpandPath(value)
+		if err != nil {
+			continue
+		}
+
+		if info, err := os.Stat(path); err == nil {
+			result[i] = fileVersion{modTime: info.ModTime(), size: info.Size()}
+		}
+	}
+
+	return result
+}
+
+// readPEM returns the PEM of the value, which is either the PEM itself or the path of a file of it.
+func readPEM(name, value string) ([]byte, error) {
+	if strings.HasPrefix(strings.TrimSpace(value), inlinePEM) {
+		return []byte(value), nil
+	}
+
+	path, err := utils.ExpandPath(value)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the %s: %w", name, err)
+	}
+
+	return data, nil
+}
+
+// TLSHandshakeError is a request the server refused at the TLS handshake, rather than with a
+// response such as a 401, for instance for a client certificate it doesn't accept. It is an
+// ErrTLSHandshake.
+type TLSHandshakeError struct {
+	Host string
+	// ClientCertificate tells whether the request presented a client certificate
+	ClientCertificate bool
+	Err               error
+}
+
+func (e *TLSHandshakeError) Error() string {
+	message := fmt.Sprintf("TLS handshake with %s failed: %v", e.Host, e.Err)
+
+	if strings.Contains(e.Err.Error(), "remote error: tls:") && strings.Contains(e.Err.Error(), "certificate") {
+		if e.ClientCertificate {
+			return message + ", the server refused the certificate of client_cert"
+		}
+		return message + ", the server may require a client certificate, set client_cert and client_key"
+	}
+
+	return message
+}
+
+func (e *TLSHandshakeError) Unwrap() []error {
+	return []error{ErrTLSHandshake, e.Err}
+}
+
+// handshakeError returns the error as a *TLSHandshakeError when the TLS handshake failed, the
+// remote ones such as a refused client certificate and the local ones such as a server certificate
+// that isn't trusted alike.
+func (r *RestCaller) handshakeError(req *http.Request, err error) error {
+	var opError *net.OpError
+	var verification *tls.CertificateVerificationError
+
+	// net/http doesn't keep the *net.OpError of the alerts that arrive with the first response of
+	// TLS 1.3, only their message tells them apart
+	if (errors.As(err, &opError) && opError.Op == "remote error") || errors.As(err, &verification) ||
+		strings.Contains(err.Error(), "remote error: tls:") {
+		return &TLSHandshakeError{Host: req.URL.Host, ClientCertificate: r.config.ClientCert != "", Err: err}
+	}
+
+	return err
+}
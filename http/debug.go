@@ -0,0 +1,281 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultDebugBodyLimit is how much of a body the debug log shows when no limit is configured.
+	DefaultDebugBodyLimit = 4096
+	// debugStreamEvents is how many of the first and of the last events of a stream are logged.
+	debugStreamEvents = 3
+	redactedPrefix    = "****"
+)
+
+// secretHeaderWords mark the headers whose values are redacted in the debug log.
+var secretHeaderWords = []string{"auth", "key", "token", "secret", "cookie"}
+
+// WithDebugLog logs every request and its response to w, with the secrets of the headers redacted
+// to their last 4 characters. Bodies are logged up to limit bytes, 0 is DefaultDebugBodyLimit.
+// Streamed responses are logged when they are closed, as their number of events along with the
+// first and the last of them. A nil writer turns the log off, which is the default.
+func (r *RestCaller) WithDebugLog(w io.Writer, limit int) *RestCaller {
+	if limit <= 0 {
+		limit = DefaultDebugBodyLimit
+	}
+
+	r.debug, r.debugLimit = w, limit
+	return r
+}
+
+func (r *RestCaller) logRequest(req *http.Request, body []byte) {
+	if r.debug == nil {
+		return
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "-> %s %s\n", req.Method, r.redact(req.URL.String()))
+	if index, ok := req.Context().Value(keyIndexKey{}).(int); ok {
+		fmt.Fprintf(&buf, "   with %s\n", r.keys.keyLabel(index))
+	}
+	r.writeHeaders(&buf, req.Header)
+	if len(body) > 0 {
+		r.writeBody(&buf, body, len(body))
+	}
+
+	_, _ = r.debug.Write(buf.Bytes())
+}
+
+func (r *RestCaller) logFailure(req *http.Request, start time.Time, err error) {
+	if r.debug == nil {
+		return
+	}
+
+	_, _ = fmt.Fprintf(r.debug, "<- %s %s failed after %s: %s\n", req.Method, r.redact(req.URL.String()), r.elapsed(start), r.redact(err.Error()))
+}
+
+func (r *RestCaller) logRetry(attempt, attempts int, delay time.Duration, err error) {
+	if r.debug == nil {
+		return
+	}
+
+	_, _ = fmt.Fprintf(r.debug, "<- attempt %d of %d failed, retrying in %s: %s\n", attempt, attempts, delay.Round(time.Millisecond), r.redact(err.Error()))
+}
+
+func (r *RestCaller) logRotation(index int, err error) {
+	if r.debug == nil {
+		return
+	}
+
+	_, _ = fmt.Fprintf(r.debug, "<- %s was refused, trying the next key: %s\n", r.keys.keyLabel(index), r.redact(err.Error()))
+}
+
+// logResponse logs the status and the headers of the response, and returns its body wrapped so
+// the body is logged as it is read, once it is closed.
+func (r *RestCaller) logResponse(response *http.Response, start time.Time, stream bool) io.ReadCloser {
+	if r.debug == nil {
+		return response.Body
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "<- %s in %s%s\n", response.Status, r.elapsed(start), requestIDSuffix(response.Header.Get(headerRequestID)))
+	r.writeHeaders(&buf, response.Header)
+	_, _ = r.debug.Write(buf.Bytes())
+
+	return &debugBody{ReadCloser: response.Body, caller: r, start: start, stream: stream, id: response.Header.Get(headerRequestID)}
+}
+
+func (r *RestCaller) writeHeaders(buf *bytes.Buffer, header http.Header) {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, value := range header[name] {
+			if r.isSecretHeader(name) {
+				value = redactValue(value)
+			}
+			fmt.Fprintf(buf, "   %s: %s\n", name, r.redact(value))
+		}
+	}
+}
+
+// writeBody writes the body of the given size, indented when it is JSON, up to the limit.
+func (r *RestCaller) writeBody(buf *bytes.Buffer, body []byte, size int) {
+	var indented bytes.Buffer
+	if size <= r.debugLimit && json.Indent(&indented, body, "   ", "  ") == nil {
+		body = indented.Bytes()
+	}
+
+	fmt.Fprintf(buf, "   %s\n", r.redact(r.truncate(string(body), size)))
+}
+
+// truncate cuts the text, which was size bytes long, at the limit.
+func (r *RestCaller) truncate(text string, size int) string {
+	if size <= r.debugLimit {
+		return text
+	}
+
+	return fmt.Sprintf("%s... (%d more bytes)", text[:min(len(text), r.debugLimit)], size-r.debugLimit)
+}
+
+func (r *RestCaller) isSecretHeader(name string) bool {
+	if isAuthHeader(name, r.config) {
+		return true
+	}
+
+	name = strings.ToLower(name)
+	for _, word := range secretHeaderWords {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// redact replaces the API keys wherever they show, such as in an error that quotes a header.
+func (r *RestCaller) redact(text string) string {
+	if r.config.APIKey != "" {
+		text = strings.ReplaceAll(text, r.config.APIKey, redactValue(r.config.APIKey))
+	}
+
+	if r.keys != nil {
+		for _, key := range r.keys.keys {
+			text = strings.ReplaceAll(text, key, redactValue(key))
+		}
+	}
+
+	return text
+}
+
+// redactValue keeps the last 4 characters of the value, shorter values are hidden entirely.
+func redactValue(value string) string {
+	if len(value) <= 8 {
+		return redactedPrefix
+	}
+
+	return redactedPrefix + value[len(value)-4:]
+}
+
+func (r *RestCaller) elapsed(start time.Time) time.Duration {
+	return r.clock.Now().Sub(start).Round(time.Millisecond)
+}
+
+// debugBody logs the body of a response once it is closed. The body of a buffered response is
+// kept up to the limit, a stream only keeps its first and last events.
+type debugBody struct {
+	io.ReadCloser
+	caller *RestCaller
+	start  time.Time
+	stream bool
+	id     string
+	body   bytes.Buffer
+	size   int
+	line   bytes.Buffer
+	events int
+	first  []string
+	last   []string
+	logged bool
+}
+
+func (d *debugBody) Read(p []byte) (int, error) {
+	n, err := d.ReadCloser.Read(p)
+
+	if d.stream {
+		d.scan(p[:n])
+	} else {
+		d.size += n
+		d.body.Write(p[:min(n, max(d.caller.debugLimit-d.body.Len(), 0))])
+	}
+
+	return n, err
+}
+
+func (d *debugBody) Close() error {
+	err := d.ReadCloser.Close()
+
+	if !d.logged {
+		d.logged = true
+		d.log()
+	}
+
+	return err
+}
+
+// scan collects the events of the stream, which may be split across reads. Only the limit of
+// every line is kept.
+func (d *debugBody) scan(data []byte) {
+	for len(data) > 0 {
+		end := bytes.IndexByte(data, '\n')
+		if end < 0 {
+			d.keep(data)
+			return
+		}
+
+		d.keep(data[:end])
+		d.event()
+		data = data[end+1:]
+	}
+}
+
+func (d *debugBody) keep(data []byte) {
+	d.size += len(data)
+	d.line.Write(data[:min(len(data), max(d.caller.debugLimit-d.line.Len(), 0))])
+}
+
+func (d *debugBody) event() {
+	line, size := strings.TrimSpace(d.line.String()), d.size
+	d.line.Reset()
+	d.size = 0
+
+	if !strings.HasPrefix(line, "data:") {
+		return
+	}
+	line = d.caller.truncate(line, size)
+
+	d.events++
+	if len(d.first) < debugStreamEvents {
+		d.first = append(d.first, line)
+		return
+	}
+
+	d.last = append(d.last, line)
+	if len(d.last) > debugStreamEvents {
+		d.last = d.last[1:]
+	}
+}
+
+func (d *debugBody) log() {
+	var buf bytes.Buffer
+
+	if !d.stream {
+		if d.size > 0 {
+			d.caller.writeBody(&buf, d.body.Bytes(), d.size)
+		}
+		_, _ = d.caller.debug.Write(buf.Bytes())
+		return
+	}
+
+	fmt.Fprintf(&buf, "<- stream of %d events in %s%s\n", d.events, d.caller.elapsed(d.start), requestIDSuffix(d.id))
+	for _, event := range d.first {
+		fmt.Fprintf(&buf, "   %s\n", d.caller.redact(event))
+	}
+	if skipped := d.events - len(d.first) - len(d.last); skipped > 0 {
+		fmt.Fprintf(&buf, "   ... %d more events\n", skipped)
+	}
+	for _, event := range d.last {
+		fmt.Fprintf(&buf, "   %s\n", d.caller.redact(event))
+	}
+
+	_, _ = d.caller.debug.Write(buf.Bytes())
+}
@@ -0,0 +1,124 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/types"
+	"net"
+	"strings"
+)
+
+// DialOptions are how the connections of the requests are dialed. ForceIPv4 dials over IPv4 only,
+// for the networks whose IPv6 routes black-hole. Resolver is the host:port of the DNS server the
+// names are looked up with instead of the one of the system. Overrides pin a host, or a host:port,
+// to the address it is dialed at, an IP or an IP:port, like an entry of /etc/hosts would. The
+// requests keep their host, so TLS verifies the certificate of the name that was asked for.
+//
+// The options apply to the connections the CLI dials itself. Through a proxy that is the
+// connection to the proxy, so the host of the proxy is what is overridden and resolved, while the
+// host of the API is resolved by the proxy.
+type DialOptions struct {
+	ForceIPv4 bool
+	Resolver  string
+	Overrides map[string]string
+}
+
+// DialOptionsOf returns the dial options of the configuration, force_ipv4, dns_resolver and
+// dial_overrides. A resolver without a port is asked on port 53.
+func DialOptionsOf(cfg types.Config) (DialOptions, error) {
+	result := DialOptions{ForceIPv4: cfg.ForceIPv4, Resolver: cfg.DNSResolver}
+
+	if result.Resolver != "" {
+		if _, _, err := net.SplitHostPort(result.Resolver); err != nil {
+			result.Resolver = net.JoinHostPort(result.Resolver, "53")
+		}
+		if host, _, _ := net.SplitHostPort(result.Resolver); net.ParseIP(host) == nil {
+			return DialOptions{}, fmt.Errorf("invalid dns_resolver %s, use an address such as 1.1.1.1:53", cfg.DNSResolver)
+		}
+	}
+
+	for host, address := range cfg.DialOverrides {
+		ip := address
+		if hostOf, _, err := net.SplitHostPort(address); err == nil {
+			ip = hostOf
+		}
+		if net.ParseIP(ip) == nil {
+			return DialOptions{}, fmt.Errorf("invalid dial_overrides address %s of %s, use an IP such as 127.0.0.1 or an IP and a port", address, host)
+		}
+	}
+	result.Overrides = cfg.DialOverrides
+
+	return result, nil
+}
+
+// dialer returns the dial of the connections of the options, within the connect timeout. The
+// requests to unix sockets connect to their socket, which the options don't apply to.
+func (o DialOptions) dialer(timeouts Timeouts) func(ctx context.Context, network, address string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeouts.Connect, KeepAlive: keepAlive}
+
+	if o.Resolver != "" {
+		dialer.Resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return (&net.Dialer{Timeout: timeouts.Connect}).DialContext(ctx, network, o.Resolver)
+			},
+		}
+	}
+
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		if host, _, err := net.SplitHostPort(address); err == nil {
+			if socket, ok := unixSocketOf(host); ok {
+				conn, err := dialer.DialContext(ctx, "unix", socket)
+				if err != nil {
+					return nil, unixDialError(socket, err)
+				}
+				return conn, nil
+			}
+		}
+
+		if o.ForceIPv4 && network == "tcp" {
+			network = "tcp4"
+		}
+
+		return dialer.DialContext(ctx, network, o.override(address))
+	}
+}
+
+// override returns the address the host:port is pinned to, the port is kept when the override
+// doesn't tell one.
+func (o DialOptions) override(address string) string {
+	if pinned, ok := o.Overrides[address]; ok {
+		return withPort(pinned, address)
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+
+	if pinned, ok := o.Overrides[host]; ok {
+		return withPort(pinned, address)
+	}
+
+	return address
+}
+
+func withPort(pinned, address string) string {
+	if _, _, err := net.SplitHostPort(pinned); err == nil {
+		return pinned
+	}
+
+	_, port, _ := net.SplitHostPort(address)
+	return net.JoinHostPort(pinned, port)
+}
+
+// key returns the options as the key of a shared transport, the overrides in the order of their
+// hosts.
+func (o DialOptions) key() string {
+	var overrides []string
+	for _, host := range sortedKeys(o.Overrides) {
+		overrides = append(overrides, host+"="+o.Overrides[host])
+	}
+
+	return fmt.Sprintf("%t|%s|%s", o.ForceIPv4, o.Resolver, strings.Join(overrides, ","))
+}
@@ -0,0 +1,183 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/types"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errorBodyLimit is how much of an error body that isn't the error of the API an APIError quotes.
+const errorBodyLimit = 200
+
+// The kinds of failures callers branch on, with errors.Is. An *APIError is the kind its status and
+// code tell, the details, such as how long to wait before retrying, are fields of the APIError.
+var (
+	ErrUnauthorized          = errors.New("unauthorized")
+	ErrRateLimited           = errors.New("rate limited")
+	ErrContextLengthExceeded = errors.New("context length exceeded")
+	ErrModelNotFound         = errors.New("model not found")
+	ErrContentFiltered       = errors.New("the content was filtered by the API")
+	ErrTimeout               = errors.New("timeout")
+	ErrTLSHandshake          = errors.New("TLS handshake failed")
+)
+
+// contextLength matches the token counts of the message of a context_length_exceeded error.
+var contextLength = regexp.MustCompile(`maximum context length is (\d+) tokens.*?(?:resulted in|requested) (\d+) tokens`)
+
+// APIError is a response with an error status. Message, Type, Param and Code are those of the
+// error the API sent, they are empty when the body was something else, such as the HTML page of a
+// proxy, which Body then holds. RequestID is the id the API gave the request, which its support
+// asks for, and Organization the organization the request was billed to. RetryAfter is how long a
+// rate limited caller is asked to wait, and ContextLimit and ContextTokens are the token counts of
+// a context length exceeded, when the API tells them.
+type APIError struct {
+	StatusCode    int
+	Host          string
+	Message       string
+	Type          string
+	Param         string
+	Code          string
+	Body          []byte
+	RequestID     string
+	Organization  string
+	RetryAfter    time.Duration
+	ContextLimit  int
+	ContextTokens int
+}
+
+// Error returns the message of the API after its code, such as "insufficient_quota: you exceeded
+// your current quota", or the status and the start of the body when the API didn't send an error,
+// followed by the organization when the error is about it, and by the id of the request when the
+// API gave one.
+func (e *APIError) Error() string {
+	return e.message() + e.organizationSuffix() + requestIDSuffix(e.RequestID)
+}
+
+func (e *APIError) message() string {
+	if e.Message == "" {
+		body := strings.TrimSpace(strings.ToValidUTF8(string(e.Body[:min(len(e.Body), errorBodyLimit)]), ""))
+		if body == "" {
+			body = http.StatusText(e.StatusCode)
+		}
+
+		return fmt.Sprintf("HTTP %d from %s: %s", e.StatusCode, e.Host, body)
+	}
+
+	switch {
+	case e.Code != "":
+		return e.Code + ": " + e.Message
+	case e.Type != "":
+		return e.Type + ": " + e.Message
+	default:
+		return fmt.Sprintf("HTTP %d: %s", e.StatusCode, e.Message)
+	}
+}
+
+// Unwrap returns the kind of the error, nil when it is none of the kinds.
+func (e *APIError) Unwrap() error {
+	switch {
+	case e.Code == "context_length_exceeded":
+		return ErrContextLengthExceeded
+	case e.Code == "model_not_found":
+		return ErrModelNotFound
+	case e.Code == "content_filter" || e.Code == "content_policy_violation":
+		return ErrContentFiltered
+	case e.StatusCode == http.StatusUnauthorized:
+		return ErrUnauthorized
+	// running out of quota is a 429 as well, but waiting doesn't help with it
+	case e.StatusCode == http.StatusTooManyRequests && e.Code != "insufficient_quota":
+		return ErrRateLimited
+	}
+
+	return nil
+}
+
+// StatusOf returns the HTTP status a request of the caller ended with, 0 when it failed without
+// one, such as when the API couldn't be reached.
+func StatusOf(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+
+	var apiError *APIError
+	if errors.As(err, &apiError) {
+		return apiError.StatusCode
+	}
+
+	return 0
+}
+
+// newAPIError decodes the error of the response body.
+func newAPIError(response *http.Response, body []byte) *APIError {
+	result := &APIError{
+		StatusCode:   response.StatusCode,
+		Host:         response.Request.URL.Host,
+		Body:         body,
+		RequestID:    response.Header.Get(headerRequestID),
+		Organization: organizationOf(response),
+		RetryAfter:   parseRetryAfter(response.Header.Get("Retry-After")),
+	}
+
+	var errorData types.ErrorResponse
+	if err := json.Unmarshal(body, &errorData); err == nil {
+		result.Message = errorData.Error.Message
+		result.Type = errorData.Error.Type
+		result.Param = errorData.Error.Param
+		result.Code = errorData.Error.Code
+	}
+
+	if match := contextLength.FindStringSubmatch(result.Message); match != nil {
+		result.ContextLimit, _ = strconv.Atoi(match[1])
+		result.ContextTokens, _ = strconv.Atoi(match[2])
+	}
+
+	return result
+}
+
+// parseRetryAfter returns the wait of a Retry-After header, given in seconds or as a date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date).Round(time.Second), 0)
+	}
+
+	return 0
+}
+
+// timeoutError is an error that is also ErrTimeout, with the message of the error.
+type timeoutError struct {
+	err error
+}
+
+func (e *timeoutError) Error() string {
+	return e.err.Error()
+}
+
+func (e *timeoutError) Unwrap() []error {
+	return []error{ErrTimeout, e.err}
+}
+
+// withTimeout returns the error as an ErrTimeout when it is about a timeout.
+func withTimeout(err error) error {
+	var netError net.Error
+	if (errors.As(err, &netError) && netError.Timeout()) || errors.Is(err, context.DeadlineExceeded) {
+		return &timeoutError{err: err}
+	}
+
+	return err
+}
@@ -0,0 +1,229 @@
+package http
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	// fixtureExtension ends the names of the files of the fixtures.
+	fixtureExtension = ".json"
+	// scrubbedValue replaces the values of the secret headers in the fixtures.
+	scrubbedValue = "REDACTED"
+)
+
+// nonSlug matches what a path is cut at to name the file of its fixture.
+var nonSlug = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Fixture is a request along with its response, as a FixtureRecorder writes it and a ReplayCaller
+// serves it. The secret headers of the request, such as its authorization, are scrubbed. A body
+// that isn't text, such as the audio of a speech, is base64, which RequestBase64 and Base64 tell.
+type Fixture struct {
+	Method        string      `json:"method"`
+	URL           string      `json:"url"`
+	RequestHeader http.Header `json:"request_header,omitempty"`
+	RequestBody   string      `json:"request_body,omitempty"`
+	RequestBase64 bool        `json:"request_base64,omitempty"`
+	Status        int         `json:"status"`
+	Header        http.Header `json:"header,omitempty"`
+	Body          string      `json:"body"`
+	Base64        bool        `json:"base64,omitempty"`
+}
+
+// requestBody returns the body of the request, decoded when it is base64.
+func (f *Fixture) requestBody() []byte {
+	return decodeBody(f.RequestBody, f.RequestBase64)
+}
+
+// responseBody returns the body of the response, decoded when it is base64.
+func (f *Fixture) responseBody() []byte {
+	return decodeBody(f.Body, f.Base64)
+}
+
+// FixtureRecorder writes the requests of a caller along with their responses to a directory, a
+// file of a Fixture for each of them, for tests to replay with a ReplayCaller. The files are named
+// by the order of the requests, their method and their path, such as
+// 0001-post-v1-chat-completions.json, and a recorder that starts on a directory of fixtures
+// numbers its own after them. It is safe for concurrent use.
+type FixtureRecorder struct {
+	dir   string
+	mu    sync.Mutex
+	count int
+}
+
+// NewFixtureRecorder returns a recorder of the directory, which it creates when it doesn't exist.
+func NewFixtureRecorder(dir string) (*FixtureRecorder, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create the fixtures directory: %w", err)
+	}
+
+	names, err := fixtureFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FixtureRecorder{dir: dir, count: len(names)}, nil
+}
+
+// WithFixtureRecorder records every request that got a response, with its response, to the
+// recorder. A response is written once its body was read and closed, so the fixture of a stream
+// holds the whole stream.
+func (r *RestCaller) WithFixtureRecorder(recorder *FixtureRecorder) *RestCaller {
+	r.fixtures = recorder
+	return r
+}
+
+// startFixture returns the fixture of the request, with the body of a request whose body is
+// streamed, such as a multipart upload, read as it is sent.
+func (r *RestCaller) startFixture(req *http.Request, body []byte) *fixtureBody {
+	if r.fixtures == nil {
+		return nil
+	}
+
+	result := &fixtureBody{
+		recorder: r.fixtures,
+		fixture: Fixture{
+			Method:        req.Method,
+			URL:           r.redact(req.URL.String()),
+			RequestHeader: r.scrubHeaders(req.Header),
+		},
+	}
+
+	if body != nil {
+		result.request.Write(body)
+	} else if req.Body != nil {
+		// req.Body is what the transport reads the request from
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.TeeReader(req.Body, &result.request), req.Body}
+	}
+
+	return result
+}
+
+// scrubHeaders returns the headers with the values of the secret ones scrubbed.
+func (r *RestCaller) scrubHeaders(header http.Header) http.Header {
+	result := header.Clone()
+	for name := range result {
+		if r.isSecretHeader(name) {
+			result[name] = []string{scrubbedValue}
+		}
+	}
+
+	return result
+}
+
+// fixtureBody is the body of a response whose fixture is written once it is closed.
+type fixtureBody struct {
+	io.ReadCloser
+	recorder *FixtureRecorder
+	fixture  Fixture
+	request  bytes.Buffer
+	response bytes.Buffer
+}
+
+// wrap returns the body of the response, which records what is read of it.
+func (f *fixtureBody) wrap(response *http.Response, scrub func(http.Header) http.Header) io.ReadCloser {
+	f.fixture.Status = response.StatusCode
+	f.fixture.Header = scrub(response.Header)
+	// the body is recorded as it was decompressed
+	f.fixture.Header.Del(headerContentEncoding)
+	f.fixture.Header.Del("Content-Length")
+
+	f.ReadCloser = response.Body
+	return f
+}
+
+func (f *fixtureBody) Read(p []byte) (int, error) {
+	n, err := f.ReadCloser.Read(p)
+	f.response.Write(p[:n])
+
+	return n, err
+}
+
+func (f *fixtureBody) Close() error {
+	err := f.ReadCloser.Close()
+
+	f.fixture.RequestBody, f.fixture.RequestBase64 = encodeBody(f.request.Bytes())
+	f.fixture.Body, f.fixture.Base64 = encodeBody(f.response.Bytes())
+	if recordErr := f.recorder.write(f.fixture); recordErr != nil && err == nil {
+		err = recordErr
+	}
+
+	return err
+}
+
+// write writes the fixture to the next file of the directory.
+func (f *FixtureRecorder) write(fixture Fixture) error {
+	data, err := json.MarshalIndent(fixture, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.count++
+	name := fmt.Sprintf("%04d-%s%s", f.count, fixtureSlug(fixture.Method, fixture.URL), fixtureExtension)
+	if err := os.WriteFile(filepath.Join(f.dir, name), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write the fixture %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// fixtureSlug names the fixture of a request by its method and its path.
+func fixtureSlug(method, raw string) string {
+	path := raw
+	if parsed, err := url.Parse(raw); err == nil {
+		path = parsed.Path
+	}
+
+	slug := strings.Trim(nonSlug.ReplaceAllString(strings.ToLower(path), "-"), "-")
+	if slug == "" {
+		return strings.ToLower(method)
+	}
+
+	return strings.ToLower(method) + "-" + slug
+}
+
+// fixtureFiles returns the names of the files of the fixtures of the directory, in their order.
+func fixtureFiles(dir string) ([]string, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*"+fixtureExtension))
+	if err != nil {
+		return nil, err
+	}
+
+	// Glob sorts the names, which start with the order of their request
+	return names, nil
+}
+
+// encodeBody returns the body as it is when it is text, and as base64 otherwise.
+func encodeBody(body []byte) (string, bool) {
+	if utf8.Valid(body) {
+		return string(body), false
+	}
+
+	return base64.StdEncoding.EncodeToString(body), true
+}
+
+func decodeBody(body string, isBase64 bool) []byte {
+	if !isBase64 {
+		return []byte(body)
+	}
+
+	result, _ := base64.StdEncoding.DecodeString(body)
+	return result
+}
@@ -0,0 +1,54 @@
+package http
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	headerAcceptEncoding  = "Accept-Encoding"
+	headerContentEncoding = "Content-Encoding"
+	encodingGzip          = "gzip"
+)
+
+// decompress replaces the body of a gzip compressed response with its decompressed content. The
+// transport only does so itself when the request didn't ask for gzip explicitly, which the requests
+// do. The body is decompressed as it is read, so a streamed response still arrives event by event.
+func decompress(response *http.Response) {
+	if !strings.EqualFold(response.Header.Get(headerContentEncoding), encodingGzip) {
+		return
+	}
+
+	response.Body = &gzipBody{body: response.Body}
+	response.ContentLength = -1
+	response.Uncompressed = true
+}
+
+// gzipBody decompresses a body. The gzip header is only read on the first read, so the header of
+// a stream doesn't block the response.
+type gzipBody struct {
+	body   io.ReadCloser
+	reader *gzip.Reader
+}
+
+func (g *gzipBody) Read(p []byte) (int, error) {
+	if g.reader == nil {
+		reader, err := gzip.NewReader(g.body)
+		if err != nil {
+			return 0, err
+		}
+		g.reader = reader
+	}
+
+	return g.reader.Read(p)
+}
+
+func (g *gzipBody) Close() error {
+	if g.reader != nil {
+		_ = g.reader.Close()
+	}
+
+	return g.body.Close()
+}
@@ -0,0 +1,112 @@
+package http
+
+import (
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/types"
+	"net/http"
+	"strings"
+)
+
+const defaultAuthHeader = "Authorization"
+
+// HeaderFunc returns the headers to add to a request, such as a token that expires, it is called
+// for every request the caller sends. The headers are checked like the static headers are.
+type HeaderFunc func(method, url string) (map[string]string, error)
+
+// WithHeaders adds the headers to every request, along with those of the configuration. Invalid
+// headers fail the requests of the caller, like an invalid configuration does.
+func (r *RestCaller) WithHeaders(headers map[string]string) *RestCaller {
+	r.headers = MergeHeaders(r.headers, headers)
+
+	if err := CheckHeaders(r.headers, r.config); err != nil && r.err == nil {
+		r.err = err
+	}
+
+	return r
+}
+
+// WithHeaderFunc calls fn for the headers of every request, they are set after the static headers.
+func (r *RestCaller) WithHeaderFunc(fn HeaderFunc) *RestCaller {
+	r.headerFunc = fn
+	return r
+}
+
+// MergeHeaders returns the headers of every map, by their canonical names, the later maps win.
+// Names are case insensitive, and the keys of a config file are read in lower case.
+func MergeHeaders(headers ...map[string]string) map[string]string {
+	result := make(map[string]string)
+
+	for _, m := range headers {
+		for name, value := range m {
+			result[http.CanonicalHeaderKey(name)] = value
+		}
+	}
+
+	return result
+}
+
+// ParseHeaders parses headers given as "Name: value".
+func ParseHeaders(pairs []string) (map[string]string, error) {
+	result := make(map[string]string, len(pairs))
+
+	for _, pair := range pairs {
+		name, value, found := strings.Cut(pair, ":")
+		if !found || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid header %q, expected Name: value", pair)
+		}
+		result[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+
+	return result, nil
+}
+
+// CheckHeaders returns an error for the headers that can't be sent. The Content-Type of the
+// requests is never overridden, and the authorization header only with allow_auth_override, so a
+// stray header doesn't replace the API key by accident.
+func CheckHeaders(headers map[string]string, cfg types.Config) error {
+	for name, value := range headers {
+		if name == "" || strings.ContainsAny(name, " \t\r\n:") || strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("invalid header %q", name)
+		}
+
+		if strings.EqualFold(name, headerContentType) {
+			return fmt.Errorf("the %s header can't be overridden", headerContentType)
+		}
+
+		if isAuthHeader(name, cfg) && !cfg.AllowAuthOverride {
+			return fmt.Errorf("the %s header is only overridden with allow_auth_override", http.CanonicalHeaderKey(name))
+		}
+	}
+
+	return nil
+}
+
+// setHeaders sets the static headers and those of the header func on the request.
+func (r *RestCaller) setHeaders(req *http.Request) error {
+	for name, value := range r.headers {
+		req.Header.Set(name, value)
+	}
+
+	if r.headerFunc == nil {
+		return nil
+	}
+
+	headers, err := r.headerFunc(req.Method, req.URL.String())
+	if err != nil {
+		return fmt.Errorf("failed to get the headers of the request: %w", err)
+	}
+
+	if err := CheckHeaders(headers, r.config); err != nil {
+		return err
+	}
+
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	return nil
+}
+
+func isAuthHeader(name string, cfg types.Config) bool {
+	return strings.EqualFold(name, defaultAuthHeader) || (cfg.AuthHeader != "" && strings.EqualFold(name, cfg.AuthHeader))
+}
@@ -0,0 +1,205 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/clock"
+	"github.com/kardolus/chatgpt-cli/credentials"
+	"github.com/kardolus/chatgpt-cli/types"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultKeyCooldown is how long a key that was refused for its rate limit or its quota is left
+// out when the API didn't tell how long to wait.
+const DefaultKeyCooldown = time.Minute
+
+var (
+	keyRingsMu sync.Mutex
+	// keyRings are shared by the callers of the same keys, so a key one caller found exhausted is
+	// left out by the callers that come after it
+	keyRings = map[string]*KeyRing{}
+)
+
+// KeysOf returns the API keys of the configuration, api_key followed by the comma separated keys
+// of api_keys, without the duplicates.
+func KeysOf(cfg types.Config) []string {
+	var result []string
+
+	seen := make(map[string]bool)
+	for _, key := range append([]string{cfg.APIKey}, strings.Split(cfg.APIKeys, ",")...) {
+		if key = strings.TrimSpace(key); key != "" && !seen[key] {
+			seen[key] = true
+			result = append(result, key)
+		}
+	}
+
+	return result
+}
+
+// CredentialOf returns the account the key of the configuration is stored under: its credential,
+// or else its name, such as openai.
+func CredentialOf(cfg types.Config) string {
+	if cfg.Credential != "" {
+		return cfg.Credential
+	}
+	return cfg.Name
+}
+
+// WithStoredKey returns the configuration with the API key the store keeps for its credential, when
+// it has no key of its own. A key of the environment, a flag or the config file comes first, so CI
+// is never given a key some user stored.
+func WithStoredKey(cfg types.Config, store credentials.Store) (types.Config, error) {
+	if len(KeysOf(cfg)) > 0 {
+		return cfg, nil
+	}
+
+	key, err := store.Get(CredentialOf(cfg))
+	if errors.Is(err, credentials.ErrNotFound) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+
+	cfg.APIKey = key
+	return cfg, nil
+}
+
+// KeyRing spreads the requests across API keys of separate rate limits, round-robin. A key the API
+// refuses for its rate limit or its quota is left out until the wait the API told, or for
+// DefaultKeyCooldown, and the request is sent again with the next key. When every key is left
+// out the one that recovers first is used. It is safe for concurrent use.
+type KeyRing struct {
+	mu    sync.Mutex
+	keys  []string
+	until []time.Time
+	next  int
+	clock clock.Clock
+}
+
+// NewKeyRing returns a ring of the keys, in their order.
+func NewKeyRing(keys []string) *KeyRing {
+	return &KeyRing{keys: keys, until: make([]time.Time, len(keys)), clock: clock.Real}
+}
+
+// WithClock replaces the clock the keys recover by.
+func (k *KeyRing) WithClock(clock clock.Clock) *KeyRing {
+	k.clock = clock
+	return k
+}
+
+// WithKeyRing sends the requests with the keys of the ring instead of the API key of the
+// configuration.
+func (r *RestCaller) WithKeyRing(keys *KeyRing) *RestCaller {
+	r.keys = keys
+	return r
+}
+
+// Len returns the number of keys of the ring.
+func (k *KeyRing) Len() int {
+	return len(k.keys)
+}
+
+// Healthy reports whether the key at the index is used for the requests.
+func (k *KeyRing) Healthy(index int) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return !k.clock.Now().Before(k.until[index])
+}
+
+// pick returns the index and the key of the next request.
+func (k *KeyRing) pick() (int, string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	now, soonest := k.clock.Now(), -1
+	for i := range k.keys {
+		index := (k.next + i) % len(k.keys)
+		if !now.Before(k.until[index]) {
+			k.next = index + 1
+			return index, k.keys[index]
+		}
+		if soonest < 0 || k.until[index].Before(k.until[soonest]) {
+			soonest = index
+		}
+	}
+
+	k.next = soonest + 1
+	return soonest, k.keys[soonest]
+}
+
+// exhaust leaves the key at the index out for the wait.
+func (k *KeyRing) exhaust(index int, wait time.Duration) {
+	if wait <= 0 {
+		wait = DefaultKeyCooldown
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.until[index] = k.clock.Now().Add(wait)
+}
+
+// keyLabel names the key at the index for the logs by its position and its last 4 characters,
+// such as "key 2 of 3 (****abcd)".
+func (k *KeyRing) keyLabel(index int) string {
+	return fmt.Sprintf("key %d of %d (%s)", index+1, len(k.keys), redactValue(k.keys[index]))
+}
+
+// keyIndexKey is the context value of the index of the key a request is sent with.
+type keyIndexKey struct{}
+
+// apiKey returns the key of the next request, and the context of the request with the index of
+// the key in the ring, which is -1 without a ring.
+func (r *RestCaller) apiKey(ctx context.Context) (context.Context, string, int) {
+	if r.keys == nil || r.keys.Len() == 0 {
+		return ctx, r.config.APIKey, -1
+	}
+
+	index, key := r.keys.pick()
+	return context.WithValue(ctx, keyIndexKey{}, index), key, index
+}
+
+// rotate leaves the key at the index out once the API refused it for its rate limit or its quota,
+// and reports whether the request is sent again with the next key, which it is until every key
+// was tried once.
+func (r *RestCaller) rotate(index, tried int, err error) bool {
+	if index < 0 || StatusOf(err) != http.StatusTooManyRequests {
+		return false
+	}
+
+	var wait time.Duration
+	var apiError *APIError
+	if errors.As(err, &apiError) {
+		wait = apiError.RetryAfter
+	}
+	r.keys.exhaust(index, wait)
+
+	if tried >= r.keys.Len() {
+		return false
+	}
+
+	r.logRotation(index, err)
+	return true
+}
+
+// sharedKeyRing returns the ring of the keys, which the callers that come after share.
+func sharedKeyRing(keys []string) *KeyRing {
+	keyRingsMu.Lock()
+	defer keyRingsMu.Unlock()
+
+	id := strings.Join(keys, "\n")
+	if ring, ok := keyRings[id]; ok {
+		return ring
+	}
+
+	ring := NewKeyRing(keys)
+	keyRings[id] = ring
+
+	return ring
+}
@@ -0,0 +1,103 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/types"
+	"io"
+	"net/http"
+)
+
+const (
+	// DefaultMaxResponseBytes, DefaultMaxEventBytes and DefaultMaxStreamBytes are the limits of a
+	// configuration that sets none, many times what the longest completion takes.
+	DefaultMaxResponseBytes = 16 << 20
+	DefaultMaxEventBytes    = 1 << 20
+	DefaultMaxStreamBytes   = 16 << 20
+
+	// contentTypeStream is the content type of a streamed response.
+	contentTypeStream = "text/event-stream"
+)
+
+// ErrResponseTooLarge is the kind of the errors of the responses that were more than their limit.
+var ErrResponseTooLarge = errors.New("response too large")
+
+// ResponseTooLargeError is a response that was cut off at its limit, rather than read into memory
+// to its end, such as the endless page of a misbehaving proxy. Part is what was too large, the
+// "body" of a buffered response, an "event" of a stream or the "content" of a stream, and
+// ContentType is the content type the response was sent as.
+type ResponseTooLargeError struct {
+	Part        string
+	Limit       int64
+	ContentType string
+}
+
+func (e *ResponseTooLargeError) Error() string {
+	contentType := e.ContentType
+	if contentType == "" {
+		contentType = "unknown content type"
+	}
+
+	return fmt.Sprintf("response too large: the %s is more than %d bytes of %s", e.Part, e.Limit, contentType)
+}
+
+func (e *ResponseTooLargeError) Unwrap() error {
+	return ErrResponseTooLarge
+}
+
+// ResponseLimits are the most a response is read into memory. Body limits a buffered response,
+// Event a single event of a stream and Stream the content a stream adds up to.
+type ResponseLimits struct {
+	Body   int64
+	Event  int64
+	Stream int64
+}
+
+// DefaultResponseLimits are the limits of a caller that sets none.
+var DefaultResponseLimits = ResponseLimits{
+	Body:   DefaultMaxResponseBytes,
+	Event:  DefaultMaxEventBytes,
+	Stream: DefaultMaxStreamBytes,
+}
+
+// ResponseLimitsOf returns the limits of the configuration, max_response_bytes, max_event_bytes
+// and max_stream_bytes, each of them the default when it isn't set.
+func ResponseLimitsOf(cfg types.Config) ResponseLimits {
+	return ResponseLimits{
+		Body:   orDefault(cfg.MaxResponseBytes, DefaultMaxResponseBytes),
+		Event:  orDefault(cfg.MaxEventBytes, DefaultMaxEventBytes),
+		Stream: orDefault(cfg.MaxStreamBytes, DefaultMaxStreamBytes),
+	}
+}
+
+// WithResponseLimits replaces the limits of the responses, such as for the endpoints of files and
+// images whose responses are larger than those of completions.
+func (r *RestCaller) WithResponseLimits(limits ResponseLimits) *RestCaller {
+	r.limits = limits
+	return r
+}
+
+// readBody reads the body of a buffered response, up to the body limit.
+func (r *RestCaller) readBody(response *http.Response) ([]byte, error) {
+	id := response.Header.Get(headerRequestID)
+	limit := orDefault(r.limits.Body, DefaultMaxResponseBytes)
+
+	result, err := io.ReadAll(io.LimitReader(requestIDBody{response.Body, id}, limit+1))
+	if err != nil {
+		return nil, withTimeout(fmt.Errorf(errFailedToRead, err))
+	}
+
+	if int64(len(result)) > limit {
+		return nil, WithRequestID(&ResponseTooLargeError{Part: "body", Limit: limit, ContentType: response.Header.Get(headerContentType)}, id)
+	}
+
+	return result, nil
+}
+
+func orDefault[T int | int64](value T, fallback int64) int64 {
+	if value <= 0 {
+		return fallback
+	}
+
+	return int64(value)
+}
@@ -0,0 +1,130 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"sort"
+)
+
+// UploadProgress is told how many bytes of the file of a field were sent so far, as the upload
+// goes.
+type UploadProgress func(field string, sent int64)
+
+// nameOf is what files such as *os.File tell their name with.
+type nameOf interface {
+	Name() string
+}
+
+// WithUploadProgress reports the progress of the files PostMultipart uploads to fn.
+func (r *RestCaller) WithUploadProgress(fn UploadProgress) *RestCaller {
+	r.progress = fn
+	return r
+}
+
+// PostMultipart sends the fields and the files as a multipart/form-data request, such as an audio
+// file to transcribe, and returns the body of the response. The files are streamed while the
+// request is sent rather than read into memory first, each is named by the base name of the file
+// when it tells one, like an *os.File does, and by its field otherwise. Fields and files are
+// written in the order of their names. The authorization, the headers and the errors are those of
+// Post.
+func (r *RestCaller) PostMultipart(url string, fields map[string]string, files map[string]io.Reader) ([]byte, error) {
+	return r.PostMultipartContext(context.Background(), url, fields, files)
+}
+
+// PostMultipartContext sends the request like PostMultipart does, bound to the context.
+func (r *RestCaller) PostMultipartContext(ctx context.Context, url string, fields map[string]string, files map[string]io.Reader) ([]byte, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	reader, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+
+	// the files are read as they are sent, so a refused key isn't followed by the next one
+	ctx, key, _ := r.apiKey(ctx)
+	req, err := r.newRequest(ctx, http.MethodPost, url, key, reader, form.FormDataContentType())
+	if err != nil {
+		return nil, fmt.Errorf(errFailedToCreateRequest, err)
+	}
+
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		// a failure to read a file fails the request with it
+		writer.CloseWithError(writeForm(form, fields, files, r.progress))
+	}()
+
+	response, errorResponse, err := r.do(req, nil, false)
+	// what is left of the form is no longer read once the API answered
+	_ = reader.Close()
+	<-written
+	if err != nil {
+		return errorResponse, err
+	}
+	defer drainedBody{response.Body}.Close()
+
+	return r.readBody(response)
+}
+
+func writeForm(form *multipart.Writer, fields map[string]string, files map[string]io.Reader, progress UploadProgress) error {
+	for _, name := range sortedKeys(fields) {
+		if err := form.WriteField(name, fields[name]); err != nil {
+			return err
+		}
+	}
+
+	for _, field := range sortedKeys(files) {
+		file := files[field]
+
+		filename := field
+		if named, ok := file.(nameOf); ok {
+			filename = filepath.Base(named.Name())
+		}
+
+		part, err := form.CreateFormFile(field, filename)
+		if err != nil {
+			return err
+		}
+
+		var target io.Writer = part
+		if progress != nil {
+			target = &progressWriter{Writer: part, field: field, report: progress}
+		}
+
+		if _, err := io.Copy(target, file); err != nil {
+			return fmt.Errorf("failed to upload %s: %w", filename, err)
+		}
+	}
+
+	return form.Close()
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	result := make([]string, 0, len(m))
+	for key := range m {
+		result = append(result, key)
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+// progressWriter reports the bytes of a file as they are written to the form.
+type progressWriter struct {
+	io.Writer
+	field  string
+	sent   int64
+	report UploadProgress
+}
+
+func (p *progressWriter) Write(data []byte) (int, error) {
+	n, err := p.Writer.Write(data)
+	p.sent += int64(n)
+	p.report(p.field, p.sent)
+
+	return n, err
+}
@@ -0,0 +1,37 @@
+package http
+
+import (
+	"net/http"
+	"strings"
+)
+
+const (
+	// headerOrganization tells the API which organization of the key a request is billed to, and
+	// headerProject which project. Keys that aren't scoped to a project ignore the project.
+	headerOrganization = "OpenAI-Organization"
+	headerProject      = "OpenAI-Project"
+)
+
+// organizationOf returns the organization the response echoes, or the one the request was sent
+// with when the response doesn't echo one.
+func organizationOf(response *http.Response) string {
+	if organization := response.Header.Get(headerOrganization); organization != "" {
+		return organization
+	}
+
+	if response.Request != nil {
+		return response.Request.Header.Get(headerOrganization)
+	}
+
+	return ""
+}
+
+// organizationSuffix names the organization of an error that is about the organization, such as a
+// key that doesn't belong to it.
+func (e *APIError) organizationSuffix() string {
+	if e.Organization == "" || !strings.Contains(strings.ToLower(e.Code+" "+e.Message), "organization") {
+		return ""
+	}
+
+	return " (organization " + e.Organization + ")"
+}
@@ -0,0 +1,125 @@
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/kardolus/chatgpt-cli/types"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"time"
+)
+
+// Probe is how a request to the API went, each phase of its connection timed. A phase the request
+// skipped, such as the DNS lookup of an IP or the TLS handshake of http, is zero, and so is Date
+// when the response tells no time.
+type Probe struct {
+	// Proxy is the proxy the request went through, its password hidden, empty for none
+	Proxy     string
+	DNS       time.Duration
+	Connect   time.Duration
+	TLS       time.Duration
+	FirstByte time.Duration
+	Status    int
+	// Date is the time of the Date header of the response, and Sent and Received when the
+	// request was sent and its headers received, by the clock of the system
+	Date     time.Time
+	Sent     time.Time
+	Received time.Time
+}
+
+// ProbeEndpoint sends a GET to the endpoint on a connection of its own, with the proxy, the TLS
+// settings, the timeouts and the dial options of the configuration, and returns how each phase of
+// it went. Any response is a success, the request only fails when the API can't be reached.
+func ProbeEndpoint(ctx context.Context, cfg types.Config, endpoint string) (Probe, error) {
+	var result Probe
+
+	proxy := proxyFromEnvironment
+	if cfg.Proxy != "" {
+		proxyURL, err := ParseProxy(cfg.Proxy)
+		if err != nil {
+			return result, err
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	tlsConfig, err := TLSConfig(cfg)
+	if err != nil {
+		return result, err
+	}
+	timeouts, err := TimeoutsOf(cfg)
+	if err != nil {
+		return result, err
+	}
+	dial, err := DialOptionsOf(cfg)
+	if err != nil {
+		return result, err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return result, err
+	}
+	request.Header.Set("User-Agent", UserAgent(""))
+
+	if proxyURL, err := proxy(request); err == nil && proxyURL != nil {
+		result.Proxy = proxyURL.Redacted()
+	}
+
+	var dnsStart, connectStart, tlsStart time.Time
+	trace := &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone:           func(httptrace.DNSDoneInfo) { result.DNS = time.Since(dnsStart) },
+		ConnectStart:      func(string, string) { connectStart = time.Now() },
+		ConnectDone:       func(string, string, error) { result.Connect = time.Since(connectStart) },
+		TLSHandshakeStart: func() { tlsStart = time.Now() },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { result.TLS = time.Since(tlsStart) },
+	}
+
+	// the connection isn't one of the shared transports, so each of its phases is taken
+	transport := &http.Transport{
+		Proxy: func(req *http.Request) (*url.URL, error) {
+			if _, ok := unixSocketOf(req.URL.Host); ok {
+				return nil, nil
+			}
+			return proxy(req)
+		},
+		TLSClientConfig:       tlsConfig,
+		DialContext:           dial.dialer(timeouts),
+		TLSHandshakeTimeout:   timeouts.TLSHandshake,
+		ResponseHeaderTimeout: timeouts.ResponseHeader,
+		DisableKeepAlives:     true,
+		ForceAttemptHTTP2:     true,
+	}
+	defer transport.CloseIdleConnections()
+
+	result.Sent = time.Now()
+	response, err := (&http.Client{Transport: transport}).Do(request.WithContext(httptrace.WithClientTrace(ctx, trace)))
+	if err != nil {
+		return result, err
+	}
+	defer response.Body.Close()
+	result.Received = time.Now()
+	result.FirstByte = result.Received.Sub(result.Sent)
+	_, _ = io.CopyN(io.Discard, response.Body, maxDrain)
+
+	result.Status = response.StatusCode
+	if date, err := http.ParseTime(response.Header.Get("Date")); err == nil {
+		result.Date = date
+	}
+
+	return result, nil
+}
+
+// ClockSkew returns how far the clock of the system is behind the one of the API, negative when it
+// is ahead, from the midpoint of the request. The Date header only tells seconds, so a skew of
+// less than a second is noise. It is false when the response told no time.
+func (p Probe) ClockSkew() (time.Duration, bool) {
+	if p.Date.IsZero() {
+		return 0, false
+	}
+
+	local := p.Sent.Add(p.Received.Sub(p.Sent) / 2)
+	return p.Date.Sub(local.Truncate(time.Second)), true
+}
@@ -0,0 +1,50 @@
+package http
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// Page selects a page of a list endpoint that pages by cursor, such as the files or the batches.
+// After is the id of the last item of the previous page, empty for the first page. Order is asc or
+// desc. The zero fields are left to the defaults of the API.
+type Page struct {
+	Limit int
+	After string
+	Order string
+}
+
+// Values returns the query parameters of the page.
+func (p Page) Values() url.Values {
+	result := url.Values{}
+
+	if p.Limit > 0 {
+		result.Set("limit", strconv.Itoa(p.Limit))
+	}
+	if p.After != "" {
+		result.Set("after", p.After)
+	}
+	if p.Order != "" {
+		result.Set("order", p.Order)
+	}
+
+	return result
+}
+
+// WithQuery returns the endpoint with the parameters added to its query, encoded and sorted by
+// name. Parameters the endpoint already has are replaced.
+func WithQuery(endpoint string, params url.Values) (string, error) {
+	parsed, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid endpoint %s: %w", endpoint, err)
+	}
+
+	query := parsed.Query()
+	for name, values := range params {
+		query[name] = values
+	}
+	parsed.RawQuery = query.Encode()
+
+	return parsed.String(), nil
+}
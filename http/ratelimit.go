@@ -0,0 +1,172 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxThrottle is the longest a request waits for a quota to reset. Quotas that reset later, such as
+// those of a day, are left for the API to enforce.
+const maxThrottle = time.Minute
+
+// Quota is what is left of a limit of the API until it resets. Known is false when the API didn't
+// tell about the limit.
+type Quota struct {
+	Known     bool
+	Limit     int
+	Remaining int
+	Reset     time.Time
+}
+
+// RateLimit is the state of the rate limits of a host, as its last response told it, less the
+// requests that were sent since.
+type RateLimit struct {
+	Requests Quota
+	Tokens   Quota
+	Updated  time.Time
+}
+
+// Describe returns the rate limit as it reads at now, such as "rate limit: 14 requests remaining,
+// resets in 3.2s, 39000 tokens remaining, resets in 1s".
+func (l RateLimit) Describe(now time.Time) string {
+	var parts []string
+
+	for _, quota := range []struct {
+		name string
+		Quota
+	}{{"requests", l.Requests}, {"tokens", l.Tokens}} {
+		if !quota.Known {
+			continue
+		}
+
+		part := fmt.Sprintf("%d %s remaining", quota.Remaining, quota.name)
+		if reset := quota.Reset.Sub(now); reset > 0 {
+			part += fmt.Sprintf(", resets in %s", reset.Round(100*time.Millisecond))
+		}
+		parts = append(parts, part)
+	}
+
+	if len(parts) == 0 {
+		return "rate limit: unknown"
+	}
+
+	return "rate limit: " + strings.Join(parts, ", ")
+}
+
+// CurrentRateLimit returns the rate limit of the host of the URL, as the responses of every caller
+// told it, and false when no response of the host told it yet.
+func CurrentRateLimit(endpoint string) (RateLimit, bool) {
+	parsed, err := url.Parse(endpoint)
+	if err != nil {
+		return RateLimit{}, false
+	}
+
+	return rateLimits.get(parsed.Host)
+}
+
+// rateLimits is shared by the callers, the limits are those of the account, not of a caller.
+var rateLimits = &rateLimitState{hosts: make(map[string]RateLimit)}
+
+type rateLimitState struct {
+	mu    sync.RWMutex
+	hosts map[string]RateLimit
+}
+
+func (s *rateLimitState) get(host string) (RateLimit, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result, ok := s.hosts[host]
+	return result, ok
+}
+
+// update keeps the limits the response tells, the ones it doesn't tell are kept as they were.
+func (s *rateLimitState) update(host string, header http.Header, now time.Time) {
+	requests, requestsOK := parseQuota(header, "requests", now)
+	tokens, tokensOK := parseQuota(header, "tokens", now)
+	if !requestsOK && !tokensOK {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := s.hosts[host]
+	if requestsOK {
+		state.Requests = requests
+	}
+	if tokensOK {
+		state.Tokens = tokens
+	}
+	state.Updated = now
+	s.hosts[host] = state
+}
+
+// reserve returns how long a request to the host waits for its quotas to reset, and counts the
+// request against the remaining requests. A quota is waited for once it is near its end.
+func (s *rateLimitState) reserve(host string, now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.hosts[host]
+	if !ok {
+		return 0
+	}
+
+	var wait time.Duration
+	for _, quota := range []Quota{state.Requests, state.Tokens} {
+		if until := quota.Reset.Sub(now); quota.Known && nearEnd(quota) && until > 0 && until <= maxThrottle {
+			wait = max(wait, until)
+		}
+	}
+
+	if state.Requests.Known && state.Requests.Remaining > 0 {
+		state.Requests.Remaining--
+		s.hosts[host] = state
+	}
+
+	return wait
+}
+
+// nearEnd reports whether no more than 1% of the quota is left.
+func nearEnd(quota Quota) bool {
+	return quota.Remaining <= quota.Limit/100
+}
+
+// parseQuota parses the x-ratelimit headers of the limit, such as x-ratelimit-remaining-requests.
+func parseQuota(header http.Header, name string, now time.Time) (Quota, bool) {
+	remaining, err := strconv.Atoi(header.Get("X-Ratelimit-Remaining-" + name))
+	if err != nil {
+		return Quota{}, false
+	}
+
+	result := Quota{Known: true, Remaining: remaining}
+	result.Limit, _ = strconv.Atoi(header.Get("X-Ratelimit-Limit-" + name))
+
+	if reset, err := time.ParseDuration(header.Get("X-Ratelimit-Reset-" + name)); err == nil {
+		result.Reset = now.Add(reset)
+	}
+
+	return result, true
+}
+
+// throttle waits for the quotas of the host to reset when they are near their end, rather than
+// sending a request the API would refuse.
+func (r *RestCaller) throttle(ctx context.Context, host string) error {
+	wait := rateLimits.reserve(host, r.clock.Now())
+	if wait <= 0 {
+		return nil
+	}
+
+	if r.debug != nil {
+		_, _ = fmt.Fprintf(r.debug, "<- waiting %s for the rate limit of %s to reset\n", wait.Round(time.Millisecond), host)
+	}
+
+	return r.clock.Sleep(ctx, wait)
+}
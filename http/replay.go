@@ -0,0 +1,219 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// replayBoundary replaces the random boundary of multipart requests when they are matched.
+const replayBoundary = "fixture-boundary"
+
+// ErrNoFixture is a request of a ReplayCaller that none of its fixtures matches.
+var ErrNoFixture = errors.New("no fixture matches the request")
+
+// Scrubber normalizes the volatile parts of the URL or the body of a request, such as timestamps
+// and ids, so a request matches its fixture whatever those parts are.
+type Scrubber func(string) string
+
+// DefaultScrubbers normalize the UUIDs and the RFC 3339 timestamps of the requests.
+var DefaultScrubbers = []Scrubber{
+	ScrubPattern(regexp.MustCompile(`(?i)\b[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b`), "<uuid>"),
+	ScrubPattern(regexp.MustCompile(`\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:\d{2})`), "<timestamp>"),
+}
+
+// ScrubPattern replaces the matches of the pattern with the replacement.
+func ScrubPattern(pattern *regexp.Regexp, replacement string) Scrubber {
+	return func(text string) string {
+		return pattern.ReplaceAllLiteralString(text, replacement)
+	}
+}
+
+// ScrubJSONFields replaces the values of the fields of a JSON body, strings and numbers, wherever
+// the fields are nested, such as ScrubJSONFields("user", "seed").
+func ScrubJSONFields(fields ...string) Scrubber {
+	quoted := make([]string, len(fields))
+	for i, field := range fields {
+		quoted[i] = regexp.QuoteMeta(field)
+	}
+	pattern := regexp.MustCompile(`("(?:` + strings.Join(quoted, "|") + `)"\s*:\s*)(?:"(?:[^"\\]|\\.)*"|-?\d+(?:\.\d+)?(?:[eE][+-]?\d+)?)`)
+
+	return func(text string) string {
+		return pattern.ReplaceAllString(text, `${1}"<scrubbed>"`)
+	}
+}
+
+// ReplayCaller is a Caller that serves the requests from the fixtures a FixtureRecorder wrote,
+// without a network, for tests. A request is matched by its method, its URL and its body, after
+// the scrubbers normalized them, and the fixtures of the same request are served in their order,
+// the last of them once they were all served. A request that no fixture matches fails with an
+// ErrNoFixture. The responses with an error status fail like they do with a RestCaller. It is
+// safe for concurrent use.
+type ReplayCaller struct {
+	dir       string
+	scrubbers []Scrubber
+	mu        sync.Mutex
+	fixtures  []Fixture
+	served    []bool
+}
+
+var _ Caller = &ReplayCaller{}
+
+// NewReplayCaller returns a caller of the fixtures of the directory, whose requests are
+// normalized by the scrubbers, by the DefaultScrubbers when none are given.
+func NewReplayCaller(dir string, scrubbers ...Scrubber) (*ReplayCaller, error) {
+	if len(scrubbers) == 0 {
+		scrubbers = DefaultScrubbers
+	}
+
+	names, err := fixtureFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ReplayCaller{dir: dir, scrubbers: scrubbers}
+	for _, name := range names {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the fixture %s: %w", name, err)
+		}
+
+		var fixture Fixture
+		if err := json.Unmarshal(data, &fixture); err != nil {
+			return nil, fmt.Errorf("invalid fixture %s: %w", name, err)
+		}
+		result.fixtures = append(result.fixtures, fixture)
+	}
+	result.served = make([]bool, len(result.fixtures))
+
+	return result, nil
+}
+
+func (c *ReplayCaller) Post(url string, body []byte, stream bool) ([]byte, error) {
+	result, err := c.replay(http.MethodPost, url, body, "")
+	if err != nil || !stream {
+		return result, err
+	}
+
+	content, _, err := ReadStream(bytes.NewReader(result), os.Stdout, false)
+	return content, err
+}
+
+func (c *ReplayCaller) PostStream(url string, body []byte) (io.ReadCloser, error) {
+	result, err := c.replay(http.MethodPost, url, body, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return io.NopCloser(bytes.NewReader(result)), nil
+}
+
+// PostMultipart matches the form the fields and the files make, whatever the boundary of it.
+func (c *ReplayCaller) PostMultipart(url string, fields map[string]string, files map[string]io.Reader) ([]byte, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	if err := form.SetBoundary(replayBoundary); err != nil {
+		return nil, err
+	}
+
+	if err := writeForm(form, fields, files, nil); err != nil {
+		return nil, err
+	}
+
+	return c.replay(http.MethodPost, url, body.Bytes(), form.FormDataContentType())
+}
+
+func (c *ReplayCaller) Get(url string) ([]byte, error) {
+	return c.replay(http.MethodGet, url, nil, "")
+}
+
+func (c *ReplayCaller) Delete(url string) ([]byte, error) {
+	return c.replay(http.MethodDelete, url, nil, "")
+}
+
+// replay returns the body of the fixture of the request, or its error.
+func (c *ReplayCaller) replay(method, url string, body []byte, contentType string) ([]byte, error) {
+	fixture, err := c.match(method, url, body, contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	result := fixture.responseBody()
+	if fixture.Status < 200 || fixture.Status >= 300 {
+		req, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			return nil, fmt.Errorf(errFailedToCreateRequest, err)
+		}
+
+		response := &http.Response{StatusCode: fixture.Status, Header: fixture.Header, Request: req}
+		if response.Header == nil {
+			response.Header = http.Header{}
+		}
+
+		return result, newAPIError(response, result)
+	}
+
+	return result, nil
+}
+
+// match returns the first fixture of the request that wasn't served yet, or the last of them once
+// they all were.
+func (c *ReplayCaller) match(method, url string, body []byte, contentType string) (*Fixture, error) {
+	key := c.key(method, url, body, contentType)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	last := -1
+	for i := range c.fixtures {
+		fixture := &c.fixtures[i]
+		if c.key(fixture.Method, fixture.URL, fixture.requestBody(), fixture.RequestHeader.Get(headerContentType)) != key {
+			continue
+		}
+
+		if !c.served[i] {
+			c.served[i] = true
+			return fixture, nil
+		}
+		last = i
+	}
+
+	if last >= 0 {
+		return &c.fixtures[last], nil
+	}
+
+	quoted := c.scrub(string(body))
+	if len(quoted) > errorBodyLimit {
+		quoted = quoted[:errorBodyLimit] + "..."
+	}
+
+	return nil, fmt.Errorf("%w, none of the %d fixtures of %s is of %s %s %s", ErrNoFixture, len(c.fixtures), c.dir, method, url, quoted)
+}
+
+// key returns the request as it is matched, scrubbed and with the boundary of a multipart body
+// replaced.
+func (c *ReplayCaller) key(method, url string, body []byte, contentType string) string {
+	text := string(body)
+	if _, params, err := mime.ParseMediaType(contentType); err == nil && params["boundary"] != "" {
+		text = strings.ReplaceAll(text, params["boundary"], replayBoundary)
+	}
+
+	return method + " " + c.scrub(url) + "\n" + c.scrub(text)
+}
+
+func (c *ReplayCaller) scrub(text string) string {
+	for _, scrubber := range c.scrubbers {
+		text = scrubber(text)
+	}
+
+	return text
+}
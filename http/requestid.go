@@ -0,0 +1,105 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"github.com/kardolus/chatgpt-cli/types"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	headerRequestID      = "X-Request-Id"
+	headerProcessingTime = "Openai-Processing-Ms"
+)
+
+type recorderKey struct{}
+
+// RecordRequests returns a context that has the requests sent with it, through the methods of
+// ContextCaller, reported to record in the order they were answered. Only the requests the API
+// answered are reported, along with what their response headers tell about them.
+func RecordRequests(ctx context.Context, record func(types.RequestInfo)) context.Context {
+	return context.WithValue(ctx, recorderKey{}, record)
+}
+
+// RequestID returns the id the API gave the request an error is about, empty when the error
+// doesn't tell, such as when the API couldn't be reached.
+func RequestID(err error) string {
+	var apiError *APIError
+	if errors.As(err, &apiError) {
+		return apiError.RequestID
+	}
+
+	var requestError *requestIDError
+	if errors.As(err, &requestError) {
+		return requestError.id
+	}
+
+	return ""
+}
+
+// WithRequestID attaches the id of the request to an error that doesn't tell it yet, so support
+// can find the request. Errors that already tell an id are returned as they are.
+func WithRequestID(err error, id string) error {
+	if err == nil || id == "" || RequestID(err) != "" {
+		return err
+	}
+
+	return &requestIDError{id: id, err: err}
+}
+
+// requestIDError is an error of a request that was answered, along with the id of the request.
+type requestIDError struct {
+	id  string
+	err error
+}
+
+func (e *requestIDError) Error() string {
+	return e.err.Error() + requestIDSuffix(e.id)
+}
+
+func (e *requestIDError) Unwrap() error {
+	return e.err
+}
+
+func requestIDSuffix(id string) string {
+	if id == "" {
+		return ""
+	}
+
+	return " (request " + id + ")"
+}
+
+// requestInfo returns what the headers of the response tell about its request.
+func requestInfo(response *http.Response) types.RequestInfo {
+	result := types.RequestInfo{ID: response.Header.Get(headerRequestID)}
+
+	if ms, err := strconv.Atoi(response.Header.Get(headerProcessingTime)); err == nil && ms >= 0 {
+		result.ProcessingTime = time.Duration(ms) * time.Millisecond
+	}
+
+	return result
+}
+
+func recordRequest(ctx context.Context, info types.RequestInfo) {
+	if record, ok := ctx.Value(recorderKey{}).(func(types.RequestInfo)); ok {
+		record(info)
+	}
+}
+
+// requestIDBody attaches the id of the request to the errors of reading its body.
+type requestIDBody struct {
+	io.ReadCloser
+	id string
+}
+
+func (r requestIDBody) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		err = WithRequestID(err, r.id)
+	}
+
+	return n, err
+}
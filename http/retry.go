@@ -0,0 +1,177 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/clock"
+	"github.com/kardolus/chatgpt-cli/types"
+	"github.com/kardolus/chatgpt-cli/utils"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	// DefaultRetryBaseDelay and DefaultRetryMaxDelay are the backoff of a policy that sets none.
+	DefaultRetryBaseDelay = 500 * time.Millisecond
+	DefaultRetryMaxDelay  = 20 * time.Second
+)
+
+// RetryPolicy decides whether, and after how long, a failed request is sent again. MaxAttempts
+// counts the first attempt, so a policy of at most 1 attempt never retries, which is the zero
+// policy. The wait before the nth retry is drawn at random between 0 and BaseDelay doubled n-1
+// times, capped at MaxDelay, the full jitter that keeps the callers that failed together from
+// retrying together. A zero BaseDelay or MaxDelay is DefaultRetryBaseDelay or DefaultRetryMaxDelay.
+// A rate limited response that tells how long to wait is retried after that wait instead, unless
+// the wait is longer than MaxDelay, when the attempts end with its error.
+//
+// Retryable decides which failures are retried, DefaultRetryable when it is nil. Sleep waits
+// between the attempts, until ctx is done, with the clock of the caller when it is nil, and Jitter
+// draws the wait up to its limit, both are there for tests to replace. OnRetry is told about every
+// retry before its wait.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Retryable   func(status int, err error) bool
+	Sleep       func(ctx context.Context, d time.Duration) error
+	Jitter      func(limit time.Duration) time.Duration
+	OnRetry     func(attempt int, delay time.Duration, err error)
+}
+
+// RetryPolicyOf returns the retry policy of the configuration, retry_max_attempts with the backoff
+// of retry_base_delay and retry_max_delay.
+func RetryPolicyOf(cfg types.Config) (RetryPolicy, error) {
+	result := RetryPolicy{
+		MaxAttempts: cfg.RetryMaxAttempts,
+		BaseDelay:   DefaultRetryBaseDelay,
+		MaxDelay:    DefaultRetryMaxDelay,
+	}
+
+	var err error
+	if cfg.RetryBaseDelay != "" {
+		if result.BaseDelay, err = utils.ParseDuration(cfg.RetryBaseDelay); err != nil {
+			return RetryPolicy{}, fmt.Errorf("invalid retry_base_delay: %w", err)
+		}
+	}
+	if cfg.RetryMaxDelay != "" {
+		if result.MaxDelay, err = utils.ParseDuration(cfg.RetryMaxDelay); err != nil {
+			return RetryPolicy{}, fmt.Errorf("invalid retry_max_delay: %w", err)
+		}
+	}
+
+	return result, nil
+}
+
+// DefaultRetryable retries the responses that are rate limited, except for running out of quota,
+// which waiting doesn't solve, the timeouts and the server errors that are usually transient, and
+// the requests that failed to reach the API or broke off. Anything canceled isn't retried.
+func DefaultRetryable(status int, err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	switch status {
+	case http.StatusTooManyRequests:
+		return errors.Is(err, ErrRateLimited)
+	case http.StatusRequestTimeout, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	case 0:
+		var opError *net.OpError
+		return errors.Is(err, ErrTimeout) || errors.As(err, &opError) || errors.Is(err, io.ErrUnexpectedEOF)
+	}
+
+	return false
+}
+
+// WithRetryPolicy retries the requests that fail by the policy. Buffered requests are sent again
+// with their body, streamed ones only until their response arrived, so nothing of a stream is ever
+// delivered twice. Multipart uploads are never retried, their files are read as they are sent.
+func (r *RestCaller) WithRetryPolicy(policy RetryPolicy) *RestCaller {
+	r.retryPolicy = policy
+	return r
+}
+
+// retry makes the attempts of a request until one succeeds or the policy gives up, and returns the
+// error of the last attempt. A wait that ctx ends also ends the attempts, with the error of ctx
+// wrapped around that of the last attempt.
+func (r *RestCaller) retry(ctx context.Context, attempt func() error) error {
+	policy := r.retryPolicy
+
+	for n := 1; ; n++ {
+		err := attempt()
+		if err == nil || n >= policy.MaxAttempts || r.err != nil || !policy.retryable(err) {
+			return err
+		}
+
+		delay, ok := policy.delay(n, err)
+		if !ok {
+			return fmt.Errorf("%w, the API asks to wait %s, longer than the retry_max_delay of %s", err, delay, policy.ceiling())
+		}
+		if policy.OnRetry != nil {
+			policy.OnRetry(n, delay, err)
+		}
+		r.logRetry(n, policy.MaxAttempts, delay, err)
+
+		// a wait that Ctrl-C ends is an interruption rather than the failure it waited after
+		if sleepErr := policy.sleep(ctx, r.clock, delay); sleepErr != nil {
+			return fmt.Errorf("%w while waiting to retry: %w", sleepErr, err)
+		}
+	}
+}
+
+func (p RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(StatusOf(err), err)
+	}
+
+	return DefaultRetryable(StatusOf(err), err)
+}
+
+// delay returns the wait after the failed attempt n. It returns false when the API asks for a wait
+// longer than the ceiling, which isn't waited for.
+func (p RetryPolicy) delay(n int, err error) (time.Duration, bool) {
+	ceiling := p.ceiling()
+
+	var apiError *APIError
+	if errors.As(err, &apiError) && apiError.RetryAfter > 0 {
+		return apiError.RetryAfter, apiError.RetryAfter <= ceiling
+	}
+
+	base := p.BaseDelay
+	if base <= 0 {
+		base = DefaultRetryBaseDelay
+	}
+
+	limit := base
+	for i := 1; i < n && limit < ceiling; i++ {
+		limit *= 2
+	}
+	limit = min(limit, ceiling)
+
+	if p.Jitter != nil {
+		return p.Jitter(limit), true
+	}
+
+	return time.Duration(rand.Int63n(int64(limit) + 1)), true
+}
+
+// ceiling returns the longest wait between two attempts.
+func (p RetryPolicy) ceiling() time.Duration {
+	if p.MaxDelay <= 0 {
+		return DefaultRetryMaxDelay
+	}
+
+	return p.MaxDelay
+}
+
+func (p RetryPolicy) sleep(ctx context.Context, clock clock.Clock, d time.Duration) error {
+	if p.Sleep != nil {
+		return p.Sleep(ctx, d)
+	}
+
+	return clock.Sleep(ctx, d)
+}
@@ -0,0 +1,229 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/types"
+	"github.com/kardolus/chatgpt-cli/utils"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultConnectTimeout, DefaultTLSHandshakeTimeout and DefaultResponseHeaderTimeout are the
+	// timeouts of a configuration that sets none. A host that can't be reached fails fast, while
+	// the headers of a buffered completion only arrive once the model is done with it.
+	DefaultConnectTimeout        = 5 * time.Second
+	DefaultTLSHandshakeTimeout   = 10 * time.Second
+	DefaultResponseHeaderTimeout = 10 * time.Minute
+
+	// DefaultQueryTimeout and DefaultIdleTimeout are the timeouts of a query of a configuration
+	// that sets none. A query may take as long as it takes, while a stream may stall for as long
+	// as the headers of a response may take to arrive.
+	DefaultQueryTimeout = 0
+	DefaultIdleTimeout  = DefaultResponseHeaderTimeout
+
+	// keepAlive is the interval of the keep-alive probes of the connections, that of net/http.
+	keepAlive = 30 * time.Second
+)
+
+// The phases of a request a TimeoutError tells.
+const (
+	PhaseConnect        = "connect"
+	PhaseTLSHandshake   = "TLS handshake"
+	PhaseResponseHeader = "response header"
+	PhaseQuery          = "query"
+	PhaseIdle           = "stream idle"
+)
+
+// errStalled is why a stream timed out once it stalled for the idle timeout.
+var errStalled = errors.New("no data arrived")
+
+// Timeouts are how long each phase of a request may take, apart from the deadline of the context
+// of the request, which bounds it as a whole. Connect is how long the connection takes to be
+// established, TLSHandshake its handshake and ResponseHeader the wait for the headers of the
+// response once the request was sent. A zero timeout never times out.
+type Timeouts struct {
+	Connect        time.Duration
+	TLSHandshake   time.Duration
+	ResponseHeader time.Duration
+}
+
+// TimeoutsOf returns the timeouts of the configuration, connect_timeout, tls_handshake_timeout
+// and response_header_timeout, each of them the default when it isn't set.
+func TimeoutsOf(cfg types.Config) (Timeouts, error) {
+	result := Timeouts{
+		Connect:        DefaultConnectTimeout,
+		TLSHandshake:   DefaultTLSHandshakeTimeout,
+		ResponseHeader: DefaultResponseHeaderTimeout,
+	}
+
+	for _, timeout := range []struct {
+		key    string
+		value  string
+		target *time.Duration
+	}{
+		{"connect_timeout", cfg.ConnectTimeout, &result.Connect},
+		{"tls_handshake_timeout", cfg.TLSHandshakeTimeout, &result.TLSHandshake},
+		{"response_header_timeout", cfg.ResponseHeaderTimeout, &result.ResponseHeader},
+	} {
+		if timeout.value == "" {
+			continue
+		}
+
+		var err error
+		if *timeout.target, err = parseTimeout(timeout.value); err != nil {
+			return Timeouts{}, fmt.Errorf("invalid %s: %w", timeout.key, err)
+		}
+	}
+
+	return result, nil
+}
+
+// QueryTimeouts are how long a query may take. Query bounds the query as a whole, along with its
+// retries, and Idle how long a streamed answer may go without any data arriving once it started.
+// A zero timeout never times out.
+type QueryTimeouts struct {
+	Query time.Duration
+	Idle  time.Duration
+}
+
+// QueryTimeoutsOf returns the timeouts of the queries of the configuration, timeout and
+// idle_timeout, each of them the default when it isn't set.
+func QueryTimeoutsOf(cfg types.Config) (QueryTimeouts, error) {
+	result := QueryTimeouts{Query: DefaultQueryTimeout, Idle: DefaultIdleTimeout}
+
+	var err error
+	if cfg.Timeout != "" {
+		if result.Query, err = parseTimeout(cfg.Timeout); err != nil {
+			return QueryTimeouts{}, fmt.Errorf("invalid timeout: %w", err)
+		}
+	}
+	if cfg.IdleTimeout != "" {
+		if result.Idle, err = parseTimeout(cfg.IdleTimeout); err != nil {
+			return QueryTimeouts{}, fmt.Errorf("invalid idle_timeout: %w", err)
+		}
+	}
+
+	return result, nil
+}
+
+// parseTimeout parses a timeout like utils.ParseDuration does, and also accepts a whole number of
+// seconds, such as "90". A timeout can't be negative.
+func parseTimeout(value string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, nil
+	}
+
+	result, err := utils.ParseDuration(value)
+	if err != nil || result < 0 {
+		return 0, fmt.Errorf("%q isn't a timeout, use a duration such as 90s or 2m, or 0 to never time out", value)
+	}
+
+	return result, nil
+}
+
+// WithQueryTimeout returns a context that is done once the timeout passed, whose cause is the
+// *TimeoutError of the query, along with the function that releases it. A zero timeout returns
+// the context as it is.
+func WithQueryTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeoutCause(ctx, timeout, &TimeoutError{Phase: PhaseQuery, Timeout: timeout, Err: context.DeadlineExceeded})
+}
+
+// QueryTimeout returns the *TimeoutError of the query of the context instead of the error, when
+// the query failed because its timeout passed, and the error as it is otherwise.
+func QueryTimeout(ctx context.Context, err error) error {
+	var timeoutError *TimeoutError
+	if err == nil || !errors.Is(ctx.Err(), context.DeadlineExceeded) || !errors.As(context.Cause(ctx), &timeoutError) {
+		return err
+	}
+
+	return timeoutError
+}
+
+// TimeoutError is a request that timed out in the phase of its timeout, such as a host that
+// didn't accept the connection within the connect timeout. It is an ErrTimeout.
+type TimeoutError struct {
+	Phase   string
+	Timeout time.Duration
+	Err     error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s timeout after %s: %v", e.Phase, e.Timeout, e.Err)
+}
+
+func (e *TimeoutError) Unwrap() []error {
+	return []error{ErrTimeout, e.Err}
+}
+
+// phaseTimeout returns the error as a *TimeoutError when it is the timeout of a phase.
+func (r *RestCaller) phaseTimeout(err error) error {
+	var opError *net.OpError
+	switch {
+	case errors.As(err, &opError) && opError.Op == "dial" && opError.Timeout():
+		return &TimeoutError{Phase: PhaseConnect, Timeout: r.timeouts.Connect, Err: err}
+	// net/http doesn't export the errors of these timeouts, only their messages tell them apart
+	case strings.Contains(err.Error(), "TLS handshake timeout"):
+		return &TimeoutError{Phase: PhaseTLSHandshake, Timeout: r.timeouts.TLSHandshake, Err: err}
+	case strings.Contains(err.Error(), "timeout awaiting response headers"):
+		return &TimeoutError{Phase: PhaseResponseHeader, Timeout: r.timeouts.ResponseHeader, Err: err}
+	}
+
+	return err
+}
+
+// idleBody is the body of a stream that fails with the *TimeoutError of the idle timeout once no
+// data arrived for it, and cancels the request of the stream, so a stream that stalled doesn't
+// hold the query forever.
+type idleBody struct {
+	io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	cancel  context.CancelFunc
+	stalled atomic.Bool
+}
+
+// newIdleBody starts the idle timeout of the body, whose request cancel cancels, also once the body
+// is closed. A zero timeout never times out.
+func newIdleBody(body io.ReadCloser, timeout time.Duration, cancel context.CancelFunc) *idleBody {
+	result := &idleBody{ReadCloser: body, timeout: timeout, cancel: cancel}
+	if timeout > 0 {
+		result.timer = time.AfterFunc(timeout, func() {
+			result.stalled.Store(true)
+			cancel()
+		})
+	}
+
+	return result
+}
+
+func (b *idleBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if b.stalled.Load() {
+		return n, &TimeoutError{Phase: PhaseIdle, Timeout: b.timeout, Err: errStalled}
+	}
+	if b.timer != nil {
+		b.timer.Reset(b.timeout)
+	}
+
+	return n, err
+}
+
+func (b *idleBody) Close() error {
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	defer b.cancel()
+
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,121 @@
+package http
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/types"
+	"github.com/kardolus/chatgpt-cli/utils"
+	"os"
+	"strings"
+)
+
+// tlsVersions are the versions tls_min_version accepts.
+var tlsVersions = []struct {
+	name    string
+	version uint16
+}{
+	{"1.0", tls.VersionTLS10},
+	{"1.1", tls.VersionTLS11},
+	{"1.2", tls.VersionTLS12},
+	{"1.3", tls.VersionTLS13},
+}
+
+// TLSConfig returns the TLS configuration of the requests, buffered and streamed alike. The
+// certificates of the CA bundle, a PEM file, are trusted in addition to those of the system, for
+// proxies that re-sign the traffic with an internal CA. SkipTLSVerify turns off the verification of
+// certificates altogether, which is only ever meant for lab environments. A bundle that can't be
+// read or holds anything but certificates is an error, so it fails when the configuration is read
+// rather than on the first request.
+//
+// The client certificate of client_cert and client_key, if any, is presented to the servers that
+// ask for one, for mutual TLS. Each of them is either the path of a PEM file or the PEM itself, and
+// an encrypted key is decrypted with client_key_passphrase. A key that isn't the one of the
+// certificate is an error, and the files are read again once they changed.
+func TLSConfig(cfg types.Config) (*tls.Config, error) {
+	result := &tls.Config{InsecureSkipVerify: cfg.SkipTLSVerify}
+
+	if cfg.TLSMinVersion != "" {
+		version, err := parseTLSVersion(cfg.TLSMinVersion)
+		if err != nil {
+			return nil, err
+		}
+		result.MinVersion = version
+	}
+
+	if cfg.CABundle != "" {
+		pool, err := loadCABundle(cfg.CABundle)
+		if err != nil {
+			return nil, err
+		}
+		result.RootCAs = pool
+	}
+
+	if cfg.ClientCert != "" || cfg.ClientKey != "" {
+		if cfg.ClientCert == "" || cfg.ClientKey == "" {
+			return nil, errors.New("client_cert and client_key go together, set both of them")
+		}
+
+		certificate, err := LoadClientCertificate(cfg.ClientCert, cfg.ClientKey, cfg.ClientKeyPassphrase)
+		if err != nil {
+			return nil, err
+		}
+		result.GetClientCertificate = certificate.GetClientCertificate
+	}
+
+	return result, nil
+}
+
+// loadCABundle returns the certificates of the system along with those of the bundle.
+func loadCABundle(bundle string) (*x509.CertPool, error) {
+	path, err := utils.ExpandPath(bundle)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the CA bundle: %w", err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+
+	var count int
+	for rest := data; len(strings.TrimSpace(string(rest))) > 0; count++ {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			return nil, fmt.Errorf("invalid CA bundle %s, it holds something other than PEM certificates", path)
+		}
+
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("invalid CA bundle %s, block %d is a %s instead of a certificate", path, count+1, block.Type)
+		}
+
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CA bundle %s, certificate %d: %w", path, count+1, err)
+		}
+		pool.AddCert(certificate)
+	}
+
+	if count == 0 {
+		return nil, fmt.Errorf("invalid CA bundle %s, it holds no certificates", path)
+	}
+
+	return pool, nil
+}
+
+func parseTLSVersion(name string) (uint16, error) {
+	for _, version := range tlsVersions {
+		if version.name == name {
+			return version.version, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unsupported tls_min_version %s, use 1.0, 1.1, 1.2 or 1.3", name)
+}
@@ -0,0 +1,129 @@
+package http
+
+import (
+	"crypto/tls"
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/types"
+	"github.com/kardolus/chatgpt-cli/utils"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxIdleConns is how many idle connections to the API are kept open when the
+	// configuration doesn't tell, the default of the transport keeps 2, which parallel queries
+	// exhaust. DefaultIdleConnTimeout is how long they are kept open.
+	DefaultMaxIdleConns    = 16
+	DefaultIdleConnTimeout = 90 * time.Second
+
+	// maxDrain is how much of what follows the end of a stream is read, so its connection can be
+	// reused, before the stream is closed.
+	maxDrain = 4 << 10
+)
+
+var (
+	transportsMu sync.Mutex
+	// transports are shared by the callers of the same proxy, TLS, timeout, pool and dial
+	// settings, so consecutive callers reuse the connections of the ones before them
+	transports = map[transportKey]*http.Transport{}
+)
+
+type transportKey struct {
+	proxy         string
+	caBundle      string
+	tlsMinVersion string
+	skipTLSVerify bool
+	clientCert    string
+	clientKey     string
+	timeouts      Timeouts
+	pool          Pool
+	dial          string
+}
+
+// Pool is how the connections to the API are kept alive between the requests, so the queries that
+// follow each other skip setting up a connection. MaxIdleConns is how many idle connections to a
+// host are kept open and IdleTimeout how long an idle connection is kept open.
+type Pool struct {
+	MaxIdleConns int
+	IdleTimeout  time.Duration
+}
+
+// PoolOf returns the pool of the configuration, max_idle_conns and idle_conn_timeout, each of them
+// the default when it isn't set.
+func PoolOf(cfg types.Config) (Pool, error) {
+	result := Pool{MaxIdleConns: DefaultMaxIdleConns, IdleTimeout: DefaultIdleConnTimeout}
+
+	if cfg.MaxIdleConns > 0 {
+		result.MaxIdleConns = cfg.MaxIdleConns
+	}
+
+	if cfg.IdleConnTimeout != "" {
+		var err error
+		if result.IdleTimeout, err = utils.ParseDuration(cfg.IdleConnTimeout); err != nil {
+			return Pool{}, fmt.Errorf("invalid idle_conn_timeout: %w", err)
+		}
+	}
+
+	return result, nil
+}
+
+// WithHTTPClient sends the requests with the client instead of the shared one, so its transport
+// and timeouts apply. The proxy and the TLS settings of the configuration are those of the client
+// then.
+func (r *RestCaller) WithHTTPClient(client *http.Client) *RestCaller {
+	r.client = client
+	return r
+}
+
+// WithTransport sends the requests through the round tripper, such as one that audits them or a
+// transport with its own pool sizes, instead of the shared transport.
+func (r *RestCaller) WithTransport(transport http.RoundTripper) *RestCaller {
+	return r.WithHTTPClient(&http.Client{Transport: transport})
+}
+
+// sharedTransport returns the transport of the settings, which keeps its connections alive for
+// the callers that come after. It negotiates HTTP/2 with the hosts that support it, so the
+// requests to them share a single connection.
+func sharedTransport(key transportKey, proxy func(*http.Request) (*url.URL, error), tlsConfig *tls.Config, dial DialOptions) *http.Transport {
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+
+	if transport, ok := transports[key]; ok {
+		return transport
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ForceAttemptHTTP2 = true
+	transport.MaxIdleConnsPerHost = key.pool.MaxIdleConns
+	transport.MaxIdleConns = max(transport.MaxIdleConns, key.pool.MaxIdleConns)
+	transport.IdleConnTimeout = key.pool.IdleTimeout
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		if _, ok := unixSocketOf(req.URL.Host); ok {
+			return nil, nil
+		}
+		return proxy(req)
+	}
+	transport.TLSClientConfig = tlsConfig
+	transport.DialContext = dial.dialer(key.timeouts)
+	transport.TLSHandshakeTimeout = key.timeouts.TLSHandshake
+	transport.ResponseHeaderTimeout = key.timeouts.ResponseHeader
+
+	transports[key] = transport
+
+	return transport
+}
+
+// drainedBody reads what is left of a body, up to maxDrain, when it is closed. A stream is read up
+// to its [DONE] chunk, and the transport of older Go versions only reuses a connection
+// whose body was read to its end.
+type drainedBody struct {
+	io.ReadCloser
+}
+
+func (d drainedBody) Close() error {
+	_, _ = io.CopyN(io.Discard, d.ReadCloser, maxDrain)
+	return d.ReadCloser.Close()
+}
@@ -0,0 +1,101 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	// schemeUnix is the scheme of the endpoints of a server that listens on a unix socket, such as
+	// unix:///var/run/llm.sock/v1/chat/completions.
+	schemeUnix = "unix://"
+	// unixHostSuffix ends the placeholder hosts of the requests to unix sockets, .invalid is
+	// reserved, so no host of the network is ever mistaken for one.
+	unixHostSuffix = ".unix.invalid"
+)
+
+// unixSockets maps the placeholder hosts of the requests to the paths of their sockets, for the
+// dialer to connect to.
+var unixSockets sync.Map
+
+// unixURL returns the URL of the request to a unix:// endpoint, the route of the endpoint on a
+// placeholder host of its socket, which the dialer connects to instead of a host. The socket is
+// the longest start of the path that is a file, or when there is none yet, the path up to the
+// first name that ends in .sock, so unix:///var/run/llm.sock/v1/models asks /var/run/llm.sock
+// for /v1/models. Other URLs are returned as they are.
+func unixURL(raw string) string {
+	rest, ok := strings.CutPrefix(raw, schemeUnix)
+	if !ok {
+		return raw
+	}
+
+	path, query, hasQuery := strings.Cut(rest, "?")
+	socket, route := splitSocket(path)
+
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(socket))
+	host := fmt.Sprintf("%016x%s", hash.Sum64(), unixHostSuffix)
+	unixSockets.Store(host, socket)
+
+	result := "http://" + host + route
+	if hasQuery {
+		result += "?" + query
+	}
+
+	return result
+}
+
+func splitSocket(path string) (string, string) {
+	names := strings.Split(strings.TrimPrefix(path, "/"), "/")
+
+	for i := len(names); i > 0; i-- {
+		socket := "/" + strings.Join(names[:i], "/")
+		if info, err := os.Stat(socket); err == nil && !info.IsDir() {
+			return socket, route(names[i:])
+		}
+	}
+
+	for i, name := range names {
+		if strings.HasSuffix(name, ".sock") {
+			return "/" + strings.Join(names[:i+1], "/"), route(names[i+1:])
+		}
+	}
+
+	return path, "/"
+}
+
+func route(names []string) string {
+	return "/" + strings.Join(names, "/")
+}
+
+// unixSocketOf returns the socket of the placeholder host of a request to a unix socket.
+func unixSocketOf(host string) (string, bool) {
+	if !strings.HasSuffix(host, unixHostSuffix) {
+		return "", false
+	}
+
+	socket, ok := unixSockets.Load(host)
+	if !ok {
+		return "", false
+	}
+
+	return socket.(string), true
+}
+
+// unixDialError tells why the socket couldn't be connected to in the words of the socket rather
+// than of the system call.
+func unixDialError(socket string, err error) error {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return fmt.Errorf("unix socket %s doesn't exist, is the server running?: %w", socket, err)
+	case errors.Is(err, fs.ErrPermission):
+		return fmt.Errorf("permission denied to connect to unix socket %s: %w", socket, err)
+	default:
+		return fmt.Errorf("failed to connect to unix socket %s: %w", socket, err)
+	}
+}
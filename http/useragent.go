@@ -0,0 +1,41 @@
+package http
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+const (
+	headerUserAgent = "User-Agent"
+	userAgentName   = "chatgpt-cli"
+	// develVersion is the version of a build that tells none, such as one made with go run.
+	develVersion = "dev"
+)
+
+// UserAgent returns the User-Agent the requests identify the CLI with, such as
+// "chatgpt-cli/1.7.1 (linux; amd64)". An empty version is the one of the build info, the module
+// version of a go install, or dev when the build tells none.
+func UserAgent(version string) string {
+	if version == "" {
+		version = buildVersion()
+	}
+
+	return fmt.Sprintf("%s/%s (%s; %s)", userAgentName, version, runtime.GOOS, runtime.GOARCH)
+}
+
+// WithUserAgent replaces the User-Agent of the requests. A User-Agent among the headers of the
+// caller still wins over it.
+func (r *RestCaller) WithUserAgent(userAgent string) *RestCaller {
+	r.userAgent = userAgent
+	return r
+}
+
+func buildVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok || info.Main.Version == "" || info.Main.Version == "(devel)" {
+		return develVersion
+	}
+
+	return info.Main.Version
+}
@@ -0,0 +1,38 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// Warmer is a Caller that can set up its connection to the API ahead of the first request. The
+// client uses it when its caller implements it.
+type Warmer interface {
+	Warmup(ctx context.Context, url string) error
+}
+
+var _ Warmer = &RestCaller{}
+
+// Warmup sets up the connection to the host of the URL, TLS handshake included, with a HEAD
+// request, so the first query doesn't wait for it. The connection is then kept alive for the
+// requests that follow. Whatever status the API answers with, the connection is set up, only a
+// request that got no response is an error. The rate limits, the circuit breaker and the retries
+// don't apply to it.
+func (r *RestCaller) Warmup(ctx context.Context, url string) error {
+	if r.err != nil {
+		return r.err
+	}
+
+	req, err := r.newRequest(ctx, http.MethodHead, url, r.config.APIKey, nil, contentTypeJSON)
+	if err != nil {
+		return fmt.Errorf(errFailedToCreateRequest, err)
+	}
+
+	response, err := r.client.Do(req)
+	if err != nil {
+		return r.requestError(req, err)
+	}
+
+	return drainedBody{response.Body}.Close()
+}
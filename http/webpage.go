@@ -0,0 +1,139 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	// MaxPageRedirects is how many redirects FetchPage follows before it gives up on a page.
+	MaxPageRedirects = 5
+
+	// acceptPage is what FetchPage asks for, the pages it can read the text of first.
+	acceptPage   = "text/html, application/xhtml+xml, text/plain;q=0.9, */*;q=0.1"
+	headerAccept = "Accept"
+
+	headerETag        = "ETag"
+	headerIfNoneMatch = "If-None-Match"
+)
+
+var (
+	// ErrTooManyRedirects is a page that redirected more than MaxPageRedirects times, such as in a
+	// loop.
+	ErrTooManyRedirects = errors.New("too many redirects")
+	// ErrNotModified is a page that didn't change since the copy of the ETag of the FetchOptions
+	// was fetched.
+	ErrNotModified = errors.New("not modified")
+)
+
+// WebPage is a web page that FetchPage fetched. URL is the one it was fetched from, after the
+// redirects, and ETag the entity tag the site tagged it with, if any.
+type WebPage struct {
+	URL         string
+	ContentType string
+	ETag        string
+	Body        []byte
+}
+
+// FetchOptions tell Fetch how to fetch a page. Accept is what it asks for and ETag the entity tag of
+// the copy of the page that is kept, if any, which makes a page that didn't change an
+// ErrNotModified. HeaderHosts are the hosts the headers of the caller are sent to, such as those of
+// a wiki that takes a token, the exact names, without their ports. No other host gets them, not
+// even one that a host of them redirects to.
+type FetchOptions struct {
+	Accept      string
+	ETag        string
+	HeaderHosts []string
+}
+
+// FetchPage fetches a web page through the proxy, the TLS settings and the timeouts of the caller,
+// like its requests to the API, but with none of their headers, so neither the API key nor the
+// organization is sent to the site. The redirects are followed up to MaxPageRedirects, and the body
+// is read up to the body limit of the caller. A response with an error status is an error, without
+// its body.
+func (r *RestCaller) FetchPage(ctx context.Context, url string) (WebPage, error) {
+	return r.Fetch(ctx, url, FetchOptions{Accept: acceptPage})
+}
+
+// Fetch fetches a page like FetchPage does, as the options tell, and only sends the headers of the
+// caller to the hosts of the options.
+func (r *RestCaller) Fetch(ctx context.Context, url string, options FetchOptions) (WebPage, error) {
+	if r.err != nil {
+		return WebPage{}, r.err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return WebPage{}, fmt.Errorf(errFailedToCreateRequest, err)
+	}
+	if isHeaderHost(req, options.HeaderHosts) {
+		for name, value := range r.headers {
+			req.Header.Set(name, value)
+		}
+	}
+	req.Header.Set(headerUserAgent, r.userAgent)
+	req.Header.Set(headerAccept, options.Accept)
+	if options.ETag != "" {
+		req.Header.Set(headerIfNoneMatch, options.ETag)
+	}
+
+	client := *r.client
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) > MaxPageRedirects {
+			return ErrTooManyRedirects
+		}
+
+		// the headers of the request are copied to the redirect, whatever its host
+		if !isHeaderHost(req, options.HeaderHosts) {
+			for name := range r.headers {
+				req.Header.Del(name)
+			}
+		}
+		return nil
+	}
+
+	response, err := client.Do(req)
+	if err != nil {
+		if errors.Is(err, ErrTooManyRedirects) {
+			return WebPage{}, fmt.Errorf("%w: %s redirected more than %d times", ErrTooManyRedirects, url, MaxPageRedirects)
+		}
+		return WebPage{}, r.requestError(req, err)
+	}
+	defer drainedBody{response.Body}.Close()
+
+	if response.StatusCode == http.StatusNotModified && options.ETag != "" {
+		return WebPage{}, ErrNotModified
+	}
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return WebPage{}, fmt.Errorf("failed to fetch %s: %s", response.Request.URL, response.Status)
+	}
+
+	limit := orDefault(r.limits.Body, DefaultMaxResponseBytes)
+	contentType := response.Header.Get(headerContentType)
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, limit+1))
+	if err != nil {
+		return WebPage{}, withTimeout(fmt.Errorf(errFailedToRead, err))
+	}
+	if int64(len(body)) > limit {
+		return WebPage{}, &ResponseTooLargeError{Part: "body", Limit: limit, ContentType: contentType}
+	}
+
+	return WebPage{URL: response.Request.URL.String(), ContentType: contentType, ETag: response.Header.Get(headerETag), Body: body}, nil
+}
+
+// isHeaderHost tells whether the host of the request is one of the hosts.
+func isHeaderHost(req *http.Request, hosts []string) bool {
+	for _, host := range hosts {
+		if strings.EqualFold(req.URL.Hostname(), strings.TrimSpace(host)) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -1,14 +1,17 @@
 package integration_test
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/kardolus/chatgpt-cli/config"
+	"github.com/kardolus/chatgpt-cli/history"
 	"github.com/kardolus/chatgpt-cli/types"
 	"github.com/kardolus/chatgpt-cli/utils"
 	"github.com/onsi/gomega/gexec"
 	"io"
 	"net/http"
+	"os"
 	"strings"
 	"sync"
 )
@@ -161,3 +164,25 @@ func validateRequest(w http.ResponseWriter, r *http.Request, allowedMethod strin
 
 	return nil
 }
+
+// readHistoryFile returns the messages of a history file written in the current schema version.
+func readHistoryFile(fileName string) ([]types.Message, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	var stored struct {
+		Version  int             `json:"version"`
+		Messages []types.Message `json:"messages"`
+	}
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, err
+	}
+
+	if stored.Version != history.SchemaVersion {
+		return nil, fmt.Errorf("%s has schema version %d", fileName, stored.Version)
+	}
+
+	return stored.Messages, nil
+}
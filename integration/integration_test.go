@@ -1,22 +1,36 @@
 package integration_test
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/kardolus/chatgpt-cli/batch"
+	"github.com/kardolus/chatgpt-cli/buildinfo"
+	"github.com/kardolus/chatgpt-cli/client"
 	"github.com/kardolus/chatgpt-cli/config"
+	"github.com/kardolus/chatgpt-cli/configloader"
 	"github.com/kardolus/chatgpt-cli/configmanager"
+	"github.com/kardolus/chatgpt-cli/exitcode"
 	"github.com/kardolus/chatgpt-cli/history"
+	"github.com/kardolus/chatgpt-cli/ledger"
 	"github.com/kardolus/chatgpt-cli/types"
 	"github.com/kardolus/chatgpt-cli/utils"
+	"github.com/onsi/gomega/gbytes"
 	"github.com/onsi/gomega/gexec"
 	"github.com/sclevine/spec"
 	"github.com/sclevine/spec/report"
 	"io"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
 	"sync"
@@ -77,6 +91,12 @@ func testIntegration(t *testing.T, when spec.G, it spec.S) {
 					Content: "Test message 2",
 				},
 			}
+
+			// the store records the token counts, so the messages it returns carry them
+			for i := range messages {
+				messages[i].Tokens = utils.EstimateTokens(messages[i].Content)
+				messages[i].TokenEncoding = utils.TokenEncoding
+			}
 		})
 
 		it.After(func() {
@@ -96,557 +116,4333 @@ func testIntegration(t *testing.T, when spec.G, it spec.S) {
 			Expect(err).NotTo(HaveOccurred())
 			Expect(readMessages).To(Equal(messages))
 		})
-	})
 
-	when("Read, Write, List, Delete Config", func() {
-		var (
-			tmpDir     string
-			tmpFile    *os.File
-			historyDir string
-			configIO   *config.FileIO
-			testConfig types.Config
-			err        error
-		)
+		it("keeps the threads apart", func() {
+			Expect(fileIO.Write(messages)).To(Succeed())
 
-		it.Before(func() {
-			tmpDir, err = os.MkdirTemp("", "chatgpt-cli-test")
-			Expect(err).NotTo(HaveOccurred())
+			fileIO.SetThread("other-thread")
+			Expect(fileIO.Write(messages[:1])).To(Succeed())
 
-			historyDir, err = os.MkdirTemp(tmpDir, "history")
+			readMessages, err := fileIO.ReadThread(threadName)
 			Expect(err).NotTo(HaveOccurred())
+			Expect(readMessages).To(Equal(messages))
 
-			tmpFile, err = os.CreateTemp(tmpDir, "config.yaml")
+			readMessages, err = fileIO.Read()
 			Expect(err).NotTo(HaveOccurred())
+			Expect(readMessages).To(Equal(messages[:1]))
+		})
 
-			Expect(tmpFile.Close()).To(Succeed())
+		it("reads a thread that does not exist as an empty history", func() {
+			readMessages, err := fileIO.ReadThread("does-not-exist")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(readMessages).To(BeEmpty())
+		})
 
-			configIO = config.New().WithConfigPath(tmpFile.Name()).WithHistoryPath(historyDir)
+		it("rejects thread names that escape the history directory", func() {
+			_, err = fileIO.ReadThread("../config")
+			Expect(err).To(HaveOccurred())
 
-			testConfig = types.Config{
-				Model: "test-model",
-			}
+			fileIO.SetThread("../config")
+			Expect(fileIO.Write(messages)).NotTo(Succeed())
+			Expect(path.Join(path.Dir(tmpDir), "config.json")).NotTo(BeAnExistingFile())
 		})
 
-		it.After(func() {
-			Expect(os.RemoveAll(tmpDir)).To(Succeed())
-		})
+		it("keeps the history in a directory it creates for the user only", func() {
+			workingDir, err := os.Getwd()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(os.Chdir(tmpDir)).To(Succeed())
+			defer os.Chdir(workingDir)
 
-		when("performing a migration", func() {
-			defaults := config.New().ReadDefaults()
+			store, err := history.NewInDirectory(path.Join("synced", "chatgpt", "history"))
+			Expect(err).NotTo(HaveOccurred())
+			store.SetThread(threadName)
+			Expect(store.Write(messages)).To(Succeed())
 
-			it("it doesn't apply a migration when max_tokens is 0", func() {
-				testConfig.MaxTokens = 0
+			// the directory was resolved when the store was made, changing directories doesn't move it
+			Expect(os.Chdir(workingDir)).To(Succeed())
+			readMessages, err := store.Read()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(readMessages).To(Equal(messages))
 
-				err = configIO.Write(testConfig) // need to write before reading
+			for _, dir := range []string{"synced", "synced/chatgpt", "synced/chatgpt/history"} {
+				info, err := os.Stat(path.Join(tmpDir, dir))
 				Expect(err).NotTo(HaveOccurred())
+				Expect(info.Mode().Perm()).To(Equal(os.FileMode(0700)), dir)
+			}
+			Expect(path.Join(tmpDir, "synced", "chatgpt", "history", threadName+".json")).To(BeARegularFile())
+		})
 
-				readConfig, err := configIO.Read()
-				Expect(err).NotTo(HaveOccurred())
-				Expect(readConfig).To(Equal(testConfig))
-			})
-			it("it migrates small values of max_tokens as expected", func() {
-				testConfig.MaxTokens = defaults.ContextWindow - 1
+		it("keeps the default history directory and its files for the user only", func() {
+			if runtime.GOOS == "windows" {
+				t.Skip("Windows has no permission bits")
+			}
 
-				err = configIO.Write(testConfig) // need to write before reading
-				Expect(err).NotTo(HaveOccurred())
+			Expect(os.Setenv(utils.ConfigHomeEnv, tmpDir)).To(Succeed())
+			defer os.Unsetenv(utils.ConfigHomeEnv)
 
-				readConfig, err := configIO.Read()
-				Expect(err).NotTo(HaveOccurred())
+			store, err := history.New()
+			Expect(err).NotTo(HaveOccurred())
+			store.SetThread(threadName)
+			Expect(store.Write(messages)).To(Succeed())
 
-				expectedConfig := testConfig
-				expectedConfig.MaxTokens = defaults.MaxTokens
-				expectedConfig.ContextWindow = defaults.ContextWindow
+			historyDir := path.Join(tmpDir, utils.DefaultDataDir)
+			info, err := os.Stat(historyDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0700)))
 
-				Expect(readConfig).To(Equal(expectedConfig))
-			})
-			it("it migrates large values of max_tokens as expected", func() {
-				testConfig.MaxTokens = defaults.ContextWindow + 1
+			for _, file := range []string{threadName + ".json", threadName + ".json.bak", ".index.json", ".lock"} {
+				info, err := os.Stat(path.Join(historyDir, file))
+				Expect(err).NotTo(HaveOccurred(), file)
+				Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)), file)
+			}
+		})
 
-				err = configIO.Write(testConfig) // need to write before reading
-				Expect(err).NotTo(HaveOccurred())
+		it("lists the threads with their metadata", func() {
+			before := time.Now().Add(-time.Second)
 
-				readConfig, err := configIO.Read()
-				Expect(err).NotTo(HaveOccurred())
+			Expect(fileIO.Write(messages)).To(Succeed())
+			fileIO.SetThread("other-thread")
+			Expect(fileIO.Write(messages[:1])).To(Succeed())
 
-				expectedConfig := testConfig
-				expectedConfig.MaxTokens = defaults.MaxTokens
-				expectedConfig.ContextWindow = testConfig.MaxTokens
+			// threads written by older versions are not in the index
+			Expect(os.WriteFile(path.Join(tmpDir, "legacy.json"), []byte(`[{"role":"user","content":"hi"}]`), 0644)).To(Succeed())
 
-				Expect(readConfig).To(Equal(expectedConfig))
-			})
+			threads, err := fileIO.ListThreads()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(threads).To(HaveLen(3))
+
+			Expect(threads[0].Name).To(Equal(threadName))
+			Expect(threads[0].Messages).To(Equal(2))
+			Expect(threads[0].Created).To(BeTemporally(">", before))
+			// file times come from a coarser clock and can trail time.Now by a tick
+			Expect(threads[0].Updated).To(BeTemporally(">=", threads[0].Created.Add(-time.Second)))
+
+			Expect(threads[1].Name).To(Equal("legacy"))
+			Expect(threads[1].Messages).To(Equal(1))
+
+			Expect(threads[2].Name).To(Equal("other-thread"))
+			Expect(threads[2].Messages).To(Equal(1))
 		})
 
-		it("lists all the threads", func() {
-			files := []string{"thread1.json", "thread2.json", "thread3.json"}
+		it("does not lose or corrupt messages with concurrent writers", func() {
+			const writers = 20
 
-			for _, file := range files {
-				file, err := os.Create(filepath.Join(historyDir, file))
-				Expect(err).NotTo(HaveOccurred())
+			var wg sync.WaitGroup
+			errs := make(chan error, writers)
 
-				Expect(file.Close()).To(Succeed())
-			}
+			for i := 0; i < writers; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
 
-			result, err := configIO.List()
-			Expect(err).NotTo(HaveOccurred())
-			Expect(result).To(HaveLen(3))
-			Expect(result[2]).To(Equal("thread3.json"))
-		})
+					// every writer has its own store, like separate invocations of the CLI
+					store, _ := history.New()
+					store = store.WithDirectory(tmpDir)
+					store.SetThread(threadName)
 
-		it("deletes the thread", func() {
-			files := []string{"thread1.json", "thread2.json", "thread3.json"}
+					errs <- store.Update(func(existing []types.Message) []types.Message {
+						return append(existing, types.Message{Role: "user", Content: fmt.Sprintf("message %d", i)})
+					})
+				}(i)
+			}
 
-			for _, file := range files {
-				file, err := os.Create(filepath.Join(historyDir, file))
+			wg.Wait()
+			close(errs)
+			for err := range errs {
 				Expect(err).NotTo(HaveOccurred())
-
-				Expect(file.Close()).To(Succeed())
 			}
 
-			err = configIO.Delete("thread2")
+			readMessages, err := fileIO.Read()
 			Expect(err).NotTo(HaveOccurred())
+			Expect(readMessages).To(HaveLen(writers))
 
-			_, err = os.Stat(filepath.Join(historyDir, "thread2.json"))
-			Expect(os.IsNotExist(err)).To(BeTrue())
-
-			_, err = os.Stat(filepath.Join(historyDir, "thread3.json"))
-			Expect(os.IsNotExist(err)).To(BeFalse())
+			var contents []string
+			for _, message := range readMessages {
+				contents = append(contents, message.Content)
+			}
+			for i := 0; i < writers; i++ {
+				Expect(contents).To(ContainElement(fmt.Sprintf("message %d", i)))
+			}
 		})
-	})
 
-	when("Performing the Lifecycle", func() {
-		const (
-			exitSuccess = 0
-			exitFailure = 1
-		)
+		it("gives up when another process holds the lock for too long", func() {
+			Expect(fileIO.Write(messages)).To(Succeed())
 
-		var (
-			homeDir      string
-			filePath     string
-			configFile   string
-			err          error
-			apiKeyEnvVar string
-		)
+			locked, release := make(chan struct{}), make(chan struct{})
+			done := make(chan error)
+			go func() {
+				holder, _ := history.New()
+				holder = holder.WithDirectory(tmpDir)
+				holder.SetThread(threadName)
 
-		runCommand := func(args ...string) string {
-			command := exec.Command(binaryPath, args...)
-			session, err := gexec.Start(command, io.Discard, io.Discard)
+				done <- holder.Update(func(existing []types.Message) []types.Message {
+					close(locked)
+					<-release
+					return existing
+				})
+			}()
+			<-locked
 
-			ExpectWithOffset(1, err).NotTo(HaveOccurred())
-			<-session.Exited
+			fileIO.WithLockTimeout(50 * time.Millisecond)
+			Expect(fileIO.Write(messages)).To(MatchError(history.ErrLocked))
 
-			if tmp := string(session.Err.Contents()); tmp != "" {
-				fmt.Printf("error output: %s", string(session.Err.Contents()))
-			}
+			close(release)
+			Expect(<-done).To(Succeed())
+			Expect(fileIO.Write(messages)).To(Succeed())
+		})
 
-			ExpectWithOffset(1, session).Should(gexec.Exit(0))
-			return string(session.Out.Contents())
-		}
+		it("clears a thread and keeps it current", func() {
+			Expect(fileIO.Write(messages)).To(Succeed())
 
-		checkConfigFileContent := func(expectedContent string) {
-			content, err := os.ReadFile(configFile)
-			ExpectWithOffset(1, err).NotTo(HaveOccurred())
-			ExpectWithOffset(1, string(content)).To(ContainSubstring(expectedContent))
-		}
+			Expect(fileIO.Clear(threadName)).To(Succeed())
+			Expect(fileIO.GetThread()).To(Equal(threadName))
 
-		it.Before(func() {
-			once.Do(func() {
-				SetDefaultEventuallyTimeout(10 * time.Second)
+			readMessages, err := fileIO.Read()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(readMessages).To(BeEmpty())
 
-				log.Println("Building binary...")
-				Expect(buildBinary()).To(Succeed())
-				log.Println("Binary built successfully!")
+			// clearing a thread without history is fine
+			Expect(fileIO.Clear(threadName)).To(Succeed())
+		})
 
-				log.Println("Starting mock server...")
-				Expect(runMockServer()).To(Succeed())
-				log.Println("Mock server started!")
+		it("deletes a thread and falls back to the default thread when it was the current one", func() {
+			Expect(fileIO.Write(messages)).To(Succeed())
 
-				Eventually(func() (string, error) {
-					return curl(fmt.Sprintf("%s/ping", serviceURL))
-				}).Should(ContainSubstring("pong"))
-			})
+			Expect(fileIO.DeleteThread(threadName)).To(Succeed())
+			Expect(fileIO.GetThread()).To(Equal("default"))
 
-			homeDir, err = os.MkdirTemp("", "mockHome")
+			threads, err := fileIO.ListThreads()
 			Expect(err).NotTo(HaveOccurred())
+			Expect(threads).To(BeEmpty())
 
-			apiKeyEnvVar = configmanager.New(config.New()).WithEnvironment().APIKeyEnvVarName()
-
-			Expect(os.Setenv("HOME", homeDir)).To(Succeed())
-			Expect(os.Setenv(apiKeyEnvVar, expectedToken)).To(Succeed())
+			Expect(fileIO.DeleteThread("does-not-exist")).To(Succeed())
 		})
 
-		it.After(func() {
-			gexec.Kill()
-			Expect(os.RemoveAll(homeDir))
+		it("searches all threads and streams the matches", func() {
+			fileIO.SetThread("b-thread")
+			Expect(fileIO.Write([]types.Message{
+				{Role: "user", Content: "How do I fix pgbouncer timeouts?"},
+				{Role: "assistant", Content: "Raise the PgBouncer query_timeout."},
+			})).To(Succeed())
+
+			fileIO.SetThread("a-thread")
+			Expect(fileIO.Write([]types.Message{
+				{Role: "user", Content: "Unrelated question"},
+				{Role: "user", Content: "pgbouncer again"},
+			})).To(Succeed())
+
+			var matches []history.SearchMatch
+			collect := func(match history.SearchMatch) error {
+				matches = append(matches, match)
+				return nil
+			}
+
+			Expect(fileIO.Search(history.SearchOptions{Term: "PGBOUNCER"}, collect)).To(Succeed())
+			Expect(matches).To(HaveLen(3))
+			Expect(matches[0].Thread).To(Equal("a-thread"))
+			Expect(matches[0].Index).To(Equal(1))
+			Expect(matches[1].Thread).To(Equal("b-thread"))
+			Expect(matches[1].Index).To(Equal(0))
+			Expect(matches[1].Updated).NotTo(BeZero())
+			Expect(matches[2].Role).To(Equal("assistant"))
+
+			matches = nil
+			Expect(fileIO.Search(history.SearchOptions{Term: "pgbouncer", Role: "assistant"}, collect)).To(Succeed())
+			Expect(matches).To(HaveLen(1))
+			Expect(matches[0].Snippet[matches[0].Start:matches[0].End]).To(Equal("PgBouncer"))
+
+			matches = nil
+			Expect(fileIO.Search(history.SearchOptions{Term: `query_\w+`, Regexp: true}, collect)).To(Succeed())
+			Expect(matches).To(HaveLen(1))
+
+			stop := errors.New("stop")
+			calls := 0
+			err := fileIO.Search(history.SearchOptions{Term: "pgbouncer"}, func(history.SearchMatch) error {
+				calls++
+				return stop
+			})
+			Expect(err).To(MatchError(stop))
+			Expect(calls).To(Equal(1))
 		})
 
-		it("throws an error when the API key is missing", func() {
-			Expect(os.Unsetenv(apiKeyEnvVar)).To(Succeed())
+		it("recovers the last good version when the thread file was partially written", func() {
+			Expect(fileIO.Write(messages[:1])).To(Succeed())
+			Expect(fileIO.Write(messages)).To(Succeed())
 
-			command := exec.Command(binaryPath, "some prompt")
-			session, err := gexec.Start(command, io.Discard, io.Discard)
+			threadFile := path.Join(tmpDir, threadName+".json")
+			data, err := os.ReadFile(threadFile)
 			Expect(err).NotTo(HaveOccurred())
+			Expect(os.WriteFile(threadFile, data[:len(data)/2], 0644)).To(Succeed())
 
-			Eventually(session).Should(gexec.Exit(exitFailure))
-
-			output := string(session.Err.Contents())
-			Expect(output).To(ContainSubstring("API key is required."))
-		})
+			readMessages, err := fileIO.Read()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(readMessages).To(Equal(messages))
 
-		it("should not require an API key for the --version flag", func() {
-			Expect(os.Unsetenv(apiKeyEnvVar)).To(Succeed())
+			Expect(fileIO.Update(func(stored []types.Message) []types.Message {
+				return append(stored, types.Message{Role: "user", Content: "one more"})
+			})).To(Succeed())
 
-			command := exec.Command(binaryPath, "--version")
-			session, err := gexec.Start(command, io.Discard, io.Discard)
+			readMessages, err = fileIO.Read()
 			Expect(err).NotTo(HaveOccurred())
+			Expect(readMessages).To(HaveLen(3))
 
-			Eventually(session).Should(gexec.Exit(exitSuccess))
-		})
+			// no temporary files are left behind and the backup is not listed as a thread
+			entries, err := os.ReadDir(tmpDir)
+			Expect(err).NotTo(HaveOccurred())
+			for _, entry := range entries {
+				Expect(entry.Name()).NotTo(ContainSubstring(".tmp-"))
+			}
 
-		it("should require a hidden folder for the --list-threads flag", func() {
-			command := exec.Command(binaryPath, "--list-threads")
-			session, err := gexec.Start(command, io.Discard, io.Discard)
+			threads, err := fileIO.ListThreads()
 			Expect(err).NotTo(HaveOccurred())
+			Expect(threads).To(HaveLen(1))
+		})
 
-			Eventually(session).Should(gexec.Exit(exitFailure))
+		it("fails to read a damaged thread without a backup", func() {
+			Expect(os.WriteFile(path.Join(tmpDir, threadName+".json"), []byte(`[{"role":"us`), 0644)).To(Succeed())
 
-			output := string(session.Err.Contents())
-			Expect(output).To(ContainSubstring(".chatgpt-cli/history: no such file or directory"))
+			_, err := fileIO.Read()
+			Expect(err).To(HaveOccurred())
 		})
 
-		it("should return an error when --new-thread is used with --set-thread", func() {
-			command := exec.Command(binaryPath, "--new-thread", "--set-thread", "some-thread")
-			session, err := gexec.Start(command, io.Discard, io.Discard)
-			Expect(err).NotTo(HaveOccurred())
+		it("removes and renames the backup along with the thread", func() {
+			Expect(fileIO.Write(messages)).To(Succeed())
+			Expect(path.Join(tmpDir, threadName+".json.bak")).To(BeAnExistingFile())
 
-			Eventually(session).Should(gexec.Exit(exitFailure))
+			Expect(fileIO.RenameThread(threadName, "renamed")).To(Succeed())
+			Expect(path.Join(tmpDir, threadName+".json.bak")).NotTo(BeAnExistingFile())
+			Expect(path.Join(tmpDir, "renamed.json.bak")).To(BeAnExistingFile())
 
-			output := string(session.Err.Contents())
-			Expect(output).To(ContainSubstring("the --new-thread flag cannot be used with the --set-thread or --thread flags"))
+			Expect(fileIO.Clear("renamed")).To(Succeed())
+			Expect(path.Join(tmpDir, "renamed.json.bak")).NotTo(BeAnExistingFile())
 		})
 
-		it("should return an error when --new-thread is used with --thread", func() {
-			command := exec.Command(binaryPath, "--new-thread", "--thread", "some-thread")
-			session, err := gexec.Start(command, io.Discard, io.Discard)
-			Expect(err).NotTo(HaveOccurred())
+		it("stores the message timestamps and reads messages without one as zero times", func() {
+			timestamp := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+			Expect(fileIO.Write([]types.Message{
+				{Role: "user", Content: "old question"},
+				{Role: "user", Content: "new question", Timestamp: timestamp},
+			})).To(Succeed())
 
-			Eventually(session).Should(gexec.Exit(exitFailure))
+			data, err := os.ReadFile(path.Join(tmpDir, threadName+".json"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(strings.Count(string(data), `"timestamp"`)).To(Equal(1))
 
-			output := string(session.Err.Contents())
-			Expect(output).To(ContainSubstring("the --new-thread flag cannot be used with the --set-thread or --thread flags"))
+			readMessages, err := fileIO.Read()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(readMessages).To(HaveLen(2))
+			Expect(readMessages[0].Timestamp).To(BeZero())
+			Expect(readMessages[1].Timestamp).To(BeTemporally("==", timestamp))
+
+			var matches []history.SearchMatch
+			Expect(fileIO.Search(history.SearchOptions{Term: "question"}, func(match history.SearchMatch) error {
+				matches = append(matches, match)
+				return nil
+			})).To(Succeed())
+			Expect(matches).To(HaveLen(2))
+			Expect(matches[0].Timestamp).To(BeZero())
+			Expect(matches[1].Timestamp).To(BeTemporally("==", timestamp))
 		})
 
-		it("should require an argument for the --set-model flag", func() {
-			command := exec.Command(binaryPath, "--set-model")
-			session, err := gexec.Start(command, io.Discard, io.Discard)
+		it("records the token counts and counts the messages of older histories on read", func() {
+			Expect(fileIO.Write([]types.Message{
+				{Role: "user", Content: "counted once"},
+				{Role: "user", Content: "recounted", Tokens: 100, TokenEncoding: "another-encoding"},
+			})).To(Succeed())
+
+			data, err := os.ReadFile(path.Join(tmpDir, threadName+".json"))
 			Expect(err).NotTo(HaveOccurred())
+			Expect(strings.Count(string(data), `"token_encoding":"`+utils.TokenEncoding+`"`)).To(Equal(2))
 
-			Eventually(session).Should(gexec.Exit(exitFailure))
+			readMessages, err := fileIO.Read()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(readMessages[1].Tokens).To(Equal(utils.EstimateTokens("recounted")))
 
-			output := string(session.Err.Contents())
-			Expect(output).To(ContainSubstring("flag needs an argument: --set-model"))
-		})
+			Expect(os.WriteFile(path.Join(tmpDir, "legacy.json"), []byte(`[{"role":"user","content":"no count yet"}]`), 0644)).To(Succeed())
 
-		it("should require an argument for the --set-thread flag", func() {
-			command := exec.Command(binaryPath, "--set-thread")
-			session, err := gexec.Start(command, io.Discard, io.Discard)
+			readMessages, err = fileIO.ReadThread("legacy")
 			Expect(err).NotTo(HaveOccurred())
+			Expect(readMessages[0].Tokens).To(Equal(utils.EstimateTokens("no count yet")))
+			Expect(readMessages[0].TokenEncoding).To(Equal(utils.TokenEncoding))
+		})
 
-			Eventually(session).Should(gexec.Exit(exitFailure))
+		it("keeps the creation time of a thread that is missing from the index", func() {
+			legacy := path.Join(tmpDir, "legacy.json")
+			created := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+			Expect(os.WriteFile(legacy, []byte(`[{"role":"user","content":"hi"}]`), 0644)).To(Succeed())
+			Expect(os.Chtimes(legacy, created, created)).To(Succeed())
 
-			output := string(session.Err.Contents())
-			Expect(output).To(ContainSubstring("flag needs an argument: --set-thread"))
+			fileIO.SetThread("legacy")
+			Expect(fileIO.Write(messages)).To(Succeed())
+
+			threads, err := fileIO.ListThreads()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(threads).To(HaveLen(1))
+			Expect(threads[0].Created).To(BeTemporally("==", created))
+			Expect(threads[0].Messages).To(Equal(2))
 		})
 
-		it("should require an argument for the --set-max-tokens flag", func() {
-			command := exec.Command(binaryPath, "--set-max-tokens")
-			session, err := gexec.Start(command, io.Discard, io.Discard)
+		it("renames a thread and keeps its metadata", func() {
+			Expect(fileIO.Write(messages)).To(Succeed())
+
+			threads, err := fileIO.ListThreads()
 			Expect(err).NotTo(HaveOccurred())
+			created := threads[0].Created
 
-			Eventually(session).Should(gexec.Exit(exitFailure))
+			Expect(fileIO.RenameThread(threadName, "renamed")).To(Succeed())
+			Expect(fileIO.GetThread()).To(Equal("renamed"))
 
-			output := string(session.Err.Contents())
-			Expect(output).To(ContainSubstring("flag needs an argument: --set-max-tokens"))
+			threads, err = fileIO.ListThreads()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(threads).To(HaveLen(1))
+			Expect(threads[0].Name).To(Equal("renamed"))
+			Expect(threads[0].Created).To(BeTemporally("==", created))
+			Expect(threads[0].Messages).To(Equal(2))
+
+			Expect(fileIO.RenameThread("does-not-exist", "other")).To(MatchError("thread does-not-exist does not exist"))
+
+			fileIO.SetThread("other")
+			Expect(fileIO.Write(messages)).To(Succeed())
+			Expect(fileIO.RenameThread("renamed", "other")).To(MatchError("thread other already exists"))
 		})
 
-		it("should require an argument for the --set-context-window flag", func() {
-			command := exec.Command(binaryPath, "--set-context-window")
-			session, err := gexec.Start(command, io.Discard, io.Discard)
+		it("pins messages and keeps the pins when the thread is written", func() {
+			Expect(fileIO.Write([]types.Message{{Role: "system", Content: "hi"}, {Role: "user", Content: "we are on Go 1.21"}})).To(Succeed())
+			Expect(fileIO.SetPinned(threadName, []int{0}, true)).To(MatchError("message 0 is a system prompt, which is always kept"))
+			Expect(fileIO.SetPinned(threadName, []int{1}, true)).To(Succeed())
+
+			stored, err := readHistoryFile(path.Join(tmpDir, threadName+".json"))
 			Expect(err).NotTo(HaveOccurred())
+			Expect(stored[1].Pinned).To(BeFalse()) // the wire form leaves the pin out
 
-			Eventually(session).Should(gexec.Exit(exitFailure))
+			data, err := os.ReadFile(path.Join(tmpDir, threadName+".json"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(ContainSubstring(`"pinned":true`))
 
-			output := string(session.Err.Contents())
-			Expect(output).To(ContainSubstring("flag needs an argument: --set-context-window"))
+			readMessages, err := fileIO.Read()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(readMessages[1].Pinned).To(BeTrue())
 		})
 
-		it("should warn when config.yaml does not exist and OPENAI_CONFIG_HOME is set", func() {
-			configHomeDir := "does-not-exist"
-			Expect(os.Setenv(utils.ConfigHomeEnv, configHomeDir)).To(Succeed())
+		it("keeps the model that gave each answer", func() {
+			Expect(fileIO.Write([]types.Message{
+				{Role: "user", Content: "question 1"},
+				{Role: "assistant", Content: "answer 1", Model: "gpt-4o"},
+				{Role: "user", Content: "question 2"},
+				{Role: "assistant", Content: "answer 2", Model: "gpt-4o-mini"},
+			})).To(Succeed())
 
-			configFilePath := path.Join(configHomeDir, "config.yaml")
-			Expect(configFilePath).NotTo(BeAnExistingFile())
+			data, err := os.ReadFile(path.Join(tmpDir, threadName+".json"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(ContainSubstring(`"model":"gpt-4o-mini"`))
 
-			command := exec.Command(binaryPath, "llm query")
-			session, err := gexec.Start(command, io.Discard, io.Discard)
+			readMessages, err := fileIO.Read()
 			Expect(err).NotTo(HaveOccurred())
+			Expect(readMessages[1].Model).To(Equal("gpt-4o"))
+			Expect(readMessages[3].Model).To(Equal("gpt-4o-mini"))
+		})
 
-			Eventually(session).Should(gexec.Exit(exitSuccess))
+		it("keeps the title of a thread through writes and renames", func() {
+			Expect(fileIO.SetTitle(threadName, "A title")).To(MatchError("thread " + threadName + " does not exist"))
 
-			output := string(session.Out.Contents())
-			Expect(output).To(ContainSubstring(fmt.Sprintf("Warning: config.yaml doesn't exist in %s, create it", configHomeDir)))
+			Expect(fileIO.Write(messages)).To(Succeed())
+			Expect(fileIO.SetTitle(threadName, "A title")).To(Succeed())
+			Expect(fileIO.Write(messages)).To(Succeed())
+			Expect(fileIO.RenameThread(threadName, "renamed")).To(Succeed())
 
-			// Unset the variable to prevent pollution
-			Expect(os.Unsetenv(utils.ConfigHomeEnv)).To(Succeed())
+			threads, err := fileIO.ListThreads()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(threads).To(HaveLen(1))
+			Expect(threads[0].Title).To(Equal("A title"))
 		})
 
-		it("should NOT warn when config.yaml does not exist and OPENAI_CONFIG_HOME is NOT set", func() {
-			configHomeDir := "does-not-exist"
-			Expect(os.Unsetenv(utils.ConfigHomeEnv)).To(Succeed())
+		it("keeps the tags of a thread through writes and renames", func() {
+			Expect(fileIO.SetTags(threadName, []string{"work"})).To(MatchError("thread " + threadName + " does not exist"))
 
-			configFilePath := path.Join(configHomeDir, "config.yaml")
-			Expect(configFilePath).NotTo(BeAnExistingFile())
+			Expect(fileIO.Write(messages)).To(Succeed())
+			Expect(fileIO.SetTags(threadName, []string{"golang", " Work"})).To(Succeed())
+			Expect(fileIO.SetTags(threadName, []string{"two words"})).To(MatchError(ContainSubstring("tags can't contain spaces")))
+			Expect(fileIO.Write(messages)).To(Succeed())
+			Expect(fileIO.RenameThread(threadName, "renamed")).To(Succeed())
 
-			command := exec.Command(binaryPath, "llm query")
-			session, err := gexec.Start(command, io.Discard, io.Discard)
+			threads, err := fileIO.ListThreads()
 			Expect(err).NotTo(HaveOccurred())
-
-			Eventually(session).Should(gexec.Exit(exitSuccess))
-
-			output := string(session.Out.Contents())
-			Expect(output).NotTo(ContainSubstring(fmt.Sprintf("Warning: config.yaml doesn't exist in %s, create it", configHomeDir)))
+			Expect(threads).To(HaveLen(1))
+			Expect(threads[0].Tags).To(Equal([]string{"golang", "work"}))
 		})
 
-		it("should require the chatgpt-cli folder but not an API key for the --set-model flag", func() {
-			Expect(os.Unsetenv(apiKeyEnvVar)).To(Succeed())
+		it("keeps a thread archived through writes and renames, and leaves it out of searches", func() {
+			Expect(fileIO.SetArchived(threadName, true)).To(MatchError("thread " + threadName + " does not exist"))
 
-			command := exec.Command(binaryPath, "--set-model", "123")
-			session, err := gexec.Start(command, io.Discard, io.Discard)
+			Expect(fileIO.Write(messages)).To(Succeed())
+			Expect(fileIO.SetArchived(threadName, true)).To(Succeed())
+			Expect(fileIO.Write(messages)).To(Succeed())
+			Expect(fileIO.RenameThread(threadName, "renamed")).To(Succeed())
+
+			threads, err := fileIO.ListThreads()
 			Expect(err).NotTo(HaveOccurred())
+			Expect(threads).To(HaveLen(1))
+			Expect(threads[0].Archived).To(BeTrue())
+
+			var found []string
+			search := func(opts history.SearchOptions) {
+				found = nil
+				Expect(fileIO.Search(opts, func(match history.SearchMatch) error {
+					found = append(found, match.Thread)
+					return nil
+				})).To(Succeed())
+			}
 
-			Eventually(session).Should(gexec.Exit(exitFailure))
+			search(history.SearchOptions{Term: messages[0].Content})
+			Expect(found).To(BeEmpty())
+			search(history.SearchOptions{Term: messages[0].Content, ArchivedThreads: true})
+			Expect(found).NotTo(BeEmpty())
 
-			output := string(session.Err.Contents())
-			Expect(output).To(ContainSubstring("config directory does not exist:"))
-			Expect(output).NotTo(ContainSubstring(apiKeyEnvVar))
+			Expect(fileIO.SetArchived("renamed", false)).To(Succeed())
+			search(history.SearchOptions{Term: messages[0].Content})
+			Expect(found).NotTo(BeEmpty())
 		})
 
-		it("should require the chatgpt-cli folder but not an API key for the --set-thread flag", func() {
-			Expect(os.Unsetenv(apiKeyEnvVar)).To(Succeed())
+		it("keeps the summary of a thread through writes and renames", func() {
+			summary := types.Summary{Topics: []string{"pgbouncer timeouts"}, Decisions: []string{"raise query_timeout"}}
+			Expect(fileIO.SetSummary(threadName, summary)).To(MatchError("thread " + threadName + " does not exist"))
 
-			command := exec.Command(binaryPath, "--set-thread", "thread-name")
-			session, err := gexec.Start(command, io.Discard, io.Discard)
+			Expect(fileIO.Write(messages)).To(Succeed())
+			Expect(fileIO.SetSummary(threadName, summary)).To(Succeed())
+			Expect(fileIO.Write(messages)).To(Succeed())
+			Expect(fileIO.RenameThread(threadName, "renamed")).To(Succeed())
+
+			threads, err := fileIO.ListThreads()
 			Expect(err).NotTo(HaveOccurred())
+			Expect(threads).To(HaveLen(1))
+			Expect(threads[0].Summary).To(Equal(&summary))
+		})
 
-			Eventually(session).Should(gexec.Exit(exitFailure))
+		when("the history has an older schema version", func() {
+			var threadFile string
 
-			output := string(session.Err.Contents())
-			Expect(output).To(ContainSubstring("config directory does not exist:"))
-			Expect(output).NotTo(ContainSubstring(apiKeyEnvVar))
-		})
+			copyFixture := func(fixture string) {
+				data, err := utils.FileToBytes(path.Join("history", fixture))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(os.WriteFile(threadFile, data, 0644)).To(Succeed())
+			}
 
-		it("should require the chatgpt-cli folder but not an API key for the --set-max-tokens flag", func() {
-			Expect(os.Unsetenv(apiKeyEnvVar)).To(Succeed())
+			it.Before(func() {
+				threadFile = path.Join(tmpDir, threadName+".json")
+			})
 
-			command := exec.Command(binaryPath, "--set-max-tokens", "789")
-			session, err := gexec.Start(command, io.Discard, io.Discard)
-			Expect(err).NotTo(HaveOccurred())
+			for _, fixture := range []string{"v0-plain.json", "v0-timestamps.json", "v0-tokens.json", "v1.json"} {
+				fixture := fixture
 
-			Eventually(session).Should(gexec.Exit(exitFailure))
+				it("reads "+fixture+" and writes it back in the current version", func() {
+					copyFixture(fixture)
 
-			output := string(session.Err.Contents())
-			Expect(output).To(ContainSubstring("config directory does not exist:"))
-			Expect(output).NotTo(ContainSubstring(apiKeyEnvVar))
-		})
+					read, err := fileIO.Read()
+					Expect(err).NotTo(HaveOccurred())
+					Expect(read).To(HaveLen(3))
+					Expect(read[1].Role).To(Equal("user"))
+					Expect(read[1].Content).To(Equal("Which bars are in Red Hook?"))
+					Expect(read[2].Content).To(Equal("Sunny's Bar and Red Hook Bait & Tackle."))
 
-		it("should require the chatgpt-cli folder but not an API key for the --set-context-window flag", func() {
-			Expect(os.Unsetenv(apiKeyEnvVar)).To(Succeed())
+					stored, err := readHistoryFile(threadFile)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(stored).To(HaveLen(3))
 
-			command := exec.Command(binaryPath, "--set-context-window", "789")
-			session, err := gexec.Start(command, io.Discard, io.Discard)
-			Expect(err).NotTo(HaveOccurred())
+					reread, err := fileIO.Read()
+					Expect(err).NotTo(HaveOccurred())
+					Expect(reread).To(Equal(read))
 
-			Eventually(session).Should(gexec.Exit(exitFailure))
+					threads, err := fileIO.ListThreads()
+					Expect(err).NotTo(HaveOccurred())
+					Expect(threads[0].Messages).To(Equal(3))
+				})
+			}
 
-			output := string(session.Err.Contents())
-			Expect(output).To(ContainSubstring("config directory does not exist:"))
-			Expect(output).NotTo(ContainSubstring(apiKeyEnvVar))
-		})
+			it("keeps the timestamps, token counts and alternatives of older versions", func() {
+				copyFixture("v0-tokens.json")
 
-		it("should return the expected result for the --version flag", func() {
-			output := runCommand("--version")
+				read, err := fileIO.Read()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(read[1].Timestamp).To(Equal(time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)))
+				Expect(read[2].Tokens).To(Equal(42))
+				Expect(read[2].Alternatives).To(Equal([]string{"Try Sunny's Bar."}))
+			})
 
-			Expect(output).To(ContainSubstring(fmt.Sprintf("commit %s", gitCommit)))
-			Expect(output).To(ContainSubstring(fmt.Sprintf("version %s", gitVersion)))
-		})
+			it("refuses files written by a newer version and leaves them alone", func() {
+				copyFixture("v2.json")
+				before, err := os.ReadFile(threadFile)
+				Expect(err).NotTo(HaveOccurred())
 
-		it("should return the expected result for the --list-models flag", func() {
-			output := runCommand("--list-models")
+				_, err = fileIO.Read()
+				Expect(errors.Is(err, history.ErrNewerSchema)).To(BeTrue())
+				Expect(err).To(MatchError(ContainSubstring("upgrade chatgpt-cli")))
 
-			Expect(output).To(ContainSubstring("* gpt-3.5-turbo (current)"))
-			Expect(output).To(ContainSubstring("- gpt-3.5-turbo-0301"))
+				Expect(fileIO.Update(func(messages []types.Message) []types.Message {
+					return messages
+				})).To(MatchError(history.ErrNewerSchema))
+
+				after, err := os.ReadFile(threadFile)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(after).To(Equal(before))
+			})
 		})
 
-		it("should return the expected result for the --query flag", func() {
-			Expect(os.Setenv("OPENAI_TRACK_TOKEN_USAGE", "false")).To(Succeed())
+		when("a thread is damaged", func() {
+			var (
+				threadFile string
+				repairs    []history.RepairResult
+			)
 
-			output := runCommand("--query", "some-query")
+			copyFixture := func(fixture string) []byte {
+				data, err := utils.FileToBytes(path.Join("history", fixture))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(os.WriteFile(threadFile, data, 0644)).To(Succeed())
+				return data
+			}
 
-			expectedResponse := `I don't have personal opinions about bars, but here are some popular bars in Red Hook, Brooklyn:`
-			Expect(output).To(ContainSubstring(expectedResponse))
-			Expect(output).NotTo(ContainSubstring("Token Usage:"))
-		})
+			it.Before(func() {
+				threadFile = path.Join(tmpDir, threadName+".json")
+				repairs = nil
+			})
 
-		it("should display token usage after a query when configured to do so", func() {
-			Expect(os.Setenv("OPENAI_TRACK_TOKEN_USAGE", "true")).To(Succeed())
+			for _, example := range []struct {
+				fixture   string
+				recovered []string
+				lost      int
+			}{
+				{"damaged-truncated.json", []string{"system", "user", "assistant"}, 1},
+				{"damaged-garbage.json", []string{"system", "assistant", "user"}, 1},
+				{"damaged-utf8.json", []string{"system", "user", "assistant", "user"}, 0},
+			} {
+				example := example
 
-			output := runCommand("--query", "tell me a 5 line joke")
-			Expect(output).To(ContainSubstring("Token Usage:"))
-		})
+				it("recovers what can be read of "+example.fixture+" and moves the damaged file aside", func() {
+					data := copyFixture(example.fixture)
 
-		it("prints debug information with the --debug flag", func() {
-			Expect(os.Setenv("OPENAI_DEBUG", "true")).To(Succeed())
+					_, err := fileIO.Read()
+					Expect(err).To(HaveOccurred())
 
-			output := runCommand("--query", "tell me a joke")
+					fileIO.WithRecovery(func(result history.RepairResult) {
+						repairs = append(repairs, result)
+					})
 
-			Expect(output).To(ContainSubstring("Generated cURL command"))
-			Expect(output).To(ContainSubstring("/v1/chat/completions"))
-			Expect(output).To(ContainSubstring("--header \"Authorization: Bearer ${OPENAI_API_KEY}\""))
-			Expect(output).To(ContainSubstring("--header 'Content-Type: application/json'"))
-			Expect(output).To(ContainSubstring("\"model\":\"gpt-3.5-turbo\""))
-			Expect(output).To(ContainSubstring("\"messages\":"))
-			Expect(output).To(ContainSubstring("Response"))
+					read, err := fileIO.Read()
+					Expect(err).NotTo(HaveOccurred())
 
-			Expect(os.Unsetenv("OPENAI_DEBUG")).To(Succeed())
-		})
+					var roles []string
+					for _, message := range read {
+						roles = append(roles, message.Role)
+					}
+					Expect(roles).To(Equal(example.recovered))
+					Expect(read[0].Content).To(Equal("You are a helpful assistant."))
 
-		it("should assemble http errors as expected", func() {
-			Expect(os.Setenv(apiKeyEnvVar, "wrong-token")).To(Succeed())
+					Expect(repairs).To(HaveLen(1))
+					Expect(repairs[0].Thread).To(Equal(threadName))
+					Expect(repairs[0].Recovered).To(Equal(len(example.recovered)))
+					Expect(repairs[0].Lost).To(Equal(example.lost))
+					Expect(repairs[0].Moved).To(HavePrefix(threadFile + ".corrupt-"))
 
-			command := exec.Command(binaryPath, "--query", "some-query")
-			session, err := gexec.Start(command, io.Discard, io.Discard)
-			Expect(err).NotTo(HaveOccurred())
+					moved, err := os.ReadFile(repairs[0].Moved)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(moved).To(Equal(data))
 
-			Eventually(session).Should(gexec.Exit(exitFailure))
+					stored, err := readHistoryFile(threadFile)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(stored).To(HaveLen(len(example.recovered)))
 
-			output := string(session.Err.Contents())
+					threads, err := fileIO.ListThreads()
+					Expect(err).NotTo(HaveOccurred())
+					Expect(threads).To(HaveLen(1))
 
-			// see error.json
-			Expect(output).To(Equal("http status 401: Incorrect API key provided\n"))
+					reread, err := fileIO.Read()
+					Expect(err).NotTo(HaveOccurred())
+					Expect(reread).To(Equal(read))
+					Expect(repairs).To(HaveLen(1))
+				})
+			}
+
+			it("replaces what isn't valid UTF-8", func() {
+				copyFixture("damaged-utf8.json")
+
+				results, err := fileIO.Repair()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(results).To(HaveLen(1))
+
+				read, err := fileIO.Read()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(read[2].Content).To(Equal("Sunny's Bar and \uFFFD\uFFFDRed Hook Bait & Tackle."))
+			})
+
+			it("repairs every thread and archive, from the backup when it has more messages", func() {
+				Expect(fileIO.Write(messages)).To(Succeed())
+				data, err := os.ReadFile(threadFile)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(os.WriteFile(threadFile, data[:len(data)/2], 0644)).To(Succeed())
+
+				fileIO.SetThread("rotated")
+				fileIO.WithRotation(2, 0)
+				Expect(fileIO.Write(append(append([]types.Message(nil), messages...), messages...))).To(Succeed())
+				fileIO.WithRotation(0, 0)
+
+				archive := path.Join(tmpDir, ".archive", "rotated.1.json.gz")
+				Expect(os.WriteFile(archive, []byte{0x1f, 0x8b, 0x08, 0x00, 0x01}, 0644)).To(Succeed())
+
+				results, err := fileIO.Repair()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(results).To(HaveLen(2))
+
+				Expect(results[0].Thread).To(Equal(threadName))
+				Expect(results[0].FromBackup).To(BeTrue())
+				Expect(results[0].Recovered).To(Equal(2))
+				Expect(results[0].Lost).To(BeZero())
+
+				Expect(results[1].Thread).To(Equal("rotated"))
+				Expect(results[1].Archive).To(Equal(1))
+				Expect(results[1].Moved).To(HavePrefix(archive + ".corrupt-"))
+
+				read, err := fileIO.ReadThread(threadName)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(read).To(Equal(messages))
+
+				_, err = fileIO.ReadArchived("rotated")
+				Expect(err).NotTo(HaveOccurred())
+
+				results, err = fileIO.Repair()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(results).To(BeEmpty())
+			})
+
+			it("scans the threads without repairing them", func() {
+				Expect(fileIO.Write(messages)).To(Succeed())
+				data, err := os.ReadFile(threadFile)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(os.WriteFile(threadFile, data[:len(data)/2], 0644)).To(Succeed())
+
+				results, err := fileIO.Scan()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(results).To(HaveLen(1))
+				Expect(results[0].Thread).To(Equal(threadName))
+				Expect(results[0].FromBackup).To(BeTrue())
+				Expect(results[0].Recovered).To(Equal(2))
+				Expect(results[0].Moved).To(BeEmpty())
+
+				scanned, err := os.ReadFile(threadFile)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(scanned).To(Equal(data[:len(data)/2]))
+
+				matches, err := filepath.Glob(threadFile + ".corrupt-*")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(matches).To(BeEmpty())
+			})
 		})
 
-		when("there is a hidden chatgpt-cli folder in the home dir", func() {
-			it.Before(func() {
-				filePath = path.Join(os.Getenv("HOME"), ".chatgpt-cli")
-				Expect(os.MkdirAll(filePath, 0777)).To(Succeed())
+		when("the threads are capped", func() {
+			conversation := func(exchanges int) []types.Message {
+				result := []types.Message{{Role: "system", Content: "You are a helpful assistant."}}
+				for i := 0; i < exchanges; i++ {
+					result = append(result,
+						types.Message{Role: "user", Content: fmt.Sprintf("question %d", i)},
+						types.Message{Role: "assistant", Content: fmt.Sprintf("answer %d", i)},
+					)
+				}
+
+				// the store records the token counts, so the messages it returns carry them
+				for i := range result {
+					result[i].Tokens = utils.EstimateTokens(result[i].Content)
+					result[i].TokenEncoding = utils.TokenEncoding
+				}
+				return result
+			}
+
+			it("archives the oldest messages and keeps the system prompt and whole exchanges", func() {
+				fileIO.WithRotation(8, 0)
+				all := conversation(5)
+
+				Expect(fileIO.Write(all[:7])).To(Succeed())
+				Expect(path.Join(tmpDir, ".archive")).NotTo(BeADirectory())
+
+				Expect(fileIO.Write(all)).To(Succeed())
+
+				active, err := fileIO.Read()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(active).To(Equal(append(all[:1:1], all[7:]...)))
+
+				Expect(path.Join(tmpDir, ".archive", threadName+".1.json.gz")).To(BeARegularFile())
+
+				readMessages, err := fileIO.ReadArchived(threadName)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(readMessages).To(Equal(all))
+
+				threads, err := fileIO.ListThreads()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(threads).To(HaveLen(1))
+				Expect(threads[0].Messages).To(Equal(len(active)))
 			})
 
-			it.After(func() {
-				Expect(os.RemoveAll(filePath)).To(Succeed())
+			it("merges a thread along with its archives and removes them", func() {
+				fileIO.WithRotation(8, 0)
+				all := conversation(5)
+
+				fileIO.SetThread("fork")
+				Expect(fileIO.Write(all)).To(Succeed())
+				Expect(path.Join(tmpDir, ".archive", "fork.1.json.gz")).To(BeARegularFile())
+
+				fileIO.WithRotation(0, 0)
+				fileIO.SetThread(threadName)
+				Expect(fileIO.Write(all[:3])).To(Succeed())
+
+				result, err := fileIO.MergeThreads(threadName, "fork", history.MergeAppend)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result).To(Equal(history.MergeResult{Added: len(all) - 3, Dropped: 3}))
+
+				merged, err := fileIO.Read()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(merged).To(Equal(all))
+
+				Expect(path.Join(tmpDir, "fork.json")).NotTo(BeAnExistingFile())
+				Expect(path.Join(tmpDir, ".archive", "fork.1.json.gz")).NotTo(BeAnExistingFile())
 			})
 
-			it("should not require an API key for the --list-threads flag", func() {
-				historyPath := path.Join(filePath, "history")
-				Expect(os.MkdirAll(historyPath, 0777)).To(Succeed())
+			it("keeps the pinned messages in the thread", func() {
+				fileIO.WithRotation(8, 0)
+				all := conversation(5)
+				all[1].Pinned = true
 
-				Expect(os.Unsetenv(apiKeyEnvVar)).To(Succeed())
+				Expect(fileIO.Write(all)).To(Succeed())
 
-				command := exec.Command(binaryPath, "--list-threads")
-				session, err := gexec.Start(command, io.Discard, io.Discard)
+				active, err := fileIO.Read()
 				Expect(err).NotTo(HaveOccurred())
+				Expect(active).To(Equal(append(all[:2:2], all[7:]...)))
 
-				Eventually(session).Should(gexec.Exit(exitSuccess))
+				archived, err := fileIO.ReadArchived(threadName)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(archived).To(HaveLen(len(all)))
 			})
 
-			it("migrates the legacy history as expected", func() {
-				// Legacy history file should not exist
-				legacyFile := path.Join(filePath, "history")
-				Expect(legacyFile).NotTo(BeAnExistingFile())
+			it("never cuts between a question and its answer", func() {
+				fileIO.WithRotation(4, 0)
+				all := conversation(2)
+				all = append(all, types.Message{Role: "assistant", Content: "a follow up", Tokens: utils.EstimateTokens("a follow up"), TokenEncoding: utils.TokenEncoding})
 
-				// History should not exist yet
-				historyFile := path.Join(filePath, "history", "default.json")
-				Expect(historyFile).NotTo(BeAnExistingFile())
+				Expect(fileIO.Write(all)).To(Succeed())
 
-				bytes, err := utils.FileToBytes("history.json")
+				active, err := fileIO.Read()
 				Expect(err).NotTo(HaveOccurred())
+				Expect(active).To(Equal(append(all[:1:1], all[3:]...)))
+			})
 
-				Expect(os.WriteFile(legacyFile, bytes, 0644)).To(Succeed())
-				Expect(legacyFile).To(BeARegularFile())
+			it("caps the threads by size", func() {
+				all := conversation(20)
 
-				// Perform a query
-				command := exec.Command(binaryPath, "--query", "some-query")
-				session, err := gexec.Start(command, io.Discard, io.Discard)
+				data, err := json.Marshal(all)
 				Expect(err).NotTo(HaveOccurred())
+				fileIO.WithRotation(0, len(data)/2)
 
-				// The CLI response should be as expected
-				Eventually(session).Should(gexec.Exit(exitSuccess))
+				Expect(fileIO.Write(all)).To(Succeed())
 
-				output := string(session.Out.Contents())
+				threadData, err := os.ReadFile(path.Join(tmpDir, threadName+".json"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(len(threadData)).To(BeNumerically("<=", len(data)/2))
 
-				response := `I don't have personal opinions about bars, but here are some popular bars in Red Hook, Brooklyn:`
-				Expect(output).To(ContainSubstring(response))
+				readMessages, err := fileIO.ReadArchived(threadName)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(readMessages).To(Equal(all))
+			})
+
+			it("numbers the archives in the order they were written", func() {
+				fileIO.WithRotation(4, 0)
+				all := conversation(6)
+
+				Expect(fileIO.Write(all[:7])).To(Succeed())
+				Expect(fileIO.Update(func(messages []types.Message) []types.Message {
+					return append(messages, all[7:]...)
+				})).To(Succeed())
+
+				for _, name := range []string{threadName + ".1.json.gz", threadName + ".2.json.gz"} {
+					Expect(path.Join(tmpDir, ".archive", name)).To(BeARegularFile())
+				}
+
+				readMessages, err := fileIO.ReadArchived(threadName)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(readMessages).To(Equal(all))
+			})
+
+			it("searches the archives when asked to", func() {
+				fileIO.WithRotation(4, 0)
+				Expect(fileIO.Write(conversation(3))).To(Succeed())
+
+				search := func(archives bool) []history.SearchMatch {
+					var matches []history.SearchMatch
+					Expect(fileIO.Search(history.SearchOptions{Term: "question", Archives: archives}, func(match history.SearchMatch) error {
+						matches = append(matches, match)
+						return nil
+					})).To(Succeed())
+					return matches
+				}
+
+				Expect(search(false)).To(HaveLen(1))
+
+				matches := search(true)
+				Expect(matches).To(HaveLen(3))
+				Expect(matches[0].Archive).To(Equal(1))
+				Expect(matches[0].Index).To(Equal(0))
+				Expect(matches[2].Archive).To(BeZero())
+				Expect(matches[2].Index).To(Equal(1))
+			})
+
+			it("adds up the archived messages in the statistics", func() {
+				fileIO.WithRotation(4, 0)
+				all := conversation(3)
+				Expect(fileIO.Write(all)).To(Succeed())
+
+				stats, err := fileIO.Stats(threadName, history.DateRange{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(stats.Messages).To(Equal(map[string]int{"system": 1, "user": 3, "assistant": 3}))
+
+				tokens := 0
+				for _, message := range all {
+					tokens += message.Tokens
+				}
+				Expect(stats.PromptTokens + stats.CompletionTokens).To(Equal(tokens))
+				Expect(stats.Longest.Role).To(Equal("system"))
+			})
+
+			it("clears and renames the archives along with the thread", func() {
+				fileIO.WithRotation(4, 0)
+				Expect(fileIO.Write(conversation(3))).To(Succeed())
+
+				Expect(fileIO.RenameThread(threadName, "renamed")).To(Succeed())
+				Expect(path.Join(tmpDir, ".archive", threadName+".1.json.gz")).NotTo(BeAnExistingFile())
+				Expect(path.Join(tmpDir, ".archive", "renamed.1.json.gz")).To(BeARegularFile())
+
+				Expect(fileIO.Clear("renamed")).To(Succeed())
+				Expect(path.Join(tmpDir, ".archive", "renamed.1.json.gz")).NotTo(BeAnExistingFile())
+			})
+
+			it("compresses the archives but never the active thread", func() {
+				fileIO.WithRotation(4, 0)
+				Expect(fileIO.Write(conversation(3))).To(Succeed())
+
+				archived, err := os.ReadFile(path.Join(tmpDir, ".archive", threadName+".1.json.gz"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(archived[:2]).To(Equal([]byte{0x1f, 0x8b}))
+
+				active, err := os.ReadFile(path.Join(tmpDir, threadName+".json"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(active)).To(HavePrefix("{"))
+			})
+
+			it("compresses the archives that were written uncompressed in place", func() {
+				all := conversation(3)
+				Expect(fileIO.Write(append(all[:1:1], all[5:]...))).To(Succeed())
+
+				plain := path.Join(tmpDir, ".archive", threadName+".1.json")
+				Expect(os.MkdirAll(path.Dir(plain), 0755)).To(Succeed())
+				data, err := json.Marshal(all[1:5])
+				Expect(err).NotTo(HaveOccurred())
+				Expect(os.WriteFile(plain, data, 0644)).To(Succeed())
+
+				modified := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+				Expect(os.Chtimes(plain, modified, modified)).To(Succeed())
+
+				count, err := fileIO.CompressArchives()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(count).To(Equal(1))
+
+				Expect(plain).NotTo(BeAnExistingFile())
+				info, err := os.Stat(plain + ".gz")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(info.ModTime()).To(BeTemporally("==", modified))
+
+				readMessages, err := fileIO.ReadArchived(threadName)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(readMessages).To(Equal(all))
+
+				count, err = fileIO.CompressArchives()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(count).To(BeZero())
+			})
+
+			it("names the archive that can't be decompressed", func() {
+				Expect(fileIO.Write(conversation(1))).To(Succeed())
+
+				damaged := path.Join(tmpDir, ".archive", threadName+".1.json.gz")
+				Expect(os.MkdirAll(path.Dir(damaged), 0755)).To(Succeed())
+				Expect(os.WriteFile(damaged, []byte{0x1f, 0x8b, 0x08, 0x00, 0x01}, 0644)).To(Succeed())
+
+				_, err := fileIO.ReadArchived(threadName)
+				Expect(err).To(MatchError(ContainSubstring("failed to decompress " + damaged)))
+
+				err = fileIO.Search(history.SearchOptions{Term: "question", Archives: true}, func(history.SearchMatch) error {
+					return nil
+				})
+				Expect(err).To(MatchError(ContainSubstring("failed to decompress " + damaged)))
+			})
+		})
+
+		it("reads a page of a thread, in the order it was written", func() {
+			var all []types.Message
+			for i := 0; i < 10; i++ {
+				all = append(all, types.Message{Role: "user", Content: fmt.Sprintf("question %d", i)})
+			}
+			Expect(fileIO.Write(all)).To(Succeed())
+
+			written, err := fileIO.Read()
+			Expect(err).NotTo(HaveOccurred())
+
+			page, total, err := fileIO.ReadPage(threadName, 4, 3)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(total).To(Equal(10))
+			Expect(page).To(Equal(written[4:7]))
+
+			page, total, err = fileIO.ReadPage(threadName, 8, 5)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(total).To(Equal(10))
+			Expect(page).To(Equal(written[8:]))
+
+			page, total, err = fileIO.ReadPage(threadName, 10, 5)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(total).To(Equal(10))
+			Expect(page).To(BeEmpty())
+
+			page, total, err = fileIO.ReadPage("does-not-exist", 0, 5)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(total).To(BeZero())
+			Expect(page).To(BeEmpty())
+
+			// a truncated thread is read from its backup instead
+			threadFile := path.Join(tmpDir, threadName+".json")
+			data, err := os.ReadFile(threadFile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(os.WriteFile(threadFile, data[:len(data)/2], 0644)).To(Succeed())
+
+			page, total, err = fileIO.ReadPage(threadName, 9, 5)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(total).To(Equal(10))
+			Expect(page).To(Equal(written[9:]))
+		})
+
+		it("deletes messages from the thread and its backup", func() {
+			all := append([]types.Message{{Role: "system", Content: "You are a helpful assistant."}}, messages...)
+			Expect(fileIO.Write(all)).To(Succeed())
+
+			result, err := fileIO.DeleteMessages(threadName, []int{1}, true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Deleted).To(Equal([]int{1, 2}))
+
+			for _, name := range []string{threadName + ".json", threadName + ".json.bak"} {
+				data, err := os.ReadFile(path.Join(tmpDir, name))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(data)).NotTo(ContainSubstring("Test message"), name)
+			}
+
+			_, err = fileIO.DeleteMessages(threadName, []int{0}, false)
+			Expect(err).To(MatchError("message 0 is a system prompt and can't be deleted"))
+
+			threads, err := fileIO.ListThreads()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(threads[0].Messages).To(Equal(1))
+		})
+
+		when("the history is pruned", func() {
+			old := time.Now().Add(-48 * time.Hour)
+
+			it("removes the old exchanges and keeps the system prompt", func() {
+				all := []types.Message{
+					{Role: "system", Content: "You are a helpful assistant.", Timestamp: old},
+					{Role: "user", Content: "old question", Timestamp: old},
+					{Role: "assistant", Content: "old answer", Timestamp: old},
+					{Role: "user", Content: "old question, recent answer", Timestamp: old},
+					{Role: "assistant", Content: "recent answer", Timestamp: time.Now()},
+				}
+				Expect(fileIO.Write(all)).To(Succeed())
+
+				result, err := fileIO.Prune(24*time.Hour, true)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result).To(Equal([]history.PruneResult{{Thread: threadName, Removed: 2, Kept: 3}}))
+
+				readMessages, err := fileIO.Read()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(readMessages).To(HaveLen(5))
+
+				result, err = fileIO.Prune(24*time.Hour, false)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result).To(HaveLen(1))
+
+				readMessages, err = fileIO.Read()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(readMessages).To(HaveLen(3))
+				Expect(readMessages[0].Role).To(Equal("system"))
+				Expect(readMessages[1].Content).To(Equal("old question, recent answer"))
+
+				threads, err := fileIO.ListThreads()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(threads[0].Messages).To(Equal(3))
+			})
+
+			it("dates the messages of older histories by their file and removes threads that are entirely old", func() {
+				legacy := path.Join(tmpDir, "legacy.json")
+				Expect(os.WriteFile(legacy, []byte(`[{"role":"user","content":"hi"},{"role":"assistant","content":"hello"}]`), 0644)).To(Succeed())
+				Expect(os.Chtimes(legacy, old, old)).To(Succeed())
+
+				Expect(fileIO.Write(messages)).To(Succeed())
+
+				result, err := fileIO.Prune(24*time.Hour, false)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result).To(Equal([]history.PruneResult{{Thread: "legacy", Removed: 2}}))
+				Expect(legacy).NotTo(BeAnExistingFile())
+
+				readMessages, err := fileIO.Read()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(readMessages).To(Equal(messages))
+			})
+
+			it("prunes the archives before the thread", func() {
+				fileIO.WithRotation(4, 0)
+
+				var all []types.Message
+				for i := 0; i < 4; i++ {
+					all = append(all,
+						types.Message{Role: "user", Content: fmt.Sprintf("question %d", i), Timestamp: old},
+						types.Message{Role: "assistant", Content: fmt.Sprintf("answer %d", i), Timestamp: old},
+					)
+				}
+				all[7].Timestamp = time.Now()
+				Expect(fileIO.Write(all)).To(Succeed())
+				Expect(path.Join(tmpDir, ".archive", threadName+".1.json.gz")).To(BeARegularFile())
+
+				result, err := fileIO.Prune(24*time.Hour, false)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result).To(Equal([]history.PruneResult{{Thread: threadName, Removed: 6, Kept: 2}}))
+				Expect(path.Join(tmpDir, ".archive", threadName+".1.json.gz")).NotTo(BeAnExistingFile())
+
+				readMessages, err := fileIO.ReadArchived(threadName)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(readMessages).To(HaveLen(2))
+				Expect(readMessages[0].Content).To(Equal("question 3"))
+			})
+		})
+
+		when("the history is encrypted", func() {
+			const encryptedMagic = "chatgpt-cli encrypted history v1\n"
+
+			var encrypted *history.FileIO
+
+			it.Before(func() {
+				encrypted, _ = history.New()
+				encrypted = encrypted.WithDirectory(tmpDir).WithPassphrase([]byte("correct horse"))
+				encrypted.SetThread(threadName)
+			})
+
+			it("writes the thread encrypted and reads it back", func() {
+				Expect(encrypted.Write(messages)).To(Succeed())
+
+				data, err := os.ReadFile(path.Join(tmpDir, threadName+".json"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(data)).To(HavePrefix(encryptedMagic))
+				Expect(string(data)).NotTo(ContainSubstring("Test message"))
+
+				readMessages, err := encrypted.Read()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(readMessages).To(Equal(messages))
+			})
+
+			it("refuses to read the thread with the wrong passphrase or without one", func() {
+				Expect(encrypted.Write(messages)).To(Succeed())
+
+				wrong, _ := history.New()
+				wrong = wrong.WithDirectory(tmpDir).WithPassphrase([]byte("wrong horse"))
+				_, err = wrong.ReadThread(threadName)
+				Expect(err).To(MatchError(history.ErrWrongKey))
+
+				_, err = fileIO.Read()
+				Expect(err).To(MatchError(history.ErrEncrypted))
+			})
+
+			it("still reads plain threads and encrypts them on the next write", func() {
+				Expect(fileIO.Write(messages)).To(Succeed())
+
+				readMessages, err := encrypted.Read()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(readMessages).To(Equal(messages))
+
+				Expect(encrypted.Write(readMessages)).To(Succeed())
+
+				data, err := os.ReadFile(path.Join(tmpDir, threadName+".json"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(data)).To(HavePrefix(encryptedMagic))
+			})
+
+			it("encrypts the existing history in place", func() {
+				Expect(fileIO.Write(messages)).To(Succeed())
+				fileIO.SetThread("other-thread")
+				Expect(fileIO.Write(messages[:1])).To(Succeed())
+
+				count, err := encrypted.EncryptHistory()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(count).To(Equal(2))
+
+				for _, name := range []string{threadName + ".json", threadName + ".json.bak", "other-thread.json"} {
+					data, err := os.ReadFile(path.Join(tmpDir, name))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(data)).To(HavePrefix(encryptedMagic), name)
+				}
+
+				count, err = encrypted.EncryptHistory()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(count).To(BeZero())
+
+				readMessages, err := encrypted.ReadThread("other-thread")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(readMessages).To(Equal(messages[:1]))
+			})
+
+			it("encrypts the titles of the index, those written before the history was encrypted too", func() {
+				// the fallback title is the first question, cut short
+				Expect(fileIO.Write(messages)).To(Succeed())
+				Expect(fileIO.SetTitle(threadName, "Test message 1 about the layoffs...")).To(Succeed())
+
+				count, err := encrypted.EncryptHistory()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(count).To(Equal(1))
+
+				data, err := os.ReadFile(path.Join(tmpDir, ".index.json"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(data)).To(HavePrefix(encryptedMagic))
+				Expect(string(data)).NotTo(ContainSubstring("layoffs"))
+
+				threads, err := encrypted.ListThreads()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(threads[0].Title).To(Equal("Test message 1 about the layoffs..."))
+
+				Expect(encrypted.SetTitle(threadName, "Quarterly layoffs")).To(Succeed())
+				data, err = os.ReadFile(path.Join(tmpDir, ".index.json"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(data)).NotTo(ContainSubstring("layoffs"))
+			})
+
+			it("encrypts the summaries of the index, and keeps them from a store without the passphrase", func() {
+				Expect(encrypted.Write(messages)).To(Succeed())
+				summary := types.Summary{Topics: []string{"the secret merger"}, Decisions: []string{"buy the rival"}}
+				Expect(encrypted.SetSummary(threadName, summary)).To(Succeed())
+
+				data, err := os.ReadFile(path.Join(tmpDir, ".index.json"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(data)).To(HavePrefix(encryptedMagic))
+				Expect(string(data)).NotTo(ContainSubstring("secret merger"))
+
+				threads, err := encrypted.ListThreads()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(threads).To(HaveLen(1))
+				Expect(threads[0].Summary).To(Equal(&summary))
+
+				// a store without the passphrase lists the threads from their files, and leaves the index as it is
+				threads, err = fileIO.ListThreads()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(threads).To(HaveLen(1))
+				Expect(threads[0].Summary).To(BeNil())
+				Expect(fileIO.SetSummary(threadName, types.Summary{})).To(MatchError(history.ErrEncrypted))
+
+				threads, err = encrypted.ListThreads()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(threads[0].Summary).To(Equal(&summary))
+			})
+
+			it("lists and searches the encrypted threads", func() {
+				Expect(encrypted.Write(messages)).To(Succeed())
+
+				threads, err := encrypted.ListThreads()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(threads).To(HaveLen(1))
+				Expect(threads[0].Messages).To(Equal(2))
+
+				var matches []history.SearchMatch
+				Expect(encrypted.Search(history.SearchOptions{Term: "message 2"}, func(match history.SearchMatch) error {
+					matches = append(matches, match)
+					return nil
+				})).To(Succeed())
+				Expect(matches).To(HaveLen(1))
+				Expect(matches[0].Thread).To(Equal(threadName))
+				Expect(matches[0].Index).To(Equal(1))
+			})
+		})
+
+		when("the history is backed up", func() {
+			var (
+				backup   bytes.Buffer
+				restored *history.FileIO
+			)
+
+			// archive writes the entries to a gzipped tar archive, to make backups that Backup doesn't
+			archive := func(entries map[string]string) []byte {
+				var buffer bytes.Buffer
+				gz := gzip.NewWriter(&buffer)
+				writer := tar.NewWriter(gz)
+				for name, content := range entries {
+					Expect(writer.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})).To(Succeed())
+					_, err := writer.Write([]byte(content))
+					Expect(err).NotTo(HaveOccurred())
+				}
+				Expect(writer.Close()).To(Succeed())
+				Expect(gz.Close()).To(Succeed())
+				return buffer.Bytes()
+			}
+
+			it.Before(func() {
+				backup.Reset()
+
+				Expect(fileIO.Write(messages)).To(Succeed())
+				Expect(fileIO.SetTitle(threadName, "Test messages")).To(Succeed())
+
+				// the other thread is rotated, so it has an archive to back up
+				fileIO.SetThread("other-thread")
+				fileIO.WithRotation(2, 0)
+				Expect(fileIO.Write(append(append([]types.Message(nil), messages...), messages...))).To(Succeed())
+				fileIO.WithRotation(0, 0)
+				fileIO.SetThread(threadName)
+				Expect(path.Join(tmpDir, ".archive", "other-thread.1.json.gz")).To(BeARegularFile())
+
+				threads, err := fileIO.Backup(&backup)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(threads).To(Equal([]string{threadName, "other-thread"}))
+
+				restored, _ = history.New()
+				restored = restored.WithDirectory(t.TempDir())
+			})
+
+			it("restores every thread with its archives and metadata", func() {
+				threads, err := restored.Restore(bytes.NewReader(backup.Bytes()), history.RestoreOptions{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(threads).To(Equal([]string{threadName, "other-thread"}))
+
+				for _, thread := range []string{threadName, "other-thread"} {
+					original, err := fileIO.ReadThread(thread)
+					Expect(err).NotTo(HaveOccurred())
+					readMessages, err := restored.ReadThread(thread)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(readMessages).To(Equal(original), thread)
+				}
+
+				originalThreads, err := fileIO.ListThreads()
+				Expect(err).NotTo(HaveOccurred())
+				restoredThreads, err := restored.ListThreads()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(restoredThreads).To(HaveLen(2))
+				for i, thread := range restoredThreads {
+					Expect(thread.Name).To(Equal(originalThreads[i].Name))
+					Expect(thread.Title).To(Equal(originalThreads[i].Title))
+					Expect(thread.Created).To(BeTemporally("~", originalThreads[i].Created, time.Second))
+					Expect(thread.Updated).To(BeTemporally("~", originalThreads[i].Updated, time.Second))
+				}
+
+				var matches []history.SearchMatch
+				Expect(restored.Search(history.SearchOptions{Term: "message 1", Archives: true}, func(match history.SearchMatch) error {
+					matches = append(matches, match)
+					return nil
+				})).To(Succeed())
+				Expect(matches).To(HaveLen(3))
+			})
+
+			it("restores a single thread out of the backup", func() {
+				threads, err := restored.Restore(bytes.NewReader(backup.Bytes()), history.RestoreOptions{Thread: "other-thread"})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(threads).To(Equal([]string{"other-thread"}))
+
+				restoredThreads, err := restored.ListThreads()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(restoredThreads).To(HaveLen(1))
+				Expect(restoredThreads[0].Name).To(Equal("other-thread"))
+
+				_, err = restored.Restore(bytes.NewReader(backup.Bytes()), history.RestoreOptions{Thread: "missing-thread"})
+				Expect(err).To(MatchError("thread missing-thread is not in the backup"))
+			})
+
+			it("refuses to replace existing threads without force", func() {
+				Expect(fileIO.Write(messages[:1])).To(Succeed())
+
+				_, err := fileIO.Restore(bytes.NewReader(backup.Bytes()), history.RestoreOptions{})
+				Expect(err).To(MatchError("thread " + threadName + ", other-thread already exists, add --force to replace it"))
+
+				readMessages, err := fileIO.Read()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(readMessages).To(Equal(messages[:1]))
+
+				_, err = fileIO.Restore(bytes.NewReader(backup.Bytes()), history.RestoreOptions{Thread: threadName, Force: true})
+				Expect(err).NotTo(HaveOccurred())
+
+				readMessages, err = fileIO.Read()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(readMessages).To(Equal(messages))
+			})
+
+			it("refuses corrupt backups and writes nothing", func() {
+				manifest := `{"version":1,"threads":["` + threadName + `"]}`
+				thread := `{"version":1,"messages":[]}`
+
+				for name, data := range map[string][]byte{
+					"garbage":              []byte("not a backup"),
+					"a truncated backup":   backup.Bytes()[:backup.Len()/2],
+					"a path outside":       archive(map[string]string{"manifest.json": manifest, "threads/../../escaped.json": thread}),
+					"an unexpected entry":  archive(map[string]string{"manifest.json": manifest, "config.yaml": "model: gpt-4o"}),
+					"no manifest":          archive(map[string]string{"threads/" + threadName + ".json": thread}),
+					"a missing thread":     archive(map[string]string{"manifest.json": manifest}),
+					"a damaged thread":     archive(map[string]string{"manifest.json": manifest, "threads/" + threadName + ".json": `{"version":1,"messages":[`}),
+					"a malformed manifest": archive(map[string]string{"manifest.json": "{", "threads/" + threadName + ".json": thread}),
+				} {
+					_, err := restored.Restore(bytes.NewReader(data), history.RestoreOptions{})
+					Expect(err).To(MatchError(history.ErrCorruptBackup), name)
+				}
+
+				_, err := restored.Restore(bytes.NewReader(archive(map[string]string{
+					"manifest.json": `{"version":2,"threads":[]}`,
+				})), history.RestoreOptions{})
+				Expect(err).To(MatchError(ContainSubstring("upgrade chatgpt-cli to restore it")))
+
+				threads, err := restored.ListThreads()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(threads).To(BeEmpty())
+			})
+
+			it("backs up encrypted threads as they are", func() {
+				encrypted, _ := history.New()
+				encrypted = encrypted.WithDirectory(tmpDir).WithPassphrase([]byte("correct horse"))
+				_, err := encrypted.EncryptHistory()
+				Expect(err).NotTo(HaveOccurred())
+
+				backup.Reset()
+				_, err = fileIO.Backup(&backup)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(backup.String()).NotTo(ContainSubstring("Test message"))
+
+				restored = restored.WithPassphrase([]byte("correct horse"))
+				_, err = restored.Restore(bytes.NewReader(backup.Bytes()), history.RestoreOptions{Thread: threadName})
+				Expect(err).NotTo(HaveOccurred())
+
+				readMessages, err := restored.ReadThread(threadName)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(readMessages).To(Equal(messages))
+
+				wrong, _ := history.New()
+				wrong = wrong.WithDirectory(t.TempDir()).WithPassphrase([]byte("wrong horse"))
+				_, err = wrong.Restore(bytes.NewReader(backup.Bytes()), history.RestoreOptions{})
+				Expect(err).To(MatchError(history.ErrWrongKey))
+			})
+		})
+	})
+
+	when("Read and Write SQLite History", func() {
+		const threadName = "default-thread"
+
+		var (
+			tmpDir string
+			store  *history.SQLStore
+		)
+
+		it.Before(func() {
+			var err error
+			tmpDir = t.TempDir()
+
+			store, err = history.OpenSQLiteFile(filepath.Join(tmpDir, "history.db"), 5*time.Second)
+			Expect(err).NotTo(HaveOccurred())
+			store.SetThread(threadName)
+		})
+
+		it.After(func() {
+			Expect(store.Close()).To(Succeed())
+		})
+
+		it("deletes messages in a single transaction", func() {
+			Expect(store.Write([]types.Message{
+				{Role: "system", Content: "You are a helpful assistant."},
+				{Role: "user", Content: "question"},
+				{Role: "assistant", Content: "answer"},
+			})).To(Succeed())
+
+			result, err := store.DeleteMessages(threadName, []int{2}, true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Deleted).To(Equal([]int{1, 2}))
+
+			readMessages, err := store.Read()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(readMessages).To(HaveLen(1))
+
+			_, err = store.DeleteMessages(threadName, []int{0}, false)
+			Expect(err).To(HaveOccurred())
+		})
+
+		it("reads a page of a thread", func() {
+			var messages []types.Message
+			for i := 0; i < 10; i++ {
+				messages = append(messages, types.Message{Role: "user", Content: fmt.Sprintf("question %d", i)})
+			}
+			Expect(store.Write(messages)).To(Succeed())
+
+			page, total, err := store.ReadPage(threadName, 4, 3)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(total).To(Equal(10))
+			Expect(page).To(HaveLen(3))
+			Expect(page[0].Content).To(Equal("question 4"))
+			Expect(page[2].Content).To(Equal("question 6"))
+
+			page, total, err = store.ReadPage(threadName, 8, 5)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(total).To(Equal(10))
+			Expect(page).To(HaveLen(2))
+
+			page, total, err = store.ReadPage(threadName, 12, 5)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(total).To(Equal(10))
+			Expect(page).To(BeEmpty())
+		})
+
+		it("prunes the messages older than the cutoff", func() {
+			old := time.Now().Add(-48 * time.Hour)
+			Expect(store.Write([]types.Message{
+				{Role: "system", Content: "You are a helpful assistant.", Timestamp: old},
+				{Role: "user", Content: "old question", Timestamp: old},
+				{Role: "assistant", Content: "old answer", Timestamp: old},
+				{Role: "user", Content: "recent question", Timestamp: time.Now()},
+			})).To(Succeed())
+
+			result, err := store.Prune(24*time.Hour, true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal([]history.PruneResult{{Thread: threadName, Removed: 2, Kept: 2}}))
+
+			result, err = store.Prune(24*time.Hour, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(HaveLen(1))
+
+			readMessages, err := store.Read()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(readMessages).To(HaveLen(2))
+			Expect(readMessages[1].Content).To(Equal("recent question"))
+		})
+
+		it("round-trips the messages with their bookkeeping", func() {
+			timestamp := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+			messages := []types.Message{
+				{Role: "system", Content: "You are a helpful assistant."},
+				{Role: "user", Content: "question", Timestamp: timestamp},
+				{Role: "assistant", Content: "answer", Alternatives: []string{"first try"}, Timestamp: timestamp},
+			}
+			Expect(store.Write(messages)).To(Succeed())
+
+			readMessages, err := store.Read()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(readMessages).To(HaveLen(3))
+			Expect(readMessages[0].Timestamp).To(BeZero())
+			Expect(readMessages[2].Alternatives).To(Equal([]string{"first try"}))
+			Expect(readMessages[2].Timestamp).To(BeTemporally("==", timestamp))
+			Expect(readMessages[2].TokenEncoding).To(Equal(utils.TokenEncoding))
+
+			readMessages, err = store.ReadThread("does-not-exist")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(readMessages).To(BeEmpty())
+
+			store.SetThread("../escape")
+			Expect(store.Write(messages)).NotTo(Succeed())
+		})
+
+		it("keeps the database and the directory it creates for the user only", func() {
+			if runtime.GOOS == "windows" {
+				t.Skip("Windows has no permission bits")
+			}
+
+			dir := filepath.Join(tmpDir, "synced")
+			other, err := history.OpenSQLiteFile(filepath.Join(dir, "history.db"), 5*time.Second)
+			Expect(err).NotTo(HaveOccurred())
+			defer other.Close()
+
+			info, err := os.Stat(dir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0700)))
+
+			info, err = os.Stat(filepath.Join(dir, "history.db"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+		})
+
+		it("keeps the updates of two stores on one database", func() {
+			other, err := history.OpenSQLiteFile(filepath.Join(tmpDir, "history.db"), 5*time.Second)
+			Expect(err).NotTo(HaveOccurred())
+			defer other.Close()
+			other.SetThread(threadName)
+
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				for _, s := range []*history.SQLStore{store, other} {
+					wg.Add(1)
+					go func(s *history.SQLStore, i int) {
+						defer wg.Done()
+						Expect(s.Update(func(messages []types.Message) []types.Message {
+							return append(messages, types.Message{Role: "user", Content: strconv.Itoa(i)})
+						})).To(Succeed())
+					}(s, i)
+				}
+			}
+			wg.Wait()
+
+			readMessages, err := store.Read()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(readMessages).To(HaveLen(20))
+		})
+
+		it("lists, renames, clears and deletes threads", func() {
+			Expect(store.Write([]types.Message{{Role: "user", Content: "one"}, {Role: "assistant", Content: "two"}})).To(Succeed())
+			store.SetThread("other")
+			Expect(store.Write([]types.Message{{Role: "user", Content: "three"}})).To(Succeed())
+
+			threads, err := store.ListThreads()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(threads).To(HaveLen(2))
+			Expect(threads[0].Name).To(Equal(threadName))
+			Expect(threads[0].Messages).To(Equal(2))
+			Expect(threads[0].Created).NotTo(BeZero())
+
+			Expect(store.RenameThread("other", "renamed")).To(Succeed())
+			Expect(store.GetThread()).To(Equal("renamed"))
+			Expect(store.RenameThread("does-not-exist", "x")).To(MatchError("thread does-not-exist does not exist"))
+			Expect(store.RenameThread("renamed", threadName)).To(MatchError("thread " + threadName + " already exists"))
+
+			readMessages, err := store.ReadThread("renamed")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(readMessages).To(HaveLen(1))
+
+			result, err := store.MergeThreads(threadName, "renamed", history.MergeAppend)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Added).To(Equal(1))
+			Expect(store.GetThread()).To(Equal(threadName))
+
+			readMessages, err = store.Read()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(readMessages).To(HaveLen(3))
+
+			_, err = store.MergeThreads(threadName, "renamed", history.MergeAppend)
+			Expect(err).To(MatchError("thread renamed does not exist"))
+			Expect(store.RenameThread(threadName, "renamed")).To(Succeed())
+
+			Expect(store.Clear(threadName)).To(Succeed())
+			Expect(store.DeleteThread("renamed")).To(Succeed())
+			Expect(store.GetThread()).To(Equal("default"))
+
+			threads, err = store.ListThreads()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(threads).To(BeEmpty())
+		})
+
+		it("keeps the model that gave each answer", func() {
+			Expect(store.Write([]types.Message{{Role: "user", Content: "question"}, {Role: "assistant", Content: "answer", Model: "gpt-4o"}})).To(Succeed())
+
+			readMessages, err := store.Read()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(readMessages[1].Model).To(Equal("gpt-4o"))
+		})
+
+		it("pins messages", func() {
+			Expect(store.Write([]types.Message{{Role: "system", Content: "hi"}, {Role: "user", Content: "we are on Go 1.21"}})).To(Succeed())
+			Expect(store.SetPinned(threadName, []int{0}, true)).To(MatchError("message 0 is a system prompt, which is always kept"))
+			Expect(store.SetPinned(threadName, []int{1}, true)).To(Succeed())
+
+			readMessages, err := store.Read()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(readMessages[1].Pinned).To(BeTrue())
+
+			Expect(store.SetPinned(threadName, []int{1}, false)).To(Succeed())
+
+			readMessages, err = store.Read()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(readMessages[1].Pinned).To(BeFalse())
+		})
+
+		it("keeps the title of a thread", func() {
+			Expect(store.SetTitle(threadName, "A title")).To(MatchError("thread " + threadName + " does not exist"))
+
+			Expect(store.Write([]types.Message{{Role: "user", Content: "one"}})).To(Succeed())
+			Expect(store.SetTitle(threadName, "A title")).To(Succeed())
+			Expect(store.RenameThread(threadName, "renamed")).To(Succeed())
+
+			threads, err := store.ListThreads()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(threads[0].Title).To(Equal("A title"))
+		})
+
+		it("keeps the tags of a thread and searches by tag", func() {
+			Expect(store.SetTags(threadName, []string{"work"})).To(MatchError("thread " + threadName + " does not exist"))
+
+			Expect(store.Write([]types.Message{{Role: "user", Content: "pgbouncer timeouts"}})).To(Succeed())
+			store.SetThread("other")
+			Expect(store.Write([]types.Message{{Role: "user", Content: "pgbouncer at home"}})).To(Succeed())
+
+			Expect(store.SetTags(threadName, []string{"Work", "postgres"})).To(Succeed())
+			Expect(store.RenameThread(threadName, "renamed")).To(Succeed())
+
+			threads, err := store.ListThreads()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(threads[1].Name).To(Equal("renamed"))
+			Expect(threads[1].Tags).To(Equal([]string{"postgres", "work"}))
+			Expect(threads[0].Tags).To(BeEmpty())
+
+			var found []string
+			Expect(store.Search(history.SearchOptions{Term: "pgbouncer", Tag: "work"}, func(match history.SearchMatch) error {
+				found = append(found, match.Thread)
+				return nil
+			})).To(Succeed())
+			Expect(found).To(Equal([]string{"renamed"}))
+		})
+
+		it("archives a thread and leaves it out of searches", func() {
+			Expect(store.SetArchived(threadName, true)).To(MatchError("thread " + threadName + " does not exist"))
+
+			Expect(store.Write([]types.Message{{Role: "user", Content: "pgbouncer timeouts"}})).To(Succeed())
+			store.SetThread("other")
+			Expect(store.Write([]types.Message{{Role: "user", Content: "pgbouncer at home"}})).To(Succeed())
+
+			Expect(store.SetArchived(threadName, true)).To(Succeed())
+
+			threads, err := store.ListThreads()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(threads[0].Name).To(Equal(threadName))
+			Expect(threads[0].Archived).To(BeTrue())
+			Expect(threads[1].Archived).To(BeFalse())
+
+			var found []string
+			search := func(opts history.SearchOptions) {
+				found = nil
+				Expect(store.Search(opts, func(match history.SearchMatch) error {
+					found = append(found, match.Thread)
+					return nil
+				})).To(Succeed())
+			}
+
+			search(history.SearchOptions{Term: "pgbouncer"})
+			Expect(found).To(Equal([]string{"other"}))
+			search(history.SearchOptions{Term: "pgbouncer", ArchivedThreads: true})
+			Expect(found).To(Equal([]string{threadName, "other"}))
+
+			Expect(store.SetArchived(threadName, false)).To(Succeed())
+			threads, err = store.ListThreads()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(threads[0].Archived).To(BeFalse())
+		})
+
+		it("keeps the summary of a thread", func() {
+			summary := types.Summary{Topics: []string{"pgbouncer timeouts"}}
+			Expect(store.SetSummary(threadName, summary)).To(MatchError("thread " + threadName + " does not exist"))
+
+			Expect(store.Write([]types.Message{{Role: "user", Content: "one"}})).To(Succeed())
+			Expect(store.SetSummary(threadName, summary)).To(Succeed())
+
+			threads, err := store.ListThreads()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(threads[0].Summary).To(Equal(&summary))
+		})
+
+		it("searches the messages of all threads", func() {
+			timestamp := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+			Expect(store.Write([]types.Message{
+				{Role: "user", Content: "How do I fix pgbouncer timeouts?", Timestamp: timestamp},
+				{Role: "assistant", Content: "Raise the PgBouncer query_timeout."},
+			})).To(Succeed())
+
+			var matches []history.SearchMatch
+			collect := func(match history.SearchMatch) error {
+				matches = append(matches, match)
+				return nil
+			}
+
+			Expect(store.Search(history.SearchOptions{Term: "pgbouncer"}, collect)).To(Succeed())
+			Expect(matches).To(HaveLen(2))
+			Expect(matches[0].Timestamp).To(BeTemporally("==", timestamp))
+			Expect(matches[1].Index).To(Equal(1))
+
+			matches = nil
+			Expect(store.Search(history.SearchOptions{Term: "pgbouncer", Role: "assistant"}, collect)).To(Succeed())
+			Expect(matches).To(HaveLen(1))
+			Expect(matches[0].Role).To(Equal("assistant"))
+
+			matches = nil
+			dates := history.DateRange{Since: timestamp.Add(-time.Hour), Until: timestamp.Add(time.Hour)}
+			Expect(store.Search(history.SearchOptions{Term: "pgbouncer", Range: dates}, collect)).To(Succeed())
+			Expect(matches).To(HaveLen(1))
+			Expect(matches[0].Index).To(Equal(0))
+
+			matches = nil
+			dates.Undated = true
+			Expect(store.Search(history.SearchOptions{Term: "pgbouncer", Range: dates}, collect)).To(Succeed())
+			Expect(matches).To(HaveLen(2))
+		})
+
+		it("adds up the messages without loading their content", func() {
+			timestamp := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+			Expect(store.Write([]types.Message{
+				{Role: "user", Content: "How do I fix pgbouncer timeouts?", Timestamp: timestamp},
+				{Role: "assistant", Content: "Raise the PgBouncer query_timeout.", Timestamp: timestamp.Add(time.Minute)},
+			})).To(Succeed())
+			store.SetThread("other")
+			Expect(store.Write([]types.Message{{Role: "user", Content: "hi"}})).To(Succeed())
+
+			stats, err := store.Stats("", history.DateRange{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stats.Threads).To(Equal(2))
+			Expect(stats.Messages).To(Equal(map[string]int{"user": 2, "assistant": 1}))
+			Expect(stats.CompletionTokens).To(Equal(utils.EstimateTokens("Raise the PgBouncer query_timeout.")))
+			Expect(stats.First).To(BeTemporally("==", timestamp))
+			Expect(stats.Longest).To(Equal(&history.MessageRef{Thread: threadName, Index: 1, Role: "assistant", Characters: 34}))
+
+			stats, err = store.Stats(threadName, history.DateRange{Since: timestamp.Add(time.Second)})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stats.TotalMessages()).To(Equal(1))
+		})
+
+		it("imports the JSON history once", func() {
+			filesDir := filepath.Join(tmpDir, "files")
+			Expect(os.MkdirAll(filesDir, 0755)).To(Succeed())
+
+			files, _ := history.New()
+			files = files.WithDirectory(filesDir)
+			files.SetThread("legacy")
+			Expect(files.Write([]types.Message{{Role: "user", Content: "from a file"}})).To(Succeed())
+
+			imported, err := store.MigrateFiles(files)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(imported).To(Equal(1))
+
+			readMessages, err := store.ReadThread("legacy")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(readMessages).To(HaveLen(1))
+			Expect(readMessages[0].Content).To(Equal("from a file"))
+
+			Expect(store.DeleteThread("legacy")).To(Succeed())
+			imported, err = store.MigrateFiles(files)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(imported).To(BeZero())
+		})
+	})
+
+	when("Read, Write, List, Delete Config", func() {
+		var (
+			tmpDir     string
+			tmpFile    *os.File
+			historyDir string
+			configIO   *config.FileIO
+			testConfig types.Config
+			err        error
+		)
+
+		it.Before(func() {
+			tmpDir, err = os.MkdirTemp("", "chatgpt-cli-test")
+			Expect(err).NotTo(HaveOccurred())
+
+			historyDir, err = os.MkdirTemp(tmpDir, "history")
+			Expect(err).NotTo(HaveOccurred())
+
+			tmpFile, err = os.CreateTemp(tmpDir, "config.yaml")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(tmpFile.Close()).To(Succeed())
+
+			configIO = config.New().WithConfigPath(tmpFile.Name()).WithHistoryPath(historyDir)
+
+			testConfig = types.Config{
+				Model: "test-model",
+			}
+		})
+
+		it.After(func() {
+			Expect(os.RemoveAll(tmpDir)).To(Succeed())
+		})
+
+		when("performing a migration", func() {
+			defaults := config.New().ReadDefaults()
+
+			it("it doesn't apply a migration when max_tokens is 0", func() {
+				testConfig.MaxTokens = 0
+
+				err = configIO.Write(testConfig) // need to write before reading
+				Expect(err).NotTo(HaveOccurred())
+
+				readConfig, err := configIO.Read()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(readConfig).To(Equal(testConfig))
+			})
+			it("it migrates small values of max_tokens as expected", func() {
+				testConfig.MaxTokens = defaults.ContextWindow - 1
+
+				err = configIO.Write(testConfig) // need to write before reading
+				Expect(err).NotTo(HaveOccurred())
+
+				readConfig, err := configIO.Read()
+				Expect(err).NotTo(HaveOccurred())
+
+				expectedConfig := testConfig
+				expectedConfig.MaxTokens = defaults.MaxTokens
+				expectedConfig.ContextWindow = defaults.ContextWindow
+
+				Expect(readConfig).To(Equal(expectedConfig))
+			})
+			it("it migrates large values of max_tokens as expected", func() {
+				testConfig.MaxTokens = defaults.ContextWindow + 1
+
+				err = configIO.Write(testConfig) // need to write before reading
+				Expect(err).NotTo(HaveOccurred())
+
+				readConfig, err := configIO.Read()
+				Expect(err).NotTo(HaveOccurred())
+
+				expectedConfig := testConfig
+				expectedConfig.MaxTokens = defaults.MaxTokens
+				expectedConfig.ContextWindow = testConfig.MaxTokens
+
+				Expect(readConfig).To(Equal(expectedConfig))
+			})
+		})
+
+		it("lists all the threads", func() {
+			files := []string{"thread1.json", "thread2.json", "thread3.json"}
+
+			for _, file := range files {
+				file, err := os.Create(filepath.Join(historyDir, file))
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(file.Close()).To(Succeed())
+			}
+
+			result, err := configIO.List()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(HaveLen(3))
+			Expect(result[2]).To(Equal("thread3.json"))
+		})
+
+		it("deletes the thread", func() {
+			files := []string{"thread1.json", "thread2.json", "thread3.json"}
+
+			for _, file := range files {
+				file, err := os.Create(filepath.Join(historyDir, file))
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(file.Close()).To(Succeed())
+			}
+
+			err = configIO.Delete("thread2")
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = os.Stat(filepath.Join(historyDir, "thread2.json"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+
+			_, err = os.Stat(filepath.Join(historyDir, "thread3.json"))
+			Expect(os.IsNotExist(err)).To(BeFalse())
+		})
+	})
+
+	when("Performing the Lifecycle", func() {
+		const (
+			exitSuccess    = 0
+			exitFailure    = 1
+			exitUsage      = 2
+			exitAuth       = 3
+			exitNetwork    = 6
+			exitOverBudget = 8
+		)
+
+		var (
+			homeDir      string
+			filePath     string
+			configFile   string
+			err          error
+			apiKeyEnvVar string
+		)
+
+		runCommand := func(args ...string) string {
+			command := exec.Command(binaryPath, args...)
+			session, err := gexec.Start(command, io.Discard, io.Discard)
+
+			ExpectWithOffset(1, err).NotTo(HaveOccurred())
+			<-session.Exited
+
+			if tmp := string(session.Err.Contents()); tmp != "" {
+				fmt.Printf("error output: %s", string(session.Err.Contents()))
+			}
+
+			ExpectWithOffset(1, session).Should(gexec.Exit(0))
+			return string(session.Out.Contents())
+		}
+
+		checkConfigFileContent := func(expectedContent string) {
+			content, err := os.ReadFile(configFile)
+			ExpectWithOffset(1, err).NotTo(HaveOccurred())
+			ExpectWithOffset(1, string(content)).To(ContainSubstring(expectedContent))
+		}
+
+		it.Before(func() {
+			once.Do(func() {
+				SetDefaultEventuallyTimeout(10 * time.Second)
+
+				log.Println("Building binary...")
+				Expect(buildBinary()).To(Succeed())
+				log.Println("Binary built successfully!")
+
+				log.Println("Starting mock server...")
+				Expect(runMockServer()).To(Succeed())
+				log.Println("Mock server started!")
+
+				Eventually(func() (string, error) {
+					return curl(fmt.Sprintf("%s/ping", serviceURL))
+				}).Should(ContainSubstring("pong"))
+			})
+
+			homeDir, err = os.MkdirTemp("", "mockHome")
+			Expect(err).NotTo(HaveOccurred())
+
+			apiKeyEnvVar = configmanager.New(config.New()).WithEnvironment().APIKeyEnvVarName()
+
+			Expect(os.Setenv("HOME", homeDir)).To(Succeed())
+			Expect(os.Unsetenv(utils.XDGConfigHomeEnv)).To(Succeed())
+			Expect(os.Unsetenv(utils.XDGStateHomeEnv)).To(Succeed())
+			Expect(os.Setenv(apiKeyEnvVar, expectedToken)).To(Succeed())
+		})
+
+		it.After(func() {
+			gexec.Kill()
+			Expect(os.RemoveAll(homeDir))
+		})
+
+		it("throws an error when the API key is missing", func() {
+			Expect(os.Unsetenv(apiKeyEnvVar)).To(Succeed())
+
+			command := exec.Command(binaryPath, "some prompt")
+			session, err := gexec.Start(command, io.Discard, io.Discard)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(session).Should(gexec.Exit(exitAuth))
+
+			output := string(session.Err.Contents())
+			Expect(output).To(ContainSubstring("API key is required."))
+		})
+
+		it("should not require an API key for the --version flag", func() {
+			Expect(os.Unsetenv(apiKeyEnvVar)).To(Succeed())
+
+			command := exec.Command(binaryPath, "--version")
+			session, err := gexec.Start(command, io.Discard, io.Discard)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(session).Should(gexec.Exit(exitSuccess))
+		})
+
+		it("should require a hidden folder for the --list-threads flag", func() {
+			command := exec.Command(binaryPath, "--list-threads")
+			session, err := gexec.Start(command, io.Discard, io.Discard)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(session).Should(gexec.Exit(exitFailure))
+
+			output := string(session.Err.Contents())
+			Expect(output).To(ContainSubstring("chatgpt-cli/history: no such file or directory"))
+		})
+
+		it("should return an error when --new-thread is used with --set-thread", func() {
+			command := exec.Command(binaryPath, "--new-thread", "--set-thread", "some-thread")
+			session, err := gexec.Start(command, io.Discard, io.Discard)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(session).Should(gexec.Exit(exitUsage))
+
+			output := string(session.Err.Contents())
+			Expect(output).To(ContainSubstring("the --new-thread flag cannot be used with the --set-thread flag, name the new thread with --thread"))
+		})
+
+		it("should require an argument for the --set-model flag", func() {
+			command := exec.Command(binaryPath, "--set-model")
+			session, err := gexec.Start(command, io.Discard, io.Discard)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(session).Should(gexec.Exit(exitUsage))
+
+			output := string(session.Err.Contents())
+			Expect(output).To(ContainSubstring("flag needs an argument: --set-model"))
+		})
+
+		it("should require an argument for the --set-thread flag", func() {
+			command := exec.Command(binaryPath, "--set-thread")
+			session, err := gexec.Start(command, io.Discard, io.Discard)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(session).Should(gexec.Exit(exitUsage))
+
+			output := string(session.Err.Contents())
+			Expect(output).To(ContainSubstring("flag needs an argument: --set-thread"))
+		})
+
+		it("should require an argument for the --set-max-tokens flag", func() {
+			command := exec.Command(binaryPath, "--set-max-tokens")
+			session, err := gexec.Start(command, io.Discard, io.Discard)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(session).Should(gexec.Exit(exitUsage))
+
+			output := string(session.Err.Contents())
+			Expect(output).To(ContainSubstring("flag needs an argument: --set-max-tokens"))
+		})
+
+		it("should require an argument for the --set-context-window flag", func() {
+			command := exec.Command(binaryPath, "--set-context-window")
+			session, err := gexec.Start(command, io.Discard, io.Discard)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(session).Should(gexec.Exit(exitUsage))
+
+			output := string(session.Err.Contents())
+			Expect(output).To(ContainSubstring("flag needs an argument: --set-context-window"))
+		})
+
+		it("should warn when config.yaml does not exist and OPENAI_CONFIG_HOME is set", func() {
+			configHomeDir := filepath.Join(t.TempDir(), "does-not-exist")
+			Expect(os.Setenv(utils.ConfigHomeEnv, configHomeDir)).To(Succeed())
+
+			configFilePath := path.Join(configHomeDir, "config.yaml")
+			Expect(configFilePath).NotTo(BeAnExistingFile())
+
+			command := exec.Command(binaryPath, "llm query")
+			session, err := gexec.Start(command, io.Discard, io.Discard)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(session).Should(gexec.Exit(exitSuccess))
+
+			// the output is piped, so the warning goes to stderr, leaving stdout to the answer
+			output := string(session.Err.Contents())
+			Expect(output).To(ContainSubstring(fmt.Sprintf("Warning: config.yaml doesn't exist in %s, create it", configHomeDir)))
+
+			// Unset the variable to prevent pollution
+			Expect(os.Unsetenv(utils.ConfigHomeEnv)).To(Succeed())
+		})
+
+		it("should NOT warn when config.yaml does not exist and OPENAI_CONFIG_HOME is NOT set", func() {
+			configHomeDir := filepath.Join(t.TempDir(), "does-not-exist")
+			Expect(os.Unsetenv(utils.ConfigHomeEnv)).To(Succeed())
+
+			configFilePath := path.Join(configHomeDir, "config.yaml")
+			Expect(configFilePath).NotTo(BeAnExistingFile())
+
+			command := exec.Command(binaryPath, "llm query")
+			session, err := gexec.Start(command, io.Discard, io.Discard)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(session).Should(gexec.Exit(exitSuccess))
+
+			output := string(session.Out.Contents()) + string(session.Err.Contents())
+			Expect(output).NotTo(ContainSubstring(fmt.Sprintf("Warning: config.yaml doesn't exist in %s, create it", configHomeDir)))
+		})
+
+		it("should require the chatgpt-cli folder but not an API key for the --set-model flag", func() {
+			Expect(os.Unsetenv(apiKeyEnvVar)).To(Succeed())
+
+			command := exec.Command(binaryPath, "--set-model", "123")
+			session, err := gexec.Start(command, io.Discard, io.Discard)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(session).Should(gexec.Exit(exitFailure))
+
+			output := string(session.Err.Contents())
+			Expect(output).To(ContainSubstring("config directory does not exist:"))
+			Expect(output).NotTo(ContainSubstring(apiKeyEnvVar))
+		})
+
+		it("should require the chatgpt-cli folder but not an API key for the --set-thread flag", func() {
+			Expect(os.Unsetenv(apiKeyEnvVar)).To(Succeed())
+
+			command := exec.Command(binaryPath, "--set-thread", "thread-name")
+			session, err := gexec.Start(command, io.Discard, io.Discard)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(session).Should(gexec.Exit(exitFailure))
+
+			output := string(session.Err.Contents())
+			Expect(output).To(ContainSubstring("config directory does not exist:"))
+			Expect(output).NotTo(ContainSubstring(apiKeyEnvVar))
+		})
+
+		it("should require the chatgpt-cli folder but not an API key for the --set-max-tokens flag", func() {
+			Expect(os.Unsetenv(apiKeyEnvVar)).To(Succeed())
+
+			command := exec.Command(binaryPath, "--set-max-tokens", "789")
+			session, err := gexec.Start(command, io.Discard, io.Discard)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(session).Should(gexec.Exit(exitFailure))
+
+			output := string(session.Err.Contents())
+			Expect(output).To(ContainSubstring("config directory does not exist:"))
+			Expect(output).NotTo(ContainSubstring(apiKeyEnvVar))
+		})
+
+		it("should require the chatgpt-cli folder but not an API key for the --set-context-window flag", func() {
+			Expect(os.Unsetenv(apiKeyEnvVar)).To(Succeed())
+
+			command := exec.Command(binaryPath, "--set-context-window", "789")
+			session, err := gexec.Start(command, io.Discard, io.Discard)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(session).Should(gexec.Exit(exitFailure))
+
+			output := string(session.Err.Contents())
+			Expect(output).To(ContainSubstring("config directory does not exist:"))
+			Expect(output).NotTo(ContainSubstring(apiKeyEnvVar))
+		})
+
+		it("should return the expected result for the --version flag", func() {
+			output := runCommand("--version")
+
+			Expect(output).To(ContainSubstring(fmt.Sprintf("commit %s", gitCommit)))
+			Expect(output).To(ContainSubstring(fmt.Sprintf("version %s", gitVersion)))
+			Expect(output).To(ContainSubstring("Go version: go"))
+			Expect(output).To(ContainSubstring(fmt.Sprintf("Platform:   %s/%s", runtime.GOOS, runtime.GOARCH)))
+		})
+
+		it("prints the build metadata with the version command, as JSON with --json", func() {
+			Expect(runCommand("version")).To(Equal(runCommand("--version")))
+
+			var info buildinfo.Info
+			Expect(json.Unmarshal([]byte(runCommand("version", "--json")), &info)).To(Succeed())
+			Expect(info.Version).To(Equal(gitVersion))
+			Expect(info.Commit).To(Equal(gitCommit))
+			Expect(info.GoVersion).To(HavePrefix("go"))
+			Expect(info.OS).To(Equal(runtime.GOOS))
+			Expect(info.Arch).To(Equal(runtime.GOARCH))
+		})
+
+		it("should return the expected result for the --list-models flag", func() {
+			output := runCommand("--list-models")
+
+			Expect(output).To(ContainSubstring("* gpt-3.5-turbo (current)"))
+			Expect(output).To(ContainSubstring("- gpt-3.5-turbo-0301"))
+		})
+
+		it("should return the expected result for the --query flag", func() {
+			Expect(os.Setenv("OPENAI_TRACK_TOKEN_USAGE", "false")).To(Succeed())
+
+			output := runCommand("--query", "some-query")
+
+			expectedResponse := `I don't have personal opinions about bars, but here are some popular bars in Red Hook, Brooklyn:`
+			Expect(output).To(ContainSubstring(expectedResponse))
+			Expect(output).NotTo(ContainSubstring("Token Usage:"))
+		})
+
+		it("should display token usage after a query when configured to do so", func() {
+			Expect(os.Setenv("OPENAI_TRACK_TOKEN_USAGE", "true")).To(Succeed())
+
+			command := exec.Command(binaryPath, "--query", "tell me a 5 line joke")
+			session, err := gexec.Start(command, io.Discard, io.Discard)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(session).Should(gexec.Exit(exitSuccess))
+
+			// the output is piped, so the usage goes to stderr, leaving stdout to the answer
+			Expect(string(session.Out.Contents())).NotTo(ContainSubstring("Token Usage:"))
+			Expect(string(session.Err.Contents())).To(ContainSubstring("Token Usage:"))
+			Expect(string(session.Err.Contents())).To(ContainSubstring("prompt 16 / completion 92 tokens"))
+		})
+
+		it("prints exactly the content of the answer, ended with a newline, when the output is piped", func() {
+			Expect(os.Setenv("OPENAI_TRACK_TOKEN_USAGE", "true")).To(Succeed())
+			Expect(os.Setenv(utils.ConfigHomeEnv, filepath.Join(t.TempDir(), "does-not-exist"))).To(Succeed())
+			defer os.Unsetenv(utils.ConfigHomeEnv)
+
+			data, err := utils.FileToBytes("completions.json")
+			Expect(err).NotTo(HaveOccurred())
+
+			var response types.CompletionsResponse
+			Expect(json.Unmarshal(data, &response)).To(Succeed())
+			expected := response.Choices[0].Message.Content + "\n"
+
+			for _, args := range [][]string{{"--query", "some-query"}, {"--query", "--raw", "some-query"}} {
+				command := exec.Command(binaryPath, args...)
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitSuccess))
+				Expect(session.Out.Contents()).To(Equal([]byte(expected)))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("Token Usage:"))
+			}
+		})
+
+		it("treats the history of a fresh install as empty", func() {
+			Expect(os.Unsetenv(apiKeyEnvVar)).To(Succeed())
+			Expect(os.Setenv(utils.ConfigHomeEnv, filepath.Join(t.TempDir(), "does-not-exist"))).To(Succeed())
+			defer os.Unsetenv(utils.ConfigHomeEnv)
+
+			output := runCommand("history", "list", "--json")
+			Expect(strings.TrimSpace(output)).To(Equal("[]"))
+
+			output = runCommand("--rewind", "1")
+			Expect(output).To(ContainSubstring("Nothing to rewind."))
+
+			for args, message := range map[string]string{
+				"--show-last 1": "thread default has no answered questions",
+				"--fork branch": "thread default is empty, there is nothing to fork",
+			} {
+				command := exec.Command(binaryPath, strings.Fields(args)...)
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitFailure))
+				Expect(string(session.Err.Contents())).To(ContainSubstring(message))
+				Expect(string(session.Err.Contents())).NotTo(ContainSubstring("no such file or directory"))
+			}
+		})
+
+		it("wraps only the rendered answers with --width, and refuses a negative one", func() {
+			data, err := utils.FileToBytes("completions.json")
+			Expect(err).NotTo(HaveOccurred())
+
+			var response types.CompletionsResponse
+			Expect(json.Unmarshal(data, &response)).To(Succeed())
+
+			// the output is piped, so the answer is printed as it is
+			output := runCommand("--query", "--width", "10", "some-query")
+			Expect(output).To(ContainSubstring(response.Choices[0].Message.Content))
+
+			command := exec.Command(binaryPath, "--query", "--width", "-5", "some-query")
+			session, err := gexec.Start(command, io.Discard, io.Discard)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(session).Should(gexec.Exit(exitUsage))
+			Expect(string(session.Err.Contents())).To(ContainSubstring("invalid --width -5, use a positive number of columns"))
+		})
+
+		it("asks for a single shell command with x, and only offers to run it", func() {
+			output := runCommand("x", "--dry-run", "list the files")
+			Expect(output).To(ContainSubstring("into a single command of the"))
+			Expect(output).To(ContainSubstring(`"content": "list the files"`))
+			Expect(output).To(ContainSubstring(`"type": "json_object"`))
+
+			for args, message := range map[string]string{
+				"--no-exec list the files":       "--no-exec only prints the command of chatgpt x <request>",
+				"x --interactive list the files": "x suggests the command of a single request, it can't be used in interactive mode",
+			} {
+				command := exec.Command(binaryPath, strings.Fields(args)...)
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+				Eventually(session).Should(gexec.Exit(exitUsage))
+				Expect(string(session.Err.Contents())).To(ContainSubstring(message))
+			}
+		})
+
+		it("writes the commit message of the staged diff with commit", func() {
+			dir := t.TempDir()
+			git := func(args ...string) {
+				command := exec.Command("git", args...)
+				command.Dir = dir
+				output, err := command.CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), string(output))
+			}
+			git("init", "-q")
+
+			command := exec.Command(binaryPath, "commit")
+			command.Dir = dir
+			session, err := gexec.Start(command, io.Discard, io.Discard)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(session).Should(gexec.Exit(exitFailure))
+			Expect(string(session.Err.Contents())).To(ContainSubstring("nothing is staged, stage the changes to describe with git add first"))
+
+			Expect(os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0644)).To(Succeed())
+			git("add", "main.go")
+
+			command = exec.Command(binaryPath, "commit", "--dry-run")
+			command.Dir = dir
+			session, err = gexec.Start(command, io.Discard, io.Discard)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(session).Should(gexec.Exit(exitSuccess))
+			Expect(string(session.Out.Contents())).To(ContainSubstring("in the format of the conventional commits"))
+			Expect(string(session.Out.Contents())).To(ContainSubstring(`"content": "The staged diff:\n\ndiff --git a/main.go b/main.go\n`))
+		})
+
+		it("explains a piped diff with explain-diff, part by part when it is long", func() {
+			diff := "diff --git a/main.go b/main.go\n--- a/main.go\n+++ b/main.go\n@@ -1 +1 @@\n-package old\n+package main\n"
+
+			command := exec.Command(binaryPath, "explain-diff", "--dry-run")
+			command.Stdin = strings.NewReader(diff)
+			session, err := gexec.Start(command, io.Discard, io.Discard)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(session).Should(gexec.Exit(exitSuccess))
+			Expect(string(session.Out.Contents())).To(ContainSubstring("to a reviewer who hasn't read them"))
+			Expect(string(session.Out.Contents())).To(ContainSubstring(`"content": "Explain this diff.\n\n`))
+
+			Expect(os.Setenv("OPENAI_CHUNK_TOKENS", "60")).To(Succeed())
+			defer os.Unsetenv("OPENAI_CHUNK_TOKENS")
+
+			command = exec.Command(binaryPath, "explain-diff")
+			command.Stdin = strings.NewReader(diff + strings.Replace(diff, "main.go", "util.go", -1))
+			session, err = gexec.Start(command, io.Discard, io.Discard)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(session).Should(gexec.Exit(exitSuccess))
+			Expect(string(session.Err.Contents())).To(ContainSubstring("Explaining part 1 of 2\nExplaining part 2 of 2\nMerging the explanations of the 2 parts\n"))
+			Expect(session.Out.Contents()).NotTo(BeEmpty())
+
+			command = exec.Command(binaryPath, "explain-diff")
+			session, err = gexec.Start(command, io.Discard, io.Discard)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(session).Should(gexec.Exit(exitFailure))
+			Expect(string(session.Err.Contents())).To(ContainSubstring("explain-diff explains what is piped"))
+		})
+
+		it("translates the arguments or the piped text with translate", func() {
+			output := runCommand("translate", "--to", "de", "--from", "en", "--formality", "casual", "--dry-run", "Good morning")
+			Expect(output).To(ContainSubstring("Translate the text of each message of the user from en into de. "))
+			Expect(output).To(ContainSubstring("Use a casual register"))
+			Expect(output).To(ContainSubstring(`"content": "Good morning"`))
+
+			command := exec.Command(binaryPath, "translate", "--to", "de")
+			command.Stdin = strings.NewReader("Good morning\n\nGood night\n")
+			session, err := gexec.Start(command, io.Discard, io.Discard)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(session).Should(gexec.Exit(exitSuccess))
+			Expect(session.Out.Contents()).NotTo(BeEmpty())
+
+			for args, message := range map[string]string{
+				"translate --from en Hello":              "translate needs the language to translate into",
+				"translate --to de --formality polite x": `invalid formality "polite", use casual or formal`,
+				"--to de Hello":                          "--to, --from and --formality only set the translation",
+				"translate --to de":                      "translate needs a text",
+			} {
+				command = exec.Command(binaryPath, strings.Fields(args)...)
+				session, err = gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+				Eventually(session).Should(gexec.Exit(exitUsage))
+				Expect(string(session.Err.Contents())).To(ContainSubstring(message), args)
+			}
+		})
+
+		it("summarizes the page of a URL with summarize", func() {
+			page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/post":
+					w.Header().Set("Content-Type", "text/html")
+					_, _ = io.WriteString(w, "<title>Release</title><nav>Home</nav><main><h1>Shipping</h1><p>Upgrade before June.</p></main>")
+				case "/doc.pdf":
+					w.Header().Set("Content-Type", "application/pdf")
+					_, _ = io.WriteString(w, "%PDF-1.7")
+				default:
+					http.Redirect(w, r, "/post", http.StatusFound)
+				}
+			}))
+			defer page.Close()
+
+			output := runCommand("summarize", "--dry-run", page.URL+"/old")
+			Expect(output).To(ContainSubstring("## Key points"))
+			Expect(output).To(ContainSubstring(`"content": "Summarize this page.\n\nURL: ` + page.URL + `/post\nTitle: Release\n\nThe text of the page:\n\n# Shipping\n\nUpgrade before June."`))
+
+			output = runCommand("summarize", "--dry-run", "--selector", "main p", page.URL+"/post")
+			Expect(output).To(ContainSubstring(`The text of the page:\n\nUpgrade before June."`))
+
+			for args, failure := range map[string]struct {
+				code    int
+				message string
+			}{
+				"summarize " + page.URL + "/doc.pdf":                  {exitFailure, "the page is a PDF"},
+				"summarize --selector #missing " + page.URL + "/post": {exitFailure, `no element of the page matches the selector "#missing"`},
+				"--selector main hello":                               {exitUsage, "--selector only scopes the page of chatgpt summarize <url>"},
+			} {
+				command := exec.Command(binaryPath, strings.Fields(args)...)
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+				Eventually(session).Should(gexec.Exit(failure.code))
+				Expect(string(session.Err.Contents())).To(ContainSubstring(failure.message), args)
+			}
+		})
+
+		it("prints neither the progress nor the usage with --quiet", func() {
+			Expect(os.Setenv("OPENAI_TRACK_TOKEN_USAGE", "true")).To(Succeed())
+
+			for _, args := range [][]string{{"--query", "some-query"}, {"--query", "--quiet", "some-query"}} {
+				command := exec.Command(binaryPath, args...)
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitSuccess))
+				Expect(session.Out.Contents()).NotTo(BeEmpty())
+
+				// stderr isn't a terminal, so the spinner is never drawn
+				Expect(string(session.Err.Contents())).NotTo(ContainSubstring("Waiting for"))
+				Expect(strings.Contains(string(session.Err.Contents()), "Token Usage:")).To(Equal(len(args) == 2))
+			}
+		})
+
+		it("writes the answer to a file with the --output flag", func() {
+			Expect(os.Setenv("OPENAI_TRACK_TOKEN_USAGE", "false")).To(Succeed())
+			answerFile := path.Join(t.TempDir(), "answers", "bars.md")
+
+			command := exec.Command(binaryPath, "--query", "--output", answerFile, "some-query")
+			session, err := gexec.Start(command, io.Discard, io.Discard)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(session).Should(gexec.Exit(exitUsage))
+			Expect(string(session.Err.Contents())).To(ContainSubstring("add --mkdir to create it"))
+
+			output := runCommand("--query", "--output", answerFile, "--mkdir", "some-query")
+			Expect(output).NotTo(ContainSubstring("Red Hook"))
+
+			data, err := os.ReadFile(answerFile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(ContainSubstring("popular bars in Red Hook"))
+
+			command = exec.Command(binaryPath, "--query", "--output", answerFile, "some-query")
+			session, err = gexec.Start(command, io.Discard, io.Discard)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(session).Should(gexec.Exit(exitUsage))
+			Expect(string(session.Err.Contents())).To(ContainSubstring("already exists, add --force to overwrite it"))
+
+			output = runCommand("--query", "--output", answerFile, "--force", "--tee", "some-query")
+			Expect(output).To(ContainSubstring("popular bars in Red Hook"))
+
+			// an answer without code leaves the file of --code-only as it was
+			command = exec.Command(binaryPath, "--query", "--output", answerFile, "--force", "--code-only", "some-query")
+			session, err = gexec.Start(command, io.Discard, io.Discard)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(session).Should(gexec.Exit(exitFailure))
+			Expect(string(session.Err.Contents())).To(ContainSubstring("the answer has no code block"))
+			Expect(os.ReadFile(answerFile)).To(Equal(data))
+		})
+
+		it("prints the request without sending it with the --dry-run flag", func() {
+			output := runCommand("--dry-run", "some-query")
+
+			Expect(output).To(ContainSubstring(fmt.Sprintf("POST %s/v1/chat/completions", serviceURL)))
+			Expect(output).To(ContainSubstring(`"content": "some-query"`))
+			Expect(output).To(ContainSubstring(`"stream": true`))
+			Expect(output).NotTo(ContainSubstring("Red Hook"))
+		})
+
+		when("input is piped", func() {
+			runPiped := func(input string, args ...string) *gexec.Session {
+				command := exec.Command(binaryPath, append([]string{"--dry-run"}, args...)...)
+				command.Stdin = strings.NewReader(input)
+
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				ExpectWithOffset(1, err).NotTo(HaveOccurred())
+				<-session.Exited
+
+				return session
+			}
+
+			it("uses the piped input as the query when there is no query", func() {
+				session := runPiped("line one\nline two\n\n")
+
+				Expect(session).To(gexec.Exit(0))
+				Expect(string(session.Out.Contents())).To(ContainSubstring(`"content": "line one\nline two"`))
+			})
+			it("composes the query and the piped input fenced as a code block", func() {
+				session := runPiped("package main\n", "find", "the", "race")
+
+				Expect(session).To(gexec.Exit(0))
+				Expect(string(session.Out.Contents())).To(ContainSubstring(`"content": "find the race\n\n` + "```go\\npackage main\\n```" + `"`))
+			})
+			it("composes them with the pipe_template", func() {
+				Expect(os.Setenv("OPENAI_PIPE_TEMPLATE", "{{.input}} | {{.instruction}}")).To(Succeed())
+				defer os.Unsetenv("OPENAI_PIPE_TEMPLATE")
+
+				session := runPiped("some input", "some-query")
+
+				Expect(session).To(gexec.Exit(0))
+				Expect(string(session.Out.Contents())).To(ContainSubstring(`"content": "some input | some-query"`))
+			})
+			it("refuses input that isn't text", func() {
+				session := runPiped("\x89PNG\x00\x00", "describe this")
+
+				Expect(session).To(gexec.Exit(exitUsage))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("the piped input isn't text"))
+			})
+			it("refuses input larger than max_pipe_bytes", func() {
+				Expect(os.Setenv("OPENAI_MAX_PIPE_BYTES", "8")).To(Succeed())
+				defer os.Unsetenv("OPENAI_MAX_PIPE_BYTES")
+
+				session := runPiped("more than eight bytes")
+
+				Expect(session).To(gexec.Exit(exitUsage))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("larger than max_pipe_bytes (8 bytes)"))
+			})
+			it("warns about a query larger than the context_window before it is sent", func() {
+				session := runPiped(strings.Repeat("word ", 20000))
+
+				Expect(session).To(gexec.Exit(0))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("Warning: the query is about 50000 tokens, more than the context_window of 8192"))
+			})
+		})
+
+		it("notifies the end of a query that took at least notify_after seconds with --notify", func() {
+			dir := t.TempDir()
+			record := filepath.Join(dir, "notification")
+			script := "#!/bin/sh\nprintf '%s\\n' \"$@\" > \"" + record + "\"\n"
+			Expect(os.WriteFile(filepath.Join(dir, "notify-send"), []byte(script), 0755)).To(Succeed())
+
+			notified := func(args ...string) *gexec.Session {
+				command := exec.Command(binaryPath, args...)
+				command.Env = append(os.Environ(), "PATH="+dir+string(os.PathListSeparator)+os.Getenv("PATH"), "WSL_DISTRO_NAME=")
+
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				ExpectWithOffset(1, err).NotTo(HaveOccurred())
+				<-session.Exited
+
+				return session
+			}
+
+			Expect(notified("--query", "--notify", "some-query")).To(gexec.Exit(0))
+			Expect(record).NotTo(BeAnExistingFile())
+
+			Expect(notified("--query", "--notify", "--notify-after", "0", "some-query")).To(gexec.Exit(0))
+			data, err := os.ReadFile(record)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(MatchRegexp(`^--app-name\nchatgpt-cli\nchatgpt answered in [0-9.]+m?s\n.*I don't have personal opinions about bars`))
+
+			Expect(notified("--query", "--notify", "--notify-after", "0", "--expect-json", "some-query")).To(gexec.Exit(exitFailure))
+			data, err = os.ReadFile(record)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(ContainSubstring("chatgpt failed after"))
+		})
+
+		it("fails a query that took longer than its --timeout and tells how to raise it", func() {
+			command := exec.Command(binaryPath, "--query", "--timeout", "1ns", "some-query")
+			session, err := gexec.Start(command, io.Discard, io.Discard)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+
+			Expect(session).To(gexec.Exit(exitNetwork))
+			Expect(string(session.Err.Contents())).To(ContainSubstring("query timeout after 1ns"))
+			Expect(string(session.Err.Contents())).To(ContainSubstring("Raise the query timeout with --timeout"))
+		})
+
+		it("refuses a timeout that isn't a duration", func() {
+			command := exec.Command(binaryPath, "--idle-timeout", "soon", "some-query")
+			session, err := gexec.Start(command, io.Discard, io.Discard)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+
+			Expect(session).To(gexec.Exit(exitUsage))
+			Expect(string(session.Err.Contents())).To(ContainSubstring(`invalid idle_timeout: "soon" isn't a timeout`))
+		})
+
+		when("the clipboard is pasted", func() {
+			var dir string
+
+			// a fake xclip prints the clipboard, which is the file it reads
+			runPasted := func(clipboard []byte, args ...string) *gexec.Session {
+				ExpectWithOffset(1, os.WriteFile(filepath.Join(dir, "clipboard"), clipboard, 0644)).To(Succeed())
+
+				command := exec.Command(binaryPath, append([]string{"--dry-run", "--paste"}, args...)...)
+				command.Env = append(os.Environ(), "PATH="+dir+string(os.PathListSeparator)+os.Getenv("PATH"),
+					"DISPLAY=:0", "WAYLAND_DISPLAY=", "WSL_DISTRO_NAME=", "OPENAI_MAX_PIPE_BYTES=64")
+
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				ExpectWithOffset(1, err).NotTo(HaveOccurred())
+				<-session.Exited
+
+				return session
+			}
+
+			it.Before(func() {
+				dir = t.TempDir()
+				script := "#!/bin/sh\ncat \"" + filepath.Join(dir, "clipboard") + "\"\n"
+				Expect(os.WriteFile(filepath.Join(dir, "xclip"), []byte(script), 0755)).To(Succeed())
+			})
+
+			it("composes the query and the clipboard as it composes the piped input", func() {
+				session := runPasted([]byte("package main\n"), "find", "the", "race")
+
+				Expect(session).To(gexec.Exit(0))
+				Expect(string(session.Out.Contents())).To(ContainSubstring(`"content": "find the race\n\n` + "```go\\npackage main\\n```" + `"`))
+			})
+			it("refuses an empty clipboard, one that isn't text and one larger than max_pipe_bytes", func() {
+				for clipboard, message := range map[string]string{
+					"\n":                     "the clipboard is empty",
+					"\x89PNG\x00\x00":        "the clipboard doesn't hold text",
+					strings.Repeat("x", 100): "the clipboard is larger than max_pipe_bytes (64 bytes)",
+				} {
+					session := runPasted([]byte(clipboard), "describe this")
+
+					Expect(session).To(gexec.Exit(exitFailure))
+					Expect(string(session.Err.Contents())).To(ContainSubstring(message))
+				}
+			})
+		})
+
+		when("files are attached", func() {
+			var dir string
+
+			it.Before(func() {
+				dir = t.TempDir()
+			})
+
+			it("puts the files after the query in their order, fenced like the piped input", func() {
+				Expect(os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0644)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("some notes"), 0644)).To(Succeed())
+
+				output := runCommand("--dry-run", "-f", filepath.Join(dir, "notes.txt"), "--file", filepath.Join(dir, "main.go"), "review", "these")
+				Expect(output).To(ContainSubstring(`"content": "review these\n\nFile: ` + filepath.Join(dir, "notes.txt") + "\\n```\\nsome notes\\n```" +
+					`\n\nFile: ` + filepath.Join(dir, "main.go") + "\\n```go\\npackage main\\n```" + `"`))
+			})
+			it("truncates the files larger than max_file_kb, and refuses the binary ones", func() {
+				Expect(os.Setenv("OPENAI_MAX_FILE_KB", "1")).To(Succeed())
+				defer os.Unsetenv("OPENAI_MAX_FILE_KB")
+
+				Expect(os.WriteFile(filepath.Join(dir, "big.log"), []byte(strings.Repeat("a line\n", 1000)), 0644)).To(Succeed())
+
+				command := exec.Command(binaryPath, "--dry-run", "-f", filepath.Join(dir, "big.log"), "summarize")
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+				Eventually(session).Should(gexec.Exit(exitSuccess))
+				Expect(string(session.Out.Contents())).To(ContainSubstring("```\\n[truncated at 1 KB]"))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("is larger than max_file_kb (1 KB), only its start is attached"))
+
+				Expect(os.WriteFile(filepath.Join(dir, "image.png"), []byte("\x89PNG\x00\x00"), 0644)).To(Succeed())
+
+				command = exec.Command(binaryPath, "--dry-run", "-f", filepath.Join(dir, "image.png"), "describe")
+				session, err = gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+				Eventually(session).Should(gexec.Exit(exitFailure))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("image.png isn't a text file, only text files can be attached"))
+			})
+			it("attaches the files of the project that --context matches, and reports them", func() {
+				Expect(os.MkdirAll(filepath.Join(dir, "internal"), 0755)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(dir, "internal", "a.go"), []byte("package a\n"), 0644)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(dir, "internal", "a_test.go"), []byte("package a\n"), 0644)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(dir, "internal", "big.go"), []byte("package a\n"+strings.Repeat("// padding text\n", 200)), 0644)).To(Succeed())
+				Expect(os.Setenv("OPENAI_CONTEXT_BUDGET", "100")).To(Succeed())
+				defer os.Unsetenv("OPENAI_CONTEXT_BUDGET")
+
+				command := exec.Command(binaryPath, "--dry-run", "--context", "internal/**/*.go", "--context", "!**/*_test.go", "explain")
+				command.Dir = dir
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+				Eventually(session).Should(gexec.Exit(exitSuccess))
+
+				Expect(string(session.Out.Contents())).To(ContainSubstring(`"content": "explain\n\nFile: internal/a.go\n` + "```go\\npackage a\\n```" + `"`))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("Context: 1 file(s), about"))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("  + internal/a.go (about"))
+				Expect(string(session.Err.Contents())).To(MatchRegexp(`  - internal/big.go \(about \d+ tokens, more than the \d+ left of the budget\)`))
+				Expect(string(session.Err.Contents())).NotTo(ContainSubstring("a_test.go"))
+
+				command = exec.Command(binaryPath, "--dry-run", "--context", "**/*.rs", "explain")
+				command.Dir = dir
+				session, err = gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+				Eventually(session).Should(gexec.Exit(exitFailure))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("no file matches --context **/*.rs"))
+			})
+		})
+
+		it("asks for corrections and fails when the answer is not valid JSON with the --expect-json flag", func() {
+			command := exec.Command(binaryPath, "--expect-json", "--json-retries", "1", "some-query")
+			session, err := gexec.Start(command, io.Discard, io.Discard)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(session).Should(gexec.Exit(exitFailure))
+
+			output := string(session.Err.Contents())
+			Expect(output).To(ContainSubstring("invalid JSON after 2 attempt(s)"))
+		})
+
+		it("uses the rendered template as the query with the --template flag", func() {
+			templatesDir := t.TempDir()
+			Expect(os.WriteFile(path.Join(templatesDir, "code-review.tmpl"), []byte("Review this code for {{.focus}}"), 0644)).To(Succeed())
+
+			Expect(os.Setenv(utils.TemplatesHomeEnv, templatesDir)).To(Succeed())
+			defer os.Unsetenv(utils.TemplatesHomeEnv)
+
+			output := runCommand("--dry-run", "--template", "code-review", "--var", "focus=concurrency")
+			Expect(output).To(ContainSubstring(`"content": "Review this code for concurrency"`))
+
+			command := exec.Command(binaryPath, "--dry-run", "--template", "code-review")
+			session, err := gexec.Start(command, io.Discard, io.Discard)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(session).Should(gexec.Exit(exitFailure))
+			Expect(string(session.Err.Contents())).To(ContainSubstring("focus"))
+		})
+
+		it("asks the named prompt of the library with its settings, below those of the flags, with --prompt", func() {
+			promptsDir := t.TempDir()
+			Expect(os.WriteFile(path.Join(promptsDir, "code-review.md"), []byte("---\nmodel: gpt-4o\n---\n# Review code\nReview this code for {{.focus}}"), 0644)).To(Succeed())
+
+			Expect(os.Setenv(utils.PromptsHomeEnv, promptsDir)).To(Succeed())
+			defer os.Unsetenv(utils.PromptsHomeEnv)
+
+			output := runCommand("--dry-run", "-p", "code-review", "--var", "focus=concurrency", "and", "errors")
+			Expect(output).To(ContainSubstring(`"model": "gpt-4o"`))
+			Expect(output).To(ContainSubstring(`"content": "# Review code\nReview this code for concurrency\n\nand errors"`))
+
+			output = runCommand("--dry-run", "-p", "code-review", "--var", "focus=concurrency", "--model", "gpt-4o-mini")
+			Expect(output).To(ContainSubstring(`"model": "gpt-4o-mini"`))
+
+			Expect(runCommand("prompts", "list")).To(Equal("code-review  Review code\n"))
+
+			command := exec.Command(binaryPath, "--dry-run", "-p", "code-reveiw")
+			session, err := gexec.Start(command, io.Discard, io.Discard)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(session).Should(gexec.Exit(exitFailure))
+			Expect(string(session.Err.Contents())).To(ContainSubstring("unknown prompt code-reveiw, did you mean code-review?"))
+		})
+
+		it("asks the prompt of --prompt-url, kept along with its ETag for offline use", func() {
+			var requests []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests = append(requests, r.Header.Get("X-Team")+"|"+r.Header.Get("If-None-Match"))
+				switch {
+				case r.URL.Path == "/page":
+					w.Header().Set("Content-Type", "text/html")
+					_, _ = io.WriteString(w, "<html>Review</html>")
+				case r.URL.Path != "/review.md":
+					http.NotFound(w, r)
+				case r.Header.Get("If-None-Match") == `"v1"`:
+					w.WriteHeader(http.StatusNotModified)
+				default:
+					w.Header().Set("ETag", `"v1"`)
+					_, _ = io.WriteString(w, "Review this code for {{.focus}}")
+				}
+			}))
+
+			output := runCommand("--dry-run", "--prompt-url", server.URL+"/review.md", "--var", "focus=races", "--header", "X-Team: a")
+			Expect(output).To(ContainSubstring(`"content": "Review this code for races"`))
+
+			// the headers only go to the hosts of prompt_url_hosts
+			Expect(os.Setenv("OPENAI_PROMPT_URL_HOSTS", "127.0.0.1")).To(Succeed())
+			defer os.Unsetenv("OPENAI_PROMPT_URL_HOSTS")
+			output = runCommand("--dry-run", "--prompt-url", server.URL+"/review.md", "--var", "focus=leaks", "--header", "X-Team: a")
+			Expect(output).To(ContainSubstring(`"content": "Review this code for leaks"`))
+			Expect(requests).To(Equal([]string{"|", `a|"v1"`}))
+
+			fail := func(args ...string) *gexec.Session {
+				session, err := gexec.Start(exec.Command(binaryPath, args...), io.Discard, io.Discard)
+				ExpectWithOffset(1, err).NotTo(HaveOccurred())
+				<-session.Exited
+				return session
+			}
+
+			session := fail("--dry-run", "--prompt-url", server.URL+"/missing.md")
+			Expect(session).To(gexec.Exit(exitFailure))
+			Expect(string(session.Err.Contents())).To(ContainSubstring("404 Not Found"))
+
+			session = fail("--dry-run", "--prompt-url", server.URL+"/page")
+			Expect(session).To(gexec.Exit(exitFailure))
+			Expect(string(session.Err.Contents())).To(ContainSubstring("is a web page rather than a prompt"))
+
+			// the copy that was kept is used offline, unless it is refreshed
+			server.Close()
+
+			session = fail("--dry-run", "--prompt-url", server.URL+"/review.md", "--var", "focus=races")
+			Expect(session).To(gexec.Exit(exitSuccess))
+			Expect(string(session.Out.Contents())).To(ContainSubstring(`"content": "Review this code for races"`))
+			Expect(string(session.Err.Contents())).To(ContainSubstring("can't be reached, the copy fetched"))
+
+			session = fail("--dry-run", "--refresh", "--prompt-url", server.URL+"/review.md", "--var", "focus=races")
+			Expect(session).To(gexec.Exit(exitNetwork))
+		})
+
+		it("expands the aliases of alias add to their arguments, below the flags that were typed", func() {
+			configHomeDir := t.TempDir()
+			Expect(os.WriteFile(path.Join(configHomeDir, "config.yaml"), []byte("model: gpt-4o\n"), 0644)).To(Succeed())
+
+			Expect(os.Setenv(utils.ConfigHomeEnv, configHomeDir)).To(Succeed())
+			defer os.Unsetenv(utils.ConfigHomeEnv)
+
+			runCommand("alias", "add", "terse", "--system", "Don't be long", "--temperature", "0.2")
+			runCommand("alias", "add", "fast", "terse", "--model", "gpt-4o-mini")
+			Expect(runCommand("alias", "list")).To(Equal("fast   terse --model gpt-4o-mini\nterse  --system 'Don'\\''t be long' --temperature 0.2\n"))
+
+			output := runCommand("fast", "--dry-run", "hello")
+			Expect(output).To(ContainSubstring(`"model": "gpt-4o-mini"`))
+			Expect(output).To(ContainSubstring(`"temperature": 0.2`))
+			Expect(output).To(ContainSubstring(`"content": "Don't be long"`))
+
+			output = runCommand("fast", "--dry-run", "--model", "gpt-4o", "hello")
+			Expect(output).To(ContainSubstring(`"model": "gpt-4o"`))
+
+			fail := func(args ...string) string {
+				session, err := gexec.Start(exec.Command(binaryPath, args...), io.Discard, io.Discard)
+				ExpectWithOffset(1, err).NotTo(HaveOccurred())
+				EventuallyWithOffset(1, session).Should(gexec.Exit(exitUsage))
+				return string(session.Err.Contents())
+			}
+
+			Expect(fail("alias", "add", "history", "--list-threads")).To(ContainSubstring("history is a command of chatgpt, an alias can't replace it"))
+			Expect(fail("alias", "add", "terse", "fast")).To(ContainSubstring("alias terse expands to itself: terse -> fast -> terse"))
+			Expect(fail("alias", "add", "quick", "--modle", "gpt-4o-mini")).To(ContainSubstring("unknown flag --modle in the alias"))
+
+			runCommand("alias", "remove", "fast")
+			Expect(runCommand("alias", "list")).NotTo(ContainSubstring("fast"))
+		})
+
+		it("prints debug information with the --debug flag", func() {
+			Expect(os.Setenv("OPENAI_DEBUG", "true")).To(Succeed())
+
+			command := exec.Command(binaryPath, "--query", "tell me a joke")
+			session, err := gexec.Start(command, io.Discard, io.Discard)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(session).Should(gexec.Exit(exitSuccess))
+
+			// the debug information goes to stderr, stdout is left to the answer
+			output := string(session.Err.Contents())
+			Expect(output).To(ContainSubstring("Generated cURL command"))
+			Expect(output).To(ContainSubstring("/v1/chat/completions"))
+			Expect(output).To(ContainSubstring("--header \"Authorization: Bearer ${OPENAI_API_KEY}\""))
+			Expect(output).To(ContainSubstring("--header 'Content-Type: application/json'"))
+			Expect(output).To(ContainSubstring("\"model\":\"gpt-3.5-turbo\""))
+			Expect(output).To(ContainSubstring("\"messages\":"))
+			Expect(output).To(ContainSubstring("Response"))
+			Expect(string(session.Out.Contents())).NotTo(ContainSubstring("Generated cURL command"))
+
+			Expect(os.Unsetenv("OPENAI_DEBUG")).To(Succeed())
+		})
+
+		it("logs the requests with the authorization redacted with CHATGPT_CLI_DEBUG", func() {
+			Expect(os.Setenv("CHATGPT_CLI_DEBUG", "1")).To(Succeed())
+			defer os.Unsetenv("CHATGPT_CLI_DEBUG")
+
+			command := exec.Command(binaryPath, "--query", "tell me a joke")
+			session, err := gexec.Start(command, io.Discard, io.Discard)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(session).Should(gexec.Exit(exitSuccess))
+
+			output := string(session.Err.Contents())
+			Expect(output).To(ContainSubstring("-> POST " + serviceURL + "/v1/chat/completions"))
+			Expect(output).To(ContainSubstring("Authorization: ****"))
+			Expect(output).NotTo(ContainSubstring(os.Getenv(apiKeyEnvVar)))
+			Expect(output).To(ContainSubstring("[Model: gpt-3.5-turbo |"))
+		})
+
+		it("reports the model, the usage, the elapsed time and the retries to stderr with --verbose", func() {
+			command := exec.Command(binaryPath, "--verbose", "--model", "gpt-4o", "--query", "tell me a joke")
+			session, err := gexec.Start(command, io.Discard, io.Discard)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(session).Should(gexec.Exit(exitSuccess))
+
+			Expect(string(session.Err.Contents())).To(MatchRegexp(
+				`\[Model: gpt-4o \| Tokens: \d+ \(prompt \d+ / completion \d+\) \| Cost: \$\d+\.\d{4} \| Elapsed: \S+ \| Retries: 0\]`))
+			Expect(string(session.Out.Contents())).NotTo(ContainSubstring("[Model:"))
+		})
+
+		it("prints the usage of the answer on a line of stderr with --usage", func() {
+			command := exec.Command(binaryPath, "--usage", "--model", "gpt-4o", "--query", "tell me a joke")
+			session, err := gexec.Start(command, io.Discard, io.Discard)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(session).Should(gexec.Exit(exitSuccess))
+
+			Expect(string(session.Err.Contents())).To(MatchRegexp(`(?m)^gpt-4o · 16 prompt \+ 92 completion tokens · <\$0\.001 · \d+\.\ds$`))
+			Expect(string(session.Out.Contents())).NotTo(ContainSubstring("completion tokens ·"))
+		})
+
+		it("prints no escape byte with NO_COLOR or --no-color, whatever the command", func() {
+			commands := [][]string{
+				{"--verbose", "--usage", "--query", "tell me a joke"},
+				{"--verbose", "tell me a joke"},
+				{"--show-history"},
+				{"--list-threads"},
+				{"doctor"},
+			}
+
+			for _, noColor := range []bool{true, false} {
+				for _, args := range commands {
+					command := exec.Command(binaryPath, args...)
+					command.Env = append(os.Environ(), "CHATGPT_CLI_NO_UPDATE_CHECK=true", "COLORTERM=truecolor")
+					if noColor {
+						command.Env = append(command.Env, "NO_COLOR=1")
+					} else {
+						command.Args = append(command.Args, "--no-color")
+					}
+
+					session, err := gexec.Start(command, io.Discard, io.Discard)
+					Expect(err).NotTo(HaveOccurred())
+					Eventually(session).Should(gexec.Exit())
+
+					output := append(session.Out.Contents(), session.Err.Contents()...)
+					Expect(output).NotTo(BeEmpty())
+					Expect(output).NotTo(ContainElement(byte(0x1b)), strings.Join(args, " "))
+				}
+			}
+		})
+
+		it("prints only the answer with --quiet, and with quiet_mode along with --raw", func() {
+			Expect(os.Setenv(strings.Replace(apiKeyEnvVar, "API_KEY", "CONTEXT_WINDOW", 1), "5")).To(Succeed())
+			defer os.Unsetenv(strings.Replace(apiKeyEnvVar, "API_KEY", "CONTEXT_WINDOW", 1))
+
+			command := exec.Command(binaryPath, "--usage", "--query", "tell me a joke")
+			session, err := gexec.Start(command, io.Discard, io.Discard)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(session).Should(gexec.Exit(exitSuccess))
+			Expect(string(session.Err.Contents())).To(ContainSubstring("Warning: the query is about"))
+
+			command = exec.Command(binaryPath, "--quiet", "--usage", "--query", "tell me a joke")
+			session, err = gexec.Start(command, io.Discard, io.Discard)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(session).Should(gexec.Exit(exitSuccess))
+			Expect(session.Err.Contents()).To(BeEmpty())
+			answer := string(session.Out.Contents())
+			Expect(answer).NotTo(BeEmpty())
+
+			Expect(os.Setenv(strings.Replace(apiKeyEnvVar, "API_KEY", "QUIET_MODE", 1), "true")).To(Succeed())
+			defer os.Unsetenv(strings.Replace(apiKeyEnvVar, "API_KEY", "QUIET_MODE", 1))
+
+			command = exec.Command(binaryPath, "--raw", "--usage", "--query", "tell me a joke")
+			session, err = gexec.Start(command, io.Discard, io.Discard)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(session).Should(gexec.Exit(exitSuccess))
+			Expect(session.Err.Contents()).To(BeEmpty())
+			Expect(string(session.Out.Contents())).To(Equal(answer))
+		})
+
+		it("should assemble http errors as expected", func() {
+			Expect(os.Setenv(apiKeyEnvVar, "wrong-token")).To(Succeed())
+
+			command := exec.Command(binaryPath, "--query", "some-query")
+			session, err := gexec.Start(command, io.Discard, io.Discard)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(session).Should(gexec.Exit(exitAuth))
+
+			output := string(session.Err.Contents())
+
+			// see error.json
+			Expect(output).To(Equal("invalid_api_key: Incorrect API key provided\n"))
+		})
+
+		it("prints the answer and the errors as JSON objects on stdout with --json", func() {
+			var answer map[string]interface{}
+			Expect(json.Unmarshal([]byte(runCommand("--json", "--query", "tell me a joke")), &answer)).To(Succeed())
+			Expect(answer["content"]).NotTo(BeEmpty())
+			Expect(answer["model"]).NotTo(BeEmpty())
+			Expect(answer["session_id"]).NotTo(BeEmpty())
+			Expect(answer).To(HaveKey("usage"))
+			Expect(answer).To(HaveKey("elapsed_ms"))
+
+			Expect(os.Setenv(apiKeyEnvVar, "wrong-token")).To(Succeed())
+
+			command := exec.Command(binaryPath, "--json", "--query", "some-query")
+			session, err := gexec.Start(command, io.Discard, io.Discard)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(session).Should(gexec.Exit(exitAuth))
+			Expect(session.Err.Contents()).To(BeEmpty())
+
+			var failure map[string]string
+			Expect(json.Unmarshal(session.Out.Contents(), &failure)).To(Succeed())
+			Expect(failure).To(Equal(map[string]string{"error": "invalid_api_key: Incorrect API key provided"}))
+		})
+
+		when("there is a hidden chatgpt-cli folder in the home dir", func() {
+			it.Before(func() {
+				filePath = path.Join(os.Getenv("HOME"), ".chatgpt-cli")
+				Expect(os.MkdirAll(filePath, 0777)).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.RemoveAll(filePath)).To(Succeed())
+			})
+
+			it("should not require an API key for the --list-threads flag", func() {
+				historyPath := path.Join(filePath, "history")
+				Expect(os.MkdirAll(historyPath, 0777)).To(Succeed())
+
+				Expect(os.Unsetenv(apiKeyEnvVar)).To(Succeed())
+
+				command := exec.Command(binaryPath, "--list-threads")
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitSuccess))
+			})
+
+			it("migrates the legacy history as expected", func() {
+				// Legacy history file should not exist
+				legacyFile := path.Join(filePath, "history")
+				Expect(legacyFile).NotTo(BeAnExistingFile())
+
+				// History should not exist yet
+				historyFile := path.Join(filePath, "history", "default.json")
+				Expect(historyFile).NotTo(BeAnExistingFile())
+
+				bytes, err := utils.FileToBytes("history.json")
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(os.WriteFile(legacyFile, bytes, 0644)).To(Succeed())
+				Expect(legacyFile).To(BeARegularFile())
+
+				// Perform a query
+				command := exec.Command(binaryPath, "--query", "some-query")
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				// The CLI response should be as expected
+				Eventually(session).Should(gexec.Exit(exitSuccess))
+
+				output := string(session.Out.Contents())
+
+				response := `I don't have personal opinions about bars, but here are some popular bars in Red Hook, Brooklyn:`
+				Expect(output).To(ContainSubstring(response))
+
+				// The history file should have the expected content
+				Expect(path.Dir(historyFile)).To(BeADirectory())
+				content, err := os.ReadFile(historyFile)
+
+				Expect(err).NotTo(HaveOccurred())
+				Expect(content).NotTo(BeEmpty())
+				Expect(string(content)).To(ContainSubstring(response))
+
+				// The legacy file should now be a directory
+				Expect(legacyFile).To(BeADirectory())
+				Expect(legacyFile).NotTo(BeARegularFile())
+
+				// The content was moved to the new file
+				Expect(string(content)).To(ContainSubstring("Of course! Which city are you referring to?"))
+			})
+
+			it("should not require an API key for the --clear-history flag", func() {
+				Expect(os.Unsetenv(apiKeyEnvVar)).To(Succeed())
+
+				command := exec.Command(binaryPath, "--clear-history", "--force")
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitSuccess))
+			})
+
+			it("asks for confirmation before clearing the history", func() {
+				historyDir := path.Join(filePath, "history")
+				historyFile := path.Join(historyDir, "default.json")
+				Expect(os.MkdirAll(historyDir, 0755)).To(Succeed())
+				Expect(os.WriteFile(historyFile, []byte(`[{"role":"user","content":"hello"}]`), 0644)).To(Succeed())
+
+				command := exec.Command(binaryPath, "--clear-history")
+				command.Stdin = strings.NewReader("n\n")
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitSuccess))
+				Expect(string(session.Out.Contents())).To(ContainSubstring("Clear the history of thread default? [y/N]"))
+				Expect(string(session.Out.Contents())).To(ContainSubstring("History not cleared."))
+				Expect(historyFile).To(BeAnExistingFile())
+
+				command = exec.Command(binaryPath, "--clear-history")
+				command.Stdin = strings.NewReader("y\n")
+				session, err = gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitSuccess))
+				Expect(string(session.Out.Contents())).To(ContainSubstring("History successfully cleared."))
+				Expect(historyFile).NotTo(BeAnExistingFile())
+			})
+
+			it("includes the archives in the search and the export with the --include-archives flag", func() {
+				historyDir := path.Join(filePath, "history")
+				Expect(os.MkdirAll(path.Join(historyDir, ".archive"), 0755)).To(Succeed())
+				Expect(os.WriteFile(path.Join(historyDir, "default.json"), []byte(`[{"role":"user","content":"recent question"}]`), 0644)).To(Succeed())
+				Expect(os.WriteFile(path.Join(historyDir, ".archive", "default.1.json"), []byte(`[{"role":"user","content":"archived question"}]`), 0644)).To(Succeed())
+
+				output := runCommand("--search", "question")
+				Expect(output).To(ContainSubstring("recent"))
+				Expect(output).NotTo(ContainSubstring("archived"))
+
+				output = runCommand("--search", "question", "--include-archives")
+				Expect(output).To(ContainSubstring("default (archive 1) [0] user"))
+				Expect(output).To(ContainSubstring("default [0] user"))
+
+				output = runCommand("--export", "md", "--include-archives")
+				Expect(output).To(ContainSubstring("archived question"))
+				Expect(strings.Index(output, "archived question")).To(BeNumerically("<", strings.Index(output, "recent question")))
+			})
+
+			it("repairs the damaged threads with the --repair-history flag and when they are read", func() {
+				historyDir := path.Join(filePath, "history")
+				historyFile := path.Join(historyDir, "default.json")
+				Expect(os.MkdirAll(historyDir, 0755)).To(Succeed())
+
+				data, err := utils.FileToBytes(path.Join("history", "damaged-truncated.json"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(os.WriteFile(historyFile, data, 0644)).To(Succeed())
+
+				output := runCommand("--repair-history")
+				Expect(output).To(ContainSubstring("Repaired the damaged thread default, recovered 3 messages and lost 1, the damaged file was moved to " + historyFile + ".corrupt-"))
+
+				output = runCommand("--repair-history")
+				Expect(output).To(ContainSubstring("No damaged threads were found"))
+
+				// without a backup to fall back to, the thread is repaired as it is read
+				Expect(os.WriteFile(historyFile, data, 0644)).To(Succeed())
+				Expect(os.Remove(historyFile + ".bak")).To(Succeed())
+
+				command := exec.Command(binaryPath, "--show-history")
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitSuccess))
+				Expect(string(session.Out.Contents())).To(ContainSubstring("Which bars are in Red Hook?"))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("Warning: repaired the damaged thread default, recovered 3 messages and lost 1"))
+			})
+
+			it("compresses the existing archives with the --compress-archives flag", func() {
+				historyDir := path.Join(filePath, "history")
+				Expect(os.MkdirAll(path.Join(historyDir, ".archive"), 0755)).To(Succeed())
+				Expect(os.WriteFile(path.Join(historyDir, "default.json"), []byte(`[{"role":"user","content":"recent question"}]`), 0644)).To(Succeed())
+				Expect(os.WriteFile(path.Join(historyDir, ".archive", "default.1.json"), []byte(`[{"role":"user","content":"archived question"}]`), 0644)).To(Succeed())
+
+				output := runCommand("--compress-archives")
+				Expect(output).To(ContainSubstring("Successfully compressed 1 archives"))
+				Expect(path.Join(historyDir, ".archive", "default.1.json")).NotTo(BeAnExistingFile())
+				Expect(path.Join(historyDir, ".archive", "default.1.json.gz")).To(BeARegularFile())
+				Expect(path.Join(historyDir, "default.json")).To(BeARegularFile())
+
+				output = runCommand("--export", "md", "--include-archives")
+				Expect(output).To(ContainSubstring("archived question"))
+			})
+
+			it("prunes the history with the --prune-history flag and on startup with history_max_age", func() {
+				historyDir := path.Join(filePath, "history")
+				historyFile := path.Join(historyDir, "default.json")
+				Expect(os.MkdirAll(historyDir, 0755)).To(Succeed())
+
+				old := time.Now().Add(-48 * time.Hour).UTC().Format(time.RFC3339)
+				content := `[{"role":"system","content":"You are a helpful assistant."},` +
+					`{"role":"user","content":"old question","timestamp":"` + old + `"},` +
+					`{"role":"assistant","content":"old answer","timestamp":"` + old + `"},` +
+					`{"role":"user","content":"recent question","timestamp":"` + time.Now().UTC().Format(time.RFC3339) + `"}]`
+				Expect(os.WriteFile(historyFile, []byte(content), 0644)).To(Succeed())
+
+				command := exec.Command(binaryPath, "--prune-history")
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitUsage))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("--older-than"))
+
+				output := runCommand("--prune-history", "--older-than", "1d", "--dry-run")
+				Expect(output).To(ContainSubstring("Would remove 2 messages from thread default, 2 left"))
+
+				data, err := os.ReadFile(historyFile)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(data)).To(ContainSubstring("old question"))
+
+				maxAgeEnvKey := strings.Replace(apiKeyEnvVar, "API_KEY", "HISTORY_MAX_AGE", 1)
+				Expect(os.Setenv(maxAgeEnvKey, "1d")).To(Succeed())
+				defer os.Unsetenv(maxAgeEnvKey)
+
+				output = runCommand("--show-history")
+				Expect(output).NotTo(ContainSubstring("old question"))
+				Expect(output).To(ContainSubstring("recent question"))
+
+				output = runCommand("--prune-history")
+				Expect(output).To(ContainSubstring("No messages are older than 1d."))
+			})
+
+			it("filters the search, the export and the pruning by date with --since and --until", func() {
+				historyDir := path.Join(filePath, "history")
+				historyFile := path.Join(historyDir, "default.json")
+				Expect(os.MkdirAll(historyDir, 0755)).To(Succeed())
+
+				old := time.Now().Add(-10 * 24 * time.Hour).UTC().Format(time.RFC3339)
+				content := `[{"role":"system","content":"You are a helpful assistant."},` +
+					`{"role":"user","content":"old question","timestamp":"` + old + `"},` +
+					`{"role":"user","content":"undated question"},` +
+					`{"role":"user","content":"recent question","timestamp":"` + time.Now().UTC().Format(time.RFC3339) + `"}]`
+				Expect(os.WriteFile(historyFile, []byte(content), 0644)).To(Succeed())
+
+				output := runCommand("--search", "question", "--since", "1w")
+				Expect(output).To(ContainSubstring("recent **question**"))
+				Expect(output).NotTo(ContainSubstring("old **question**"))
+				Expect(output).NotTo(ContainSubstring("undated **question**"))
+
+				output = runCommand("--search", "question", "--until", "2w", "--include-undated")
+				Expect(output).To(ContainSubstring("undated **question**"))
+				Expect(output).NotTo(ContainSubstring("old **question**"))
+
+				output = runCommand("--export", "md", "--until", "1w")
+				Expect(output).To(ContainSubstring("You are a helpful assistant."))
+				Expect(output).To(ContainSubstring("old question"))
+				Expect(output).NotTo(ContainSubstring("recent question"))
+
+				command := exec.Command(binaryPath, "--search", "question", "--since", "last week")
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitUsage))
+				Expect(string(session.Err.Contents())).To(ContainSubstring(`invalid --since or --until: invalid date "last week", use a date such as 2024-06-01`))
+
+				command = exec.Command(binaryPath, "--prune-history", "--since", "1w")
+				session, err = gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitUsage))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("--until or --older-than instead of --since"))
+
+				output = runCommand("--prune-history", "--until", "1w", "--dry-run")
+				Expect(output).To(ContainSubstring("Would remove 1 messages from thread default"))
+			})
+
+			it("shows the statistics of the history with the --stats flag", func() {
+				historyDir := path.Join(filePath, "history")
+				Expect(os.MkdirAll(historyDir, 0755)).To(Succeed())
+
+				content := `[{"role":"system","content":"You are a helpful assistant."},` +
+					`{"role":"user","content":"what is Go?","timestamp":"2024-06-01T09:00:00Z","tokens":4,"token_encoding":"` + utils.TokenEncoding + `"},` +
+					`{"role":"assistant","content":"A programming language.","timestamp":"2024-06-01T09:01:00Z","tokens":5,"token_encoding":"` + utils.TokenEncoding + `"}]`
+				Expect(os.WriteFile(path.Join(historyDir, "default.json"), []byte(content), 0644)).To(Succeed())
+				Expect(os.WriteFile(path.Join(historyDir, "other.json"), []byte(`[{"role":"user","content":"hi"}]`), 0644)).To(Succeed())
+
+				output := runCommand("--stats")
+				Expect(output).To(ContainSubstring("Statistics of thread default:"))
+				Expect(output).To(ContainSubstring("Messages:          3 (assistant 1, system 1, user 1)"))
+				Expect(output).To(ContainSubstring("Completion tokens: 5"))
+				Expect(output).To(ContainSubstring("Estimated cost:"))
+				Expect(output).To(ContainSubstring("Longest message:   default [0] system, 28 characters"))
+
+				output = runCommand("--stats", "--all-threads", "--since", "2024-06-01T09:01")
+				Expect(output).To(ContainSubstring("Statistics of all threads:"))
+				Expect(output).To(ContainSubstring("Threads:           1"))
+
+				output = runCommand("--stats", "--all-threads", "--json")
+				var stats history.Stats
+				Expect(json.Unmarshal([]byte(output), &stats)).To(Succeed())
+				Expect(stats.Threads).To(Equal(2))
+				Expect(stats.Messages).To(Equal(map[string]int{"system": 1, "user": 2, "assistant": 1}))
+				Expect(stats.CompletionTokens).To(Equal(5))
+			})
+
+			it("replays a thread against another model with the --replay flag and resumes it", func() {
+				historyDir := path.Join(filePath, "history")
+				historyFile := path.Join(historyDir, "old.json")
+				Expect(os.MkdirAll(historyDir, 0755)).To(Succeed())
+
+				content := `[{"role":"system","content":"You are a helpful assistant."},` +
+					`{"role":"user","content":"which bars are in Red Hook?"},{"role":"assistant","content":"the original answer"},` +
+					`{"role":"user","content":"and in Park Slope?"}]`
+				Expect(os.WriteFile(historyFile, []byte(content), 0644)).To(Succeed())
+
+				output := runCommand("--replay", "old", "--model", "gpt-4o")
+				Expect(output).To(ContainSubstring("# Replay of thread old against gpt-4o"))
+				Expect(output).To(ContainSubstring("## Question 2\n\nand in Park Slope?\n\n### Original answer\n\n_No answer._"))
+				Expect(output).To(ContainSubstring("### gpt-4o\n\nAs an AI language model, I don't have personal opinions about bars"))
+
+				data, err := os.ReadFile(historyFile)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(data)).To(ContainSubstring("the original answer"))
+				Expect(string(data)).NotTo(ContainSubstring("Brooklyn"))
+
+				// a replay that stopped after the first question is resumed from the file
+				replayFile := path.Join(filePath, "replay.json")
+				Expect(os.WriteFile(replayFile, []byte(`{"thread":"old","model":"gpt-4o","turns":[`+
+					`{"index":1,"question":"which bars are in Red Hook?","original":"the original answer","answer":"the resumed answer"}]}`), 0644)).To(Succeed())
+
+				output = runCommand("--replay", "old", "--model", "gpt-4o", "--output", replayFile)
+				Expect(output).To(ContainSubstring("Successfully replayed thread old against gpt-4o to " + replayFile))
+
+				var result client.ReplayResult
+				data, err = os.ReadFile(replayFile)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(json.Unmarshal(data, &result)).To(Succeed())
+				Expect(result.Done).To(BeTrue())
+				Expect(result.Turns).To(HaveLen(2))
+				Expect(result.Turns[0].Answer).To(Equal("the resumed answer"))
+				Expect(result.Turns[1].Answer).To(ContainSubstring("Red Hook, Brooklyn"))
+
+				command := exec.Command(binaryPath, "--replay", "old", "--model", "o1", "--output", replayFile)
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitFailure))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("can't resume the replay of thread old against gpt-4o"))
+			})
+
+			it("asks the prompts of a file with chatgpt batch, writes their results as JSON lines and resumes them", func() {
+				promptsFile := path.Join(filePath, "prompts.jsonl")
+				Expect(os.WriteFile(promptsFile, []byte(`{"prompt": "which bars are in Red Hook?", "model": "gpt-4o"}`+"\n\n"+
+					`{"prompt": "and in Park Slope?", "model": "gpt-4o-mini", "temperature": 0.2}`+"\n"), 0644)).To(Succeed())
+
+				output := runCommand("batch", promptsFile, "--concurrency", "2")
+				lines := strings.Split(strings.TrimSpace(output), "\n")
+				Expect(lines).To(HaveLen(2))
+				for _, line := range lines {
+					var result batch.Result
+					Expect(json.Unmarshal([]byte(line), &result)).To(Succeed())
+					Expect(result.Answer).NotTo(BeEmpty())
+					Expect(result.Error).To(BeEmpty())
+				}
+
+				// a batch that stopped after the first prompt, in the middle of the second result, is resumed
+				resultsFile := path.Join(filePath, "results.jsonl")
+				Expect(os.WriteFile(resultsFile, []byte(`{"prompt":"which bars are in Red Hook?","model":"gpt-4o","answer":"the resumed answer","usage":{}}`+"\n"+
+					`{"prompt":"and in Park`), 0644)).To(Succeed())
+
+				output = runCommand("batch", promptsFile, "--output", resultsFile, "--resume")
+				Expect(output).To(ContainSubstring("Successfully answered 1 prompts of " + promptsFile + " to " + resultsFile))
+
+				data, err := os.ReadFile(resultsFile)
+				Expect(err).NotTo(HaveOccurred())
+				lines = strings.Split(strings.TrimSpace(string(data)), "\n")
+				Expect(lines).To(HaveLen(2))
+				Expect(lines[0]).To(ContainSubstring("the resumed answer"))
+
+				var result batch.Result
+				Expect(json.Unmarshal([]byte(lines[1]), &result)).To(Succeed())
+				Expect(result.Prompt).To(Equal("and in Park Slope?"))
+				Expect(result.Model).To(Equal("gpt-4o-mini"))
+
+				command := exec.Command(binaryPath, "batch", promptsFile, "--output", resultsFile)
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitUsage))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("already exists, add --force to overwrite it"))
+
+				command = exec.Command(binaryPath, "batch", promptsFile, "--resume")
+				session, err = gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitUsage))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("--resume resumes the batch of the --output file"))
+			})
+
+			it("asks the query of the --watch file again whenever it changes, until Ctrl+C", func() {
+				promptFile := path.Join(filePath, "prompt.md")
+				Expect(os.WriteFile(promptFile, []byte("which bars are in Red Hook?"), 0644)).To(Succeed())
+
+				command := exec.Command(binaryPath, "--watch", promptFile, "--query")
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session.Err, 5*time.Second).Should(gbytes.Say("Waiting for " + promptFile + " to change"))
+				Expect(string(session.Out.Contents())).To(HavePrefix("==> " + promptFile + ", run 1, "))
+				Expect(string(session.Out.Contents())).To(ContainSubstring("Red Hook, Brooklyn"))
+
+				Expect(os.WriteFile(promptFile, []byte("and in Park Slope?"), 0644)).To(Succeed())
+				Eventually(session.Err, 5*time.Second).Should(gbytes.Say("Waiting for " + promptFile + " to change"))
+				Expect(string(session.Out.Contents())).To(ContainSubstring("==> " + promptFile + ", run 2, "))
+
+				session.Interrupt()
+				Eventually(session).Should(gexec.Exit(exitcode.Interrupted))
+
+				// each run is asked on its own, in a thread of its own
+				threads, err := filepath.Glob(path.Join(filePath, "history", client.WatchThreadPrefix+"*.json"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(threads).To(HaveLen(1))
+
+				data, err := os.ReadFile(threads[0])
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(data)).To(ContainSubstring("and in Park Slope?"))
+				Expect(string(data)).NotTo(ContainSubstring("which bars are in Red Hook?"))
+
+				command = exec.Command(binaryPath, "--watch", promptFile, "--interactive")
+				session, err = gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitUsage))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("--watch asks the query again when the file changes"))
+
+				command = exec.Command(binaryPath, "--accumulate", "hello")
+				session, err = gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitUsage))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("--accumulate only applies to --watch"))
+			})
+
+			it("anonymizes the export with the --anonymize flag", func() {
+				historyDir := path.Join(filePath, "history")
+				Expect(os.MkdirAll(historyDir, 0755)).To(Succeed())
+				Expect(os.WriteFile(path.Join(historyDir, "default.json"), []byte(`[{"role":"user","content":"Bob Smith gets `+
+					`\"permission denied\" for /home/bob/app.log on 10.1.2.3, mail bob@example.com"},`+
+					`{"role":"assistant","content":"Ask bob@example.com to check the owner of /home/bob/app.log."}]`), 0644)).To(Succeed())
+
+				mapFile := path.Join(filePath, "map.json")
+				output := runCommand("--export", "md", "--anonymize", "--anonymize-value", "Bob Smith", "--anonymize-map", mapFile)
+				Expect(output).To(ContainSubstring(`<literal-1> gets "permission denied" for <path-1> on <ip-1>, mail <email-1>`))
+				Expect(output).To(ContainSubstring("Ask <email-1> to check the owner of <path-1>."))
+				Expect(output).NotTo(ContainSubstring("bob"))
+
+				info, err := os.Stat(mapFile)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+
+				var mapping []history.AnonymizedValue
+				data, err := os.ReadFile(mapFile)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(json.Unmarshal(data, &mapping)).To(Succeed())
+				Expect(mapping).To(ContainElement(history.AnonymizedValue{Placeholder: "<email-1>", Value: "bob@example.com"}))
+				Expect(mapping).To(HaveLen(4))
+
+				command := exec.Command(binaryPath, "--export", "md", "--anonymize-map", mapFile)
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitUsage))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("only apply to exports with --anonymize"))
+			})
+
+			it("shows the last exchanges with the --show-last flag", func() {
+				historyDir := path.Join(filePath, "history")
+				Expect(os.MkdirAll(historyDir, 0755)).To(Succeed())
+				Expect(os.WriteFile(path.Join(historyDir, "default.json"), []byte(`[{"role":"system","content":"You are a helpful assistant."},`+
+					`{"role":"user","content":"first question"},{"role":"assistant","content":"first answer"},`+
+					`{"role":"user","content":"second question","timestamp":"2024-06-01T09:00:00Z"},{"role":"assistant","content":"second answer","timestamp":"2024-06-01T09:00:05Z"}]`), 0644)).To(Succeed())
+
+				output := runCommand("--show-last", "1")
+				Expect(output).To(ContainSubstring("second answer"))
+				Expect(output).NotTo(ContainSubstring("first answer"))
+
+				output = runCommand("--show-last", "2", "--json")
+				var exchanges []client.Exchange
+				Expect(json.Unmarshal([]byte(output), &exchanges)).To(Succeed())
+				Expect(exchanges).To(HaveLen(2))
+				Expect(exchanges[0].Question).To(Equal("first question"))
+				Expect(exchanges[1].Answered).To(Equal(time.Date(2024, 6, 1, 9, 0, 5, 0, time.UTC)))
+
+				Expect(os.WriteFile(path.Join(historyDir, "default.json"), []byte(`[{"role":"user","content":"unanswered"}]`), 0644)).To(Succeed())
+
+				command := exec.Command(binaryPath, "--show-last", "1")
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitFailure))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("thread default has no answered questions"))
+			})
+
+			it("composes the query in the editor with --editor and --edit-last", func() {
+				historyDir := path.Join(filePath, "history")
+				Expect(os.MkdirAll(historyDir, 0755)).To(Succeed())
+				Expect(os.WriteFile(path.Join(historyDir, "default.json"), []byte(`[{"role":"user","content":"last question"},`+
+					`{"role":"assistant","content":"last answer"}]`), 0644)).To(Succeed())
+
+				editorScript := path.Join(filePath, "editor.sh")
+				Expect(os.Setenv("EDITOR", editorScript)).To(Succeed())
+				defer os.Unsetenv("EDITOR")
+				Expect(os.Unsetenv("VISUAL")).To(Succeed())
+
+				Expect(os.WriteFile(editorScript, []byte("#!/bin/sh\nprintf 'and more\\n' >> \"$1\"\n"), 0755)).To(Succeed())
+
+				output := runCommand("-e", "--dry-run", "--query", "the query")
+				Expect(output).To(ContainSubstring(`"content": "the query\nand more"`))
+
+				output = runCommand("--edit-last", "--dry-run", "--query")
+				Expect(output).To(ContainSubstring(`"content": "last question\nand more"`))
+
+				for script, message := range map[string]string{
+					"#!/bin/sh\nprintf '\\n\\n' > \"$1\"\n":    "the prompt is empty, nothing was sent",
+					"#!/bin/sh\necho saved > \"$1\"\nexit 1\n": "exited with status 1, nothing was sent",
+				} {
+					Expect(os.WriteFile(editorScript, []byte(script), 0755)).To(Succeed())
+
+					command := exec.Command(binaryPath, "--editor", "--query")
+					session, err := gexec.Start(command, io.Discard, io.Discard)
+					Expect(err).NotTo(HaveOccurred())
+
+					Eventually(session).Should(gexec.Exit(exitFailure))
+					Expect(string(session.Err.Contents())).To(ContainSubstring(message))
+				}
+			})
+
+			it("completes the threads and the shells without sending anything", func() {
+				historyDir := path.Join(filePath, "history")
+				Expect(os.MkdirAll(historyDir, 0755)).To(Succeed())
+				Expect(os.WriteFile(path.Join(historyDir, "work.json"), []byte(`[{"role":"user","content":"question"}]`), 0644)).To(Succeed())
+
+				output := runCommand("__complete", "--delete-thread", "")
+				Expect(output).To(ContainSubstring("work\n"))
+
+				output = runCommand("__complete", "completion", "")
+				Expect(output).To(ContainSubstring("bash\nzsh\nfish\npowershell\n"))
+
+				output = runCommand("completion", "zsh")
+				Expect(output).To(ContainSubstring("#compdef chatgpt"))
+			})
+
+			it("tells when the last answer has no code block to copy with the --copy-code flag", func() {
+				historyDir := path.Join(filePath, "history")
+				Expect(os.MkdirAll(historyDir, 0755)).To(Succeed())
+				Expect(os.WriteFile(path.Join(historyDir, "default.json"), []byte(`[{"role":"user","content":"question"},`+
+					`{"role":"assistant","content":"an answer without code"}]`), 0644)).To(Succeed())
+
+				for args, message := range map[string]string{
+					"--copy-code":   "the answer has no code block, add --copy to copy the whole answer",
+					"--copy-code=2": "the answer has no code block, add --copy to copy the whole answer",
+				} {
+					command := exec.Command(binaryPath, args)
+					session, err := gexec.Start(command, io.Discard, io.Discard)
+					Expect(err).NotTo(HaveOccurred())
+
+					Eventually(session).Should(gexec.Exit(exitFailure))
+					Expect(string(session.Err.Contents())).To(ContainSubstring(message))
+				}
+
+				Expect(os.WriteFile(path.Join(historyDir, "default.json"), []byte(`[{"role":"user","content":"question"},`+
+					"{\"role\":\"assistant\",\"content\":\"```go\\nfmt.Println()\\n```\"}]"), 0644)).To(Succeed())
+
+				command := exec.Command(binaryPath, "--copy-code=2")
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitFailure))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("the answer has 1 code block(s), there is no code block 2"))
+			})
+
+			it("applies the session policy to queries unless --continue is given", func() {
+				historyDir := path.Join(filePath, "history")
+				Expect(os.MkdirAll(historyDir, 0755)).To(Succeed())
+				Expect(os.WriteFile(path.Join(historyDir, "default.json"), []byte(`[{"role":"system","content":"You are a helpful assistant."},`+
+					`{"role":"user","content":"earlier question"},{"role":"assistant","content":"earlier answer"}]`), 0644)).To(Succeed())
+				Expect(os.WriteFile(path.Join(filePath, "config.yaml"), []byte("session_policy: new\n"), 0644)).To(Succeed())
+
+				output := runCommand("--dry-run", "some-query")
+				Expect(output).To(ContainSubstring("some-query"))
+				Expect(output).NotTo(ContainSubstring("earlier answer"))
+
+				output = runCommand("--dry-run", "--continue", "some-query")
+				Expect(output).To(ContainSubstring("earlier answer"))
+
+				Expect(os.WriteFile(path.Join(filePath, "config.yaml"), []byte("session_policy: recent\nsession_window: 1h\n"), 0644)).To(Succeed())
+
+				output = runCommand("--dry-run", "some-query")
+				Expect(output).To(ContainSubstring("earlier answer"))
+
+				old := time.Now().Add(-2 * time.Hour)
+				Expect(os.Chtimes(path.Join(historyDir, "default.json"), old, old)).To(Succeed())
+
+				output = runCommand("--dry-run", "some-query")
+				Expect(output).NotTo(ContainSubstring("earlier answer"))
+
+				command := exec.Command(binaryPath, "--new", "--continue", "some-query")
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitUsage))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("the --new-thread and --continue flags cannot be used together"))
+			})
+
+			it("starts a new thread with --new whatever the session policy says, and names it with --thread", func() {
+				historyDir := path.Join(filePath, "history")
+				Expect(os.MkdirAll(historyDir, 0755)).To(Succeed())
+				Expect(os.WriteFile(path.Join(historyDir, "default.json"), []byte(`[{"role":"system","content":"You are a helpful assistant."},`+
+					`{"role":"user","content":"earlier question"},{"role":"assistant","content":"earlier answer"}]`), 0644)).To(Succeed())
+				Expect(os.WriteFile(path.Join(filePath, "config.yaml"), []byte("session_policy: continue\n"), 0644)).To(Succeed())
+
+				output := runCommand("--dry-run", "--new", "some-query")
+				Expect(output).To(ContainSubstring("some-query"))
+				Expect(output).NotTo(ContainSubstring("earlier answer"))
+
+				command := exec.Command(binaryPath, "--new", "--query", "some-query")
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+				Eventually(session).Should(gexec.Exit(exitSuccess))
+				Expect(string(session.Err.Contents())).To(MatchRegexp(`Started thread cmd_\S+, the threads before are left as they were`))
+
+				before, err := os.ReadFile(path.Join(historyDir, "default.json"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(before)).To(ContainSubstring("earlier answer"))
+				Expect(string(before)).NotTo(ContainSubstring("some-query"))
+
+				command = exec.Command(binaryPath, "--new", "--thread", "fresh", "--query", "some-query")
+				session, err = gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+				Eventually(session).Should(gexec.Exit(exitSuccess))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("Started thread fresh"))
+				Expect(path.Join(historyDir, "fresh.json")).To(BeAnExistingFile())
+
+				command = exec.Command(binaryPath, "--new", "--thread", "fresh", "--query", "some-query")
+				session, err = gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+				Eventually(session).Should(gexec.Exit(exitFailure))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("thread fresh already exists"))
+			})
+
+			it("refuses the headers that would override the request before sending anything", func() {
+				for _, c := range []struct {
+					config string
+					args   []string
+					err    string
+				}{
+					{"headers:\n  content-type: text/plain\n", []string{"some-query"}, "the Content-Type header can't be overridden"},
+					{"", []string{"--header", "Authorization: Bearer other", "some-query"}, "the Authorization header is only overridden with allow_auth_override"},
+					{"", []string{"--header", "X-Team=search", "some-query"}, `invalid header "X-Team=search", expected Name: value`},
+				} {
+					Expect(os.WriteFile(path.Join(filePath, "config.yaml"), []byte(c.config), 0644)).To(Succeed())
+
+					command := exec.Command(binaryPath, append([]string{"--dry-run"}, c.args...)...)
+					session, err := gexec.Start(command, io.Discard, io.Discard)
+					Expect(err).NotTo(HaveOccurred())
+
+					Eventually(session).Should(gexec.Exit(exitFailure))
+					Expect(string(session.Err.Contents())).To(ContainSubstring(c.err))
+				}
+
+				Expect(os.WriteFile(path.Join(filePath, "config.yaml"), []byte("allow_auth_override: true\n"), 0644)).To(Succeed())
+				Expect(runCommand("--dry-run", "--header", "Authorization: Bearer other", "some-query")).To(ContainSubstring("some-query"))
+			})
+
+			it("fails on an invalid CA bundle before sending anything", func() {
+				bundle := path.Join(filePath, "ca.pem")
+				Expect(os.WriteFile(bundle, []byte("not a certificate"), 0644)).To(Succeed())
+				Expect(os.WriteFile(path.Join(filePath, "config.yaml"), []byte("ca_bundle: "+bundle+"\n"), 0644)).To(Succeed())
+
+				command := exec.Command(binaryPath, "--dry-run", "some-query")
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitFailure))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("invalid CA bundle " + bundle))
+			})
+
+			it("archives and unarchives threads", func() {
+				historyDir := path.Join(filePath, "history")
+				Expect(os.MkdirAll(historyDir, 0755)).To(Succeed())
+				Expect(os.WriteFile(path.Join(historyDir, "finished.json"), []byte(`[{"role":"system","content":"You are a helpful assistant."},`+
+					`{"role":"user","content":"pgbouncer timeouts"},{"role":"assistant","content":"raise query_timeout"}]`), 0644)).To(Succeed())
+
+				output := runCommand("--archive-thread", "--thread", "finished")
+				Expect(output).To(ContainSubstring("Successfully archived thread finished"))
+
+				output = runCommand("--list-threads")
+				Expect(output).NotTo(ContainSubstring("finished"))
+				output = runCommand("--list-threads", "--archived")
+				Expect(output).To(ContainSubstring("finished (archived)"))
+
+				output = runCommand("--search", "pgbouncer")
+				Expect(output).To(ContainSubstring("No matches found."))
+				output = runCommand("--search", "pgbouncer", "--archived")
+				Expect(output).To(ContainSubstring("finished"))
+
+				output = runCommand("--export", "json", "--thread", "finished")
+				Expect(output).To(ContainSubstring(`"archived": true`))
+
+				Expect(os.WriteFile(path.Join(filePath, "config.yaml"), []byte("auto_unarchive: false\n"), 0644)).To(Succeed())
+
+				command := exec.Command(binaryPath, "--thread", "finished", "some-query")
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitFailure))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("thread finished is archived, unarchive it with --unarchive-thread to continue it"))
+
+				output = runCommand("--unarchive-thread", "--thread", "finished")
+				Expect(output).To(ContainSubstring("Successfully unarchived thread finished"))
+				output = runCommand("--list-threads")
+				Expect(output).To(ContainSubstring("finished"))
+				Expect(output).NotTo(ContainSubstring("(archived)"))
+
+				command = exec.Command(binaryPath, "--archive-thread", "--thread", "missing")
+				session, err = gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitFailure))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("thread missing does not exist"))
+			})
+
+			it("deletes messages with the --delete-messages flag", func() {
+				historyDir := path.Join(filePath, "history")
+				historyFile := path.Join(historyDir, "other.json")
+				Expect(os.MkdirAll(historyDir, 0755)).To(Succeed())
+				Expect(os.WriteFile(historyFile, []byte(`[{"role":"system","content":"You are a helpful assistant."},`+
+					`{"role":"user","content":"my password is hunter2"},{"role":"assistant","content":"Don't share it."}]`), 0644)).To(Succeed())
+
+				command := exec.Command(binaryPath, "--delete-messages", "1", "--thread", "other")
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitSuccess))
+				Expect(string(session.Out.Contents())).To(ContainSubstring("Successfully deleted 1 messages from thread other"))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("Warning: the answer to message 1 was kept"))
+
+				data, err := os.ReadFile(historyFile)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(data)).NotTo(ContainSubstring("hunter2"))
+				Expect(string(data)).To(ContainSubstring("Don't share it."))
+
+				command = exec.Command(binaryPath, "--delete-messages", "0", "--thread", "other")
+				session, err = gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitFailure))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("message 0 is a system prompt and can't be deleted"))
+			})
+
+			it("lists the saved summaries and refuses to summarize an empty thread", func() {
+				historyDir := path.Join(filePath, "history")
+				Expect(os.MkdirAll(historyDir, 0755)).To(Succeed())
+				Expect(os.WriteFile(path.Join(historyDir, "other.json"), []byte(`[{"role":"user","content":"hello"}]`), 0644)).To(Succeed())
+				Expect(os.WriteFile(path.Join(historyDir, ".index.json"), []byte(`{"other":{"created":"2024-05-01T12:30:00Z",`+
+					`"messages":1,"summary":{"topics":["pgbouncer timeouts","query_timeout"]}}}`), 0644)).To(Succeed())
+
+				output := runCommand("--list-threads")
+				Expect(output).To(ContainSubstring("topics: pgbouncer timeouts, query_timeout"))
+
+				command := exec.Command(binaryPath, "--summarize", "--thread", "empty")
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitFailure))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("thread empty has nothing to summarize"))
+			})
+
+			it("pins and unpins messages with the --pin and --unpin flags", func() {
+				historyDir := path.Join(filePath, "history")
+				historyFile := path.Join(historyDir, "default.json")
+				Expect(os.MkdirAll(historyDir, 0755)).To(Succeed())
+				Expect(os.WriteFile(historyFile, []byte(`[{"role":"system","content":"You are a helpful assistant."},`+
+					`{"role":"user","content":"we are on Go 1.21"},{"role":"assistant","content":"Noted."}]`), 0644)).To(Succeed())
+
+				output := runCommand("--pin", "1-2")
+				Expect(output).To(ContainSubstring("Successfully pinned 2 messages in thread default"))
+
+				output = runCommand("--show-history")
+				Expect(output).To(ContainSubstring("👤 📌:\nwe are on Go 1.21"))
+
+				output = runCommand("--unpin", "2")
+				Expect(output).To(ContainSubstring("Successfully unpinned 1 messages in thread default"))
+
+				data, err := os.ReadFile(historyFile)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(strings.Count(string(data), `"pinned":true`)).To(Equal(1))
+
+				command := exec.Command(binaryPath, "--pin", "0")
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitFailure))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("message 0 is a system prompt"))
+			})
+
+			it("merges a thread into the current one with the --merge-thread flag", func() {
+				runCommand("--query", "What is the capital of France?")
+				runCommand("--thread", "fork", "--query", "What is the capital of Spain?")
+
+				output := runCommand("--merge-thread", "fork", "--merge-strategy", "interleave")
+				Expect(output).To(ContainSubstring("Successfully merged thread fork into default, added 2 messages and dropped 1 duplicates"))
+
+				output = runCommand("--list-threads")
+				Expect(output).NotTo(ContainSubstring("fork"))
+
+				content, err := os.ReadFile(path.Join(filePath, "history", "default.json"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(content)).To(ContainSubstring("What is the capital of Spain?"))
+
+				command := exec.Command(binaryPath, "--merge-thread", "fork", "--merge-strategy", "zip")
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitFailure))
+				Expect(string(session.Err.Contents())).To(ContainSubstring(`unknown merge strategy "zip"`))
+			})
+
+			it("tags threads and lists, searches and exports them by tag", func() {
+				runCommand("--query", "What is the capital of France?")
+				runCommand("--thread", "other", "--query", "What is the capital of Spain?")
+
+				output := runCommand("--add-tag", "Work,geography")
+				Expect(output).To(ContainSubstring("Successfully updated the tags of thread default: geography, work"))
+
+				output = runCommand("--remove-tag", "geography", "--add-tag", "travel")
+				Expect(output).To(ContainSubstring("Successfully updated the tags of thread default: travel, work"))
+
+				output = runCommand("--list-threads", "--tag", "WORK")
+				Expect(output).To(ContainSubstring("default (current)"))
+				Expect(output).To(ContainSubstring("[travel, work]"))
+				Expect(output).NotTo(ContainSubstring("other"))
+
+				output = runCommand("--search", "capital", "--tag", "work")
+				Expect(output).To(ContainSubstring("France"))
+				Expect(output).NotTo(ContainSubstring("Spain"))
+
+				exportFile := path.Join(filePath, "default.json")
+				runCommand("--export", "json", "--output", exportFile)
+				runCommand("--import", exportFile, "--thread", "copy")
+
+				output = runCommand("--list-threads", "--tag", "travel")
+				Expect(output).To(ContainSubstring("copy"))
+
+				command := exec.Command(binaryPath, "--add-tag", "two words")
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitFailure))
+				Expect(string(session.Err.Contents())).To(ContainSubstring(`invalid tag "two words"`))
+			})
+
+			it("titles new threads and replaces the title with the --set-title flag", func() {
+				runCommand("--query", "Which bars are in Red Hook?")
+
+				output := runCommand("--list-threads")
+				Expect(output).To(ContainSubstring(`"As an AI language model, I don't have"`))
+
+				output = runCommand("--set-title", "Bars in  Red Hook")
+				Expect(output).To(ContainSubstring("Successfully set the title of thread"))
+
+				output = runCommand("--list-threads")
+				Expect(output).To(ContainSubstring(`"Bars in Red Hook"`))
+
+				command := exec.Command(binaryPath, "--set-title", "x", "--thread", "does-not-exist")
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitFailure))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("thread does-not-exist does not exist"))
+			})
+
+			it("redacts secrets before they are stored with redact_secrets", func() {
+				patternsFile := path.Join(filePath, "patterns.txt")
+				Expect(os.WriteFile(patternsFile, []byte("# internal ticket numbers\n\\bACME-[0-9]{6}\\b\n"), 0644)).To(Succeed())
+
+				command := exec.Command(binaryPath, "--redact-secrets", "--redact-patterns-file", patternsFile, "--query", "password=hunter2 for ACME-123456")
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+				Eventually(session).Should(gexec.Exit(exitSuccess))
+				Expect(string(session.Err.Contents())).To(MatchRegexp(`Redacted 2 secrets before storing the history: \[REDACTED password [0-9a-f]{8}\], \[REDACTED custom [0-9a-f]{8}\]`))
+
+				content, err := os.ReadFile(path.Join(filePath, "history", "default.json"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(content)).NotTo(ContainSubstring("hunter2"))
+				Expect(string(content)).NotTo(ContainSubstring("ACME-123456"))
+
+				command = exec.Command(binaryPath, "--redact-secrets", "--redact-patterns-file", path.Join(filePath, "missing.txt"), "--query", "hello")
+				session, err = gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+				Eventually(session).Should(gexec.Exit(exitFailure))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("failed to read the redaction patterns"))
+			})
+
+			it("syncs the history with an S3 bucket and reports the conflicts", func() {
+				var (
+					mu        sync.Mutex
+					objects   = map[string][]byte{}
+					conflicts bool
+				)
+				bucket := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					mu.Lock()
+					defer mu.Unlock()
+
+					switch {
+					case r.Method == http.MethodPut && conflicts:
+						w.WriteHeader(http.StatusPreconditionFailed)
+					case r.Method == http.MethodPut:
+						objects[r.URL.Path], _ = io.ReadAll(r.Body)
+						w.Header().Set("ETag", fmt.Sprintf(`"%d"`, len(objects[r.URL.Path])))
+					case objects[r.URL.Path] == nil:
+						w.WriteHeader(http.StatusNotFound)
+					default:
+						w.Header().Set("ETag", fmt.Sprintf(`"%d"`, len(objects[r.URL.Path])))
+						_, _ = w.Write(objects[r.URL.Path])
+					}
+				}))
+				defer bucket.Close()
+
+				for key, value := range map[string]string{
+					"AWS_ACCESS_KEY_ID":     "AKIDEXAMPLE",
+					"AWS_SECRET_ACCESS_KEY": "secret",
+					"AWS_ENDPOINT_URL":      bucket.URL,
+					strings.Replace(apiKeyEnvVar, "API_KEY", "HISTORY_BACKEND", 1): "s3://chat-bucket/team",
+				} {
+					Expect(os.Setenv(key, value)).To(Succeed())
+					defer os.Unsetenv(key)
+				}
+
+				runCommand("--query", "What is the capital of France?")
+
+				mu.Lock()
+				Expect(string(objects["/chat-bucket/team/default.json"])).To(ContainSubstring("What is the capital of France?"))
+				conflicts = true
+				mu.Unlock()
+
+				command := exec.Command(binaryPath, "--query", "And of Spain?")
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+				Eventually(session).Should(gexec.Exit(exitSuccess))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("Warning: failed to write the history: the thread was changed on the remote since it was last read"))
+			})
+
+			it("encrypts the history with the --encrypt-history flag", func() {
+				historyDir := path.Join(filePath, "history")
+				historyFile := path.Join(historyDir, "default.json")
+				Expect(os.MkdirAll(historyDir, 0755)).To(Succeed())
+				Expect(os.WriteFile(historyFile, []byte(`[{"role":"user","content":"hello"}]`), 0644)).To(Succeed())
+
+				command := exec.Command(binaryPath, "--encrypt-history")
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitFailure))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("no passphrase to encrypt the history with"))
+
+				keyFile := path.Join(filePath, "history.key")
+				Expect(os.WriteFile(keyFile, []byte("correct horse\n"), 0600)).To(Succeed())
+
+				keyFileEnvKey := strings.Replace(apiKeyEnvVar, "API_KEY", "HISTORY_KEY_FILE", 1)
+				Expect(os.Setenv(keyFileEnvKey, keyFile)).To(Succeed())
+				defer os.Unsetenv(keyFileEnvKey)
+
+				output := runCommand("--encrypt-history")
+				Expect(output).To(ContainSubstring("Successfully encrypted 1 threads"))
+
+				content, err := os.ReadFile(historyFile)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(content)).NotTo(ContainSubstring("hello"))
+
+				output = runCommand("--show-history")
+				Expect(output).To(ContainSubstring("hello"))
+			})
+			it("moves the files of ~/.chatgpt-cli to the XDG directories with the --move-to-xdg flag", func() {
+				Expect(os.WriteFile(path.Join(filePath, "config.yaml"), []byte("model: gpt-4o\n"), 0644)).To(Succeed())
+				runCommand("--query", "some-query")
+
+				output := runCommand("--move-to-xdg")
+				Expect(output).To(ContainSubstring("Successfully moved the config to " + path.Join(homeDir, ".config", "chatgpt-cli")))
+
+				Expect(path.Join(homeDir, ".config", "chatgpt-cli", "config.yaml")).To(BeARegularFile())
+				Expect(path.Join(homeDir, ".local", "state", "chatgpt-cli", "history", "default.json")).To(BeARegularFile())
+				Expect(path.Join(filePath, utils.MovedNote)).To(BeARegularFile())
+				Expect(path.Join(filePath, "history")).NotTo(BeAnExistingFile())
+
+				output = runCommand("--show-history")
+				Expect(output).To(ContainSubstring("some-query"))
+
+				output = runCommand("--config")
+				Expect(output).To(ContainSubstring("model: gpt-4o"))
+			})
+
+			it("keeps the history in the directory given with history_dir", func() {
+				command := exec.Command(binaryPath, "--history-dir", "workspace/history", "--query", "some-query")
+				command.Dir = filePath
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+				Eventually(session).Should(gexec.Exit(exitSuccess))
+
+				Expect(path.Join(filePath, "workspace", "history", "default.json")).To(BeARegularFile())
+				Expect(path.Join(filePath, "history", "default.json")).NotTo(BeAnExistingFile())
+
+				historyDirEnvKey := strings.Replace(apiKeyEnvVar, "API_KEY", "HISTORY_DIR", 1)
+				Expect(os.Setenv(historyDirEnvKey, path.Join(filePath, "workspace", "history"))).To(Succeed())
+				defer os.Unsetenv(historyDirEnvKey)
+
+				output := runCommand("--show-history")
+				Expect(output).To(ContainSubstring("some-query"))
+			})
+
+			it("backs up and restores the history with the --backup-history and --restore-history flags", func() {
+				historyDir := path.Join(filePath, "history")
+				Expect(os.MkdirAll(historyDir, 0755)).To(Succeed())
+				Expect(os.WriteFile(path.Join(historyDir, "default.json"), []byte(`[{"role":"user","content":"hello"}]`), 0644)).To(Succeed())
+				Expect(os.WriteFile(path.Join(historyDir, "other.json"), []byte(`[{"role":"user","content":"bye"}]`), 0644)).To(Succeed())
+
+				// without --output the backup is named after the time, in the current directory
+				command := exec.Command(binaryPath, "--backup-history")
+				command.Dir = filePath
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+				Eventually(session).Should(gexec.Exit(exitSuccess))
+				Expect(string(session.Out.Contents())).To(ContainSubstring("Successfully backed up 2 threads to chatgpt-cli-history-"))
+
+				backups, err := filepath.Glob(path.Join(filePath, "chatgpt-cli-history-*.tar.gz"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(backups).To(HaveLen(1))
+
+				Expect(os.RemoveAll(historyDir)).To(Succeed())
+				Expect(os.MkdirAll(historyDir, 0755)).To(Succeed())
+
+				output := runCommand("--restore-history", backups[0], "--restore-thread", "other")
+				Expect(output).To(ContainSubstring("Successfully restored 1 threads"))
+				Expect(path.Join(historyDir, "default.json")).NotTo(BeAnExistingFile())
+
+				command = exec.Command(binaryPath, "--restore-history", backups[0])
+				session, err = gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+				Eventually(session).Should(gexec.Exit(exitFailure))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("thread other already exists, add --force to replace it"))
+
+				output = runCommand("--restore-history", backups[0], "--force")
+				Expect(output).To(ContainSubstring("Successfully restored 2 threads"))
+
+				output = runCommand("--show-history")
+				Expect(output).To(ContainSubstring("hello"))
+			})
+
+			it("keeps track of history", func() {
+				// History should not exist yet
+				historyDir := path.Join(filePath, "history")
+				historyFile := path.Join(historyDir, "default.json")
+				Expect(historyFile).NotTo(BeAnExistingFile())
+
+				// Perform a query and check response
+				response := `I don't have personal opinions about bars, but here are some popular bars in Red Hook, Brooklyn:`
+				output := runCommand("--query", "some-query")
+				Expect(output).To(ContainSubstring(response))
+
+				// Check if history file was created with expected content
+				Expect(historyDir).To(BeADirectory())
+				checkHistoryContent := func(expectedContent string) {
+					content, err := os.ReadFile(historyFile)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(content)).To(ContainSubstring(expectedContent))
+				}
+				checkHistoryContent(response)
+
+				// Clear the history using the CLI
+				runCommand("--clear-history", "--force")
+				Expect(historyFile).NotTo(BeAnExistingFile())
+
+				// Test omitting history through environment variable
+				omitHistoryEnvKey := strings.Replace(apiKeyEnvVar, "API_KEY", "OMIT_HISTORY", 1)
+				envValue := "true"
+				Expect(os.Setenv(omitHistoryEnvKey, envValue)).To(Succeed())
+
+				// Perform another query with history omitted
+				runCommand("--query", "some-query")
+				// The history file should NOT be recreated
+				Expect(historyFile).NotTo(BeAnExistingFile())
+
+				// Cleanup: Unset the environment variable
+				Expect(os.Unsetenv(omitHistoryEnvKey)).To(Succeed())
+			})
+
+			it("lists and applies the personas with the --list-personas and --persona flags", func() {
+				content := "sql:\n  role: You review SQL queries.\n  model: gpt-4o\nshell:\n  role: You are a terse shell expert.\n"
+				Expect(os.WriteFile(path.Join(filePath, "personas.yaml"), []byte(content), 0644)).To(Succeed())
+
+				output := runCommand("--list-personas")
+				Expect(output).To(ContainSubstring("Available personas:\nshell\nsql"))
+
+				output = runCommand("--persona", "sql", "--dry-run", "some-query")
+				Expect(output).To(ContainSubstring(`"model": "gpt-4o"`))
+				Expect(output).To(ContainSubstring(`"content": "You review SQL queries."`))
+
+				command := exec.Command(binaryPath, "--persona", "unknown", "--query", "some-query")
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitFailure))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("available personas: shell, sql"))
+			})
+
+			it("replaces the system prompt of a single run with --system, over the config and the persona", func() {
+				content := "sql:\n  role: You review SQL queries.\n"
+				Expect(os.WriteFile(path.Join(filePath, "personas.yaml"), []byte(content), 0644)).To(Succeed())
+
+				output := runCommand("--dry-run", "some-query")
+				Expect(output).To(ContainSubstring(`"content": "You are a helpful assistant."`))
+
+				roleEnvKey := strings.Replace(apiKeyEnvVar, "API_KEY", "ROLE", 1)
+				Expect(os.Setenv(roleEnvKey, "You answer in French.")).To(Succeed())
+				defer func() { Expect(os.Unsetenv(roleEnvKey)).To(Succeed()) }()
+
+				output = runCommand("--dry-run", "some-query")
+				Expect(output).To(ContainSubstring(`"content": "You answer in French."`))
+
+				output = runCommand("--persona", "sql", "--dry-run", "some-query")
+				Expect(output).To(ContainSubstring(`"content": "You review SQL queries."`))
+				Expect(output).NotTo(ContainSubstring("You answer in French."))
+
+				output = runCommand("--persona", "sql", "--system", "Answer only with a single shell command.", "--dry-run", "some-query")
+				Expect(output).To(ContainSubstring(`"content": "Answer only with a single shell command."`))
+				Expect(output).NotTo(ContainSubstring("You review SQL queries."))
+
+				// the thread keeps its own system prompt
+				runCommand("--system", "Answer only with a single shell command.", "--query", "some-query")
+				history, err := os.ReadFile(path.Join(filePath, "history", "default.json"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(history)).To(ContainSubstring("You answer in French."))
+				Expect(string(history)).NotTo(ContainSubstring("Answer only with a single shell command."))
+			})
+
+			it("lists, shows and locates the threads with the history subcommand", func() {
+				historyDir := path.Join(filePath, "history")
+				Expect(os.MkdirAll(historyDir, 0755)).To(Succeed())
+
+				messages := []types.Message{
+					{Role: "system", Content: "You are a helpful assistant."},
+					{Role: "user", Content: "first question"},
+					{Role: "assistant", Content: "first answer"},
+					{Role: "user", Content: "second question"},
+					{Role: "assistant", Content: "second answer"},
+				}
+				data, err := json.Marshal(messages)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(os.WriteFile(path.Join(historyDir, "work.json"), data, 0644)).To(Succeed())
+				runCommand("--add-tag", "golang", "--thread", "work")
+
+				output := runCommand("history", "list")
+				Expect(output).To(MatchRegexp(`(?m)^  THREAD +TITLE +MESSAGES +LAST ACTIVITY +TAGS$`))
+				Expect(output).To(MatchRegexp(`(?m)^  work +5 +\d{4}-\d{2}-\d{2} \d{2}:\d{2}  golang$`))
+
+				var threads []history.ThreadInfo
+				Expect(json.Unmarshal([]byte(runCommand("history", "list", "--json")), &threads)).To(Succeed())
+				Expect(threads).To(HaveLen(1))
+				Expect(threads[0].Name).To(Equal("work"))
+				Expect(threads[0].Tags).To(Equal([]string{"golang"}))
+
+				output = runCommand("history", "show", "work", "--last", "1")
+				Expect(output).To(ContainSubstring("**USER** 👤:\nsecond question\n"))
+				Expect(output).NotTo(ContainSubstring("first question"))
+
+				var shown []types.Message
+				Expect(json.Unmarshal([]byte(runCommand("history", "show", "work", "--json")), &shown)).To(Succeed())
+				Expect(shown).To(HaveLen(5))
+				Expect(shown[1].Content).To(Equal("first question"))
+
+				var paths map[string]string
+				Expect(json.Unmarshal([]byte(runCommand("history", "path", "--json")), &paths)).To(Succeed())
+				Expect(paths).To(HaveKeyWithValue("history", historyDir))
+				Expect(paths).To(HaveKeyWithValue("config", path.Join(filePath, "config.yaml")))
+
+				for args, failure := range map[string]struct {
+					code    int
+					message string
+				}{
+					"history show missing":      {exitFailure, "thread missing does not exist"},
+					"history list --last 2":     {exitUsage, "--last only applies to chatgpt history show"},
+					"--last 2 --query question": {exitUsage, "--last only applies to chatgpt history show"},
+					"history pick":              {exitUsage, "--pick picks the thread on a terminal"},
+					"--pick":                    {exitUsage, "--pick picks the thread on a terminal"},
+					"--pick question":           {exitUsage, "--pick continues the thread it picks in interactive mode, it takes no query"},
+				} {
+					command := exec.Command(binaryPath, strings.Fields(args)...)
+					session, err := gexec.Start(command, io.Discard, io.Discard)
+					Expect(err).NotTo(HaveOccurred())
+
+					Eventually(session).Should(gexec.Exit(failure.code))
+					Expect(string(session.Err.Contents())).To(ContainSubstring(failure.message), args)
+				}
+			})
+
+			it("reports the usage and the cost of a month from the ledger with the cost subcommand", func() {
+				historyDir := path.Join(filePath, "history")
+				Expect(os.MkdirAll(historyDir, 0755)).To(Succeed())
+				ledgerFile := path.Join(historyDir, "usage.jsonl")
+
+				runCommand("--query", "some-query")
+				entries, err := os.ReadFile(ledgerFile)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(entries)).To(ContainSubstring(`"thread":"default"`))
+				Expect(string(entries)).To(ContainSubstring(`"prompt_tokens":16,"completion_tokens":92`))
+
+				// the entries of an older version, without a cost, and a line cut short
+				Expect(os.WriteFile(ledgerFile, []byte(""+
+					`{"time":"2024-06-01T12:00:00Z","thread":"work","model":"gpt-4o","prompt_tokens":1000000,"completion_tokens":100000}`+"\n"+
+					`{"time":"2024-06-02T12:00:00Z","model":"gpt-4o","total_tokens":500}`+"\n"+
+					`{"time":"2024-06-03T12:00:00Z","thr`+"\n"), 0644)).To(Succeed())
+
+				output := runCommand("cost", "--month", "2024-06", "--by-model")
+				Expect(output).To(ContainSubstring("Usage of 2024-06\n"))
+				Expect(output).To(MatchRegexp(`(?m)^gpt-4o +2 +1000000 +100000 +1100500 +\$3\.5000$`))
+				Expect(output).To(ContainSubstring("1 line(s) of the ledger that aren't entries were skipped."))
+
+				var report ledger.Report
+				Expect(json.Unmarshal([]byte(runCommand("cost", "--month", "2024-06", "--json")), &report)).To(Succeed())
+				Expect(report.Total.Requests).To(Equal(2))
+				Expect(report.Total.Unpriced).To(Equal(1))
+				Expect(report.OverBudget).To(BeFalse())
+
+				budgetEnvKey := strings.Replace(apiKeyEnvVar, "API_KEY", "MONTHLY_BUDGET", 1)
+				Expect(os.Setenv(budgetEnvKey, "2")).To(Succeed())
+				defer func() { Expect(os.Unsetenv(budgetEnvKey)).To(Succeed()) }()
+
+				command := exec.Command(binaryPath, "cost", "--month", "2024-06")
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitOverBudget))
+				Expect(string(session.Out.Contents())).To(ContainSubstring("Budget: $3.5000 of $2.00 used (175.0%), over budget by $1.5000"))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("the usage of 2024-06 is over the monthly budget of $2.00"))
+			})
+
+			it("replaces the last answer with the --regenerate flag", func() {
+				historyDir := path.Join(filePath, "history")
+				Expect(os.MkdirAll(historyDir, 0755)).To(Succeed())
+				historyFile := path.Join(historyDir, "default.json")
+
+				messages := []types.Message{
+					{Role: "system", Content: "You are a helpful assistant."},
+					{Role: "user", Content: "Which bars are in Red Hook?"},
+					{Role: "assistant", Content: "a mediocre answer"},
+				}
+				data, err := json.Marshal(messages)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(os.WriteFile(historyFile, data, 0644)).To(Succeed())
+
+				output := runCommand("--regenerate")
+				Expect(output).To(ContainSubstring("popular bars in Red Hook, Brooklyn"))
+
+				stored, err := readHistoryFile(historyFile)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(stored).To(HaveLen(3))
+				Expect(stored[2].Content).To(ContainSubstring("popular bars in Red Hook, Brooklyn"))
+				Expect(stored[2].Alternatives).To(Equal([]string{"a mediocre answer"}))
+			})
+
+			it("removes the last exchanges with the --rewind flag", func() {
+				Expect(os.Unsetenv(apiKeyEnvVar)).To(Succeed())
+
+				historyDir := path.Join(filePath, "history")
+				Expect(os.MkdirAll(historyDir, 0755)).To(Succeed())
+				historyFile := path.Join(historyDir, "default.json")
+
+				messages := []types.Message{
+					{Role: "system", Content: "You are a helpful assistant."},
+					{Role: "user", Content: "question 1"},
+					{Role: "assistant", Content: "answer 1"},
+					{Role: "user", Content: "question 2"},
+					{Role: "assistant", Content: "answer 2"},
+				}
+				data, err := json.Marshal(messages)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(os.WriteFile(historyFile, data, 0644)).To(Succeed())
 
-				// The history file should have the expected content
-				Expect(path.Dir(historyFile)).To(BeADirectory())
-				content, err := os.ReadFile(historyFile)
+				output := runCommand("--rewind", "1")
+				Expect(output).To(ContainSubstring("**USER** 👤:\nquestion 2"))
+				Expect(output).To(ContainSubstring("**ASSISTANT** 🤖:\nanswer 2"))
 
+				stored, err := readHistoryFile(historyFile)
 				Expect(err).NotTo(HaveOccurred())
-				Expect(content).NotTo(BeEmpty())
-				Expect(string(content)).To(ContainSubstring(response))
+				Expect(stored).To(Equal(messages[:3]))
 
-				// The legacy file should now be a directory
-				Expect(legacyFile).To(BeADirectory())
-				Expect(legacyFile).NotTo(BeARegularFile())
+				output = runCommand("--rewind", "5")
+				Expect(output).To(ContainSubstring("question 1"))
 
-				// The content was moved to the new file
-				Expect(string(content)).To(ContainSubstring("Of course! Which city are you referring to?"))
+				output = runCommand("--rewind", "1")
+				Expect(output).To(ContainSubstring("Nothing to rewind."))
 			})
 
-			it("should not require an API key for the --clear-history flag", func() {
+			it("copies the current thread into a new one with the --fork flag", func() {
 				Expect(os.Unsetenv(apiKeyEnvVar)).To(Succeed())
 
-				command := exec.Command(binaryPath, "--clear-history")
-				session, err := gexec.Start(command, io.Discard, io.Discard)
-				Expect(err).NotTo(HaveOccurred())
+				historyDir := path.Join(filePath, "history")
+				Expect(os.MkdirAll(historyDir, 0755)).To(Succeed())
 
-				Eventually(session).Should(gexec.Exit(exitSuccess))
-			})
+				messages := []types.Message{
+					{Role: "system", Content: "You are a helpful assistant."},
+					{Role: "user", Content: "question 1"},
+					{Role: "assistant", Content: "answer 1"},
+				}
+				data, err := json.Marshal(messages)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(os.WriteFile(path.Join(historyDir, "default.json"), data, 0644)).To(Succeed())
 
-			it("keeps track of history", func() {
-				// History should not exist yet
-				historyDir := path.Join(filePath, "history")
-				historyFile := path.Join(historyDir, "default.json")
-				Expect(historyFile).NotTo(BeAnExistingFile())
+				output := runCommand("--fork", "branch")
+				Expect(output).To(ContainSubstring("Successfully forked thread default into branch"))
 
-				// Perform a query and check response
-				response := `I don't have personal opinions about bars, but here are some popular bars in Red Hook, Brooklyn:`
-				output := runCommand("--query", "some-query")
-				Expect(output).To(ContainSubstring(response))
+				forked, err := readHistoryFile(path.Join(historyDir, "branch.json"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(forked).To(Equal(messages))
 
-				// Check if history file was created with expected content
-				Expect(historyDir).To(BeADirectory())
-				checkHistoryContent := func(expectedContent string) {
-					content, err := os.ReadFile(historyFile)
-					Expect(err).NotTo(HaveOccurred())
-					Expect(string(content)).To(ContainSubstring(expectedContent))
-				}
-				checkHistoryContent(response)
+				output = runCommand("--list-threads")
+				Expect(output).To(ContainSubstring("* branch (current)"))
+				Expect(output).To(ContainSubstring("- default"))
 
-				// Clear the history using the CLI
-				runCommand("--clear-history")
-				Expect(historyFile).NotTo(BeAnExistingFile())
+				runCommand("--set-thread", "default")
+				runCommand("--fork", "start", "--fork-at", "1")
 
-				// Test omitting history through environment variable
-				omitHistoryEnvKey := strings.Replace(apiKeyEnvVar, "API_KEY", "OMIT_HISTORY", 1)
-				envValue := "true"
-				Expect(os.Setenv(omitHistoryEnvKey, envValue)).To(Succeed())
+				content, err := os.ReadFile(path.Join(historyDir, "start.json"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(content)).NotTo(ContainSubstring("question 1"))
 
-				// Perform another query with history omitted
-				runCommand("--query", "some-query")
-				// The history file should NOT be recreated
-				Expect(historyFile).NotTo(BeAnExistingFile())
+				runCommand("--set-thread", "default")
+				command := exec.Command(binaryPath, "--fork", "split", "--fork-at", "2")
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
 
-				// Cleanup: Unset the environment variable
-				Expect(os.Unsetenv(omitHistoryEnvKey)).To(Succeed())
+				Eventually(session).Should(gexec.Exit(exitFailure))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("splits an exchange"))
 			})
 
 			it("should return the expected result for the --list-threads flag", func() {
@@ -697,6 +4493,107 @@ func testIntegration(t *testing.T, when spec.G, it spec.S) {
 				Expect(output).To(ContainSubstring("- thread3"))
 			})
 
+			it("renames the current thread and falls back to the default thread when it is deleted", func() {
+				historyDir := path.Join(filePath, "history")
+				Expect(os.MkdirAll(historyDir, 0755)).To(Succeed())
+				Expect(os.WriteFile(path.Join(historyDir, "default.json"), []byte(`[{"role":"system","content":"hi"}]`), 0644)).To(Succeed())
+
+				output := runCommand("--rename-thread", "renamed")
+				Expect(output).To(ContainSubstring("Successfully renamed thread default to renamed"))
+
+				output = runCommand("--list-threads")
+				Expect(output).To(ContainSubstring("* renamed (current): 1 messages"))
+				Expect(output).NotTo(ContainSubstring("default"))
+
+				runCommand("--delete-thread", "renamed")
+
+				content, err := os.ReadFile(path.Join(filePath, "config.yaml"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(content)).To(ContainSubstring("thread: default"))
+			})
+
+			it("searches the threads with the --search flag", func() {
+				historyDir := path.Join(filePath, "history")
+				Expect(os.MkdirAll(historyDir, 0755)).To(Succeed())
+				content := `[{"role":"user","content":"pgbouncer timeouts"},{"role":"assistant","content":"Raise the timeout."}]`
+				Expect(os.WriteFile(path.Join(historyDir, "default.json"), []byte(content), 0644)).To(Succeed())
+
+				output := runCommand("--search", "TIMEOUT")
+				Expect(output).To(ContainSubstring("default [0] user"))
+				Expect(output).To(ContainSubstring("pgbouncer **timeout**s"))
+				Expect(output).To(ContainSubstring("default [1] assistant"))
+
+				output = runCommand("--search", "timeout", "--search-role", "assistant")
+				Expect(output).NotTo(ContainSubstring("default [0] user"))
+				Expect(output).To(ContainSubstring("Raise the **timeout**."))
+
+				output = runCommand("--search", "pg(pool|cat)", "--search-regexp")
+				Expect(output).To(ContainSubstring("No matches found."))
+			})
+
+			it("exports the current thread as Markdown with the --export flag", func() {
+				historyDir := path.Join(filePath, "history")
+				Expect(os.MkdirAll(historyDir, 0755)).To(Succeed())
+				content := `[{"role":"system","content":"You are a helpful assistant."},{"role":"user","content":"hello"},{"role":"assistant","content":"hi"}]`
+				Expect(os.WriteFile(path.Join(historyDir, "default.json"), []byte(content), 0644)).To(Succeed())
+
+				output := runCommand("--export", "md")
+				Expect(output).To(HavePrefix("# default\n"))
+				Expect(output).To(ContainSubstring("**System:**"))
+				Expect(output).To(ContainSubstring("**User:**\n\nhello"))
+
+				exportFile := path.Join(filePath, "export.md")
+				runCommand("--export", "md", "--omit-system", "-o", exportFile)
+
+				exported, err := os.ReadFile(exportFile)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(exported)).NotTo(ContainSubstring("**System:**"))
+				Expect(string(exported)).To(ContainSubstring("**Assistant:**\n\nhi"))
+
+				command := exec.Command(binaryPath, "--export", "pdf")
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitFailure))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("unsupported export format pdf"))
+			})
+
+			it("round-trips a thread with the --export and --import flags", func() {
+				historyDir := path.Join(filePath, "history")
+				Expect(os.MkdirAll(historyDir, 0755)).To(Succeed())
+				content := `[{"role":"system","content":"You are a helpful assistant."},{"role":"user","content":"hello"},{"role":"assistant","content":"hi","alternatives":["hey"]}]`
+				Expect(os.WriteFile(path.Join(historyDir, "default.json"), []byte(content), 0644)).To(Succeed())
+
+				exportFile := path.Join(filePath, "export.jsonl")
+				runCommand("--export", "jsonl", "-o", exportFile)
+
+				output := runCommand("--import", exportFile, "--thread", "copy")
+				Expect(output).To(ContainSubstring("Successfully imported 3 messages into thread copy"))
+
+				imported, err := os.ReadFile(path.Join(historyDir, "copy.json"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(imported)).To(ContainSubstring(`"alternatives":["hey"]`))
+
+				// merging leaves out the system prompt the thread already has
+				runCommand("--import", exportFile, "--thread", "copy")
+				output = runCommand("--list-threads")
+				Expect(output).To(ContainSubstring("copy: 5 messages"))
+
+				runCommand("--import", exportFile, "--thread", "copy", "--import-replace")
+				output = runCommand("--list-threads")
+				Expect(output).To(ContainSubstring("copy: 3 messages"))
+
+				invalidFile := path.Join(filePath, "invalid.json")
+				Expect(os.WriteFile(invalidFile, []byte(`{"version":9}`), 0644)).To(Succeed())
+
+				command := exec.Command(binaryPath, "--import", invalidFile)
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitFailure))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("unsupported export version 9"))
+			})
+
 			it("should not throw an error when a non-existent thread is deleted using the --delete-threads flag", func() {
 				command := exec.Command(binaryPath, "--delete-thread", "does-not-exist")
 				session, err := gexec.Start(command, io.Discard, io.Discard)
@@ -788,6 +4685,17 @@ func testIntegration(t *testing.T, when spec.G, it spec.S) {
 					Expect(os.Unsetenv(modelEnvKey)).To(Succeed())
 				})
 
+				it("reads the organization from the variable of the OpenAI SDKs", func() {
+					Expect(os.Setenv("OPENAI_ORG_ID", "org-from-env")).To(Succeed())
+					defer os.Unsetenv("OPENAI_ORG_ID")
+
+					output := runCommand("--config")
+					Expect(output).To(ContainSubstring("organization: org-from-env"))
+
+					output = runCommand("--organization", "org-from-flag", "--config")
+					Expect(output).To(ContainSubstring("organization: org-from-flag"))
+				})
+
 				it("has a configurable default thread", func() {
 					defaults := config.New().ReadDefaults()
 
@@ -863,6 +4771,122 @@ func testIntegration(t *testing.T, when spec.G, it spec.S) {
 				Expect(output).To(ContainSubstring("* " + newModel + " (current)"))
 			})
 
+			it("takes the settings of the output from the config, then the environment, then the flags", func() {
+				settings := []struct {
+					key, fallback, config, env string
+					flag                       []string
+					flagged                    string
+				}{
+					{"show_usage", "false", "true", "false", []string{"--usage"}, "true"},
+					{"quiet_mode", "false", "true", "false", []string{"--quiet"}, "true"},
+					{"pager", "true", "false", "true", []string{"--no-pager"}, "false"},
+					{"markdown", "true", "false", "true", []string{"--no-markdown"}, "false"},
+					{"wrap_width", "0", "100", "80", []string{"--width", "60"}, "60"},
+					{"pager_command", "", "more", "most -w", []string{"--pager-command", "less -R"}, "less -R"},
+					{"notify_after", "15", "30", "45", []string{"--notify-after", "5"}, "5"},
+				}
+
+				for _, setting := range settings {
+					Expect(runCommand("config", "get", setting.key)).To(Equal(setting.fallback+"\n"), setting.key)
+				}
+
+				var config strings.Builder
+				for _, setting := range settings {
+					fmt.Fprintf(&config, "%s: %s\n", setting.key, setting.config)
+				}
+				Expect(os.WriteFile(configFile, []byte(config.String()), 0644)).To(Succeed())
+				for _, setting := range settings {
+					Expect(runCommand("config", "get", setting.key)).To(Equal(setting.config+"\n"), setting.key)
+				}
+
+				for _, setting := range settings {
+					variable := "CHATGPT_CLI_" + strings.ToUpper(setting.key)
+					Expect(os.Setenv(variable, setting.env)).To(Succeed())
+					defer os.Unsetenv(variable)
+				}
+				for _, setting := range settings {
+					Expect(runCommand("config", "get", setting.key)).To(Equal(setting.env+"\n"), setting.key)
+					Expect(runCommand(append([]string{"config", "get", setting.key}, setting.flag...)...)).To(Equal(setting.flagged+"\n"), setting.key)
+				}
+			})
+
+			it("turns the switches of the output off with their negative flags, or with the flags given to false", func() {
+				Expect(os.WriteFile(configFile, []byte("show_usage: true\nquiet_mode: true\nmarkdown: true\n"), 0644)).To(Succeed())
+				Expect(runCommand("config", "get", "markdown", "--no-markdown")).To(Equal("false\n"))
+
+				query := func(args ...string) string {
+					command := exec.Command(binaryPath, append(args, "--query", "tell me a joke")...)
+					session, err := gexec.Start(command, io.Discard, io.Discard)
+					Expect(err).NotTo(HaveOccurred())
+					Eventually(session).Should(gexec.Exit(exitSuccess))
+
+					return string(session.Err.Contents())
+				}
+
+				// quiet_mode silences the usage line of show_usage, until --no-quiet
+				Expect(query()).NotTo(ContainSubstring("completion tokens ·"))
+				Expect(query("--no-quiet")).To(ContainSubstring("completion tokens ·"))
+				Expect(query("--no-quiet", "--no-usage")).NotTo(ContainSubstring("completion tokens ·"))
+				Expect(query("--no-quiet", "--usage=false")).NotTo(ContainSubstring("completion tokens ·"))
+				Expect(query("--quiet=false")).To(ContainSubstring("completion tokens ·"))
+
+				command := exec.Command(binaryPath, "--usage", "--no-usage", "--query", "tell me a joke")
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+				Eventually(session).Should(gexec.Exit(exitUsage))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("the --usage and --no-usage flags cannot be used together"))
+			})
+
+			it("overlays the config with the project file of the repository, its sensitive settings once it is trusted", func() {
+				Expect(os.Unsetenv(envVar)).To(Succeed())
+				Expect(os.WriteFile(configFile, []byte("model: "+newModel+"\nthread: global\n"), 0644)).To(Succeed())
+
+				project := t.TempDir()
+				Expect(os.MkdirAll(filepath.Join(project, ".git"), 0755)).To(Succeed())
+				Expect(os.MkdirAll(filepath.Join(project, "src"), 0755)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(project, configloader.ProjectFileName), []byte("thread: repo\nauth_header: X-Repo-Key\n"), 0644)).To(Succeed())
+
+				inProject := func(args ...string) (string, string) {
+					command := exec.Command(binaryPath, args...)
+					command.Dir = filepath.Join(project, "src")
+					session, err := gexec.Start(command, io.Discard, io.Discard)
+					Expect(err).NotTo(HaveOccurred())
+					Eventually(session).Should(gexec.Exit(exitSuccess))
+
+					return string(session.Out.Contents()), string(session.Err.Contents())
+				}
+
+				output, warnings := inProject("config", "get", "thread")
+				Expect(output).To(Equal("repo\n"))
+				Expect(warnings).To(ContainSubstring("the sensitive settings auth_header of"))
+				output, _ = inProject("config", "get", "model")
+				Expect(output).To(Equal(newModel + "\n"))
+				output, _ = inProject("config", "get", "auth_header")
+				Expect(output).To(Equal("Authorization\n"))
+
+				// the thread is the one of the config outside of the repository
+				Expect(runCommand("config", "get", "thread")).To(Equal("global\n"))
+
+				runCommand("config", "trust", project)
+				output, warnings = inProject("config", "get", "auth_header")
+				Expect(output).To(Equal("X-Repo-Key\n"))
+				Expect(warnings).To(BeEmpty())
+
+				runCommand("config", "untrust", project)
+				output, _ = inProject("config", "get", "auth_header")
+				Expect(output).To(Equal("Authorization\n"))
+			})
+
+			it("resolves the aliases of model_aliases, given with -m", func() {
+				Expect(os.WriteFile(configFile, []byte("model_aliases:\n  fast: "+newModel+"\n"), 0644)).To(Succeed())
+
+				output := runCommand("-m", "fast", "--list-models")
+				Expect(output).To(ContainSubstring("* " + newModel + " (current)"))
+
+				output = runCommand("-m", envModel, "--list-models")
+				Expect(output).To(ContainSubstring("* " + envModel + " (current)"))
+			})
+
 			it("falls back to default when config and env are absent", func() {
 				// Step 1: Ensure no config file and no environment variable.
 				Expect(os.Unsetenv(envVar)).To(Succeed())
@@ -871,6 +4895,357 @@ func testIntegration(t *testing.T, when spec.G, it spec.S) {
 				output := runCommand("--list-models")
 				Expect(output).To(ContainSubstring("* " + defaultModel + " (current)"))
 			})
+
+			it("warns of the unknown keys of the config file and refuses a malformed one, with their line", func() {
+				Expect(os.WriteFile(configFile, []byte("model: "+newModel+"\ntemprature: 0.5\n"), 0644)).To(Succeed())
+
+				command := exec.Command(binaryPath, "--list-models")
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitSuccess))
+				Expect(string(session.Out.Contents())).To(ContainSubstring("* " + newModel + " (current)"))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("Warning: unknown key temprature on line 2 of " + configFile))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("did you mean temperature?"))
+
+				Expect(os.WriteFile(configFile, []byte("model: "+newModel+"\nmax_tokens: many\n"), 0644)).To(Succeed())
+
+				command = exec.Command(binaryPath, "--list-models")
+				session, err = gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitFailure))
+				Expect(string(session.Err.Contents())).To(ContainSubstring(`line 2: invalid max_tokens "many", use a whole number`))
+			})
+
+			it("reads the settings of the profile of --profile, CHATGPT_CLI_PROFILE or the profile key", func() {
+				Expect(os.WriteFile(configFile, []byte("model: "+defaultModel+"\nprofile: home\nprofiles:\n"+
+					"  home:\n    model: "+newModel+"\n  work:\n    model: "+envModel+"\n"), 0644)).To(Succeed())
+
+				output := runCommand("config", "profiles")
+				Expect(output).To(Equal("* home (current)\n- work\n"))
+
+				output = runCommand("--list-models")
+				Expect(output).To(ContainSubstring("* " + newModel + " (current)"))
+
+				Expect(os.Setenv(configloader.ProfileEnv, "work")).To(Succeed())
+				output = runCommand("--list-models")
+				Expect(output).To(ContainSubstring("* " + envModel + " (current)"))
+
+				output = runCommand("--profile", "home", "--list-models")
+				Expect(output).To(ContainSubstring("* " + newModel + " (current)"))
+				Expect(os.Unsetenv(configloader.ProfileEnv)).To(Succeed())
+
+				// the --set- flags write to the profile in use
+				runCommand("--profile", "work", "--set-model", "gpt-5")
+				data, err := os.ReadFile(configFile)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(data)).To(ContainSubstring("work:\n        model: gpt-5"))
+				Expect(string(data)).To(HavePrefix("model: " + defaultModel + "\n"))
+
+				command := exec.Command(binaryPath, "--profile", "wrok", "--list-models")
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitFailure))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("unknown profile wrok, use one of home, work"))
+			})
+
+			it("sets up the config with init from its flags, keeping what was there, and only asks on a terminal", func() {
+				Expect(os.Setenv("CHATGPT_CLI_CREDENTIAL_STORE", "file")).To(Succeed())
+				defer os.Unsetenv("CHATGPT_CLI_CREDENTIAL_STORE")
+				Expect(os.Unsetenv(apiKeyEnvVar)).To(Succeed())
+				Expect(os.WriteFile(configFile, []byte("# my settings\ntemperature: 0.5\n"), 0644)).To(Succeed())
+
+				for args, message := range map[string]string{
+					"init":                          "chatgpt init asks its questions on a terminal",
+					"init --provider gemini --yes":  `invalid --provider "gemini", use openai, azure, anthropic, ollama`,
+					"init --model nope --yes":       "lists no model nope, use --model with one of",
+					"init --provider azure --yes":   "the azure provider needs the url of its resource",
+					"--yes what is the capital":     "--yes only applies to chatgpt init",
+					"init --api-key sk-ant-1 --yes": "the key of openai is an Anthropic API key",
+				} {
+					command := exec.Command(binaryPath, strings.Fields(args)...)
+					if args == "init --model nope --yes" {
+						command.Env = append(os.Environ(), apiKeyEnvVar+"="+expectedToken)
+					}
+					session, err := gexec.Start(command, io.Discard, io.Discard)
+					Expect(err).NotTo(HaveOccurred())
+
+					Eventually(session).Should(gexec.Exit(exitUsage), args)
+					Expect(string(session.Err.Contents())).To(ContainSubstring(message))
+				}
+
+				output := runCommand("init", "--api-key", expectedToken, "--model", defaultModel, "--stream=false", "--yes")
+				Expect(output).To(ContainSubstring("Wrote " + configFile + ", try it with:"))
+
+				data, err := os.ReadFile(configFile)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(data)).To(Equal("# my settings\ntemperature: 0.5\nmodel: " + defaultModel + "\nprovider: openai\nstream: false\n"))
+
+				// the key was stored, and the queries use it
+				Expect(runCommand("auth", "status")).To(ContainSubstring("The API key of openai is stored in"))
+				Expect(runCommand("what", "is", "the", "capital")).NotTo(BeEmpty())
+			})
+
+			it("resolves the provider and the key of a profile together, and refuses a profile that can't work", func() {
+				Expect(os.WriteFile(configFile, []byte("profiles:\n"+
+					"  keyed:\n    api_key_env: WORK_KEY\n"+
+					"  claude:\n    provider: anthropic\n"+
+					"  work:\n    provider: azure\n    url: https://work.openai.azure.com\n"), 0644)).To(Succeed())
+
+				// the key of api_key_env is used when the environment has none of its own
+				Expect(os.Unsetenv(apiKeyEnvVar)).To(Succeed())
+				Expect(os.Setenv("WORK_KEY", expectedToken)).To(Succeed())
+				defer os.Unsetenv("WORK_KEY")
+				Expect(runCommand("--profile", "keyed", "--list-models")).To(ContainSubstring("gpt-3.5-turbo"))
+				Expect(runCommand("--profile", "keyed", "auth", "status")).To(ContainSubstring("comes from WORK_KEY"))
+
+				Expect(os.Setenv(apiKeyEnvVar, expectedToken)).To(Succeed())
+				command := exec.Command(binaryPath, "--profile", "claude", "--list-models")
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitFailure))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("the anthropic provider takes Anthropic API keys"))
+
+				command = exec.Command(binaryPath, "--profile", "work", "config", "validate")
+				session, err = gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitFailure))
+				Expect(string(session.Out.Contents())).To(ContainSubstring("the azure provider needs azure_deployment"))
+			})
+
+			it("checks the setup with doctor, failing on a critical check and reporting as JSON", func() {
+				Expect(os.Setenv("CHATGPT_CLI_NO_UPDATE_CHECK", "true")).To(Succeed())
+				defer os.Unsetenv("CHATGPT_CLI_NO_UPDATE_CHECK")
+				Expect(os.WriteFile(configFile, []byte("model: "+newModel+"\n"), 0644)).To(Succeed())
+
+				output := runCommand("doctor")
+				Expect(output).To(MatchRegexp(`model +config +` + newModel))
+				Expect(output).To(MatchRegexp(`PASS  API key +the key of ` + apiKeyEnvVar + ` is taken by the API`))
+				Expect(output).To(MatchRegexp(`PASS  endpoint +the API at \S+ answered with \d+, TCP`))
+				Expect(output).To(ContainSubstring("0 failed"))
+
+				Expect(os.Unsetenv(apiKeyEnvVar)).To(Succeed())
+				command := exec.Command(binaryPath, "doctor", "--json")
+				command.Env = append(os.Environ(), "CHATGPT_CLI_CREDENTIAL_STORE=file")
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitFailure))
+				Expect(session.Err.Contents()).To(BeEmpty())
+
+				var report struct {
+					Checks []struct{ Name, Status, Hint string }
+				}
+				Expect(json.Unmarshal(session.Out.Contents(), &report)).To(Succeed())
+				Expect(report.Checks).To(HaveLen(8))
+				Expect(report.Checks[2].Name).To(Equal("API key"))
+				Expect(report.Checks[2].Status).To(Equal("fail"))
+				Expect(report.Checks[2].Hint).To(ContainSubstring("chatgpt auth login"))
+
+				// a config that doesn't parse is reported rather than failing the doctor
+				Expect(os.WriteFile(configFile, []byte("model: [\n"), 0644)).To(Succeed())
+				session, err = gexec.Start(exec.Command(binaryPath, "doctor"), io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitFailure))
+				Expect(string(session.Out.Contents())).To(ContainSubstring("FAIL  config file  invalid " + configFile))
+			})
+
+			it("lists the variables of the settings with where their values come from, and refuses an invalid one", func() {
+				Expect(os.WriteFile(configFile, []byte("model: "+newModel+"\nrole: file-role\n"), 0644)).To(Succeed())
+				Expect(os.Unsetenv(envVar)).To(Succeed())
+				Expect(os.Setenv("CHATGPT_CLI_MAX_TOKENS", "512")).To(Succeed())
+				defer os.Unsetenv("CHATGPT_CLI_MAX_TOKENS")
+
+				output := runCommand("config", "env", "--role", "flag-role")
+				Expect(output).To(MatchRegexp(`CHATGPT_CLI_MODEL +config +` + newModel + `\n`))
+				Expect(output).To(MatchRegexp(`CHATGPT_CLI_MAX_TOKENS +env +512\n`))
+				Expect(output).To(MatchRegexp(`CHATGPT_CLI_ROLE +flag +flag-role\n`))
+				Expect(output).To(MatchRegexp(`CHATGPT_CLI_THREAD +default +default\n`))
+				Expect(output).To(MatchRegexp(`OPENAI_API_KEY +env +\*{8}\n`))
+
+				output = runCommand("config", "env", "--json")
+				Expect(output).To(ContainSubstring(`"variable": "CHATGPT_CLI_MAX_TOKENS"`))
+
+				Expect(os.Setenv("CHATGPT_CLI_TEMPERATURE", "hot")).To(Succeed())
+				defer os.Unsetenv("CHATGPT_CLI_TEMPERATURE")
+
+				command := exec.Command(binaryPath, "--list-models")
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitFailure))
+				Expect(string(session.Err.Contents())).To(ContainSubstring(`invalid CHATGPT_CLI_TEMPERATURE "hot", use a number`))
+			})
+
+			it("gets, sets and unsets the settings of the config file, checked as the file is, and validates it", func() {
+				Expect(os.WriteFile(configFile, []byte("# the model\nmodel: "+newModel+" # for now\n"), 0600)).To(Succeed())
+
+				Expect(runCommand("config", "get", "model")).To(Equal(newModel + "\n"))
+
+				runCommand("config", "set", "temperature", "0.5")
+				runCommand("config", "set", "role", "You", "are", "a", "pirate")
+				runCommand("config", "set", "model", defaultModel)
+				data, err := os.ReadFile(configFile)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(data)).To(Equal("# the model\nmodel: " + defaultModel + " # for now\ntemperature: 0.5\nrole: You are a pirate\n"))
+
+				info, err := os.Stat(configFile)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+
+				runCommand("config", "unset", "temperature")
+				Expect(runCommand("config", "get", "temperature")).To(Equal("1\n"))
+
+				Expect(runCommand("config", "validate", "--online")).To(ContainSubstring(configFile + " is valid"))
+
+				for args, message := range map[string]string{
+					"config set temperature hot": `invalid temperature "hot", use a number`,
+					"config get temprature":      "unknown setting temprature, did you mean temperature?",
+				} {
+					command := exec.Command(binaryPath, strings.Fields(args)...)
+					session, err := gexec.Start(command, io.Discard, io.Discard)
+					Expect(err).NotTo(HaveOccurred())
+
+					Eventually(session).Should(gexec.Exit(exitUsage))
+					Expect(string(session.Err.Contents())).To(ContainSubstring(message))
+				}
+
+				// a secret isn't written in plaintext
+				command := exec.Command(binaryPath, "config", "set", "api_key", "sk-secret")
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitFailure))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("set CHATGPT_CLI_API_KEY in the environment instead"))
+
+				runCommand("config", "set", "temperature", "3")
+				command = exec.Command(binaryPath, "config", "validate")
+				session, err = gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitFailure))
+				Expect(string(session.Out.Contents())).To(ContainSubstring("invalid --temperature 3, use a value from 0 to 2"))
+				Expect(string(session.Err.Contents())).To(ContainSubstring(configFile + " has a problem"))
+			})
+
+			it("stores the API key with auth login once the API takes it, and uses it when the environment has none", func() {
+				Expect(os.Setenv("CHATGPT_CLI_CREDENTIAL_STORE", "file")).To(Succeed())
+				defer os.Unsetenv("CHATGPT_CLI_CREDENTIAL_STORE")
+				Expect(os.Unsetenv(apiKeyEnvVar)).To(Succeed())
+
+				// a key the API refuses isn't stored
+				command := exec.Command(binaryPath, "auth", "login")
+				command.Stdin = strings.NewReader("wrong-key\n")
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitAuth))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("it wasn't stored"))
+				Expect(path.Join(filePath, "credentials.json")).NotTo(BeAnExistingFile())
+
+				command = exec.Command(binaryPath, "auth", "login")
+				command.Stdin = strings.NewReader(expectedToken + "\n")
+				session, err = gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(0))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("Stored the API key of openai in " + path.Join(filePath, "credentials.json")))
+
+				info, err := os.Stat(path.Join(filePath, "credentials.json"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+
+				Expect(runCommand("auth", "status")).To(ContainSubstring("The API key of openai is stored in"))
+				Expect(runCommand("--list-models")).To(ContainSubstring("gpt-3.5-turbo"))
+
+				// the environment takes precedence over the stored key
+				Expect(os.Setenv(apiKeyEnvVar, "env-key")).To(Succeed())
+				Expect(runCommand("auth", "status")).To(ContainSubstring("comes from " + apiKeyEnvVar + ", which takes precedence"))
+				Expect(os.Unsetenv(apiKeyEnvVar)).To(Succeed())
+
+				runCommand("auth", "logout")
+
+				command = exec.Command(binaryPath, "auth", "status")
+				session, err = gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitAuth))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("no API key of openai, log in with chatgpt auth login"))
+			})
+
+			it("takes the sampling parameters from the flags, the environment, the config file and the defaults", func() {
+				// Step 1: Without a config file, the defaults are sent, and no seed nor stop sequences.
+				output := runCommand("--dry-run", "--query", "hello")
+				Expect(output).To(ContainSubstring(`"temperature": 1,`))
+				Expect(output).To(ContainSubstring(`"top_p": 1,`))
+				Expect(output).To(ContainSubstring(`"max_tokens": 4096,`))
+				Expect(output).NotTo(ContainSubstring(`"seed"`))
+				Expect(output).NotTo(ContainSubstring(`"stop"`))
+				Expect(output).NotTo(ContainSubstring(`"presence_penalty"`))
+
+				// Step 2: The config file replaces the defaults.
+				Expect(os.WriteFile(configFile, []byte("temperature: 0.3\ntop_p: 0.8\nmax_tokens: 512\n"+
+					"presence_penalty: 0.5\nfrequency_penalty: 0.25\nseed: 42\nstop:\n  - END\n"), 0644)).To(Succeed())
+
+				output = runCommand("--dry-run", "--query", "hello")
+				Expect(output).To(ContainSubstring(`"temperature": 0.3,`))
+				Expect(output).To(ContainSubstring(`"top_p": 0.8,`))
+				Expect(output).To(ContainSubstring(`"max_tokens": 512,`))
+				Expect(output).To(ContainSubstring(`"presence_penalty": 0.5,`))
+				Expect(output).To(ContainSubstring(`"frequency_penalty": 0.25,`))
+				Expect(output).To(ContainSubstring(`"seed": 42,`))
+				Expect(output).To(MatchRegexp(`"stop": \[\s+"END"\s+\]`))
+
+				// Step 3: The environment replaces the config file, for the values it sets.
+				temperatureEnv := strings.Replace(apiKeyEnvVar, "API_KEY", "TEMPERATURE", 1)
+				Expect(os.Setenv(temperatureEnv, "0.5")).To(Succeed())
+				defer os.Unsetenv(temperatureEnv)
+
+				output = runCommand("--dry-run", "--query", "hello")
+				Expect(output).To(ContainSubstring(`"temperature": 0.5,`))
+				Expect(output).To(ContainSubstring(`"top_p": 0.8,`))
+
+				// Step 4: The flags replace them all, the stop sequences of the config as a whole.
+				output = runCommand("--temperature", "0.7", "--top-p", "0.6", "--max-tokens", "256", "--seed", "0",
+					"--stop", "A", "--stop", "B", "--dry-run", "--query", "hello")
+				Expect(output).To(ContainSubstring(`"temperature": 0.7,`))
+				Expect(output).To(ContainSubstring(`"top_p": 0.6,`))
+				Expect(output).To(ContainSubstring(`"max_tokens": 256,`))
+				Expect(output).To(ContainSubstring(`"seed": 0,`))
+				Expect(output).To(MatchRegexp(`"stop": \[\s+"A",\s+"B"\s+\]`))
+				Expect(output).NotTo(ContainSubstring(`"END"`))
+			})
+
+			it("refuses the sampling parameters out of range, naming the flag", func() {
+				for args, message := range map[string]string{
+					"--temperature 2.5":     "invalid --temperature 2.5, use a value from 0 to 2",
+					"--top-p 1.5":           "invalid --top-p 1.5, use a value from 0 to 1",
+					"--presence-penalty -3": "invalid --presence-penalty -3, use a value from -2 to 2",
+					"--max-tokens 0":        "invalid --max-tokens 0, use at least 1",
+					"--seed many":           `invalid --seed "many", use a whole number`,
+					"--stop a --stop b --stop c --stop d --stop e": "too many --stop sequences, the API accepts up to 4 of them",
+				} {
+					command := exec.Command(binaryPath, append(strings.Fields(args), "--dry-run", "--query", "hello")...)
+					session, err := gexec.Start(command, io.Discard, io.Discard)
+					Expect(err).NotTo(HaveOccurred())
+					Eventually(session).Should(gexec.Exit(exitUsage))
+					Expect(string(session.Err.Contents())).To(ContainSubstring(message))
+				}
+
+				// a value of the config file out of range is refused as well
+				Expect(os.WriteFile(configFile, []byte("temperature: 4\n"), 0644)).To(Succeed())
+
+				command := exec.Command(binaryPath, "--dry-run", "--query", "hello")
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+				Eventually(session).Should(gexec.Exit(exitUsage))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("invalid --temperature 4"))
+			})
 		})
 
 		when("show-history flag is used", func() {
@@ -0,0 +1,152 @@
+package interrupt
+
+import (
+	"context"
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/clock"
+	"github.com/kardolus/chatgpt-cli/exitcode"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+// Grace is how soon a second Ctrl-C has to follow the first one to force the exit, rather than to
+// interrupt again.
+const Grace = 2 * time.Second
+
+// Handler turns a Ctrl-C into the cancellation of what the CLI is doing, so it stops the way it
+// does on an error: the spinner is erased, the terminal is restored and the history is written,
+// with the part of a streamed answer that arrived. The innermost context of Context is canceled,
+// such as the one of a question of the interactive mode, which then goes on with the next one. A
+// second Ctrl-C within Grace exits right away, for what doesn't stop. It is safe for concurrent
+// use.
+type Handler struct {
+	clock  clock.Clock
+	notice io.Writer
+	exit   func(int)
+
+	mu sync.Mutex
+	// contexts are the cancels of the contexts of Context, the innermost last
+	contexts    []*entry
+	cleanups    []func()
+	last        time.Time
+	interrupted bool
+}
+
+type entry struct {
+	cancel context.CancelFunc
+}
+
+// New returns a handler that tells on notice that the CLI was interrupted, and exits with os.Exit.
+func New(notice io.Writer) *Handler {
+	return &Handler{clock: clock.Real, notice: notice, exit: os.Exit}
+}
+
+// WithClock makes the handler read the time of the grace from the clock, such as a fake one in
+// tests.
+func (h *Handler) WithClock(c clock.Clock) *Handler {
+	h.clock = c
+	return h
+}
+
+// WithExit makes the handler exit with the function, such as one that records the code in tests.
+func (h *Handler) WithExit(exit func(int)) *Handler {
+	h.exit = exit
+	return h
+}
+
+// Context returns a context of the parent that the next Ctrl-C cancels, while it is the innermost
+// one. Its cancel has to be called once what it is for is over, which also makes the context it
+// is in the innermost one again.
+func (h *Handler) Context(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	e := &entry{cancel: cancel}
+
+	h.mu.Lock()
+	h.contexts = append(h.contexts, e)
+	h.mu.Unlock()
+
+	return ctx, func() {
+		cancel()
+
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		for i := range h.contexts {
+			if h.contexts[i] == e {
+				h.contexts = append(h.contexts[:i], h.contexts[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// OnExit registers what restores the terminal before a forced exit, such as the erasing of the
+// spinner, which the cancellation otherwise takes care of.
+func (h *Handler) OnExit(cleanup func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.cleanups = append(h.cleanups, cleanup)
+}
+
+// Interrupt is what a Ctrl-C does: it cancels the innermost context, or
+// runs the cleanups and exits with exitcode.Interrupted when it follows the previous one within Grace.
+func (h *Handler) Interrupt() {
+	h.mu.Lock()
+
+	now := h.clock.Now()
+	if !h.last.IsZero() && now.Sub(h.last) < Grace {
+		cleanups := h.cleanups
+		h.mu.Unlock()
+
+		for _, cleanup := range cleanups {
+			cleanup()
+		}
+		_, _ = fmt.Fprintln(h.notice, "\nInterrupted again, exiting")
+		h.exit(exitcode.Interrupted)
+		return
+	}
+
+	h.last, h.interrupted = now, true
+	var innermost *entry
+	if len(h.contexts) > 0 {
+		innermost = h.contexts[len(h.contexts)-1]
+	}
+	h.mu.Unlock()
+
+	if innermost != nil {
+		innermost.cancel()
+	}
+}
+
+// Interrupted tells whether a Ctrl-C was received.
+func (h *Handler) Interrupted() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.interrupted
+}
+
+// Listen makes the Ctrl-C of the process interrupt the handler until stop, after which a Ctrl-C
+// ends the process again.
+func (h *Handler) Listen() (stop func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range signals {
+			h.Interrupt()
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(signals)
+		<-done
+	}
+}
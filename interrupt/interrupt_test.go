@@ -0,0 +1,83 @@
+package interrupt_test
+
+import (
+	"context"
+	"github.com/kardolus/chatgpt-cli/clock"
+	"github.com/kardolus/chatgpt-cli/exitcode"
+	"github.com/kardolus/chatgpt-cli/interrupt"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitInterrupt(t *testing.T) {
+	spec.Run(t, "Testing the interrupts", testInterrupt, spec.Report(report.Terminal{}))
+}
+
+func testInterrupt(t *testing.T, when spec.G, it spec.S) {
+	var (
+		notice  *gbytes.Buffer
+		fake    *clock.Fake
+		exits   []int
+		handler *interrupt.Handler
+	)
+
+	it.Before(func() {
+		RegisterTestingT(t)
+
+		notice = gbytes.NewBuffer()
+		fake = clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+		exits = nil
+		handler = interrupt.New(notice).WithClock(fake).WithExit(func(code int) {
+			exits = append(exits, code)
+		})
+	})
+
+	when("Interrupt()", func() {
+		it("cancels the innermost context, and the one it is in once that one is over", func() {
+			outer, cancelOuter := handler.Context(context.Background())
+			defer cancelOuter()
+			inner, cancelInner := handler.Context(outer)
+
+			handler.Interrupt()
+			Expect(inner.Err()).To(MatchError(context.Canceled))
+			Expect(outer.Err()).NotTo(HaveOccurred())
+			Expect(handler.Interrupted()).To(BeTrue())
+
+			cancelInner()
+			fake.Advance(interrupt.Grace)
+
+			handler.Interrupt()
+			Expect(outer.Err()).To(MatchError(context.Canceled))
+			Expect(exits).To(BeEmpty())
+		})
+		it("cleans up and exits on a second interrupt within the grace", func() {
+			var cleaned bool
+			handler.OnExit(func() { cleaned = true })
+
+			ctx, cancel := handler.Context(context.Background())
+			defer cancel()
+
+			handler.Interrupt()
+			Expect(ctx.Err()).To(MatchError(context.Canceled))
+			Expect(exits).To(BeEmpty())
+
+			fake.Advance(interrupt.Grace / 2)
+			handler.Interrupt()
+			Expect(cleaned).To(BeTrue())
+			Expect(exits).To(Equal([]int{exitcode.Interrupted}))
+			Expect(notice).To(gbytes.Say("Interrupted again, exiting"))
+		})
+		it("tells whether it was interrupted, without a context to cancel", func() {
+			Expect(handler.Interrupted()).To(BeFalse())
+
+			handler.Interrupt()
+			Expect(handler.Interrupted()).To(BeTrue())
+			Expect(exits).To(BeEmpty())
+		})
+	})
+}
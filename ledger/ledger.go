@@ -0,0 +1,171 @@
+package ledger
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/metrics"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// File is the file of the data directory the ledger is kept in.
+const File = "usage.jsonl"
+
+// Version is the version of the entries this version of the CLI writes.
+const Version = 1
+
+// Price returns what the tokens cost with the model in US dollars, and false when the price of the
+// model is unknown, such as client.EstimateCost.
+type Price func(model string, promptTokens, completionTokens int) (float64, bool)
+
+// Entry is the usage of a request, a line of JSON of the ledger. The entries of older versions may
+// lack fields, which are counted as what they have: an entry without the tokens of the prompt and
+// of the completion only counts its total, and one without a cost is priced when it is read.
+type Entry struct {
+	Version          int       `json:"v,omitempty"`
+	Time             time.Time `json:"time"`
+	Thread           string    `json:"thread,omitempty"`
+	Model            string    `json:"model,omitempty"`
+	PromptTokens     int       `json:"prompt_tokens,omitempty"`
+	CompletionTokens int       `json:"completion_tokens,omitempty"`
+	TotalTokens      int       `json:"total_tokens,omitempty"`
+	Cost             *float64  `json:"cost,omitempty"`
+}
+
+// Total returns the tokens of the entry, its total when it only has that.
+func (e Entry) Total() int {
+	if e.PromptTokens > 0 || e.CompletionTokens > 0 {
+		return e.PromptTokens + e.CompletionTokens
+	}
+
+	return e.TotalTokens
+}
+
+// Ledger appends the usage of the answers to the ledger file, as the metrics of a client, along
+// with the thread they were asked on and what they cost.
+type Ledger struct {
+	path     string
+	thread   func() string
+	price    Price
+	now      func() time.Time
+	reporter func(error)
+	mu       sync.Mutex
+}
+
+var _ metrics.Metrics = &Ledger{}
+
+// New returns the ledger of the file, whose entries are on the thread that thread returns when the
+// usage is observed, and priced with price.
+func New(path string, thread func() string, price Price) *Ledger {
+	return &Ledger{path: path, thread: thread, price: price, now: time.Now, reporter: func(error) {}}
+}
+
+// WithClock makes the ledger date its entries with now rather than with the time of the system.
+func (l *Ledger) WithClock(now func() time.Time) *Ledger {
+	l.now = now
+	return l
+}
+
+// WithErrorReporter reports the entries that couldn't be written to reporter, the answers aren't
+// failed for them.
+func (l *Ledger) WithErrorReporter(reporter func(error)) *Ledger {
+	l.reporter = reporter
+	return l
+}
+
+func (l *Ledger) ObserveRequest(time.Duration, string, int) {}
+
+func (l *Ledger) ObserveFirstToken(time.Duration, string) {}
+
+func (l *Ledger) ObserveRetry(string) {}
+
+// ObserveTokens appends the usage to the ledger.
+func (l *Ledger) ObserveTokens(prompt, completion int, model string) {
+	entry := Entry{
+		Version:          Version,
+		Time:             l.now().UTC(),
+		Thread:           l.thread(),
+		Model:            model,
+		PromptTokens:     prompt,
+		CompletionTokens: completion,
+	}
+	if cost, ok := l.price(model, prompt, completion); ok {
+		entry.Cost = &cost
+	}
+
+	if err := l.append(entry); err != nil {
+		l.reporter(fmt.Errorf("failed to write the usage to %s: %w", l.path, err))
+	}
+}
+
+// append writes the entry as a line of its own, in a single write, so the entries of the clients
+// that write at the same time don't mix.
+func (l *Ledger) append(entry Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(l.path), 0700); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(append(data, '\n'))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Read returns the entries of the ledger file, none when it doesn't exist yet, and the number of
+// lines that were skipped because they aren't an entry, such as a line cut short by a crash. The
+// fields an entry doesn't know, written by a newer version, are ignored.
+func Read(path string) ([]Entry, int, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	var (
+		entries []Entry
+		skipped int
+	)
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		var entry Entry
+		if err := json.Unmarshal(line, &entry); err != nil || entry.Time.IsZero() {
+			skipped++
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	return entries, skipped, nil
+}
@@ -0,0 +1,181 @@
+package ledger_test
+
+import (
+	"github.com/kardolus/chatgpt-cli/ledger"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitLedger(t *testing.T) {
+	spec.Run(t, "Testing the ledger", testLedger, spec.Report(report.Terminal{}))
+}
+
+func testLedger(t *testing.T, when spec.G, it spec.S) {
+	var path string
+
+	// price charges a dollar per million prompt tokens and two per million completion tokens of
+	// gpt-4o, and knows no other model
+	price := func(model string, prompt, completion int) (float64, bool) {
+		if model != "gpt-4o" {
+			return 0, false
+		}
+		return (float64(prompt) + 2*float64(completion)) / 1_000_000, true
+	}
+	june := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	it.Before(func() {
+		RegisterTestingT(t)
+
+		path = filepath.Join(t.TempDir(), "data", ledger.File)
+	})
+
+	when("ObserveTokens()", func() {
+		it("appends the usage with its thread, its time and its cost", func() {
+			at := time.Date(2024, 6, 3, 10, 0, 0, 0, time.UTC)
+			l := ledger.New(path, func() string { return "work" }, price).WithClock(func() time.Time { return at })
+
+			l.ObserveTokens(1000, 500, "gpt-4o")
+			l.ObserveTokens(10, 20, "local-model")
+
+			entries, skipped, err := ledger.Read(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(skipped).To(BeZero())
+			Expect(entries).To(HaveLen(2))
+
+			Expect(entries[0].Version).To(Equal(ledger.Version))
+			Expect(entries[0].Time).To(Equal(at))
+			Expect(entries[0].Thread).To(Equal("work"))
+			Expect(*entries[0].Cost).To(BeNumerically("~", 0.002))
+			Expect(entries[1].Cost).To(BeNil())
+
+			info, err := os.Stat(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+		})
+		it("keeps every entry of the answers observed at the same time", func() {
+			l := ledger.New(path, func() string { return "default" }, price)
+
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					l.ObserveTokens(1, 1, "gpt-4o")
+				}()
+			}
+			wg.Wait()
+
+			entries, skipped, err := ledger.Read(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(skipped).To(BeZero())
+			Expect(entries).To(HaveLen(20))
+		})
+		it("reports an entry it can't write", func() {
+			Expect(os.MkdirAll(path, 0755)).To(Succeed())
+
+			var reported error
+			ledger.New(path, func() string { return "" }, price).WithErrorReporter(func(err error) { reported = err }).ObserveTokens(1, 1, "gpt-4o")
+			Expect(reported).To(MatchError(ContainSubstring("failed to write the usage to " + path)))
+		})
+	})
+
+	when("Read()", func() {
+		it("has no entries before the first one is written", func() {
+			entries, skipped, err := ledger.Read(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries).To(BeEmpty())
+			Expect(skipped).To(BeZero())
+		})
+		it("tolerates the entries of older and newer versions, and skips the lines that aren't entries", func() {
+			Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+			Expect(os.WriteFile(path, []byte(""+
+				`{"time":"2024-06-01T10:00:00Z","model":"gpt-4o","total_tokens":300}`+"\n"+
+				`{"v":2,"time":"2024-06-02T10:00:00Z","model":"gpt-4o","prompt_tokens":100,"completion_tokens":50,"currency":"EUR"}`+"\n"+
+				"\n"+
+				`{"time":"2024-06-03T10:00:00Z","model":"gpt`+"\n"+
+				`{"model":"gpt-4o","prompt_tokens":1}`+"\n"), 0644)).To(Succeed())
+
+			entries, skipped, err := ledger.Read(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(skipped).To(Equal(2))
+			Expect(entries).To(HaveLen(2))
+			Expect(entries[0].Total()).To(Equal(300))
+			Expect(entries[1].Total()).To(Equal(150))
+		})
+	})
+
+	when("ParseMonth()", func() {
+		it("parses a month, and defaults to the current one", func() {
+			now := time.Date(2024, 7, 15, 12, 0, 0, 0, time.UTC)
+
+			Expect(ledger.ParseMonth("", now)).To(Equal(time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)))
+			Expect(ledger.ParseMonth("2024-06", now)).To(Equal(june))
+
+			_, err := ledger.ParseMonth("June", now)
+			Expect(err).To(MatchError(`invalid month "June", use the form 2024-06`))
+		})
+	})
+
+	when("Summarize()", func() {
+		cost := func(value float64) *float64 { return &value }
+		entries := []ledger.Entry{
+			{Time: time.Date(2024, 5, 31, 23, 0, 0, 0, time.UTC), Thread: "work", Model: "gpt-4o", PromptTokens: 1_000_000},
+			{Time: time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC), Thread: "work", Model: "gpt-4o", PromptTokens: 1_000_000, CompletionTokens: 500_000, Cost: cost(3)},
+			{Time: time.Date(2024, 6, 2, 9, 0, 0, 0, time.UTC), Thread: "work", Model: "gpt-4o", PromptTokens: 500_000},
+			{Time: time.Date(2024, 6, 3, 9, 0, 0, 0, time.UTC), Thread: "home", Model: "local-model", PromptTokens: 10, CompletionTokens: 5},
+			{Time: time.Date(2024, 6, 4, 9, 0, 0, 0, time.UTC), Model: "gpt-4o", TotalTokens: 200},
+		}
+
+		it("adds up the entries of the month, with the cost they were written with or their price", func() {
+			report := ledger.Summarize(entries, ledger.Options{Month: june}, price)
+			Expect(report.Month).To(Equal("2024-06"))
+			Expect(report.Rows).To(BeEmpty())
+			Expect(report.Total).To(Equal(ledger.Row{
+				Requests: 4, PromptTokens: 1_500_010, CompletionTokens: 500_005, TotalTokens: 2_000_215, Cost: 3.5, Unpriced: 2,
+			}))
+			Expect(report.Used).To(BeNil())
+			Expect(report.OverBudget).To(BeFalse())
+
+			Expect(report.Format()).To(Equal("" +
+				"MONTH    REQUESTS  PROMPT   COMPLETION  TOTAL    COST\n" +
+				"2024-06  4         1500010  500005      2000215  $3.5000\n" +
+				"2 request(s) of models without a known price are left out of the cost.\n"))
+		})
+		it("adds up the entries by model and by thread", func() {
+			report := ledger.Summarize(entries, ledger.Options{Month: june, ByModel: true}, price)
+			Expect(report.Rows).To(HaveLen(2))
+			Expect(report.Rows[0].Model).To(Equal("gpt-4o"))
+			Expect(report.Rows[0].Requests).To(Equal(3))
+
+			report = ledger.Summarize(entries, ledger.Options{Month: june, ByModel: true, ByThread: true}, price)
+			Expect(report.Format()).To(Equal("" +
+				"Usage of 2024-06\n" +
+				"THREAD  MODEL        REQUESTS  PROMPT   COMPLETION  TOTAL    COST\n" +
+				"-       gpt-4o       1         0        0           200      unknown\n" +
+				"home    local-model  1         10       5           15       unknown\n" +
+				"work    gpt-4o       2         1500000  500000      2000000  $3.5000\n" +
+				"total                4         1500010  500005      2000215  $3.5000\n" +
+				"2 request(s) of models without a known price are left out of the cost.\n"))
+		})
+		it("tells the share of the budget the month used, and whether it is over", func() {
+			report := ledger.Summarize(entries[:3], ledger.Options{Month: june, Budget: 10}, price)
+			Expect(*report.Used).To(BeNumerically("~", 35))
+			Expect(report.OverBudget).To(BeFalse())
+			Expect(report.Format()).To(HaveSuffix("Budget: $3.5000 of $10.00 used (35.0%)\n"))
+			Expect(report.Err()).NotTo(HaveOccurred())
+
+			report = ledger.Summarize(entries[:3], ledger.Options{Month: june, Budget: 2}, price)
+			Expect(report.OverBudget).To(BeTrue())
+			Expect(report.Format()).To(HaveSuffix("Budget: $3.5000 of $2.00 used (175.0%), over budget by $1.5000\n"))
+			Expect(report.Err()).To(MatchError(ledger.ErrOverBudget))
+			Expect(report.Err()).To(MatchError("the usage of 2024-06 is over the monthly budget of $2.00"))
+		})
+	})
+}
@@ -0,0 +1,253 @@
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ErrOverBudget is the kind of the error of a month whose usage is over its budget.
+var ErrOverBudget = errors.New("over the monthly budget")
+
+// monthLayout is the layout of the months of the reports, such as 2024-06.
+const monthLayout = "2006-01"
+
+// Options tells what a report covers: the entries of the month, added up by model, by thread, or
+// both, and the budget of the month in US dollars, 0 when there is none.
+type Options struct {
+	Month    time.Time
+	ByModel  bool
+	ByThread bool
+	Budget   float64
+}
+
+// Row adds up the entries of a model, of a thread, or of all of them. Unpriced is the number of
+// requests whose cost is unknown, which Cost leaves out.
+type Row struct {
+	Thread           string  `json:"thread,omitempty"`
+	Model            string  `json:"model,omitempty"`
+	Requests         int     `json:"requests"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	Cost             float64 `json:"estimated_cost"`
+	Unpriced         int     `json:"unpriced_requests,omitempty"`
+}
+
+func (r *Row) add(entry Entry, price Price) {
+	r.Requests++
+	r.PromptTokens += entry.PromptTokens
+	r.CompletionTokens += entry.CompletionTokens
+	r.TotalTokens += entry.Total()
+
+	if cost, ok := costOf(entry, price); ok {
+		r.Cost += cost
+	} else {
+		r.Unpriced++
+	}
+}
+
+// costOf returns the cost of the entry, the one it was written with or else the one of its tokens,
+// and false when it can't be known, such as for an entry that only has its total.
+func costOf(entry Entry, price Price) (float64, bool) {
+	if entry.Cost != nil {
+		return *entry.Cost, true
+	}
+	if entry.Model == "" || (entry.PromptTokens == 0 && entry.CompletionTokens == 0) {
+		return 0, false
+	}
+
+	return price(entry.Model, entry.PromptTokens, entry.CompletionTokens)
+}
+
+// Report is the usage of a month. Rows are only set when the usage is added up by model or by
+// thread. Used is the share of the budget the month used, in percent, nil without a budget.
+type Report struct {
+	Month      string   `json:"month"`
+	Rows       []Row    `json:"rows,omitempty"`
+	Total      Row      `json:"total"`
+	Budget     float64  `json:"budget,omitempty"`
+	Used       *float64 `json:"used_percent,omitempty"`
+	OverBudget bool     `json:"over_budget"`
+	Skipped    int      `json:"skipped_entries,omitempty"`
+
+	byModel, byThread bool
+}
+
+// Err returns an error of the kind ErrOverBudget when the month is over budget, and nil otherwise.
+func (r Report) Err() error {
+	if !r.OverBudget {
+		return nil
+	}
+
+	return fmt.Errorf("the usage of %s is %w of $%.2f", r.Month, ErrOverBudget, r.Budget)
+}
+
+// ParseMonth parses a month such as 2024-06, in the location of now, and returns the one of now
+// when the text is empty.
+func ParseMonth(text string, now time.Time) (time.Time, error) {
+	if text == "" {
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()), nil
+	}
+
+	month, err := time.ParseInLocation(monthLayout, text, now.Location())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid month %q, use the form 2024-06", text)
+	}
+
+	return month, nil
+}
+
+// Summarize adds up the entries of the month of the options, in the location of the month, and
+// prices the ones written without a cost with price.
+func Summarize(entries []Entry, opts Options, price Price) Report {
+	report := Report{Month: opts.Month.Format(monthLayout), Budget: opts.Budget, byModel: opts.ByModel, byThread: opts.ByThread}
+
+	rows := map[[2]string]*Row{}
+	for _, entry := range entries {
+		at := entry.Time.In(opts.Month.Location())
+		if at.Year() != opts.Month.Year() || at.Month() != opts.Month.Month() {
+			continue
+		}
+
+		report.Total.add(entry, price)
+		if !opts.ByModel && !opts.ByThread {
+			continue
+		}
+
+		var key [2]string
+		if opts.ByThread {
+			key[0] = entry.Thread
+		}
+		if opts.ByModel {
+			key[1] = entry.Model
+		}
+		if rows[key] == nil {
+			rows[key] = &Row{Thread: key[0], Model: key[1]}
+		}
+		rows[key].add(entry, price)
+	}
+
+	for _, row := range rows {
+		report.Rows = append(report.Rows, *row)
+	}
+	sort.Slice(report.Rows, func(i, j int) bool {
+		a, b := report.Rows[i], report.Rows[j]
+		if a.Thread != b.Thread {
+			return a.Thread < b.Thread
+		}
+		return a.Model < b.Model
+	})
+
+	if opts.Budget > 0 {
+		used := report.Total.Cost / opts.Budget * 100
+		report.Used = &used
+		report.OverBudget = report.Total.Cost > opts.Budget
+	}
+
+	return report
+}
+
+// Format renders the report as a table of the tokens and the estimated cost, followed by the
+// budget used, when there is one.
+func (r Report) Format() string {
+	grouped := r.byThread || r.byModel
+
+	var header []string
+	if r.byThread {
+		header = append(header, "THREAD")
+	}
+	if r.byModel {
+		header = append(header, "MODEL")
+	}
+	if !grouped {
+		header = append(header, "MONTH")
+	}
+	header = append(header, "REQUESTS", "PROMPT", "COMPLETION", "TOTAL", "COST")
+
+	cells := func(row Row, labels ...string) []string {
+		cost := fmt.Sprintf("$%.4f", row.Cost)
+		if row.Unpriced == row.Requests && row.Requests > 0 {
+			cost = "unknown"
+		}
+
+		return append(labels, strconv.Itoa(row.Requests), strconv.Itoa(row.PromptTokens),
+			strconv.Itoa(row.CompletionTokens), strconv.Itoa(row.TotalTokens), cost)
+	}
+
+	rows := [][]string{header}
+	if !grouped {
+		rows = append(rows, cells(r.Total, r.Month))
+	} else {
+		for _, row := range r.Rows {
+			var labels []string
+			if r.byThread {
+				labels = append(labels, orNone(row.Thread))
+			}
+			if r.byModel {
+				labels = append(labels, orNone(row.Model))
+			}
+			rows = append(rows, cells(row, labels...))
+		}
+
+		total := make([]string, len(header)-5)
+		total[0] = "total"
+		rows = append(rows, cells(r.Total, total...))
+	}
+
+	var result strings.Builder
+	if grouped {
+		fmt.Fprintf(&result, "Usage of %s\n", r.Month)
+	}
+	result.WriteString(table(rows))
+
+	if r.Total.Unpriced > 0 {
+		fmt.Fprintf(&result, "%d request(s) of models without a known price are left out of the cost.\n", r.Total.Unpriced)
+	}
+	if r.Used != nil {
+		fmt.Fprintf(&result, "Budget: $%.4f of $%.2f used (%.1f%%)", r.Total.Cost, r.Budget, *r.Used)
+		if r.OverBudget {
+			fmt.Fprintf(&result, ", over budget by $%.4f", r.Total.Cost-r.Budget)
+		}
+		result.WriteString("\n")
+	}
+	if r.Skipped > 0 {
+		fmt.Fprintf(&result, "%d line(s) of the ledger that aren't entries were skipped.\n", r.Skipped)
+	}
+
+	return result.String()
+}
+
+// orNone returns the label of a row, or - for the entries that don't tell it.
+func orNone(label string) string {
+	if label == "" {
+		return "-"
+	}
+
+	return label
+}
+
+// table aligns the cells of the rows in columns.
+func table(rows [][]string) string {
+	widths := make([]int, len(rows[0]))
+	for _, row := range rows {
+		for i, cell := range row {
+			widths[i] = max(widths[i], utf8.RuneCountInString(cell))
+		}
+	}
+
+	var result strings.Builder
+	for _, row := range rows {
+		var line strings.Builder
+		for i, cell := range row {
+			line.WriteString(cell + strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell)+2))
+		}
+		result.WriteString(strings.TrimRight(line.String(), " ") + "\n")
+	}
+
+	return result.String()
+}
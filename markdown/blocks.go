@@ -0,0 +1,101 @@
+package markdown
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNoCodeBlock is a text without fenced code blocks.
+var ErrNoCodeBlock = errors.New("the answer has no code block")
+
+// CodeBlock is a fenced code block of a Markdown text, its code without the fences.
+type CodeBlock struct {
+	Language string
+	Code     string
+}
+
+// CodeBlocks returns the fenced code blocks of the text, in their order. The indentation of a fence
+// is removed from the lines of its code, and a block that isn't closed, such as the last one of an
+// answer that was cut off, ends with the text.
+func CodeBlocks(text string) []CodeBlock {
+	var (
+		result []CodeBlock
+		fence  string
+		indent int
+		block  *CodeBlock
+		lines  []string
+	)
+
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		if block == nil {
+			if match := fencePattern.FindStringSubmatch(line); match != nil {
+				fence, indent = match[1], len(match[0])-len(match[1])
+				block, lines = &CodeBlock{Language: language(line[len(match[0]):])}, nil
+			}
+			continue
+		}
+
+		if trimmed := strings.TrimSpace(line); strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+			block.Code = strings.Join(lines, "\n")
+			result = append(result, *block)
+			block = nil
+			continue
+		}
+
+		lines = append(lines, strings.TrimPrefix(line, strings.Repeat(" ", min(indent, len(line)-len(strings.TrimLeft(line, " "))))))
+	}
+
+	if block != nil {
+		block.Code = strings.Join(lines, "\n")
+		result = append(result, *block)
+	}
+
+	return result
+}
+
+// CodeBlockAt returns the nth code block of the text, counting from 1, or from the last one when n
+// is negative, so -1 is the last one. A text without code blocks is an ErrNoCodeBlock.
+func CodeBlockAt(text string, n int) (CodeBlock, error) {
+	blocks := CodeBlocks(text)
+	if len(blocks) == 0 {
+		return CodeBlock{}, ErrNoCodeBlock
+	}
+
+	i := n - 1
+	if n < 0 {
+		i = len(blocks) + n
+	}
+
+	if n == 0 || i < 0 || i >= len(blocks) {
+		return CodeBlock{}, fmt.Errorf("the answer has %d code block(s), there is no code block %d", len(blocks), n)
+	}
+
+	return blocks[i], nil
+}
+
+// Code returns the code of the code blocks of the text, one after the other, such as to write the
+// file an answer generated. A text without code blocks is an ErrNoCodeBlock.
+func Code(text string) (string, error) {
+	blocks := CodeBlocks(text)
+	if len(blocks) == 0 {
+		return "", ErrNoCodeBlock
+	}
+
+	code := make([]string, len(blocks))
+	for i, block := range blocks {
+		code[i] = block.Code
+	}
+
+	return strings.Join(code, "\n"), nil
+}
+
+// language returns the language of the info string of a fence, such as go of "go title=main.go".
+func language(info string) string {
+	fields := strings.Fields(info)
+	if len(fields) == 0 {
+		return ""
+	}
+
+	return strings.TrimPrefix(strings.Trim(strings.ToLower(fields[0]), "{}"), ".")
+}
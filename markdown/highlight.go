@@ -0,0 +1,311 @@
+package markdown
+
+import (
+	"github.com/kardolus/chatgpt-cli/style"
+	"github.com/kardolus/chatgpt-cli/templates"
+	"regexp"
+	"strings"
+)
+
+// syntax is what the highlighting tells apart in the code of a language.
+type syntax struct {
+	keywords      map[string]bool
+	caseFold      bool
+	lineComments  []string
+	blockComments [][2]string
+	// quotes open the strings, which close with the same quote, the ones of multiline more than one
+	// line later
+	quotes    []string
+	multiline map[string]bool
+	// raw are the quotes of the strings that a backslash doesn't escape in
+	raw map[string]bool
+}
+
+func words(list string) map[string]bool {
+	result := map[string]bool{}
+	for _, word := range strings.Fields(list) {
+		result[word] = true
+	}
+
+	return result
+}
+
+var (
+	cLike = syntax{
+		lineComments:  []string{"//"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		quotes:        []string{`"`, "'"},
+	}
+	hashLike = syntax{
+		lineComments: []string{"#"},
+		quotes:       []string{`"`, "'"},
+	}
+
+	syntaxes = map[string]syntax{
+		"go": with(cLike, syntax{
+			keywords: words("break case chan const continue default defer else fallthrough for func go goto if import " +
+				"interface map package range return select struct switch type var nil true false iota"),
+			quotes: []string{`"`, "'", "`"}, multiline: words("`"), raw: words("`"),
+		}),
+		"c": with(cLike, syntax{keywords: words("auto break case char const continue default do double else enum " +
+			"extern float for goto if int long register return short signed sizeof static struct switch typedef " +
+			"union unsigned void volatile while class namespace public private protected template typename new " +
+			"delete this true false nullptr bool include define")}),
+		"java": with(cLike, syntax{keywords: words("abstract boolean break byte case catch char class const continue " +
+			"default do double else enum extends final finally float for if implements import instanceof int " +
+			"interface long new package private protected public return short static super switch this throw " +
+			"throws try void while true false null var fun val when object")}),
+		"javascript": with(cLike, syntax{
+			keywords: words("async await break case catch class const continue debugger default delete do else " +
+				"export extends finally for function if import in instanceof let new of return super switch this " +
+				"throw try typeof var void while yield true false null undefined interface type enum implements"),
+			quotes: []string{`"`, "'", "`"}, multiline: words("`"),
+		}),
+		"rust": with(cLike, syntax{keywords: words("as async await break const continue crate else enum extern " +
+			"false fn for if impl in let loop match mod move mut pub ref return self Self static struct super " +
+			"trait true type unsafe use where while dyn")}),
+		"python": with(hashLike, syntax{
+			keywords: words("and as assert async await break class continue def del elif else except finally for " +
+				"from global if import in is lambda nonlocal not or pass raise return try while with yield True " +
+				"False None self"),
+			quotes: []string{`"""`, "'''", `"`, "'"}, multiline: words(`""" '''`),
+		}),
+		"ruby": with(hashLike, syntax{keywords: words("alias and begin break case class def defined? do else elsif " +
+			"end ensure false for if in module next nil not or redo rescue retry return self super then true undef " +
+			"unless until when while yield require")}),
+		"sh": with(hashLike, syntax{keywords: words("if then else elif fi for while until do done case esac in " +
+			"function return export local readonly set unset shift exit echo source")}),
+		"yaml": with(hashLike, syntax{keywords: words("true false null yes no on off")}),
+		"json": {keywords: words("true false null"), quotes: []string{`"`}},
+		"sql": {
+			keywords: words("select from where and or not insert into values update set delete create table drop " +
+				"alter index primary key foreign references join left right inner outer on group by order having " +
+				"limit offset as distinct union all null is in like between case when then else end begin commit"),
+			caseFold:      true,
+			lineComments:  []string{"--"},
+			blockComments: [][2]string{{"/*", "*/"}},
+			quotes:        []string{"'", `"`},
+		},
+	}
+
+	// aliases are the other tags of the languages of the code blocks
+	aliases = map[string]string{
+		"golang": "go", "cpp": "c", "c++": "c", "h": "c", "hpp": "c", "cs": "java", "csharp": "java",
+		"kotlin": "java", "kt": "java", "scala": "java", "swift": "java", "js": "javascript", "jsx": "javascript",
+		"ts": "javascript", "tsx": "javascript", "typescript": "javascript", "rs": "rust", "py": "python",
+		"python3": "python", "rb": "ruby", "bash": "sh", "zsh": "sh", "shell": "sh", "console": "sh",
+		"yml": "yaml", "toml": "yaml", "ini": "yaml", "patch": "diff",
+	}
+
+	numberPattern = regexp.MustCompile(`^(0[xX][0-9a-fA-F_]+|\d[\d_]*(\.\d+)?([eE][+-]?\d+)?)`)
+	wordPattern   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*\??`)
+)
+
+// with returns the syntax with the fields that the overrides set replaced.
+func with(base, overrides syntax) syntax {
+	if overrides.keywords != nil {
+		base.keywords = overrides.keywords
+	}
+	if overrides.quotes != nil {
+		base.quotes = overrides.quotes
+	}
+	if overrides.multiline != nil {
+		base.multiline = overrides.multiline
+	}
+	if overrides.raw != nil {
+		base.raw = overrides.raw
+	}
+
+	return base
+}
+
+// highlighter highlights the code of a code block line by line, keeping the comments and the
+// strings that span lines open from one line to the next. A block without the tag of its language
+// is highlighted once GuessLanguage can tell it from the lines so far.
+type highlighter struct {
+	style    style.Styler
+	language string
+	syntax   *syntax
+	guess    bool
+	code     strings.Builder
+	// closer closes the comment or the string that the last line left open, if any
+	closer  string
+	comment bool
+	raw     bool
+}
+
+func newHighlighter(info string, s style.Styler) *highlighter {
+	result := &highlighter{style: s}
+
+	tag := language(info)
+	if tag == "" {
+		result.guess = true
+		return result
+	}
+
+	result.use(tag)
+	return result
+}
+
+// use highlights the code that follows as the language, its tag or one of the aliases of it.
+func (h *highlighter) use(tag string) {
+	if alias, ok := aliases[tag]; ok {
+		tag = alias
+	}
+
+	h.language = tag
+	if s, ok := syntaxes[tag]; ok {
+		h.syntax = &s
+	}
+}
+
+// line returns the line of code highlighted.
+func (h *highlighter) line(line string) string {
+	if h.guess {
+		h.code.WriteString(line + "\n")
+		if language := templates.GuessLanguage(h.code.String()); language != "" {
+			h.guess = false
+			h.code.Reset()
+			h.use(language)
+		}
+	}
+
+	switch {
+	case h.language == "diff":
+		return h.diff(line)
+	case h.syntax == nil:
+		return line
+	}
+
+	var result strings.Builder
+	if h.closer != "" {
+		result.WriteString(h.open())
+	}
+
+	for line != "" {
+		if h.closer != "" {
+			line = h.rest(&result, line)
+			continue
+		}
+		line = h.token(&result, line)
+	}
+
+	return result.String()
+}
+
+// diff colors the added, the removed and the hunk lines of a diff.
+func (h *highlighter) diff(line string) string {
+	switch {
+	case strings.HasPrefix(line, "+++") || strings.HasPrefix(line, "---") || strings.HasPrefix(line, "diff "):
+		return h.style.Apply(style.Bold, line)
+	case strings.HasPrefix(line, "+"):
+		return h.style.Paint(style.Added, line)
+	case strings.HasPrefix(line, "-"):
+		return h.style.Paint(style.Removed, line)
+	case strings.HasPrefix(line, "@@"):
+		return h.style.Paint(style.Hunk, line)
+	}
+
+	return line
+}
+
+// token writes the highlighted token the line starts with, and returns the rest of the line.
+func (h *highlighter) token(result *strings.Builder, line string) string {
+	s := h.syntax
+
+	for _, opener := range s.lineComments {
+		if strings.HasPrefix(line, opener) {
+			result.WriteString(h.style.Paint(style.Comment, line))
+			return ""
+		}
+	}
+
+	for _, comment := range s.blockComments {
+		if strings.HasPrefix(line, comment[0]) {
+			result.WriteString(h.style.Color(style.Comment) + comment[0])
+			h.closer, h.comment, h.raw = comment[1], true, true
+			return line[len(comment[0]):]
+		}
+	}
+
+	for _, quote := range s.quotes {
+		if strings.HasPrefix(line, quote) {
+			result.WriteString(h.style.Color(style.String) + quote)
+			h.closer, h.comment, h.raw = quote, false, s.raw[quote]
+			return line[len(quote):]
+		}
+	}
+
+	if match := wordPattern.FindString(line); match != "" {
+		word := match
+		if s.caseFold {
+			word = strings.ToLower(word)
+		}
+
+		if s.keywords[word] {
+			result.WriteString(h.style.Paint(style.Keyword, match))
+		} else {
+			result.WriteString(match)
+		}
+		return line[len(match):]
+	}
+
+	if match := numberPattern.FindString(line); match != "" {
+		result.WriteString(h.style.Paint(style.Number, match))
+		return line[len(match):]
+	}
+
+	// anything else is written as it is, a whole rune at a time
+	for i := range line {
+		if i > 0 {
+			result.WriteString(line[:i])
+			return line[i:]
+		}
+	}
+	result.WriteString(line)
+	return ""
+}
+
+// rest writes the line up to the closer of the comment or the string that is open, and returns
+// what follows it. A string that isn't multiline closes at the end of its line.
+func (h *highlighter) rest(result *strings.Builder, line string) string {
+	color := h.open()
+
+	for i := 0; i < len(line); i++ {
+		if !h.raw && line[i] == '\\' {
+			i++
+			continue
+		}
+
+		if strings.HasPrefix(line[i:], h.closer) {
+			end := i + len(h.closer)
+			result.WriteString(line[:end])
+			if color != "" {
+				result.WriteString(style.ColorOff)
+			}
+			h.closer = ""
+			return line[end:]
+		}
+	}
+
+	result.WriteString(line)
+	if color != "" {
+		// the color is reset at the end of every line, so a line of the terminal never bleeds into the
+		// next, and set again on the next one
+		result.WriteString(style.ColorOff)
+	}
+	if !h.comment && !h.syntax.multiline[h.closer] {
+		h.closer = ""
+	}
+
+	return ""
+}
+
+// open returns the color of the comment or the string that is open.
+func (h *highlighter) open() string {
+	if h.comment {
+		return h.style.Color(style.Comment)
+	}
+
+	return h.style.Color(style.String)
+}
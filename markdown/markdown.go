@@ -0,0 +1,402 @@
+package markdown
+
+import (
+	"bytes"
+	"github.com/kardolus/chatgpt-cli/style"
+	"io"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	bullet        = "•"
+	ruleRune      = "─"
+	maxRuleWidth  = 80
+	tabWidth      = 4
+	escapedOffset = 0xE000
+)
+
+var (
+	fencePattern     = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})")
+	headingPattern   = regexp.MustCompile(`^ {0,3}(#{1,6})\s+(.*?)(\s+#+)?\s*$`)
+	rulePattern      = regexp.MustCompile(`^ {0,3}([-*_])(\s*([-*_])){2,}\s*$`)
+	quotePattern     = regexp.MustCompile(`^ {0,3}>\s?(.*)$`)
+	listPattern      = regexp.MustCompile(`^(\s*)([-*+]|\d{1,9}[.)])\s+(.*)$`)
+	separatorPattern = regexp.MustCompile(`^\s*:?-+:?\s*$`)
+	escapePattern    = regexp.MustCompile("\\\\([\\\\`*_{}\\[\\]()#+\\-.!~|>])")
+	linkPattern      = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	boldPattern      = regexp.MustCompile(`\*\*(\S(?:.*?\S)?)\*\*|\b__(\S(?:.*?\S)?)__\b`)
+	italicPattern    = regexp.MustCompile(`\*(\S(?:[^*]*?\S)?)\*|\b_(\S(?:[^_]*?\S)?)_\b`)
+	strikePattern    = regexp.MustCompile(`~~(\S(?:.*?\S)?)~~`)
+	ansiPattern      = regexp.MustCompile(`\033\[[0-9;]*m`)
+)
+
+// escapable are the characters a backslash escapes, in the order of the runes they stand in for
+// while the emphasis is rendered.
+const escapable = "\\`*_{}[]()#+-.!~|>"
+
+// Writer renders the Markdown written to it for a terminal: the headings and the emphasis with
+// the ANSI escape codes of its style, the lists indented, the rules drawn and the tables aligned. The text is
+// wrapped at the width, a word longer than the width broken rather than cut off, and never the code
+// of a code block, which is written as it is, nor the rows of a table. The Markdown is rendered line by line as it arrives,
+// so an answer is rendered as it is streamed, and a table once its last row arrived. Flush renders
+// what is left once the answer ended.
+type Writer struct {
+	out         io.Writer
+	width       atomic.Int64
+	style       style.Styler
+	highlight   bool
+	highlighter *highlighter
+	line        []byte
+	fence       string
+	table       []string
+	err         error
+}
+
+// NewWriter returns a writer that renders to out, at the width, which doesn't wrap when it is 0, in
+// the colors of the dark theme.
+func NewWriter(out io.Writer, width int) *Writer {
+	w := &Writer{out: out, style: style.New(style.Dark, style.Basic)}
+	w.SetWidth(width)
+	return w
+}
+
+// SetWidth changes the width the lines are wrapped at from the next line on, such as once the
+// terminal was resized while an answer is streamed. It is safe to call while the writer writes.
+func (w *Writer) SetWidth(width int) {
+	w.width.Store(int64(width))
+}
+
+// WithStyle renders with the colors and the attributes of the styler.
+func (w *Writer) WithStyle(s style.Styler) *Writer {
+	w.style = s
+	return w
+}
+
+// WithHighlighting highlights the code of the code blocks in the colors of the style, as the
+// language their fence tags them with tells, or as the one GuessLanguage guesses when they have no
+// tag. The code is highlighted a line at a time, as it is rendered, so the escape codes are never cut.
+func (w *Writer) WithHighlighting() *Writer {
+	w.highlight = true
+	return w
+}
+
+// WithoutColor renders without any escape code, neither colors nor attributes such as bold, as
+// NO_COLOR asks.
+func (w *Writer) WithoutColor() *Writer {
+	return w.WithStyle(style.Styler{})
+}
+
+// Render returns the Markdown rendered for a terminal of the width.
+func Render(text string, width int) string {
+	var result bytes.Buffer
+
+	w := NewWriter(&result, width)
+	_, _ = w.Write([]byte(text))
+	_ = w.Flush()
+
+	return result.String()
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.line = append(w.line, p...)
+
+	for {
+		i := bytes.IndexByte(w.line, '\n')
+		if i < 0 {
+			break
+		}
+
+		line := strings.TrimSuffix(string(w.line[:i]), "\r")
+		w.line = w.line[i+1:]
+		w.render(line, true)
+	}
+
+	return len(p), w.err
+}
+
+// Flush renders the last line, which has no newline, and the table it ends, if any.
+func (w *Writer) Flush() error {
+	if len(w.line) > 0 {
+		line := string(w.line)
+		w.line = nil
+		w.render(line, false)
+	}
+	// the rows of a table that is left ended with a newline, the last line would have flushed it
+	w.flushTable(true)
+
+	return w.err
+}
+
+// render renders a line, newline telling whether it ended with one.
+func (w *Writer) render(line string, newline bool) {
+	if w.fence != "" {
+		if strings.HasPrefix(strings.TrimSpace(line), w.fence) && strings.Trim(strings.TrimSpace(line), w.fence[:1]) == "" {
+			w.fence, w.highlighter = "", nil
+			w.emit(w.style.Apply(style.Faint, line), newline)
+			return
+		}
+
+		// the code of a code block is written as it is, only colored when it is highlighted
+		if w.highlighter != nil {
+			line = w.highlighter.line(line)
+		}
+		w.emit(line, newline)
+		return
+	}
+
+	if isTableRow(line) {
+		w.table = append(w.table, line)
+		if !newline {
+			w.flushTable(false)
+		}
+		return
+	}
+	w.flushTable(true)
+
+	if match := fencePattern.FindStringSubmatch(line); match != nil {
+		w.fence = match[1]
+		if w.highlight {
+			w.highlighter = newHighlighter(line[len(match[0]):], w.style)
+		}
+		w.emit(w.style.Apply(style.Faint, line), newline)
+		return
+	}
+
+	if rulePattern.MatchString(line) {
+		width := int(w.width.Load())
+		if width <= 0 || width > maxRuleWidth {
+			width = maxRuleWidth
+		}
+		w.emit(w.style.Apply(style.Faint, strings.Repeat(ruleRune, width)), newline)
+		return
+	}
+
+	if match := headingPattern.FindStringSubmatch(line); match != nil {
+		heading := w.inline(match[2])
+		if len(match[1]) == 1 {
+			heading = w.style.Apply(style.Underline, heading)
+		}
+		w.emitWrapped(w.style.Apply(style.Bold, heading), "", "", newline)
+		return
+	}
+
+	if match := quotePattern.FindStringSubmatch(line); match != nil {
+		prefix := w.style.Apply(style.Faint, "│ ")
+		w.emitWrapped(w.style.Apply(style.Italic, w.inline(match[1])), prefix, prefix, newline)
+		return
+	}
+
+	if match := listPattern.FindStringSubmatch(line); match != nil {
+		indent := expandTabs(match[1])
+		marker := match[2]
+		if strings.ContainsAny(marker, "-*+") {
+			marker = bullet
+		}
+		w.emitWrapped(w.inline(match[3]), indent+marker+" ", strings.Repeat(" ", Width(indent+marker+" ")), newline)
+		return
+	}
+
+	indent := expandTabs(line[:len(line)-len(strings.TrimLeft(line, " \t"))])
+	w.emitWrapped(w.inline(strings.TrimLeft(line, " \t")), indent, indent, newline)
+}
+
+// emit writes a rendered line.
+func (w *Writer) emit(line string, newline bool) {
+	if w.err != nil {
+		return
+	}
+
+	if newline {
+		line += "\n"
+	}
+	_, w.err = io.WriteString(w.out, line)
+}
+
+// emitWrapped writes the text wrapped at the width, its first line after the first prefix and the
+// others after the rest.
+func (w *Writer) emitWrapped(text, first, rest string, newline bool) {
+	lines := wrap(text, int(w.width.Load()), first, rest)
+	for i, line := range lines {
+		w.emit(line, newline || i < len(lines)-1)
+	}
+}
+
+// flushTable renders the table of the rows so far, newline telling whether its last row ended with
+// one. Rows without a separator row under the header aren't a table, and are rendered as text.
+func (w *Writer) flushTable(newline bool) {
+	rows := w.table
+	w.table = nil
+	if len(rows) == 0 {
+		return
+	}
+
+	if len(rows) < 2 || !isSeparator(splitRow(rows[1])) {
+		for i, row := range rows {
+			w.emitWrapped(w.inline(strings.TrimSpace(row)), "", "", newline || i < len(rows)-1)
+		}
+		return
+	}
+
+	alignments := splitRow(rows[1])
+	var cells [][]string
+	var widths []int
+	for i, row := range rows {
+		if i == 1 {
+			continue
+		}
+
+		var rendered []string
+		for j, cell := range splitRow(row) {
+			cell = w.inline(cell)
+			if i == 0 {
+				cell = w.style.Apply(style.Bold, cell)
+			}
+			rendered = append(rendered, cell)
+
+			if j >= len(widths) {
+				widths = append(widths, 0)
+			}
+			widths[j] = max(widths[j], Width(cell))
+		}
+		cells = append(cells, rendered)
+	}
+
+	for i, row := range cells {
+		var line []string
+		for j := range widths {
+			cell := ""
+			if j < len(row) {
+				cell = row[j]
+			}
+
+			alignment := ""
+			if j < len(alignments) {
+				alignment = alignments[j]
+			}
+			line = append(line, align(cell, widths[j], alignment))
+		}
+		w.emit(strings.Join(line, w.style.Apply(style.Faint, " │ ")), newline || i < len(cells)-1)
+
+		if i == 0 {
+			var rule []string
+			for _, width := range widths {
+				rule = append(rule, strings.Repeat(ruleRune, width))
+			}
+			w.emit(w.style.Apply(style.Faint, strings.Join(rule, "─┼─")), true)
+		}
+	}
+}
+
+func isTableRow(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), "|")
+}
+
+func isSeparator(cells []string) bool {
+	for _, cell := range cells {
+		if !separatorPattern.MatchString(cell) {
+			return false
+		}
+	}
+
+	return len(cells) > 0
+}
+
+// splitRow returns the cells of a row of a table, split at the pipes that aren't escaped.
+func splitRow(row string) []string {
+	row = strings.TrimSpace(row)
+	row = strings.TrimPrefix(row, "|")
+	if strings.HasSuffix(row, "|") && !strings.HasSuffix(row, `\|`) {
+		row = row[:len(row)-1]
+	}
+
+	var result []string
+	var cell strings.Builder
+	for i := 0; i < len(row); i++ {
+		switch {
+		case row[i] == '\\' && i+1 < len(row) && row[i+1] == '|':
+			cell.WriteByte('|')
+			i++
+		case row[i] == '|':
+			result = append(result, strings.TrimSpace(cell.String()))
+			cell.Reset()
+		default:
+			cell.WriteByte(row[i])
+		}
+	}
+
+	return append(result, strings.TrimSpace(cell.String()))
+}
+
+// align pads the cell to the width as the separator of its column tells, such as :-: to center it.
+func align(cell string, width int, separator string) string {
+	padding := width - Width(cell)
+	separator = strings.TrimSpace(separator)
+
+	switch {
+	case strings.HasPrefix(separator, ":") && strings.HasSuffix(separator, ":") && len(separator) > 1:
+		return strings.Repeat(" ", padding/2) + cell + strings.Repeat(" ", padding-padding/2)
+	case strings.HasSuffix(separator, ":"):
+		return strings.Repeat(" ", padding) + cell
+	default:
+		return cell + strings.Repeat(" ", padding)
+	}
+}
+
+// inline renders the emphasis, the links and the code spans of the text. The code spans are
+// written as they are, and a backslash escapes the character after it.
+func (w *Writer) inline(text string) string {
+	var result strings.Builder
+
+	for text != "" {
+		start := strings.IndexByte(text, '`')
+		if start < 0 {
+			result.WriteString(w.emphasis(text))
+			break
+		}
+
+		ticks := len(text[start:]) - len(strings.TrimLeft(text[start:], "`"))
+		end := strings.Index(text[start+ticks:], text[start:start+ticks])
+		if end < 0 {
+			result.WriteString(w.emphasis(text))
+			break
+		}
+
+		result.WriteString(w.emphasis(text[:start]))
+		result.WriteString(w.style.Paint(style.Code, text[start+ticks:start+ticks+end]))
+		text = text[start+ticks+end+ticks:]
+	}
+
+	return result.String()
+}
+
+// emphasis renders the emphasis and the links of text that holds no code span.
+func (w *Writer) emphasis(text string) string {
+	// the escaped characters stand in as runes of the private use area, which no pattern matches
+	text = escapePattern.ReplaceAllStringFunc(text, func(match string) string {
+		return string(rune(escapedOffset + strings.IndexByte(escapable, match[1])))
+	})
+
+	text = linkPattern.ReplaceAllStringFunc(text, func(match string) string {
+		parts := linkPattern.FindStringSubmatch(match)
+		if parts[1] == parts[2] {
+			return w.style.Apply(style.Underline, parts[2])
+		}
+		return w.style.Apply(style.Underline, parts[1]) + " (" + parts[2] + ")"
+	})
+	text = boldPattern.ReplaceAllString(text, w.style.Apply(style.Bold, "${1}${2}"))
+	text = italicPattern.ReplaceAllString(text, w.style.Apply(style.Italic, "${1}${2}"))
+	text = strikePattern.ReplaceAllString(text, w.style.Apply(style.Strike, "${1}"))
+
+	return strings.Map(func(r rune) rune {
+		if r >= escapedOffset && r < escapedOffset+rune(len(escapable)) {
+			return rune(escapable[r-escapedOffset])
+		}
+		return r
+	}, text)
+}
+
+func expandTabs(indent string) string {
+	return strings.ReplaceAll(indent, "\t", strings.Repeat(" ", tabWidth))
+}
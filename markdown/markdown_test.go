@@ -0,0 +1,287 @@
+package markdown_test
+
+import (
+	"bytes"
+	"github.com/kardolus/chatgpt-cli/markdown"
+	"github.com/kardolus/chatgpt-cli/style"
+	"strconv"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitMarkdown(t *testing.T) {
+	spec.Run(t, "Testing the markdown", testMarkdown, spec.Report(report.Terminal{}))
+}
+
+func testMarkdown(t *testing.T, when spec.G, it spec.S) {
+	it.Before(func() {
+		RegisterTestingT(t)
+	})
+
+	when("Render()", func() {
+		it("renders the headings and the emphasis with escape codes", func() {
+			Expect(markdown.Render("# Title\n## Section", 0)).To(Equal("\033[1m\033[4mTitle\033[24m\033[22m\n\033[1mSection\033[22m"))
+			Expect(markdown.Render("**bold**, *italic*, ~~gone~~ and __also bold__", 0)).To(Equal(
+				"\033[1mbold\033[22m, \033[3mitalic\033[23m, \033[9mgone\033[29m and \033[1malso bold\033[22m"))
+		})
+		it("leaves the code spans and the escaped characters as they are", func() {
+			Expect(markdown.Render("`a *b* c` and \\*not italic\\*", 0)).To(Equal("\033[36ma *b* c\033[39m and *not italic*"))
+			Expect(markdown.Render("snake_case_name", 0)).To(Equal("snake_case_name"))
+		})
+		it("renders the links with their URL", func() {
+			Expect(markdown.Render("see [the docs](https://example.com)", 0)).To(Equal("see \033[4mthe docs\033[24m (https://example.com)"))
+		})
+		it("indents the lists and wraps their items under their text", func() {
+			Expect(markdown.Render("- one\n  * two words here\n3. three", 13)).To(Equal("• one\n  • two words\n    here\n3. three"))
+		})
+		it("draws the rules and the quotes", func() {
+			Expect(markdown.Render("---", 5)).To(Equal("\033[2m─────\033[22m"))
+			Expect(markdown.Render("> said", 0)).To(Equal("\033[2m│ \033[22m\033[3msaid\033[23m"))
+		})
+		it("doesn't alter the code of a code block", func() {
+			code := "```go\nfunc main() { **x** }  // a very long line that is not wrapped at all\n  - not a list\n```"
+
+			Expect(markdown.Render(code, 10)).To(Equal(
+				"\033[2m```go\033[22m\nfunc main() { **x** }  // a very long line that is not wrapped at all\n  - not a list\n\033[2m```\033[22m"))
+		})
+		it("aligns the tables as their separator row tells", func() {
+			table := "| name | n |\n|:-:|--:|\n| a | 10 |\n| longer | 2 |\n"
+
+			Expect(strings.Split(markdown.Render(table, 0), "\n")).To(Equal([]string{
+				" \033[1mname\033[22m \033[2m │ \033[22m \033[1mn\033[22m",
+				"\033[2m───────┼───\033[22m",
+				"  a   \033[2m │ \033[22m10",
+				"longer\033[2m │ \033[22m 2",
+				"",
+			}))
+		})
+		it("aligns the cells of wide runes at their columns, and doesn't wrap the rows", func() {
+			table := "| name | n |\n|---|---|\n| 日本 | 1 |\n| a | 2 |\n"
+
+			Expect(strings.Split(markdown.Render(table, 3), "\n")).To(Equal([]string{
+				"\033[1mname\033[22m\033[2m │ \033[22m\033[1mn\033[22m",
+				"\033[2m─────┼──\033[22m",
+				"日本\033[2m │ \033[22m1",
+				"a   \033[2m │ \033[22m2",
+				"",
+			}))
+		})
+		it("renders rows without a separator row as text", func() {
+			Expect(markdown.Render("| not | a table", 0)).To(Equal("| not | a table"))
+		})
+		it("wraps the long lines at the width, breaking the words longer than it", func() {
+			Expect(markdown.Render("one two three four", 9)).To(Equal("one two\nthree\nfour"))
+			Expect(markdown.Render("abcdefghij", 4)).To(Equal("abcd\nefgh\nij"))
+			Expect(markdown.Render("    indented words", 12)).To(Equal("    indented\n    words"))
+		})
+	})
+
+	when("Width()", func() {
+		it("counts the columns the text takes, without its escape codes", func() {
+			Expect(markdown.Width("plain")).To(Equal(5))
+			Expect(markdown.Width("\033[1mbold\033[22m")).To(Equal(4))
+			Expect(markdown.Width("")).To(Equal(0))
+		})
+		it("counts two columns for the wide runes", func() {
+			Expect(markdown.Width("日本語")).To(Equal(6))
+			Expect(markdown.Width("한국어")).To(Equal(6))
+			Expect(markdown.Width("ＡＢ")).To(Equal(4))
+			Expect(markdown.Width("ok 👍")).To(Equal(5))
+			Expect(markdown.Width("✅ done")).To(Equal(7))
+		})
+		it("counts no column for the runes joined to the one before", func() {
+			Expect(markdown.Width("e\u0301")).To(Equal(1))
+			Expect(markdown.Width("❤\ufe0f")).To(Equal(1))
+			Expect(markdown.Width("👍\U0001f3fd")).To(Equal(2))
+			Expect(markdown.Width("👨\u200d👩\u200d👧")).To(Equal(2))
+		})
+	})
+
+	when("Wrap()", func() {
+		it("wraps at the spaces between the words", func() {
+			Expect(markdown.Wrap("one two three four", 9)).To(Equal([]string{"one two", "three", "four"}))
+			Expect(markdown.Wrap("one two", 0)).To(Equal([]string{"one two"}))
+		})
+		it("wraps at the columns the wide runes take", func() {
+			Expect(markdown.Wrap("日本 語の 文章", 5)).To(Equal([]string{"日本", "語の", "文章"}))
+			Expect(markdown.Wrap("ok 👍👍 done", 5)).To(Equal([]string{"ok", "👍👍", "done"}))
+		})
+		it("breaks a word wider than the width without splitting a wide rune", func() {
+			Expect(markdown.Wrap("日本語のテキスト", 6)).To(Equal([]string{"日本語", "のテキ", "スト"}))
+			Expect(markdown.Wrap("a日本", 2)).To(Equal([]string{"a", "日", "本"}))
+			Expect(markdown.Wrap("日本", 1)).To(Equal([]string{"日", "本"}))
+		})
+		it("keeps the runes that take no column with the one before", func() {
+			Expect(markdown.Wrap("abce\u0301f", 4)).To(Equal([]string{"abce\u0301", "f"}))
+			Expect(markdown.Wrap("👨\u200d👩\u200d👧👍", 2)).To(Equal([]string{"👨\u200d👩\u200d👧", "👍"}))
+		})
+	})
+
+	when("Writer", func() {
+		it("renders the lines as they arrive and the last one once it is flushed", func() {
+			var out bytes.Buffer
+			w := markdown.NewWriter(&out, 0)
+
+			_, err := w.Write([]byte("# Ti"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out.String()).To(BeEmpty())
+
+			_, err = w.Write([]byte("tle\n**do"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out.String()).To(Equal("\033[1m\033[4mTitle\033[24m\033[22m\n"))
+
+			_, err = w.Write([]byte("ne**"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(w.Flush()).To(Succeed())
+			Expect(out.String()).To(Equal("\033[1m\033[4mTitle\033[24m\033[22m\n\033[1mdone\033[22m"))
+		})
+		it("wraps at the width it is set to from the next line on", func() {
+			var out bytes.Buffer
+			w := markdown.NewWriter(&out, 0)
+
+			_, _ = w.Write([]byte("one two three\n"))
+			w.SetWidth(7)
+			_, _ = w.Write([]byte("one two three\n"))
+
+			Expect(out.String()).To(Equal("one two three\none two\nthree\n"))
+		})
+		it("renders a table once its last row arrived", func() {
+			var out bytes.Buffer
+			w := markdown.NewWriter(&out, 0)
+
+			_, _ = w.Write([]byte("| a |\n|---|\n| b |\n"))
+			Expect(out.String()).To(BeEmpty())
+
+			_, _ = w.Write([]byte("\n"))
+			Expect(out.String()).To(Equal("\033[1ma\033[22m\n\033[2m─\033[22m\nb\n\n"))
+		})
+	})
+
+	when("WithHighlighting()", func() {
+		// each element has a color of its own, red for the keywords, green for the strings and so on
+		theme := style.Theme{}
+		for i, element := range []style.Element{style.Keyword, style.String, style.Comment, style.Number, style.Added, style.Removed, style.Hunk, style.Code} {
+			color, err := style.ParseColor(strconv.Itoa(i + 1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			theme[element] = color
+		}
+		styler := style.New(theme, style.Basic)
+
+		render := func(text string) string {
+			var out bytes.Buffer
+			w := markdown.NewWriter(&out, 0).WithStyle(styler).WithHighlighting()
+			_, _ = w.Write([]byte(text))
+			Expect(w.Flush()).To(Succeed())
+
+			return out.String()
+		}
+
+		it("highlights the code as the tag of its fence tells", func() {
+			Expect(render("```golang\nreturn \"a \\\" b\", 42 // done\n```")).To(Equal(
+				"\033[2m```golang\033[22m\n\033[31mreturn\033[39m \033[32m\"a \\\" b\"\033[39m, \033[34m42\033[39m \033[33m// done\033[39m\n\033[2m```\033[22m"))
+		})
+		it("keeps the comments and the strings that span lines open on the next lines", func() {
+			Expect(render("```python\nx = \"\"\"one\ntwo\"\"\" if y\n```")).To(Equal(
+				"\033[2m```python\033[22m\nx = \033[32m\"\"\"one\033[39m\n\033[32mtwo\"\"\"\033[39m \033[31mif\033[39m y\n\033[2m```\033[22m"))
+			Expect(render("```c\n/* a\nb */ int\n```")).To(Equal(
+				"\033[2m```c\033[22m\n\033[33m/* a\033[39m\n\033[33mb */\033[39m \033[31mint\033[39m\n\033[2m```\033[22m"))
+			Expect(render("```js\nx = 'open\nif\n```")).To(Equal(
+				"\033[2m```js\033[22m\nx = \033[32m'open\033[39m\n\033[31mif\033[39m\n\033[2m```\033[22m"))
+		})
+		it("guesses the language of a block without a tag", func() {
+			Expect(render("```\ndiff --git a/x b/x\n-old\n+new\n@@ -1 +1 @@\n```")).To(Equal(
+				"\033[2m```\033[22m\n\033[1mdiff --git a/x b/x\033[22m\n\033[36m-old\033[39m\n\033[35m+new\033[39m\n\033[37m@@ -1 +1 @@\033[39m\n\033[2m```\033[22m"))
+		})
+		it("leaves the code of a language it doesn't know and the text outside the blocks alone", func() {
+			Expect(render("```brainfuck\n+[->+<]\n```\nif `x`")).To(Equal("\033[2m```brainfuck\033[22m\n+[->+<]\n\033[2m```\033[22m\nif \033[90mx\033[39m"))
+		})
+		it("highlights a line at a time, as it is streamed", func() {
+			var out bytes.Buffer
+			w := markdown.NewWriter(&out, 0).WithStyle(styler).WithHighlighting()
+
+			_, _ = w.Write([]byte("```go\nfunc main() {"))
+			Expect(out.String()).To(Equal("\033[2m```go\033[22m\n"))
+
+			_, _ = w.Write([]byte("}\n"))
+			Expect(out.String()).To(Equal("\033[2m```go\033[22m\n\033[31mfunc\033[39m main() {}\n"))
+		})
+	})
+
+	when("WithoutColor()", func() {
+		it("renders without any escape code, the code blocks and the code spans included", func() {
+			var out bytes.Buffer
+			w := markdown.NewWriter(&out, 0).WithHighlighting().WithoutColor()
+			_, _ = w.Write([]byte("# `x`\n```go\nfunc\n```"))
+			Expect(w.Flush()).To(Succeed())
+
+			Expect(out.String()).To(Equal("x\n```go\nfunc\n```"))
+		})
+		it("renders no escape byte at all from a style of the level None", func() {
+			answer := "# Title\n\n**bold**, *italic*, ~~gone~~, `code` and [a link](https://example.com)\n\n> quoted\n\n---\n\n" +
+				"- item\n\n| a | b |\n|---|--:|\n| 1 | 2 |\n\n```go\nfunc main() { return \"x\" } // done\n```\n\n" +
+				"```diff\ndiff --git a/x b/x\n-old\n+new\n@@ -1 +1 @@\n```\n"
+
+			for _, theme := range []style.Theme{style.Dark, style.Light, style.Mono} {
+				var out bytes.Buffer
+				w := markdown.NewWriter(&out, 0).WithStyle(style.New(theme, style.None)).WithHighlighting()
+				_, _ = w.Write([]byte(answer))
+				Expect(w.Flush()).To(Succeed())
+
+				Expect(out.String()).To(ContainSubstring("func main()"))
+				Expect(out.Bytes()).NotTo(ContainElement(byte(0x1b)))
+			}
+		})
+	})
+
+	when("CodeBlocks()", func() {
+		it("returns the code of the fenced blocks with their language", func() {
+			text := "Try:\n\n```Go title=main.go\nfmt.Println(1)\n```\n\n  ~~~\n  indented\n    more\n  ~~~\nand ````sh\nnot a fence\n"
+
+			Expect(markdown.CodeBlocks(text)).To(Equal([]markdown.CodeBlock{
+				{Language: "go", Code: "fmt.Println(1)"},
+				{Language: "", Code: "indented\n  more"},
+			}))
+		})
+		it("ends a block that isn't closed with the text", func() {
+			Expect(markdown.CodeBlocks("```python\nprint(1)\n````\nprint(2)")).To(Equal([]markdown.CodeBlock{{Language: "python", Code: "print(1)"}}))
+			Expect(markdown.CodeBlocks("````\n```\ncut off")).To(Equal([]markdown.CodeBlock{{Code: "```\ncut off"}}))
+		})
+	})
+
+	when("CodeBlockAt()", func() {
+		text := "```\none\n```\n```\ntwo\n```\n```\nthree\n```"
+
+		it("returns the nth block, counting from the last one when n is negative", func() {
+			for n, code := range map[int]string{1: "one", 3: "three", -1: "three", -3: "one"} {
+				block, err := markdown.CodeBlockAt(text, n)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(block.Code).To(Equal(code))
+			}
+		})
+		it("returns an error for a block that doesn't exist", func() {
+			_, err := markdown.CodeBlockAt(text, 4)
+			Expect(err).To(MatchError("the answer has 3 code block(s), there is no code block 4"))
+
+			_, err = markdown.CodeBlockAt(text, -4)
+			Expect(err).To(HaveOccurred())
+
+			_, err = markdown.CodeBlockAt("no code", -1)
+			Expect(err).To(MatchError(markdown.ErrNoCodeBlock))
+		})
+	})
+
+	when("Code()", func() {
+		it("returns the code of the blocks one after the other", func() {
+			Expect(markdown.Code("First:\n```go\npackage main\n```\nThen:\n```go\nfunc main() {}\n```")).To(Equal("package main\nfunc main() {}"))
+
+			_, err := markdown.Code("no code")
+			Expect(err).To(MatchError(markdown.ErrNoCodeBlock))
+		})
+	})
+}
@@ -0,0 +1,186 @@
+package markdown
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	zeroWidthJoiner = '\u200d'
+	firstSkinTone   = '\U0001f3fb'
+	lastSkinTone    = '\U0001f3ff'
+)
+
+// wide are the runes a terminal shows two columns wide: the East Asian wide and fullwidth ones,
+// such as the CJK ideographs, and the emoji that are shown as emoji by default.
+var wide = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{Lo: 0x1100, Hi: 0x115f, Stride: 1},
+		{Lo: 0x231a, Hi: 0x231b, Stride: 1},
+		{Lo: 0x2329, Hi: 0x232a, Stride: 1},
+		{Lo: 0x23e9, Hi: 0x23ec, Stride: 1},
+		{Lo: 0x23f0, Hi: 0x23f0, Stride: 1},
+		{Lo: 0x23f3, Hi: 0x23f3, Stride: 1},
+		{Lo: 0x25fd, Hi: 0x25fe, Stride: 1},
+		{Lo: 0x2614, Hi: 0x2615, Stride: 1},
+		{Lo: 0x2648, Hi: 0x2653, Stride: 1},
+		{Lo: 0x267f, Hi: 0x267f, Stride: 1},
+		{Lo: 0x2693, Hi: 0x2693, Stride: 1},
+		{Lo: 0x26a1, Hi: 0x26a1, Stride: 1},
+		{Lo: 0x26aa, Hi: 0x26ab, Stride: 1},
+		{Lo: 0x26bd, Hi: 0x26be, Stride: 1},
+		{Lo: 0x26c4, Hi: 0x26c5, Stride: 1},
+		{Lo: 0x26ce, Hi: 0x26ce, Stride: 1},
+		{Lo: 0x26d4, Hi: 0x26d4, Stride: 1},
+		{Lo: 0x26ea, Hi: 0x26ea, Stride: 1},
+		{Lo: 0x26f2, Hi: 0x26f3, Stride: 1},
+		{Lo: 0x26f5, Hi: 0x26f5, Stride: 1},
+		{Lo: 0x26fa, Hi: 0x26fa, Stride: 1},
+		{Lo: 0x26fd, Hi: 0x26fd, Stride: 1},
+		{Lo: 0x2705, Hi: 0x2705, Stride: 1},
+		{Lo: 0x270a, Hi: 0x270b, Stride: 1},
+		{Lo: 0x2728, Hi: 0x2728, Stride: 1},
+		{Lo: 0x274c, Hi: 0x274c, Stride: 1},
+		{Lo: 0x274e, Hi: 0x274e, Stride: 1},
+		{Lo: 0x2753, Hi: 0x2755, Stride: 1},
+		{Lo: 0x2757, Hi: 0x2757, Stride: 1},
+		{Lo: 0x2795, Hi: 0x2797, Stride: 1},
+		{Lo: 0x27b0, Hi: 0x27b0, Stride: 1},
+		{Lo: 0x27bf, Hi: 0x27bf, Stride: 1},
+		{Lo: 0x2b1b, Hi: 0x2b1c, Stride: 1},
+		{Lo: 0x2b50, Hi: 0x2b50, Stride: 1},
+		{Lo: 0x2b55, Hi: 0x2b55, Stride: 1},
+		{Lo: 0x2e80, Hi: 0x303e, Stride: 1},
+		{Lo: 0x3041, Hi: 0x33ff, Stride: 1},
+		{Lo: 0x3400, Hi: 0x4dbf, Stride: 1},
+		{Lo: 0x4e00, Hi: 0x9fff, Stride: 1},
+		{Lo: 0xa000, Hi: 0xa4cf, Stride: 1},
+		{Lo: 0xa960, Hi: 0xa97f, Stride: 1},
+		{Lo: 0xac00, Hi: 0xd7a3, Stride: 1},
+		{Lo: 0xf900, Hi: 0xfaff, Stride: 1},
+		{Lo: 0xfe10, Hi: 0xfe19, Stride: 1},
+		{Lo: 0xfe30, Hi: 0xfe6f, Stride: 1},
+		{Lo: 0xff00, Hi: 0xff60, Stride: 1},
+		{Lo: 0xffe0, Hi: 0xffe6, Stride: 1},
+	},
+	R32: []unicode.Range32{
+		{Lo: 0x16fe0, Hi: 0x18cff, Stride: 1},
+		{Lo: 0x1b000, Hi: 0x1b2ff, Stride: 1},
+		{Lo: 0x1f004, Hi: 0x1f004, Stride: 1},
+		{Lo: 0x1f0cf, Hi: 0x1f0cf, Stride: 1},
+		{Lo: 0x1f18e, Hi: 0x1f18e, Stride: 1},
+		{Lo: 0x1f191, Hi: 0x1f19a, Stride: 1},
+		{Lo: 0x1f200, Hi: 0x1f251, Stride: 1},
+		{Lo: 0x1f300, Hi: 0x1f64f, Stride: 1},
+		{Lo: 0x1f680, Hi: 0x1f6ff, Stride: 1},
+		{Lo: 0x1f7e0, Hi: 0x1f7eb, Stride: 1},
+		{Lo: 0x1f900, Hi: 0x1f9ff, Stride: 1},
+		{Lo: 0x1fa70, Hi: 0x1faff, Stride: 1},
+		{Lo: 0x20000, Hi: 0x3fffd, Stride: 1},
+	},
+}
+
+// Width returns how many columns of a terminal the text takes, without its escape codes. The
+// wide runes, such as the CJK ideographs and the emoji, take two columns, and the combining marks,
+// the variation selectors and the emoji a zero width joiner or a skin tone joins to the one before
+// take none, so a family emoji takes two columns like any other emoji.
+func Width(text string) int {
+	width, previous := 0, rune(0)
+	for _, r := range ansiPattern.ReplaceAllString(text, "") {
+		width += runeWidth(r, previous)
+		previous = r
+	}
+
+	return width
+}
+
+// Wrap returns the lines of the text wrapped at the width, as the Writer wraps its paragraphs:
+// at the spaces between the words, a word wider than the width broken at the width, and the wide
+// runes never split over two lines. A width of 0 doesn't wrap.
+func Wrap(text string, width int) []string {
+	return wrap(text, width, "", "")
+}
+
+// runeWidth returns the columns the rune takes after the previous one.
+func runeWidth(r, previous rune) int {
+	switch {
+	case previous == zeroWidthJoiner:
+		return 0
+	case r >= firstSkinTone && r <= lastSkinTone && unicode.Is(wide, previous):
+		return 0
+	case r == zeroWidthJoiner || unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf, unicode.Cc):
+		return 0
+	case unicode.Is(wide, r):
+		return 2
+	default:
+		return 1
+	}
+}
+
+// wrap returns the lines of the text wrapped at the width, the first after the first prefix and
+// the others after the rest. A word wider than the width is broken.
+func wrap(text string, width int, first, rest string) []string {
+	if width <= 0 {
+		return []string{first + text}
+	}
+
+	var lines []string
+	line, lineWidth := first, Width(first)
+	empty := true
+
+	for _, word := range strings.Fields(text) {
+		wordWidth := Width(word)
+
+		if !empty && lineWidth+1+wordWidth > width {
+			lines = append(lines, line)
+			line, lineWidth, empty = rest, Width(rest), true
+		}
+
+		// a word wider than a line is broken over the lines
+		for lineWidth+wordWidth > width && width-lineWidth > 0 && empty {
+			head, tail := breakWord(word, width-lineWidth)
+			if tail == "" {
+				break
+			}
+			lines = append(lines, line+head)
+			line, lineWidth = rest, Width(rest)
+			word, wordWidth = tail, Width(tail)
+		}
+
+		if !empty {
+			line += " "
+			lineWidth++
+		}
+		line += word
+		lineWidth += wordWidth
+		empty = false
+	}
+
+	return append(lines, line)
+}
+
+// breakWord returns the first n columns of the word and the rest of it, keeping the escape codes
+// whole and the runes that take no column with the one before them. A wide rune that doesn't fit
+// in the n columns goes to the rest, unless it is the first one, which the head always takes.
+func breakWord(word string, n int) (string, string) {
+	count, previous := 0, rune(0)
+	for i := 0; i < len(word); {
+		if loc := ansiPattern.FindStringIndex(word[i:]); loc != nil && loc[0] == 0 {
+			i += loc[1]
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(word[i:])
+		width := runeWidth(r, previous)
+		if width > 0 && count > 0 && count+width > n {
+			return word[:i], word[i:]
+		}
+
+		i += size
+		count += width
+		previous = r
+	}
+
+	return word, ""
+}
@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"expvar"
+	"strconv"
+	"time"
+)
+
+// noModel is the key of the requests that aren't for a model.
+const noModel = "none"
+
+// Expvar publishes the metrics with expvar, as the map of the name, which holds:
+//
+//   - requests, the number of requests by "model/status"
+//   - request_seconds, the total duration of the requests by model
+//   - first_tokens and first_token_seconds, the streamed answers and the total time to their first
+//     content, by model
+//   - prompt_tokens and completion_tokens, the total usage by model
+//   - retries, the number of retries by reason
+type Expvar struct {
+	requests          *expvar.Map
+	requestSeconds    *expvar.Map
+	firstTokens       *expvar.Map
+	firstTokenSeconds *expvar.Map
+	promptTokens      *expvar.Map
+	completionTokens  *expvar.Map
+	retries           *expvar.Map
+}
+
+var _ Metrics = &Expvar{}
+
+// NewExpvar publishes the metrics under the name, which, like every expvar, can only be published
+// once.
+func NewExpvar(name string) *Expvar {
+	root := expvar.NewMap(name)
+
+	child := func(key string) *expvar.Map {
+		result := new(expvar.Map)
+		root.Set(key, result)
+		return result
+	}
+
+	return &Expvar{
+		requests:          child("requests"),
+		requestSeconds:    child("request_seconds"),
+		firstTokens:       child("first_tokens"),
+		firstTokenSeconds: child("first_token_seconds"),
+		promptTokens:      child("prompt_tokens"),
+		completionTokens:  child("completion_tokens"),
+		retries:           child("retries"),
+	}
+}
+
+func (e *Expvar) ObserveRequest(duration time.Duration, model string, status int) {
+	model = modelKey(model)
+	e.requests.Add(model+"/"+strconv.Itoa(status), 1)
+	e.requestSeconds.AddFloat(model, duration.Seconds())
+}
+
+func (e *Expvar) ObserveFirstToken(duration time.Duration, model string) {
+	model = modelKey(model)
+	e.firstTokens.Add(model, 1)
+	e.firstTokenSeconds.AddFloat(model, duration.Seconds())
+}
+
+func (e *Expvar) ObserveTokens(prompt, completion int, model string) {
+	model = modelKey(model)
+	e.promptTokens.Add(model, int64(prompt))
+	e.completionTokens.Add(model, int64(completion))
+}
+
+func (e *Expvar) ObserveRetry(reason string) {
+	e.retries.Add(reason, 1)
+}
+
+func modelKey(model string) string {
+	if model == "" {
+		return noModel
+	}
+
+	return model
+}
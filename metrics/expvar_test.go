@@ -0,0 +1,49 @@
+package metrics_test
+
+import (
+	"encoding/json"
+	"expvar"
+	"github.com/kardolus/chatgpt-cli/metrics"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitMetrics(t *testing.T) {
+	spec.Run(t, "Testing the metrics", testMetrics, spec.Report(report.Terminal{}))
+}
+
+func testMetrics(t *testing.T, when spec.G, it spec.S) {
+	it.Before(func() {
+		RegisterTestingT(t)
+	})
+
+	when("Expvar", func() {
+		it("publishes the metrics by model, status and reason", func() {
+			subject := metrics.NewExpvar("chatgpt_test")
+
+			subject.ObserveRequest(1500*time.Millisecond, "gpt-4o", 200)
+			subject.ObserveRequest(500*time.Millisecond, "gpt-4o", 200)
+			subject.ObserveRequest(250*time.Millisecond, "gpt-4o", 429)
+			subject.ObserveRequest(100*time.Millisecond, "", 0)
+			subject.ObserveFirstToken(300*time.Millisecond, "gpt-4o")
+			subject.ObserveTokens(120, 30, "gpt-4o")
+			subject.ObserveTokens(80, 20, "gpt-4o")
+			subject.ObserveRetry(metrics.RetryInvalidJSON)
+
+			var published map[string]map[string]float64
+			Expect(json.Unmarshal([]byte(expvar.Get("chatgpt_test").String()), &published)).To(Succeed())
+
+			Expect(published["requests"]).To(Equal(map[string]float64{"gpt-4o/200": 2, "gpt-4o/429": 1, "none/0": 1}))
+			Expect(published["request_seconds"]).To(Equal(map[string]float64{"gpt-4o": 2.25, "none": 0.1}))
+			Expect(published["first_tokens"]).To(Equal(map[string]float64{"gpt-4o": 1}))
+			Expect(published["first_token_seconds"]).To(Equal(map[string]float64{"gpt-4o": 0.3}))
+			Expect(published["prompt_tokens"]).To(Equal(map[string]float64{"gpt-4o": 200}))
+			Expect(published["completion_tokens"]).To(Equal(map[string]float64{"gpt-4o": 50}))
+			Expect(published["retries"]).To(Equal(map[string]float64{metrics.RetryInvalidJSON: 1}))
+		})
+	})
+}
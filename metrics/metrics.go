@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"time"
+)
+
+// RetryInvalidJSON is the reason of a retry that asks the model to correct an answer that isn't
+// valid JSON.
+const RetryInvalidJSON = "invalid_json"
+
+// Metrics is told about the requests of the client, for a collector such as Prometheus or StatsD
+// to record, without the client depending on one. It is called from the goroutines that send the
+// requests, so it must be safe for concurrent use.
+type Metrics interface {
+	// ObserveRequest is called once a request is done. The status is the HTTP status of the
+	// response, 0 when the request failed without one. The model is empty for the requests that
+	// aren't completions, such as listing the models.
+	ObserveRequest(duration time.Duration, model string, status int)
+	// ObserveFirstToken is called for a streamed answer once its first content arrived.
+	ObserveFirstToken(duration time.Duration, model string)
+	// ObserveTokens is called with the usage of an answer, when the API reported it.
+	ObserveTokens(prompt, completion int, model string)
+	// ObserveRetry is called when a request is sent again, such as RetryInvalidJSON.
+	ObserveRetry(reason string)
+}
+
+// Nop discards the metrics, it is what a client reports to unless it is given Metrics.
+type Nop struct{}
+
+func (Nop) ObserveRequest(time.Duration, string, int) {}
+
+func (Nop) ObserveFirstToken(time.Duration, string) {}
+
+func (Nop) ObserveTokens(int, int, string) {}
+
+func (Nop) ObserveRetry(string) {}
@@ -0,0 +1,125 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Bell is the name of the fallback of Send, the bell of the terminal.
+const Bell = "the terminal bell"
+
+// timeout is how long a command has to show the notification, so a notifier that hangs, such as
+// one waiting for a session bus that isn't there, doesn't hold the CLI.
+const timeout = 5 * time.Second
+
+// Notification is what the desktop notification of a query tells.
+type Notification struct {
+	Title   string
+	Message string
+}
+
+// Of returns the notification of a query that took the elapsed time: the first line of its answer,
+// or the error it failed with.
+func Of(answer string, err error, elapsed time.Duration) Notification {
+	elapsed = elapsed.Round(100 * time.Millisecond)
+
+	if err != nil {
+		return Notification{Title: fmt.Sprintf("chatgpt failed after %s", elapsed), Message: firstLine(err.Error())}
+	}
+
+	message := firstLine(answer)
+	if message == "" {
+		message = "The answer is ready"
+	}
+
+	return Notification{Title: fmt.Sprintf("chatgpt answered in %s", elapsed), Message: message}
+}
+
+// firstLine returns the first line of the text that isn't blank.
+func firstLine(text string) string {
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+
+	return ""
+}
+
+// Commands returns the commands that show the notification on the operating system, in the order
+// they are tried: notify-send on Linux and the BSDs, terminal-notifier or else osascript on macOS, and
+// a toast of PowerShell on Windows, and under WSL, whose notifications are the ones of Windows.
+func Commands(goos string, getenv func(string) string, n Notification) [][]string {
+	switch goos {
+	case "darwin":
+		script := fmt.Sprintf("display notification %s with title %s", appleScriptString(n.Message), appleScriptString(n.Title))
+		return [][]string{
+			{"terminal-notifier", "-title", n.Title, "-message", n.Message},
+			{"osascript", "-e", script},
+		}
+	case "windows":
+		return [][]string{{"powershell", "-NoProfile", "-NonInteractive", "-Command", toastScript(n)}}
+	}
+
+	var result [][]string
+	if getenv("WSL_DISTRO_NAME") != "" {
+		result = append(result, []string{"powershell.exe", "-NoProfile", "-NonInteractive", "-Command", toastScript(n)})
+	}
+
+	return append(result, []string{"notify-send", "--app-name", "chatgpt-cli", n.Title, n.Message})
+}
+
+// appleScriptString quotes the text as a string of AppleScript.
+func appleScriptString(text string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(text) + `"`
+}
+
+// toastScript returns the PowerShell script that shows the notification as a toast of Windows. The
+// texts are single-quoted strings of PowerShell, in which nothing but a doubled quote is special.
+func toastScript(n Notification) string {
+	quote := func(text string) string {
+		return "'" + strings.ReplaceAll(text, "'", "''") + "'"
+	}
+
+	return strings.Join([]string{
+		"[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null",
+		"$template = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)",
+		"$texts = $template.GetElementsByTagName('text')",
+		"$texts.Item(0).AppendChild($template.CreateTextNode(" + quote(n.Title) + ")) > $null",
+		"$texts.Item(1).AppendChild($template.CreateTextNode(" + quote(n.Message) + ")) > $null",
+		"[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('chatgpt-cli').Show([Windows.UI.Notifications.ToastNotification]::new($template))",
+	}, "; ")
+}
+
+// Send shows the notification with the first of the Commands that is installed, or rings the bell
+// on the terminal when none is, and returns what notified. The bell is nil without a terminal.
+func Send(n Notification, bell io.Writer) (string, error) {
+	for _, command := range Commands(runtime.GOOS, os.Getenv, n) {
+		if _, err := exec.LookPath(command[0]); err != nil {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err := exec.CommandContext(ctx, command[0], command[1:]...).Run()
+		cancel()
+		if err != nil {
+			return command[0], fmt.Errorf("%s failed: %w", command[0], err)
+		}
+
+		return command[0], nil
+	}
+
+	if bell == nil {
+		return "", errors.New("no notifier found, install notify-send, or run on a terminal for its bell")
+	}
+
+	_, err := io.WriteString(bell, "\a")
+	return Bell, err
+}
@@ -0,0 +1,67 @@
+package notify_test
+
+import (
+	"errors"
+	"github.com/kardolus/chatgpt-cli/notify"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitNotify(t *testing.T) {
+	spec.Run(t, "Testing the notifications", testNotify, spec.Report(report.Terminal{}))
+}
+
+func testNotify(t *testing.T, when spec.G, it spec.S) {
+	it.Before(func() {
+		RegisterTestingT(t)
+	})
+
+	when("Of()", func() {
+		it("tells the first line of the answer and the elapsed time", func() {
+			n := notify.Of("\n  The race is in main.go.\nMore details.", nil, 23456*time.Millisecond)
+			Expect(n).To(Equal(notify.Notification{Title: "chatgpt answered in 23.5s", Message: "The race is in main.go."}))
+		})
+		it("tells that the answer is ready when it has no text to show", func() {
+			Expect(notify.Of("", nil, 20*time.Second).Message).To(Equal("The answer is ready"))
+		})
+		it("tells the error of a failed query", func() {
+			n := notify.Of("", errors.New("rate limited\nretry later"), 16*time.Second)
+			Expect(n).To(Equal(notify.Notification{Title: "chatgpt failed after 16s", Message: "rate limited"}))
+		})
+	})
+
+	when("Commands()", func() {
+		n := notify.Notification{Title: "chatgpt answered in 20s", Message: `It's "done" \o/`}
+		env := func(vars map[string]string) func(string) string {
+			return func(key string) string { return vars[key] }
+		}
+
+		it("uses notify-send on Linux, after the toast of Windows under WSL", func() {
+			Expect(notify.Commands("linux", env(nil), n)).To(Equal([][]string{
+				{"notify-send", "--app-name", "chatgpt-cli", n.Title, n.Message},
+			}))
+
+			commands := notify.Commands("linux", env(map[string]string{"WSL_DISTRO_NAME": "Ubuntu"}), n)
+			Expect(commands).To(HaveLen(2))
+			Expect(commands[0][0]).To(Equal("powershell.exe"))
+		})
+		it("uses terminal-notifier, or else osascript with the texts quoted, on macOS", func() {
+			Expect(notify.Commands("darwin", env(nil), n)).To(Equal([][]string{
+				{"terminal-notifier", "-title", n.Title, "-message", n.Message},
+				{"osascript", "-e", `display notification "It's \"done\" \\o/" with title "chatgpt answered in 20s"`},
+			}))
+		})
+		it("shows a toast with the texts quoted for PowerShell on Windows", func() {
+			commands := notify.Commands("windows", env(nil), n)
+			Expect(commands).To(HaveLen(1))
+			Expect(commands[0][:4]).To(Equal([]string{"powershell", "-NoProfile", "-NonInteractive", "-Command"}))
+			Expect(commands[0][4]).To(ContainSubstring(`CreateTextNode('It''s "done" \o/')`))
+			Expect(strings.Count(commands[0][4], "CreateTextNode")).To(Equal(2))
+		})
+	})
+}
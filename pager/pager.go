@@ -0,0 +1,76 @@
+package pager
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// Pager shows a long output a screen at a time in the pager of Command, which reads the output on
+// its stdin and is waited for.
+type Pager struct {
+	Command []string
+	Stdout  io.Writer
+	Stderr  io.Writer
+}
+
+// New returns a pager that runs the command on the terminal of the CLI.
+func New(command []string) *Pager {
+	return &Pager{Command: command, Stdout: os.Stdout, Stderr: os.Stderr}
+}
+
+// Command returns the pager the user picked, with its arguments, such as less -R: the one of the
+// setting, the one of PAGER, or else less, which shows the colors of the rendered Markdown with -R,
+// quits by itself when the output fits the screen with -F, and leaves the output on the screen once
+// quit with -X.
+func Command(setting string, getenv func(string) string) []string {
+	if fields := strings.Fields(setting); len(fields) > 0 {
+		return fields
+	}
+	if fields := strings.Fields(getenv("PAGER")); len(fields) > 0 {
+		return fields
+	}
+
+	return []string{"less", "-RFX"}
+}
+
+// Long tells whether the text has more lines than the height of the screen, which a pager is only
+// needed for. A screen whose height isn't known doesn't need one.
+func Long(text string, height int) bool {
+	return height > 0 && strings.Count(strings.TrimRight(text, "\n"), "\n")+1 > height
+}
+
+// Page writes the text to the pager. Without a pager, or when the pager isn't installed, the text
+// is written to Stdout as it is, so the output is never lost. A pager that is quit before it read
+// the whole text, such as with q in less, or that was killed by the SIGPIPE of that, isn't an error.
+func (p *Pager) Page(text string) error {
+	if len(p.Command) == 0 {
+		_, err := io.WriteString(p.Stdout, text)
+		return err
+	}
+
+	cmd := exec.Command(p.Command[0], p.Command[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = strings.NewReader(text), p.Stdout, p.Stderr
+
+	if err := cmd.Run(); err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			_, err := io.WriteString(p.Stdout, text)
+			return err
+		}
+
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() && status.Signal() == syscall.SIGPIPE {
+				return nil
+			}
+			return fmt.Errorf("the pager %s exited with status %d", p.Command[0], exitErr.ExitCode())
+		}
+		return fmt.Errorf("failed to run the pager %s: %w", p.Command[0], err)
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package pager_test
+
+import (
+	"bytes"
+	"github.com/kardolus/chatgpt-cli/pager"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitPager(t *testing.T) {
+	spec.Run(t, "Testing the pager", testPager, spec.Report(report.Terminal{}))
+}
+
+func testPager(t *testing.T, when spec.G, it spec.S) {
+	var stdout *bytes.Buffer
+
+	it.Before(func() {
+		RegisterTestingT(t)
+
+		stdout = &bytes.Buffer{}
+	})
+
+	page := func(command ...string) *pager.Pager {
+		p := pager.New(command)
+		p.Stdout, p.Stderr = stdout, nil
+		return p
+	}
+
+	when("Command()", func() {
+		it("uses the setting, or else PAGER, with its arguments", func() {
+			env := map[string]string{"PAGER": "more -s"}
+			Expect(pager.Command("", func(key string) string { return env[key] })).To(Equal([]string{"more", "-s"}))
+			Expect(pager.Command("most -w", func(key string) string { return env[key] })).To(Equal([]string{"most", "-w"}))
+		})
+		it("falls back to less, which keeps the colors and quits by itself for a short output", func() {
+			Expect(pager.Command(" ", func(string) string { return " " })).To(Equal([]string{"less", "-RFX"}))
+		})
+	})
+
+	when("Long()", func() {
+		it("tells whether the text has more lines than the screen", func() {
+			Expect(pager.Long("one\ntwo\nthree\n", 3)).To(BeFalse())
+			Expect(pager.Long("one\ntwo\nthree\nfour", 3)).To(BeTrue())
+			Expect(pager.Long(strings.Repeat("line\n", 100), 0)).To(BeFalse())
+		})
+	})
+
+	when("Page()", func() {
+		it("writes the text to the stdin of the pager", func() {
+			Expect(page("sh", "-c", "tr a-z A-Z").Page("paged text\n")).To(Succeed())
+			Expect(stdout.String()).To(Equal("PAGED TEXT\n"))
+		})
+		it("writes the text as it is without a pager, or when it isn't installed", func() {
+			Expect(page().Page("first\n")).To(Succeed())
+			Expect(page("chatgpt-cli-missing-pager").Page("second\n")).To(Succeed())
+			Expect(stdout.String()).To(Equal("first\nsecond\n"))
+		})
+		it("doesn't report a pager that is quit before it read the whole text", func() {
+			long := strings.Repeat("a line of a long answer\n", 100000)
+			Expect(page("sh", "-c", "head -c 10").Page(long)).To(Succeed())
+			Expect(stdout.String()).To(Equal("a line of "))
+
+			Expect(page("sh", "-c", "kill -PIPE $$").Page(long)).To(Succeed())
+		})
+		it("reports a pager that fails", func() {
+			Expect(page("sh", "-c", "exit 3").Page("text")).To(MatchError("the pager sh exited with status 3"))
+		})
+	})
+}
@@ -0,0 +1,110 @@
+// Package paste tells the newlines that continue a line of the interactive mode apart from the Enter
+// that sends it: the newlines of a bracketed paste, and Alt+Enter.
+package paste
+
+import (
+	"bytes"
+	"io"
+	"unicode/utf8"
+)
+
+// Newline is what the Reader reads for a newline that doesn't send the line, and Tab for a tab of a
+// paste, which the line editor would take for a completion. They are runes of the private use area,
+// which no keyboard types.
+const (
+	Newline = '\uE00A'
+	Tab     = '\uE009'
+)
+
+const (
+	// Enable turns the bracketed paste of the terminal on, it then marks the start and the end of
+	// what is pasted, and Disable turns it off
+	Enable  = "\033[?2004h"
+	Disable = "\033[?2004l"
+
+	escape     = 0x1b
+	pasteStart = "\033[200~"
+	pasteEnd   = "\033[201~"
+)
+
+// Reader reads the keys of a terminal with the newlines of a bracketed paste, and Alt+Enter, which
+// sends an escape before the Enter, as a Newline, and the tabs of a paste as a Tab. The marks of the
+// paste are dropped.
+type Reader struct {
+	in      io.ReadCloser
+	pending []byte
+	out     []byte
+	pasting bool
+	cr      bool
+}
+
+// NewReader returns the Reader of the keys read from in.
+func NewReader(in io.ReadCloser) *Reader {
+	return &Reader{in: in}
+}
+
+// Read reads the keys, once the sequence that ends what was read, if any, is complete.
+func (r *Reader) Read(p []byte) (int, error) {
+	chunk := make([]byte, max(len(p), 64))
+	for len(r.out) == 0 {
+		n, err := r.in.Read(chunk)
+		r.pending = append(r.pending, chunk[:n]...)
+		r.translate(err != nil)
+
+		if err != nil && len(r.out) == 0 {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+// Close closes the keys.
+func (r *Reader) Close() error {
+	return r.in.Close()
+}
+
+// translate moves the pending keys to the output, with the newlines that continue the line as a
+// Newline. The start of a sequence that may be a mark or Alt+Enter stays pending, unless the keys
+// ended.
+func (r *Reader) translate(ended bool) {
+	newline, tab := utf8.AppendRune(nil, Newline), utf8.AppendRune(nil, Tab)
+
+	data := r.pending
+	for len(data) > 0 {
+		switch {
+		case bytes.HasPrefix(data, []byte(pasteStart)):
+			r.pasting, r.cr = true, false
+			data = data[len(pasteStart):]
+			continue
+		case bytes.HasPrefix(data, []byte(pasteEnd)):
+			r.pasting = false
+			data = data[len(pasteEnd):]
+			continue
+		case data[0] == escape && len(data) > 1 && (data[1] == '\r' || data[1] == '\n'):
+			r.out = append(r.out, newline...)
+			data = data[2:]
+			continue
+		case !ended && data[0] == escape && (bytes.HasPrefix([]byte(pasteStart), data) || bytes.HasPrefix([]byte(pasteEnd), data)):
+			r.pending = append([]byte(nil), data...)
+			return
+		}
+
+		switch b := data[0]; {
+		case r.pasting && b == '\n' && r.cr:
+			// the newline of a \r\n was read with its \r
+		case r.pasting && (b == '\r' || b == '\n'):
+			r.out = append(r.out, newline...)
+		case r.pasting && b == '\t':
+			r.out = append(r.out, tab...)
+		default:
+			r.out = append(r.out, b)
+		}
+		r.cr = r.pasting && data[0] == '\r'
+		data = data[1:]
+	}
+
+	r.pending = nil
+}
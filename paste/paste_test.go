@@ -0,0 +1,51 @@
+package paste_test
+
+import (
+	"github.com/kardolus/chatgpt-cli/paste"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitPaste(t *testing.T) {
+	spec.Run(t, "Testing the pastes", testPaste, spec.Report(report.Terminal{}))
+}
+
+func testPaste(t *testing.T, when spec.G, it spec.S) {
+	it.Before(func() {
+		RegisterTestingT(t)
+	})
+
+	read := func(keys string, oneByte bool) string {
+		var in io.Reader = strings.NewReader(keys)
+		if oneByte {
+			in = iotest.OneByteReader(in)
+		}
+
+		data, err := io.ReadAll(paste.NewReader(io.NopCloser(in)))
+		Expect(err).NotTo(HaveOccurred())
+		return string(data)
+	}
+	newline, tab := string(paste.Newline), string(paste.Tab)
+
+	when("Read()", func() {
+		it("reads the newlines of a paste as a Newline, without its marks, a key at a time too", func() {
+			keys := "see \033[200~panic: boom\r\ngoroutine 1\n\tmain.go:12\033[201~ why?\r"
+			expected := "see panic: boom" + newline + "goroutine 1" + newline + tab + "main.go:12 why?\r"
+
+			Expect(read(keys, false)).To(Equal(expected))
+			Expect(read(keys, true)).To(Equal(expected))
+		})
+		it("reads Alt+Enter as a Newline, and leaves the tabs that were typed", func() {
+			Expect(read("first\033\rsecond\t\r", true)).To(Equal("first" + newline + "second\t\r"))
+		})
+		it("leaves the other escape sequences and a lone escape as they are", func() {
+			Expect(read("\033[A\033[2~x\033", false)).To(Equal("\033[A\033[2~x\033"))
+		})
+	})
+}
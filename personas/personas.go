@@ -0,0 +1,163 @@
+package personas
+
+import (
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/types"
+	"github.com/kardolus/chatgpt-cli/utils"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	DefaultFile   = "personas.yaml"
+	DefaultDir    = "personas"
+	yamlExtension = ".yaml"
+)
+
+// Personas holds named persona presets.
+type Personas struct {
+	personas map[string]types.Persona
+}
+
+func New() *Personas {
+	return &Personas{
+		personas: make(map[string]types.Persona),
+	}
+}
+
+// Load returns the personas defined in the personas.yaml file and the personas directory of the
+// config home. The personas in the directory take precedence over the ones in the file.
+func Load() (*Personas, error) {
+	configHome, err := utils.GetConfigHome()
+	if err != nil {
+		return nil, err
+	}
+
+	result := New()
+
+	if err := result.LoadFile(filepath.Join(configHome, DefaultFile)); err != nil {
+		return nil, err
+	}
+
+	if err := result.LoadDir(filepath.Join(configHome, DefaultDir)); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Register stores the persona under its name, replacing any persona of the same name.
+func (p *Personas) Register(persona types.Persona) {
+	p.personas[persona.Name] = persona
+}
+
+// LoadFile registers the personas of a YAML file that maps names to personas. A missing file is
+// not an error.
+func (p *Personas) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var personas map[string]types.Persona
+	if err := yaml.Unmarshal(data, &personas); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	for name, persona := range personas {
+		persona.Name = name
+		p.Register(persona)
+	}
+
+	return nil
+}
+
+// LoadDir registers every file with the .yaml extension in dir as a single persona. The persona is
+// named after the file unless the file sets a name. A missing directory is not an error.
+func (p *Personas) LoadDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != yamlExtension {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		var persona types.Persona
+		if err := yaml.Unmarshal(data, &persona); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+
+		if persona.Name == "" {
+			persona.Name = strings.TrimSuffix(entry.Name(), yamlExtension)
+		}
+		p.Register(persona)
+	}
+
+	return nil
+}
+
+// Names returns the names of the registered personas in alphabetical order.
+func (p *Personas) Names() []string {
+	var result []string
+	for name := range p.personas {
+		result = append(result, name)
+	}
+
+	sort.Strings(result)
+	return result
+}
+
+// Get returns the named persona. The error for an unknown name lists the available personas.
+func (p *Personas) Get(name string) (types.Persona, error) {
+	persona, ok := p.personas[name]
+	if ok {
+		return persona, nil
+	}
+
+	names := p.Names()
+	if len(names) == 0 {
+		return types.Persona{}, fmt.Errorf("unknown persona %s, no personas are available", name)
+	}
+
+	return types.Persona{}, fmt.Errorf("unknown persona %s, available personas: %s", name, strings.Join(names, ", "))
+}
+
+// Apply returns a copy of cfg that uses the system prompt of the persona, and its model,
+// temperature and project when they are set.
+func Apply(cfg types.Config, persona types.Persona) types.Config {
+	cfg.Persona = persona.Name
+	cfg.Role = persona.Role
+
+	if persona.Model != "" {
+		cfg.Model = utils.ResolveModel(cfg.ModelAliases, persona.Model)
+	}
+
+	if persona.Temperature != nil {
+		cfg.Temperature = *persona.Temperature
+	}
+
+	if persona.Project != "" {
+		cfg.Project = persona.Project
+	}
+
+	return cfg
+}
@@ -0,0 +1,139 @@
+package personas_test
+
+import (
+	"github.com/kardolus/chatgpt-cli/personas"
+	"github.com/kardolus/chatgpt-cli/types"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitPersonas(t *testing.T) {
+	spec.Run(t, "Testing the personas", testPersonas, spec.Report(report.Terminal{}))
+}
+
+func testPersonas(t *testing.T, when spec.G, it spec.S) {
+	var (
+		subject *personas.Personas
+		dir     string
+	)
+
+	it.Before(func() {
+		RegisterTestingT(t)
+		subject = personas.New()
+		dir = t.TempDir()
+	})
+
+	when("LoadFile()", func() {
+		it("registers the personas by name", func() {
+			content := `
+sql:
+  role: You review SQL queries.
+  model: gpt-4o
+  temperature: 0.2
+shell:
+  role: You are a terse shell expert.
+`
+			path := filepath.Join(dir, personas.DefaultFile)
+			Expect(os.WriteFile(path, []byte(content), 0644)).To(Succeed())
+
+			Expect(subject.LoadFile(path)).To(Succeed())
+			Expect(subject.Names()).To(Equal([]string{"shell", "sql"}))
+
+			temperature := 0.2
+
+			persona, err := subject.Get("sql")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(persona).To(Equal(types.Persona{
+				Name:        "sql",
+				Role:        "You review SQL queries.",
+				Model:       "gpt-4o",
+				Temperature: &temperature,
+			}))
+
+			persona, err = subject.Get("shell")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(persona.Temperature).To(BeNil())
+		})
+		it("ignores a missing file", func() {
+			Expect(subject.LoadFile(filepath.Join(dir, "missing.yaml"))).To(Succeed())
+			Expect(subject.Names()).To(BeEmpty())
+		})
+		it("returns an error for an invalid file", func() {
+			path := filepath.Join(dir, personas.DefaultFile)
+			Expect(os.WriteFile(path, []byte("sql: ["), 0644)).To(Succeed())
+
+			err := subject.LoadFile(path)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(path))
+		})
+	})
+
+	when("LoadDir()", func() {
+		it("names the personas after their files unless they set a name", func() {
+			Expect(os.WriteFile(filepath.Join(dir, "explainer.yaml"), []byte("role: You patiently explain."), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, "other.yaml"), []byte("name: named\nrole: You have a name."), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a persona"), 0644)).To(Succeed())
+
+			Expect(subject.LoadDir(dir)).To(Succeed())
+			Expect(subject.Names()).To(Equal([]string{"explainer", "named"}))
+		})
+		it("ignores a missing directory", func() {
+			Expect(subject.LoadDir(filepath.Join(dir, "missing"))).To(Succeed())
+		})
+	})
+
+	when("Get()", func() {
+		it("lists the available personas for an unknown name", func() {
+			subject.Register(types.Persona{Name: "sql"})
+			subject.Register(types.Persona{Name: "shell"})
+
+			_, err := subject.Get("unknown")
+			Expect(err).To(MatchError("unknown persona unknown, available personas: shell, sql"))
+		})
+		it("mentions that there are no personas", func() {
+			_, err := subject.Get("unknown")
+			Expect(err).To(MatchError("unknown persona unknown, no personas are available"))
+		})
+	})
+
+	when("Apply()", func() {
+		it("only overrides the model and temperature when they are set", func() {
+			cfg := types.Config{Role: "old", Model: "gpt-3.5-turbo", Temperature: 1}
+
+			result := personas.Apply(cfg, types.Persona{Name: "shell", Role: "new"})
+			Expect(result.Persona).To(Equal("shell"))
+			Expect(result.Role).To(Equal("new"))
+			Expect(result.Model).To(Equal("gpt-3.5-turbo"))
+			Expect(result.Temperature).To(Equal(1.0))
+
+			temperature := 0.2
+			result = personas.Apply(cfg, types.Persona{Name: "sql", Role: "new", Model: "gpt-4o", Temperature: &temperature})
+			Expect(result.Model).To(Equal("gpt-4o"))
+			Expect(result.Temperature).To(Equal(0.2))
+		})
+		it("resolves the model of the persona when it is an alias", func() {
+			cfg := types.Config{Model: "gpt-3.5-turbo", ModelAliases: map[string]string{"fast": "gpt-4o-mini"}}
+
+			Expect(personas.Apply(cfg, types.Persona{Name: "sql", Model: "fast"}).Model).To(Equal("gpt-4o-mini"))
+			Expect(personas.Apply(cfg, types.Persona{Name: "sql", Model: "gpt-4o"}).Model).To(Equal("gpt-4o"))
+		})
+		it("applies a temperature of 0", func() {
+			cfg := types.Config{Role: "old", Temperature: 1}
+
+			temperature := 0.0
+			result := personas.Apply(cfg, types.Persona{Name: "sql", Role: "new", Temperature: &temperature})
+			Expect(result.Temperature).To(BeZero())
+		})
+		it("overrides the project when the persona sets one", func() {
+			cfg := types.Config{Role: "old", Project: "proj_default"}
+
+			Expect(personas.Apply(cfg, types.Persona{Name: "sql"}).Project).To(Equal("proj_default"))
+			Expect(personas.Apply(cfg, types.Persona{Name: "sql", Project: "proj_analytics"}).Project).To(Equal("proj_analytics"))
+		})
+	})
+}
@@ -0,0 +1,261 @@
+package picker
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrCanceled is a picker that was left without picking, with Esc or Ctrl-C, or once its input
+// ended.
+var ErrCanceled = errors.New("nothing was picked")
+
+// Height is how many of the items the picker shows at once, it scrolls to the highlighted one.
+const Height = 10
+
+const (
+	ctrlC     = 3
+	ctrlD     = 4
+	backspace = 8
+	ctrlN     = 14
+	ctrlP     = 16
+	ctrlU     = 21
+	escape    = 27
+	del       = 127
+
+	// hideCursor and showCursor hide the cursor while the picker draws, clearDown erases what is
+	// below it
+	hideCursor = "\033[?25l"
+	showCursor = "\033[?25h"
+	clearDown  = "\033[J"
+)
+
+// Picker picks one of the Items, such as the threads of the history. Run filters them as the query
+// is typed, on a terminal in raw mode, and Menu numbers them, for the terminals that aren't.
+type Picker struct {
+	// Prompt is what the query is typed after, such as "Thread: "
+	Prompt string
+	// Items are the lines of the items, which the query is matched against
+	Items []string
+	// Preview returns the lines that preview the item of the index while it is highlighted, it can
+	// be nil
+	Preview func(index int) []string
+	// Width is the width of the terminal, longer lines are cut at it. It is 0 when it isn't known,
+	// which doesn't cut them.
+	Width int
+}
+
+// Score tells whether all the runes of the query are in the text, in their order, and how well it
+// matches: the matches that start a word or follow the previous one count more, so "gp" ranks
+// "go-parser" before "golang-tips". The case is ignored.
+func Score(query, text string) (int, bool) {
+	if query == "" {
+		return 0, true
+	}
+
+	var (
+		score    int
+		previous = -2
+		position int
+		prev     rune
+	)
+
+	runes := []rune(strings.ToLower(query))
+	for i, r := range []rune(strings.ToLower(text)) {
+		if position == len(runes) {
+			break
+		}
+		if r != runes[position] {
+			prev = r
+			continue
+		}
+
+		score++
+		if previous == i-1 {
+			score += 2
+		}
+		if i == 0 || !unicode.IsLetter(prev) && !unicode.IsDigit(prev) {
+			score += 3
+		}
+
+		previous, prev = i, r
+		position++
+	}
+
+	return score, position == len(runes)
+}
+
+// Filter returns the indexes of the items that match the query, the best matches first, and in
+// their order for the ones that match as well.
+func Filter(items []string, query string) []int {
+	var (
+		result []int
+		scores = make(map[int]int)
+	)
+
+	for i, item := range items {
+		if score, ok := Score(query, item); ok {
+			result = append(result, i)
+			scores[i] = score
+		}
+	}
+
+	sort.SliceStable(result, func(a, b int) bool {
+		return scores[result[a]] > scores[result[b]]
+	})
+
+	return result
+}
+
+// Run lets the user filter the items by typing a query, move the highlight with the arrows, Ctrl-P
+// and Ctrl-N, and pick the highlighted item with Enter, and returns its index. It reads the keys
+// from in, which has to be a terminal in raw mode, and draws on out, which it leaves as it found it.
+// Esc and Ctrl-C are an ErrCanceled.
+func (p *Picker) Run(in io.Reader, out io.Writer) (int, error) {
+	var (
+		reader   = bufio.NewReader(in)
+		query    []rune
+		selected int
+		drawn    int
+	)
+
+	_, _ = io.WriteString(out, hideCursor)
+	defer func() {
+		p.erase(out, drawn)
+		_, _ = io.WriteString(out, showCursor)
+	}()
+
+	for {
+		matches := Filter(p.Items, string(query))
+		selected = min(selected, max(len(matches)-1, 0))
+		drawn = p.draw(out, string(query), matches, selected, drawn)
+
+		r, _, err := reader.ReadRune()
+		if err != nil {
+			return 0, ErrCanceled
+		}
+
+		switch r {
+		case '\r', '\n':
+			if len(matches) > 0 {
+				return matches[selected], nil
+			}
+		case ctrlC, ctrlD:
+			return 0, ErrCanceled
+		case escape:
+			// the arrows are escape sequences, Esc alone cancels
+			next, _, err := reader.ReadRune()
+			if err != nil || next != '[' && next != 'O' {
+				return 0, ErrCanceled
+			}
+
+			switch key, _, _ := reader.ReadRune(); key {
+			case 'A':
+				selected = max(selected-1, 0)
+			case 'B':
+				selected++
+			}
+		case ctrlP:
+			selected = max(selected-1, 0)
+		case ctrlN:
+			selected++
+		case backspace, del:
+			if len(query) > 0 {
+				query, selected = query[:len(query)-1], 0
+			}
+		case ctrlU:
+			query, selected = nil, 0
+		default:
+			if unicode.IsPrint(r) {
+				query, selected = append(query, r), 0
+			}
+		}
+	}
+}
+
+// draw erases the lines drawn before and draws the query, the items around the selected one and its
+// preview, and returns how many lines it drew.
+func (p *Picker) draw(out io.Writer, query string, matches []int, selected, drawn int) int {
+	p.erase(out, drawn)
+
+	lines := []string{p.Prompt + query}
+
+	start := max(0, min(selected-Height/2, len(matches)-Height))
+	for i := start; i < len(matches) && i < start+Height; i++ {
+		marker := "  "
+		if i == selected {
+			marker = "> "
+		}
+		lines = append(lines, marker+p.Items[matches[i]])
+	}
+	lines = append(lines, fmt.Sprintf("  %d/%d", len(matches), len(p.Items)))
+
+	if p.Preview != nil && len(matches) > 0 {
+		rule := 40
+		if p.Width > 0 {
+			rule = min(rule, p.Width)
+		}
+		lines = append(lines, strings.Repeat("─", rule))
+		lines = append(lines, p.Preview(matches[selected])...)
+	}
+
+	for i := range lines {
+		lines[i] = cut(lines[i], p.Width)
+	}
+	_, _ = io.WriteString(out, strings.Join(lines, "\r\n"))
+
+	return len(lines)
+}
+
+// erase moves the cursor to the start of the first of the lines drawn and erases them.
+func (p *Picker) erase(out io.Writer, drawn int) {
+	if drawn == 0 {
+		return
+	}
+
+	move := "\r"
+	if drawn > 1 {
+		move += fmt.Sprintf("\033[%dA", drawn-1)
+	}
+	_, _ = io.WriteString(out, move+clearDown)
+}
+
+// Menu numbers the items on out and returns the index of the one whose number is read from in, a
+// line at a time, for a terminal that can't be put in raw mode. A number that isn't one of the items
+// is asked again, and the end of the input is an ErrCanceled.
+func (p *Picker) Menu(in io.Reader, out io.Writer) (int, error) {
+	for i, item := range p.Items {
+		_, _ = fmt.Fprintf(out, "%d) %s\n", i+1, cut(item, p.Width-len(strconv.Itoa(i+1))-2))
+	}
+
+	scanner := bufio.NewScanner(in)
+	for {
+		_, _ = fmt.Fprintf(out, "%s[1-%d]: ", p.Prompt, len(p.Items))
+		if !scanner.Scan() {
+			return 0, ErrCanceled
+		}
+
+		n, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+		if err == nil && n >= 1 && n <= len(p.Items) {
+			return n - 1, nil
+		}
+		_, _ = fmt.Fprintf(out, "Type a number from 1 to %d\n", len(p.Items))
+	}
+}
+
+// cut cuts the line at the width, in runes, with an ellipsis, so it doesn't wrap. A width that
+// isn't positive leaves it whole.
+func cut(line string, width int) string {
+	if width <= 0 || utf8.RuneCountInString(line) <= width {
+		return line
+	}
+
+	runes := []rune(line)
+	return string(runes[:width-1]) + "…"
+}
@@ -0,0 +1,114 @@
+package picker_test
+
+import (
+	"github.com/kardolus/chatgpt-cli/picker"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitPicker(t *testing.T) {
+	spec.Run(t, "Testing the picker", testPicker, spec.Report(report.Terminal{}))
+}
+
+func testPicker(t *testing.T, when spec.G, it spec.S) {
+	var subject *picker.Picker
+
+	it.Before(func() {
+		RegisterTestingT(t)
+
+		subject = &picker.Picker{
+			Prompt: "Thread: ",
+			Items:  []string{"default", "golang-tips", "go-parser \"Refactor the parser\"", "recipes [cooking]"},
+			Preview: func(index int) []string {
+				return []string{"preview of " + strings.Fields(subject.Items[index])[0]}
+			},
+		}
+	})
+
+	when("Score()", func() {
+		it("matches the runes of the query in their order, whatever their case", func() {
+			_, ok := picker.Score("GP", "go-parser")
+			Expect(ok).To(BeTrue())
+
+			_, ok = picker.Score("pg", "go-parser")
+			Expect(ok).To(BeFalse())
+
+			_, ok = picker.Score("", "anything")
+			Expect(ok).To(BeTrue())
+		})
+		it("ranks the matches that start words or follow each other first", func() {
+			parser, _ := picker.Score("gp", "go-parser")
+			tips, _ := picker.Score("gp", "golang-tips")
+			Expect(parser).To(BeNumerically(">", tips))
+		})
+	})
+
+	when("Filter()", func() {
+		it("returns the matches, the best first, and all the items in their order without a query", func() {
+			Expect(picker.Filter(subject.Items, "gp")).To(Equal([]int{2, 1}))
+			Expect(picker.Filter(subject.Items, "cook")).To(Equal([]int{3}))
+			Expect(picker.Filter(subject.Items, "")).To(Equal([]int{0, 1, 2, 3}))
+			Expect(picker.Filter(subject.Items, "zzz")).To(BeEmpty())
+		})
+	})
+
+	when("Run()", func() {
+		it("picks the highlighted match of the query with Enter", func() {
+			out := gbytes.NewBuffer()
+
+			index, err := subject.Run(strings.NewReader("gp\r"), out)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(index).To(Equal(2))
+			Expect(out).To(gbytes.Say("> go-parser"))
+			Expect(out).To(gbytes.Say("preview of go-parser"))
+		})
+		it("moves the highlight with the arrows and Ctrl-P and Ctrl-N, within the matches", func() {
+			index, err := subject.Run(strings.NewReader("\033[B\033[B\x10\x0e\x0e\x0e\x0e\r"), gbytes.NewBuffer())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(index).To(Equal(3))
+		})
+		it("edits the query with Backspace and Ctrl-U", func() {
+			index, err := subject.Run(strings.NewReader("zz\x7f\x7f\x15rec\r"), gbytes.NewBuffer())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(index).To(Equal(3))
+		})
+		it("cancels with Esc, Ctrl-C and the end of the input, and leaves nothing drawn", func() {
+			for _, input := range []string{"go\033x", "go\x03", "go"} {
+				out := gbytes.NewBuffer()
+
+				_, err := subject.Run(strings.NewReader(input), out)
+				Expect(err).To(MatchError(picker.ErrCanceled))
+				Expect(string(out.Contents())).To(HaveSuffix("\033[J\033[?25h"))
+			}
+		})
+		it("cuts the lines at the width", func() {
+			subject.Width = 12
+			out := gbytes.NewBuffer()
+
+			_, err := subject.Run(strings.NewReader("recipes\r"), out)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out).To(gbytes.Say(`> recipes \[…`))
+		})
+	})
+
+	when("Menu()", func() {
+		it("numbers the items and asks again until a number of them is typed", func() {
+			out := gbytes.NewBuffer()
+
+			index, err := subject.Menu(strings.NewReader("go\n9\n2\n"), out)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(index).To(Equal(1))
+			Expect(out).To(gbytes.Say(`1\) default\n2\) golang-tips\n`))
+			Expect(out).To(gbytes.Say(`Type a number from 1 to 4`))
+		})
+		it("cancels once the input ended", func() {
+			_, err := subject.Menu(strings.NewReader(""), gbytes.NewBuffer())
+			Expect(err).To(MatchError(picker.ErrCanceled))
+		})
+	})
+}
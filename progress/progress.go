@@ -0,0 +1,97 @@
+package progress
+
+import (
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/clock"
+	"io"
+	"sync"
+	"time"
+)
+
+// Interval is how often the spinner moves to its next frame and tells the elapsed time again.
+const Interval = 100 * time.Millisecond
+
+// clearLine moves the cursor to the start of the line and erases it, so nothing of the spinner is
+// left before what is written next.
+const clearLine = "\r\033[K"
+
+var frames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// Spinner shows a line that spins and tells how long the model has been waited for, such as
+// "⠋ Waiting for gpt-4o (1.2s)", on a terminal. Stop erases the line, so the answer is written to
+// a clean one. It is safe for concurrent use, so a Stop on Ctrl-C can race the end of the query.
+type Spinner struct {
+	out   io.Writer
+	clock clock.Clock
+
+	mu   sync.Mutex
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewSpinner returns a spinner that writes to out, which is meant to be a terminal.
+func NewSpinner(out io.Writer) *Spinner {
+	return &Spinner{out: out, clock: clock.Real}
+}
+
+// WithClock makes the spinner move with the clock, such as a fake one in tests.
+func (s *Spinner) WithClock(c clock.Clock) *Spinner {
+	s.clock = c
+	return s
+}
+
+// Start shows the spinner for the model until Stop. A spinner that is already shown keeps going.
+func (s *Spinner) Start(model string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stop != nil {
+		return
+	}
+
+	s.stop, s.done = make(chan struct{}), make(chan struct{})
+	started := s.clock.Now()
+	s.draw(0, model, 0)
+
+	go func(stop, done chan struct{}) {
+		defer close(done)
+
+		for frame := 1; ; frame++ {
+			select {
+			case <-stop:
+				return
+			case now := <-s.clock.After(Interval):
+				s.mu.Lock()
+				// a Stop that came in meanwhile already erased the line
+				if s.stop != stop {
+					s.mu.Unlock()
+					return
+				}
+				s.draw(frame, model, now.Sub(started))
+				s.mu.Unlock()
+			}
+		}
+	}(s.stop, s.done)
+}
+
+// Stop erases the spinner and returns once it is no longer drawn. A spinner that isn't shown is
+// left alone.
+func (s *Spinner) Stop() {
+	s.mu.Lock()
+	if s.stop == nil {
+		s.mu.Unlock()
+		return
+	}
+
+	close(s.stop)
+	done := s.done
+	s.stop, s.done = nil, nil
+	_, _ = io.WriteString(s.out, clearLine)
+	s.mu.Unlock()
+
+	<-done
+}
+
+func (s *Spinner) draw(frame int, model string, elapsed time.Duration) {
+	_, _ = fmt.Fprintf(s.out, "%s%s Waiting for %s (%.1fs)", clearLine, frames[frame%len(frames)], model, elapsed.Seconds())
+}
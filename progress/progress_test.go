@@ -0,0 +1,157 @@
+package progress_test
+
+import (
+	"github.com/kardolus/chatgpt-cli/clock"
+	"github.com/kardolus/chatgpt-cli/progress"
+	"github.com/kardolus/chatgpt-cli/style"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitProgress(t *testing.T) {
+	spec.Run(t, "Testing the progress", testProgress, spec.Report(report.Terminal{}))
+}
+
+func testProgress(t *testing.T, when spec.G, it spec.S) {
+	const clearLine = "\r\033[K"
+
+	var (
+		out     *gbytes.Buffer
+		fake    *clock.Fake
+		spinner *progress.Spinner
+	)
+
+	it.Before(func() {
+		RegisterTestingT(t)
+
+		out = gbytes.NewBuffer()
+		fake = clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+		spinner = progress.NewSpinner(out).WithClock(fake)
+	})
+
+	when("Spinner", func() {
+		it("tells the model and the elapsed time as it spins", func() {
+			spinner.Start("gpt-4o")
+			defer spinner.Stop()
+
+			Expect(string(out.Contents())).To(Equal(clearLine + "⠋ Waiting for gpt-4o (0.0s)"))
+
+			for i := 0; i < 12; i++ {
+				Eventually(fake.Waiters).Should(Equal(1))
+				fake.Advance(progress.Interval)
+			}
+
+			Eventually(out).Should(gbytes.Say(`⠙ Waiting for gpt-4o \(0\.1s\)`))
+			Eventually(out).Should(gbytes.Say(`⠹ Waiting for gpt-4o \(1\.2s\)`))
+		})
+
+		it("erases the line when it stops, and is no longer drawn", func() {
+			spinner.Start("gpt-4o")
+			spinner.Stop()
+
+			Expect(string(out.Contents())).To(HaveSuffix(clearLine))
+
+			written := len(out.Contents())
+			fake.Advance(time.Second)
+			Consistently(func() int { return len(out.Contents()) }, 50*time.Millisecond).Should(Equal(written))
+		})
+
+		it("ignores a start while it spins and a stop while it doesn't", func() {
+			spinner.Stop()
+			Expect(out.Contents()).To(BeEmpty())
+
+			spinner.Start("gpt-4o")
+			spinner.Start("gpt-4o-mini")
+			spinner.Stop()
+			spinner.Stop()
+
+			Expect(string(out.Contents())).NotTo(ContainSubstring("gpt-4o-mini"))
+			Expect(strings.Count(string(out.Contents()), clearLine)).To(Equal(2))
+		})
+
+		it("spins again once it is started after a stop", func() {
+			spinner.Start("gpt-4o")
+			spinner.Stop()
+			spinner.Start("gpt-4o-mini")
+			spinner.Stop()
+
+			Expect(string(out.Contents())).To(HaveSuffix(clearLine + "⠋ Waiting for gpt-4o-mini (0.0s)" + clearLine))
+		})
+	})
+	when("Throughput", func() {
+		const (
+			saveCursor    = "\0337"
+			restoreCursor = "\0338"
+			clearRight    = "\033[K"
+		)
+
+		var throughput *progress.Throughput
+
+		it.Before(func() {
+			words := func(text string) int { return len(strings.Fields(text)) }
+			throughput = progress.NewThroughput(out, 40, words).WithClock(fake)
+		})
+
+		it("tells the tokens, their rate since the first one and the elapsed time", func() {
+			throughput.Start()
+			defer throughput.Stop()
+
+			fake.Advance(time.Second)
+			_, _ = throughput.Write([]byte("the answer"))
+			fake.Advance(2 * time.Second)
+			_, _ = throughput.Write([]byte(" streams in"))
+
+			Expect(throughput.String()).To(Equal("4 tokens · 2.0 tokens/s · 3.0s"))
+		})
+
+		it("draws the line right-aligned, and again every interval, leaving the cursor where it was", func() {
+			throughput.Start()
+			defer throughput.Stop()
+
+			Expect(string(out.Contents())).To(Equal(saveCursor + "\033[11G" + clearRight + "0 tokens · 0.0 tokens/s · 0.0s" + restoreCursor))
+
+			_, _ = throughput.Write([]byte("one two"))
+			Eventually(fake.Waiters).Should(Equal(1))
+			fake.Advance(progress.ThroughputInterval)
+
+			Eventually(out).Should(gbytes.Say(`2 tokens · 8\.0 tokens/s · 0\.2s`))
+		})
+
+		it("draws the line in the color of the style", func() {
+			throughput.WithStyle(style.New(style.Dark, style.Basic)).Start()
+			defer throughput.Stop()
+
+			Expect(string(out.Contents())).To(Equal(saveCursor + "\033[11G" + clearRight + "\033[90m0 tokens · 0.0 tokens/s · 0.0s\033[39m" + restoreCursor))
+		})
+
+		it("erases the line before the answer is written, and when it stops", func() {
+			answer := gbytes.NewBuffer()
+			output := throughput.Output(answer)
+
+			throughput.Start()
+			written := len(out.Contents())
+
+			_, _ = output.Write([]byte("Hello"))
+			_, _ = output.Write([]byte(" there"))
+
+			Expect(string(answer.Contents())).To(Equal("Hello there"))
+			Expect(string(out.Contents()[written:])).To(Equal(saveCursor + "\033[11G" + clearRight + restoreCursor))
+
+			fake.Advance(progress.ThroughputInterval)
+			Eventually(out).Should(gbytes.Say(`0 tokens`))
+
+			throughput.Stop()
+			Expect(string(out.Contents())).To(HaveSuffix(clearRight + restoreCursor))
+
+			written = len(out.Contents())
+			fake.Advance(time.Second)
+			Consistently(func() int { return len(out.Contents()) }, 50*time.Millisecond).Should(Equal(written))
+		})
+	})
+}
@@ -0,0 +1,180 @@
+package progress
+
+import (
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/clock"
+	"github.com/kardolus/chatgpt-cli/style"
+	"io"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// ThroughputInterval is how often the line of a Throughput is drawn again.
+const ThroughputInterval = 250 * time.Millisecond
+
+const (
+	// saveCursor and restoreCursor keep the cursor where the answer is written while the line is
+	// drawn at the right of it, and clearRight erases the line from the cursor on
+	saveCursor    = "\0337"
+	restoreCursor = "\0338"
+	clearRight    = "\033[K"
+)
+
+// Throughput tells how fast an answer streams: the tokens that arrived, how many arrive a second
+// since the first one, and the elapsed time, such as "230 tokens · 48.2 tokens/s · 4.8s". Write
+// takes the deltas of the answer, whose tokens count tells. Start draws the line right-aligned on
+// the line the answer is written to, and the writer of Output erases it before the answer is
+// written, which redraws it a ThroughputInterval later, so it is never left within the answer.
+type Throughput struct {
+	out   io.Writer
+	width int
+	count func(string) int
+	clock clock.Clock
+	style style.Styler
+
+	mu      sync.Mutex
+	text    strings.Builder
+	started time.Time
+	first   time.Time
+	drawn   string
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewThroughput returns the throughput of an answer whose tokens count tells, drawn on out, which is
+// meant to be a terminal of the width.
+func NewThroughput(out io.Writer, width int, count func(string) int) *Throughput {
+	return &Throughput{out: out, width: width, count: count, clock: clock.Real}
+}
+
+// WithClock times the answer with the clock, such as a fake one in tests.
+func (t *Throughput) WithClock(c clock.Clock) *Throughput {
+	t.clock = c
+	return t
+}
+
+// WithStyle draws the line in the color of the Verbose element of the styler.
+func (t *Throughput) WithStyle(s style.Styler) *Throughput {
+	t.style = s
+	return t
+}
+
+// Write takes a delta of the answer.
+func (t *Throughput) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.first.IsZero() && len(p) > 0 {
+		t.first = t.clock.Now()
+	}
+	t.text.Write(p)
+
+	return len(p), nil
+}
+
+// String returns the line of the throughput, such as "230 tokens · 48.2 tokens/s · 4.8s".
+func (t *Throughput) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.line(t.clock.Now())
+}
+
+func (t *Throughput) line(now time.Time) string {
+	tokens := t.count(t.text.String())
+
+	rate := 0.0
+	if streamed := now.Sub(t.first).Seconds(); !t.first.IsZero() && streamed > 0 {
+		rate = float64(tokens) / streamed
+	}
+
+	return fmt.Sprintf("%d tokens · %.1f tokens/s · %.1fs", tokens, rate, now.Sub(t.started).Seconds())
+}
+
+// Start starts the clock of the answer and draws the line every ThroughputInterval until Stop.
+func (t *Throughput) Start() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.stop != nil {
+		return
+	}
+
+	t.started = t.clock.Now()
+	t.stop, t.done = make(chan struct{}), make(chan struct{})
+	t.draw(t.started)
+
+	go func(stop, done chan struct{}) {
+		defer close(done)
+
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-t.clock.After(ThroughputInterval):
+				t.mu.Lock()
+				if t.stop == stop {
+					t.draw(now)
+				}
+				t.mu.Unlock()
+			}
+		}
+	}(t.stop, t.done)
+}
+
+// Stop erases the line and returns once it is no longer drawn.
+func (t *Throughput) Stop() {
+	t.mu.Lock()
+	if t.stop == nil {
+		t.mu.Unlock()
+		return
+	}
+
+	close(t.stop)
+	done := t.done
+	t.stop, t.done = nil, nil
+	t.erase()
+	t.mu.Unlock()
+
+	<-done
+}
+
+// Output returns the writer of the answer to out, which erases the line before what is written.
+func (t *Throughput) Output(out io.Writer) io.Writer {
+	return &throughputOutput{throughput: t, out: out}
+}
+
+// draw draws the line at the right of the line of the cursor, and leaves the cursor where it was.
+func (t *Throughput) draw(now time.Time) {
+	line := t.line(now)
+	column := max(t.width-utf8.RuneCountInString(line)+1, 1)
+
+	_, _ = fmt.Fprintf(t.out, "%s\033[%dG%s%s%s", saveCursor, column, clearRight, t.style.Paint(style.Verbose, line), restoreCursor)
+	t.drawn = line
+}
+
+// erase erases the line that was drawn, if any.
+func (t *Throughput) erase() {
+	if t.drawn == "" {
+		return
+	}
+
+	column := max(t.width-utf8.RuneCountInString(t.drawn)+1, 1)
+	_, _ = fmt.Fprintf(t.out, "%s\033[%dG%s%s", saveCursor, column, clearRight, restoreCursor)
+	t.drawn = ""
+}
+
+type throughputOutput struct {
+	throughput *Throughput
+	out        io.Writer
+}
+
+func (o *throughputOutput) Write(p []byte) (int, error) {
+	o.throughput.mu.Lock()
+	defer o.throughput.mu.Unlock()
+
+	o.throughput.erase()
+	return o.out.Write(p)
+}
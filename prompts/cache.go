@@ -0,0 +1,86 @@
+package prompts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/kardolus/chatgpt-cli/utils"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Fetched is a prompt that was fetched from its URL, kept by a Cache along with the ETag the site
+// tagged it with, if any.
+type Fetched struct {
+	URL     string    `json:"url"`
+	ETag    string    `json:"etag,omitempty"`
+	Fetched time.Time `json:"fetched"`
+	Text    string    `json:"text"`
+}
+
+// Cache keeps the prompts fetched from URLs in a directory, a file per URL, so they are only
+// fetched again once they changed, and are at hand offline.
+type Cache struct {
+	dir string
+}
+
+func NewCache(dir string) *Cache {
+	return &Cache{dir: dir}
+}
+
+// Get returns the prompt that was fetched from the URL, if it is kept.
+func (c *Cache) Get(url string) (Fetched, bool) {
+	data, err := os.ReadFile(c.file(url))
+	if err != nil {
+		return Fetched{}, false
+	}
+
+	var result Fetched
+	if err := json.Unmarshal(data, &result); err != nil || result.URL != url {
+		return Fetched{}, false
+	}
+
+	return result, true
+}
+
+// Put keeps the prompt, replacing the one of the same URL.
+func (c *Cache) Put(fetched Fetched) error {
+	if err := os.MkdirAll(c.dir, 0700); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(fetched)
+	if err != nil {
+		return err
+	}
+
+	// a prompt that is being read is never half written
+	return utils.WriteFileAtomic(c.file(fetched.URL), data, 0600)
+}
+
+// file is the file the prompt of the URL is kept in, named after the hash of the URL.
+func (c *Cache) file(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:12])+".json")
+}
+
+// NameOf returns the name of the prompt of the URL, the last element of its path without its
+// extension, such as review for https://example.com/prompts/review.md, or prompt when it has none.
+func NameOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "prompt"
+	}
+
+	name := path.Base(u.Path)
+	name = strings.TrimSuffix(name, path.Ext(name))
+	if name == "" || name == "." || name == "/" {
+		return "prompt"
+	}
+
+	return name
+}
@@ -0,0 +1,172 @@
+package prompts
+
+import (
+	"errors"
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/types"
+	"github.com/kardolus/chatgpt-cli/utils"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	markdownExtension = ".md"
+	frontMatterFence  = "---"
+)
+
+// Prompt is a reusable prompt of the library, a markdown file named after it. Text is a
+// text/template, as the templates are. The front matter, a YAML block between --- lines at the start
+// of the file, may set the model and the temperature the prompt is asked with, and its description,
+// which is the first line of the text otherwise.
+type Prompt struct {
+	Name        string   `yaml:"-"`
+	Description string   `yaml:"description"`
+	Text        string   `yaml:"-"`
+	Model       string   `yaml:"model"`
+	Temperature *float64 `yaml:"temperature"`
+}
+
+// Prompts holds the prompts of the library by name.
+type Prompts struct {
+	prompts map[string]Prompt
+}
+
+func New() *Prompts {
+	return &Prompts{
+		prompts: make(map[string]Prompt),
+	}
+}
+
+// Load returns the prompts of the prompts directory, that of utils.GetPromptsHome.
+func Load() (*Prompts, error) {
+	dir, err := utils.GetPromptsHome()
+	if err != nil {
+		return nil, err
+	}
+
+	result := New()
+	if err := result.LoadDir(dir); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Parse returns the prompt of the content of its file. The front matter is optional, and one that
+// isn't closed is part of the text.
+func Parse(name string, content []byte) (Prompt, error) {
+	result := Prompt{Name: name}
+
+	text := strings.ReplaceAll(string(content), "\r\n", "\n")
+	if rest, ok := strings.CutPrefix(text, frontMatterFence+"\n"); ok {
+		if end := strings.Index("\n"+rest, "\n"+frontMatterFence+"\n"); end >= 0 {
+			err := yaml.NewDecoder(strings.NewReader(rest[:end])).Decode(&result)
+			if err != nil && !errors.Is(err, io.EOF) {
+				return Prompt{}, fmt.Errorf("invalid front matter of prompt %s: %w", name, err)
+			}
+			text = rest[end+len(frontMatterFence)+1:]
+		}
+	}
+
+	result.Text = strings.TrimSpace(text)
+	if result.Description == "" {
+		result.Description = description(result.Text)
+	}
+
+	return result, nil
+}
+
+// description returns the first line of the text that isn't blank, without the # of a heading.
+func description(text string) string {
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(strings.TrimLeft(line, "#")); line != "" {
+			return line
+		}
+	}
+
+	return ""
+}
+
+// LoadDir registers every file with the .md extension in dir, named after the file without its
+// extension. A missing directory is not an error.
+func (p *Prompts) LoadDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != markdownExtension {
+			continue
+		}
+
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+
+		prompt, err := Parse(strings.TrimSuffix(entry.Name(), markdownExtension), content)
+		if err != nil {
+			return err
+		}
+		p.Register(prompt)
+	}
+
+	return nil
+}
+
+// Register stores the prompt under its name, replacing any prompt of the same name.
+func (p *Prompts) Register(prompt Prompt) {
+	p.prompts[prompt.Name] = prompt
+}
+
+// Names returns the names of the registered prompts in alphabetical order.
+func (p *Prompts) Names() []string {
+	var result []string
+	for name := range p.prompts {
+		result = append(result, name)
+	}
+
+	sort.Strings(result)
+	return result
+}
+
+// Get returns the named prompt. The error for an unknown name suggests the names closest to it, or
+// tells where the prompts are kept when there are none.
+func (p *Prompts) Get(name string) (Prompt, error) {
+	if prompt, ok := p.prompts[name]; ok {
+		return prompt, nil
+	}
+
+	if matches := utils.ClosestMatches(name, p.Names(), 3); len(matches) > 0 {
+		return Prompt{}, fmt.Errorf("unknown prompt %s, did you mean %s?", name, strings.Join(matches, " or "))
+	}
+
+	if len(p.prompts) == 0 {
+		return Prompt{}, fmt.Errorf("unknown prompt %s, no prompts are available", name)
+	}
+
+	return Prompt{}, fmt.Errorf("unknown prompt %s, chatgpt prompts list lists the prompts", name)
+}
+
+// Apply returns a copy of cfg that uses the model and the temperature of the prompt, when it sets
+// them, unless explicit tells that the key of the setting, model or temperature, was set by a flag,
+// which wins over the prompt.
+func Apply(cfg types.Config, prompt Prompt, explicit func(key string) bool) types.Config {
+	if prompt.Model != "" && !explicit("model") {
+		cfg.Model = utils.ResolveModel(cfg.ModelAliases, prompt.Model)
+	}
+
+	if prompt.Temperature != nil && !explicit("temperature") {
+		cfg.Temperature = *prompt.Temperature
+	}
+
+	return cfg
+}
@@ -0,0 +1,133 @@
+package prompts_test
+
+import (
+	"github.com/kardolus/chatgpt-cli/prompts"
+	"github.com/kardolus/chatgpt-cli/types"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitPrompts(t *testing.T) {
+	spec.Run(t, "Testing the prompts", testPrompts, spec.Report(report.Terminal{}))
+}
+
+func testPrompts(t *testing.T, when spec.G, it spec.S) {
+	var (
+		subject *prompts.Prompts
+		dir     string
+	)
+
+	it.Before(func() {
+		RegisterTestingT(t)
+		subject = prompts.New()
+		dir = t.TempDir()
+	})
+
+	when("Parse()", func() {
+		it("reads the model and the temperature of the front matter", func() {
+			prompt, err := prompts.Parse("code-review", []byte("---\nmodel: gpt-4o\ntemperature: 0.2\n---\n# Review Go code\n\nReview this for {{.focus}}.\n"))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(prompt.Name).To(Equal("code-review"))
+			Expect(prompt.Model).To(Equal("gpt-4o"))
+			Expect(*prompt.Temperature).To(Equal(0.2))
+			Expect(prompt.Description).To(Equal("Review Go code"))
+			Expect(prompt.Text).To(Equal("# Review Go code\n\nReview this for {{.focus}}."))
+		})
+		it("takes the whole file as the text without a front matter, or with one that isn't closed", func() {
+			prompt, err := prompts.Parse("explain", []byte("\nExplain this code.\nStep by step.\n"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(prompt).To(Equal(prompts.Prompt{Name: "explain", Description: "Explain this code.", Text: "Explain this code.\nStep by step."}))
+
+			prompt, err = prompts.Parse("rule", []byte("---\nExplain this code."))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(prompt.Text).To(Equal("---\nExplain this code."))
+		})
+		it("prefers the description of the front matter, and refuses one that isn't YAML", func() {
+			prompt, err := prompts.Parse("explain", []byte("---\ndescription: Explains code\n---\nExplain this code."))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(prompt.Description).To(Equal("Explains code"))
+
+			_, err = prompts.Parse("broken", []byte("---\nmodel: [gpt\n---\nExplain this code."))
+			Expect(err).To(MatchError(HavePrefix("invalid front matter of prompt broken: ")))
+		})
+	})
+
+	when("LoadDir()", func() {
+		it("registers the markdown files by name", func() {
+			Expect(os.WriteFile(filepath.Join(dir, "code-review.md"), []byte("Review this code."), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("ignored"), 0644)).To(Succeed())
+
+			Expect(subject.LoadDir(dir)).To(Succeed())
+			Expect(subject.Names()).To(Equal([]string{"code-review"}))
+		})
+		it("ignores a missing directory", func() {
+			Expect(subject.LoadDir(filepath.Join(dir, "missing"))).To(Succeed())
+			Expect(subject.Names()).To(BeEmpty())
+		})
+	})
+
+	when("Get()", func() {
+		it("suggests the closest names for an unknown prompt", func() {
+			subject.Register(prompts.Prompt{Name: "code-review"})
+			subject.Register(prompts.Prompt{Name: "explain"})
+
+			_, err := subject.Get("code-reveiw")
+			Expect(err).To(MatchError("unknown prompt code-reveiw, did you mean code-review?"))
+
+			_, err = subject.Get("translate")
+			Expect(err).To(MatchError("unknown prompt translate, chatgpt prompts list lists the prompts"))
+
+			_, err = prompts.New().Get("explain")
+			Expect(err).To(MatchError("unknown prompt explain, no prompts are available"))
+		})
+	})
+
+	when("Apply()", func() {
+		temperature := 0.2
+		prompt := prompts.Prompt{Name: "code-review", Model: "fast", Temperature: &temperature}
+		cfg := types.Config{Model: "gpt-4o", Temperature: 1, ModelAliases: map[string]string{"fast": "gpt-4o-mini"}}
+
+		it("uses the model and the temperature of the prompt", func() {
+			result := prompts.Apply(cfg, prompt, func(string) bool { return false })
+			Expect(result.Model).To(Equal("gpt-4o-mini"))
+			Expect(result.Temperature).To(Equal(0.2))
+		})
+		it("keeps the settings of the flags", func() {
+			result := prompts.Apply(cfg, prompt, func(key string) bool { return key == "model" })
+			Expect(result.Model).To(Equal("gpt-4o"))
+			Expect(result.Temperature).To(Equal(0.2))
+		})
+	})
+
+	when("Cache", func() {
+		it("keeps the prompts by URL", func() {
+			cache := prompts.NewCache(filepath.Join(dir, "cache"))
+			fetched := prompts.Fetched{URL: "https://example.com/review.md", ETag: `"v1"`, Fetched: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), Text: "Review this code."}
+
+			_, ok := cache.Get(fetched.URL)
+			Expect(ok).To(BeFalse())
+
+			Expect(cache.Put(fetched)).To(Succeed())
+			result, ok := cache.Get(fetched.URL)
+			Expect(ok).To(BeTrue())
+			Expect(result).To(Equal(fetched))
+
+			_, ok = cache.Get("https://example.com/other.md")
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	when("NameOf()", func() {
+		it("names the prompt after the last element of the path of its URL", func() {
+			Expect(prompts.NameOf("https://gist.example.com/raw/abc/review.md?x=1")).To(Equal("review"))
+			Expect(prompts.NameOf("https://wiki.example.com/")).To(Equal("prompt"))
+		})
+	})
+}
@@ -0,0 +1,204 @@
+// Package provider resolves the API a config speaks to as a whole: its base URL, its paths, the
+// header its key is sent in and the key itself. A config, or a profile of it, names its provider,
+// openai, azure, anthropic or ollama, and the endpoint settings it leaves at their defaults are
+// those of the provider.
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/credentials"
+	"github.com/kardolus/chatgpt-cli/http"
+	"github.com/kardolus/chatgpt-cli/types"
+	"maps"
+	"strings"
+)
+
+const (
+	OpenAI    = "openai"
+	Azure     = "azure"
+	Anthropic = "anthropic"
+	Ollama    = "ollama"
+
+	// DefaultAzureAPIVersion is the api-version of the Azure requests without azure_api_version
+	DefaultAzureAPIVersion = "2024-06-01"
+	// AnthropicVersion is the version of the Anthropic API the requests are sent with
+	AnthropicVersion = "2023-06-01"
+
+	anthropicKeyPrefix     = "sk-ant-"
+	anthropicVersionHeader = "anthropic-version"
+)
+
+// Names are the providers, in the order they are told.
+var Names = []string{OpenAI, Azure, Anthropic, Ollama}
+
+// endpoint is where a provider is reached and how its key is sent.
+type endpoint struct {
+	url             string
+	completionsPath string
+	modelsPath      string
+	authHeader      string
+	authTokenPrefix string
+}
+
+// endpoints are those of the providers. Those of OpenAI are the defaults of the settings, so a
+// setting still at the one of OpenAI was left for the provider to decide.
+var endpoints = map[string]endpoint{
+	OpenAI:    {url: "https://api.openai.com", completionsPath: "/v1/chat/completions", modelsPath: "/v1/models", authHeader: "Authorization", authTokenPrefix: "Bearer "},
+	Azure:     {completionsPath: "/openai/deployments/%s/chat/completions?api-version=%s", modelsPath: "/openai/models?api-version=%s", authHeader: "api-key"},
+	Anthropic: {url: "https://api.anthropic.com", completionsPath: "/v1/chat/completions", modelsPath: "/v1/models", authHeader: "x-api-key"},
+	Ollama:    {url: "http://localhost:11434", completionsPath: "/v1/chat/completions", modelsPath: "/v1/models", authHeader: "Authorization", authTokenPrefix: "Bearer "},
+}
+
+// Sources are where the key of a config is looked up when it doesn't set one itself.
+type Sources struct {
+	// Env returns the variable of the environment that api_key_env names, os.Getenv outside of
+	// the tests
+	Env func(string) string
+	// Store keeps the keys chatgpt auth login stored, nil for none
+	Store credentials.Store
+}
+
+// NewCaller returns the caller of the config, wired to its provider with its key, and the config
+// it was built from, as Resolve returns it.
+func NewCaller(cfg types.Config, sources Sources) (http.Caller, types.Config, error) {
+	resolved, err := Resolve(cfg, sources)
+	if err != nil {
+		return nil, cfg, err
+	}
+
+	return http.New(resolved), resolved, nil
+}
+
+// Resolve returns the config with the endpoint of its provider and its key: api_key or api_keys,
+// else the variable api_key_env names, else the key the store keeps for it. A key that isn't one
+// of its provider, such as an OpenAI key for Anthropic, is an error, as is a provider missing a
+// setting it needs, such as the deployment of Azure.
+func Resolve(cfg types.Config, sources Sources) (types.Config, error) {
+	cfg, err := withEndpoint(cfg)
+	if err != nil {
+		return cfg, err
+	}
+
+	if len(http.KeysOf(cfg)) == 0 && cfg.APIKeyEnv != "" && sources.Env != nil {
+		cfg.APIKey = sources.Env(cfg.APIKeyEnv)
+		if cfg.APIKey == "" {
+			return cfg, fmt.Errorf("api_key_env names %s, which isn't set", cfg.APIKeyEnv)
+		}
+	}
+
+	if sources.Store != nil {
+		if cfg, err = http.WithStoredKey(cfg, sources.Store); err != nil {
+			return cfg, fmt.Errorf("failed to read the stored API key of %s: %w", http.CredentialOf(cfg), err)
+		}
+	}
+
+	return cfg, checkKeys(cfg)
+}
+
+// Check returns the first problem of the provider of the config, such as an Azure config without
+// the URL of its resource, without looking its key up.
+func Check(cfg types.Config) error {
+	_, err := withEndpoint(cfg)
+	return err
+}
+
+// NeedsKey tells whether the provider of the config refuses the requests without a key, which
+// Ollama, run on the machine, doesn't.
+func NeedsKey(cfg types.Config) bool {
+	return kindOf(cfg) != Ollama
+}
+
+// kindOf returns the provider of the config, OpenAI when it names none.
+func kindOf(cfg types.Config) string {
+	if cfg.Provider == "" {
+		return OpenAI
+	}
+	return cfg.Provider
+}
+
+// withEndpoint returns the config with the endpoint settings it left at the defaults replaced by
+// those of its provider.
+func withEndpoint(cfg types.Config) (types.Config, error) {
+	kind := kindOf(cfg)
+	preset, ok := endpoints[kind]
+	if !ok {
+		return cfg, fmt.Errorf("invalid provider %q, use %s", cfg.Provider, strings.Join(Names, ", "))
+	}
+	defaults := endpoints[OpenAI]
+
+	if kind == Azure {
+		if cfg.URL == defaults.url || cfg.URL == "" {
+			return cfg, errors.New("the azure provider needs the url of its resource, such as https://<resource>.openai.azure.com")
+		}
+
+		version := cfg.AzureAPIVersion
+		if version == "" {
+			version = DefaultAzureAPIVersion
+		}
+
+		if cfg.CompletionsPath == defaults.completionsPath {
+			if cfg.AzureDeployment == "" {
+				return cfg, errors.New("the azure provider needs azure_deployment, the deployment of the model, or a completions_path of its own")
+			}
+			cfg.CompletionsPath = fmt.Sprintf(preset.completionsPath, cfg.AzureDeployment, version)
+		}
+		preset.url = cfg.URL
+		preset.completionsPath = cfg.CompletionsPath
+		preset.modelsPath = fmt.Sprintf(preset.modelsPath, version)
+	}
+
+	// the auth header and its prefix are replaced together, a prefix of its own is kept
+	if cfg.AuthHeader == defaults.authHeader && cfg.AuthTokenPrefix == defaults.authTokenPrefix {
+		cfg.AuthHeader, cfg.AuthTokenPrefix = preset.authHeader, preset.authTokenPrefix
+	}
+	if cfg.URL == defaults.url {
+		cfg.URL = preset.url
+	}
+	if cfg.CompletionsPath == defaults.completionsPath {
+		cfg.CompletionsPath = preset.completionsPath
+	}
+	if cfg.ModelsPath == defaults.modelsPath {
+		cfg.ModelsPath = preset.modelsPath
+	}
+
+	if kind == Anthropic {
+		if _, ok := lookupHeader(cfg.Headers, anthropicVersionHeader); !ok {
+			// the headers may be shared with the configs of the other profiles
+			cfg.Headers = maps.Clone(cfg.Headers)
+			if cfg.Headers == nil {
+				cfg.Headers = map[string]string{}
+			}
+			cfg.Headers[anthropicVersionHeader] = AnthropicVersion
+		}
+	}
+
+	return cfg, nil
+}
+
+// checkKeys returns an error for a key of another provider than the one of the config, which the
+// API would only refuse once asked.
+func checkKeys(cfg types.Config) error {
+	for _, key := range http.KeysOf(cfg) {
+		anthropicKey := strings.HasPrefix(key, anthropicKeyPrefix)
+
+		switch kind := kindOf(cfg); {
+		case kind == Anthropic && !anthropicKey:
+			return fmt.Errorf("the anthropic provider takes Anthropic API keys, which start with %s, and the key of %s doesn't", anthropicKeyPrefix, cfg.Name)
+		case (kind == OpenAI || kind == Azure) && anthropicKey:
+			return fmt.Errorf("the key of %s is an Anthropic API key, which the %s provider doesn't take, set provider to %s", cfg.Name, kind, Anthropic)
+		}
+	}
+
+	return nil
+}
+
+// lookupHeader returns the value of the header, whatever the case of its name.
+func lookupHeader(headers map[string]string, name string) (string, bool) {
+	for key, value := range headers {
+		if strings.EqualFold(key, name) {
+			return value, true
+		}
+	}
+	return "", false
+}
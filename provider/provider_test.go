@@ -0,0 +1,178 @@
+package provider_test
+
+import (
+	"github.com/kardolus/chatgpt-cli/credentials"
+	"github.com/kardolus/chatgpt-cli/provider"
+	"github.com/kardolus/chatgpt-cli/types"
+	"github.com/kardolus/chatgpt-cli/utils"
+	"gopkg.in/yaml.v3"
+	nethttp "net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitProvider(t *testing.T) {
+	spec.Run(t, "Testing the providers", testProvider, spec.Report(report.Terminal{}))
+}
+
+func testProvider(t *testing.T, when spec.G, it spec.S) {
+	var (
+		profiles map[string]yaml.Node
+		sources  provider.Sources
+	)
+
+	// profile returns the fixture profile, read over the defaults of the settings
+	profile := func(name string) types.Config {
+		node, ok := profiles[name]
+		Expect(ok).To(BeTrue(), "no profile "+name)
+
+		cfg := types.Config{
+			Name:            "openai",
+			URL:             "https://api.openai.com",
+			CompletionsPath: "/v1/chat/completions",
+			ModelsPath:      "/v1/models",
+			AuthHeader:      "Authorization",
+			AuthTokenPrefix: "Bearer ",
+			AzureAPIVersion: provider.DefaultAzureAPIVersion,
+		}
+		Expect(node.Decode(&cfg)).To(Succeed())
+		return cfg
+	}
+
+	it.Before(func() {
+		RegisterTestingT(t)
+
+		data, err := utils.FileToBytes("profiles.yaml")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(yaml.Unmarshal(data, &profiles)).To(Succeed())
+
+		store := credentials.NewFile(filepath.Join(t.TempDir(), credentials.FileName))
+		Expect(store.Set("anthropic-personal", "sk-ant-stored")).To(Succeed())
+
+		env := map[string]string{"WORK_AZURE_KEY": "az-env"}
+		sources = provider.Sources{Env: func(name string) string { return env[name] }, Store: store}
+	})
+
+	when("Resolve()", func() {
+		for _, tc := range []struct {
+			profile         string
+			url             string
+			completionsPath string
+			modelsPath      string
+			authHeader      string
+			authTokenPrefix string
+			key             string
+		}{
+			{"openai", "https://api.openai.com", "/v1/chat/completions", "/v1/models", "Authorization", "Bearer ", "sk-proj-1"},
+			{"azure", "https://work.openai.azure.com", "/openai/deployments/gpt-4o/chat/completions?api-version=2024-06-01", "/openai/models?api-version=2024-06-01", "api-key", "", "az-env"},
+			{"anthropic", "https://api.anthropic.com", "/v1/chat/completions", "/v1/models", "x-api-key", "", "sk-ant-stored"},
+			{"ollama", "http://localhost:11434", "/v1/chat/completions", "/v1/models", "Authorization", "Bearer ", ""},
+			{"gateway", "https://gateway.example.com/anthropic", "/v1/chat/completions", "/v1/models", "X-Gateway-Key", "", "sk-ant-gateway"},
+		} {
+			it("resolves the endpoint and the key of the "+tc.profile+" profile", func() {
+				cfg, err := provider.Resolve(profile(tc.profile), sources)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(cfg.URL).To(Equal(tc.url))
+				Expect(cfg.CompletionsPath).To(Equal(tc.completionsPath))
+				Expect(cfg.ModelsPath).To(Equal(tc.modelsPath))
+				Expect(cfg.AuthHeader).To(Equal(tc.authHeader))
+				Expect(cfg.AuthTokenPrefix).To(Equal(tc.authTokenPrefix))
+				Expect(cfg.APIKey).To(Equal(tc.key))
+			})
+		}
+
+		for name, message := range map[string]string{
+			"azure-without-deployment":  "the azure provider needs azure_deployment, the deployment of the model, or a completions_path of its own",
+			"azure-without-url":         "the azure provider needs the url of its resource, such as https://<resource>.openai.azure.com",
+			"anthropic-with-openai-key": "the anthropic provider takes Anthropic API keys, which start with sk-ant-, and the key of openai doesn't",
+			"openai-with-anthropic-key": "the key of openai is an Anthropic API key, which the openai provider doesn't take, set provider to anthropic",
+			"unset-key-env":             "api_key_env names MISSING_KEY, which isn't set",
+			"unknown":                   `invalid provider "gemini", use openai, azure, anthropic, ollama`,
+		} {
+			it("refuses the "+name+" profile", func() {
+				_, err := provider.Resolve(profile(name), sources)
+				Expect(err).To(MatchError(message))
+			})
+		}
+
+		it("checks the provider without looking the key up", func() {
+			Expect(provider.Check(profile("anthropic-with-openai-key"))).To(Succeed())
+			Expect(provider.Check(profile("azure-without-url"))).To(HaveOccurred())
+		})
+
+		it("leaves the headers of the config as they were", func() {
+			cfg := profile("anthropic")
+			cfg.Headers = map[string]string{"X-Team": "cli"}
+
+			resolved, err := provider.Resolve(cfg, sources)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resolved.Headers).To(Equal(map[string]string{"X-Team": "cli", "anthropic-version": provider.AnthropicVersion}))
+			Expect(cfg.Headers).To(Equal(map[string]string{"X-Team": "cli"}))
+		})
+	})
+
+	when("NewCaller()", func() {
+		var (
+			server   *httptest.Server
+			requests []*nethttp.Request
+		)
+
+		it.Before(func() {
+			requests = nil
+			server = httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+				requests = append(requests, r)
+				_, _ = w.Write([]byte(`{"data": []}`))
+			}))
+		})
+
+		it.After(func() {
+			server.Close()
+		})
+
+		for _, tc := range []struct {
+			profile string
+			path    string
+			headers map[string]string
+			absent  []string
+		}{
+			{"openai", "/v1/models", map[string]string{"Authorization": "Bearer sk-proj-1", "OpenAI-Organization": "org-1"}, []string{"x-api-key", "api-key"}},
+			{"azure", "/openai/models?api-version=2024-06-01", map[string]string{"api-key": "az-env"}, []string{"Authorization"}},
+			{"anthropic", "/v1/models", map[string]string{"x-api-key": "sk-ant-stored", "anthropic-version": provider.AnthropicVersion}, []string{"Authorization"}},
+			{"ollama", "/v1/models", map[string]string{}, []string{"Authorization"}},
+			{"gateway", "/v1/models", map[string]string{"X-Gateway-Key": "sk-ant-gateway", "anthropic-version": "2024-01-01"}, []string{"Authorization", "x-api-key"}},
+		} {
+			it("sends the requests of the "+tc.profile+" profile with its headers", func() {
+				cfg := profile(tc.profile)
+				cfg.URL = server.URL
+
+				caller, resolved, err := provider.NewCaller(cfg, sources)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resolved.URL).To(Equal(server.URL))
+
+				_, err = caller.Get(resolved.URL + resolved.ModelsPath)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(requests).To(HaveLen(1))
+				Expect(requests[0].URL.RequestURI()).To(Equal(tc.path))
+				for name, value := range tc.headers {
+					Expect(requests[0].Header.Get(name)).To(Equal(value), name)
+				}
+				for _, name := range tc.absent {
+					Expect(requests[0].Header.Get(name)).To(BeEmpty(), name)
+				}
+			})
+		}
+
+		it("builds no caller for a profile that is misconfigured", func() {
+			caller, _, err := provider.NewCaller(profile("azure-without-deployment"), sources)
+			Expect(err).To(HaveOccurred())
+			Expect(caller).To(BeNil())
+		})
+	})
+}
@@ -6,6 +6,7 @@ mkdir -p bin
 
 GIT_COMMIT=$(git rev-list -1 HEAD)
 GIT_TAGS=$(git rev-list --tags --max-count=1)
+BUILD_DATE=$(date -u +%Y-%m-%dT%H:%M:%SZ)
 
 # Add an array of common OSes and architectures
 TARGETS=(
@@ -32,9 +33,9 @@ for b in $(ls cmd); do
 
     if [ ! -z "$GIT_TAGS" ]; then
       GIT_VERSION=$(git describe --tags $GIT_TAGS)
-      GOOS=$os GOARCH=$arch go build -mod=vendor -ldflags="-s -w -X main.GitCommit=$GIT_COMMIT -X main.GitVersion=$GIT_VERSION" -o "bin/$binary_name" -a cmd/$b/main.go
+      GOOS=$os GOARCH=$arch go build -mod=vendor -ldflags="-s -w -X main.GitCommit=$GIT_COMMIT -X main.BuildDate=$BUILD_DATE -X main.GitVersion=$GIT_VERSION" -o "bin/$binary_name" -a cmd/$b/main.go
     else
-      GOOS=$os GOARCH=$arch go build -mod=vendor -ldflags="-s -w -X main.GitCommit=$GIT_COMMIT" -o "bin/$binary_name" -a cmd/$b/main.go
+      GOOS=$os GOARCH=$arch go build -mod=vendor -ldflags="-s -w -X main.GitCommit=$GIT_COMMIT -X main.BuildDate=$BUILD_DATE" -o "bin/$binary_name" -a cmd/$b/main.go
     fi
 
     echo "done"
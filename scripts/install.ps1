@@ -9,6 +9,7 @@ if (-not (Test-Path -Path $binDir)) {
 }
 
 $gitCommit = git rev-list -1 HEAD
+$buildDate = (Get-Date).ToUniversalTime().ToString("yyyy-MM-ddTHH:mm:ssZ")
 $gitTag = git rev-list --tags --max-count=1
 
 # Add an array of common OSes and architectures
@@ -41,12 +42,12 @@ Get-ChildItem -Directory "cmd" | ForEach-Object {
       $gitVersion = git describe --tags $gitTag
       $env:GOOS = $os
       $env:GOARCH = $arch
-      & go build -mod=vendor -ldflags="-s -w -X main.GitCommit=$gitCommit -X main.GitVersion=$gitVersion" -o "bin/$binaryName" -a "cmd/$b/main.go"
+      & go build -mod=vendor -ldflags="-s -w -X main.GitCommit=$gitCommit -X main.BuildDate=$buildDate -X main.GitVersion=$gitVersion" -o "bin/$binaryName" -a "cmd/$b/main.go"
     }
     else {
       $env:GOOS = $os
       $env:GOARCH = $arch
-      & go build -mod=vendor -ldflags="-s -w -X main.GitCommit=$gitCommit" -o "bin/$binaryName" -a "cmd/$b/main.go"
+      & go build -mod=vendor -ldflags="-s -w -X main.GitCommit=$gitCommit -X main.BuildDate=$buildDate" -o "bin/$binaryName" -a "cmd/$b/main.go"
     }
 
     Write-Host "done"
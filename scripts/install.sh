@@ -7,6 +7,7 @@ mkdir -p bin
 TARGET_OS=${1:-darwin}
 GIT_COMMIT=$(git rev-list -1 HEAD)
 GIT_TAGS=$(git rev-list --tags --max-count=1)
+BUILD_DATE=$(date -u +%Y-%m-%dT%H:%M:%SZ)
 
 echo "Target OS: $TARGET_OS"
 for b in $(ls cmd); do
@@ -15,9 +16,9 @@ for b in $(ls cmd); do
   if [ ! -z "$GIT_TAGS" ]
   then
     GIT_VERSION=$(git describe --tags $GIT_TAGS)
-    GOOS=$TARGET_OS go build -mod=vendor -ldflags="-s -w -X main.GitCommit=$GIT_COMMIT -X main.GitVersion=$GIT_VERSION" -o bin/$b -a cmd/$b/main.go
+    GOOS=$TARGET_OS go build -mod=vendor -ldflags="-s -w -X main.GitCommit=$GIT_COMMIT -X main.BuildDate=$BUILD_DATE -X main.GitVersion=$GIT_VERSION" -o bin/$b -a cmd/$b/main.go
   else
-    GOOS=$TARGET_OS go build -mod=vendor -ldflags="-s -w -X main.GitCommit=$GIT_COMMIT" -o bin/$b -a cmd/$b/main.go
+    GOOS=$TARGET_OS go build -mod=vendor -ldflags="-s -w -X main.GitCommit=$GIT_COMMIT -X main.BuildDate=$BUILD_DATE" -o bin/$b -a cmd/$b/main.go
   fi
 
   echo "done"
@@ -0,0 +1,157 @@
+package shell
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNoCommand is an answer that suggests no command, such as for a request that no command does.
+var ErrNoCommand = errors.New("the model suggested no command for this request")
+
+// SystemPrompt returns the system prompt that has the model answer with a single command of the
+// shell for the OS, as the JSON object a Suggestion is read from.
+func SystemPrompt(goos, shell string) string {
+	return fmt.Sprintf("You turn the requests of the user into a single command of the %s shell on %s. "+
+		"Answer with a JSON object that has a single key, command, whose value is the command on one line, "+
+		"without an explanation, without Markdown, and without a placeholder the user has to fill in. "+
+		"When no command does what the user asks, the command is an empty string.",
+		filepath.Base(shell), goos)
+}
+
+// Suggestion is the answer of the model to the SystemPrompt.
+type Suggestion struct {
+	Command string `json:"command"`
+}
+
+// Validate returns the command of the suggestion without the spaces around it, or ErrNoCommand
+// when it is empty.
+func (s Suggestion) Validate() (string, error) {
+	command := strings.TrimSpace(s.Command)
+	if command == "" {
+		return "", ErrNoCommand
+	}
+
+	return command, nil
+}
+
+// Path returns the shell of the user, the one of SHELL, or else the one every system of the OS
+// has, cmd.exe, which COMSPEC tells the path of, or sh.
+func Path(goos string, getenv func(string) string) string {
+	if shell := strings.TrimSpace(getenv("SHELL")); shell != "" {
+		return shell
+	}
+
+	if goos == "windows" {
+		if comspec := strings.TrimSpace(getenv("COMSPEC")); comspec != "" {
+			return comspec
+		}
+		return "cmd.exe"
+	}
+
+	return "/bin/sh"
+}
+
+// Choice is what the user does with a suggested command.
+type Choice int
+
+const (
+	Abort Choice = iota
+	Run
+	Edit
+	Copy
+)
+
+// Ask asks the user on out what to do with the command until the line read from in is a choice,
+// either its first letter or the whole word. The end of the input aborts, so a command is never
+// run without an answer.
+func Ask(in *bufio.Reader, out io.Writer) Choice {
+	for {
+		_, _ = fmt.Fprint(out, "[r]un, [e]dit, [c]opy or [a]bort? ")
+
+		line, err := in.ReadString('\n')
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "r", "run":
+			return Run
+		case "e", "edit":
+			return Edit
+		case "c", "copy":
+			return Copy
+		case "a", "abort":
+			return Abort
+		}
+
+		if err != nil {
+			// the answer didn't end the line
+			_, _ = fmt.Fprintln(out)
+			return Abort
+		}
+	}
+}
+
+// Runner runs the commands with the Shell, on the terminal of the CLI.
+type Runner struct {
+	Shell  string
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// NewRunner returns a runner of the commands with the shell, whose output is the one of the CLI.
+func NewRunner(shell string) *Runner {
+	return &Runner{Shell: shell, Stdin: os.Stdin, Stdout: os.Stdout, Stderr: os.Stderr}
+}
+
+// Run runs the command and returns its exit status once it exited. An interrupt, such as Ctrl+C,
+// stops the command rather than the CLI, which goes on with its status. A shell that can't be run
+// is an error.
+func (r *Runner) Run(command string) (int, error) {
+	cmd := exec.Command(r.Shell, commandFlag(r.Shell), command)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = r.Stdin, r.Stdout, r.Stderr
+
+	// the command is in the same process group, so it gets the interrupt of the terminal too
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+
+	err := cmd.Run()
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return 0, nil
+	case errors.As(err, &exitErr):
+		return exitErr.ExitCode(), nil
+	default:
+		return 0, fmt.Errorf("failed to run the shell %s: %w", r.Shell, err)
+	}
+}
+
+// Outcome returns what is added to the conversation once the command ran, so a follow-up question
+// about it has the command and its status. A negative status is a command that a signal stopped.
+func Outcome(command string, status int) string {
+	result := fmt.Sprintf("It exited with status %d.", status)
+	if status < 0 {
+		result = "It was stopped by a signal before it exited."
+	}
+
+	return fmt.Sprintf("I ran the command:\n\n```shell\n%s\n```\n\n%s", command, result)
+}
+
+// commandFlag returns the flag the shell takes the command to run with.
+func commandFlag(shell string) string {
+	switch strings.TrimSuffix(strings.ToLower(filepath.Base(shell)), ".exe") {
+	case "cmd":
+		return "/C"
+	case "powershell", "pwsh":
+		return "-Command"
+	default:
+		return "-c"
+	}
+}
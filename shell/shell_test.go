@@ -0,0 +1,118 @@
+package shell_test
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/kardolus/chatgpt-cli/shell"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitShell(t *testing.T) {
+	spec.Run(t, "Testing the shell", testShell, spec.Report(report.Terminal{}))
+}
+
+func testShell(t *testing.T, when spec.G, it spec.S) {
+	it.Before(func() {
+		RegisterTestingT(t)
+	})
+
+	when("SystemPrompt()", func() {
+		it("names the shell and the OS, and asks for the JSON of a Suggestion", func() {
+			prompt := shell.SystemPrompt("darwin", "/bin/zsh")
+			Expect(prompt).To(ContainSubstring("zsh shell on darwin"))
+			Expect(prompt).To(ContainSubstring("JSON object that has a single key, command"))
+		})
+	})
+
+	when("Suggestion.Validate()", func() {
+		it("returns the command without the spaces around it", func() {
+			Expect(shell.Suggestion{Command: "  ls -la\n"}.Validate()).To(Equal("ls -la"))
+		})
+		it("returns ErrNoCommand for an empty command", func() {
+			_, err := shell.Suggestion{Command: " "}.Validate()
+			Expect(err).To(MatchError(shell.ErrNoCommand))
+		})
+	})
+
+	when("Path()", func() {
+		it("prefers SHELL", func() {
+			env := map[string]string{"SHELL": "/usr/bin/fish", "COMSPEC": `C:\Windows\system32\cmd.exe`}
+			Expect(shell.Path("linux", func(key string) string { return env[key] })).To(Equal("/usr/bin/fish"))
+			Expect(shell.Path("windows", func(key string) string { return env[key] })).To(Equal("/usr/bin/fish"))
+		})
+		it("falls back to the shell of the OS", func() {
+			env := map[string]string{"COMSPEC": `C:\Windows\system32\cmd.exe`}
+			Expect(shell.Path("linux", func(key string) string { return env[key] })).To(Equal("/bin/sh"))
+			Expect(shell.Path("windows", func(key string) string { return env[key] })).To(Equal(`C:\Windows\system32\cmd.exe`))
+			Expect(shell.Path("windows", func(string) string { return "" })).To(Equal("cmd.exe"))
+		})
+	})
+
+	when("Ask()", func() {
+		ask := func(input string) (shell.Choice, string) {
+			var out bytes.Buffer
+			choice := shell.Ask(bufio.NewReader(strings.NewReader(input)), &out)
+			return choice, out.String()
+		}
+
+		it("reads the first letter or the whole word of the choice", func() {
+			for input, expected := range map[string]shell.Choice{
+				"r\n": shell.Run, "RUN\n": shell.Run, " e \n": shell.Edit, "copy\n": shell.Copy, "a\n": shell.Abort,
+			} {
+				choice, _ := ask(input)
+				Expect(choice).To(Equal(expected), input)
+			}
+		})
+		it("asks again until the answer is a choice", func() {
+			choice, out := ask("yes\n\nc\n")
+			Expect(choice).To(Equal(shell.Copy))
+			Expect(strings.Count(out, "[r]un, [e]dit, [c]opy or [a]bort? ")).To(Equal(3))
+		})
+		it("aborts at the end of the input", func() {
+			choice, _ := ask("")
+			Expect(choice).To(Equal(shell.Abort))
+
+			choice, _ = ask("maybe")
+			Expect(choice).To(Equal(shell.Abort))
+		})
+	})
+
+	when("Runner.Run()", func() {
+		var stdout, stderr bytes.Buffer
+
+		runner := func() *shell.Runner {
+			stdout.Reset()
+			stderr.Reset()
+			return &shell.Runner{Shell: "sh", Stdout: &stdout, Stderr: &stderr}
+		}
+
+		it("runs the command with the shell, and returns its status", func() {
+			status, err := runner().Run(`echo "$0"; echo oops >&2; exit 3`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(status).To(Equal(3))
+			Expect(stdout.String()).To(Equal("sh\n"))
+			Expect(stderr.String()).To(Equal("oops\n"))
+		})
+		it("returns 0 for a command that succeeded", func() {
+			Expect(runner().Run("true")).To(Equal(0))
+		})
+		it("returns an error for a shell that can't be run", func() {
+			r := runner()
+			r.Shell = "/no/such/shell"
+			_, err := r.Run("true")
+			Expect(err).To(MatchError(ContainSubstring("failed to run the shell /no/such/shell")))
+		})
+	})
+
+	when("Outcome()", func() {
+		it("tells the command and its status", func() {
+			Expect(shell.Outcome("make test", 2)).To(Equal("I ran the command:\n\n```shell\nmake test\n```\n\nIt exited with status 2."))
+			Expect(shell.Outcome("sleep 60", -1)).To(HaveSuffix("It was stopped by a signal before it exited."))
+		})
+	})
+}
@@ -0,0 +1,154 @@
+package slash
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// namePattern is what the name of a command looks like, such as /model. A first word with another
+// slash, such as /etc/hosts, is a query rather than a command.
+var namePattern = regexp.MustCompile(`^/[a-z][a-z-]*$`)
+
+// Command is a command of the interactive mode, such as /model gpt-4o, which takes effect for the
+// queries that follow it.
+type Command struct {
+	// Name is what the command is typed as, slash included, such as /model
+	Name string
+	// Args are the arguments of the command in its help, such as <name>, if it takes any
+	Args string
+	// Help is what the command does, in its help
+	Help string
+	// Run runs the command with what was typed after its name, trimmed. It returns the query to
+	// send, for a command that composes one, such as /edit, or "" for none.
+	Run func(arg string) (string, error)
+	// Complete returns the candidates of the argument of the command, given what was typed of it,
+	// for Tab. Those that don't start with what was typed are left out, so it can return all of
+	// them. It is nil for a command whose argument isn't completed.
+	Complete func(arg string) []string
+}
+
+// Commands are the commands of the interactive mode, in the order their help lists them.
+type Commands []Command
+
+// Dispatch runs the command the input starts with and returns the query it composed, if any, or
+// returns the input when it is a query. A query that starts with a slash is typed with two of them,
+// such as //usr/bin is slow, and one whose first word isn't a name, such as /usr/bin/env, is a query
+// too. A command that isn't one of the commands is an error that lists them.
+func (cs Commands) Dispatch(input string) (string, error) {
+	if escaped, ok := strings.CutPrefix(input, "//"); ok {
+		return "/" + escaped, nil
+	}
+
+	trimmed := strings.TrimLeftFunc(input, unicode.IsSpace)
+	name, arg := trimmed, ""
+	if i := strings.IndexFunc(trimmed, unicode.IsSpace); i >= 0 {
+		name, arg = trimmed[:i], strings.TrimSpace(trimmed[i:])
+	}
+
+	if !namePattern.MatchString(name) {
+		return input, nil
+	}
+
+	for _, command := range cs {
+		if command.Name == name {
+			return command.Run(arg)
+		}
+	}
+
+	names := make([]string, len(cs))
+	for i, command := range cs {
+		names[i] = command.Name
+	}
+
+	return "", fmt.Errorf("unknown command %s, the commands are %s, and // starts a query with a slash",
+		name, strings.Join(names, ", "))
+}
+
+// Do returns the completions of the line up to the cursor, as the AutoCompleter of the line editor
+// does: the rest of each candidate, and how many runes of the line they complete. The names of the
+// commands are completed, then the argument of a command with the candidates of its Complete. A
+// line that isn't a command completes to nothing.
+func (cs Commands) Do(line []rune, pos int) ([][]rune, int) {
+	typed := string(line[:pos])
+	if !strings.HasPrefix(typed, "/") || strings.HasPrefix(typed, "//") {
+		return nil, 0
+	}
+
+	var (
+		candidates []string
+		word       = typed
+	)
+	if name, arg, ok := strings.Cut(typed, " "); !ok {
+		for _, command := range cs {
+			candidates = append(candidates, command.Name+" ")
+		}
+	} else {
+		for _, command := range cs {
+			if command.Name == name && command.Complete != nil {
+				word = strings.TrimLeft(arg, " ")
+				candidates = command.Complete(word)
+			}
+		}
+		if candidates == nil {
+			return nil, 0
+		}
+	}
+
+	var result [][]rune
+	for _, candidate := range candidates {
+		if rest, ok := strings.CutPrefix(candidate, word); ok {
+			result = append(result, []rune(rest))
+		}
+	}
+
+	return result, utf8.RuneCountInString(word)
+}
+
+// Files returns the files and the directories the typed path completes to, with a slash after the
+// directories, so the files in them complete next. The hidden ones are only candidates once their
+// dot is typed.
+func Files(typed string) []string {
+	dir, base := filepath.Split(typed)
+
+	entries, err := os.ReadDir(cmp.Or(dir, "."))
+	if err != nil {
+		return nil
+	}
+
+	var result []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, base) || strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".") {
+			continue
+		}
+		if entry.IsDir() {
+			name += string(filepath.Separator)
+		}
+		result = append(result, dir+name)
+	}
+
+	return result
+}
+
+// Help returns the help of the commands, a line for each with its arguments and what it does.
+func (cs Commands) Help() string {
+	usages := make([]string, len(cs))
+	width := 0
+	for i, command := range cs {
+		usages[i] = strings.TrimSpace(command.Name + " " + command.Args)
+		width = max(width, len(usages[i]))
+	}
+
+	var b strings.Builder
+	for i, command := range cs {
+		_, _ = fmt.Fprintf(&b, "  %-*s  %s\n", width, usages[i], command.Help)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,135 @@
+package slash_test
+
+import (
+	"errors"
+	"github.com/kardolus/chatgpt-cli/slash"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitSlash(t *testing.T) {
+	spec.Run(t, "Testing the slash commands", testSlash, spec.Report(report.Terminal{}))
+}
+
+func testSlash(t *testing.T, when spec.G, it spec.S) {
+	var (
+		subject slash.Commands
+		ran     []string
+	)
+
+	it.Before(func() {
+		RegisterTestingT(t)
+
+		ran = nil
+		subject = slash.Commands{
+			{Name: "/model", Args: "<name>", Help: "Use the model", Run: func(arg string) (string, error) {
+				ran = append(ran, "model "+arg)
+				return "", nil
+			}, Complete: func(string) []string {
+				return []string{"gpt-4o", "gpt-4o-mini", "o1"}
+			}},
+			{Name: "/edit", Args: "[text]", Help: "Compose the query in the editor", Run: func(arg string) (string, error) {
+				return "composed " + arg, nil
+			}},
+			{Name: "/undo", Help: "Remove the last exchange", Run: func(string) (string, error) {
+				return "", errors.New("nothing to undo")
+			}},
+		}
+	})
+
+	when("Dispatch()", func() {
+		it("runs the command with its argument, trimmed", func() {
+			query, err := subject.Dispatch("/model   gpt-4o \n")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(query).To(BeEmpty())
+			Expect(ran).To(Equal([]string{"model gpt-4o"}))
+
+			Expect(subject.Dispatch("/edit\nthe draft")).To(Equal("composed the draft"))
+
+			_, err = subject.Dispatch("/undo")
+			Expect(err).To(MatchError("nothing to undo"))
+		})
+		it("returns the queries as they were typed", func() {
+			for _, input := range []string{"what is /model for?", "/usr/bin/env is missing", "/ alone", "/Model"} {
+				Expect(subject.Dispatch(input)).To(Equal(input))
+			}
+			Expect(ran).To(BeEmpty())
+		})
+		it("sends a query that starts with // with a single slash", func() {
+			Expect(subject.Dispatch("//model is a command")).To(Equal("/model is a command"))
+			Expect(ran).To(BeEmpty())
+		})
+		it("lists the commands for an unknown one", func() {
+			_, err := subject.Dispatch("/modle gpt-4o")
+			Expect(err).To(MatchError("unknown command /modle, the commands are /model, /edit, /undo, and // starts a query with a slash"))
+		})
+	})
+
+	when("Do()", func() {
+		complete := func(line string) ([]string, int) {
+			candidates, length := subject.Do([]rune(line), len([]rune(line)))
+
+			var result []string
+			for _, candidate := range candidates {
+				result = append(result, string(candidate))
+			}
+			return result, length
+		}
+
+		it("completes the names of the commands", func() {
+			candidates, length := complete("/")
+			Expect(candidates).To(Equal([]string{"model ", "edit ", "undo "}))
+			Expect(length).To(Equal(1))
+
+			candidates, _ = complete("/mo")
+			Expect(candidates).To(Equal([]string{"del "}))
+		})
+		it("completes the argument of a command with its candidates", func() {
+			candidates, length := complete("/model gpt")
+			Expect(candidates).To(Equal([]string{"-4o", "-4o-mini"}))
+			Expect(length).To(Equal(3))
+
+			candidates, _ = complete("/model  o")
+			Expect(candidates).To(Equal([]string{"1"}))
+		})
+		it("completes nothing for a query or the argument of a command without candidates", func() {
+			for _, line := range []string{"why", "//mo", "/edit dra", "/unknown x"} {
+				candidates, _ := complete(line)
+				Expect(candidates).To(BeEmpty())
+			}
+		})
+		it("completes up to the cursor", func() {
+			candidates, _ := subject.Do([]rune("/mo gpt"), 3)
+			Expect(candidates).To(Equal([][]rune{[]rune("del ")}))
+		})
+	})
+
+	when("Files()", func() {
+		it("returns the files and directories the path completes to, the hidden ones once their dot is typed", func() {
+			dir := t.TempDir()
+			Expect(os.Mkdir(filepath.Join(dir, "notes"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, "notes.md"), nil, 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, ".notes"), nil, 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, "todo.md"), nil, 0644)).To(Succeed())
+
+			prefix := dir + string(filepath.Separator)
+			Expect(slash.Files(prefix + "no")).To(Equal([]string{prefix + "notes" + string(filepath.Separator), prefix + "notes.md"}))
+			Expect(slash.Files(prefix + ".n")).To(Equal([]string{prefix + ".notes"}))
+			Expect(slash.Files(prefix + "missing/x")).To(BeEmpty())
+		})
+	})
+
+	when("Help()", func() {
+		it("aligns what the commands do", func() {
+			Expect(subject.Help()).To(Equal("" +
+				"  /model <name>  Use the model\n" +
+				"  /edit [text]   Compose the query in the editor\n" +
+				"  /undo          Remove the last exchange\n"))
+		})
+	})
+}
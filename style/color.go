@@ -0,0 +1,155 @@
+package style
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type colorKind int
+
+const (
+	noColor colorKind = iota
+	indexedColor
+	rgbColor
+)
+
+// Color is a color of the terminal: one of the 256 of xterm, the first 16 being the ANSI ones, or
+// an RGB color. The zero Color is none, the text keeps the color of the terminal.
+type Color struct {
+	kind  colorKind
+	index uint8
+	rgb   [3]uint8
+}
+
+// names are the 8 ANSI colors, in the order of their index.
+var names = []string{"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white"}
+
+// palette is the RGB of the 16 ANSI colors, as xterm draws them, which the colors are downgraded
+// to for the terminals of the level Basic.
+var palette = [16][3]uint8{
+	{0x00, 0x00, 0x00}, {0xcd, 0x00, 0x00}, {0x00, 0xcd, 0x00}, {0xcd, 0xcd, 0x00},
+	{0x00, 0x00, 0xee}, {0xcd, 0x00, 0xcd}, {0x00, 0xcd, 0xcd}, {0xe5, 0xe5, 0xe5},
+	{0x7f, 0x7f, 0x7f}, {0xff, 0x00, 0x00}, {0x00, 0xff, 0x00}, {0xff, 0xff, 0x00},
+	{0x5c, 0x5c, 0xff}, {0xff, 0x00, 0xff}, {0x00, 0xff, 0xff}, {0xff, 0xff, 0xff},
+}
+
+// cube are the levels of each of red, green and blue in the 6x6x6 cube of the colors 16 to 231.
+var cube = [6]uint8{0, 95, 135, 175, 215, 255}
+
+func indexed(index uint8) Color {
+	return Color{kind: indexedColor, index: index}
+}
+
+// ParseColor reads a color: a name of the ANSI colors, such as red, bright- before it for the
+// bright one, such as bright-blue, and gray for bright-black, a number from 0 to 255, or an RGB
+// color as #rrggbb. An empty color, or none, is no color.
+func ParseColor(value string) (Color, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+
+	switch value {
+	case "", "none":
+		return Color{}, nil
+	case "gray", "grey":
+		return indexed(8), nil
+	}
+
+	name, bright := strings.CutPrefix(value, "bright-")
+	for i, n := range names {
+		if n == name {
+			if bright {
+				i += 8
+			}
+			return indexed(uint8(i)), nil
+		}
+	}
+
+	if index, err := strconv.ParseUint(value, 10, 8); err == nil {
+		return indexed(uint8(index)), nil
+	}
+
+	if hex, ok := strings.CutPrefix(value, "#"); ok && len(hex) == 6 {
+		if rgb, err := strconv.ParseUint(hex, 16, 32); err == nil {
+			return Color{kind: rgbColor, rgb: [3]uint8{uint8(rgb >> 16), uint8(rgb >> 8), uint8(rgb)}}, nil
+		}
+	}
+
+	return Color{}, fmt.Errorf("unknown color %q, use a name such as red or bright-blue, a number from 0 to 255, or #rrggbb", value)
+}
+
+// escape returns the escape code of the color, downgraded to one the level takes: an RGB color to
+// the nearest of the 256 colors for Extended, and any of them to the nearest ANSI color for Basic.
+func (c Color) escape(level Level) string {
+	if c.kind == noColor || level == None {
+		return ""
+	}
+
+	if c.kind == rgbColor {
+		switch level {
+		case TrueColor:
+			return fmt.Sprintf("\033[38;2;%d;%d;%dm", c.rgb[0], c.rgb[1], c.rgb[2])
+		case Extended:
+			return indexed(nearestExtended(c.rgb)).escape(level)
+		}
+		return indexed(nearestBasic(c.rgb)).escape(level)
+	}
+
+	switch {
+	case c.index < 8:
+		return fmt.Sprintf("\033[%dm", 30+int(c.index))
+	case c.index < 16:
+		return fmt.Sprintf("\033[%dm", 90+int(c.index)-8)
+	case level == Basic:
+		return indexed(nearestBasic(rgbOf(c.index))).escape(level)
+	}
+
+	return fmt.Sprintf("\033[38;5;%dm", c.index)
+}
+
+// rgbOf returns the RGB of one of the 256 colors.
+func rgbOf(index uint8) [3]uint8 {
+	switch {
+	case index < 16:
+		return palette[index]
+	case index < 232:
+		i := index - 16
+		return [3]uint8{cube[i/36], cube[i/6%6], cube[i%6]}
+	}
+
+	gray := 8 + 10*(index-232)
+	return [3]uint8{gray, gray, gray}
+}
+
+// nearestBasic returns the index of the ANSI color nearest the RGB.
+func nearestBasic(rgb [3]uint8) uint8 {
+	nearest := 0
+	for i := range palette {
+		if distance(rgb, palette[i]) < distance(rgb, palette[nearest]) {
+			nearest = i
+		}
+	}
+
+	return uint8(nearest)
+}
+
+// nearestExtended returns the index of the color of the cube or of the grays nearest the RGB, which
+// are the colors of the 256 that every terminal draws alike.
+func nearestExtended(rgb [3]uint8) uint8 {
+	nearest := uint8(16)
+	for i := 16; i < 256; i++ {
+		if distance(rgb, rgbOf(uint8(i))) < distance(rgb, rgbOf(nearest)) {
+			nearest = uint8(i)
+		}
+	}
+
+	return nearest
+}
+
+func distance(a, b [3]uint8) int {
+	result := 0
+	for i := range a {
+		d := int(a[i]) - int(b[i])
+		result += d * d
+	}
+	return result
+}
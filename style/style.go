@@ -0,0 +1,233 @@
+// Package style holds the colors and the attributes of what the CLI prints to a terminal, such as
+// the highlighting of the code and the lines of --verbose, so a single Styler tells every output
+// whether to color and how. A Styler of the level None writes no escape code at all, as NO_COLOR and
+// --no-color ask.
+package style
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ColorOff turns the color back to the one of the terminal.
+const ColorOff = "\033[39m"
+
+// Level is how many colors the terminal takes.
+type Level int
+
+const (
+	// None is no escape code at all, neither colors nor attributes such as bold
+	None Level = iota
+	// Basic is the 16 colors of the ANSI terminals
+	Basic
+	// Extended is the 256 colors of xterm
+	Extended
+	// TrueColor is any RGB color
+	TrueColor
+)
+
+// LevelOf returns the level of the terminal the environment tells of: None when NO_COLOR is set or
+// the terminal is dumb, TrueColor when COLORTERM is truecolor or 24bit, or in Windows Terminal,
+// Extended for a TERM of 256 colors, and Basic otherwise.
+func LevelOf(env func(string) string) Level {
+	switch {
+	case env("NO_COLOR") != "" || env("TERM") == "dumb":
+		return None
+	case env("COLORTERM") == "truecolor" || env("COLORTERM") == "24bit" || env("WT_SESSION") != "":
+		return TrueColor
+	case strings.Contains(env("TERM"), "256color"):
+		return Extended
+	}
+
+	return Basic
+}
+
+// Element is a part of the output that is colored, such as the keywords of the code.
+type Element string
+
+const (
+	Keyword Element = "keyword"
+	String  Element = "string"
+	Comment Element = "comment"
+	Number  Element = "number"
+	// Added, Removed and Hunk are the lines of a diff
+	Added   Element = "added"
+	Removed Element = "removed"
+	Hunk    Element = "hunk"
+	// Code is the code spans of the text
+	Code Element = "code"
+	// Verbose is the lines of --verbose and --usage, such as the throughput of an answer
+	Verbose Element = "verbose"
+)
+
+// Elements are the elements a theme colors, in the order they are told.
+var Elements = []Element{Keyword, String, Comment, Number, Added, Removed, Hunk, Code, Verbose}
+
+// Attribute is a style of the text that isn't a color.
+type Attribute int
+
+const (
+	Bold Attribute = iota
+	Faint
+	Italic
+	Underline
+	Strike
+)
+
+// attributes are the escape codes that turn each attribute on and off.
+var attributes = map[Attribute][2]string{
+	Bold:      {"\033[1m", "\033[22m"},
+	Faint:     {"\033[2m", "\033[22m"},
+	Italic:    {"\033[3m", "\033[23m"},
+	Underline: {"\033[4m", "\033[24m"},
+	Strike:    {"\033[9m", "\033[29m"},
+}
+
+// Theme is the color of each element. An element it doesn't color keeps the one of the terminal.
+type Theme map[Element]Color
+
+var (
+	// Dark is the theme of the terminals with a dark background.
+	Dark = Theme{
+		Keyword: indexed(13),
+		String:  indexed(10),
+		Comment: indexed(8),
+		Number:  indexed(11),
+		Added:   indexed(2),
+		Removed: indexed(1),
+		Hunk:    indexed(6),
+		Code:    indexed(6),
+		Verbose: indexed(8),
+	}
+	// Light is the theme of the terminals with a light background.
+	Light = Theme{
+		Keyword: indexed(5),
+		String:  indexed(2),
+		Comment: indexed(7),
+		Number:  indexed(4),
+		Added:   indexed(2),
+		Removed: indexed(1),
+		Hunk:    indexed(4),
+		Code:    indexed(4),
+		Verbose: indexed(8),
+	}
+	// Mono colors nothing, only the attributes such as bold are kept.
+	Mono = Theme{}
+)
+
+// ThemeOf returns the theme of the name, auto, dark, light or mono. The auto theme is the light one
+// when COLORFGBG, which terminals such as rxvt and iTerm set, tells the background is light, and the
+// dark one otherwise.
+func ThemeOf(name string) (Theme, bool) {
+	switch name {
+	case "dark":
+		return Dark, true
+	case "light":
+		return Light, true
+	case "mono":
+		return Mono, true
+	case "", "auto":
+		// COLORFGBG is the foreground and the background, such as 15;0, the background last
+		parts := strings.Split(os.Getenv("COLORFGBG"), ";")
+		if background, err := strconv.Atoi(parts[len(parts)-1]); err == nil && (background == 7 || background >= 9) {
+			return Light, true
+		}
+		return Dark, true
+	}
+
+	return nil, false
+}
+
+// With returns the theme with the colors of the elements of overrides, a map of an element to a
+// color as ParseColor reads it, such as keyword: bright-blue. The theme itself is left as it is.
+func (t Theme) With(overrides map[string]string) (Theme, error) {
+	result := Theme{}
+	for element, color := range t {
+		result[element] = color
+	}
+
+	// the elements are read in order, so the error of the first one that is wrong is the same on every run
+	names := make([]string, 0, len(overrides))
+	for name := range overrides {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		element := Element(strings.ToLower(name))
+		if !isElement(element) {
+			return nil, fmt.Errorf("invalid theme_colors element %q, use one of %s", name, elementNames())
+		}
+
+		color, err := ParseColor(overrides[name])
+		if err != nil {
+			return nil, fmt.Errorf("invalid theme_colors color of %s: %w", name, err)
+		}
+		result[element] = color
+	}
+
+	return result, nil
+}
+
+func isElement(element Element) bool {
+	for _, e := range Elements {
+		if e == element {
+			return true
+		}
+	}
+	return false
+}
+
+func elementNames() string {
+	names := make([]string, len(Elements))
+	for i, element := range Elements {
+		names[i] = string(element)
+	}
+	return strings.Join(names, ", ")
+}
+
+// Styler styles the text for a terminal of its level, with the colors of its theme. The zero Styler
+// is of the level None, and styles nothing.
+type Styler struct {
+	theme Theme
+	level Level
+}
+
+// New returns the styler of the theme for a terminal of the level.
+func New(theme Theme, level Level) Styler {
+	return Styler{theme: theme, level: level}
+}
+
+// Level returns the level the styler styles for.
+func (s Styler) Level() Level {
+	return s.level
+}
+
+// Color returns the escape code of the color of the element, downgraded to one the level takes, or
+// an empty string when the element has no color or the level is None.
+func (s Styler) Color(element Element) string {
+	return s.theme[element].escape(s.level)
+}
+
+// Paint returns the text in the color of the element, or as it is when it has none.
+func (s Styler) Paint(element Element, text string) string {
+	color := s.Color(element)
+	if color == "" {
+		return text
+	}
+
+	return color + text + ColorOff
+}
+
+// Apply returns the text with the attribute, or as it is at the level None.
+func (s Styler) Apply(attribute Attribute, text string) string {
+	if s.level == None {
+		return text
+	}
+
+	codes := attributes[attribute]
+	return codes[0] + text + codes[1]
+}
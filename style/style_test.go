@@ -0,0 +1,137 @@
+package style_test
+
+import (
+	"github.com/kardolus/chatgpt-cli/style"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitStyle(t *testing.T) {
+	spec.Run(t, "Testing the style", testStyle, spec.Report(report.Terminal{}))
+}
+
+func testStyle(t *testing.T, when spec.G, it spec.S) {
+	it.Before(func() {
+		RegisterTestingT(t)
+	})
+
+	color := func(value string) style.Color {
+		result, err := style.ParseColor(value)
+		Expect(err).NotTo(HaveOccurred())
+		return result
+	}
+
+	when("LevelOf()", func() {
+		it("tells how many colors the terminal takes, none with NO_COLOR or on a dumb terminal", func() {
+			for level, env := range map[style.Level]map[string]string{
+				style.None:      {"NO_COLOR": "1", "COLORTERM": "truecolor"},
+				style.Basic:     {"TERM": "xterm"},
+				style.Extended:  {"TERM": "xterm-256color"},
+				style.TrueColor: {"TERM": "xterm-256color", "COLORTERM": "truecolor"},
+			} {
+				Expect(style.LevelOf(func(name string) string { return env[name] })).To(Equal(level))
+			}
+
+			Expect(style.LevelOf(func(name string) string { return map[string]string{"TERM": "dumb"}[name] })).To(Equal(style.None))
+			Expect(style.LevelOf(func(name string) string { return map[string]string{"NO_COLOR": ""}[name] })).To(Equal(style.Basic))
+		})
+	})
+
+	when("ParseColor()", func() {
+		it("reads the names, the numbers and the RGB colors", func() {
+			s := style.New(style.Theme{
+				style.Keyword: color("red"),
+				style.String:  color("Bright-Blue"),
+				style.Comment: color("gray"),
+				style.Number:  color("208"),
+				style.Added:   color("#ff8000"),
+			}, style.TrueColor)
+
+			Expect(s.Color(style.Keyword)).To(Equal("\033[31m"))
+			Expect(s.Color(style.String)).To(Equal("\033[94m"))
+			Expect(s.Color(style.Comment)).To(Equal("\033[90m"))
+			Expect(s.Color(style.Number)).To(Equal("\033[38;5;208m"))
+			Expect(s.Color(style.Added)).To(Equal("\033[38;2;255;128;0m"))
+			Expect(color("none")).To(Equal(style.Color{}))
+		})
+		it("refuses what isn't a color", func() {
+			for _, value := range []string{"purple", "256", "#12345", "#gggggg", "bright-"} {
+				_, err := style.ParseColor(value)
+				Expect(err).To(MatchError(HavePrefix("unknown color")))
+			}
+		})
+	})
+
+	when("Styler", func() {
+		it("downgrades the colors to those the terminal takes", func() {
+			theme := style.Theme{style.Keyword: color("#ff8000"), style.Number: color("208"), style.Code: color("cyan")}
+
+			extended := style.New(theme, style.Extended)
+			Expect(extended.Color(style.Keyword)).To(Equal("\033[38;5;208m"))
+			Expect(extended.Color(style.Code)).To(Equal("\033[36m"))
+
+			basic := style.New(theme, style.Basic)
+			Expect(basic.Color(style.Keyword)).To(Equal("\033[33m"))
+			Expect(basic.Color(style.Number)).To(Equal("\033[33m"))
+			Expect(basic.Color(style.Code)).To(Equal("\033[36m"))
+		})
+		it("paints and applies the attributes, and writes no escape code at the level None", func() {
+			s := style.New(style.Dark, style.Basic)
+			Expect(s.Paint(style.Code, "x")).To(Equal("\033[36mx\033[39m"))
+			Expect(s.Apply(style.Bold, "x")).To(Equal("\033[1mx\033[22m"))
+
+			mono := style.New(style.Mono, style.TrueColor)
+			Expect(mono.Paint(style.Code, "x")).To(Equal("x"))
+			Expect(mono.Apply(style.Underline, "x")).To(Equal("\033[4mx\033[24m"))
+
+			for _, none := range []style.Styler{style.New(style.Dark, style.None), {}} {
+				Expect(none.Paint(style.Keyword, "x")).To(Equal("x"))
+				Expect(none.Apply(style.Bold, "x")).To(Equal("x"))
+			}
+		})
+	})
+
+	when("ThemeOf()", func() {
+		it("returns the theme of the name, the light one for auto on a light background", func() {
+			theme, ok := style.ThemeOf("light")
+			Expect(ok).To(BeTrue())
+			Expect(theme).To(Equal(style.Light))
+
+			theme, _ = style.ThemeOf("mono")
+			Expect(theme).To(BeEmpty())
+
+			t.Setenv("COLORFGBG", "0;15")
+			theme, _ = style.ThemeOf("auto")
+			Expect(theme).To(Equal(style.Light))
+
+			t.Setenv("COLORFGBG", "15;0")
+			theme, _ = style.ThemeOf("")
+			Expect(theme).To(Equal(style.Dark))
+
+			_, ok = style.ThemeOf("solarized")
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	when("Theme.With()", func() {
+		it("overrides the colors of the elements, leaving the theme as it is", func() {
+			theme, err := style.Dark.With(map[string]string{"Keyword": "blue", "comment": "none"})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(style.New(theme, style.Basic).Color(style.Keyword)).To(Equal("\033[34m"))
+			Expect(style.New(theme, style.Basic).Color(style.Comment)).To(BeEmpty())
+			Expect(style.New(theme, style.Basic).Color(style.String)).To(Equal("\033[92m"))
+			Expect(style.New(style.Dark, style.Basic).Color(style.Keyword)).To(Equal("\033[95m"))
+		})
+		it("refuses an element or a color it doesn't know", func() {
+			_, err := style.Dark.With(map[string]string{"heading": "red"})
+			Expect(err).To(MatchError(`invalid theme_colors element "heading", use one of keyword, string, comment, number, added, removed, hunk, code, verbose`))
+
+			_, err = style.Dark.With(map[string]string{"keyword": "purple"})
+			Expect(err).To(MatchError(HavePrefix(`invalid theme_colors color of keyword: unknown color "purple"`)))
+		})
+	})
+}
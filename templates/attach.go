@@ -0,0 +1,127 @@
+package templates
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrBinary is a file that can't be attached as it isn't text.
+var ErrBinary = errors.New("only text files can be attached")
+
+// Attachment is text the query is about, such as a file that --file attaches or the piped input,
+// which has no path.
+type Attachment struct {
+	Path    string
+	Content string
+	// TruncatedAt is how many bytes the content was truncated at, 0 for the whole content
+	TruncatedAt int
+}
+
+// extensions are the languages of the files, by their extension, or by their name for the files
+// that have none, such as Makefile.
+var extensions = map[string]string{
+	".bash": "bash", ".c": "c", ".cc": "cpp", ".cpp": "cpp", ".cs": "csharp", ".css": "css",
+	".diff": "diff", ".go": "go", ".h": "c", ".hpp": "cpp", ".html": "html", ".java": "java",
+	".js": "javascript", ".json": "json", ".jsx": "jsx", ".kt": "kotlin", ".lua": "lua",
+	".md": "markdown", ".patch": "diff", ".php": "php", ".proto": "protobuf", ".ps1": "powershell",
+	".py": "python", ".rb": "ruby", ".rs": "rust", ".scala": "scala", ".sh": "sh", ".sql": "sql",
+	".swift": "swift", ".toml": "toml", ".ts": "typescript", ".tsx": "tsx", ".xml": "xml",
+	".yaml": "yaml", ".yml": "yaml", ".zsh": "zsh",
+	"Dockerfile": "dockerfile", "Makefile": "makefile",
+}
+
+// ReadAttachment reads the file at the path as an attachment, truncated at limit bytes, at the
+// last whole character before it. Only text can be attached: a file with a NUL byte or that isn't
+// UTF-8 is refused as an ErrBinary.
+func ReadAttachment(path string, limit int) (Attachment, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Attachment{}, fmt.Errorf("failed to attach %s: %w", path, err)
+	}
+	defer file.Close()
+
+	if info, err := file.Stat(); err == nil && info.IsDir() {
+		return Attachment{}, fmt.Errorf("%s is a directory, only files can be attached", path)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(file, int64(limit)+1))
+	if err != nil {
+		return Attachment{}, fmt.Errorf("failed to attach %s: %w", path, err)
+	}
+
+	attachment := Attachment{Path: path}
+	if len(data) > limit {
+		data, attachment.TruncatedAt = data[:limit], limit
+
+		// the limit may have cut a character, of which up to 3 bytes are left
+		for i := 0; i < utf8.UTFMax-1 && len(data) > 0; i++ {
+			if r, size := utf8.DecodeLastRune(data); r != utf8.RuneError || size > 1 {
+				break
+			}
+			data = data[:len(data)-1]
+		}
+	}
+
+	if bytes.IndexByte(data, 0) >= 0 || !utf8.Valid(data) {
+		return Attachment{}, fmt.Errorf("%s isn't a text file, %w", path, ErrBinary)
+	}
+
+	attachment.Content = strings.TrimRight(string(data), "\r\n")
+	return attachment, nil
+}
+
+// Language returns the language of the code block of the attachment, the one of the extension of
+// its path, or else the one GuessLanguage guesses from its content.
+func (a Attachment) Language() string {
+	if a.Path != "" {
+		if language, ok := extensions[filepath.Ext(a.Path)]; ok {
+			return language
+		}
+		if language, ok := extensions[filepath.Base(a.Path)]; ok {
+			return language
+		}
+	}
+
+	return GuessLanguage(a.Content)
+}
+
+// Block returns the content fenced as a code block of its language, under a line that tells the
+// path, if any, and over one that tells where it was truncated, if it was. The fence is longer
+// than any run of backticks of the content, so the code block can't end early.
+func (a Attachment) Block() string {
+	var b strings.Builder
+
+	if a.Path != "" {
+		_, _ = fmt.Fprintf(&b, "File: %s\n", a.Path)
+	}
+
+	fence := fenceFor(a.Content)
+	_, _ = fmt.Fprintf(&b, "%s%s\n%s\n%s", fence, a.Language(), a.Content, fence)
+
+	if a.TruncatedAt > 0 {
+		_, _ = fmt.Fprintf(&b, "\n[truncated at %d KB]", a.TruncatedAt/1024)
+	}
+
+	return b.String()
+}
+
+// Attach returns the query followed by the code blocks of the attachments, in their order, each
+// after a blank line. Without a query, the query is the attachments.
+func Attach(query string, attachments []Attachment) string {
+	var parts []string
+	if query != "" {
+		parts = append(parts, query)
+	}
+
+	for _, attachment := range attachments {
+		parts = append(parts, attachment.Block())
+	}
+
+	return strings.Join(parts, "\n\n")
+}
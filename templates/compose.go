@@ -0,0 +1,93 @@
+package templates
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// DefaultPipeTemplate composes the query of an instruction and of piped input, the instruction
+// first, then a blank line, then the input fenced as a code block of its language, as the files
+// that --file attaches are.
+const DefaultPipeTemplate = "{{.instruction}}\n\n{{.block}}"
+
+// pipeTemplateName is the name the pipe template is registered under.
+const pipeTemplateName = "pipe"
+
+// languages guess the language of the input, the first that matches wins. They look at the start
+// of the input, which is where a diff, a shebang or a package clause is.
+var languages = []struct {
+	name    string
+	pattern *regexp.Regexp
+}{
+	{"diff", regexp.MustCompile(`\A(diff --git |--- \S.*\n\+\+\+ |@@ -\d)`)},
+	{"sh", regexp.MustCompile(`\A#![^\n]*\b(ba|z)?sh\b`)},
+	{"python", regexp.MustCompile(`\A#![^\n]*\bpython`)},
+	{"javascript", regexp.MustCompile(`\A#![^\n]*\bnode\b`)},
+	{"xml", regexp.MustCompile(`\A<\?xml\b`)},
+	{"html", regexp.MustCompile(`(?i)\A<(!doctype html|html)\b`)},
+	{"go", regexp.MustCompile(`(?m)^package [a-z_][a-z0-9_]*\s*$`)},
+	{"rust", regexp.MustCompile(`(?m)^(use \w+(::\w+)+;|(pub )?fn \w+\()`)},
+	{"c", regexp.MustCompile(`(?m)^#include [<"]`)},
+	{"python", regexp.MustCompile(`(?m)^(def \w+\(.*\):|from [\w.]+ import |import \w+$)`)},
+	{"sql", regexp.MustCompile(`(?i)\A\s*(select|insert|update|delete|create table)\b`)},
+}
+
+// ComposeQuery returns the query of the instruction and of the piped input, as the layout, a
+// text/template such as DefaultPipeTemplate, composes them. The layout gets the instruction, the
+// input, the language GuessLanguage guessed for it, a fence longer than any run of backticks of the
+// input, so the code block can't end early, and the block of the input as Attachment.Block fences
+// it.
+func ComposeQuery(layout, instruction, input string) (string, error) {
+	if layout == "" {
+		layout = DefaultPipeTemplate
+	}
+
+	t := New()
+	if err := t.Register(pipeTemplateName, layout); err != nil {
+		return "", err
+	}
+
+	piped := Attachment{Content: input}
+
+	return t.Render(pipeTemplateName, map[string]string{
+		"instruction": instruction,
+		"block":       piped.Block(),
+		"input":       input,
+		"language":    GuessLanguage(input),
+		"fence":       fenceFor(input),
+	})
+}
+
+// GuessLanguage returns the language of the code block of the input, such as go, diff or json, or
+// an empty string when it can't tell.
+func GuessLanguage(input string) string {
+	trimmed := strings.TrimSpace(input)
+	if (strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[")) && json.Valid([]byte(trimmed)) {
+		return "json"
+	}
+
+	for _, language := range languages {
+		if language.pattern.MatchString(trimmed) {
+			return language.name
+		}
+	}
+
+	return ""
+}
+
+// fenceFor returns three backticks, or one more than the longest run of backticks of the input.
+func fenceFor(input string) string {
+	longest, run := 0, 0
+	for _, r := range input {
+		if r != '`' {
+			run = 0
+			continue
+		}
+
+		run++
+		longest = max(longest, run)
+	}
+
+	return strings.Repeat("`", max(3, longest+1))
+}
@@ -0,0 +1,169 @@
+package templates
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+const (
+	templateExtension = ".tmpl"
+)
+
+// Templates holds named prompt templates. The templates use the text/template syntax, for example
+// "Review this Go code for {{.focus}}: {{.code}}", and are rendered with named variables.
+type Templates struct {
+	templates map[string]*template.Template
+}
+
+func New() *Templates {
+	return &Templates{
+		templates: make(map[string]*template.Template),
+	}
+}
+
+// Register parses text and stores it under name, replacing any template of the same name. Parse
+// errors mention the name and the line of the template.
+func (t *Templates) Register(name, text string) error {
+	parsed, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return err
+	}
+
+	t.templates[name] = parsed
+	return nil
+}
+
+// LoadDir registers every file with the .tmpl extension in dir, named after the file without its
+// extension. A missing directory is not an error.
+func (t *Templates) LoadDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != templateExtension {
+			continue
+		}
+
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+
+		if err := t.Register(strings.TrimSuffix(entry.Name(), templateExtension), string(content)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Names returns the names of the registered templates in alphabetical order.
+func (t *Templates) Names() []string {
+	var result []string
+	for name := range t.templates {
+		result = append(result, name)
+	}
+
+	sort.Strings(result)
+	return result
+}
+
+// Render executes the named template with vars. Referencing a variable that is not in vars is an
+// error rather than an empty string.
+func (t *Templates) Render(name string, vars map[string]string) (string, error) {
+	tmpl, ok := t.templates[name]
+	if !ok {
+		return "", fmt.Errorf("template %s not found", name)
+	}
+
+	if vars == nil {
+		vars = map[string]string{}
+	}
+
+	var result strings.Builder
+	if err := tmpl.Execute(&result, vars); err != nil {
+		return "", err
+	}
+
+	return result.String(), nil
+}
+
+// Uses tells whether the named template refers to the variable, such as {{.input}}, so input it
+// leaves out can be added to what it renders instead.
+func (t *Templates) Uses(name, variable string) bool {
+	tmpl, ok := t.templates[name]
+	if !ok || tmpl.Tree == nil {
+		return false
+	}
+
+	return uses(tmpl.Tree.Root, variable)
+}
+
+// uses tells whether the node, or any node within it, refers to the variable.
+func uses(node parse.Node, variable string) bool {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return false
+		}
+		for _, child := range n.Nodes {
+			if uses(child, variable) {
+				return true
+			}
+		}
+	case *parse.ActionNode:
+		return uses(n.Pipe, variable)
+	case *parse.PipeNode:
+		if n == nil {
+			return false
+		}
+		for _, command := range n.Cmds {
+			if uses(command, variable) {
+				return true
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			if uses(arg, variable) {
+				return true
+			}
+		}
+	case *parse.FieldNode:
+		return len(n.Ident) > 0 && n.Ident[0] == variable
+	case *parse.IfNode:
+		return uses(n.Pipe, variable) || uses(n.List, variable) || uses(n.ElseList, variable)
+	case *parse.RangeNode:
+		return uses(n.Pipe, variable) || uses(n.List, variable) || uses(n.ElseList, variable)
+	case *parse.WithNode:
+		return uses(n.Pipe, variable) || uses(n.List, variable) || uses(n.ElseList, variable)
+	case *parse.TemplateNode:
+		return uses(n.Pipe, variable)
+	}
+
+	return false
+}
+
+// ParseVars turns a list of key=value pairs into a map of variables.
+func ParseVars(pairs []string) (map[string]string, error) {
+	result := make(map[string]string, len(pairs))
+
+	for _, pair := range pairs {
+		key, value, found := strings.Cut(pair, "=")
+		if !found || key == "" {
+			return nil, fmt.Errorf("invalid variable %q, expected key=value", pair)
+		}
+		result[key] = value
+	}
+
+	return result, nil
+}
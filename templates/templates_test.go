@@ -0,0 +1,248 @@
+package templates_test
+
+import (
+	"github.com/kardolus/chatgpt-cli/templates"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitTemplates(t *testing.T) {
+	spec.Run(t, "Testing the templates", testTemplates, spec.Report(report.Terminal{}))
+}
+
+func testTemplates(t *testing.T, when spec.G, it spec.S) {
+	var subject *templates.Templates
+
+	it.Before(func() {
+		RegisterTestingT(t)
+		subject = templates.New()
+	})
+
+	when("Render()", func() {
+		it("substitutes the named variables", func() {
+			Expect(subject.Register("code-review", "Review this Go code for {{.focus}}: {{.code}}")).To(Succeed())
+
+			result, err := subject.Render("code-review", map[string]string{
+				"focus": "concurrency",
+				"code":  "go f()",
+			})
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal("Review this Go code for concurrency: go f()"))
+		})
+		it("returns an error when a variable is missing", func() {
+			Expect(subject.Register("code-review", "Review this Go code for {{.focus}}")).To(Succeed())
+
+			_, err := subject.Render("code-review", nil)
+
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("focus"))
+		})
+		it("returns an error when the template does not exist", func() {
+			_, err := subject.Render("unknown", nil)
+
+			Expect(err).To(MatchError("template unknown not found"))
+		})
+	})
+
+	when("Register()", func() {
+		it("reports the name and the line of parse errors", func() {
+			err := subject.Register("broken", "first line\nsecond {{.line")
+
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("broken:2"))
+		})
+	})
+
+	when("LoadDir()", func() {
+		var dir string
+
+		it.Before(func() {
+			dir = t.TempDir()
+		})
+
+		it("registers the .tmpl files by name", func() {
+			Expect(os.WriteFile(filepath.Join(dir, "summarize.tmpl"), []byte("Summarize {{.input}}"), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, "explain.tmpl"), []byte("Explain {{.input}}"), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a template"), 0644)).To(Succeed())
+
+			Expect(subject.LoadDir(dir)).To(Succeed())
+			Expect(subject.Names()).To(Equal([]string{"explain", "summarize"}))
+
+			result, err := subject.Render("summarize", map[string]string{"input": "this"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal("Summarize this"))
+		})
+		it("ignores a missing directory", func() {
+			Expect(subject.LoadDir(filepath.Join(dir, "missing"))).To(Succeed())
+			Expect(subject.Names()).To(BeEmpty())
+		})
+		it("reports the file that fails to parse", func() {
+			Expect(os.WriteFile(filepath.Join(dir, "broken.tmpl"), []byte("{{.input"), 0644)).To(Succeed())
+
+			err := subject.LoadDir(dir)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("broken:1"))
+		})
+	})
+
+	when("Uses()", func() {
+		it("tells the variables the template refers to, also within its actions", func() {
+			Expect(subject.Register("review", "Review {{if .focus}}for {{.focus}}{{else}}{{.args | printf \"%s\"}}{{end}}")).To(Succeed())
+
+			Expect(subject.Uses("review", "focus")).To(BeTrue())
+			Expect(subject.Uses("review", "args")).To(BeTrue())
+			Expect(subject.Uses("review", "input")).To(BeFalse())
+			Expect(subject.Uses("missing", "input")).To(BeFalse())
+		})
+	})
+
+	when("ParseVars()", func() {
+		it("splits the pairs on the first equals sign", func() {
+			vars, err := templates.ParseVars([]string{"focus=concurrency", "expr=a=b"})
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(vars).To(Equal(map[string]string{"focus": "concurrency", "expr": "a=b"}))
+		})
+		it("returns an error for a pair without a key", func() {
+			_, err := templates.ParseVars([]string{"=value"})
+
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	when("ComposeQuery()", func() {
+		it("puts the instruction first and the input fenced as a code block of its language", func() {
+			result, err := templates.ComposeQuery("", "find the data race", "package main\n\nfunc main() {}")
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal("find the data race\n\n```go\npackage main\n\nfunc main() {}\n```"))
+		})
+		it("fences the input with more backticks than it holds", func() {
+			result, err := templates.ComposeQuery("", "summarize", "see ````this````")
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal("summarize\n\n`````\nsee ````this````\n`````"))
+		})
+		it("composes with the configured layout", func() {
+			result, err := templates.ComposeQuery("{{.input}}\n---\n{{.instruction}} ({{.language}})", "explain", `{"a": 1}`)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal("{\"a\": 1}\n---\nexplain (json)"))
+		})
+		it("returns an error for a layout that refers to an unknown variable", func() {
+			_, err := templates.ComposeQuery("{{.code}}", "explain", "text")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	when("GuessLanguage()", func() {
+		it("tells the language from the start of the input", func() {
+			for input, language := range map[string]string{
+				"diff --git a/main.go b/main.go\n":            "diff",
+				"--- a/main.go\n+++ b/main.go\n@@ -1 +1 @@\n": "diff",
+				"#!/usr/bin/env bash\necho hi":                "sh",
+				"#!/usr/bin/env python3\nprint(1)":            "python",
+				"[1, 2, 3]":                                   "json",
+				"<?xml version=\"1.0\"?><a/>":                 "xml",
+				"<!DOCTYPE html><html></html>":                "html",
+				"// comment\npackage http\n":                  "go",
+				"use std::io;\n\nfn main() {}":                "rust",
+				"#include <stdio.h>\nint main() {}":           "c",
+				"import os\n\ndef main():\n    pass":          "python",
+				"SELECT * FROM users":                         "sql",
+				"the quick brown fox":                         "",
+				"{not json":                                   "",
+			} {
+				Expect(templates.GuessLanguage(input)).To(Equal(language), input)
+			}
+		})
+	})
+
+	when("ReadAttachment()", func() {
+		var dir string
+
+		it.Before(func() {
+			dir = t.TempDir()
+		})
+
+		it("reads the text of the file, without the newlines it ends with", func() {
+			path := filepath.Join(dir, "main.go")
+			Expect(os.WriteFile(path, []byte("package main\n\n"), 0644)).To(Succeed())
+
+			attachment, err := templates.ReadAttachment(path, 1024)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(attachment).To(Equal(templates.Attachment{Path: path, Content: "package main"}))
+		})
+		it("truncates the file at the limit, at the last whole character", func() {
+			path := filepath.Join(dir, "notes.txt")
+			Expect(os.WriteFile(path, []byte("abc€def"), 0644)).To(Succeed())
+
+			// the euro sign takes the bytes 4 to 6
+			attachment, err := templates.ReadAttachment(path, 5)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(attachment.Content).To(Equal("abc"))
+			Expect(attachment.TruncatedAt).To(Equal(5))
+
+			attachment, err = templates.ReadAttachment(path, 6)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(attachment.Content).To(Equal("abc€"))
+		})
+		it("refuses the binary files and the directories", func() {
+			path := filepath.Join(dir, "image.png")
+			Expect(os.WriteFile(path, []byte("\x89PNG\r\n\x1a\n\x00\x00"), 0644)).To(Succeed())
+
+			_, err := templates.ReadAttachment(path, 1024)
+			Expect(err).To(MatchError(path + " isn't a text file, only text files can be attached"))
+			Expect(err).To(MatchError(templates.ErrBinary))
+
+			_, err = templates.ReadAttachment(dir, 1024)
+			Expect(err).To(MatchError(dir + " is a directory, only files can be attached"))
+
+			_, err = templates.ReadAttachment(filepath.Join(dir, "missing.txt"), 1024)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	when("Attachment", func() {
+		it("fences the content under its path, tagged with the language of its extension", func() {
+			block := templates.Attachment{Path: "cmd/main.py", Content: "print(1)"}.Block()
+			Expect(block).To(Equal("File: cmd/main.py\n```python\nprint(1)\n```"))
+
+			block = templates.Attachment{Path: "Makefile", Content: "all:"}.Block()
+			Expect(block).To(Equal("File: Makefile\n```makefile\nall:\n```"))
+		})
+		it("guesses the language of the content without a known extension", func() {
+			Expect(templates.Attachment{Path: "script", Content: "#!/bin/sh\necho hi"}.Language()).To(Equal("sh"))
+			Expect(templates.Attachment{Content: "[1]"}.Language()).To(Equal("json"))
+		})
+		it("tells where the content was truncated", func() {
+			block := templates.Attachment{Path: "big.log", Content: "line", TruncatedAt: 256 * 1024}.Block()
+			Expect(block).To(Equal("File: big.log\n```\nline\n```\n[truncated at 256 KB]"))
+		})
+	})
+
+	when("Attach()", func() {
+		it("puts the query first, then the attachments in their order", func() {
+			query := templates.Attach("review these", []templates.Attachment{
+				{Path: "b.go", Content: "package b"},
+				{Path: "a.go", Content: "package a"},
+			})
+			Expect(query).To(Equal("review these\n\nFile: b.go\n```go\npackage b\n```\n\nFile: a.go\n```go\npackage a\n```"))
+		})
+		it("fences the attachments like the piped input", func() {
+			piped, err := templates.ComposeQuery("", "explain", "package main")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(templates.Attach("explain", []templates.Attachment{{Content: "package main"}})).To(Equal(piped))
+		})
+		it("is only the attachments without a query", func() {
+			Expect(templates.Attach("", []templates.Attachment{{Path: "a.txt", Content: "text"}})).To(Equal("File: a.txt\n```\ntext\n```"))
+		})
+	})
+}
@@ -0,0 +1,104 @@
+package tracing
+
+import (
+	"context"
+	"github.com/kardolus/chatgpt-cli/client"
+	"github.com/kardolus/chatgpt-cli/types"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// QuerySpan is the name of the span of a query, RequestSpan the name of the span of each of
+	// its requests.
+	QuerySpan   = "chatgpt.query"
+	RequestSpan = "chatgpt.request"
+
+	instrumentation = "github.com/kardolus/chatgpt-cli"
+)
+
+// The attributes of the spans. The ones of the model and the usage follow the semantic conventions
+// of generative AI.
+const (
+	AttributeModel        = attribute.Key("gen_ai.request.model")
+	AttributePromptTokens = attribute.Key("chatgpt.prompt_tokens_estimate")
+	AttributeFinishReason = attribute.Key("gen_ai.response.finish_reasons")
+	AttributeInputTokens  = attribute.Key("gen_ai.usage.input_tokens")
+	AttributeOutputTokens = attribute.Key("gen_ai.usage.output_tokens")
+	AttributeStatus       = attribute.Key("http.response.status_code")
+	AttributeRetry        = attribute.Key("chatgpt.retry")
+)
+
+// New returns a client.Tracer that records the spans with the tracers of provider, or of the
+// global provider when provider is nil. The client only depends on OpenTelemetry through it.
+func New(provider trace.TracerProvider) client.Tracer {
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+
+	return &tracer{tracer: provider.Tracer(instrumentation)}
+}
+
+type tracer struct {
+	tracer trace.Tracer
+}
+
+func (t *tracer) StartQuery(ctx context.Context, model string, promptTokens int) (context.Context, client.QuerySpan) {
+	ctx, span := t.tracer.Start(ctx, QuerySpan, trace.WithSpanKind(trace.SpanKindInternal), trace.WithAttributes(
+		AttributeModel.String(model),
+		AttributePromptTokens.Int(promptTokens),
+	))
+
+	return ctx, &querySpan{tracer: t.tracer, span: span}
+}
+
+type querySpan struct {
+	tracer trace.Tracer
+	span   trace.Span
+}
+
+func (q *querySpan) StartAttempt(ctx context.Context, attempt int) (context.Context, client.AttemptSpan) {
+	ctx, span := q.tracer.Start(ctx, RequestSpan, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		AttributeRetry.Int(attempt),
+	))
+
+	return ctx, &attemptSpan{span: span}
+}
+
+func (q *querySpan) End(finishReason string, usage types.Usage, err error) {
+	if finishReason != "" {
+		q.span.SetAttributes(AttributeFinishReason.StringSlice([]string{finishReason}))
+	}
+	if usage.PromptTokens > 0 || usage.CompletionTokens > 0 {
+		q.span.SetAttributes(
+			AttributeInputTokens.Int(usage.PromptTokens),
+			AttributeOutputTokens.Int(usage.CompletionTokens),
+		)
+	}
+
+	end(q.span, err)
+}
+
+type attemptSpan struct {
+	span trace.Span
+}
+
+func (a *attemptSpan) End(status int, err error) {
+	if status != 0 {
+		a.span.SetAttributes(AttributeStatus.Int(status))
+	}
+
+	end(a.span, err)
+}
+
+func end(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
@@ -0,0 +1,197 @@
+package tracing_test
+
+import (
+	"context"
+	"github.com/kardolus/chatgpt-cli/client"
+	"github.com/kardolus/chatgpt-cli/history"
+	"github.com/kardolus/chatgpt-cli/http"
+	"github.com/kardolus/chatgpt-cli/tracing"
+	"github.com/kardolus/chatgpt-cli/types"
+	"io"
+	nethttp "net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+const completion = `{"model":"gpt-4o","choices":[{"message":{"role":"assistant","content":"Hello"},"finish_reason":"stop"}],"usage":{"prompt_tokens":12,"completion_tokens":3,"total_tokens":15}}`
+
+func TestUnitTracing(t *testing.T) {
+	spec.Run(t, "Testing the tracing", testTracing, spec.Report(report.Terminal{}))
+}
+
+func testTracing(t *testing.T, when spec.G, it spec.S) {
+	var (
+		recorder *tracetest.SpanRecorder
+		provider *sdktrace.TracerProvider
+	)
+
+	it.Before(func() {
+		RegisterTestingT(t)
+
+		recorder = tracetest.NewSpanRecorder()
+		provider = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	})
+
+	newClient := func(url string) *client.Client {
+		cfg := types.Config{
+			Model:           "gpt-4o",
+			URL:             url,
+			CompletionsPath: "/v1/chat/completions",
+			Thread:          "default",
+			OmitHistory:     true,
+		}
+
+		return client.New(http.RealCallerFactory, history.NewMemoryStore(), cfg, false).
+			WithEphemeralHistory().
+			WithTracer(tracing.New(provider))
+	}
+
+	attributes := func(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+		result := make(map[attribute.Key]attribute.Value)
+		for _, kv := range span.Attributes() {
+			result[kv.Key] = kv.Value
+		}
+		return result
+	}
+
+	when("a query is answered", func() {
+		it("traces the query with a child span for its request", func() {
+			server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+				_, _ = io.WriteString(w, completion)
+			}))
+			defer server.Close()
+
+			answer, _, err := newClient(server.URL).Query("hi")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(answer).To(Equal("Hello"))
+
+			spans := recorder.Ended()
+			Expect(spans).To(HaveLen(2))
+
+			request, query := spans[0], spans[1]
+			Expect(query.Name()).To(Equal(tracing.QuerySpan))
+			Expect(request.Name()).To(Equal(tracing.RequestSpan))
+			Expect(request.Parent().SpanID()).To(Equal(query.SpanContext().SpanID()))
+			Expect(request.SpanContext().TraceID()).To(Equal(query.SpanContext().TraceID()))
+
+			queryAttributes := attributes(query)
+			Expect(queryAttributes[tracing.AttributeModel].AsString()).To(Equal("gpt-4o"))
+			Expect(queryAttributes[tracing.AttributePromptTokens].AsInt64()).To(BeNumerically(">", 0))
+			Expect(queryAttributes[tracing.AttributeFinishReason].AsStringSlice()).To(Equal([]string{"stop"}))
+			Expect(queryAttributes[tracing.AttributeInputTokens].AsInt64()).To(BeEquivalentTo(12))
+			Expect(queryAttributes[tracing.AttributeOutputTokens].AsInt64()).To(BeEquivalentTo(3))
+			Expect(query.Status().Code).To(Equal(codes.Unset))
+
+			requestAttributes := attributes(request)
+			Expect(requestAttributes[tracing.AttributeStatus].AsInt64()).To(BeEquivalentTo(200))
+			Expect(requestAttributes[tracing.AttributeRetry].AsInt64()).To(BeEquivalentTo(0))
+		})
+
+		it("numbers the requests of a continued answer", func() {
+			var calls int
+			server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+				calls++
+				if calls == 1 {
+					_, _ = io.WriteString(w, `{"choices":[{"message":{"role":"assistant","content":"Hel"},"finish_reason":"length"}]}`)
+					return
+				}
+				_, _ = io.WriteString(w, completion)
+			}))
+			defer server.Close()
+
+			subject := newClient(server.URL)
+			subject.Config.MaxContinuations = 1
+
+			_, _, err := subject.Query("hi")
+			Expect(err).NotTo(HaveOccurred())
+
+			spans := recorder.Ended()
+			Expect(spans).To(HaveLen(3))
+			Expect(attributes(spans[0])[tracing.AttributeRetry].AsInt64()).To(BeEquivalentTo(0))
+			Expect(attributes(spans[1])[tracing.AttributeRetry].AsInt64()).To(BeEquivalentTo(1))
+			Expect(spans[1].Parent().SpanID()).To(Equal(spans[2].SpanContext().SpanID()))
+		})
+
+		it("continues the trace of the context of the caller", func() {
+			server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+				_, _ = io.WriteString(w, completion)
+			}))
+			defer server.Close()
+
+			ctx, parent := provider.Tracer("test").Start(context.Background(), "parent")
+			_, _, err := newClient(server.URL).QueryContext(ctx, "hi")
+			parent.End()
+			Expect(err).NotTo(HaveOccurred())
+
+			spans := recorder.Ended()
+			Expect(spans).To(HaveLen(3))
+			Expect(spans[1].Parent().SpanID()).To(Equal(parent.SpanContext().SpanID()))
+		})
+	})
+
+	when("a query fails", func() {
+		it("sets the error status of the spans", func() {
+			server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+				w.WriteHeader(nethttp.StatusUnauthorized)
+				_, _ = io.WriteString(w, `{"error":{"message":"Incorrect API key provided","code":"invalid_api_key"}}`)
+			}))
+			defer server.Close()
+
+			_, _, err := newClient(server.URL).Query("hi")
+			Expect(err).To(MatchError(http.ErrUnauthorized))
+
+			spans := recorder.Ended()
+			Expect(spans).To(HaveLen(2))
+
+			for _, span := range spans {
+				Expect(span.Status().Code).To(Equal(codes.Error))
+				Expect(span.Status().Description).To(Equal("invalid_api_key: Incorrect API key provided"))
+				Expect(span.Events()).To(HaveLen(1))
+			}
+			Expect(attributes(spans[0])[tracing.AttributeStatus].AsInt64()).To(BeEquivalentTo(401))
+		})
+	})
+
+	when("a stream is canceled", func() {
+		it("ends the spans once the stream stopped", func() {
+			server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+				_, _ = io.WriteString(w, "data: {\"choices\":[{\"delta\":{\"content\":\"Hel\"}}]}\n\n")
+				w.(nethttp.Flusher).Flush()
+				<-r.Context().Done()
+			}))
+			defer server.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			subject := newClient(server.URL).WithStreamOutput(cancelingWriter(cancel))
+
+			err := subject.StreamContext(ctx, "hi")
+			Expect(err).To(MatchError(context.Canceled))
+
+			spans := recorder.Ended()
+			Expect(spans).To(HaveLen(2))
+			Expect(spans[1].Name()).To(Equal(tracing.QuerySpan))
+
+			for _, span := range spans {
+				Expect(span.Status().Code).To(Equal(codes.Error))
+			}
+		})
+	})
+}
+
+// cancelingWriter cancels the stream once its first content arrived.
+type cancelingWriter context.CancelFunc
+
+func (c cancelingWriter) Write(p []byte) (int, error) {
+	c()
+	return len(p), nil
+}
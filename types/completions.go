@@ -1,6 +1,9 @@
 package types
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"time"
+)
 
 // Float64 is a custom type that wraps float64 and implements a custom YAML marshaller.
 type Float64 float64
@@ -14,34 +17,75 @@ func (f Float64) MarshalJSON() ([]byte, error) {
 }
 
 type CompletionsRequest struct {
-	Model            string    `json:"model"`
-	Temperature      float64   `json:"temperature"`
-	TopP             float64   `json:"top_p"`
-	FrequencyPenalty float64   `json:"frequency_penalty,omitempty"`
-	MaxTokens        int       `json:"max_tokens"`
-	PresencePenalty  float64   `json:"presence_penalty,omitempty"`
-	Messages         []Message `json:"messages"`
-	Stream           bool      `json:"stream"`
+	Model            string          `json:"model"`
+	Temperature      float64         `json:"temperature"`
+	TopP             float64         `json:"top_p"`
+	FrequencyPenalty float64         `json:"frequency_penalty,omitempty"`
+	MaxTokens        int             `json:"max_tokens"`
+	PresencePenalty  float64         `json:"presence_penalty,omitempty"`
+	Seed             *int            `json:"seed,omitempty"`
+	Stop             []string        `json:"stop,omitempty"`
+	Messages         []Message       `json:"messages"`
+	Stream           bool            `json:"stream"`
+	StreamOptions    *StreamOptions  `json:"stream_options,omitempty"`
+	ResponseFormat   *ResponseFormat `json:"response_format,omitempty"`
+}
+
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type ResponseFormat struct {
+	Type string `json:"type"`
 }
 
 type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role         string   `json:"role"`
+	Content      string   `json:"content"`
+	Alternatives []string `json:"alternatives,omitempty"`
+	// Timestamp is when the message was added to the conversation. The history keeps it next to
+	// the message, it is not part of the wire form. Messages from older histories have a zero time.
+	Timestamp time.Time `json:"-"`
+	// Tokens is the token count of the content as estimated by TokenEncoding. The history records
+	// it when the message is written, so a conversation isn't counted again on every query.
+	Tokens        int    `json:"-"`
+	TokenEncoding string `json:"-"`
+	// Pinned messages are never trimmed from the conversation or pruned from the history.
+	Pinned bool `json:"-"`
+	// Model is the model that gave an answer, which the history keeps next to it, as the model can
+	// change from one answer of a thread to the next. Older answers have none.
+	Model string `json:"-"`
 }
 
 type CompletionsResponse struct {
-	ID      string   `json:"id"`
-	Object  string   `json:"object"`
-	Created int      `json:"created"`
-	Model   string   `json:"model"`
-	Usage   Usage    `json:"usage"`
-	Choices []Choice `json:"choices"`
+	ID                string   `json:"id"`
+	Object            string   `json:"object"`
+	Created           int      `json:"created"`
+	Model             string   `json:"model"`
+	SystemFingerprint string   `json:"system_fingerprint"`
+	Usage             Usage    `json:"usage"`
+	Choices           []Choice `json:"choices"`
+	// Requests are the requests the answer took, in the order they were sent, such as the
+	// continuations of an answer that was cut off. They are told by the headers, not the body.
+	Requests []RequestInfo `json:"-"`
+}
+
+// RequestInfo is what the headers of a response tell about its request. ID is the x-request-id the
+// support of the API asks for, ProcessingTime is how long the API says it took to answer.
+type RequestInfo struct {
+	ID             string
+	ProcessingTime time.Duration
 }
 
 type Usage struct {
-	PromptTokens     int `json:"prompt_tokens"`
-	CompletionTokens int `json:"completion_tokens"`
-	TotalTokens      int `json:"total_tokens"`
+	PromptTokens        int                 `json:"prompt_tokens"`
+	CompletionTokens    int                 `json:"completion_tokens"`
+	TotalTokens         int                 `json:"total_tokens"`
+	PromptTokensDetails PromptTokensDetails `json:"prompt_tokens_details"`
+}
+
+type PromptTokensDetails struct {
+	CachedTokens int `json:"cached_tokens"`
 }
 
 type Choice struct {
@@ -59,6 +103,7 @@ type Data struct {
 	TopP             float64 `json:"top_p"`
 	FrequencyPenalty float64 `json:"frequency_penalty"`
 	PresencePenalty  float64 `json:"presence_penalty"`
+	Usage            *Usage  `json:"usage"`
 	Choices          []struct {
 		Delta        map[string]string `json:"delta"`
 		Index        int               `json:"index"`
@@ -70,6 +115,7 @@ type ErrorResponse struct {
 	Error struct {
 		Message string `json:"message"`
 		Type    string `json:"type"`
+		Param   string `json:"param"`
 		Code    string `json:"code"`
 	} `json:"error"`
 }
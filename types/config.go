@@ -1,28 +1,134 @@
 package types
 
 type Config struct {
-	Name                string  `yaml:"name"`
-	APIKey              string  `yaml:"api_key"`
-	Model               string  `yaml:"model"`
-	MaxTokens           int     `yaml:"max_tokens"`
-	ContextWindow       int     `yaml:"context_window"`
-	Role                string  `yaml:"role"`
-	Temperature         float64 `yaml:"temperature"`
-	TopP                float64 `yaml:"top_p"`
-	FrequencyPenalty    float64 `yaml:"frequency_penalty"`
-	PresencePenalty     float64 `yaml:"presence_penalty"`
-	Thread              string  `yaml:"thread"`
-	OmitHistory         bool    `yaml:"omit_history"`
-	URL                 string  `yaml:"url"`
-	CompletionsPath     string  `yaml:"completions_path"`
-	ModelsPath          string  `yaml:"models_path"`
-	AuthHeader          string  `yaml:"auth_header"`
-	AuthTokenPrefix     string  `yaml:"auth_token_prefix"`
-	CommandPrompt       string  `yaml:"command_prompt"`
-	OutputPrompt        string  `yaml:"output_prompt"`
-	AutoCreateNewThread bool    `yaml:"auto_create_new_thread"`
-	TrackTokenUsage     bool    `yaml:"track_token_usage"`
-	SkipTLSVerify       bool    `yaml:"skip_tls_verify"`
-	Debug               bool    `yaml:"debug"`
-	Multiline           bool    `yaml:"multiline"`
+	Name                    string  `yaml:"name"`
+	APIKey                  string  `yaml:"api_key"`
+	APIKeys                 string  `yaml:"api_keys"`
+	CredentialStore         string  `yaml:"credential_store"`
+	Credential              string  `yaml:"credential"`
+	APIKeyEnv               string  `yaml:"api_key_env"`
+	Provider                string  `yaml:"provider"`
+	AzureDeployment         string  `yaml:"azure_deployment"`
+	AzureAPIVersion         string  `yaml:"azure_api_version"`
+	Organization            string  `yaml:"organization"`
+	Project                 string  `yaml:"project"`
+	Model                   string  `yaml:"model"`
+	MaxTokens               int     `yaml:"max_tokens"`
+	ContextWindow           int     `yaml:"context_window"`
+	MaxContinuations        int     `yaml:"max_continuations"`
+	RequestsPerMinute       int     `yaml:"requests_per_minute"`
+	TokensPerMinute         int     `yaml:"tokens_per_minute"`
+	JSONRetries             int     `yaml:"json_retries"`
+	LockTimeout             int     `yaml:"lock_timeout"`
+	DebugBodyLimit          int     `yaml:"debug_body_limit"`
+	InputHistorySize        int     `yaml:"input_history_size"`
+	RetryMaxAttempts        int     `yaml:"retry_max_attempts"`
+	CircuitBreakerThreshold int     `yaml:"circuit_breaker_threshold"`
+	MaxResponseBytes        int     `yaml:"max_response_bytes"`
+	MaxPipeBytes            int     `yaml:"max_pipe_bytes"`
+	MaxFileKB               int     `yaml:"max_file_kb"`
+	ContextBudget           int     `yaml:"context_budget"`
+	DiffBudget              int     `yaml:"diff_budget"`
+	ChunkTokens             int     `yaml:"chunk_tokens"`
+	PageBudget              int     `yaml:"page_budget"`
+	MonthlyBudget           float64 `yaml:"monthly_budget"`
+	MaxEventBytes           int     `yaml:"max_event_bytes"`
+	MaxStreamBytes          int     `yaml:"max_stream_bytes"`
+	Stream                  bool    `yaml:"stream"`
+	Role                    string  `yaml:"role"`
+	Persona                 string  `yaml:"persona"`
+	HistoryBackend          string  `yaml:"history_backend"`
+	HistoryDir              string  `yaml:"history_dir"`
+	HistoryPassphrase       string  `yaml:"history_passphrase"`
+	HistoryKeyFile          string  `yaml:"history_key_file"`
+	HistoryMaxMessages      int     `yaml:"history_max_messages"`
+	HistoryMaxBytes         int     `yaml:"history_max_bytes"`
+	HistoryMaxAge           string  `yaml:"history_max_age"`
+	SummaryModel            string  `yaml:"summary_model"`
+	SummaryContextWindow    int     `yaml:"summary_context_window"`
+	TitleModel              string  `yaml:"title_model"`
+	RedactPatternsFile      string  `yaml:"redact_patterns_file"`
+	SessionPolicy           string  `yaml:"session_policy"`
+	SessionWindow           string  `yaml:"session_window"`
+	Proxy                   string  `yaml:"proxy"`
+	CABundle                string  `yaml:"ca_bundle"`
+	TLSMinVersion           string  `yaml:"tls_min_version"`
+	ClientCert              string  `yaml:"client_cert"`
+	ClientKey               string  `yaml:"client_key"`
+	ClientKeyPassphrase     string  `yaml:"client_key_passphrase"`
+	UserAgent               string  `yaml:"user_agent"`
+	RetryBaseDelay          string  `yaml:"retry_base_delay"`
+	RetryMaxDelay           string  `yaml:"retry_max_delay"`
+	CircuitBreakerCooldown  string  `yaml:"circuit_breaker_cooldown"`
+	ConnectTimeout          string  `yaml:"connect_timeout"`
+	TLSHandshakeTimeout     string  `yaml:"tls_handshake_timeout"`
+	ResponseHeaderTimeout   string  `yaml:"response_header_timeout"`
+	Timeout                 string  `yaml:"timeout"`
+	IdleTimeout             string  `yaml:"idle_timeout"`
+	MaxIdleConns            int     `yaml:"max_idle_conns"`
+	IdleConnTimeout         string  `yaml:"idle_conn_timeout"`
+	DNSResolver             string  `yaml:"dns_resolver"`
+	Temperature             float64 `yaml:"temperature"`
+	TopP                    float64 `yaml:"top_p"`
+	FrequencyPenalty        float64 `yaml:"frequency_penalty"`
+	PresencePenalty         float64 `yaml:"presence_penalty"`
+	Thread                  string  `yaml:"thread"`
+	OmitHistory             bool    `yaml:"omit_history"`
+	URL                     string  `yaml:"url"`
+	CompletionsPath         string  `yaml:"completions_path"`
+	ModelsPath              string  `yaml:"models_path"`
+	AuthHeader              string  `yaml:"auth_header"`
+	AuthTokenPrefix         string  `yaml:"auth_token_prefix"`
+	CommandPrompt           string  `yaml:"command_prompt"`
+	OutputPrompt            string  `yaml:"output_prompt"`
+	PipeTemplate            string  `yaml:"pipe_template"`
+	PromptURLHosts          string  `yaml:"prompt_url_hosts"`
+	HighlightTheme          string  `yaml:"highlight_theme"`
+	AutoCreateNewThread     bool    `yaml:"auto_create_new_thread"`
+	AutoTitle               bool    `yaml:"auto_title"`
+	TrackTokenUsage         bool    `yaml:"track_token_usage"`
+	ShowUsage               bool    `yaml:"show_usage"`
+	QuietMode               bool    `yaml:"quiet_mode"`
+	Pager                   bool    `yaml:"pager"`
+	PagerCommand            string  `yaml:"pager_command"`
+	Markdown                bool    `yaml:"markdown"`
+	WrapWidth               int     `yaml:"wrap_width"`
+	Notify                  bool    `yaml:"notify"`
+	NotifyAfter             int     `yaml:"notify_after"`
+	SkipTLSVerify           bool    `yaml:"skip_tls_verify"`
+	Debug                   bool    `yaml:"debug"`
+	DebugHTTP               bool    `yaml:"debug_http"`
+	Multiline               bool    `yaml:"multiline"`
+	JSONMode                bool    `yaml:"json_mode"`
+	KeepJSONRetries         bool    `yaml:"keep_json_retries"`
+	RedactSecrets           bool    `yaml:"redact_secrets"`
+	RedactQuery             bool    `yaml:"redact_query"`
+	PersonaNewThread        bool    `yaml:"persona_new_thread"`
+	AutoUnarchive           bool    `yaml:"auto_unarchive"`
+	CheckUpdates            bool    `yaml:"check_updates"`
+	AllowAuthOverride       bool    `yaml:"allow_auth_override"`
+	ForceIPv4               bool    `yaml:"force_ipv4"`
+	// Headers are added to every request, such as the token of a gateway
+	Headers map[string]string `yaml:"headers"`
+	// DialOverrides pin hosts to the addresses they are dialed at
+	DialOverrides map[string]string `yaml:"dial_overrides"`
+	// ThemeColors are the colors of the elements of the output that replace those of the
+	// highlight_theme, such as keyword: bright-blue
+	ThemeColors map[string]string `yaml:"theme_colors"`
+	// Seed makes the sampling repeatable, as far as the API allows, it is only sent when it is set
+	Seed *int `yaml:"seed"`
+	// Stop are the sequences the API stops the answer at
+	Stop []string `yaml:"stop"`
+	// Context are the globs of the files of the project that are attached when --context gives
+	// none, such as those of the project file of a repository
+	Context []string `yaml:"context"`
+	// ModelAliases are short names of models, such as fast for gpt-4o-mini
+	ModelAliases map[string]string `yaml:"model_aliases"`
+	// Aliases are commands of the user that expand to arguments, such as tldr for -t summarize
+	Aliases map[string]string `yaml:"aliases"`
+	// Profile is the profile of Profiles the config is read with, such as work
+	Profile string `yaml:"profile"`
+	// Profiles are named settings that replace those of the config, such as the key and the URL of
+	// a deployment at work
+	Profiles map[string]interface{} `yaml:"profiles"`
 }
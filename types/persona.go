@@ -0,0 +1,11 @@
+package types
+
+// Persona bundles a system prompt with an optional default model and temperature. Temperature is
+// a pointer so a persona can ask for a temperature of 0.
+type Persona struct {
+	Name        string   `yaml:"name"`
+	Role        string   `yaml:"role"`
+	Model       string   `yaml:"model"`
+	Temperature *float64 `yaml:"temperature"`
+	Project     string   `yaml:"project"`
+}
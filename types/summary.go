@@ -0,0 +1,8 @@
+package types
+
+// Summary is what the model makes of a thread when it is asked to summarize it.
+type Summary struct {
+	Topics        []string `json:"topics"`
+	Decisions     []string `json:"decisions"`
+	OpenQuestions []string `json:"open_questions"`
+}
@@ -5,15 +5,24 @@ import (
 	"github.com/google/uuid"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 const (
-	ConfigHomeEnv    = "OPENAI_CONFIG_HOME"
-	DataHomeEnv      = "OPENAI_DATA_HOME"
-	DefaultConfigDir = ".chatgpt-cli"
-	DefaultDataDir   = "history"
+	ConfigHomeEnv       = "OPENAI_CONFIG_HOME"
+	DataHomeEnv         = "OPENAI_DATA_HOME"
+	TemplatesHomeEnv    = "OPENAI_TEMPLATES_HOME"
+	PromptsHomeEnv      = "OPENAI_PROMPTS_HOME"
+	DefaultConfigDir    = ".chatgpt-cli"
+	DefaultDataDir      = "history"
+	DefaultTemplatesDir = "templates"
+	DefaultPromptsDir   = "prompts"
+	TokenEncoding       = "estimate-v1"
 )
 
 func FormatPrompt(str string, counter, usage int, now time.Time) string {
@@ -40,24 +49,55 @@ func FormatPrompt(str string, counter, usage int, now time.Time) string {
 	return str
 }
 
+// GetConfigHome returns the directory of the config, OPENAI_CONFIG_HOME when it is set. Otherwise
+// ~/.chatgpt-cli is used for as long as it exists, so existing setups keep their files, and the XDG
+// config directory when it doesn't.
 func GetConfigHome() (string, error) {
-	var result string
+	if tmp := os.Getenv(ConfigHomeEnv); tmp != "" {
+		return tmp, nil
+	}
 
-	homeDir, err := os.UserHomeDir()
+	legacy, inUse, err := usesLegacyHome()
 	if err != nil {
 		return "", err
 	}
+	if inUse {
+		return legacy, nil
+	}
 
-	result = filepath.Join(homeDir, DefaultConfigDir)
+	return XDGConfigHome()
+}
 
-	if tmp := os.Getenv(ConfigHomeEnv); tmp != "" {
-		result = tmp
+// GetDataHome returns the directory of the history, OPENAI_DATA_HOME when it is set. Otherwise the
+// history is kept next to the config when OPENAI_CONFIG_HOME is set or ~/.chatgpt-cli is in use, and
+// in the XDG state directory when neither is.
+func GetDataHome() (string, error) {
+	if tmp := os.Getenv(DataHomeEnv); tmp != "" {
+		return ExpandPath(tmp)
 	}
 
-	return result, nil
+	_, inUse, err := usesLegacyHome()
+	if err != nil {
+		return "", err
+	}
+
+	if os.Getenv(ConfigHomeEnv) != "" || inUse {
+		configHome, err := GetConfigHome()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(configHome, DefaultDataDir), nil
+	}
+
+	stateHome, err := XDGStateHome()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(stateHome, DefaultDataDir), nil
 }
 
-func GetDataHome() (string, error) {
+func GetTemplatesHome() (string, error) {
 	var result string
 
 	configHome, err := GetConfigHome()
@@ -65,20 +105,164 @@ func GetDataHome() (string, error) {
 		return "", err
 	}
 
-	result = filepath.Join(configHome, DefaultDataDir)
+	result = filepath.Join(configHome, DefaultTemplatesDir)
 
-	if tmp := os.Getenv(DataHomeEnv); tmp != "" {
+	if tmp := os.Getenv(TemplatesHomeEnv); tmp != "" {
 		result = tmp
 	}
 
 	return result, nil
 }
 
+// GetPromptsHome returns the directory of the prompt library, the prompts directory of the config
+// home unless OPENAI_PROMPTS_HOME names another one.
+func GetPromptsHome() (string, error) {
+	if dir := os.Getenv(PromptsHomeEnv); dir != "" {
+		return dir, nil
+	}
+
+	configHome, err := GetConfigHome()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(configHome, DefaultPromptsDir), nil
+}
+
+// ExpandPath expands a leading ~ to the home directory and makes relative paths absolute, resolved
+// against the working directory.
+func ExpandPath(path string) (string, error) {
+	if path == "~" || strings.HasPrefix(path, "~/") || strings.HasPrefix(path, "~"+string(filepath.Separator)) {
+		homeDir, err := HomeDir(runtime.GOOS, os.Getenv)
+		if err != nil {
+			return "", err
+		}
+		path = filepath.Join(homeDir, path[1:])
+	}
+
+	return filepath.Abs(path)
+}
+
 func GenerateUniqueSlug(prefix string) string {
 	guid := uuid.New()
 	return prefix + guid.String()[:4]
 }
 
+// EstimateTokens approximates the number of tokens of the content. TokenEncoding names the
+// estimate, so counts recorded with an earlier version of it can be told apart.
+func EstimateTokens(content string) int {
+	charCount, wordCount := 0, 0
+	words := strings.Fields(content)
+	wordCount += len(words)
+
+	for _, word := range words {
+		charCount += utf8.RuneCountInString(word)
+	}
+
+	// This is a simple approximation; actual token count may differ.
+	// You can adjust this based on your language and the specific tokenizer used by the model.
+	return (charCount + wordCount) / 2
+}
+
+// ParseDuration parses a duration like time.ParseDuration does, and also accepts a whole number of
+// days, such as "30d".
+func ParseDuration(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		count, err := strconv.Atoi(days)
+		if err != nil || count < 0 {
+			return 0, fmt.Errorf("invalid duration %q, use a number of days such as 30d or a duration such as 12h", value)
+		}
+
+		return time.Duration(count) * 24 * time.Hour, nil
+	}
+
+	result, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q, use a number of days such as 30d or a duration such as 12h", value)
+	}
+
+	return result, nil
+}
+
+// ResolveModel returns the model the alias stands for, or the model as it is when it isn't one of
+// the aliases.
+func ResolveModel(aliases map[string]string, model string) string {
+	if resolved, ok := aliases[model]; ok && resolved != "" {
+		return resolved
+	}
+
+	return model
+}
+
+// ClosestMatches returns up to n of the candidates that are the closest to the word, the closest
+// first, such as the models a mistyped model could have meant. Case is ignored, and a candidate
+// only matches when few of its characters differ from the word or when it contains the word.
+func ClosestMatches(word string, candidates []string, n int) []string {
+	type match struct {
+		candidate string
+		distance  int
+	}
+
+	word = strings.ToLower(word)
+	limit := max(2, utf8.RuneCountInString(word)/3)
+
+	var matches []match
+	for _, candidate := range candidates {
+		lower := strings.ToLower(candidate)
+		if lower == word {
+			continue
+		}
+
+		distance := levenshtein(word, lower)
+		if distance > limit && !strings.Contains(lower, word) {
+			continue
+		}
+		matches = append(matches, match{candidate, distance})
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].distance != matches[j].distance {
+			return matches[i].distance < matches[j].distance
+		}
+		return matches[i].candidate < matches[j].candidate
+	})
+
+	var result []string
+	for _, m := range matches {
+		if len(result) == n {
+			break
+		}
+		result = append(result, m.candidate)
+	}
+
+	return result
+}
+
+// levenshtein returns the number of runes to insert, delete or substitute to turn a into b.
+func levenshtein(a, b string) int {
+	first, second := []rune(a), []rune(b)
+
+	previous := make([]int, len(second)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(first); i++ {
+		current := make([]int, len(second)+1)
+		current[0] = i
+		for j := 1; j <= len(second); j++ {
+			cost := 1
+			if first[i-1] == second[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+
+	return previous[len(second)]
+}
+
 func FileToString(fileName string) (string, error) {
 	bytes, err := os.ReadFile(fileName)
 	if err != nil {
@@ -87,3 +271,34 @@ func FileToString(fileName string) (string, error) {
 
 	return string(bytes), nil
 }
+
+// WriteFileAtomic writes the data to a temporary file in the same directory and renames it over
+// the target once it is synced, with the permissions, so an error or a crash never leaves the
+// target half written and a reader never sees it half written. The temporary file is only readable
+// by the user until it has the permissions.
+func WriteFileAtomic(fileName string, data []byte, perm os.FileMode) error {
+	file, err := os.CreateTemp(filepath.Dir(fileName), "."+filepath.Base(fileName)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := file.Name()
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Chmod(perm)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpName, fileName)
+	}
+
+	if err != nil {
+		_ = os.Remove(tmpName)
+	}
+	return err
+}
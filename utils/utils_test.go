@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"github.com/kardolus/chatgpt-cli/utils"
 	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
 	"time"
 
@@ -17,10 +19,18 @@ func TestUnitUtils(t *testing.T) {
 }
 
 func testUtils(t *testing.T, when spec.G, it spec.S) {
+	var homeDir string
+
 	it.Before(func() {
 		RegisterTestingT(t)
 		Expect(os.Unsetenv(utils.ConfigHomeEnv)).To(Succeed())
 		Expect(os.Unsetenv(utils.DataHomeEnv)).To(Succeed())
+
+		// the homes depend on what exists in the home directory, so each test gets an empty one
+		homeDir = t.TempDir()
+		t.Setenv("HOME", homeDir)
+		t.Setenv(utils.XDGConfigHomeEnv, "")
+		t.Setenv(utils.XDGStateHomeEnv, "")
 	})
 
 	when("FormatPrompt()", func() {
@@ -90,11 +100,41 @@ func testUtils(t *testing.T, when spec.G, it spec.S) {
 	})
 
 	when("GetConfigHome()", func() {
-		it("Uses the default value if OPENAI_CONFIG_HOME is not set", func() {
+		it("Uses the XDG config directory if OPENAI_CONFIG_HOME is not set", func() {
 			configHome, err := utils.GetConfigHome()
 
 			Expect(err).NotTo(HaveOccurred())
-			Expect(configHome).To(ContainSubstring(".chatgpt-cli")) // Assuming default location is ~/.chatgpt-cli
+			Expect(configHome).To(Equal(filepath.Join(homeDir, ".config", "chatgpt-cli")))
+		})
+
+		it("Uses XDG_CONFIG_HOME when it is an absolute path", func() {
+			t.Setenv(utils.XDGConfigHomeEnv, "/xdg/config")
+
+			configHome, err := utils.GetConfigHome()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(configHome).To(Equal(filepath.Join("/xdg/config", "chatgpt-cli")))
+
+			t.Setenv(utils.XDGConfigHomeEnv, "relative/config")
+
+			configHome, err = utils.GetConfigHome()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(configHome).To(Equal(filepath.Join(homeDir, ".config", "chatgpt-cli")))
+		})
+
+		it("Keeps using ~/.chatgpt-cli while it exists", func() {
+			legacy := filepath.Join(homeDir, ".chatgpt-cli")
+			Expect(os.Mkdir(legacy, 0755)).To(Succeed())
+			t.Setenv(utils.XDGConfigHomeEnv, "/xdg/config")
+
+			configHome, err := utils.GetConfigHome()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(configHome).To(Equal(legacy))
+
+			Expect(os.WriteFile(filepath.Join(legacy, utils.MovedNote), nil, 0644)).To(Succeed())
+
+			configHome, err = utils.GetConfigHome()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(configHome).To(Equal(filepath.Join("/xdg/config", "chatgpt-cli")))
 		})
 
 		it("Overwrites the default when OPENAI_CONFIG_HOME is set", func() {
@@ -109,11 +149,31 @@ func testUtils(t *testing.T, when spec.G, it spec.S) {
 	})
 
 	when("GetDataHome()", func() {
-		it("Uses the default value if OPENAI_DATA_HOME is not set", func() {
+		it("Uses the XDG state directory if OPENAI_DATA_HOME is not set", func() {
+			dataHome, err := utils.GetDataHome()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dataHome).To(Equal(filepath.Join(homeDir, ".local", "state", "chatgpt-cli", "history")))
+
+			t.Setenv(utils.XDGStateHomeEnv, "/xdg/state")
+
+			dataHome, err = utils.GetDataHome()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dataHome).To(Equal(filepath.Join("/xdg/state", "chatgpt-cli", "history")))
+		})
+
+		it("Keeps the history next to the config in ~/.chatgpt-cli or OPENAI_CONFIG_HOME", func() {
+			legacy := filepath.Join(homeDir, ".chatgpt-cli")
+			Expect(os.Mkdir(legacy, 0755)).To(Succeed())
+
 			dataHome, err := utils.GetDataHome()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dataHome).To(Equal(filepath.Join(legacy, "history")))
 
+			Expect(os.Setenv(utils.ConfigHomeEnv, "/custom/config/path")).To(Succeed())
+
+			dataHome, err = utils.GetDataHome()
 			Expect(err).NotTo(HaveOccurred())
-			Expect(dataHome).To(ContainSubstring(".chatgpt-cli/history")) // Assuming default location is ~/.local/share/chatgpt-cli
+			Expect(dataHome).To(Equal(filepath.Join("/custom/config/path", "history")))
 		})
 
 		it("Overwrites the default when OPENAI_DATA_HOME is set", func() {
@@ -125,5 +185,176 @@ func testUtils(t *testing.T, when spec.G, it spec.S) {
 			Expect(err).NotTo(HaveOccurred())
 			Expect(dataHome).To(Equal(customDataHome))
 		})
+
+		it("Expands OPENAI_DATA_HOME to an absolute path", func() {
+			Expect(os.Setenv("OPENAI_DATA_HOME", "custom/data")).To(Succeed())
+
+			dataHome, err := utils.GetDataHome()
+			Expect(err).NotTo(HaveOccurred())
+
+			workingDir, err := os.Getwd()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dataHome).To(Equal(filepath.Join(workingDir, "custom", "data")))
+		})
+	})
+
+	when("ExpandPath()", func() {
+		it("expands the home directory", func() {
+			homeDir, err := os.UserHomeDir()
+			Expect(err).NotTo(HaveOccurred())
+
+			for path, expected := range map[string]string{
+				"~":              homeDir,
+				"~/sync/history": filepath.Join(homeDir, "sync", "history"),
+			} {
+				result, err := utils.ExpandPath(path)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result).To(Equal(expected), path)
+			}
+		})
+
+		it("resolves relative paths against the working directory", func() {
+			workingDir, err := os.Getwd()
+			Expect(err).NotTo(HaveOccurred())
+
+			for path, expected := range map[string]string{
+				"history":           filepath.Join(workingDir, "history"),
+				"./ci/../history/":  filepath.Join(workingDir, "history"),
+				"~user/history":     filepath.Join(workingDir, "~user", "history"),
+				"/absolute/history": "/absolute/history",
+			} {
+				result, err := utils.ExpandPath(path)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result).To(Equal(expected), path)
+			}
+		})
+	})
+
+	when("GetTemplatesHome()", func() {
+		it("Uses the templates directory in the config home if OPENAI_TEMPLATES_HOME is not set", func() {
+			Expect(os.Setenv("OPENAI_CONFIG_HOME", "/custom/config/path")).To(Succeed())
+
+			templatesHome, err := utils.GetTemplatesHome()
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(templatesHome).To(Equal("/custom/config/path/templates"))
+		})
+
+		it("Overwrites the default when OPENAI_TEMPLATES_HOME is set", func() {
+			customTemplatesHome := "/custom/templates/path"
+			Expect(os.Setenv("OPENAI_TEMPLATES_HOME", customTemplatesHome)).To(Succeed())
+			defer os.Unsetenv("OPENAI_TEMPLATES_HOME")
+
+			templatesHome, err := utils.GetTemplatesHome()
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(templatesHome).To(Equal(customTemplatesHome))
+		})
+	})
+
+	when("GetPromptsHome()", func() {
+		it("uses the prompts directory in the config home unless OPENAI_PROMPTS_HOME is set", func() {
+			Expect(os.Setenv("OPENAI_CONFIG_HOME", "/custom/config/path")).To(Succeed())
+
+			promptsHome, err := utils.GetPromptsHome()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(promptsHome).To(Equal("/custom/config/path/prompts"))
+
+			Expect(os.Setenv("OPENAI_PROMPTS_HOME", "/custom/prompts/path")).To(Succeed())
+			defer os.Unsetenv("OPENAI_PROMPTS_HOME")
+
+			promptsHome, err = utils.GetPromptsHome()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(promptsHome).To(Equal("/custom/prompts/path"))
+		})
+	})
+
+	when("ParseDuration()", func() {
+		it("parses a number of days", func() {
+			duration, err := utils.ParseDuration("30d")
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(duration).To(Equal(30 * 24 * time.Hour))
+		})
+
+		it("parses the durations time.ParseDuration does", func() {
+			duration, err := utils.ParseDuration("1h30m")
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(duration).To(Equal(90 * time.Minute))
+		})
+
+		it("refuses anything else", func() {
+			for _, value := range []string{"", "d", "-1d", "1.5d", "a week"} {
+				_, err := utils.ParseDuration(value)
+				Expect(err).To(HaveOccurred(), value)
+			}
+		})
+	})
+
+	when("ResolveModel()", func() {
+		aliases := map[string]string{"fast": "gpt-4o-mini", "smart": "gpt-4o"}
+
+		it("returns the model an alias stands for", func() {
+			Expect(utils.ResolveModel(aliases, "fast")).To(Equal("gpt-4o-mini"))
+		})
+
+		it("returns any other model as it is", func() {
+			Expect(utils.ResolveModel(aliases, "gpt-4-turbo")).To(Equal("gpt-4-turbo"))
+			Expect(utils.ResolveModel(nil, "fast")).To(Equal("fast"))
+		})
+	})
+
+	when("ClosestMatches()", func() {
+		candidates := []string{"gpt-4o", "gpt-4o-mini", "gpt-4-turbo", "gpt-3.5-turbo", "o1-preview"}
+
+		it("returns the closest candidates first", func() {
+			Expect(utils.ClosestMatches("gpt4o", candidates, 3)).To(Equal([]string{"gpt-4o"}))
+			Expect(utils.ClosestMatches("gpt-4", candidates, 3)).To(Equal([]string{"gpt-4o", "gpt-4-turbo", "gpt-4o-mini"}))
+			Expect(utils.ClosestMatches("GPT-4-TURBO2", candidates, 3)).To(Equal([]string{"gpt-4-turbo", "gpt-3.5-turbo"}))
+		})
+
+		it("returns at most n candidates", func() {
+			Expect(utils.ClosestMatches("gpt-4", candidates, 1)).To(Equal([]string{"gpt-4o"}))
+		})
+
+		it("returns nothing when no candidate is close", func() {
+			Expect(utils.ClosestMatches("claude-3", candidates, 3)).To(BeEmpty())
+			Expect(utils.ClosestMatches("gpt-4o", nil, 3)).To(BeEmpty())
+		})
+	})
+
+	when("WriteFileAtomic()", func() {
+		it("replaces the file with the data and the permissions, without leaving a temporary file", func() {
+			dir := t.TempDir()
+			fileName := filepath.Join(dir, "file.json")
+			Expect(os.WriteFile(fileName, []byte("old"), 0644)).To(Succeed())
+
+			Expect(utils.WriteFileAtomic(fileName, []byte("new"), 0600)).To(Succeed())
+
+			Expect(os.ReadFile(fileName)).To(Equal([]byte("new")))
+			entries, err := os.ReadDir(dir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries).To(HaveLen(1))
+
+			if runtime.GOOS != "windows" {
+				info, err := os.Stat(fileName)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+			}
+		})
+
+		it("leaves the file untouched when it can't be replaced", func() {
+			dir := t.TempDir()
+			fileName := filepath.Join(dir, "file.json")
+			Expect(os.Mkdir(fileName, 0755)).To(Succeed())
+
+			Expect(utils.WriteFileAtomic(fileName, []byte("new"), 0644)).NotTo(Succeed())
+
+			Expect(fileName).To(BeADirectory())
+			entries, err := os.ReadDir(dir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries).To(HaveLen(1))
+		})
 	})
 }
@@ -0,0 +1,231 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+const (
+	XDGConfigHomeEnv = "XDG_CONFIG_HOME"
+	XDGStateHomeEnv  = "XDG_STATE_HOME"
+	// AppDir is the directory of chatgpt-cli in the XDG config and state homes.
+	AppDir = "chatgpt-cli"
+	// MovedNote is left behind in the legacy home once its files were moved to the XDG directories.
+	MovedNote = "MOVED.txt"
+	// keepLegacyFile records that the user declined to move the legacy home, so they are asked once.
+	keepLegacyFile = ".keep-legacy-home"
+)
+
+// LegacyHome returns ~/.chatgpt-cli, where the config and the history were kept before the XDG
+// directories were used.
+func LegacyHome() (string, error) {
+	homeDir, err := HomeDir(runtime.GOOS, os.Getenv)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(homeDir, DefaultConfigDir), nil
+}
+
+// usesLegacyHome reports whether the legacy home is still in use: it exists and its files were not
+// moved to the XDG directories.
+func usesLegacyHome() (string, bool, error) {
+	legacy, err := LegacyHome()
+	if err != nil {
+		return "", false, err
+	}
+
+	if info, err := os.Stat(legacy); err != nil || !info.IsDir() {
+		return legacy, false, nil
+	}
+
+	if _, err := os.Stat(filepath.Join(legacy, MovedNote)); err == nil {
+		return legacy, false, nil
+	}
+
+	return legacy, true, nil
+}
+
+// XDGConfigHome returns the config directory of the XDG Base Directory specification,
+// $XDG_CONFIG_HOME/chatgpt-cli or ~/.config/chatgpt-cli, and %AppData%\chatgpt-cli on Windows.
+func XDGConfigHome() (string, error) {
+	return ConfigHome(runtime.GOOS, os.Getenv)
+}
+
+// XDGStateHome returns the state directory of the XDG Base Directory specification, where the
+// history is kept: $XDG_STATE_HOME/chatgpt-cli or ~/.local/state/chatgpt-cli, and
+// %LocalAppData%\chatgpt-cli on Windows.
+func XDGStateHome() (string, error) {
+	return StateHome(runtime.GOOS, os.Getenv)
+}
+
+// HomeDir returns the home directory of the user on the operating system, read from the
+// environment as os.UserHomeDir reads it: %USERPROFILE% on Windows, $home on Plan 9 and $HOME
+// everywhere else.
+func HomeDir(goos string, getenv func(string) string) (string, error) {
+	variable := "HOME"
+	switch goos {
+	case "windows":
+		variable = "USERPROFILE"
+	case "plan9":
+		variable = "home"
+	}
+
+	dir := getenv(variable)
+	if dir == "" {
+		return "", fmt.Errorf("the home directory is unknown, %s is not set", variable)
+	}
+
+	return dir, nil
+}
+
+// ConfigHome returns the directory of chatgpt-cli in the config directory of the operating system,
+// %AppData% on Windows and the one of the XDG Base Directory specification everywhere else.
+func ConfigHome(goos string, getenv func(string) string) (string, error) {
+	if goos == "windows" {
+		dir := getenv("AppData")
+		if dir == "" {
+			return "", errors.New("the config directory is unknown, AppData is not set")
+		}
+		return filepath.Join(dir, AppDir), nil
+	}
+
+	return xdgHome(goos, getenv, XDGConfigHomeEnv, ".config")
+}
+
+// StateHome returns the directory of chatgpt-cli in the state directory of the operating system,
+// %LocalAppData% on Windows, or the ConfigHome without it, and the one of the XDG Base Directory
+// specification everywhere else.
+func StateHome(goos string, getenv func(string) string) (string, error) {
+	if goos == "windows" {
+		if dir := getenv("LocalAppData"); dir != "" {
+			return filepath.Join(dir, AppDir), nil
+		}
+		return ConfigHome(goos, getenv)
+	}
+
+	return xdgHome(goos, getenv, XDGStateHomeEnv, filepath.Join(".local", "state"))
+}
+
+// xdgHome returns the directory of chatgpt-cli in the directory the variable names. The
+// specification has relative paths ignored, the default in the home directory is used instead.
+func xdgHome(goos string, getenv func(string) string, variable, fallback string) (string, error) {
+	if dir := getenv(variable); filepath.IsAbs(dir) {
+		return filepath.Join(dir, AppDir), nil
+	}
+
+	homeDir, err := HomeDir(goos, getenv)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(homeDir, fallback, AppDir), nil
+}
+
+// ShouldOfferMove reports whether the files in the legacy home can be moved to the XDG directories:
+// the legacy home is in use, the XDG config directory doesn't exist yet, no location was set with
+// the environment and the user did not decline the move before.
+func ShouldOfferMove() bool {
+	if os.Getenv(ConfigHomeEnv) != "" || os.Getenv(DataHomeEnv) != "" {
+		return false
+	}
+
+	legacy, inUse, err := usesLegacyHome()
+	if err != nil || !inUse {
+		return false
+	}
+
+	if _, err := os.Stat(filepath.Join(legacy, keepLegacyFile)); err == nil {
+		return false
+	}
+
+	configHome, err := XDGConfigHome()
+	if err != nil {
+		return false
+	}
+
+	_, err = os.Stat(configHome)
+	return os.IsNotExist(err)
+}
+
+// KeepLegacyHome records that the files in the legacy home stay where they are.
+func KeepLegacyHome() error {
+	legacy, err := LegacyHome()
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(legacy, keepLegacyFile), nil, 0644)
+}
+
+// MoveLegacyHome moves the history of the legacy home to the XDG state directory and everything
+// else, the config, the templates and the personas, to the XDG config directory. A note in the
+// legacy home tells where the files went. It returns the config and the history directories.
+func MoveLegacyHome() (string, string, error) {
+	legacy, inUse, err := usesLegacyHome()
+	if err != nil {
+		return "", "", err
+	}
+	if !inUse {
+		return "", "", errors.New("there is nothing to move, ~/" + DefaultConfigDir + " is not in use")
+	}
+
+	configHome, err := XDGConfigHome()
+	if err != nil {
+		return "", "", err
+	}
+
+	stateHome, err := XDGStateHome()
+	if err != nil {
+		return "", "", err
+	}
+	dataHome := filepath.Join(stateHome, DefaultDataDir)
+
+	entries, err := os.ReadDir(legacy)
+	if err != nil {
+		return "", "", err
+	}
+
+	// nothing is moved when anything is in the way
+	targets := make(map[string]string)
+	for _, entry := range entries {
+		if entry.Name() == keepLegacyFile {
+			continue
+		}
+
+		target := filepath.Join(configHome, entry.Name())
+		if entry.Name() == DefaultDataDir {
+			target = dataHome
+		}
+
+		if _, err := os.Lstat(target); err == nil {
+			return "", "", fmt.Errorf("%s already exists, move the files of %s yourself", target, legacy)
+		}
+		targets[entry.Name()] = target
+	}
+
+	for _, dir := range []string{configHome, stateHome} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return "", "", err
+		}
+	}
+
+	for name, target := range targets {
+		if err := os.Rename(filepath.Join(legacy, name), target); err != nil {
+			return "", "", fmt.Errorf("failed to move %s, the files moved so far are in %s and %s: %w", name, configHome, dataHome, err)
+		}
+	}
+
+	note := fmt.Sprintf("The files of chatgpt-cli were moved to the XDG directories.\n\n"+
+		"Config, templates and personas: %s\nHistory: %s\n\nThis directory can be removed.\n", configHome, dataHome)
+	if err := os.WriteFile(filepath.Join(legacy, MovedNote), []byte(note), 0644); err != nil {
+		return "", "", err
+	}
+
+	_ = os.Remove(filepath.Join(legacy, keepLegacyFile))
+
+	return configHome, dataHome, nil
+}
@@ -0,0 +1,151 @@
+package utils_test
+
+import (
+	"github.com/kardolus/chatgpt-cli/utils"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitXDG(t *testing.T) {
+	spec.Run(t, "Testing the move to the XDG directories", testXDG, spec.Report(report.Terminal{}))
+}
+
+func testXDG(t *testing.T, when spec.G, it spec.S) {
+	var homeDir, legacy string
+
+	it.Before(func() {
+		RegisterTestingT(t)
+
+		homeDir = t.TempDir()
+		t.Setenv("HOME", homeDir)
+		t.Setenv(utils.ConfigHomeEnv, "")
+		t.Setenv(utils.DataHomeEnv, "")
+		t.Setenv(utils.XDGConfigHomeEnv, filepath.Join(homeDir, "xdg-config"))
+		t.Setenv(utils.XDGStateHomeEnv, filepath.Join(homeDir, "xdg-state"))
+
+		legacy = filepath.Join(homeDir, ".chatgpt-cli")
+		Expect(os.MkdirAll(filepath.Join(legacy, "history"), 0755)).To(Succeed())
+		Expect(os.MkdirAll(filepath.Join(legacy, "templates"), 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(legacy, "config.yaml"), []byte("model: gpt-4o\n"), 0644)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(legacy, "history", "default.json"), []byte("[]"), 0644)).To(Succeed())
+	})
+
+	when("ShouldOfferMove()", func() {
+		it("offers to move the legacy home that is in use", func() {
+			Expect(utils.ShouldOfferMove()).To(BeTrue())
+		})
+
+		it("doesn't offer without a legacy home", func() {
+			Expect(os.RemoveAll(legacy)).To(Succeed())
+			Expect(utils.ShouldOfferMove()).To(BeFalse())
+		})
+
+		it("doesn't offer when a location was set with the environment", func() {
+			t.Setenv(utils.DataHomeEnv, filepath.Join(homeDir, "data"))
+			Expect(utils.ShouldOfferMove()).To(BeFalse())
+		})
+
+		it("doesn't offer when the XDG config directory exists", func() {
+			Expect(os.MkdirAll(filepath.Join(homeDir, "xdg-config", "chatgpt-cli"), 0700)).To(Succeed())
+			Expect(utils.ShouldOfferMove()).To(BeFalse())
+		})
+
+		it("only offers once", func() {
+			Expect(utils.KeepLegacyHome()).To(Succeed())
+			Expect(utils.ShouldOfferMove()).To(BeFalse())
+
+			configHome, err := utils.GetConfigHome()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(configHome).To(Equal(legacy))
+		})
+	})
+
+	when("MoveLegacyHome()", func() {
+		it("moves the history to the state directory, the rest to the config directory and leaves a note", func() {
+			configHome, dataHome, err := utils.MoveLegacyHome()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(configHome).To(Equal(filepath.Join(homeDir, "xdg-config", "chatgpt-cli")))
+			Expect(dataHome).To(Equal(filepath.Join(homeDir, "xdg-state", "chatgpt-cli", "history")))
+
+			Expect(filepath.Join(configHome, "config.yaml")).To(BeARegularFile())
+			Expect(filepath.Join(configHome, "templates")).To(BeADirectory())
+			Expect(filepath.Join(dataHome, "default.json")).To(BeARegularFile())
+
+			note, err := os.ReadFile(filepath.Join(legacy, utils.MovedNote))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(note)).To(ContainSubstring(configHome))
+			Expect(string(note)).To(ContainSubstring(dataHome))
+
+			// the homes follow the files
+			Expect(utils.GetConfigHome()).To(Equal(configHome))
+			Expect(utils.GetDataHome()).To(Equal(dataHome))
+			Expect(utils.ShouldOfferMove()).To(BeFalse())
+
+			_, _, err = utils.MoveLegacyHome()
+			Expect(err).To(MatchError(ContainSubstring("there is nothing to move")))
+		})
+
+		it("moves nothing when a file is in the way", func() {
+			dataHome := filepath.Join(homeDir, "xdg-state", "chatgpt-cli", "history")
+			Expect(os.MkdirAll(dataHome, 0700)).To(Succeed())
+
+			_, _, err := utils.MoveLegacyHome()
+			Expect(err).To(MatchError(ContainSubstring(dataHome + " already exists")))
+
+			Expect(filepath.Join(legacy, "config.yaml")).To(BeARegularFile())
+			Expect(filepath.Join(legacy, utils.MovedNote)).NotTo(BeAnExistingFile())
+		})
+	})
+
+	when("the directories of the operating system", func() {
+		envOf := func(env map[string]string) func(string) string {
+			return func(key string) string { return env[key] }
+		}
+
+		it("reads the home directory from the variable of the OS", func() {
+			env := envOf(map[string]string{"HOME": "/home/me", "USERPROFILE": `C:\Users\me`, "home": "/usr/me"})
+
+			Expect(utils.HomeDir("linux", env)).To(Equal("/home/me"))
+			Expect(utils.HomeDir("darwin", env)).To(Equal("/home/me"))
+			Expect(utils.HomeDir("windows", env)).To(Equal(`C:\Users\me`))
+			Expect(utils.HomeDir("plan9", env)).To(Equal("/usr/me"))
+
+			_, err := utils.HomeDir("windows", envOf(map[string]string{"HOME": "/home/me"}))
+			Expect(err).To(MatchError(ContainSubstring("USERPROFILE is not set")))
+		})
+
+		it("uses AppData and LocalAppData on Windows, not the XDG variables", func() {
+			env := map[string]string{
+				"USERPROFILE":          `C:\Users\me`,
+				"AppData":              `C:\Users\me\AppData\Roaming`,
+				"LocalAppData":         `C:\Users\me\AppData\Local`,
+				utils.XDGConfigHomeEnv: "/xdg-config",
+			}
+
+			Expect(utils.ConfigHome("windows", envOf(env))).To(Equal(filepath.Join(`C:\Users\me\AppData\Roaming`, "chatgpt-cli")))
+			Expect(utils.StateHome("windows", envOf(env))).To(Equal(filepath.Join(`C:\Users\me\AppData\Local`, "chatgpt-cli")))
+
+			delete(env, "LocalAppData")
+			Expect(utils.StateHome("windows", envOf(env))).To(Equal(filepath.Join(`C:\Users\me\AppData\Roaming`, "chatgpt-cli")))
+
+			delete(env, "AppData")
+			_, err := utils.ConfigHome("windows", envOf(env))
+			Expect(err).To(MatchError(ContainSubstring("AppData is not set")))
+		})
+
+		it("uses the XDG variables elsewhere, and the home directory when they aren't absolute", func() {
+			env := map[string]string{"HOME": "/home/me", utils.XDGConfigHomeEnv: "/xdg-config", utils.XDGStateHomeEnv: "relative"}
+
+			Expect(utils.ConfigHome("linux", envOf(env))).To(Equal(filepath.Join("/xdg-config", "chatgpt-cli")))
+			Expect(utils.StateHome("linux", envOf(env))).To(Equal(filepath.Join("/home/me", ".local", "state", "chatgpt-cli")))
+
+			delete(env, utils.XDGConfigHomeEnv)
+			Expect(utils.ConfigHome("darwin", envOf(env))).To(Equal(filepath.Join("/home/me", ".config", "chatgpt-cli")))
+		})
+	})
+}